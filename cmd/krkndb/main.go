@@ -0,0 +1,440 @@
+// Command krkndb imports streams into a KrknDB database from other tools and exports them.
+//
+//	sometool | krkndb ingest --db ./data --type 1000 --format potfile -
+//	krkndb export --db ./data --type 1000 --format jsonl --rate 50000 -o hashes.jsonl
+//	krkndb status --db ./data --listen 127.0.0.1:8080 --prefix /krkndb/
+//	krkndb serve --db ./data --tokens tokens.txt --tls-cert krkndb.crt --tls-key krkndb.key
+//	krkndb backup --db ./data -o krkndb.bak
+//	krkndb restore --db ./restored --verify 5 krkndb.bak
+//
+// The key is loaded from --key, a key source as accepted by LoadKey, env:KRKNDB_KEY by default
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/kdb"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "ingest":
+		if err := ingest(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "krkndb: %v\n", err)
+			os.Exit(1)
+		}
+	case "export":
+		if err := export(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "krkndb: %v\n", err)
+			os.Exit(1)
+		}
+	case "status":
+		if err := status(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "krkndb: %v\n", err)
+			os.Exit(1)
+		}
+	case "serve":
+		if err := serve(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "krkndb: %v\n", err)
+			os.Exit(1)
+		}
+	case "backup":
+		if err := backup(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "krkndb: %v\n", err)
+			os.Exit(1)
+		}
+	case "restore":
+		if err := restore(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "krkndb: %v\n", err)
+			os.Exit(1)
+		}
+	case "-h", "--help", "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "krkndb: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: krkndb ingest --db DIR [--key SOURCE] [--type MODE] [--format FORMAT] [--progress INTERVAL] FILE|-")
+	fmt.Fprintln(os.Stderr, "       krkndb export --db DIR [--key SOURCE] [--type MODE] [--format FORMAT] [--rate ROWS] [--bytes-rate BYTES] [-o FILE]")
+	fmt.Fprintln(os.Stderr, "       krkndb status --db DIR [--key SOURCE] [--listen ADDR] [--prefix PATH] [--refresh INTERVAL] [--tokens FILE]")
+	fmt.Fprintln(os.Stderr, "       krkndb serve --db DIR [--key SOURCE] [--listen ADDR] --tokens FILE [--tls-cert FILE --tls-key FILE]")
+	fmt.Fprintln(os.Stderr, "       krkndb backup --db DIR [--key SOURCE] [-o FILE]")
+	fmt.Fprintln(os.Stderr, "       krkndb restore --db DIR [--key SOURCE] [--verify TYPES] [--recount] FILE|-")
+}
+
+// ingest imports a file, or stdin for -, with IngestStream. Interrupting stores what was read
+func ingest(args []string) error {
+	fs := flag.NewFlagSet("ingest", flag.ContinueOnError)
+	dir := fs.String("db", "", "database directory")
+	keySource := fs.String("key", "env:KRKNDB_KEY", "key source: a file, env:NAME or keyring:service/account")
+	hashType := fs.Uint64("type", 0, "hashcat mode of the hashes, ignored by csv, jsonl and pwdump")
+	formatName := fs.String("format", "potfile", "potfile, hashlist, founds, founds-salted, hashtopolis, pwdump, csv or jsonl")
+	interval := fs.Duration("progress", time.Second, "how often progress is printed to stderr")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" || fs.NArg() != 1 {
+		usage()
+		return errors.New("a database directory and one input are required")
+	}
+
+	format, err := kdb.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if name := fs.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	db, err := open(*dir, *keySource, *interval)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return db.IngestStream(ctx, in, format, *hashType, func(p kdb.IngestProgress) {
+		fmt.Fprintf(os.Stderr, "%d lines, %d inserted, %d invalid, %.0f lines/s, %s\n",
+			p.Lines, p.Inserted, p.Invalid, p.LinesPerSec, p.Elapsed.Round(time.Millisecond))
+	})
+}
+
+// exporters are the export formats by name
+var exporters = map[string]func(db *kdb.KDB, w io.Writer, hashType uint64, opts *kdb.ExportOptions) (int, error){
+	"potfile": func(db *kdb.KDB, w io.Writer, hashType uint64, opts *kdb.ExportOptions) (int, error) {
+		return db.ExportPotfile(w, hashType, opts)
+	},
+	"csv": func(db *kdb.KDB, w io.Writer, hashType uint64, opts *kdb.ExportOptions) (int, error) {
+		return db.ExportCSV(w, hashType, opts)
+	},
+	"jsonl": func(db *kdb.KDB, w io.Writer, hashType uint64, opts *kdb.ExportOptions) (int, error) {
+		return db.ExportJSONL(w, hashType, opts)
+	},
+	"hashtopolis": func(db *kdb.KDB, w io.Writer, hashType uint64, opts *kdb.ExportOptions) (int, error) {
+		return db.ExportHashtopolis(w, hashType, false, opts)
+	},
+	"pwdump": func(db *kdb.KDB, w io.Writer, _ uint64, opts *kdb.ExportOptions) (int, error) {
+		return db.ExportPwdump(w, opts)
+	},
+}
+
+// export writes the hashes of a type to a file, or stdout without -o, drawing a progress bar on
+// stderr. Interrupting leaves the rows written so far
+func export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	dir := fs.String("db", "", "database directory")
+	keySource := fs.String("key", "env:KRKNDB_KEY", "key source: a file, env:NAME or keyring:service/account")
+	hashType := fs.Uint64("type", 0, "hashcat mode of the hashes to export, ignored by pwdump")
+	formatName := fs.String("format", "potfile", "potfile, csv, jsonl, hashtopolis or pwdump")
+	out := fs.String("o", "", "output file, stdout if empty")
+	rate := fs.Int("rate", 0, "most rows written a second, 0 for no limit")
+	byteRate := fs.Int("bytes-rate", 0, "most bytes written a second, 0 for no limit")
+	interval := fs.Duration("progress", time.Second/4, "how often the progress bar is redrawn")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	exportFn, ok := exporters[*formatName]
+	if *dir == "" || !ok || fs.NArg() != 0 {
+		usage()
+		return errors.New("a database directory and a potfile, csv, jsonl, hashtopolis or pwdump format are required")
+	}
+
+	db, err := open(*dir, *keySource, 0)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	// The potfile holds the cracked hashes only
+	total, err := db.HashesByType(*hashType)
+	if *formatName == "potfile" {
+		total, err = db.CrackedByType(*hashType)
+	}
+	if err != nil {
+		total = 0
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	written, err := exportFn(db, w, *hashType, &kdb.ExportOptions{
+		Progress:         func(written uint64) { drawBar(written, total) },
+		ProgressInterval: *interval,
+		RowsPerSecond:    *rate,
+		BytesPerSecond:   *byteRate,
+		Context:          ctx,
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return fmt.Errorf("export stopped after %d rows: %w", written, err)
+	}
+	return nil
+}
+
+// status serves the read-only status page until interrupted
+func status(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	dir := fs.String("db", "", "database directory")
+	keySource := fs.String("key", "env:KRKNDB_KEY", "key source: a file, env:NAME or keyring:service/account")
+	listen := fs.String("listen", "127.0.0.1:8080", "address to serve the page on")
+	prefix := fs.String("prefix", "/", "path the page is served under, such as /krkndb/ behind a reverse proxy")
+	refresh := fs.Duration("refresh", 30*time.Second, "how often the page reloads itself")
+	tokenFile := fs.String("tokens", "", "file of \"role token\" lines, the page then needs a read or admin bearer token")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" || fs.NArg() != 0 {
+		usage()
+		return errors.New("a database directory is required")
+	}
+
+	var tokens *kdb.TokenRoles
+	if *tokenFile != "" {
+		var err error
+		if tokens, err = loadTokens(*tokenFile); err != nil {
+			return err
+		}
+	}
+
+	db, err := open(*dir, *keySource, 0)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var page http.Handler = db.StatusHandler(*refresh)
+	if tokens != nil {
+		page = tokens.Require(kdb.RoleRead, page)
+	}
+	path := "/" + strings.Trim(*prefix, "/")
+	mux := http.NewServeMux()
+	mux.Handle(path, page)
+	if path != "/" {
+		mux.Handle(path+"/", http.RedirectHandler(path, http.StatusMovedPermanently))
+	}
+	srv := &http.Server{Addr: *listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	fmt.Fprintf(os.Stderr, "serving the status page on http://%s%s\n", *listen, path)
+	return listenUntilInterrupted(srv, "", "")
+}
+
+// serve serves the hash API of pkg/client until interrupted. The API hands out plaintexts, so a
+// token file is required
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	dir := fs.String("db", "", "database directory")
+	keySource := fs.String("key", "env:KRKNDB_KEY", "key source: a file, env:NAME or keyring:service/account")
+	listen := fs.String("listen", "127.0.0.1:8443", "address to serve the API on")
+	tokenFile := fs.String("tokens", "", "file of \"role token\" lines, reads need a read token and writes a write token")
+	certFile := fs.String("tls-cert", "", "TLS certificate file, plain http without one")
+	keyFile := fs.String("tls-key", "", "TLS private key file of the certificate")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" || fs.NArg() != 0 {
+		usage()
+		return errors.New("a database directory is required")
+	}
+	if *tokenFile == "" {
+		return errors.New("a token file is required, the API serves plaintexts")
+	}
+	if (*certFile == "") != (*keyFile == "") {
+		return errors.New("--tls-cert and --tls-key go together")
+	}
+
+	tokens, err := loadTokens(*tokenFile)
+	if err != nil {
+		return err
+	}
+	db, err := open(*dir, *keySource, 0)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	srv := &http.Server{Addr: *listen, Handler: db.APIHandler(tokens), ReadHeaderTimeout: 10 * time.Second}
+	scheme := "http"
+	if *certFile != "" {
+		scheme = "https"
+	}
+	fmt.Fprintf(os.Stderr, "serving the API on %s://%s\n", scheme, *listen)
+	return listenUntilInterrupted(srv, *certFile, *keyFile)
+}
+
+// loadTokens reads the token file at path, see LoadTokenRoles
+func loadTokens(path string) (*kdb.TokenRoles, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return kdb.LoadTokenRoles(f)
+}
+
+// listenUntilInterrupted serves srv until SIGINT or SIGTERM, over TLS with certFile and keyFile
+// if set, then gives the requests running 5 seconds to finish
+func listenUntilInterrupted(srv *http.Server, certFile, keyFile string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	var err error
+	if certFile != "" {
+		err = srv.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// backup writes a backup of the database to a file, or stdout
+func backup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	dir := fs.String("db", "", "database directory")
+	keySource := fs.String("key", "env:KRKNDB_KEY", "key source: a file, env:NAME or keyring:service/account")
+	out := fs.String("o", "", "output file, stdout if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" || fs.NArg() != 0 {
+		usage()
+		return errors.New("a database directory is required")
+	}
+
+	db, err := open(*dir, *keySource, 0)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	manifest, err := db.Backup(w)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "backed up %d hashes of %d types\n", manifest.Total, len(manifest.Counts))
+	return nil
+}
+
+// restore restores a backup file, or stdin for -, into an empty database and prints the
+// verification. Counters that don't match the backup fail the command
+func restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dir := fs.String("db", "", "database directory")
+	keySource := fs.String("key", "env:KRKNDB_KEY", "key source: a file, env:NAME or keyring:service/account")
+	verify := fs.Int("verify", 0, "hash types recounted after the restore, 0 for all, negative for none")
+	recount := fs.Bool("recount", false, "recount every type if the counters don't match the backup")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" || fs.NArg() != 1 {
+		usage()
+		return errors.New("a database directory and a backup file are required")
+	}
+
+	var r io.Reader = os.Stdin
+	if name := fs.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	db, err := open(*dir, *keySource, 0)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := db.Restore(r, &kdb.RestoreOptions{VerifyTypes: *verify, Recount: *recount})
+	if report != nil {
+		fmt.Fprintf(os.Stderr, "restored %s\n", report)
+	}
+	if err != nil {
+		return err
+	}
+	if !report.OK() && !report.Recounted {
+		return errors.New("restored counters don't match the backup")
+	}
+	return nil
+}
+
+// barWidth is the number of cells of the progress bar
+const barWidth = 40
+
+// drawBar redraws the progress bar of an export on stderr, a plain row count when the total
+// isn't known
+func drawBar(written uint64, total int) {
+	if total <= 0 {
+		fmt.Fprintf(os.Stderr, "\r%d rows", written)
+		return
+	}
+	done := min(float64(written)/float64(total), 1)
+	cells := int(done * barWidth)
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %3.0f%% %d/%d rows", strings.Repeat("#", cells), strings.Repeat(".", barWidth-cells), done*100, written, total)
+}
+
+// open opens the database in dir with the key from keySource
+func open(dir, keySource string, progressInterval time.Duration) (*kdb.KDB, error) {
+	opts := kdb.DefaultOptions()
+	opts.KeySource = keySource
+	opts.IngestProgressInterval = progressInterval
+	return kdb.New(dir, nil, opts)
+}
@@ -1,3 +1,5 @@
+//go:build ignore
+
 package main
 
 import (
@@ -27,15 +29,15 @@ func main() {
 		value    string
 		hashType uint64
 	}{
-		{"5f4dcc3b5aa765d61d8327deb882cf99", "password", 0},     // MD5
-		{"5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8", "password", 1400}, // SHA256
-		{"b109f3bbbc244eb82441917ed06d618b9008dd09b3befd1b5e07394c706a8bb980b1d7785e5976ec049b46df5f1326af5a2ea6d103fd07c95385ffab0cacbc86", "password", 1700}, // SHA512
-		{"482c811da5d5b4bc6d497ffa98491e38", "password123", 0}, // MD5
+		{"5f4dcc3b5aa765d61d8327deb882cf99", "password", kdb.MD5},
+		{"5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8", "password", kdb.SHA256},
+		{"b109f3bbbc244eb82441917ed06d618b9008dd09b3befd1b5e07394c706a8bb980b1d7785e5976ec049b46df5f1326af5a2ea6d103fd07c95385ffab0cacbc86", "password", kdb.SHA512},
+		{"482c811da5d5b4bc6d497ffa98491e38", "password123", kdb.MD5},
 	}
 
 	for _, h := range hashes {
 		hash := kdb.NewHash(h.hash, h.value, h.hashType)
-		if err := hash.Store(); err != nil {
+		if _, err := db.StoreHash(hash); err != nil {
 			log.Printf("Failed to store hash: %v", err)
 		} else {
 			fmt.Printf("Stored hash: %s (type: %d)\n", h.hash, h.hashType)
@@ -45,26 +47,23 @@ func main() {
 	// Example 2: Retrieve hashes by hash type using generator
 	fmt.Println("\n=== Retrieving MD5 Hashes (type 0) ===")
 	count := 0
-	for hash := range db.GetHashesByHashType(0) {
+	for hash := range db.GetHashesByHashType(kdb.MD5) {
 		count++
-		fmt.Printf("Hash #%d: %s -> %s (sum: %s)\n", count, hash.Hash, hash.Value, string(hash.Sum))
+		fmt.Printf("Hash #%d: %s -> %s (sum: %s)\n", count, hash.Hash, hash.Value, hash.Sum())
 	}
 
 	// Example 3: Retrieve SHA256 hashes (type 1400)
 	fmt.Println("\n=== Retrieving SHA256 Hashes (type 1400) ===")
 	count = 0
-	for hash := range db.GetHashesByHashType(1400) {
+	for hash := range db.GetHashesByHashType(kdb.SHA256) {
 		count++
 		fmt.Printf("Hash #%d: %s -> %s\n", count, hash.Hash, hash.Value)
 	}
 
-	// Example 4: Find a specific hash by its sum
+	// Example 4: Find a specific hash by its sum, GetHashByOriginalHash takes the hash itself
 	fmt.Println("\n=== Finding Hash by Sum ===")
 	targetHash := "5f4dcc3b5aa765d61d8327deb882cf99"
-	foundHash, err := db.GetHashBySum(
-		string(kdb.NewHash(targetHash, "", 0).Sum),
-		0,
-	)
+	foundHash, err := db.GetHashBySum(kdb.SumOf(targetHash), kdb.MD5)
 	if err != nil {
 		log.Printf("Hash not found: %v", err)
 	} else {
@@ -75,7 +74,7 @@ func main() {
 	fmt.Println("\n=== Searching by Prefix ===")
 	// Search for hashes starting with "5f" in MD5 (type 0)
 	count = 0
-	for hash := range db.SearchHashesByPrefix("5f", 0) {
+	for hash := range db.SearchHashesByPrefix("5f", kdb.MD5) {
 		count++
 		fmt.Printf("Match #%d: %s -> %s\n", count, hash.Hash, hash.Value)
 	}
@@ -83,7 +82,7 @@ func main() {
 	// Example 6: Demonstrate early termination of generator
 	fmt.Println("\n=== Early Termination (first 2 MD5 hashes only) ===")
 	count = 0
-	for hash := range db.GetHashesByHashType(0) {
+	for hash := range db.GetHashesByHashType(kdb.MD5) {
 		count++
 		fmt.Printf("Hash #%d: %s -> %s\n", count, hash.Hash, hash.Value)
 		if count >= 2 {
@@ -1,3 +1,5 @@
+//go:build ignore
+
 package main
 
 import (
@@ -26,8 +28,8 @@ func main() {
 	start := time.Now()
 	for i := 0; i < 1000; i++ {
 		hashStr := fmt.Sprintf("hash_%d_test_data_for_performance", i)
-		hash := kdb.NewHash(hashStr, fmt.Sprintf("value_%d", i), 0)
-		if err := hash.Store(); err != nil {
+		hash := kdb.NewHash(hashStr, fmt.Sprintf("value_%d", i), kdb.MD5)
+		if _, err := db.StoreHash(hash); err != nil {
 			log.Printf("Failed to store hash: %v", err)
 		}
 	}
@@ -38,7 +40,7 @@ func main() {
 	fmt.Println("Looking up a single hash by exact match...")
 	start = time.Now()
 	targetHash := "hash_500_test_data_for_performance"
-	found, err := db.GetHashByOriginalHash(targetHash, 0)
+	found, err := db.GetHashByOriginalHash(targetHash, kdb.MD5)
 	elapsed := time.Since(start)
 	if err != nil {
 		log.Printf("Not found: %v", err)
@@ -65,7 +67,7 @@ func main() {
 
 	start = time.Now()
 	foundCount := 0
-	for hash := range db.FindHashes(searchHashes, 0) {
+	for hash := range db.FindHashes(searchHashes, kdb.MD5) {
 		foundCount++
 		fmt.Printf("  Found: %s -> %s\n", hash.Hash, hash.Value)
 	}
@@ -78,7 +80,7 @@ func main() {
 	fmt.Println("Iterating through all 1000 hashes...")
 	start = time.Now()
 	count := 0
-	for range db.GetHashesByHashType(0) {
+	for range db.GetHashesByHashType(kdb.MD5) {
 		count++
 	}
 	elapsed = time.Since(start)
@@ -90,7 +92,7 @@ func main() {
 	fmt.Println("Getting only first 5 hashes (demonstrating lazy evaluation)...")
 	start = time.Now()
 	count = 0
-	for hash := range db.GetHashesByHashType(0) {
+	for hash := range db.GetHashesByHashType(kdb.MD5) {
 		count++
 		if count == 1 {
 			fmt.Printf("  First hash: %s\n", hash.Hash)
@@ -113,17 +115,17 @@ func main() {
 		"aabbccdddeadbeefcafebabe12345678",
 	}
 	for i, h := range testHashes {
-		hash := kdb.NewHash(h, fmt.Sprintf("prefix_test_%d", i), 0)
-		hash.Store()
+		hash := kdb.NewHash(h, fmt.Sprintf("prefix_test_%d", i), kdb.MD5)
+		db.StoreHash(hash)
 	}
 
 	start = time.Now()
 	count = 0
 	// Search for hashes where the SHA256 sum starts with a specific pattern
 	// Note: This searches the SHA256 sum of the hash, not the hash itself
-	for hash := range db.SearchHashesByPrefix("", 0) {
+	for hash := range db.SearchHashesByPrefix("", kdb.MD5) {
 		if count < 3 {
-			fmt.Printf("  Match: %s (sum prefix: %.16s...)\n", hash.Hash, string(hash.Sum))
+			fmt.Printf("  Match: %s (sum prefix: %.16s...)\n", hash.Hash, hash.Sum())
 		}
 		count++
 		if count >= 3 {
@@ -0,0 +1,57 @@
+//go:build ignore
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/kdb"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Println("Usage: go run potfile_watcher.go <potfile> <hash type>")
+		os.Exit(1)
+	}
+
+	hashType, err := strconv.ParseUint(os.Args[2], 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid hash type: %v", err)
+	}
+
+	// Create a 32-byte encryption key (in production, use a secure key)
+	encryptionKey := []byte("12345678901234567890123456789012")
+
+	db, err := kdb.New("./data", encryptionKey)
+	if err != nil {
+		log.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	// Stop watching on Ctrl+C, the read offset is kept for the next run
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	opts := kdb.WatchOptions{
+		PollInterval: time.Second,
+		OnConflict:   kdb.ConflictKeep,
+		Source:       &kdb.Source{Tool: "hashcat"},
+	}
+
+	fmt.Printf("Watching %s for cracks of hash type %d, press Ctrl+C to stop\n", os.Args[1], hashType)
+	if err := db.WatchPotfile(ctx, os.Args[1], hashType, opts); err != nil {
+		log.Fatalf("Watcher stopped: %v", err)
+	}
+
+	count, err := db.HashesByType(hashType)
+	if err != nil {
+		log.Fatalf("Failed to count hashes: %v", err)
+	}
+	fmt.Printf("Stopped, %d hashes of type %d stored\n", count, hashType)
+}
@@ -0,0 +1,535 @@
+package kdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	apiMaxBody    = 64 << 20 // Largest request body the API reads
+	apiMaxFind    = 10000    // Most hashes one find request may search
+	apiPageSize   = 1000     // Hashes a listing reads per page, the database lock is released between pages
+	apiStreamType = "application/x-ndjson"
+)
+
+// APIError is an error of the API APIHandler serves, as its responses carry it and pkg/client
+// returns it. errors.Is reports true for the database error it stands for, such as
+// badger.ErrKeyNotFound for a hash that isn't stored or ErrInvalidSum
+type APIError struct {
+	Status  int    `json:"status"`         // HTTP status of the response
+	Code    string `json:"code,omitempty"` // Names the database error, empty for others
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	for _, known := range apiErrors {
+		if known.code == e.Code {
+			return known.err
+		}
+	}
+	return nil
+}
+
+// Temporary returns true for errors a later attempt may not run into: write contention, a
+// deadline that passed, too many open iterators or a server that is overloaded or restarting
+func (e *APIError) Temporary() bool {
+	switch e.Status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// apiErrors are the database errors the API names, the first one an error matches wins
+var apiErrors = []struct {
+	code   string
+	err    error
+	status int
+}{
+	{"not_found", badger.ErrKeyNotFound, http.StatusNotFound},
+	{"invalid_sum", ErrInvalidSum, http.StatusBadRequest},
+	{"empty_hash", ErrEmptyHash, http.StatusBadRequest},
+	{"hash_too_large", ErrHashTooLarge, http.StatusBadRequest},
+	{"value_too_large", ErrValueTooLarge, http.StatusBadRequest},
+	{"invalid_hash", ErrInvalidHash, http.StatusBadRequest},
+	{"invalid_hash_type", ErrInvalidHashType, http.StatusBadRequest},
+	{"metadata_too_large", ErrMetadataTooLarge, http.StatusBadRequest},
+	{"batch_too_large", ErrBatchTooLarge, http.StatusRequestEntityTooLarge},
+	{"quota_exceeded", ErrQuotaExceeded, http.StatusConflict},
+	{"too_much_contention", ErrTooMuchContention, http.StatusServiceUnavailable},
+	{"too_many_iterators", ErrTooManyIterators, http.StatusServiceUnavailable},
+	{"operation_timeout", ErrOperationTimeout, http.StatusGatewayTimeout},
+	{"internal_panic", ErrInternalPanic, http.StatusInternalServerError},
+}
+
+// apiLine is a line of a streamed response: a hash with the cursor to resume after it, the error
+// that ended the stream, or done for a stream that ended normally, so a cut connection can't
+// pass for the end
+type apiLine struct {
+	Hash   *Hash     `json:"hash,omitempty"`
+	Cursor string    `json:"cursor,omitempty"`
+	Error  *APIError `json:"error,omitempty"`
+	Done   bool      `json:"done,omitempty"`
+}
+
+// apiCrack is the body of a crack request
+type apiCrack struct {
+	Hash   string  `json:"hash"`
+	Value  string  `json:"value"`
+	Source *Source `json:"source,omitempty"`
+}
+
+// apiFind is the body of a find request
+type apiFind struct {
+	Hashes []string `json:"hashes"`
+}
+
+// APIHandler serves the HashStore API over HTTP for pkg/client, under /v1/. Hashes travel in
+// their JSON form; listings and finds stream JSON lines, read from the database a page at a time
+// so a slow reader doesn't hold the database lock. Errors are APIError bodies naming the database
+// error. With tokens, reads need the read role and stores, cracks and deletes the write role, see
+// TokenRoles.Require. nil tokens let every request through, for listeners only trusted clients
+// reach. Mount it under a path prefix with http.StripPrefix
+func (kc *KDB) APIHandler(tokens *TokenRoles) http.Handler {
+	mux := http.NewServeMux()
+	route := func(pattern string, role Role, h http.HandlerFunc) {
+		var handler http.Handler = h
+		if tokens != nil {
+			handler = tokens.Require(role, handler)
+		}
+		mux.Handle(pattern, handler)
+	}
+
+	route("POST /v1/hash", RoleWrite, kc.apiStoreHash)
+	route("POST /v1/hashes", RoleWrite, kc.apiStoreHashes)
+	route("GET /v1/count", RoleRead, kc.apiTotal)
+	route("GET /v1/types", RoleRead, kc.apiTypes)
+	route("GET /v1/types/{type}/count", RoleRead, kc.apiTypeCount)
+	route("GET /v1/types/{type}/sum", RoleRead, kc.apiGetBySum)
+	route("GET /v1/types/{type}/hash", RoleRead, kc.apiGetByHash)
+	route("DELETE /v1/types/{type}/hash", RoleWrite, kc.apiDelete)
+	route("POST /v1/types/{type}/cracked", RoleWrite, kc.apiMarkCracked)
+	route("GET /v1/types/{type}/hashes", RoleRead, kc.apiList)
+	route("POST /v1/types/{type}/find", RoleRead, kc.apiFind)
+	return mux
+}
+
+func (kc *KDB) apiStoreHash(w http.ResponseWriter, r *http.Request) {
+	var sh Hash
+	if err := decodeAPIBody(w, r, &sh); err != nil {
+		kc.writeAPIError(w, r, "store", err)
+		return
+	}
+	isNew, err := kc.StoreHashContext(r.Context(), &sh)
+	if err != nil {
+		kc.writeAPIError(w, r, "store", err)
+		return
+	}
+	writeAPI(w, http.StatusOK, struct {
+		New bool `json:"new"`
+	}{isNew})
+}
+
+// apiStoreHashes stores the hashes of a body of JSON lines as one batch
+func (kc *KDB) apiStoreHashes(w http.ResponseWriter, r *http.Request) {
+	var hashes []*Hash
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBody))
+	for {
+		sh := &Hash{}
+		err := dec.Decode(sh)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			kc.writeAPIError(w, r, "store batch", apiBodyError(err))
+			return
+		}
+		hashes = append(hashes, sh)
+	}
+
+	result, err := kc.StoreHashesContext(r.Context(), hashes)
+	if err != nil {
+		kc.writeAPIError(w, r, "store batch", err)
+		return
+	}
+	writeAPI(w, http.StatusOK, result)
+}
+
+func (kc *KDB) apiTotal(w http.ResponseWriter, r *http.Request) {
+	n, err := kc.TotalHashes()
+	if err != nil {
+		kc.writeAPIError(w, r, "count", err)
+		return
+	}
+	writeAPI(w, http.StatusOK, struct {
+		Count int `json:"count"`
+	}{n})
+}
+
+func (kc *KDB) apiTypes(w http.ResponseWriter, r *http.Request) {
+	types, err := kc.GetRegisteredHashTypes()
+	if err != nil {
+		kc.writeAPIError(w, r, "list types", err)
+		return
+	}
+	writeAPI(w, http.StatusOK, struct {
+		Types []uint64 `json:"types"`
+	}{types})
+}
+
+func (kc *KDB) apiTypeCount(w http.ResponseWriter, r *http.Request) {
+	hashType, err := apiHashType(r)
+	if err != nil {
+		kc.writeAPIError(w, r, "count", err)
+		return
+	}
+	n, err := kc.HashesByType(hashType)
+	if err != nil {
+		kc.writeAPIError(w, r, "count", err)
+		return
+	}
+	writeAPI(w, http.StatusOK, struct {
+		Count int `json:"count"`
+	}{n})
+}
+
+// apiGetBySum looks a hash up by the sum in the query, raw sums are sent URL encoded
+func (kc *KDB) apiGetBySum(w http.ResponseWriter, r *http.Request) {
+	hashType, err := apiHashType(r)
+	if err != nil {
+		kc.writeAPIError(w, r, "lookup", err)
+		return
+	}
+	sh, err := kc.GetHashBySumContext(r.Context(), r.URL.Query().Get("sum"), hashType)
+	if err != nil {
+		kc.writeAPIError(w, r, "lookup", err)
+		return
+	}
+	writeAPI(w, http.StatusOK, sh)
+}
+
+func (kc *KDB) apiGetByHash(w http.ResponseWriter, r *http.Request) {
+	hashType, err := apiHashType(r)
+	if err != nil {
+		kc.writeAPIError(w, r, "lookup", err)
+		return
+	}
+	sh, err := kc.GetHashByOriginalHashContext(r.Context(), r.URL.Query().Get("hash"), hashType)
+	if err != nil {
+		kc.writeAPIError(w, r, "lookup", err)
+		return
+	}
+	writeAPI(w, http.StatusOK, sh)
+}
+
+func (kc *KDB) apiDelete(w http.ResponseWriter, r *http.Request) {
+	hashType, err := apiHashType(r)
+	if err != nil {
+		kc.writeAPIError(w, r, "delete", err)
+		return
+	}
+	if err := kc.DeleteHashContext(r.Context(), r.URL.Query().Get("hash"), hashType); err != nil {
+		kc.writeAPIError(w, r, "delete", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (kc *KDB) apiMarkCracked(w http.ResponseWriter, r *http.Request) {
+	hashType, err := apiHashType(r)
+	if err != nil {
+		kc.writeAPIError(w, r, "crack", err)
+		return
+	}
+	var crack apiCrack
+	if err := decodeAPIBody(w, r, &crack); err != nil {
+		kc.writeAPIError(w, r, "crack", err)
+		return
+	}
+	var sources []*Source
+	if crack.Source != nil {
+		sources = append(sources, crack.Source)
+	}
+	if err := kc.MarkCracked(crack.Hash, hashType, crack.Value, sources...); err != nil {
+		kc.writeAPIError(w, r, "crack", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiList streams the hashes of a type, those whose sums start with the prefix in the query if
+// there is one, a page at a time
+func (kc *KDB) apiList(w http.ResponseWriter, r *http.Request) {
+	hashType, err := apiHashType(r)
+	if err != nil {
+		kc.writeAPIError(w, r, "iteration", err)
+		return
+	}
+	so, err := kc.apiScanOptions(r, hashType)
+	if err != nil {
+		kc.writeAPIError(w, r, "iteration", err)
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+
+	limit := so.Limit
+	var stream *apiStream
+	for sent := 0; ; {
+		so.Limit = apiPageSize
+		if limit > 0 {
+			so.Limit = min(apiPageSize, limit-sent)
+		}
+		page, err := kc.apiPage(hashType, prefix, nil, so)
+		if err != nil {
+			if stream == nil {
+				kc.writeAPIError(w, r, "iteration", err)
+			} else if r.Context().Err() == nil {
+				stream.end(kc.apiError(r, "iteration", err))
+			}
+			return
+		}
+
+		if stream == nil {
+			stream = newAPIStream(w)
+		}
+		if err := stream.page(page); err != nil {
+			return
+		}
+		sent += len(page)
+		if len(page) < so.Limit || sent == limit {
+			stream.end(nil)
+			return
+		}
+		so.After = page[len(page)-1]
+	}
+}
+
+// apiFind streams the hashes stored of those in the body, read in a single page
+func (kc *KDB) apiFind(w http.ResponseWriter, r *http.Request) {
+	hashType, err := apiHashType(r)
+	if err != nil {
+		kc.writeAPIError(w, r, "find", err)
+		return
+	}
+	so, err := kc.apiScanOptions(r, hashType)
+	if err != nil {
+		kc.writeAPIError(w, r, "find", err)
+		return
+	}
+	var find apiFind
+	if err := decodeAPIBody(w, r, &find); err != nil {
+		kc.writeAPIError(w, r, "find", err)
+		return
+	}
+	if len(find.Hashes) > apiMaxFind {
+		kc.writeAPIError(w, r, "find", badAPIRequest("%d hashes searched at once, at most %d may be", len(find.Hashes), apiMaxFind))
+		return
+	}
+
+	var page []*Hash
+	if len(find.Hashes) > 0 {
+		if page, err = kc.apiPage(hashType, "", find.Hashes, so); err != nil {
+			kc.writeAPIError(w, r, "find", err)
+			return
+		}
+	}
+	stream := newAPIStream(w)
+	if err := stream.page(page); err == nil {
+		stream.end(nil)
+	}
+}
+
+// apiPage reads a page of the hashes of hashType into memory, those under prefix or, when
+// searching, those of possibleHashes, in the order and within the limit of so. The database lock
+// is released before the page is written out
+func (kc *KDB) apiPage(hashType uint64, prefix string, possibleHashes []string, so *ScanOptions) (page []*Hash, err error) {
+	defer kc.recoverPanic("API read", &err)
+	kc.ops.iterations.Add(1)
+
+	collect := func(sh *Hash) bool {
+		page = append(page, sh)
+		return true
+	}
+	err = kc.viewLocked(func(txn *badger.Txn) error {
+		if possibleHashes == nil {
+			return kc.scan(txn, hashType, prefix, nil, so, collect)
+		}
+		targets := kc.findSums(possibleHashes, hashType)
+		plan, err := kc.planFind(txn, hashType, len(targets.sums), so)
+		if err != nil {
+			return err
+		}
+		return kc.find(txn, plan.Strategy, hashType, targets, so, collect)
+	})
+	return page, err
+}
+
+// apiScanOptions reads the scan options of a listing or find from the query of r: order, limit,
+// keys_only and after, the cursor of the hash to resume after, its hex sum. A scan stops once
+// the client goes away
+func (kc *KDB) apiScanOptions(r *http.Request, hashType uint64) (*ScanOptions, error) {
+	query := r.URL.Query()
+	so := DefaultScanOptions()
+	so.ctx = r.Context()
+
+	if name := query.Get("order"); name != "" {
+		order, ok := parseOrder(name)
+		if !ok {
+			return nil, badAPIRequest("unknown order %q", name)
+		}
+		so.Order = order
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return nil, badAPIRequest("invalid limit %q", limit)
+		}
+		so.Limit = n
+	}
+	if keysOnly := query.Get("keys_only"); keysOnly != "" {
+		b, err := strconv.ParseBool(keysOnly)
+		if err != nil {
+			return nil, badAPIRequest("invalid keys_only %q", keysOnly)
+		}
+		so.KeysOnly = b
+	}
+
+	after := query.Get("after")
+	if after == "" {
+		return so, nil
+	}
+	sum, err := checkSum(after)
+	if err != nil {
+		return nil, err
+	}
+	// Insertion orders resume after the hash's place in the insertion index, read from its record
+	if so.Order.insertion() {
+		if so.After, err = kc.GetHashBySumContext(r.Context(), sum, hashType); err != nil {
+			return nil, fmt.Errorf("failed to read the hash to resume after: %w", err)
+		}
+		return so, nil
+	}
+	so.After = &Hash{sum: rawSum([]byte(sum)), HashType: hashType}
+	return so, nil
+}
+
+// parseOrder returns the order named name, as Order.String names it
+func parseOrder(name string) (Order, bool) {
+	for _, order := range []Order{SumAsc, SumDesc, InsertionAsc, InsertionDesc} {
+		if order.String() == name {
+			return order, true
+		}
+	}
+	return 0, false
+}
+
+// apiHashType reads the hash type of the path of r
+func apiHashType(r *http.Request) (uint64, error) {
+	hashType, err := strconv.ParseUint(r.PathValue("type"), 10, 64)
+	if err != nil {
+		return 0, badAPIRequest("invalid hash type %q", r.PathValue("type"))
+	}
+	return hashType, nil
+}
+
+// badAPIRequest returns the error of a request the API can't make sense of
+func badAPIRequest(format string, args ...any) *APIError {
+	return &APIError{Status: http.StatusBadRequest, Code: "bad_request", Message: fmt.Sprintf(format, args...)}
+}
+
+// decodeAPIBody decodes the JSON body of r into v, reading at most apiMaxBody bytes of it
+func decodeAPIBody(w http.ResponseWriter, r *http.Request, v any) error {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBody)).Decode(v); err != nil {
+		return apiBodyError(err)
+	}
+	return nil
+}
+
+// apiBodyError returns the error of a request body that failed to decode with err
+func apiBodyError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("%w: the request body is over %d bytes", ErrBatchTooLarge, tooLarge.Limit)
+	}
+	return badAPIRequest("malformed request body: %v", err)
+}
+
+// apiError returns err as the API reports it. Errors that aren't one of apiErrors are logged and
+// reported without their text, which may name paths or other internals
+func (kc *KDB) apiError(r *http.Request, op string, err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	for _, known := range apiErrors {
+		if errors.Is(err, known.err) {
+			return &APIError{Status: known.status, Code: known.code, Message: err.Error()}
+		}
+	}
+	kc.log(fmt.Sprintf("API %s %s failed: %v", r.Method, r.URL.Path, err), Error)
+	return &APIError{Status: http.StatusInternalServerError, Message: op + " failed, see the server log"}
+}
+
+// writeAPIError writes the error response of err, unless the client is gone already
+func (kc *KDB) writeAPIError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	if r.Context().Err() != nil {
+		return
+	}
+	apiErr := kc.apiError(r, op, err)
+	writeAPI(w, apiErr.Status, apiErr)
+}
+
+// writeAPI writes v as the JSON body of a response with status
+func writeAPI(w http.ResponseWriter, status int, v any) {
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// apiStream writes a streamed response, JSON lines flushed to the client after every page
+type apiStream struct {
+	enc *json.Encoder
+	rc  *http.ResponseController
+}
+
+// newAPIStream starts a streamed response on w
+func newAPIStream(w http.ResponseWriter) *apiStream {
+	h := w.Header()
+	h.Set("Content-Type", apiStreamType)
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	return &apiStream{enc: json.NewEncoder(w), rc: http.NewResponseController(w)}
+}
+
+// page writes the lines of hashes and flushes them. An error means the client is gone
+func (s *apiStream) page(hashes []*Hash) error {
+	for _, sh := range hashes {
+		if err := s.enc.Encode(apiLine{Hash: sh, Cursor: sh.Sum()}); err != nil {
+			return err
+		}
+	}
+	if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// end writes the last line of the stream, the error that ended it or done
+func (s *apiStream) end(err *APIError) {
+	s.enc.Encode(apiLine{Error: err, Done: err == nil})
+	s.rc.Flush()
+}
@@ -0,0 +1,116 @@
+package kdb
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+func TestAPIListsInPages(t *testing.T) {
+	db := newTestDB(t, testPrefix, nil)
+	hashes := make([]*Hash, 2*apiPageSize+500)
+	for i := range hashes {
+		hashes[i] = NewHash(testHash(i), "", 0)
+	}
+	if _, err := db.StoreHashes(hashes); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	bySum := make([]string, len(hashes))
+	for i, sh := range hashes {
+		bySum[i] = sh.Sum()
+	}
+	byInsertion := slices.Clone(bySum)
+	slices.Sort(bySum)
+	slices.Reverse(byInsertion)
+
+	api := db.APIHandler(nil)
+	for order, want := range map[Order][]string{SumAsc: bySum, InsertionDesc: byInsertion} {
+		// Over two pages and into a third, resuming after the second hash
+		query := fmt.Sprintf("/v1/types/0/hashes?order=%s&limit=%d&after=%s", order, 2*apiPageSize+100, want[1])
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", order, rec.Code, rec.Body)
+		}
+
+		var got []string
+		done := false
+		scanner := bufio.NewScanner(rec.Body)
+		for scanner.Scan() {
+			var line struct {
+				Hash   *Hash `json:"hash"`
+				Cursor string
+				Done   bool
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				t.Fatalf("%s: the line %s: %v", order, scanner.Bytes(), err)
+			}
+			if done {
+				t.Fatalf("%s: a line after the done line", order)
+			}
+			if line.Hash != nil {
+				if line.Cursor != line.Hash.Sum() {
+					t.Errorf("%s: %s has the cursor %s", order, line.Hash.Sum(), line.Cursor)
+				}
+				got = append(got, line.Cursor)
+			}
+			done = line.Done
+		}
+		if !done {
+			t.Errorf("%s: the stream didn't end with its done line", order)
+		}
+		if !slices.Equal(got, want[2:2*apiPageSize+102]) {
+			t.Errorf("%s: %d hashes listed out of order", order, len(got))
+		}
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	var logged logRecorder
+	db := newTestDB(t, testPrefix, func(opts *Options) { opts.Logger = logged.log })
+	req := httptest.NewRequest(http.MethodGet, "/v1/types/0/hash", nil)
+
+	apiErr := db.apiError(req, "lookup", fmt.Errorf("failed to read: %w", badger.ErrKeyNotFound))
+	if apiErr.Status != http.StatusNotFound || !errors.Is(apiErr, badger.ErrKeyNotFound) {
+		t.Errorf("a miss became %+v", apiErr)
+	}
+	if read := (&APIError{Code: apiErr.Code}); !errors.Is(read, badger.ErrKeyNotFound) {
+		t.Errorf("the code %q as the client reads it doesn't unwrap", apiErr.Code)
+	}
+	if apiErr := db.apiError(req, "store", ErrTooMuchContention); !apiErr.Temporary() {
+		t.Errorf("contention isn't temporary: %+v", apiErr)
+	}
+
+	// Errors the API doesn't know are logged, their text isn't sent
+	apiErr = db.apiError(req, "lookup", errors.New("open /srv/krkndb/000042.vlog: input/output error"))
+	if apiErr.Status != http.StatusInternalServerError || strings.Contains(apiErr.Message, "/srv") || errors.Unwrap(apiErr) != nil {
+		t.Errorf("an unknown error became %+v", apiErr)
+	}
+	if logged.count("000042.vlog") != 1 {
+		t.Errorf("the unknown error wasn't logged")
+	}
+
+	api := db.APIHandler(nil)
+	for _, bad := range []struct{ method, path, body string }{
+		{http.MethodGet, "/v1/types/lm/count", ""},
+		{http.MethodGet, "/v1/types/0/hashes?order=random", ""},
+		{http.MethodGet, "/v1/types/0/hashes?limit=-1", ""},
+		{http.MethodPost, "/v1/types/0/find", "{"},
+		{http.MethodPost, "/v1/hashes", `{"hash":"a"}{`},
+	} {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(bad.method, bad.path, strings.NewReader(bad.body)))
+		var got APIError
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || rec.Code != http.StatusBadRequest || got.Code != "bad_request" {
+			t.Errorf("%s %s answered %d %s", bad.method, bad.path, rec.Code, rec.Body)
+		}
+	}
+}
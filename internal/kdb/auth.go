@@ -0,0 +1,118 @@
+package kdb
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Role is what a bearer token may do over HTTP
+type Role int
+
+const (
+	RoleRead  Role = iota + 1 // Pages and reads that change nothing, such as StatusHandler
+	RoleWrite                 // Stores and imports
+	RoleAdmin                 // Everything, destructive calls such as dropping a type or restoring included
+)
+
+// String returns the name of the role, e.g. "read"
+func (r Role) String() string {
+	switch r {
+	case RoleRead:
+		return "read"
+	case RoleWrite:
+		return "write"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return fmt.Sprintf("role %d", int(r))
+	}
+}
+
+// ParseRole returns the role named read, write or admin
+func ParseRole(name string) (Role, error) {
+	switch strings.ToLower(name) {
+	case "read":
+		return RoleRead, nil
+	case "write":
+		return RoleWrite, nil
+	case "admin":
+		return RoleAdmin, nil
+	}
+	return 0, fmt.Errorf("unknown role %q, want read, write or admin", name)
+}
+
+// grants returns true if a token of role r may make calls needing role need. Admin may make
+// every call, read and write only their own, so ingest workers can't read and dashboards can't
+// write
+func (r Role) grants(need Role) bool {
+	return r == RoleAdmin || r == need
+}
+
+// TokenRoles maps bearer tokens to their role. Tokens are kept as SHA-256 digests so lookups
+// don't compare the secrets themselves and nothing holding the map can print them
+type TokenRoles struct {
+	roles map[[sha256.Size]byte]Role
+}
+
+// LoadTokenRoles reads a token file: one "role token" pair per line, blank lines and lines
+// starting with # are skipped. Errors name the line, never the token
+func LoadTokenRoles(r io.Reader) (*TokenRoles, error) {
+	t := &TokenRoles{roles: make(map[[sha256.Size]byte]Role)}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("token file line %d: want a role and a token", line)
+		}
+		role, err := ParseRole(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("token file line %d: %w", line, err)
+		}
+		digest := sha256.Sum256([]byte(fields[1]))
+		if _, ok := t.roles[digest]; ok {
+			return nil, fmt.Errorf("token file line %d: token listed twice", line)
+		}
+		t.roles[digest] = role
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+	if len(t.roles) == 0 {
+		return nil, fmt.Errorf("token file lists no tokens")
+	}
+	return t, nil
+}
+
+// Role returns the role of token, false for tokens not in the file
+func (t *TokenRoles) Role(token string) (Role, bool) {
+	role, ok := t.roles[sha256.Sum256([]byte(token))]
+	return role, ok
+}
+
+// Require wraps next so only requests with an "Authorization: Bearer" token granted role get
+// through. Requests without a known token get 401, those whose token lacks the role 403 naming the
+// role missing. Neither the token nor the header is logged
+func (t *TokenRoles) Require(role Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		has, known := t.Role(strings.TrimSpace(token))
+		switch {
+		case !ok || !known:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="krkndb"`)
+			http.Error(w, "unauthorized: a bearer token is required", http.StatusUnauthorized)
+		case !has.grants(role):
+			logger(fmt.Sprintf("Refused %s %s to a %s token, it needs the %s role", r.Method, r.URL.Path, has, role), Warning)
+			http.Error(w, fmt.Sprintf("permission denied: needs the %s role", role), http.StatusForbidden)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package kdb
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testTokenFile gives every role a token
+const testTokenFile = `
+# dashboards
+read  dash-7f3a9c
+# ingest workers
+write ingest-51be20
+admin ops-d41d8c
+`
+
+// testTokens are the tokens of testTokenFile and one that isn't in it
+var testTokens = []string{"dash-7f3a9c", "ingest-51be20", "ops-d41d8c", "not-a-token"}
+
+func TestLoadTokenRoles(t *testing.T) {
+	tokens, err := LoadTokenRoles(strings.NewReader(testTokenFile))
+	if err != nil {
+		t.Fatalf("LoadTokenRoles: %v", err)
+	}
+	for token, want := range map[string]Role{"dash-7f3a9c": RoleRead, "ingest-51be20": RoleWrite, "ops-d41d8c": RoleAdmin} {
+		if role, ok := tokens.Role(token); !ok || role != want {
+			t.Errorf("token %s has role %v, want %v", token, role, want)
+		}
+	}
+	if _, ok := tokens.Role("nobody"); ok {
+		t.Errorf("an unknown token has a role")
+	}
+
+	for _, bad := range []string{"reader dash-1\n", "read\n", "read a b\n", "read dash-1\nwrite dash-1\n", "# nothing\n"} {
+		_, err := LoadTokenRoles(strings.NewReader(bad))
+		if err == nil {
+			t.Errorf("the token file %q loaded", bad)
+		} else if strings.Contains(err.Error(), "dash-1") {
+			t.Errorf("the error names the token: %v", err)
+		}
+	}
+}
+
+func TestRequireRoleBoundaries(t *testing.T) {
+	// Refusals are logged where no database is at hand
+	var logged logRecorder
+	log := Logger(logged.log)
+	previous := defaultLogger.Swap(&log)
+	t.Cleanup(func() { defaultLogger.Store(previous) })
+
+	tokens, err := LoadTokenRoles(strings.NewReader(testTokenFile))
+	if err != nil {
+		t.Fatalf("LoadTokenRoles: %v", err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "ok") })
+	mux := http.NewServeMux()
+	mux.Handle("/status", tokens.Require(RoleRead, ok))
+	mux.Handle("/ingest", tokens.Require(RoleWrite, ok))
+	mux.Handle("/restore", tokens.Require(RoleAdmin, ok))
+
+	cases := []struct {
+		path, token string
+		status      int
+		missing     string
+	}{
+		{"/status", "dash-7f3a9c", 200, ""},
+		{"/ingest", "dash-7f3a9c", 403, "write"},
+		{"/restore", "dash-7f3a9c", 403, "admin"},
+		{"/status", "ingest-51be20", 403, "read"},
+		{"/ingest", "ingest-51be20", 200, ""},
+		{"/restore", "ingest-51be20", 403, "admin"},
+		{"/status", "ops-d41d8c", 200, ""},
+		{"/ingest", "ops-d41d8c", 200, ""},
+		{"/restore", "ops-d41d8c", 200, ""},
+		{"/status", "", 401, ""},
+		{"/restore", "not-a-token", 401, ""},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		body := rec.Body.String()
+		if rec.Code != c.status {
+			t.Errorf("%s with %q returned %d, want %d", c.path, c.token, rec.Code, c.status)
+		}
+		if c.missing != "" && !strings.Contains(body, "needs the "+c.missing+" role") {
+			t.Errorf("%s with %q: %q doesn't name the %s role", c.path, c.token, body, c.missing)
+		}
+		if c.token != "" && strings.Contains(body, c.token) {
+			t.Errorf("the response to %s carries the token", c.path)
+		}
+	}
+
+	if logged.count("Refused") != 4 {
+		t.Errorf("%d of 4 refusals were logged: %v", logged.count("Refused"), logged.messages)
+	}
+	for _, token := range testTokens {
+		if n := logged.count(token); n > 0 {
+			t.Errorf("%d log lines carry the token %s", n, token)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package kdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	backupMagic         = "KRKNBAK1" // first bytes of every backup stream
+	backupFormatVersion = 1          // version of the stream layout, see Backup
+	maxManifestSize     = 64 << 20   // largest manifest ReadBackupManifest accepts
+	maxBackupField      = 1 << 30    // largest key or value a backup stream may carry
+)
+
+// backupOwnMeta are the meta keys describing the database itself rather than its data. Backups
+// leave them out, the database restored into keeps its own
+var backupOwnMeta = []string{keyProbeMetaKey, keyPrefixMetaKey, schemaVersionMetaKey, cleanGenerationMetaKey, LastBackupMetaKey, migrationCursorMetaKey, deltaIDMetaKey}
+
+// BackupManifest heads a backup stream with the counters as they were when the backup was taken,
+// read in the same transaction as the entries that follow, so a restore can check it got them all
+type BackupManifest struct {
+	Version       int            `json:"version"`        // Stream layout version
+	SchemaVersion int            `json:"schema_version"` // Schema version of the database backed up
+	KeyPrefix     string         `json:"key_prefix"`     // Options.KeyPrefix of the database backed up
+	CreatedAt     time.Time      `json:"created_at"`     // When the backup was taken, UTC
+	Total         int            `json:"total"`          // Total hash counter
+	Counts        map[uint64]int `json:"counts"`         // Hash counter of every registered type
+}
+
+// RestoreOptions tune Restore
+type RestoreOptions struct {
+	// VerifyTypes is how many hash types, picked at random, are recounted after the restore and
+	// compared to the manifest. 0 recounts every type, negative none; counters are compared to
+	// the manifest either way
+	VerifyTypes int
+	// Recount runs RebuildRegistry when verification finds a mismatch, so the registry and the
+	// counters match what was actually restored
+	Recount bool
+	// Context cancels the recounts of the verification, nil uses context.Background
+	Context context.Context
+}
+
+// CountCheck compares a counter after a restore with the manifest of the backup
+type CountCheck struct {
+	HashType  uint64 `json:"type"`      // Unused by RestoreReport.Total
+	Backup    int    `json:"backup"`    // Count in the manifest
+	Counter   int    `json:"counter"`   // Counter as restored
+	Recounted int    `json:"recounted"` // Hashes found by a recount, -1 if not recounted
+}
+
+// Match reports whether the counter, and the recount if there was one, agree with the manifest
+func (c CountCheck) Match() bool {
+	return c.Counter == c.Backup && (c.Recounted < 0 || c.Recounted == c.Backup)
+}
+
+// RestoreReport is what Restore wrote and how it compares to the manifest of the backup
+type RestoreReport struct {
+	Manifest   BackupManifest `json:"manifest"`
+	Entries    int            `json:"entries"`    // Keys written
+	Checks     []CountCheck   `json:"checks"`     // Every type of the manifest, ordered by type
+	Total      CountCheck     `json:"total"`      // Recounted only if every type was
+	Mismatches []CountCheck   `json:"mismatches"` // The checks of types that didn't match
+	Recounted  bool           `json:"recounted"`  // RebuildRegistry ran because of the mismatches, see RestoreOptions.Recount
+}
+
+// OK reports whether every counter checked agrees with the manifest
+func (r *RestoreReport) OK() bool {
+	return len(r.Mismatches) == 0 && r.Total.Match()
+}
+
+// String summarizes the report
+func (r *RestoreReport) String() string {
+	recounted := 0
+	for _, c := range r.Checks {
+		if c.Recounted >= 0 {
+			recounted++
+		}
+	}
+	s := fmt.Sprintf("%d entries, %d types, %d recounted", r.Entries, len(r.Checks), recounted)
+	if r.OK() {
+		return s + ", counters match the backup"
+	}
+	var mismatches []string
+	for _, c := range r.Mismatches {
+		mismatches = append(mismatches, fmt.Sprintf("type %d backup %d counter %d recounted %d", c.HashType, c.Backup, c.Counter, c.Recounted))
+	}
+	if c := r.Total; !c.Match() {
+		mismatches = append(mismatches, fmt.Sprintf("total backup %d counter %d recounted %d", c.Backup, c.Counter, c.Recounted))
+	}
+	return s + ", mismatches: " + strings.Join(mismatches, "; ")
+}
+
+// Backup writes every key of the database to w, headed by a manifest of its counters, all read
+// in one transaction so the backup is consistent while writes go on. Meta entries describing the
+// database itself, such as the key probe, are left out. Values are written as stored, in the
+// clear, encrypt the stream if it leaves the machine. On success the time is recorded under
+// LastBackupMetaKey
+func (kc *KDB) Backup(w io.Writer) (*BackupManifest, error) {
+	if kc.Nil() || kc.c.IsClosed() {
+		return nil, ErrNotInitialized
+	}
+
+	txn := kc.c.NewTransaction(false)
+	defer txn.Discard()
+
+	manifest, err := kc.backupManifest(txn)
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to read the backup manifest: %v", err), Error)
+		return nil, fmt.Errorf("failed to read the backup manifest: %w", err)
+	}
+
+	bw := bufio.NewWriterSize(w, 256<<10)
+	if err := writeBackupManifest(bw, manifest); err != nil {
+		return nil, fmt.Errorf("failed to write the backup manifest: %w", err)
+	}
+
+	own := make([][]byte, len(backupOwnMeta))
+	for i, key := range backupOwnMeta {
+		own[i] = []byte(kc.keys.key(metaPrefix, key))
+	}
+	// Session sets are scratch space of the process that wrote them
+	own = append(own, []byte(kc.keys.key(sessionHeaderScanPrefix)), []byte(kc.keys.key(sessionSetScanPrefix)))
+
+	prefix := []byte(kc.keys.prefix + ":")
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := kc.newIterator(txn, opts)
+	defer it.Close()
+
+	entries := 0
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		key := item.Key()
+		if slices.ContainsFunc(own, func(p []byte) bool { return bytes.HasPrefix(key, p) }) {
+			continue
+		}
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to read %q for the backup: %v", key, err), Error)
+			return nil, fmt.Errorf("failed to read %q: %w", key, err)
+		}
+		if err := writeBackupEntry(bw, key, value, item.UserMeta(), item.ExpiresAt()); err != nil {
+			return nil, fmt.Errorf("failed to write the backup: %w", err)
+		}
+		entries++
+	}
+
+	// An empty key ends the entries, followed by their number
+	if _, err := bw.Write(binary.AppendUvarint([]byte{0}, uint64(entries))); err != nil {
+		return nil, fmt.Errorf("failed to write the backup: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write the backup: %w", err)
+	}
+
+	if err := kc.SetMeta(LastBackupMetaKey, []byte(time.Now().UTC().Format(time.RFC3339))); err != nil {
+		kc.log(fmt.Sprintf("Failed to record the backup time: %v", err), Warning)
+	}
+	kc.log(fmt.Sprintf("Backed up %d entries, %d hashes of %d types", entries, manifest.Total, len(manifest.Counts)), Info)
+	return manifest, nil
+}
+
+// backupManifest reads the counters within txn
+func (kc *KDB) backupManifest(txn *badger.Txn) (*BackupManifest, error) {
+	schema, err := kc.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	manifest := &BackupManifest{
+		Version:       backupFormatVersion,
+		SchemaVersion: schema,
+		KeyPrefix:     kc.keys.prefix,
+		CreatedAt:     time.Now().UTC(),
+		Counts:        make(map[uint64]int),
+	}
+
+	if manifest.Total, err = kc.readShardedCount(txn, kc.keys.key(totalHashesKey)); err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	hashTypes, err := kc.readHashTypes(txn)
+	if err != nil {
+		return nil, err
+	}
+	for _, hashType := range hashTypes {
+		count, err := kc.readTypeCount(txn, hashType)
+		if err != nil && !isNotFound(err) {
+			return nil, err
+		}
+		manifest.Counts[hashType] = count
+	}
+	return manifest, nil
+}
+
+// writeBackupManifest writes the magic and the manifest, its length first
+func writeBackupManifest(w io.Writer, manifest *BackupManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, len(backupMagic)+4, len(backupMagic)+4+len(data))
+	copy(header, backupMagic)
+	binary.BigEndian.PutUint32(header[len(backupMagic):], uint32(len(data)))
+	_, err = w.Write(append(header, data...))
+	return err
+}
+
+// writeBackupEntry writes one key: its length and bytes, the value's, the user meta byte and the
+// expiry. The key is never empty, an empty one ends the entries
+func writeBackupEntry(w *bufio.Writer, key, value []byte, userMeta byte, expiresAt uint64) error {
+	buf := binary.AppendUvarint(nil, uint64(len(key)))
+	buf = append(buf, key...)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	if _, err := w.Write(value); err != nil {
+		return err
+	}
+	buf = append(buf[:0], userMeta)
+	buf = binary.AppendUvarint(buf, expiresAt)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadBackupManifest reads the manifest heading a backup stream, leaving r at the first entry
+func ReadBackupManifest(r io.Reader) (*BackupManifest, error) {
+	header := make([]byte, len(backupMagic)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read the backup header: %w", err)
+	}
+	if string(header[:len(backupMagic)]) != backupMagic {
+		return nil, errors.New("not a KrknDB backup")
+	}
+	size := binary.BigEndian.Uint32(header[len(backupMagic):])
+	if size > maxManifestSize {
+		return nil, fmt.Errorf("backup manifest of %d bytes is too large", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("failed to read the backup manifest: %w", err)
+	}
+	manifest := &BackupManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse the backup manifest: %w", err)
+	}
+	if manifest.Version != backupFormatVersion {
+		return nil, fmt.Errorf("backup format version %d isn't supported, this build reads %d", manifest.Version, backupFormatVersion)
+	}
+	return manifest, nil
+}
+
+// Restore writes a stream written by Backup into this database, which must not hold any hashes
+// yet, then verifies the counters against the manifest of the backup: every restored counter is
+// compared, and RestoreOptions.VerifyTypes types are recounted, so a restore that didn't write
+// everything shows up as mismatches in the report rather than as counters silently short.
+//
+// If the stream breaks off the entries read up to there stay written, Restore verifies them and
+// returns the report along with the error. Writes wait until the entries are written. The backup
+// must come from a database with the same key prefix and schema version, the encryption key may
+// differ
+func (kc *KDB) Restore(r io.Reader, opts *RestoreOptions) (*RestoreReport, error) {
+	if kc.Nil() || kc.c.IsClosed() {
+		return nil, ErrNotInitialized
+	}
+	if opts == nil {
+		opts = &RestoreOptions{}
+	}
+
+	br := bufio.NewReaderSize(r, 256<<10)
+	manifest, err := ReadBackupManifest(br)
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to read the backup: %v", err), Error)
+		return nil, err
+	}
+	if err := kc.checkRestoreTarget(manifest); err != nil {
+		kc.log(fmt.Sprintf("Refusing to restore: %v", err), Error)
+		return nil, err
+	}
+
+	report := &RestoreReport{Manifest: *manifest}
+	report.Entries, err = kc.restoreEntries(br)
+	if err != nil {
+		kc.log(fmt.Sprintf("Restore stopped after %d entries: %v", report.Entries, err), Error)
+		err = fmt.Errorf("restore stopped after %d entries: %w", report.Entries, err)
+	}
+
+	// The restored quotas and value dictionary replace the empty ones loaded on open
+	if loadErr := kc.loadQuotas(); loadErr != nil {
+		return report, errors.Join(err, fmt.Errorf("failed to load quotas: %w", loadErr))
+	}
+	if loadErr := kc.loadValueDictionary(); loadErr != nil {
+		return report, errors.Join(err, fmt.Errorf("failed to load the value dictionary: %w", loadErr))
+	}
+
+	if verifyErr := kc.verifyRestore(report, opts); verifyErr != nil {
+		kc.log(fmt.Sprintf("Failed to verify the restore: %v", verifyErr), Error)
+		return report, errors.Join(err, fmt.Errorf("failed to verify the restore: %w", verifyErr))
+	}
+	if report.OK() {
+		kc.log(fmt.Sprintf("Restored %s", report), Info)
+	} else {
+		kc.log(fmt.Sprintf("Restore doesn't match the backup: %s", report), Warning)
+	}
+
+	if !report.OK() && opts.Recount {
+		if recountErr := kc.RebuildRegistry(ctxOf(opts)); recountErr != nil {
+			return report, errors.Join(err, fmt.Errorf("failed to recount: %w", recountErr))
+		}
+		report.Recounted = true
+	}
+	return report, err
+}
+
+// checkRestoreTarget makes sure the backup fits this database and nothing would be overwritten
+func (kc *KDB) checkRestoreTarget(manifest *BackupManifest) error {
+	if manifest.KeyPrefix != kc.keys.prefix {
+		return fmt.Errorf("%w: backup was taken with key prefix %q, restoring into %q", ErrKeyPrefixMismatch, manifest.KeyPrefix, kc.keys.prefix)
+	}
+	schema, err := kc.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	switch {
+	case manifest.SchemaVersion > schema:
+		return fmt.Errorf("%w: backup is at schema version %d, this database at %d", ErrUnsupportedSchema, manifest.SchemaVersion, schema)
+	case manifest.SchemaVersion < schema:
+		return fmt.Errorf("%w: backup is at schema version %d, this database at %d, restore with the build that took it and migrate", ErrMigrationRequired, manifest.SchemaVersion, schema)
+	}
+
+	hashTypes, err := kc.getRegisteredHashTypes()
+	if err != nil {
+		return err
+	}
+	total, err := kc.TotalHashes()
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	if len(hashTypes) > 0 || total > 0 {
+		return fmt.Errorf("%w: %d hashes of %d types", ErrDatabaseNotEmpty, total, len(hashTypes))
+	}
+	return nil
+}
+
+// restoreEntries writes the entries of a backup stream until its end, returning how many it wrote
+func (kc *KDB) restoreEntries(br *bufio.Reader) (int, error) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	wb := kc.c.NewWriteBatch()
+	defer wb.Cancel()
+
+	entries := 0
+	for {
+		key, value, userMeta, expiresAt, err := readBackupEntry(br)
+		if err == nil && len(key) == 0 {
+			// The end, expiresAt holds the number of entries
+			if expiresAt != uint64(entries) {
+				err = fmt.Errorf("backup ends after %d of %d entries", entries, expiresAt)
+			}
+		}
+		if err != nil || len(key) == 0 {
+			if flushErr := wb.Flush(); flushErr != nil {
+				return entries, errors.Join(err, flushErr)
+			}
+			return entries, err
+		}
+
+		entry := badger.NewEntry(key, value).WithMeta(userMeta)
+		entry.ExpiresAt = expiresAt
+		if err := wb.SetEntry(entry); err != nil {
+			return entries, err
+		}
+		entries++
+	}
+}
+
+// readBackupEntry reads one key as written by writeBackupEntry
+func readBackupEntry(br *bufio.Reader) (key, value []byte, userMeta byte, expiresAt uint64, err error) {
+	readField := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxBackupField {
+			return nil, fmt.Errorf("backup entry of %d bytes is too large", n)
+		}
+		field := make([]byte, n)
+		_, err = io.ReadFull(br, field)
+		return field, err
+	}
+
+	if key, err = readField(); err != nil {
+		return nil, nil, 0, 0, noEOF(err)
+	}
+	if len(key) > 0 {
+		if value, err = readField(); err != nil {
+			return nil, nil, 0, 0, noEOF(err)
+		}
+		if userMeta, err = br.ReadByte(); err != nil {
+			return nil, nil, 0, 0, noEOF(err)
+		}
+	}
+	if expiresAt, err = binary.ReadUvarint(br); err != nil {
+		return nil, nil, 0, 0, noEOF(err)
+	}
+	return key, value, userMeta, expiresAt, nil
+}
+
+// noEOF turns the end of the stream into io.ErrUnexpectedEOF, a backup only ends after its count
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ctxOf returns RestoreOptions.Context or context.Background
+func ctxOf(opts *RestoreOptions) context.Context {
+	if opts.Context != nil {
+		return opts.Context
+	}
+	return context.Background()
+}
+
+// verifyRestore compares the counters after a restore with the manifest, recounting the types
+// RestoreOptions.VerifyTypes selects
+func (kc *KDB) verifyRestore(report *RestoreReport, opts *RestoreOptions) error {
+	ctx := ctxOf(opts)
+	hashTypes := make([]uint64, 0, len(report.Manifest.Counts))
+	for hashType := range report.Manifest.Counts {
+		hashTypes = append(hashTypes, hashType)
+	}
+	slices.Sort(hashTypes)
+
+	recount := make(map[uint64]bool)
+	switch {
+	case opts.VerifyTypes == 0 || opts.VerifyTypes >= len(hashTypes):
+		for _, hashType := range hashTypes {
+			recount[hashType] = true
+		}
+	case opts.VerifyTypes > 0:
+		for _, i := range rand.Perm(len(hashTypes))[:opts.VerifyTypes] {
+			recount[hashTypes[i]] = true
+		}
+	}
+
+	recountedTotal := 0
+	for _, hashType := range hashTypes {
+		check := CountCheck{HashType: hashType, Backup: report.Manifest.Counts[hashType], Recounted: -1}
+		counter, err := kc.typeCount(hashType)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		check.Counter = counter
+		if recount[hashType] {
+			n, err := kc.CountWhere(ctx, hashType, CountOptions{})
+			if err != nil {
+				return err
+			}
+			check.Recounted = int(n)
+			recountedTotal += check.Recounted
+		}
+		report.Checks = append(report.Checks, check)
+		if !check.Match() {
+			report.Mismatches = append(report.Mismatches, check)
+		}
+	}
+
+	total, err := kc.TotalHashes()
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	report.Total = CountCheck{Backup: report.Manifest.Total, Counter: total, Recounted: -1}
+	if len(recount) == len(hashTypes) {
+		report.Total.Recounted = recountedTotal
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package kdb
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+const (
+	maxHashcatMode   = 99999 // Highest hashcat mode number
+	maxMetaEntries   = 64    // Maximum number of metadata entries on a hash
+	maxMetaKeySize   = 128   // Maximum size of a metadata key in bytes
+	maxMetaValueSize = 4096  // Maximum size of a metadata value in bytes
+)
+
+// HashBuilder builds a Hash step by step and validates it in Build.
+// Use NewHash for the simple hash/value/type case
+//
+//	hash, err := kdb.BuildHash("8846f7eaee8fb117ad06bdd830b7586c").
+//		Type(1000).
+//		Value("password").
+//		Meta("user", "bob").
+//		Build()
+type HashBuilder struct {
+	hash    string
+	value   string
+	salt    string
+	session string
+	binary  []byte
+	meta    map[string]string
+	source  *Source
+
+	hashType uint64
+	typeSet  bool
+	strict   bool
+
+	db *KDB
+}
+
+// BuildHash starts building a hash from its hash string.
+// The hash may be empty if Binary is set, in which case the hex encoding of the binary form is used
+func BuildHash(hash string) *HashBuilder {
+	return &HashBuilder{hash: hash}
+}
+
+// BuildHash starts building a hash bound to this database, so Hash.Store writes to it
+func (kc *KDB) BuildHash(hash string) *HashBuilder {
+	return &HashBuilder{hash: hash, db: kc}
+}
+
+// Type sets the hashcat mode of the hash
+func (b *HashBuilder) Type(hashType uint64) *HashBuilder {
+	b.hashType = hashType
+	b.typeSet = true
+	return b
+}
+
+// Value sets the cracked value of the hash
+func (b *HashBuilder) Value(value string) *HashBuilder {
+	b.value = value
+	return b
+}
+
+// Salt sets the salt of a salted hash
+func (b *HashBuilder) Salt(salt string) *HashBuilder {
+	b.salt = salt
+	return b
+}
+
+// Meta adds a metadata entry to the hash
+func (b *HashBuilder) Meta(key, value string) *HashBuilder {
+	if b.meta == nil {
+		b.meta = make(map[string]string)
+	}
+	b.meta[key] = value
+	return b
+}
+
+// Session records the cracking session or job the hash belongs to
+func (b *HashBuilder) Session(session string) *HashBuilder {
+	b.session = session
+	return b
+}
+
+// Binary sets the raw bytes of a binary hash format
+func (b *HashBuilder) Binary(raw []byte) *HashBuilder {
+	b.binary = append([]byte(nil), raw...)
+	return b
+}
+
+// Source records the attack that cracked the hash
+func (b *HashBuilder) Source(source Source) *HashBuilder {
+	b.source = &source
+	return b
+}
+
+// Strict enables strict validation: the type must be set explicitly and be a valid hashcat mode
+func (b *HashBuilder) Strict() *HashBuilder {
+	b.strict = true
+	return b
+}
+
+// Build validates the hash and returns it ready to be stored
+func (b *HashBuilder) Build() (*Hash, error) {
+	hash := b.hash
+	if hash == "" && len(b.binary) > 0 {
+		hash = hex.EncodeToString(b.binary)
+	}
+
+	if hash == "" {
+		return nil, ErrEmptyHash
+	}
+
+	if b.strict {
+		if !b.typeSet {
+			return nil, fmt.Errorf("%w: hash type must be set under strict validation", ErrInvalidHashType)
+		}
+		if b.hashType > maxHashcatMode {
+			return nil, fmt.Errorf("%w: %d is not a hashcat mode (0 - %d)", ErrInvalidHashType, b.hashType, maxHashcatMode)
+		}
+	}
+
+	if err := validateMeta(b.meta); err != nil {
+		return nil, err
+	}
+
+	sh := &Hash{
+		Hash:      submittedHash(hash, b.hashType),
+		Value:     b.value,
+		HashType:  b.hashType,
+		CreatedAt: time.Now().UTC(),
+		Salt:      b.salt,
+		Session:   b.session,
+		Binary:    b.binary,
+		Source:    b.source,
+		db:        b.db,
+	}
+	if len(b.meta) > 0 {
+		sh.Meta = make(map[string]string, len(b.meta))
+		for k, v := range b.meta {
+			sh.Meta[k] = v
+		}
+	}
+
+	sh.generateKey()
+	return sh, nil
+}
+
+// validateMeta checks metadata against the size limits
+func validateMeta(meta map[string]string) error {
+	if len(meta) > maxMetaEntries {
+		return fmt.Errorf("%w: %d entries, at most %d allowed", ErrMetadataTooLarge, len(meta), maxMetaEntries)
+	}
+
+	for k, v := range meta {
+		if len(k) > maxMetaKeySize {
+			return fmt.Errorf("%w: key '%.32s...' is %d bytes, at most %d allowed", ErrMetadataTooLarge, k, len(k), maxMetaKeySize)
+		}
+		if len(v) > maxMetaValueSize {
+			return fmt.Errorf("%w: value of '%s' is %d bytes, at most %d allowed", ErrMetadataTooLarge, k, len(v), maxMetaValueSize)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package kdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// bulkChunkSize is how many hashes BulkLookup reads before looking them up
+const bulkChunkSize = 10000
+
+// BulkLookupSummary summarizes a BulkLookup
+type BulkLookupSummary struct {
+	Lines          int     `json:"lines"`           // Non-blank lines read
+	Found          int     `json:"found"`           // Hashes stored with a value
+	Left           int     `json:"left"`            // Hashes unknown or stored without a value
+	Invalid        int     `json:"invalid"`         // Lines too long to be a hash
+	PercentCracked float64 `json:"percent_cracked"` // Found out of the valid lines
+}
+
+// BulkLookup reads a hash list from r, one hash per line, and sorts it into founds written as
+// hash:plain (plaintexts as $HEX[...] where needed) and a left list of the hashes without a value.
+// Input and output are streamed in chunks, neither is held in memory whole. Lines longer than
+// Options.MaxHashSize are counted as invalid and skipped
+func (kc *KDB) BulkLookup(r io.Reader, hashType uint64, founds, left io.Writer) (BulkLookupSummary, error) {
+	var summary BulkLookupSummary
+
+	foundsW, leftW := bufio.NewWriter(founds), bufio.NewWriter(left)
+	maxHash := kc.maxHashSize(hashType)
+
+	chunk := make([]string, 0, bulkChunkSize)
+	flush := func() error {
+		values, err := kc.lookupValues(chunk, hashType)
+		if err != nil {
+			return err
+		}
+
+		for i, hash := range chunk {
+			if values[i] == "" {
+				summary.Left++
+				_, err = leftW.WriteString(hash + "\n")
+			} else {
+				summary.Found++
+				_, err = foundsW.WriteString(hash + ":" + util.EncodeHexPlain(values[i]) + "\n")
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		chunk = chunk[:0]
+		return nil
+	}
+
+	err := scanLines(r, func(_ int, text string) error {
+		hash := strings.TrimSpace(text)
+		if hash == "" {
+			return nil
+		}
+
+		summary.Lines++
+		if len(hash) > maxHash {
+			summary.Invalid++
+			return nil
+		}
+
+		chunk = append(chunk, hash)
+		if len(chunk) >= bulkChunkSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err == nil {
+		err = foundsW.Flush()
+	}
+	if err == nil {
+		err = leftW.Flush()
+	}
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed bulk lookup: %v", err), Error)
+		return summary, fmt.Errorf("failed bulk lookup: %w", err)
+	}
+
+	if valid := summary.Lines - summary.Invalid; valid > 0 {
+		summary.PercentCracked = float64(summary.Found) / float64(valid) * 100
+	}
+	return summary, nil
+}
+
+// lookupValues returns the stored value of every hash, empty for hashes that aren't stored,
+// using direct lookups in one transaction
+func (kc *KDB) lookupValues(hashes []string, hashType uint64) ([]string, error) {
+	values := make([]string, len(hashes))
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	err := kc.c.View(func(txn *badger.Txn) error {
+		for i, hash := range hashes {
+			key, err := kc.originalHashKey(txn, hash, hashType)
+			if isNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			item, err := txn.Get(key)
+			if err != nil {
+				return err
+			}
+
+			var stored Hash
+			if err := item.Value(func(val []byte) error {
+				return kc.decodeHash(val, &stored)
+			}); err != nil {
+				return err
+			}
+			values[i] = stored.Value
+		}
+		return nil
+	})
+
+	return values, err
+}
@@ -0,0 +1,257 @@
+package kdb
+
+import (
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Hashcat modes of the challenge-response formats
+const (
+	NetNTLMv1 = 5500 // user::domain:lm response:nt response:server challenge
+	NetNTLMv2 = 5600 // user::domain:server challenge:nt proof:blob
+)
+
+const defaultChallengeCaptures = 5
+
+// ChallengeKeying selects what stored challenge-response captures are keyed on. Every capture
+// of an account differs since the challenges do, keyed on the account they share one record
+type ChallengeKeying int
+
+const (
+	ChallengeKeyAccount ChallengeKeying = iota // User and domain, see NormalizeUser
+	ChallengeKeyUser                           // User only, for captures naming the domain inconsistently
+	ChallengeKeyCapture                        // Every capture on its own, as stored before accounts were keyed
+)
+
+// ChallengeResponse is a parsed NetNTLMv1 or NetNTLMv2 capture. Hex fields are lowercase
+type ChallengeResponse struct {
+	HashType   uint64
+	User       string
+	Domain     string
+	Challenge  string // Server challenge
+	NTResponse string // NT response, the NTProofStr for NetNTLMv2
+	LMResponse string // LM response, NetNTLMv1 only
+	Blob       string // Client blob, NetNTLMv2 only
+}
+
+// Account returns the account of the capture in the form of NormalizeUser
+func (cr *ChallengeResponse) Account() string {
+	return NormalizeUser(cr.User, cr.Domain)
+}
+
+// IsChallengeResponse returns true for the hash types ParseChallengeResponse parses
+func IsChallengeResponse(hashType uint64) bool {
+	return hashType == NetNTLMv1 || hashType == NetNTLMv2
+}
+
+// ParseChallengeResponse parses a capture in hashcat's format for hashType, NetNTLMv1 or
+// NetNTLMv2. Returns ErrInvalidHashType for other types and ErrInvalidHash for a capture that
+// doesn't have the fields of its type
+func ParseChallengeResponse(capture string, hashType uint64) (*ChallengeResponse, error) {
+	if !IsChallengeResponse(hashType) {
+		return nil, fmt.Errorf("%w: %d is not a challenge-response mode", ErrInvalidHashType, hashType)
+	}
+
+	fields := strings.Split(strings.TrimSpace(capture), ":")
+	if len(fields) != 6 || fields[1] != "" || fields[0] == "" {
+		return nil, fmt.Errorf("%w: expected user::domain followed by three fields", ErrInvalidHash)
+	}
+	for i := 3; i < 6; i++ {
+		fields[i] = strings.ToLower(fields[i])
+		if _, err := hex.DecodeString(fields[i]); err != nil || fields[i] == "" {
+			return nil, fmt.Errorf("%w: field %d is not hex", ErrInvalidHash, i+1)
+		}
+	}
+
+	cr := &ChallengeResponse{HashType: hashType, User: fields[0], Domain: fields[2]}
+	if hashType == NetNTLMv1 {
+		cr.LMResponse, cr.NTResponse, cr.Challenge = fields[3], fields[4], fields[5]
+		if len(cr.LMResponse) != 48 || len(cr.NTResponse) != 48 || len(cr.Challenge) != 16 {
+			return nil, fmt.Errorf("%w: NetNTLMv1 responses are 24 bytes and the challenge 8", ErrInvalidHash)
+		}
+		return cr, nil
+	}
+
+	cr.Challenge, cr.NTResponse, cr.Blob = fields[3], fields[4], fields[5]
+	if len(cr.Challenge) != 16 || len(cr.NTResponse) != 32 {
+		return nil, fmt.Errorf("%w: NetNTLMv2 challenges are 8 bytes and the NT proof 16", ErrInvalidHash)
+	}
+	return cr, nil
+}
+
+// challengeKeying returns Options.ChallengeKeying
+func (kc *KDB) challengeKeying() ChallengeKeying {
+	if kc.opts == nil {
+		return ChallengeKeyAccount
+	}
+	return kc.opts.ChallengeKeying
+}
+
+// challengeCaptures returns how many captures an account record keeps, Options.ChallengeCaptures
+func (kc *KDB) challengeCaptures() int {
+	if kc.opts == nil || kc.opts.ChallengeCaptures <= 0 {
+		return defaultChallengeCaptures
+	}
+	return kc.opts.ChallengeCaptures
+}
+
+// parseCapture returns the parsed capture if hash is one of hashType, nil otherwise
+func parseCapture(hash string, hashType uint64) *ChallengeResponse {
+	if !IsChallengeResponse(hashType) {
+		return nil
+	}
+	cr, err := ParseChallengeResponse(hash, hashType)
+	if err != nil {
+		return nil
+	}
+	return cr
+}
+
+// accountKeyed returns the parsed capture if hash is stored keyed on its account, nil for other
+// types, strings that don't parse and ChallengeKeyCapture
+func (kc *KDB) accountKeyed(hash string, hashType uint64) *ChallengeResponse {
+	if kc.challengeKeying() == ChallengeKeyCapture {
+		return nil
+	}
+	return parseCapture(hash, hashType)
+}
+
+// accountSum returns the sum an account record of cr is keyed on. The key material can't be a
+// capture, so account records never collide with captures stored on their own
+func (kc *KDB) accountSum(cr *ChallengeResponse) []byte {
+	name, domain := splitUser(cr.User, cr.Domain)
+	if kc.challengeKeying() == ChallengeKeyUser {
+		return util.SHA256Sum("\x00user\x00" + name)
+	}
+	return util.SHA256Sum("\x00account\x00" + name + "\x00" + domain)
+}
+
+// bindHash keys sh in this database. Challenge-response captures are keyed on their account and
+// Kerberos tickets on their checksum and encrypted part, both get user and domain meta entries,
+// unless they have a user entry already, so GetHashesByUser finds them. Everything else is keyed
+// on its hash and salt
+func (kc *KDB) bindHash(sh *Hash) {
+	if sh.Salt == "" {
+		canonical := sh.Canonical()
+		if cr := kc.accountKeyed(canonical, sh.HashType); cr != nil {
+			sh.sum = rawSum(kc.accountSum(cr))
+			setAccountMeta(sh, cr.User, cr.Domain)
+		} else if kt := parseTicket(canonical, sh.HashType); kt != nil {
+			sh.sum = rawSum(ticketSum(kt))
+			setAccountMeta(sh, kt.User, kt.Realm)
+		}
+	}
+	kc.keys.bindKey(sh)
+}
+
+// setAccountMeta sets the user and domain meta entries of sh unless it has a user entry or no room
+func setAccountMeta(sh *Hash, user, domain string) {
+	if user == "" || sh.Meta[UserMetaKey] != "" || len(sh.Meta)+2 > maxMetaEntries {
+		return
+	}
+	if sh.Meta == nil {
+		sh.Meta = make(map[string]string, 2)
+	}
+	sh.Meta[UserMetaKey] = user
+	sh.Meta[DomainMetaKey] = domain
+}
+
+// lookupSums returns the sums an unsalted hash may be stored under, the one it is stored under
+// now first. Captures have two, their account sum and the sum of the capture itself, for captures
+// stored before accounts were keyed or with ChallengeKeyCapture. Tickets have their ticket sum and
+// the sum of the ticket as written, for tickets stored before they were canonicalized
+func (kc *KDB) lookupSums(originalHash string, hashType uint64) []string {
+	normalized := normalizeHash(originalHash)
+	sum := string(util.SHA256Sum(normalized))
+	if kt := parseTicket(normalized, hashType); kt != nil {
+		return []string{string(ticketSum(kt)), sum}
+	}
+	cr := parseCapture(normalized, hashType)
+	switch {
+	case cr == nil:
+		return []string{sum}
+	case kc.challengeKeying() == ChallengeKeyCapture:
+		return []string{sum, string(kc.accountSum(cr))}
+	default:
+		return []string{string(kc.accountSum(cr)), sum}
+	}
+}
+
+// originalHashKey returns the key an unsalted hash is stored under in txn, the first of its
+// lookupSums that exists. A capture is only stored under an account record that holds it, see
+// holdsCapture. Returns badger.ErrKeyNotFound if the hash isn't stored
+func (kc *KDB) originalHashKey(txn *badger.Txn, originalHash string, hashType uint64) ([]byte, error) {
+	normalized := normalizeHash(originalHash)
+	capture := parseCapture(normalized, hashType) != nil
+	for _, sum := range kc.lookupSums(originalHash, hashType) {
+		key := kc.keys.hashKey(hashType, sum)
+		if capture {
+			stored, err := kc.storedRecord(txn, key)
+			if err != nil {
+				return nil, err
+			}
+			if stored != nil && holdsCapture(stored, normalized) {
+				return key, nil
+			}
+			continue
+		}
+
+		_, err := txn.Get(key)
+		if err == nil {
+			return key, nil
+		}
+		if !isNotFound(err) {
+			return nil, err
+		}
+	}
+	return nil, badger.ErrKeyNotFound
+}
+
+// holdsCapture returns true if stored holds capture, normalized, as its Hash or one of its
+// Captures. Every capture of an account shares the account record, a capture it doesn't hold was
+// never stored or has been pushed out by newer ones
+func holdsCapture(stored *Hash, capture string) bool {
+	if normalizeHash(stored.Hash) == capture {
+		return true
+	}
+	return slices.ContainsFunc(stored.Captures, func(c string) bool {
+		return normalizeHash(c) == capture
+	})
+}
+
+// mergeCaptures folds the account record stored into sh, a newer capture of the same account
+// about to replace it: sh keeps the most recent Options.ChallengeCaptures captures, its own first,
+// and the stored value, crack time and source if it has no value of its own
+func (kc *KDB) mergeCaptures(sh, stored *Hash) {
+	if stored == nil || kc.accountKeyed(sh.Canonical(), sh.HashType) == nil {
+		return
+	}
+
+	limit := kc.challengeCaptures() - 1
+	seen := map[string]bool{sh.Canonical(): true}
+	var captures []string
+	for _, capture := range slices.Concat(sh.Captures, []string{stored.Hash}, stored.Captures) {
+		if len(captures) >= limit {
+			break
+		}
+		if capture == "" || seen[normalizeHash(capture)] {
+			continue
+		}
+		seen[normalizeHash(capture)] = true
+		captures = append(captures, capture)
+	}
+	sh.Captures = captures
+
+	if sh.Value == "" && stored.Value != "" {
+		sh.Value = stored.Value
+		sh.CrackedAt = stored.CrackedAt
+		if sh.Source == nil {
+			sh.Source = stored.Source
+		}
+	}
+}
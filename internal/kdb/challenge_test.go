@@ -0,0 +1,85 @@
+package kdb
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// testCapture returns the i-th NetNTLMv2 capture of alice, every one with another challenge
+func testCapture(i int) string {
+	return fmt.Sprintf("alice::CORP:%016x:%032x:0101000000000000", i, i)
+}
+
+func TestCaptureLookupsNeedTheCapture(t *testing.T) {
+	db := newTestDB(t, testPrefix, func(opts *Options) { opts.ChallengeCaptures = 2 })
+
+	// The record keeps the last two captures, the first one is pushed out
+	for i := range 3 {
+		if _, err := db.StoreHash(NewHash(testCapture(i), "", NetNTLMv2)); err != nil {
+			t.Fatalf("StoreHash: %v", err)
+		}
+	}
+	stored := []bool{false, true, true, false}
+
+	ctx := context.Background()
+	many, err := db.ExistsMany(ctx, []string{testCapture(0), testCapture(1), testCapture(2), testCapture(3)}, NetNTLMv2)
+	if err != nil || !slices.Equal(many, stored) {
+		t.Errorf("ExistsMany returned %v, want %v: %v", many, stored, err)
+	}
+	for i, want := range stored {
+		if ok, err := db.Exists(testCapture(i), NetNTLMv2); ok != want || err != nil {
+			t.Errorf("Exists of capture %d returned %v: %v", i, ok, err)
+		}
+		if _, err := db.GetHashByOriginalHash(testCapture(i), NetNTLMv2); (err == nil) != want {
+			t.Errorf("GetHashByOriginalHash of capture %d returned %v", i, err)
+		}
+	}
+
+	for _, keysOnly := range []bool{false, true} {
+		so := &ScanOptions{KeysOnly: keysOnly}
+		n := 0
+		for range db.FindHashes([]string{testCapture(0), testCapture(3)}, NetNTLMv2, so) {
+			n++
+		}
+		if n != 0 {
+			t.Errorf("FindHashes (keys only %v) found %d captures that aren't stored", keysOnly, n)
+		}
+		n = 0
+		for range db.FindHashes([]string{testCapture(1), testCapture(3)}, NetNTLMv2, so) {
+			n++
+		}
+		if n != 1 {
+			t.Errorf("FindHashes (keys only %v) found the account %d times", keysOnly, n)
+		}
+	}
+
+	var indexes []int
+	for m, err := range db.FindHashesChunked(ctx, []string{testCapture(3), testCapture(1), testCapture(2)}, NetNTLMv2, nil) {
+		if err != nil {
+			t.Fatalf("FindHashesChunked: %v", err)
+		}
+		indexes = append(indexes, m.Index)
+	}
+	slices.Sort(indexes)
+	if !slices.Equal(indexes, []int{1, 2}) {
+		t.Errorf("FindHashesChunked matched inputs %v", indexes)
+	}
+
+	if err := db.DeleteHash(testCapture(3), NetNTLMv2); !isNotFound(err) {
+		t.Errorf("deleting a capture that isn't stored returned %v", err)
+	}
+
+	// Cracking a capture the record doesn't hold adds it to the record
+	if err := db.MarkCracked(testCapture(3), NetNTLMv2, "Summer2024"); err != nil {
+		t.Fatalf("MarkCracked: %v", err)
+	}
+	got, err := db.GetHashByOriginalHash(testCapture(3), NetNTLMv2)
+	if err != nil || got.Value != "Summer2024" {
+		t.Errorf("the cracked capture %+v: %v", got, err)
+	}
+	if n, _ := db.HashesByType(NetNTLMv2); n != 1 {
+		t.Errorf("%d account records, want 1", n)
+	}
+}
@@ -0,0 +1,135 @@
+package kdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	flagCompressed      byte = 0x80                // Set on the format tag of a record whose payload is zstd compressed
+	compressionSavedKey      = "compression_saved" // Counter of the bytes saved by value compression
+	maxRecordSize            = 64 << 20            // Largest payload a compressed record may expand to
+)
+
+var (
+	zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return enc
+	})
+	zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+		dec, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxRecordSize))
+		return dec
+	})
+)
+
+// compressRecord compresses the payload of an encoded record larger than threshold bytes and flags
+// its format tag. Records that don't get smaller are returned as they are. Returns the bytes saved
+func compressRecord(data []byte, threshold int) ([]byte, int) {
+	if threshold <= 0 || len(data) <= threshold || data[0] == formatLegacy {
+		return data, 0
+	}
+
+	compressed := make([]byte, 1, len(data))
+	compressed[0] = data[0] | flagCompressed
+	compressed = zstdEncoder().EncodeAll(data[1:], compressed)
+	if len(compressed) >= len(data) {
+		return data, 0
+	}
+	return compressed, len(data) - len(compressed)
+}
+
+// decompressRecord undoes compressRecord
+func decompressRecord(data []byte) ([]byte, error) {
+	record := []byte{data[0] &^ flagCompressed}
+	record, err := zstdDecoder().DecodeAll(data[1:], record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress hash record: %w", err)
+	}
+	return record, nil
+}
+
+// encodeRecord serializes a hash for storage in this database, applying value normalization,
+// Options.ValueEncoder, interning and compression. The caller's hash is left unchanged. Returns the
+// bytes saved by compression
+func (kc *KDB) encodeRecord(sh *Hash) ([]byte, int, error) {
+	encoded := sh
+	value, err := kc.encodeValue(kc.normalizeValue(sh.Value), sh)
+	if err != nil {
+		return nil, 0, err
+	}
+	if value != sh.Value {
+		normalized := *sh
+		normalized.Value = value
+		encoded = &normalized
+	}
+
+	data, err := encodeHash(kc.internHash(encoded))
+	if err != nil {
+		return nil, 0, err
+	}
+	if kc.opts == nil {
+		return data, 0, nil
+	}
+
+	data, saved := compressRecord(data, kc.opts.CompressValueThreshold)
+	return data, saved, nil
+}
+
+// recordCompressionSaved adds to the bytes saved counter
+func (kc *KDB) recordCompressionSaved(saved int) {
+	if saved == 0 {
+		return
+	}
+	if err := kc.updateCount(kc.keys.key(compressionSavedKey), saved); err != nil {
+		kc.log(fmt.Sprintf("failed to update compression counter: %v", err), Error)
+	}
+}
+
+// RecompressValues rewrites every stored record whose stored form differs from what the current
+// options would write: records above Options.CompressValueThreshold get compressed, compressed ones
+// below it or with compression disabled get expanded, and records in older formats are upgraded.
+// The bytes saved counter reported by Stats is recomputed exactly, so an interrupted run starts
+// over rather than resuming. Returns the number of hashes rewritten
+func (kc *KDB) RecompressValues(ctx context.Context) (int, error) {
+	var rewritten, saved atomic.Int64
+	_, err := kc.RunMigration(ctx, "recompress", func(ctx context.Context, batch []*Hash) ([]MigrationWrite, error) {
+		var writes []MigrationWrite
+		for _, hash := range batch {
+			// Normalization is left to NormalizeValues, only the stored form changes here
+			stored := *hash
+			value, err := kc.encodeValue(hash.Value, hash)
+			if err != nil {
+				return nil, err
+			}
+			stored.Value = value
+			data, err := encodeHash(kc.internHash(&stored))
+			if err != nil {
+				return nil, err
+			}
+			data, s := compressRecord(data, kc.opts.CompressValueThreshold)
+			saved.Add(int64(s))
+
+			if bytes.Equal(data, hash.record) {
+				continue
+			}
+			writes = append(writes, MigrationWrite{Key: hash.Key, Value: data})
+			rewritten.Add(1)
+		}
+		return writes, nil
+	}, &MigrationOptions{Restart: true})
+	if err != nil {
+		return int(rewritten.Load()), fmt.Errorf("failed to recompress values: %w", err)
+	}
+
+	if err := kc.setCount(kc.keys.key(compressionSavedKey), int(saved.Load())); err != nil {
+		return int(rewritten.Load()), fmt.Errorf("failed to update compression counter: %w", err)
+	}
+
+	kc.log(fmt.Sprintf("Recompressed %d values, %d bytes saved", rewritten.Load(), saved.Load()), Info)
+	return int(rewritten.Load()), nil
+}
@@ -1,6 +1,7 @@
 package kdb
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 
@@ -11,32 +12,33 @@ import (
 // This is useful if counters get out of sync or corrupted
 // Uses the hash type registry for efficient iteration
 func (kc *KDB) PerformRecount() error {
-	logger("Starting full recount of all hash types", Info)
+	kc.log("Starting full recount of all hash types", Info)
+	kc.ops.recounts.Add(1)
 
 	// Get all registered hash types
 	hashTypes, err := kc.getRegisteredHashTypes()
 	if err != nil {
-		logger(fmt.Sprintf("Failed to get registered hash types: %v", err), Error)
+		kc.log(fmt.Sprintf("Failed to get registered hash types: %v", err), Error)
 		return fmt.Errorf("failed to get registered hash types: %w", err)
 	}
 
 	if len(hashTypes) == 0 {
-		logger("No hash types registered, nothing to recount", Info)
+		kc.log("No hash types registered, nothing to recount", Info)
 		// Set total to 0
-		if err := kc.setCount(totalHashesKey, 0); err != nil {
+		if err := kc.recordShardedCount(kc.keys.key(totalHashesKey), 0); err != nil {
 			return fmt.Errorf("failed to update total hash count: %w", err)
 		}
 		return nil
 	}
 
-	logger(fmt.Sprintf("Found %d registered hash types", len(hashTypes)), Info)
+	kc.log(fmt.Sprintf("Found %d registered hash types", len(hashTypes)), Info)
 
 	totalCount := 0
 
 	// Recount each registered hash type
 	for _, hashType := range hashTypes {
 		count := 0
-		prefix := []byte(fmt.Sprintf(hashTypeLookupPrefix, hashType))
+		prefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
 
 		// Count all hashes with this hash type prefix
 		err := kc.c.View(func(txn *badger.Txn) error {
@@ -44,7 +46,7 @@ func (kc *KDB) PerformRecount() error {
 			opts.PrefetchValues = false // We only need to count keys
 			opts.Prefix = prefix
 
-			it := txn.NewIterator(opts)
+			it := kc.newIterator(txn, opts)
 			defer it.Close()
 
 			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
@@ -55,37 +57,37 @@ func (kc *KDB) PerformRecount() error {
 		})
 
 		if err != nil {
-			logger(fmt.Sprintf("Failed to count hash type %d: %v", hashType, err), Error)
+			kc.log(fmt.Sprintf("Failed to count hash type %d: %v", hashType, err), Error)
 			return fmt.Errorf("failed to count hash type %d: %w", hashType, err)
 		}
 
 		// Update the counter for this hash type
-		countKey := fmt.Sprintf(hashTypeCountPrefix, hashType)
-		if err := kc.setCount(countKey, count); err != nil {
-			logger(fmt.Sprintf("Failed to update count for hash type %d: %v", hashType, err), Error)
+		if err := kc.recordTypeCount(hashType, count); err != nil {
+			kc.log(fmt.Sprintf("Failed to update count for hash type %d: %v", hashType, err), Error)
 			return fmt.Errorf("failed to update count for hash type %d: %w", hashType, err)
 		}
 
-		logger(fmt.Sprintf("Updated hash type %d: %d hashes", hashType, count), Info)
+		kc.log(fmt.Sprintf("Updated hash type %d: %d hashes", hashType, count), Info)
 		totalCount += count
 	}
 
 	// Update the total hash count
-	if err := kc.setCount(totalHashesKey, totalCount); err != nil {
-		logger(fmt.Sprintf("Failed to update total hash count: %v", err), Error)
+	if err := kc.recordShardedCount(kc.keys.key(totalHashesKey), totalCount); err != nil {
+		kc.log(fmt.Sprintf("Failed to update total hash count: %v", err), Error)
 		return fmt.Errorf("failed to update total hash count: %w", err)
 	}
 
-	logger(fmt.Sprintf("Recount completed successfully: %d total hashes across %d hash types", totalCount, len(hashTypes)), Info)
+	kc.log(fmt.Sprintf("Recount completed successfully: %d total hashes across %d hash types", totalCount, len(hashTypes)), Info)
 	return nil
 }
 
 // RecountHashType recounts hashes for a specific hash type and updates its counter
 func (kc *KDB) RecountHashType(hashType uint64) error {
-	logger(fmt.Sprintf("Starting recount for hash type %d", hashType), Info)
+	kc.log(fmt.Sprintf("Starting recount for hash type %d", hashType), Info)
+	kc.ops.recounts.Add(1)
 
 	count := 0
-	prefix := []byte(fmt.Sprintf(hashTypeLookupPrefix, hashType))
+	prefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
 
 	// Count all hashes with this hash type prefix
 	err := kc.c.View(func(txn *badger.Txn) error {
@@ -93,7 +95,7 @@ func (kc *KDB) RecountHashType(hashType uint64) error {
 		opts.PrefetchValues = false // We only need to count keys
 		opts.Prefix = prefix
 
-		it := txn.NewIterator(opts)
+		it := kc.newIterator(txn, opts)
 		defer it.Close()
 
 		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
@@ -104,30 +106,130 @@ func (kc *KDB) RecountHashType(hashType uint64) error {
 	})
 
 	if err != nil {
-		logger(fmt.Sprintf("Failed to count hash type %d: %v", hashType, err), Error)
+		kc.log(fmt.Sprintf("Failed to count hash type %d: %v", hashType, err), Error)
 		return fmt.Errorf("failed to count hash type %d: %w", hashType, err)
 	}
 
 	// Update the counter for this hash type
-	countKey := fmt.Sprintf(hashTypeCountPrefix, hashType)
-	if err := kc.setCount(countKey, count); err != nil {
-		logger(fmt.Sprintf("Failed to update count for hash type %d: %v", hashType, err), Error)
+	if err := kc.recordTypeCount(hashType, count); err != nil {
+		kc.log(fmt.Sprintf("Failed to update count for hash type %d: %v", hashType, err), Error)
 		return fmt.Errorf("failed to update count for hash type %d: %w", hashType, err)
 	}
 
-	logger(fmt.Sprintf("Recount for hash type %d completed: %d hashes", hashType, count), Info)
+	kc.log(fmt.Sprintf("Recount for hash type %d completed: %d hashes", hashType, count), Info)
 	return nil
 }
 
+// recountTypeCounters is the migration to schema version 3. Version 1 wrote the count of a type
+// under hashTypeLookupPrefix while HashesByType read hashTypeCountPrefix, so every type is
+// recounted into the counters read and the stale keys are deleted. Safe to run again
+func recountTypeCounters(kc *KDB) error {
+	if err := kc.PerformRecount(); err != nil {
+		return err
+	}
+	hashTypes, err := kc.getRegisteredHashTypes()
+	if err != nil {
+		return fmt.Errorf("failed to get registered hash types: %w", err)
+	}
+	return kc.c.Update(func(txn *badger.Txn) error {
+		for _, hashType := range hashTypes {
+			if err := txn.Delete([]byte(kc.keys.key(hashTypeLookupPrefix, hashType))); err != nil {
+				return fmt.Errorf("failed to delete the stale counter of hash type %d: %w", hashType, err)
+			}
+		}
+		return nil
+	})
+}
+
 // setCount sets a counter to a specific value (used by recount operations)
 func (kc *KDB) setCount(key string, count int) error {
-	kc.mu.Lock()
-	defer kc.mu.Unlock()
+	return kc.retryConflicts("counter update", func() error {
+		kc.mu.Lock()
+		defer kc.mu.Unlock()
+
+		return kc.c.Update(func(txn *badger.Txn) error {
+			// Store as binary uint64 (8 bytes)
+			buf := make([]byte, 8)
+			binary.BigEndian.PutUint64(buf, uint64(count))
+			return txn.Set([]byte(key), buf)
+		})
+	})
+}
 
-	return kc.c.Update(func(txn *badger.Txn) error {
-		// Store as binary uint64 (8 bytes)
-		buf := make([]byte, 8)
-		binary.BigEndian.PutUint64(buf, uint64(count))
-		return txn.Set([]byte(key), buf)
+// countProgressInterval is how many keys CountWhere scans between progress callbacks
+const countProgressInterval = 10000
+
+// CountOptions selects the hashes CountWhere counts. The zero value counts every hash of the type
+type CountOptions struct {
+	SumPrefix      string            // Only hashes whose hex sum starts with this, checked on the key
+	ValuePredicate func(string) bool // Only hashes whose value passes, reads every value
+	CrackedOnly    bool              // Only hashes with a value, reads every value
+	// Progress is called every 10000 keys and once at the end with the keys scanned and counted so
+	// far. It runs while the database is locked and must not call back into it
+	Progress func(scanned, matched uint64)
+}
+
+// needsValues returns true if counting needs the stored values and not just the keys
+func (co CountOptions) needsValues() bool {
+	return co.ValuePredicate != nil || co.CrackedOnly
+}
+
+// CountWhere counts the hashes of a hash type that match opts without materializing them.
+// Keys are iterated without reading values unless a value filter is set. A cancelled ctx stops
+// the count and returns what was counted so far with the context's error
+func (kc *KDB) CountWhere(ctx context.Context, hashType uint64, opts CountOptions) (uint64, error) {
+	prefix := []byte(kc.keys.key(storedHashPrefix, hashType, opts.SumPrefix))
+	var scanned, matched uint64
+
+	err := kc.viewLocked(func(txn *badger.Txn) error {
+		iterOpts := badger.DefaultIteratorOptions
+		iterOpts.Prefix = prefix
+		iterOpts.PrefetchValues = opts.needsValues()
+
+		it := kc.newIterator(txn, iterOpts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if scanned%countProgressInterval == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if opts.Progress != nil && scanned > 0 {
+					opts.Progress(scanned, matched)
+				}
+			}
+			scanned++
+
+			if !opts.needsValues() {
+				matched++
+				continue
+			}
+
+			var hash Hash
+			if err := it.Item().Value(func(val []byte) error {
+				return kc.decodeHash(val, &hash)
+			}); err != nil {
+				continue
+			}
+			if opts.CrackedOnly && hash.Value == "" {
+				continue
+			}
+			if opts.ValuePredicate != nil && !opts.ValuePredicate(hash.Value) {
+				continue
+			}
+			matched++
+		}
+		return nil
 	})
+
+	if opts.Progress != nil {
+		opts.Progress(scanned, matched)
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			kc.log(fmt.Sprintf("Failed to count hash type %d: %v", hashType, err), Error)
+		}
+		return matched, fmt.Errorf("failed to count hash type %d: %w", hashType, err)
+	}
+	return matched, nil
 }
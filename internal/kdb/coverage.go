@@ -0,0 +1,233 @@
+package kdb
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const coverageRecentCracks = 10 // Most recent cracks listed per hash type in a CoverageReport
+
+// TypeCoverage is how much of a hash type is cracked
+type TypeCoverage struct {
+	HashType uint64  `json:"hash_type"`
+	Cracked  int     `json:"cracked"` // Hashes with a value
+	Total    int     `json:"total"`   // Hashes stored, cracked or not
+	Percent  float64 `json:"percent"` // Cracked out of total, 0 for an empty type
+
+	// Cracks in the last day and week, and the most recent ones, newest first. Only filled in by
+	// CoverageReport and only covering hashes with a CrackedAt
+	Last24h int           `json:"last_24h"`
+	Last7d  int           `json:"last_7d"`
+	Recent  []RecentCrack `json:"recent,omitempty"`
+}
+
+// RecentCrack is a crack listed in a CoverageReport
+type RecentCrack struct {
+	Hash      string    `json:"hash"`
+	Value     string    `json:"value"`
+	CrackedAt time.Time `json:"cracked_at"`
+}
+
+// CoverageReport summarizes the coverage of several hash types at one point in time
+type CoverageReport struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Types       []TypeCoverage `json:"types"`
+	Cracked     int            `json:"cracked"` // Sums over the types
+	Total       int            `json:"total"`
+	Percent     float64        `json:"percent"`
+	Last24h     int            `json:"last_24h"`
+	Last7d      int            `json:"last_7d"`
+}
+
+// String renders the report as a table followed by the recent cracks of each type, for the CLI
+// and status updates
+func (r CoverageReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Coverage at %s\n", r.GeneratedAt.Format(time.DateTime+" MST"))
+	fmt.Fprintf(&b, "%-20s %21s %7s %7s %7s\n", "TYPE", "CRACKED/TOTAL", "%", "24H", "7D")
+
+	row := func(name string, cracked, total int, percent float64, last24h, last7d int) {
+		fmt.Fprintf(&b, "%-20s %21s %6.1f%% %7d %7d\n", name, fmt.Sprintf("%d/%d", cracked, total), percent, last24h, last7d)
+	}
+	for _, tc := range r.Types {
+		row(hashTypeLabel(tc.HashType), tc.Cracked, tc.Total, tc.Percent, tc.Last24h, tc.Last7d)
+	}
+	if len(r.Types) > 1 {
+		row("all", r.Cracked, r.Total, r.Percent, r.Last24h, r.Last7d)
+	}
+
+	for _, tc := range r.Types {
+		if len(tc.Recent) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\nRecent cracks of %s:\n", hashTypeLabel(tc.HashType))
+		for _, rc := range tc.Recent {
+			hash := rc.Hash
+			if len(hash) > 32 {
+				hash = hash[:32] + "…"
+			}
+			fmt.Fprintf(&b, "  %s  %s  %s\n", rc.CrackedAt.Format(time.DateTime), hash, rc.Value)
+		}
+	}
+	return b.String()
+}
+
+// hashTypeLabel names a hash type for reports, e.g. "md5 (0)"
+func hashTypeLabel(hashType uint64) string {
+	if name, ok := hashTypeNames[hashType]; ok {
+		return fmt.Sprintf("%s (%d)", name, hashType)
+	}
+	return fmt.Sprintf("%d", hashType)
+}
+
+// Coverage returns how many of the hashes of hashType are cracked, read from the counters.
+// Load the target's hash dump with ImportHashList first and the total is the whole dump
+func (kc *KDB) Coverage(hashType uint64) (TypeCoverage, error) {
+	tc := TypeCoverage{HashType: hashType}
+
+	cracked, err := kc.CrackedByType(hashType)
+	if err != nil {
+		return tc, err
+	}
+	total, err := kc.HashesByType(hashType)
+	if err != nil && !isNotFound(err) {
+		kc.log(fmt.Sprintf("Failed to read count for hash type %d: %v", hashType, err), Error)
+		return tc, fmt.Errorf("failed to read count for hash type %d: %w", hashType, err)
+	}
+
+	tc.setCounts(cracked, total)
+	return tc, nil
+}
+
+// setCounts fills in the counts and the percentage
+func (tc *TypeCoverage) setCounts(cracked, total int) {
+	tc.Cracked = cracked
+	tc.Total = max(total, cracked)
+	if tc.Total > 0 {
+		tc.Percent = float64(tc.Cracked) / float64(tc.Total) * 100
+	}
+}
+
+// CoverageReport summarizes the coverage of hashTypes, every registered type if empty: totals and
+// cracks from the counters, cracks of the last day and week and the ten most recent ones from the
+// crack time index. Everything is read in one transaction, so the numbers agree with each other
+// and with a potfile or left list exported at the same time. The index scans are bounded by the
+// cracks of the last week
+func (kc *KDB) CoverageReport(hashTypes []uint64) (CoverageReport, error) {
+	if err := kc.ensureCrackedCounts(); err != nil {
+		return CoverageReport{}, err
+	}
+
+	now := time.Now().UTC()
+	report := CoverageReport{GeneratedAt: now, Types: []TypeCoverage{}}
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	err := kc.c.View(func(txn *badger.Txn) error {
+		types := hashTypes
+		if len(types) == 0 {
+			var err error
+			if types, err = kc.readHashTypes(txn); err != nil {
+				return fmt.Errorf("failed to get registered hash types: %w", err)
+			}
+			types = slices.Sorted(slices.Values(types))
+		}
+
+		for _, hashType := range types {
+			tc, err := kc.typeCoverage(txn, hashType, now)
+			if err != nil {
+				return fmt.Errorf("hash type %d: %w", hashType, err)
+			}
+			report.Types = append(report.Types, tc)
+			report.Cracked += tc.Cracked
+			report.Total += tc.Total
+			report.Last24h += tc.Last24h
+			report.Last7d += tc.Last7d
+		}
+		return nil
+	})
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to build coverage report: %v", err), Error)
+		return report, fmt.Errorf("failed to build coverage report: %w", err)
+	}
+
+	if report.Total > 0 {
+		report.Percent = float64(report.Cracked) / float64(report.Total) * 100
+	}
+	return report, nil
+}
+
+// typeCoverage reads the coverage of one hash type in txn
+func (kc *KDB) typeCoverage(txn *badger.Txn, hashType uint64, now time.Time) (TypeCoverage, error) {
+	tc := TypeCoverage{HashType: hashType, Recent: []RecentCrack{}}
+
+	cracked, err := readCount(txn, kc.keys.key(crackedCountPrefix, hashType))
+	if err != nil && !isNotFound(err) {
+		return tc, err
+	}
+	total, err := kc.readTypeCount(txn, hashType)
+	if err != nil && !isNotFound(err) {
+		return tc, err
+	}
+	tc.setCounts(cracked, total)
+
+	dayAgo, weekAgo := now.Add(-24*time.Hour), now.Add(-7*24*time.Hour)
+	prefix := []byte(kc.keys.key(crackTimeScanPrefix, hashType))
+	err = kc.scanCrackTimes(txn, hashType, kc.keys.crackTimeKey(hashType, weekAgo, nil), false, func(hash *Hash) bool {
+		tc.Last7d++
+		if hash.CrackedAt.After(dayAgo) {
+			tc.Last24h++
+		}
+		return true
+	})
+	if err != nil {
+		return tc, err
+	}
+
+	err = kc.scanCrackTimes(txn, hashType, append(prefix, 0xff), true, func(hash *Hash) bool {
+		tc.Recent = append(tc.Recent, RecentCrack{Hash: hash.hashLine(), Value: hash.Value, CrackedAt: hash.CrackedAt})
+		return len(tc.Recent) < coverageRecentCracks
+	})
+	return tc, err
+}
+
+// scanCrackTimes yields the cracked hashes of hashType in crack time order from seek on, newest
+// first if reverse. Entries whose hash is gone or no longer has that crack time are skipped
+func (kc *KDB) scanCrackTimes(txn *badger.Txn, hashType uint64, seek []byte, reverse bool, yield func(*Hash) bool) error {
+	prefix := []byte(kc.keys.key(crackTimeScanPrefix, hashType))
+	typePrefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+	opts.Reverse = reverse
+
+	it := kc.newIterator(txn, opts)
+	defer it.Close()
+
+	for it.Seek(seek); it.ValidForPrefix(prefix); it.Next() {
+		// nanoseconds:sum
+		entry := it.Item().Key()[len(prefix):]
+		if len(entry) < 17 {
+			continue
+		}
+
+		stored, err := kc.storedRecord(txn, append(bytes.Clone(typePrefix), entry[17:]...))
+		if err != nil {
+			return err
+		}
+		if stored == nil || stored.Value == "" || !bytes.Equal(kc.keys.crackTimeKey(hashType, stored.CrackedAt, stored.sum), it.Item().Key()) {
+			continue
+		}
+		if !yield(stored) {
+			return nil
+		}
+	}
+	return nil
+}
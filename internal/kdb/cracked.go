@@ -0,0 +1,143 @@
+package kdb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	// crackedCountsMetaKey marks a database whose cracked counters are complete. Databases written
+	// before they existed get them counted on first use
+	crackedCountsMetaKey = "cracked_counts"
+
+	crackTimeIndexPrefix = "cracktime:%d:%016x:%s" // hash_type, Unix nanoseconds, hex sum
+	crackTimeScanPrefix  = "cracktime:%d:"         // hash_type, used to scan the cracks of a type in time order
+)
+
+// CrackedByType returns the number of hashes of a specific type that have a value
+func (kc *KDB) CrackedByType(hashType uint64) (int, error) {
+	if err := kc.ensureCrackedCounts(); err != nil {
+		return 0, err
+	}
+
+	count, err := kc.getCount(kc.keys.key(crackedCountPrefix, hashType))
+	if isNotFound(err) {
+		return 0, nil
+	}
+	return count, err
+}
+
+// ensureCrackedCounts counts the cracked hashes of every type once for databases written before
+// the cracked counters, holding the lock so no write moves a counter while it is counted
+func (kc *KDB) ensureCrackedCounts() error {
+	if _, err := kc.GetMeta(crackedCountsMetaKey); !isNotFound(err) {
+		return err
+	}
+
+	kc.log("Counting cracked hashes, the database predates the cracked counters", Info)
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	if err := kc.recountCracked(nil); err != nil {
+		kc.log(fmt.Sprintf("Failed to count cracked hashes: %v", err), Error)
+		return fmt.Errorf("failed to count cracked hashes: %w", err)
+	}
+	return nil
+}
+
+// RecountCracked recounts the cracked hashes of the given hash types, or of every registered type
+// if none are given, and updates their counters. Reads every value
+func (kc *KDB) RecountCracked(hashTypes ...uint64) error {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	if err := kc.recountCracked(hashTypes); err != nil {
+		kc.log(fmt.Sprintf("Failed to recount cracked hashes: %v", err), Error)
+		return fmt.Errorf("failed to recount cracked hashes: %w", err)
+	}
+	return nil
+}
+
+// recountCracked is RecountCracked with kc.mu held. Counting every registered type also marks
+// the counters complete
+func (kc *KDB) recountCracked(hashTypes []uint64) error {
+	counts := make(map[uint64]int)
+	all := len(hashTypes) == 0
+	err := kc.c.View(func(txn *badger.Txn) error {
+		if all {
+			var err error
+			if hashTypes, err = kc.readHashTypes(txn); err != nil {
+				return fmt.Errorf("failed to get registered hash types: %w", err)
+			}
+		}
+
+		for _, hashType := range hashTypes {
+			prefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = prefix
+
+			it := kc.newIterator(txn, opts)
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				var hash Hash
+				if err := it.Item().Value(func(val []byte) error {
+					return kc.decodeHash(val, &hash)
+				}); err == nil && hash.Value != "" {
+					counts[hashType]++
+				}
+			}
+			it.Close()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return kc.c.Update(func(txn *badger.Txn) error {
+		for _, hashType := range hashTypes {
+			if err := txn.Set([]byte(kc.keys.key(crackedCountPrefix, hashType)), countValue(counts[hashType])); err != nil {
+				return err
+			}
+		}
+		if !all {
+			return nil
+		}
+		return txn.Set([]byte(kc.keys.key(metaPrefix, crackedCountsMetaKey)), nil)
+	})
+}
+
+// crackTimeKey returns the key of the crack time index entry of a hash with sum, raw or hex encoded
+func (ks keyspace) crackTimeKey(hashType uint64, crackedAt time.Time, sum []byte) []byte {
+	return []byte(ks.key(crackTimeIndexPrefix, hashType, uint64(crackedAt.UnixNano()), hexSum(sum)))
+}
+
+// indexCrackTime sets when sh was cracked, for a hash about to be stored over stored, nil if it is
+// new, and updates its crack time index entry. A hash keeps the time it first got a value for as
+// long as it has one, a time set by the caller is kept for hashes that are newly cracked
+func (kc *KDB) indexCrackTime(w indexWriter, sh, stored *Hash, now time.Time) error {
+	var before time.Time
+	if stored != nil && stored.Value != "" {
+		before = stored.CrackedAt
+	}
+
+	switch {
+	case sh.Value == "":
+		sh.CrackedAt = time.Time{}
+	case stored != nil && stored.Value != "":
+		sh.CrackedAt = before
+	case sh.CrackedAt.IsZero():
+		sh.CrackedAt = now.UTC()
+	}
+
+	if !before.IsZero() && !before.Equal(sh.CrackedAt) {
+		if err := w.Delete(kc.keys.crackTimeKey(sh.HashType, before, sh.sum)); err != nil {
+			return err
+		}
+	}
+	if sh.CrackedAt.IsZero() {
+		return nil
+	}
+	return w.Set(kc.keys.crackTimeKey(sh.HashType, sh.CrackedAt, sh.sum), nil)
+}
@@ -1,50 +1,102 @@
 package kdb
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+	"github.com/KrakenTech-LLC/KrknDB/internal/util/keysource"
 	"github.com/dgraph-io/badger/v4"
 )
 
 var (
-	initOnce sync.Once
-	krkn     *KDB
-	logger   Logger
+	defaultMu sync.Mutex // guards krkn
+	krkn      *KDB       // the default database, the first one opened
+
+	// defaultLogger is the logger of the default database, used where no database is at hand
+	defaultLogger atomic.Pointer[Logger]
 )
 
+// Key formats, every key is built from one under the key prefix by keyspace.key
 const (
-	storedHashPrefix     = "krkn:%d:%v" // hash_type:stored_hash.Key
-	hashTypeLookupPrefix = "krkn:%d"    // hash_type
+	storedHashPrefix     = "%d:%v" // hash_type:stored_hash.Key
+	hashTypeLookupPrefix = "%d"    // hash_type, where schema version 1 kept the count of the type
+	hashTypeScanPrefix   = "%d:"   // hash_type, used to scan every stored hash of a type
 
 	// Counters
-	totalHashesKey      = "krkn:total_hashes"
-	hashTypeCountPrefix = "krkn:num:%d" // hash_type
+	totalHashesKey      = "total_hashes"
+	hashTypeCountPrefix = "num:%d"     // hash_type
+	crackedCountPrefix  = "cracked:%d" // hash_type, hashes with a value
 
 	// Registry
-	hashTypeRegistryKey = "krkn:registry:hash_types" // Stores map of all hash types
+	hashTypeRegistryKey = "registry:hash_types"    // Stores map of all hash types
+	firstSeenPrefix     = "registry:first_seen:%d" // hash_type, when it was registered
+
+	// Meta store
+	metaPrefix = "meta:%s" // meta key
 )
 
 // KDB represents the key-value database
 type KDB struct {
-	encryptionKey []byte
-	c             *badger.DB // badger database
-	mu            sync.Mutex // mutex for concurrent access
-	isNew         bool       // true if the krkn is new
-	absPath       string     // absolute path to the database file
-	parentFolder  string     // absolute path to the parent folder
-	logger        Logger
+	badgerKey    []byte     // badger's copy of the encryption key, wiped by Close
+	c            Engine     // badger database, wrapped by Options.WrapEngine
+	mu           sync.Mutex // mutex for concurrent access
+	isNew        bool       // true if the krkn is new
+	absPath      string     // absolute path to the database file
+	parentFolder string     // absolute path to the parent folder
+	logger       atomic.Pointer[Logger]
+	opts         *Options // options the database was opened with
+	keys         keyspace // builds every key under Options.KeyPrefix
+
+	ops       opCounters    // operation counters for debugging
+	inflight  inflight      // open iterators and buffered batch bytes
+	pressure  writePressure // write pressure and the pressured state, see WritePressure
+	dedup     *dedupWindow  // hashes stored moments ago, nil without Options.DedupWindow
+	lastError atomic.Value  // last error string recorded by an operation
+	typeCache countCache    // lazily refreshed per-type counts
+
+	quotaMu sync.RWMutex          // guards quotas
+	quotas  map[QuotaScope]uint64 // quotas loaded from the meta store
+
+	fallbacks []*fallback // Options.Fallbacks with their circuit breakers
+
+	hooksMu    sync.RWMutex      // guards crackHooks and nextHookID
+	crackHooks map[int]crackHook // called after hashes with values are written
+	nextHookID int
+
+	mirrorMu sync.Mutex                // guards mirrors
+	mirrors  map[string]*potfileMirror // potfile mirrors by absolute path
+
+	dirtyMu sync.Mutex      // guards dirty, held while flags are written
+	dirty   map[uint64]bool // hash types flagged dirty since open
+
+	openCheck   *OpenCheck                  // result of Options.CheckOnOpen, nil if it didn't run
+	indexReport atomic.Pointer[IndexReport] // result of the last VerifyIndexes, nil if none ran
+	dirtyOpen   string                      // why the database wasn't closed cleanly before it was opened, "" if it was
+
+	compacting atomic.Bool      // set while CompactNow runs
+	interned   valueDictionary  // the value dictionary, see InternValues
+	operations operationManager // operations started with StartOperation
+	warmup     warmupState      // progress of Warmup
+	sessions   sessionRegistry  // open sessions, see StartSession
+
+	stop     chan struct{} // closed by Close to stop background work
+	stopOnce sync.Once
 }
 
 // New creates a new KDB instance
 // dbFolder is the folder to store the database in
-// encryptionKey is the 32-byte encryption key
+// encryptionKey is the 32-byte encryption key, nil loads it from Options.KeySource. It isn't
+// retained, the caller may wipe it once New returns
 // opts is an optional set of KDBOptions
 func New(dbFolder string, encryptionKey []byte, opts ...*Options) (*KDB, error) {
 	var (
@@ -59,12 +111,16 @@ func New(dbFolder string, encryptionKey []byte, opts ...*Options) (*KDB, error)
 		dbOptions = DefaultOptions()
 	}
 
-	logger = dbOptions.Logger
+	// Until the database is open its messages go to the logger of the options
+	log := dbOptions.Logger
+	if log == nil {
+		log = logger
+	}
 
 	// Get the absolute path for the parent folder
 	absPath, err = filepath.Abs(dbFolder)
 	if err != nil {
-		logger(fmt.Sprintf("failed to get absolute path for '%s': %v", dbFolder, err), Error)
+		log(fmt.Sprintf("failed to get absolute path for '%s': %v", dbFolder, err), Error)
 		return nil, fmt.Errorf("failed to get absolute path for '%s': %w", dbFolder, err)
 	}
 
@@ -76,97 +132,337 @@ func New(dbFolder string, encryptionKey []byte, opts ...*Options) (*KDB, error)
 	} else {
 		dbOptions.ValueDir, err = filepath.Abs(dbOptions.ValueDir)
 		if err != nil {
-			logger(fmt.Sprintf("failed to get absolute path for '%s': %v", dbOptions.ValueDir, err), Error)
+			log(fmt.Sprintf("failed to get absolute path for '%s': %v", dbOptions.ValueDir, err), Error)
 			return nil, fmt.Errorf("failed to get absolute path for '%s': %w", dbOptions.ValueDir, err)
 		}
 	}
 
+	if len(encryptionKey) == 0 && dbOptions.KeySource != "" {
+		if encryptionKey, err = keysource.LoadKey(dbOptions.KeySource); err != nil {
+			log(fmt.Sprintf("Failed to load encryption key: %v", err), Error)
+			return nil, fmt.Errorf("failed to load encryption key: %w", err)
+		}
+		defer util.Zeroize(encryptionKey)
+	}
+
 	if len(encryptionKey) == 0 || len(encryptionKey) != 32 {
-		logger("encryption key must be 32 bytes", Error)
+		log("encryption key must be 32 bytes", Error)
 		return nil, fmt.Errorf("encryption key must be 32 bytes")
 	}
 
-	initOnce.Do(func() {
-		// Check if the krkn database already exists
-		isNewDB := !util.PathExists(absPath)
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
 
-		if isNewDB {
-			// Create the krkn database directory
-			if err = os.MkdirAll(absPath, 0700); err != nil {
-				logger(fmt.Sprintf("failed to create krkn database directory: %v", err), Error)
-				err = fmt.Errorf("failed to create krkn database directory: %w", err)
-				return
-			}
+	// Opening the folder of the open default database again returns it
+	if krkn != nil && krkn.parentFolder == absPath && !krkn.c.IsClosed() {
+		return krkn, nil
+	}
+
+	kc, err := open(absPath, dbFile, encryptionKey, dbOptions)
+	if err != nil {
+		log(fmt.Sprintf("Failed to create database: %v", err), Error)
+		return nil, err
+	}
+
+	// The first database opened becomes the default used by Get and the package-level helpers
+	if krkn == nil {
+		krkn = kc
+		defaultLogger.Store(kc.logger.Load())
+	}
+
+	kc.log("Successfully created database", Info)
+	return kc, nil
+}
+
+// open opens the badger database in absPath and wraps it in a new KDB
+func open(absPath, dbFile string, encryptionKey []byte, dbOptions *Options) (*KDB, error) {
+	var err error
+
+	log := dbOptions.Logger
+	if log == nil {
+		log = logger
+	}
+
+	// badger keeps the key it is given to encrypt the data keys it rotates to, it gets a copy
+	// of its own so the caller's can be wiped. Close wipes the copy, so does a failed open
+	badgerKey := bytes.Clone(encryptionKey)
+	opened := false
+	defer func() {
+		if !opened {
+			util.Zeroize(badgerKey)
 		}
+	}()
 
-		// Configure BadgerDB options
-		opts := badger.DefaultOptions(absPath).
-			WithValueDir(absPath).                                                      // Use the same directory for data and value files
-			WithEncryptionKey(encryptionKey).                                           // Enable encryption
-			WithCompression(dbOptions.Compression).                                     // Use ZSTD compression
-			WithEncryptionKeyRotationDuration(dbOptions.EncryptionKeyRotationDuration). // Rotate keys daily
-			WithNumVersionsToKeep(dbOptions.NumVersionsToKeep).                         // Only keep the latest version of each key
-			// WithBlockCacheSize(8 << 30).                       						// 8GB block krkn
-			WithIndexCacheSize(dbOptions.IndexCacheSize).                   // 10GB index krkn
-			WithValueThreshold(dbOptions.ValueThreshold).                   // 64KB inline threshold
-			WithValueLogFileSize(dbOptions.ValueLogFileSize).               // 2GB log files
-			WithMemTableSize(dbOptions.MemTableSize).                       // 512MB memtables
-			WithNumMemtables(dbOptions.NumMemTables).                       // More in-RAM tables
-			WithNumCompactors(dbOptions.NumCompactors).                     // More compaction threads
-			WithNumLevelZeroTables(dbOptions.NumLevelZeroTables).           // 20 L0 tables before compaction
-			WithNumLevelZeroTablesStall(dbOptions.NumLevelZeroTablesStall). // 40 L0 tables before stalling
-			WithBaseLevelSize(dbOptions.BaseLevelSize).                     // 20GB base level
-			WithMaxLevels(dbOptions.MaxLevels).                             // 7 levels
-			WithBloomFalsePositive(dbOptions.BloomFalsePositive).           // 1% false positive rate
-			WithLogger(nil)                                                 // Disable logging for speed
-
-		// Try to open the database with retries
-		var db *badger.DB
-		maxRetries := 3
-		retryDelay := 3 * time.Second
-		for i := 0; i < maxRetries; i++ {
-			db, err = badger.Open(opts)
-			if err == nil {
-				// Successfully opened
-				logger("Successfully opened database", Info)
-				break
-			}
+	if err = checkPlatform(dbOptions); err != nil {
+		log(fmt.Sprintf("Failed to open database: %v", err), Error)
+		return nil, err
+	}
+
+	keys, err := newKeyspace(dbOptions.KeyPrefix)
+	if err != nil {
+		log(fmt.Sprintf("Failed to open database: %v", err), Error)
+		return nil, err
+	}
+
+	// Check if the krkn database already exists
+	isNewDB := !util.PathExists(absPath)
+
+	if isNewDB {
+		// Create the krkn database directory
+		if err = os.MkdirAll(absPath, 0700); err != nil {
+			log(fmt.Sprintf("failed to create krkn database directory: %v", err), Error)
+			return nil, fmt.Errorf("failed to create krkn database directory: %w", err)
+		}
+		// Windows ignores the mode, the directory gets an ACL instead
+		if err = util.RestrictDir(absPath); err != nil {
+			log(fmt.Sprintf("failed to restrict access to krkn database directory: %v", err), Error)
+			return nil, fmt.Errorf("failed to restrict access to krkn database directory: %w", err)
+		}
+	}
 
-			if i < maxRetries-1 {
-				logger(fmt.Sprintf("Failed to open database: %v. Retrying in %v...", err, retryDelay), Warning)
-				time.Sleep(retryDelay)
+	// The marker is compared with the MANIFEST badger.Open is about to rewrite
+	var marker *cleanMarker
+	var manifest string
+	if !isNewDB {
+		if marker, err = readCleanMarker(absPath); err != nil {
+			log(fmt.Sprintf("Failed to read the clean close marker: %v", err), Warning)
+		}
+		manifest, _ = manifestChecksum(absPath)
+	}
+
+	// badger opens some files through the Win32 API, which needs long paths prefixed
+	badgerPath := util.LongPath(absPath)
+
+	// Configure BadgerDB options
+	opts := badger.DefaultOptions(badgerPath).
+		WithValueDir(badgerPath).                                                   // Use the same directory for data and value files
+		WithEncryptionKey(badgerKey).                                               // Enable encryption
+		WithCompression(dbOptions.Compression).                                     // Use ZSTD compression
+		WithEncryptionKeyRotationDuration(dbOptions.EncryptionKeyRotationDuration). // Rotate keys daily
+		WithNumVersionsToKeep(dbOptions.NumVersionsToKeep).                         // Only keep the latest version of each key
+		// WithBlockCacheSize(8 << 30).                       						// 8GB block krkn
+		WithIndexCacheSize(dbOptions.IndexCacheSize).                   // 10GB index krkn
+		WithValueThreshold(badgerValueThreshold(dbOptions)).            // 64KB inline threshold, 512B with tickets in the value log
+		WithValueLogFileSize(dbOptions.ValueLogFileSize).               // 2GB log files
+		WithMemTableSize(dbOptions.MemTableSize).                       // 512MB memtables
+		WithNumMemtables(dbOptions.NumMemTables).                       // More in-RAM tables
+		WithNumCompactors(dbOptions.NumCompactors).                     // More compaction threads
+		WithNumLevelZeroTables(dbOptions.NumLevelZeroTables).           // 20 L0 tables before compaction
+		WithNumLevelZeroTablesStall(dbOptions.NumLevelZeroTablesStall). // 40 L0 tables before stalling
+		WithBaseLevelSize(dbOptions.BaseLevelSize).                     // 20GB base level
+		WithMaxLevels(dbOptions.MaxLevels).                             // 7 levels
+		WithBloomFalsePositive(dbOptions.BloomFalsePositive).           // 1% false positive rate
+		WithDetectConflicts(dbOptions.DetectConflicts).                 // Reject transactions that read keys written since they started
+		WithLogger(nil)                                                 // Disable logging for speed
+
+	// Try to open the database with retries
+	var db *badger.DB
+	maxRetries := 3
+	retryDelay := 3 * time.Second
+	for i := 0; i < maxRetries; i++ {
+		db, err = badger.Open(opts)
+		if err == nil {
+			// Successfully opened
+			log("Successfully opened database", Info)
+			break
+		}
+
+		// A wrong key won't get better by retrying
+		if errors.Is(err, badger.ErrEncryptionKeyMismatch) {
+			log("Failed to open database: wrong encryption key", Error)
+			return nil, fmt.Errorf("%w: %v", ErrWrongKey, err)
+		}
+
+		// Neither will a lock held by a running process, one that is gone may still be releasing it
+		if isLockedError(err) {
+			if pid := liveHolder(absPath); pid != 0 {
+				log(fmt.Sprintf("Failed to open database: in use by process %d", pid), Error)
+				return nil, fmt.Errorf("%w by process %d", ErrDatabaseLocked, pid)
 			}
 		}
 
-		if err != nil {
-			logger(fmt.Sprintf("Failed to open database after %d retries: %v", maxRetries, err), Error)
-			err = fmt.Errorf("failed to open krkn database after %d retries: %w", maxRetries, err)
-			return
+		if i < maxRetries-1 {
+			log(fmt.Sprintf("Failed to open database: %v. Retrying in %v...", err, retryDelay), Warning)
+			time.Sleep(retryDelay)
 		}
+	}
 
-		krkn = &KDB{
-			encryptionKey: encryptionKey,
-			c:             db,
-			mu:            sync.Mutex{},
-			isNew:         isNewDB,
-			absPath:       dbFile,
-			parentFolder:  absPath,
+	if err != nil {
+		log(fmt.Sprintf("Failed to open database after %d retries: %v", maxRetries, err), Error)
+		if isLockedError(err) {
+			err = fmt.Errorf("%w: %w", ErrDatabaseLocked, err)
 		}
+		return nil, fmt.Errorf("failed to open krkn database after %d retries: %w", maxRetries, err)
+	}
 
-		go runPeriodicCompaction()
-	})
+	kc := &KDB{
+		badgerKey:    badgerKey,
+		c:            db,
+		mu:           sync.Mutex{},
+		isNew:        isNewDB,
+		absPath:      dbFile,
+		parentFolder: absPath,
+		opts:         dbOptions,
+		keys:         keys,
+		dedup:        newDedupWindow(dbOptions.DedupWindow, dbOptions.DedupWindowTTL),
+		stop:         make(chan struct{}),
+	}
+	kc.logger.Store(&log)
+	kc.fallbacks = newFallbacks(kc, dbOptions.Fallbacks)
+	if dbOptions.WrapEngine != nil {
+		kc.c = dbOptions.WrapEngine(db)
+	}
 
+	identity, err := kc.checkIdentity()
 	if err != nil {
-		logger(fmt.Sprintf("Failed to create database: %v", err), Error)
-		return nil, err
+		kc.log(fmt.Sprintf("Failed to check database identity: %v", err), Error)
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to check database identity: %w", err)
+	}
+
+	if err = kc.checkKeyPrefix(); err != nil {
+		kc.log(fmt.Sprintf("Failed to check key prefix: %v", err), Error)
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to check key prefix: %w", err)
+	}
+
+	if err = kc.verifyKnownValue(encryptionKey); err != nil {
+		kc.log(fmt.Sprintf("Failed to verify encryption key: %v", err), Error)
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to verify encryption key: %w", err)
+	}
+
+	if err = kc.checkSchema(); err != nil {
+		kc.log(fmt.Sprintf("Failed to check schema version: %v", err), Error)
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to check schema version: %w", err)
+	}
+
+	if !kc.isNew {
+		if kc.dirtyOpen, err = kc.checkCleanMarker(marker, manifest); err != nil {
+			kc.log(fmt.Sprintf("Failed to check the clean close marker: %v", err), Error)
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to check the clean close marker: %w", err)
+		}
+	}
+	if kc.dirtyOpen != "" {
+		if dbOptions.StrictOpen {
+			kc.log(fmt.Sprintf("Refusing to open %s, it wasn't closed cleanly: %s", absPath, kc.dirtyOpen), Error)
+			_ = db.Close()
+			return nil, fmt.Errorf("%w: %s", ErrDirtyOpen, kc.dirtyOpen)
+		}
+		kc.log(fmt.Sprintf("WARNING: %s wasn't closed cleanly (%s). It crashed or was copied while open, entries may be missing", absPath, kc.dirtyOpen), Warning)
+	}
+	// Gone while the database is open, so a copy taken meanwhile or a crash is caught by the next open
+	if err = removeCleanMarker(absPath); err != nil {
+		kc.log(fmt.Sprintf("Failed to remove the clean close marker: %v", err), Warning)
+	}
+
+	if err = kc.loadQuotas(); err != nil {
+		kc.log(fmt.Sprintf("Failed to load quotas: %v", err), Error)
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to load quotas: %w", err)
+	}
+
+	if err = kc.loadValueDictionary(); err != nil {
+		kc.log(fmt.Sprintf("Failed to load the value dictionary: %v", err), Error)
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to load the value dictionary: %w", err)
+	}
+
+	if err = kc.checkNormalizationFlag(); err != nil {
+		kc.log(fmt.Sprintf("Failed to check value normalization setting: %v", err), Error)
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to check value normalization setting: %w", err)
+	}
+
+	if !kc.isNew {
+		if err = kc.warnUnregistered(); err != nil {
+			kc.log(fmt.Sprintf("Failed to check the hash type registry: %v", err), Error)
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to check the hash type registry: %w", err)
+		}
+	}
+
+	// A new database counts cracked hashes from the first write
+	if kc.isNew {
+		if err = kc.SetMeta(crackedCountsMetaKey, nil); err != nil {
+			kc.log(fmt.Sprintf("Failed to record cracked counters: %v", err), Error)
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to record cracked counters: %w", err)
+		}
+	}
+
+	// Before the dirty recount, so counters the check flags are recounted right away
+	if dbOptions.CheckOnOpen && !kc.isNew {
+		if kc.openCheck, err = kc.checkOnOpen(); err != nil {
+			kc.log(fmt.Sprintf("Failed to check database consistency: %v", err), Error)
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to check database consistency: %w", err)
+		}
+		if kc.openCheck.OK() {
+			kc.log(fmt.Sprintf("Consistency check passed in %v: %s", kc.openCheck.Duration, kc.openCheck), Info)
+		} else {
+			kc.log(fmt.Sprintf("Consistency check found problems: %s", kc.openCheck), Warning)
+		}
+	}
+
+	if dbOptions.RecountDirtyOnOpen {
+		if _, err = kc.RecountDirtyHashTypes(); err != nil {
+			kc.log(fmt.Sprintf("Failed to recount dirty hash types: %v", err), Error)
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to recount dirty hash types: %w", err)
+		}
+	}
+
+	// Every check passed, only now is a directory without a marker stamped as this database
+	if err = kc.writeIdentity(identity); err != nil {
+		kc.log(fmt.Sprintf("Failed to record the database identity: %v", err), Error)
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to record the database identity: %w", err)
+	}
+
+	// Sets left by a process that crashed mid-import, a failed sweep is logged and tried again next open
+	if _, err = kc.SweepSessions(); err != nil {
+		kc.log(fmt.Sprintf("Failed to sweep stale session sets: %v", err), Warning)
+	}
+
+	if dbOptions.Expvar {
+		publishExpvar(kc)
+	}
+
+	if err = claimHolder(absPath, kc.log); err != nil {
+		kc.log(fmt.Sprintf("Failed to record the database holder: %v", err), Warning)
 	}
 
-	logger("Successfully created database", Info)
-	return krkn, nil
+	go kc.runPeriodicCompaction()
+	go kc.runPressureMonitor()
+	if dbOptions.WarmupOnOpen {
+		go kc.runWarmupOnOpen()
+	}
+	if dbOptions.RetentionInterval > 0 {
+		go kc.runRetentionSweeper(dbOptions.RetentionInterval)
+	}
+	if dbOptions.VerifyIndexesOnOpen && !kc.isNew {
+		sample := dbOptions.VerifyIndexesSample
+		if sample <= 0 {
+			sample = defaultIndexCheckSample
+		}
+		if _, err := kc.StartIndexCheck(nil, sample, true); err != nil {
+			kc.log(fmt.Sprintf("Failed to start the index check: %v", err), Warning)
+		}
+	}
+
+	opened = true
+	return kc, nil
 }
 
-// Get returns the global KDB instance
+// Get returns the default KDB instance, the first one opened by New.
+// Returns nil if no database is open
 func Get() *KDB {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
 	return krkn
 }
 
@@ -175,12 +471,66 @@ func (kc *KDB) IsNew() bool {
 	return kc.isNew
 }
 
-// Close closes the database
-func (kc *KDB) Close() error {
+// Close closes the database and stops its background work.
+// If this is the default database, the next database opened becomes the default.
+// A panic while closing is returned as a *PanicError with the database lock released
+func (kc *KDB) Close() (err error) {
+	defer kc.recoverPanic("close", &err)
+	kc.operations.stopAll()
+
+	// Mirrors go first so no hook appends to a closed file
+	mirrorErr := kc.closeMirrors()
+	if mirrorErr != nil {
+		kc.log(fmt.Sprintf("Failed to close potfile mirrors: %v", mirrorErr), Error)
+	}
+
 	kc.mu.Lock()
 	defer kc.mu.Unlock()
+	defer func() {
+		util.Zeroize(kc.badgerKey)
+		kc.badgerKey = nil
+	}()
+
+	kc.stopOnce.Do(func() { close(kc.stop) })
+	kc.dedup.clear()
 
-	return kc.c.Close()
+	defaultMu.Lock()
+	if krkn == kc {
+		krkn = nil
+	}
+	defaultMu.Unlock()
+
+	// Counters are written synchronously, so they are all in by now
+	dirtyErr := kc.clearDirty()
+	if dirtyErr != nil {
+		kc.log(fmt.Sprintf("Failed to clear dirty flags: %v", dirtyErr), Error)
+	}
+
+	// The marker is only written for a close that got through, any error leaves the next open dirty
+	var marker *cleanMarker
+	var markerErr error
+	if !kc.c.IsClosed() && dirtyErr == nil {
+		if marker, markerErr = kc.bumpCleanGeneration(); markerErr != nil {
+			kc.log(fmt.Sprintf("Failed to record the clean close generation: %v", markerErr), Error)
+		}
+	}
+
+	closeErr := kc.c.Close()
+	if closeErr == nil && marker != nil {
+		marker.ClosedAt = time.Now().UTC()
+		if marker.Manifest, markerErr = manifestChecksum(kc.parentFolder); markerErr == nil {
+			markerErr = writeCleanMarker(kc.parentFolder, marker)
+		}
+		if markerErr != nil {
+			kc.log(fmt.Sprintf("Failed to write the clean close marker: %v", markerErr), Error)
+		}
+	}
+	if closeErr == nil {
+		if err := releaseHolder(kc.parentFolder); err != nil {
+			kc.log(fmt.Sprintf("Failed to remove the database holder: %v", err), Warning)
+		}
+	}
+	return errors.Join(closeErr, mirrorErr, dirtyErr, markerErr)
 }
 
 // Nil returns true if the database is nil
@@ -200,72 +550,56 @@ func (kc *KDB) DBPath() string {
 
 // TotalHashes returns the total number of hashes in the database
 func (kc *KDB) TotalHashes() (int, error) {
-	return kc.getCount(totalHashesKey)
+	return kc.shardedCount(kc.keys.key(totalHashesKey))
 }
 
 // HashesByType returns the number of hashes of a specific type in the database
 func (kc *KDB) HashesByType(hashType uint64) (int, error) {
-	return kc.getCount(fmt.Sprintf(hashTypeCountPrefix, hashType))
+	return kc.typeCount(hashType)
 }
 
-// SetLogger sets the logger.
-// Can also be set in the options
+// SetLogger sets the logger of the database, the default database's is also used where no
+// database is at hand. On a nil database it sets only that one. Can also be set in the options
 func (kc *KDB) SetLogger(l Logger) {
-	logger = l
-}
-
-// incrementTotalHashCount increments the total hash count.
-// Creates the key if it doesn't exist
-func (kc *KDB) incrementTotalHashCount() {
-	// Check if the key exists
-	err := kc.checkCounter(totalHashesKey)
-	if err != nil {
-		logger(fmt.Sprintf("failed to check total hash count: %v", err), Error)
+	if kc != nil {
+		kc.logger.Store(&l)
+	}
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if kc == nil || krkn == kc {
+		defaultLogger.Store(&l)
 	}
+}
 
-	err = krkn.updateCount(totalHashesKey, 1)
-	if err != nil {
-		if errors.Is(err, badger.ErrKeyNotFound) {
-			logger("total hash count not found, initializing", Info)
-			err = krkn.initializeCounter(totalHashesKey, 1)
-			if err != nil {
-				logger(fmt.Sprintf("failed to initialize total hash count: %v", err), Error)
-			}
+// log sends msg to the logger of the database, or to the default one if there is no database
+func (kc *KDB) log(msg string, severity Severity) {
+	if kc != nil {
+		if l := kc.logger.Load(); l != nil && *l != nil {
+			(*l)(msg, severity)
 			return
 		}
-		logger(fmt.Sprintf("failed to update total hash count: %v", err), Error)
 	}
+	logger(msg, severity)
 }
 
-// incrementHashTypeCount increments the hash type count.
-// Creates the key if it doesn't exist and registers the hash type
-func (kc *KDB) incrementHashTypeCount(hashType uint64) {
-	prefix := fmt.Sprintf(hashTypeLookupPrefix, hashType)
-
-	// Check if the key exists
-	err := kc.checkCounter(prefix)
-	if err != nil {
-		logger(fmt.Sprintf("failed to check hash type count: %v", err), Error)
+// logger sends msg to the logger of the default database, DefaultLogger before one is opened
+func logger(msg string, severity Severity) {
+	if l := defaultLogger.Load(); l != nil && *l != nil {
+		(*l)(msg, severity)
+		return
 	}
+	DefaultLogger(msg, severity)
+}
 
-	// Register this hash type if it's new
-	if err := kc.registerHashType(hashType); err != nil {
-		logger(fmt.Sprintf("failed to register hash type %d: %v", hashType, err), Error)
+// countNewHash counts a hash stored for the first time within txn and registers its hash type
+func (kc *KDB) countNewHash(txn *badger.Txn, hashType uint64) error {
+	if err := kc.addHashType(txn, hashType); err != nil {
+		return err
 	}
-
-	err = kc.updateCount(prefix, 1)
-	if err != nil {
-		if errors.Is(err, badger.ErrKeyNotFound) {
-			logger("hash type count not found, initializing", Info)
-			err = krkn.initializeCounter(prefix, 1)
-			if err != nil {
-				logger(fmt.Sprintf("failed to initialize hash type count: %v", err), Error)
-			}
-			return
-		}
-		logger(fmt.Sprintf("failed to update hash type count: %v", err), Error)
-		fmt.Printf("failed to update hash type count: %v\n", err)
+	if err := kc.addTypeCount(txn, hashType, 1); err != nil {
+		return err
 	}
+	return kc.addShardedCount(txn, kc.keys.key(totalHashesKey), 1)
 }
 
 // registerHashType adds a hash type to the registry if it doesn't exist
@@ -274,46 +608,56 @@ func (kc *KDB) registerHashType(hashType uint64) error {
 	defer kc.mu.Unlock()
 
 	return kc.c.Update(func(txn *badger.Txn) error {
-		// Get existing registry
-		registry := make(map[uint64]bool)
-		item, err := txn.Get([]byte(hashTypeRegistryKey))
+		return kc.addHashType(txn, hashType)
+	})
+}
 
-		if err == nil {
-			// Registry exists, unmarshal it
-			err = item.Value(func(val []byte) error {
-				// Registry is stored as a list of uint64s
-				for i := 0; i < len(val); i += 8 {
-					if i+8 <= len(val) {
-						ht := binary.BigEndian.Uint64(val[i : i+8])
-						registry[ht] = true
-					}
+// addHashType adds a hash type to the registry within txn if it doesn't exist
+func (kc *KDB) addHashType(txn *badger.Txn, hashType uint64) error {
+	// Get existing registry
+	registry := make(map[uint64]bool)
+	item, err := txn.Get([]byte(kc.keys.key(hashTypeRegistryKey)))
+
+	if err == nil {
+		// Registry exists, unmarshal it
+		err = item.Value(func(val []byte) error {
+			// Registry is stored as a list of uint64s
+			for i := 0; i < len(val); i += 8 {
+				if i+8 <= len(val) {
+					ht := binary.BigEndian.Uint64(val[i : i+8])
+					registry[ht] = true
 				}
-				return nil
-			})
-			if err != nil {
-				return err
 			}
-		} else if err != badger.ErrKeyNotFound {
+			return nil
+		})
+		if err != nil {
 			return err
 		}
+	} else if err != badger.ErrKeyNotFound {
+		return err
+	}
 
-		// Add the new hash type if not already present
-		if !registry[hashType] {
-			registry[hashType] = true
-
-			// Serialize the registry
-			buf := make([]byte, len(registry)*8)
-			i := 0
-			for ht := range registry {
-				binary.BigEndian.PutUint64(buf[i:i+8], ht)
-				i += 8
-			}
+	// Add the new hash type if not already present
+	if !registry[hashType] {
+		registry[hashType] = true
 
-			return txn.Set([]byte(hashTypeRegistryKey), buf)
+		if err := kc.writeHashTypes(txn, slices.Collect(maps.Keys(registry))); err != nil {
+			return err
 		}
+		return kc.recordFirstSeen(txn, hashType, time.Now())
+	}
 
-		return nil
-	})
+	return nil
+}
+
+// writeHashTypes replaces the hash type registry within txn
+func (kc *KDB) writeHashTypes(txn *badger.Txn, hashTypes []uint64) error {
+	// Registry is stored as a list of uint64s
+	buf := make([]byte, len(hashTypes)*8)
+	for i, ht := range hashTypes {
+		binary.BigEndian.PutUint64(buf[i*8:], ht)
+	}
+	return txn.Set([]byte(kc.keys.key(hashTypeRegistryKey)), buf)
 }
 
 // getRegisteredHashTypes returns all registered hash types (internal method)
@@ -324,24 +668,35 @@ func (kc *KDB) getRegisteredHashTypes() ([]uint64, error) {
 	var hashTypes []uint64
 
 	err := kc.c.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(hashTypeRegistryKey))
-		if err == badger.ErrKeyNotFound {
-			return nil // No hash types registered yet
-		}
-		if err != nil {
-			return err
-		}
+		var err error
+		hashTypes, err = kc.readHashTypes(txn)
+		return err
+	})
 
-		return item.Value(func(val []byte) error {
-			// Parse the registry
-			for i := 0; i < len(val); i += 8 {
-				if i+8 <= len(val) {
-					ht := binary.BigEndian.Uint64(val[i : i+8])
-					hashTypes = append(hashTypes, ht)
-				}
+	return hashTypes, err
+}
+
+// readHashTypes reads the hash type registry within txn
+func (kc *KDB) readHashTypes(txn *badger.Txn) ([]uint64, error) {
+	var hashTypes []uint64
+
+	item, err := txn.Get([]byte(kc.keys.key(hashTypeRegistryKey)))
+	if err == badger.ErrKeyNotFound {
+		return nil, nil // No hash types registered yet
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = item.Value(func(val []byte) error {
+		// Parse the registry
+		for i := 0; i < len(val); i += 8 {
+			if i+8 <= len(val) {
+				ht := binary.BigEndian.Uint64(val[i : i+8])
+				hashTypes = append(hashTypes, ht)
 			}
-			return nil
-		})
+		}
+		return nil
 	})
 
 	return hashTypes, err
@@ -353,28 +708,32 @@ func (kc *KDB) GetRegisteredHashTypes() ([]uint64, error) {
 	return kc.getRegisteredHashTypes()
 }
 
-// runPeriodicCompaction runs periodic compaction on the database
-func runPeriodicCompaction() {
-	if krkn == nil {
-		logger("krkn database is nil, skipping compaction", Warning)
-		return
-	}
+// runValueLogGC runs a value log GC pass, a panic of badger fails just this pass
+func (kc *KDB) runValueLogGC() (err error) {
+	defer kc.recoverPanic("value log GC", &err)
+	return kc.c.RunValueLogGC(0.5)
+}
 
+// runPeriodicCompaction runs periodic compaction on the database until it is closed
+func (kc *KDB) runPeriodicCompaction() {
 	// Run compaction immediately on startup
-	if err := krkn.c.RunValueLogGC(0.5); err != nil && err != badger.ErrNoRewrite {
-		logger(fmt.Sprintf("failed to run value log GC: %v", err), Error)
-		fmt.Printf("failed to run value log GC: %v\n", err)
+	if err := kc.runValueLogGC(); err != nil && err != badger.ErrNoRewrite {
+		kc.log(fmt.Sprintf("failed to run value log GC: %v", err), Error)
 	}
 
 	// Then run every 6 hours
 	ticker := time.NewTicker(6 * time.Hour)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		logger("running periodic compaction", Info)
-		if err := krkn.c.RunValueLogGC(0.5); err != nil && err != badger.ErrNoRewrite {
-			logger(fmt.Sprintf("failed to run value log GC: %v", err), Error)
-			fmt.Printf("failed to run value log GC: %v\n", err)
+	for {
+		select {
+		case <-kc.stop:
+			return
+		case <-ticker.C:
+			kc.log("running periodic compaction", Info)
+			if err := kc.runValueLogGC(); err != nil && err != badger.ErrNoRewrite {
+				kc.log(fmt.Sprintf("failed to run value log GC: %v", err), Error)
+			}
 		}
 	}
 }
@@ -0,0 +1,27 @@
+package kdb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCloseWipesTheKey(t *testing.T) {
+	key := bytes.Clone(testKey)
+	db := newTestDB(t, testPrefix, nil)
+	held := db.badgerKey
+	if !bytes.Equal(held, testKey) {
+		t.Fatalf("badger holds the key %x", held)
+	}
+
+	db.Close()
+	if db.badgerKey != nil {
+		t.Errorf("the key is still held after Close")
+	}
+	if !bytes.Equal(held, make([]byte, len(held))) {
+		t.Errorf("the key wasn't wiped by Close: %x", held)
+	}
+	// badger's copy is its own, the caller's key is the caller's to wipe
+	if !bytes.Equal(testKey, key) {
+		t.Errorf("Close wiped the caller's key")
+	}
+}
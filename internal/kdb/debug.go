@@ -0,0 +1,178 @@
+package kdb
+
+import (
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// typeCountRefreshInterval is how long cached per-type counts are served before being re-read
+const typeCountRefreshInterval = 30 * time.Second
+
+var (
+	expvarOnce sync.Once
+	expvarDB   atomic.Pointer[KDB]
+)
+
+// opCounters tracks how many times each operation has run
+type opCounters struct {
+	stores            atomic.Uint64
+	storeErrors       atomic.Uint64
+	lookups           atomic.Uint64
+	lookupHits        atomic.Uint64
+	lookupMisses      atomic.Uint64
+	iterations        atomic.Uint64
+	recounts          atomic.Uint64
+	conflicts         atomic.Uint64 // transactions that hit badger.ErrConflict, retried or not
+	undecodable       atomic.Uint64 // entries scans skipped because they failed to decode
+	timeouts          atomic.Uint64 // calls that failed with ErrOperationTimeout, not counted as store errors
+	panics            atomic.Uint64 // panics recovered into ErrInternalPanic
+	skippedDuplicates atomic.Uint64 // stores Options.DedupWindow skipped as stored moments ago
+}
+
+// snapshot returns the counters as a plain map
+func (o *opCounters) snapshot() map[string]uint64 {
+	return map[string]uint64{
+		"stores":             o.stores.Load(),
+		"store_errors":       o.storeErrors.Load(),
+		"lookups":            o.lookups.Load(),
+		"lookup_hits":        o.lookupHits.Load(),
+		"lookup_misses":      o.lookupMisses.Load(),
+		"iterations":         o.iterations.Load(),
+		"recounts":           o.recounts.Load(),
+		"conflicts":          o.conflicts.Load(),
+		"undecodable":        o.undecodable.Load(),
+		"timeouts":           o.timeouts.Load(),
+		"panics":             o.panics.Load(),
+		"skipped_duplicates": o.skippedDuplicates.Load(),
+	}
+}
+
+// countCache holds per-type counts that are refreshed lazily
+type countCache struct {
+	mu        sync.Mutex
+	refreshed time.Time
+	total     int
+	byType    map[string]int
+}
+
+// get returns the cached counts, re-reading them from the database when stale
+func (cc *countCache) get(kc *KDB) (int, map[string]int) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.byType != nil && time.Since(cc.refreshed) < typeCountRefreshInterval {
+		return cc.total, cc.byType
+	}
+
+	total, _ := kc.TotalHashes()
+	byType := make(map[string]int)
+
+	hashTypes, err := kc.getRegisteredHashTypes()
+	if err == nil {
+		for _, hashType := range hashTypes {
+			count, _ := kc.HashesByType(hashType)
+			byType[fmt.Sprintf("%d", hashType)] = count
+		}
+	}
+
+	cc.total = total
+	cc.byType = byType
+	cc.refreshed = time.Now()
+	return cc.total, cc.byType
+}
+
+// recordError remembers err as the last error seen by the database
+func (kc *KDB) recordError(err error) {
+	if err != nil {
+		kc.lastError.Store(err.Error())
+	}
+}
+
+// LastError returns the last error recorded by a database operation
+func (kc *KDB) LastError() string {
+	if v, ok := kc.lastError.Load().(string); ok {
+		return v
+	}
+	return ""
+}
+
+// DebugSnapshot returns operation counters, per-type counts, open status, the last error,
+// badger's internal metrics, its LSM tree, warmup progress, the operations of ListOperations and
+// the open iterators and batches.
+// This is the same data published
+// under the "krkndb" expvar map when Options.Expvar is enabled
+func (kc *KDB) DebugSnapshot() map[string]any {
+	open := !kc.Nil() && !kc.c.IsClosed()
+
+	snapshot := map[string]any{
+		"open":       open,
+		"path":       kc.absPath,
+		"operations": kc.ops.snapshot(),
+		"last_error": kc.LastError(),
+
+		"long_operations": kc.ListOperations(),
+		"inflight":        kc.inflight.snapshot(),
+	}
+
+	if !open {
+		return snapshot
+	}
+
+	total, byType := kc.typeCache.get(kc)
+	snapshot["total_hashes"] = total
+	snapshot["hash_types"] = byType
+	snapshot["badger"] = kc.badgerMetrics()
+	if lsm, err := kc.LSMInfo(); err == nil {
+		snapshot["lsm"] = lsm
+	}
+	snapshot["warmup"] = kc.WarmupStatus()
+
+	return snapshot
+}
+
+// badgerMetrics collects the sizes and cache metrics badger exposes, along with
+// its process-wide expvar counters
+func (kc *KDB) badgerMetrics() map[string]any {
+	lsm, vlog := kc.c.Size()
+	metrics := map[string]any{
+		"lsm_size":  lsm,
+		"vlog_size": vlog,
+	}
+
+	if m := kc.c.BlockCacheMetrics(); m != nil {
+		metrics["block_cache_hits"] = m.Hits()
+		metrics["block_cache_misses"] = m.Misses()
+	}
+	if m := kc.c.IndexCacheMetrics(); m != nil {
+		metrics["index_cache_hits"] = m.Hits()
+		metrics["index_cache_misses"] = m.Misses()
+	}
+
+	expvar.Do(func(kv expvar.KeyValue) {
+		if strings.HasPrefix(kv.Key, "badger_") {
+			metrics[kv.Key] = kv.Value
+		}
+	})
+
+	return metrics
+}
+
+// publishExpvar publishes the debug snapshot of kc under the "krkndb" expvar map.
+// expvar names can only be registered once per process, so later calls just point
+// the published map at the newest database
+func publishExpvar(kc *KDB) {
+	expvarDB.Store(kc)
+	expvarOnce.Do(func() {
+		expvar.Publish("krkndb", expvar.Func(func() any {
+			db := expvarDB.Load()
+			if db == nil {
+				return map[string]any{"open": false}
+			}
+			return db.DebugSnapshot()
+		}))
+	})
+}
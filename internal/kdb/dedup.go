@@ -0,0 +1,207 @@
+package kdb
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultDedupWindowTTL is how long the dedup window remembers a hash when Options.DedupWindowTTL is 0
+const defaultDedupWindowTTL = 10 * time.Second
+
+// dedupWindow remembers the hashes stored moments ago, see Options.DedupWindow, so a source
+// sending the same hash again and again costs no writes. Entries are keyed on the key of the
+// hash, its type and sum, and hold a fingerprint of what was written: a hash whose value or any
+// other field differs misses and is written. It is best-effort, whatever misses takes the normal
+// path. Entries are remembered and forgotten under KDB.mu, in the same critical section as the
+// write, so the window never claims a record the database no longer holds
+type dedupWindow struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List // of *dedupEntry, oldest first
+	paused  int        // running migrations, which write outside KDB.mu, see suspend
+}
+
+// dedupEntry is a hash the window remembers
+type dedupEntry struct {
+	key    string
+	print  [sha256.Size]byte
+	stored time.Time
+}
+
+// newDedupWindow returns a window of size hashes remembered for ttl, nil for size 0
+func newDedupWindow(size int, ttl time.Duration) *dedupWindow {
+	if size <= 0 {
+		return nil
+	}
+	if ttl <= 0 {
+		ttl = defaultDedupWindowTTL
+	}
+	return &dedupWindow{size: size, ttl: ttl, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// fingerprint digests the fields of a hash that end up in its record. Timestamps and the
+// insertion sequence are left out, a duplicate sent moments later keeps those of the first write
+func fingerprint(sh *Hash) [sha256.Size]byte {
+	h := sha256.New()
+	field := func(b []byte) {
+		h.Write(binary.AppendUvarint(nil, uint64(len(b))))
+		h.Write(b)
+	}
+	field([]byte(sh.Hash))
+	field([]byte(sh.Value))
+	field([]byte(sh.Salt))
+	field([]byte(sh.Session))
+	field(sh.Binary)
+	field(binary.AppendUvarint(nil, uint64(len(sh.Meta))))
+	for _, k := range slices.Sorted(maps.Keys(sh.Meta)) {
+		field([]byte(k))
+		field([]byte(sh.Meta[k]))
+	}
+	field(binary.AppendUvarint(nil, uint64(len(sh.Captures))))
+	for _, capture := range sh.Captures {
+		field([]byte(capture))
+	}
+	if sh.Source != nil {
+		field([]byte(sh.Source.Wordlist))
+		field([]byte(sh.Source.Rule))
+		field([]byte(sh.Source.Mask))
+		field([]byte(sh.Source.Tool))
+	}
+	var print [sha256.Size]byte
+	h.Sum(print[:0])
+	return print
+}
+
+// seen reports whether sh was stored moments ago exactly as it is now
+func (dw *dedupWindow) seen(sh *Hash) bool {
+	if dw == nil {
+		return false
+	}
+	print := fingerprint(sh)
+
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+
+	el, ok := dw.entries[string(sh.Key)]
+	if !ok || dw.paused > 0 {
+		return false
+	}
+	entry := el.Value.(*dedupEntry)
+	if time.Since(entry.stored) >= dw.ttl {
+		dw.order.Remove(el)
+		delete(dw.entries, entry.key)
+		return false
+	}
+	return entry.print == print
+}
+
+// remember records hashes as just written, evicting the oldest entries beyond the size
+func (dw *dedupWindow) remember(hashes ...*Hash) {
+	if dw == nil {
+		return
+	}
+	prints := make([][sha256.Size]byte, len(hashes))
+	for i, sh := range hashes {
+		prints[i] = fingerprint(sh)
+	}
+	now := time.Now()
+
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+
+	if dw.paused > 0 {
+		return
+	}
+	for i, sh := range hashes {
+		if el, ok := dw.entries[string(sh.Key)]; ok {
+			dw.order.Remove(el)
+		}
+		entry := &dedupEntry{key: string(sh.Key), print: prints[i], stored: now}
+		dw.entries[entry.key] = dw.order.PushBack(entry)
+	}
+	for dw.order.Len() > dw.size {
+		oldest := dw.order.Front()
+		dw.order.Remove(oldest)
+		delete(dw.entries, oldest.Value.(*dedupEntry).key)
+	}
+}
+
+// forget drops the entry of a key whose record is being changed or deleted other than by a store
+func (dw *dedupWindow) forget(key []byte) {
+	if dw == nil {
+		return
+	}
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+
+	if el, ok := dw.entries[string(key)]; ok {
+		dw.order.Remove(el)
+		delete(dw.entries, string(key))
+	}
+}
+
+// clear drops every entry, for Close
+func (dw *dedupWindow) clear() {
+	if dw == nil {
+		return
+	}
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+
+	clear(dw.entries)
+	dw.order.Init()
+}
+
+// suspend empties the window and keeps it empty until the returned function is called, for
+// migrations rewriting records outside KDB.mu where a store could be remembered after a rewrite
+// of its record had begun
+func (dw *dedupWindow) suspend() func() {
+	if dw == nil {
+		return func() {}
+	}
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+
+	dw.paused++
+	clear(dw.entries)
+	dw.order.Init()
+	return func() {
+		dw.mu.Lock()
+		defer dw.mu.Unlock()
+		dw.paused--
+	}
+}
+
+// skipDuplicates returns the hashes of a batch the window hasn't seen stored moments ago and how
+// many it skipped, counted in the skipped_duplicates metric
+func (kc *KDB) skipDuplicates(hashes []*Hash) ([]*Hash, int) {
+	if kc.dedup == nil {
+		return hashes, 0
+	}
+	var fresh []*Hash
+	skipped := 0
+	for i, sh := range hashes {
+		if !kc.dedup.seen(sh) {
+			if skipped > 0 {
+				fresh = append(fresh, sh)
+			}
+			continue
+		}
+		if skipped == 0 {
+			fresh = slices.Clone(hashes[:i])
+		}
+		skipped++
+	}
+	if skipped == 0 {
+		return hashes, 0
+	}
+	kc.ops.skippedDuplicates.Add(uint64(skipped))
+	return fresh, skipped
+}
@@ -0,0 +1,440 @@
+package kdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	// tombstonePrefix keys the deletion time of a deleted hash, hash_type:sum, see ExportDelta
+	tombstonePrefix     = "tombstone:%d:%s"
+	tombstoneScanPrefix = "tombstone:"
+
+	// deltaIDMetaKey is the meta entry holding the random id delta markers are bound to
+	deltaIDMetaKey = "delta_id"
+
+	deltaMarkerVersion = 1
+	deltaMarkerSize    = 1 + 8 + 8 + 4 // version, badger version, database id, checksum
+)
+
+// DeltaReport summarizes an ApplyDelta. The embedded ImportReport covers the records stored,
+// Conflicts counts those that were stored with a different value, kept or replaced by the policy
+type DeltaReport struct {
+	ImportReport
+	Deleted int    `json:"deleted"` // Hashes removed by the tombstones of the delta
+	Marker  string `json:"marker"`  // The marker the delta ended with, for the next ExportDelta of its source
+}
+
+// deltaTombstone is the delta line of a deleted hash
+type deltaTombstone struct {
+	Deleted   bool      `json:"deleted"`
+	HashType  uint64    `json:"type"`
+	Sum       string    `json:"sum"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// deltaTrailer is the last line of a delta, a delta without it was cut short
+type deltaTrailer struct {
+	End     bool   `json:"end"`
+	Records int    `json:"records"` // Lines before the trailer
+	Marker  string `json:"marker"`
+}
+
+// deltaLine is what ApplyDelta reads of a line to tell records, tombstones and the trailer apart
+type deltaLine struct {
+	deltaTombstone
+	deltaTrailer
+}
+
+// ExportDelta writes the hashes created, updated or deleted since the export that returned
+// sinceMarker to w as JSON lines, and returns the marker to pass to the next one. "" exports
+// everything. Created and updated hashes are written as ExportJSONL writes them, deleted ones as
+// tombstones, {"deleted":true,"type":...,"sum":...}, and a trailer line ends the delta. Markers
+// are opaque and only valid for this database: one from another database, altered or ahead of
+// it returns ErrInvalidMarker. Records rewritten in place, by migrations or RecompressValues,
+// count as updated. Deletes are recorded by DeleteHash, purges and transactions, hashes removed
+// by a migration aren't. See PruneTombstones
+func (kc *KDB) ExportDelta(w io.Writer, sinceMarker string) (string, error) {
+	if kc.Nil() || kc.c.IsClosed() {
+		return "", ErrNotInitialized
+	}
+
+	id, err := kc.deltaID()
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to read the delta id: %v", err), Error)
+		return "", fmt.Errorf("failed to read the delta id: %w", err)
+	}
+	since, err := kc.parseDeltaMarker(sinceMarker, id)
+	if err != nil {
+		return "", err
+	}
+
+	txn := kc.c.NewTransaction(false)
+	defer txn.Discard()
+
+	bw := bufio.NewWriter(w)
+	records := 0
+	emit := func(v any) error {
+		line, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to marshal delta line: %w", err)
+		}
+		if _, err := bw.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("failed to write delta: %w", err)
+		}
+		records++
+		return nil
+	}
+
+	upserts, err := kc.exportChanged(txn, since, emit)
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to export the delta: %v", err), Error)
+		return "", err
+	}
+	deletes, err := kc.exportTombstones(txn, since, emit)
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to export the delta: %v", err), Error)
+		return "", err
+	}
+
+	marker := encodeDeltaMarker(txn.ReadTs(), id)
+	if err := emit(deltaTrailer{End: true, Records: records, Marker: marker}); err != nil {
+		return "", err
+	}
+	if err := bw.Flush(); err != nil {
+		return "", fmt.Errorf("failed to flush delta: %w", err)
+	}
+
+	kc.log(fmt.Sprintf("Exported a delta of %d changed and %d deleted hashes", upserts, deletes), Info)
+	return marker, nil
+}
+
+// exportChanged emits every hash written after version since. Hash keys are the only keys of the
+// keyspace that start with a digit, see storedHashTypes
+func (kc *KDB) exportChanged(txn *badger.Txn, since uint64, emit func(any) error) (int, error) {
+	prefix := []byte(kc.keys.key(""))
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.SinceTs = since
+	it := kc.newIterator(txn, opts)
+	defer it.Close()
+
+	n := 0
+	for it.Seek(append(bytes.Clone(prefix), '0')); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		if rest := item.Key()[len(prefix):]; rest[0] < '0' || rest[0] > '9' {
+			break
+		}
+
+		var hash Hash
+		if err := item.Value(func(val []byte) error {
+			return kc.decodeHash(val, &hash)
+		}); err != nil {
+			return n, fmt.Errorf("failed to decode %q: %w", item.Key(), err)
+		}
+		if err := emit(&hash); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// exportTombstones emits the tombstones written after version since, skipping hashes stored again
+// after they were deleted, which exportChanged wrote
+func (kc *KDB) exportTombstones(txn *badger.Txn, since uint64, emit func(any) error) (int, error) {
+	prefix := []byte(kc.keys.key(tombstoneScanPrefix))
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.SinceTs = since
+	it := kc.newIterator(txn, opts)
+	defer it.Close()
+
+	n := 0
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		hashType, sum, ok := parseTombstone(item.Key()[len(prefix):])
+		if !ok {
+			kc.log(fmt.Sprintf("Skipping malformed tombstone %q", item.Key()), Warning)
+			continue
+		}
+
+		if _, err := txn.Get(kc.keys.hashKey(hashType, sum)); err == nil {
+			continue
+		} else if !isNotFound(err) {
+			return n, err
+		}
+
+		var deletedAt time.Time
+		if err := item.Value(func(val []byte) error {
+			if len(val) == 8 {
+				deletedAt = time.Unix(0, int64(binary.BigEndian.Uint64(val))).UTC()
+			}
+			return nil
+		}); err != nil {
+			return n, err
+		}
+		if err := emit(deltaTombstone{Deleted: true, HashType: hashType, Sum: sum, DeletedAt: deletedAt}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// parseTombstone splits the hash_type:sum of a tombstone key
+func parseTombstone(rest []byte) (uint64, string, bool) {
+	i := bytes.IndexByte(rest, ':')
+	if i < 0 {
+		return 0, "", false
+	}
+	hashType, err := strconv.ParseUint(string(rest[:i]), 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return hashType, string(rest[i+1:]), true
+}
+
+// writeTombstone records in txn that the hash of hashType under key is being deleted
+func (kc *KDB) writeTombstone(txn *badger.Txn, hashType uint64, key []byte) error {
+	sum := key[len(kc.keys.key(hashTypeScanPrefix, hashType)):]
+	deletedAt := binary.BigEndian.AppendUint64(nil, uint64(time.Now().UnixNano()))
+	return txn.Set([]byte(kc.keys.key(tombstonePrefix, hashType, sum)), deletedAt)
+}
+
+// PruneTombstones removes the tombstones of hashes deleted before the export that returned marker,
+// returning how many it removed. Deltas exported since an earlier marker no longer carry those
+// deletes, so prune only once every copy synced past marker
+func (kc *KDB) PruneTombstones(marker string) (int, error) {
+	if kc.Nil() || kc.c.IsClosed() {
+		return 0, ErrNotInitialized
+	}
+	id, err := kc.deltaID()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the delta id: %w", err)
+	}
+	until, err := kc.parseDeltaMarker(marker, id)
+	if err != nil || until == 0 {
+		return 0, err
+	}
+
+	var keys [][]byte
+	prefix := []byte(kc.keys.key(tombstoneScanPrefix))
+	err = kc.viewLocked(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		opts.PrefetchValues = false
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if it.Item().Version() <= until {
+				keys = append(keys, it.Item().KeyCopy(nil))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read tombstones: %w", err)
+	}
+
+	pruned := 0
+	for start := 0; start < len(keys); start += purgeChunkSize {
+		chunk := keys[start:min(start+purgeChunkSize, len(keys))]
+		err = kc.updateLocked(func(txn *badger.Txn) error {
+			for _, key := range chunk {
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to prune tombstones: %v", err), Error)
+			return pruned, fmt.Errorf("failed to prune tombstones: %w", err)
+		}
+		pruned += len(chunk)
+	}
+	return pruned, nil
+}
+
+// deltaID returns the random id markers of this database are bound to, creating it on first use
+func (kc *KDB) deltaID() ([]byte, error) {
+	key := []byte(kc.keys.key(metaPrefix, deltaIDMetaKey))
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	var id []byte
+	err := kc.c.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err == nil {
+			id, err = item.ValueCopy(nil)
+			return err
+		}
+		if !isNotFound(err) {
+			return err
+		}
+		id = make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		return txn.Set(key, id)
+	})
+	return id, err
+}
+
+// encodeDeltaMarker encodes the badger version a delta was read at for this database
+func encodeDeltaMarker(version uint64, id []byte) string {
+	b := make([]byte, 0, deltaMarkerSize)
+	b = append(b, deltaMarkerVersion)
+	b = binary.BigEndian.AppendUint64(b, version)
+	b = append(b, id...)
+	b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// parseDeltaMarker returns the version marker was read at, 0 for "". Returns ErrInvalidMarker for
+// a marker that is malformed, from another database or ahead of this one
+func (kc *KDB) parseDeltaMarker(marker string, id []byte) (uint64, error) {
+	if marker == "" {
+		return 0, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(marker)
+	if err != nil || len(b) != deltaMarkerSize || b[0] != deltaMarkerVersion ||
+		binary.BigEndian.Uint32(b[deltaMarkerSize-4:]) != crc32.ChecksumIEEE(b[:deltaMarkerSize-4]) {
+		return 0, fmt.Errorf("%w: %q is malformed", ErrInvalidMarker, marker)
+	}
+	if !bytes.Equal(b[9:17], id) {
+		return 0, fmt.Errorf("%w: %q belongs to another database", ErrInvalidMarker, marker)
+	}
+	version := binary.BigEndian.Uint64(b[1:9])
+	if version > kc.c.MaxVersion() {
+		return 0, fmt.Errorf("%w: %q is ahead of this database", ErrInvalidMarker, marker)
+	}
+	return version, nil
+}
+
+// ApplyDelta applies a delta written by ExportDelta of another database. Records are stored whole,
+// replacing the stored ones; policy decides who wins for a hash stored with another value, counted
+// in DeltaReport.Conflicts. Tombstones delete the hash whatever the policy. A delta cut short is
+// applied as far as it goes and returns an error, its marker must not be used
+func (kc *KDB) ApplyDelta(r io.Reader, policy ConflictPolicy) (DeltaReport, error) {
+	im := kc.newImporter()
+	im.checkConflicts = true
+	im.wholeRecords = true
+	im.onConflict = policy
+
+	deletes := make(map[uint64][][]byte)
+	var trailer *deltaTrailer
+	records := 0
+	err := scanLines(r, func(line int, text string) error {
+		if trailer != nil {
+			return fmt.Errorf("line %d: lines after the end of the delta", line)
+		}
+
+		var dl deltaLine
+		if err := json.Unmarshal([]byte(text), &dl); err != nil {
+			im.report.Lines++
+			im.invalid(line, err)
+			return nil
+		}
+		if dl.End {
+			trailer = &dl.deltaTrailer
+			return nil
+		}
+		records++
+		im.report.Lines++
+
+		if dl.Deleted {
+			if _, err := hex.DecodeString(dl.Sum); err != nil || dl.Sum == "" {
+				im.invalid(line, fmt.Errorf("invalid tombstone sum %q", dl.Sum))
+				return nil
+			}
+			deletes[dl.HashType] = append(deletes[dl.HashType], kc.keys.hashKey(dl.HashType, dl.Sum))
+			return nil
+		}
+
+		sh := &Hash{}
+		if err := json.Unmarshal([]byte(text), sh); err != nil {
+			im.invalid(line, err)
+			return nil
+		}
+		sh.db = kc
+		return im.add(line, sh)
+	})
+
+	var report DeltaReport
+	imported, flushErr := im.finish()
+	report.ImportReport = imported
+	if flushErr != nil {
+		kc.log(fmt.Sprintf("Failed to apply delta: %v", flushErr), Error)
+		return report, flushErr
+	}
+
+	report.Deleted, err = kc.applyTombstones(deletes, err)
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to apply delta: %v", err), Error)
+		return report, fmt.Errorf("failed to apply delta: %w", err)
+	}
+
+	switch {
+	case trailer == nil:
+		err = fmt.Errorf("delta ended after %d records without its trailer, it was cut short", records)
+	case trailer.Records != records:
+		err = fmt.Errorf("delta holds %d records, its trailer says %d", records, trailer.Records)
+	}
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to apply delta: %v", err), Error)
+		return report, err
+	}
+
+	report.Marker = trailer.Marker
+	kc.log(fmt.Sprintf("Applied a delta: %d hashes stored, %d deleted, %d conflicts", report.Imported, report.Deleted, report.Conflicts), Info)
+	return report, nil
+}
+
+// applyTombstones deletes the stored hashes among deletes. readErr is the error reading the delta
+// ended with, returned unless deleting fails
+func (kc *KDB) applyTombstones(deletes map[uint64][][]byte, readErr error) (int, error) {
+	deleted := 0
+	for _, hashType := range slices.Sorted(maps.Keys(deletes)) {
+		var stored [][]byte
+		err := kc.viewLocked(func(txn *badger.Txn) error {
+			for _, key := range deletes[hashType] {
+				if _, err := txn.Get(key); err == nil {
+					stored = append(stored, key)
+				} else if !isNotFound(err) {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return deleted, err
+		}
+
+		n, err := kc.deleteKeys(context.Background(), hashType, stored)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, readErr
+}
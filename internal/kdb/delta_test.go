@@ -0,0 +1,110 @@
+package kdb
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+)
+
+// deltaTypes are the hash types the delta tests store
+var deltaTypes = []uint64{0, NTLM}
+
+// diffDBs returns how the hashes of a and b differ, one line per hash, nil if they hold the same
+func diffDBs(a, b *KDB) []string {
+	records := func(db *KDB) map[string]string {
+		out := make(map[string]string)
+		for h := range db.GetHashesByHashTypes(deltaTypes) {
+			out[fmt.Sprintf("%d:%s", h.HashType, h.Hash)] = fmt.Sprintf("%q %v %q %v %v", h.Value, h.Meta, h.Session, h.CreatedAt.UnixNano(), h.CrackedAt.UnixNano())
+		}
+		return out
+	}
+	ra, rb := records(a), records(b)
+	var diff []string
+	for _, key := range slices.Sorted(maps.Keys(ra)) {
+		if got, ok := rb[key]; !ok {
+			diff = append(diff, "only in a: "+key)
+		} else if got != ra[key] {
+			diff = append(diff, fmt.Sprintf("%s: %s != %s", key, ra[key], got))
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(rb)) {
+		if _, ok := ra[key]; !ok {
+			diff = append(diff, "only in b: "+key)
+		}
+	}
+	for _, t := range deltaTypes {
+		na, _ := a.HashesByType(t)
+		nb, _ := b.HashesByType(t)
+		if na != nb {
+			diff = append(diff, fmt.Sprintf("hash type %d counts %d != %d", t, na, nb))
+		}
+	}
+	return diff
+}
+
+func TestDeltaReplicates(t *testing.T) {
+	a, b := newTestDB(t, testPrefix, nil), newTestDB(t, testPrefix, nil)
+	for i := range 40 {
+		h := NewHash(testHash(i), "", deltaTypes[i%2])
+		h.Meta = map[string]string{UserMetaKey: fmt.Sprintf("user%d", i)}
+		if _, err := a.StoreHash(h); err != nil {
+			t.Fatalf("store %d: %v", i, err)
+		}
+	}
+
+	var delta bytes.Buffer
+	marker, err := a.ExportDelta(&delta, "")
+	if err != nil {
+		t.Fatalf("full export: %v", err)
+	}
+	if report, err := b.ApplyDelta(&delta, ConflictOverwrite); err != nil || report.Imported != 40 {
+		t.Fatalf("applying the full export: %+v %v", report, err)
+	}
+	if diff := diffDBs(a, b); diff != nil {
+		t.Fatalf("after the full export:\n%v", diff)
+	}
+
+	// Crack some, delete some, store new ones and store one of the deleted again
+	for i := range 10 {
+		if err := a.MarkCracked(testHash(i), deltaTypes[i%2], fmt.Sprintf("Summer%d!", i)); err != nil {
+			t.Fatalf("MarkCracked %d: %v", i, err)
+		}
+	}
+	for i := 10; i < 20; i++ {
+		if err := a.DeleteHash(testHash(i), deltaTypes[i%2]); err != nil {
+			t.Fatalf("DeleteHash %d: %v", i, err)
+		}
+	}
+	for i := 40; i < 50; i++ {
+		if _, err := a.StoreHash(NewHash(testHash(i), "fresh", deltaTypes[i%2])); err != nil {
+			t.Fatalf("store %d: %v", i, err)
+		}
+	}
+	if _, err := a.StoreHash(NewHash(testHash(10), "back again", deltaTypes[0])); err != nil {
+		t.Fatalf("storing a deleted hash again: %v", err)
+	}
+
+	delta.Reset()
+	next, err := a.ExportDelta(&delta, marker)
+	if err != nil {
+		t.Fatalf("delta export: %v", err)
+	}
+	report, err := b.ApplyDelta(&delta, ConflictOverwrite)
+	if err != nil || report.Deleted != 9 || report.Marker != next {
+		t.Fatalf("applying the delta: %+v %v", report, err)
+	}
+	if diff := diffDBs(a, b); diff != nil {
+		t.Errorf("after the delta:\n%v", diff)
+	}
+
+	// Nothing changed since, the next delta is empty
+	delta.Reset()
+	if _, err := a.ExportDelta(&delta, next); err != nil {
+		t.Fatalf("empty delta export: %v", err)
+	}
+	if report, err := b.ApplyDelta(&delta, ConflictOverwrite); err != nil || report.Imported != 0 || report.Deleted != 0 {
+		t.Errorf("an empty delta applied %+v: %v", report, err)
+	}
+}
@@ -0,0 +1,136 @@
+package kdb
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// hashShape is what the hashes of a hashcat mode look like, see DetectHashTypes
+type hashShape struct {
+	hexLen   int      // Length of a plain hex digest, 0 for modes that aren't one
+	prefixes []string // Crypt prefixes, such as "$6$"
+	parse    func(hash string) bool
+}
+
+// hashShapes are the modes DetectHashTypes knows the shape of
+var hashShapes = map[uint64]hashShape{
+	0:     {hexLen: 32},  // md5
+	100:   {hexLen: 40},  // sha1
+	900:   {hexLen: 32},  // md4
+	NTLM:  {hexLen: 32},  // ntlm
+	1300:  {hexLen: 56},  // sha224
+	1400:  {hexLen: 64},  // sha256
+	1700:  {hexLen: 128}, // sha512
+	LM:    {hexLen: 32},  // lm
+	6000:  {hexLen: 40},  // ripemd160
+	10800: {hexLen: 96},  // sha384
+	17400: {hexLen: 64},  // sha3-256
+	17600: {hexLen: 128}, // sha3-512
+
+	500:  {prefixes: []string{"$1$"}},                  // md5crypt
+	1800: {prefixes: []string{"$6$"}},                  // sha512crypt
+	3200: {prefixes: []string{"$2a$", "$2b$", "$2y$"}}, // bcrypt
+	7400: {prefixes: []string{"$5$"}},                  // sha256crypt
+
+	NetNTLMv1:      {parse: func(hash string) bool { _, err := ParseChallengeResponse(hash, NetNTLMv1); return err == nil }},
+	NetNTLMv2:      {parse: func(hash string) bool { _, err := ParseChallengeResponse(hash, NetNTLMv2); return err == nil }},
+	KerberosTGSREP: {parse: func(hash string) bool { return parseTicket(hash, KerberosTGSREP) != nil }},
+	KerberosASREP:  {parse: func(hash string) bool { return parseTicket(hash, KerberosASREP) != nil }},
+}
+
+// fits returns true if hash looks like a hash of the shape. A salt after the digest, hash:salt,
+// doesn't count against it
+func (s hashShape) fits(hash string) bool {
+	switch {
+	case s.parse != nil:
+		return s.parse(hash)
+	case len(s.prefixes) > 0:
+		return slices.ContainsFunc(s.prefixes, func(prefix string) bool { return strings.HasPrefix(hash, prefix) })
+	}
+	digest, _, _ := strings.Cut(strings.TrimSpace(hash), ":")
+	return len(digest) == s.hexLen && isHex(digest)
+}
+
+// DetectHashTypes returns the hashcat modes a hash may be of, judged by its length, charset and
+// prefix, ordered by mode. A 32 character hex string is md5, md4, ntlm or lm. Only modes whose
+// shape is known are returned, see GetHashAnyType for the rest
+func DetectHashTypes(hash string) []uint64 {
+	var hashTypes []uint64
+	for _, hashType := range slices.Sorted(maps.Keys(hashShapes)) {
+		if hashShapes[hashType].fits(hash) {
+			hashTypes = append(hashTypes, hashType)
+		}
+	}
+	return hashTypes
+}
+
+// plausibleType returns true if hash may be a hash of hashType, always for modes of unknown shape
+func plausibleType(hash string, hashType uint64) bool {
+	shape, ok := hashShapes[hashType]
+	return !ok || shape.fits(hash)
+}
+
+// GetHashAnyType looks a hash of unknown type up under every registered type it may be of,
+// per DetectHashTypes, returning every match ordered by type. Registered modes whose shape isn't
+// known are always tried. It takes one point lookup per type tried, no scan, and an empty result
+// if no type holds the hash. Fallback resolvers aren't consulted
+func (kc *KDB) GetHashAnyType(hash string) (_ []*Hash, err error) {
+	defer kc.recoverPanic("lookup", &err)
+	registered, err := kc.getRegisteredHashTypes()
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to get registered hash types: %v", err), Error)
+		return nil, fmt.Errorf("failed to get registered hash types: %w", err)
+	}
+	slices.Sort(registered)
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	var found []*Hash
+	err = kc.c.View(func(txn *badger.Txn) error {
+		for _, hashType := range registered {
+			if !plausibleType(hash, hashType) {
+				continue
+			}
+
+			stored, err := kc.lookupType(txn, hash, hashType)
+			kc.countLookup(err)
+			if isNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			found = append(found, stored)
+		}
+		return nil
+	})
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to look up hash under every type: %v", err), Error)
+		return nil, fmt.Errorf("failed to look up hash: %w", err)
+	}
+	return found, nil
+}
+
+// lookupType reads the hash stored as hashType, see originalHashKey. Returns
+// badger.ErrKeyNotFound if there is none
+func (kc *KDB) lookupType(txn *badger.Txn, hash string, hashType uint64) (*Hash, error) {
+	key, err := kc.originalHashKey(txn, hash, hashType)
+	if err != nil {
+		return nil, err
+	}
+	item, err := txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := &Hash{}
+	err = item.Value(func(val []byte) error {
+		return kc.decodeHash(val, stored)
+	})
+	return stored, err
+}
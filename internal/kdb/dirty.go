@@ -0,0 +1,156 @@
+package kdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// dirtyTypePrefix is the meta key prefix of the dirty flags, followed by the hash type.
+// A flag is set before the first counted write to a hash type after open and cleared by Close,
+// so flags found on open name the types whose counters a crash may have left out of sync
+const dirtyTypePrefix = "dirty_type:"
+
+// markDirty sets the dirty flag of every hash type not flagged since open. It returns once the
+// flags are written, callers mark before writing so the flag always precedes the counter drift
+func (kc *KDB) markDirty(hashTypes ...uint64) error {
+	kc.dirtyMu.Lock()
+	defer kc.dirtyMu.Unlock()
+
+	var unmarked []uint64
+	for _, hashType := range hashTypes {
+		if !kc.dirty[hashType] {
+			unmarked = append(unmarked, hashType)
+		}
+	}
+	if len(unmarked) == 0 {
+		return nil
+	}
+
+	err := kc.updateLocked(func(txn *badger.Txn) error {
+		for _, hashType := range unmarked {
+			if err := txn.Set(kc.keys.dirtyTypeKey(hashType), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to mark hash types dirty: %v", err), Error)
+		return fmt.Errorf("failed to mark hash types dirty: %w", err)
+	}
+
+	if kc.dirty == nil {
+		kc.dirty = make(map[uint64]bool)
+	}
+	for _, hashType := range unmarked {
+		kc.dirty[hashType] = true
+	}
+	return nil
+}
+
+// clearDirty removes the dirty flags set since open. Close calls it with kc.mu held, after the
+// last counter update
+func (kc *KDB) clearDirty() error {
+	kc.dirtyMu.Lock()
+	defer kc.dirtyMu.Unlock()
+
+	if len(kc.dirty) == 0 {
+		return nil
+	}
+
+	err := kc.c.Update(func(txn *badger.Txn) error {
+		for hashType := range kc.dirty {
+			if err := txn.Delete(kc.keys.dirtyTypeKey(hashType)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	kc.dirty = nil
+	return nil
+}
+
+// DirtyHashTypes returns the hash types flagged dirty, those written to since the database was
+// last closed cleanly. After an unclean shutdown their counters may be off
+func (kc *KDB) DirtyHashTypes() ([]uint64, error) {
+	entries, err := kc.listMeta(dirtyTypePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	kc.dirtyMu.Lock()
+	defer kc.dirtyMu.Unlock()
+
+	var hashTypes []uint64
+	for key := range entries {
+		hashType, err := strconv.ParseUint(strings.TrimPrefix(key, dirtyTypePrefix), 10, 64)
+		if err != nil {
+			kc.log(fmt.Sprintf("Skipping malformed dirty flag %q", key), Warning)
+			continue
+		}
+		// Flags set by this session are expected, only leftovers point to a crash
+		if !kc.dirty[hashType] {
+			hashTypes = append(hashTypes, hashType)
+		}
+	}
+	return hashTypes, nil
+}
+
+// RecountDirtyHashTypes recounts the hash types left dirty by an unclean shutdown and the total,
+// then clears their flags. Open runs it when Options.RecountDirtyOnOpen is set.
+// Returns the hash types recounted
+func (kc *KDB) RecountDirtyHashTypes() ([]uint64, error) {
+	hashTypes, err := kc.DirtyHashTypes()
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to read dirty hash types: %v", err), Error)
+		return nil, fmt.Errorf("failed to read dirty hash types: %w", err)
+	}
+	if len(hashTypes) == 0 {
+		return nil, nil
+	}
+
+	kc.log(fmt.Sprintf("Recounting %d hash types left dirty by an unclean shutdown", len(hashTypes)), Warning)
+	for _, hashType := range hashTypes {
+		if err := kc.RecountHashType(hashType); err != nil {
+			return nil, err
+		}
+		if err := kc.DeleteMeta(dirtyTypePrefix + strconv.FormatUint(hashType, 10)); err != nil {
+			return nil, fmt.Errorf("failed to clear dirty flag of hash type %d: %w", hashType, err)
+		}
+	}
+	if err := kc.RecountCracked(hashTypes...); err != nil {
+		return nil, err
+	}
+
+	// Clean types are trusted, the total is their sum with the recounted ones
+	registered, err := kc.getRegisteredHashTypes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get registered hash types: %w", err)
+	}
+	total := 0
+	for _, hashType := range registered {
+		count, err := kc.HashesByType(hashType)
+		if err != nil && !isNotFound(err) {
+			return nil, fmt.Errorf("failed to read count for hash type %d: %w", hashType, err)
+		}
+		total += count
+	}
+	if err := kc.recordShardedCount(kc.keys.key(totalHashesKey), total); err != nil {
+		kc.log(fmt.Sprintf("Failed to update total hash count: %v", err), Error)
+		return nil, fmt.Errorf("failed to update total hash count: %w", err)
+	}
+
+	return hashTypes, nil
+}
+
+// dirtyTypeKey returns the storage key of the dirty flag of hashType
+func (ks keyspace) dirtyTypeKey(hashType uint64) []byte {
+	return []byte(ks.key(metaPrefix, dirtyTypePrefix+strconv.FormatUint(hashType, 10)))
+}
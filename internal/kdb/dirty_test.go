@@ -0,0 +1,73 @@
+package kdb
+
+import "testing"
+
+func TestCrashRecountsOnlyDirtyTypes(t *testing.T) {
+	dir := t.TempDir()
+	stored := map[uint64]int{0: 10, NTLM: 20, LM: 30}
+	db := newTestDBIn(t, dir, testPrefix, nil)
+	i := 0
+	for hashType, n := range stored {
+		for range n {
+			if _, err := db.StoreHash(NewHash(testHash(i), "", hashType)); err != nil {
+				t.Fatalf("store %d: %v", i, err)
+			}
+			i++
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("clean close: %v", err)
+	}
+
+	// Write to two of the types, then skew every counter as if writes were lost and crash: the
+	// engine goes away without Close clearing the dirty flags or writing the clean marker
+	db = newTestDBIn(t, dir, testPrefix, nil)
+	for _, hashType := range []uint64{0, NTLM} {
+		if _, err := db.StoreHash(NewHash(testHash(i), "", hashType)); err != nil {
+			t.Fatalf("store %d: %v", i, err)
+		}
+		stored[hashType]++
+		i++
+	}
+	for hashType := range stored {
+		if err := db.recordTypeCount(hashType, 1000); err != nil {
+			t.Fatalf("skewing the counter of %d: %v", hashType, err)
+		}
+	}
+	if err := db.c.Close(); err != nil {
+		t.Fatalf("crash: %v", err)
+	}
+
+	// The skew of the clean type stays, it was never written to and isn't read again
+	db = newTestDBIn(t, dir, testPrefix, nil)
+	if db.dirtyOpen == "" {
+		t.Errorf("the crashed database opened as cleanly closed")
+	}
+	if recounts := db.ops.recounts.Load(); recounts != 2 {
+		t.Errorf("%d hash types recounted, want 2", recounts)
+	}
+	want := map[uint64]int{0: stored[0], NTLM: stored[NTLM], LM: 1000}
+	for hashType, n := range want {
+		if got, err := db.HashesByType(hashType); err != nil || got != n {
+			t.Errorf("hash type %d counts %d, want %d: %v", hashType, got, n, err)
+		}
+	}
+	if total, err := db.TotalHashes(); err != nil || total != stored[0]+stored[NTLM]+1000 {
+		t.Errorf("the total counts %d: %v", total, err)
+	}
+	if dirty, err := db.DirtyHashTypes(); err != nil || len(dirty) != 0 {
+		t.Errorf("hash types %v still dirty: %v", dirty, err)
+	}
+
+	// The recount cleared the flags, a second crash without writes recounts nothing
+	if err := db.c.Close(); err != nil {
+		t.Fatalf("second crash: %v", err)
+	}
+	db = newTestDBIn(t, dir, testPrefix, nil)
+	if recounts := db.ops.recounts.Load(); recounts != 0 {
+		t.Errorf("%d hash types recounted after a crash without writes", recounts)
+	}
+	if dirty, err := db.DirtyHashTypes(); err != nil || len(dirty) != 0 {
+		t.Errorf("hash types %v dirty after a crash without writes: %v", dirty, err)
+	}
+}
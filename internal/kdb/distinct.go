@@ -0,0 +1,275 @@
+package kdb
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// distinctRunSize is how many value digests an exact count sorts in memory before spilling them
+// to a temporary run file, 32 MiB worth
+const distinctRunSize = 1 << 20
+
+// DistinctMode is how a distinct value count was produced
+type DistinctMode string
+
+const (
+	DistinctApproximate DistinctMode = "approximate" // HyperLogLog, within StdError of the true count
+	DistinctExact       DistinctMode = "exact"       // Sorted digests of every value
+)
+
+// DistinctOptions tunes DistinctValueCount
+type DistinctOptions struct {
+	// Exact counts precisely instead of estimating. Value digests are sorted in runs of a million
+	// and spilled to temporary files, plaintexts never touch the disk
+	Exact bool
+	// TempDir holds the run files of an exact count, the system temp directory if empty
+	TempDir string
+	// CaseInsensitive counts values differing only in Unicode case as one
+	CaseInsensitive bool
+}
+
+// DistinctCount is the number of distinct cracked values
+type DistinctCount struct {
+	Count    uint64       `json:"count"`     // Distinct values
+	Cracked  uint64       `json:"cracked"`   // Cracked hashes the values came from
+	Mode     DistinctMode `json:"mode"`      // How Count was produced
+	StdError float64      `json:"std_error"` // Relative standard error of Count, 0 when exact
+}
+
+// DistinctValueCount counts the distinct cracked values of hashType, or of every registered type
+// if it is nil, which is the number of passwords recovered as opposed to hashes cracked. Memory is
+// bounded either way: by default the count is a HyperLogLog estimate, with an Exact option digests
+// of the values are sorted on disk
+func (kc *KDB) DistinctValueCount(ctx context.Context, hashType *uint64, opts ...DistinctOptions) (DistinctCount, error) {
+	var o DistinctOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
+	var counter distinctCounter
+	if o.Exact {
+		counter = &exactDistinct{dir: o.TempDir}
+	} else {
+		counter = &approxDistinct{hll: util.NewHyperLogLog()}
+	}
+	defer counter.close()
+
+	var cracked uint64
+	err := kc.viewLocked(func(txn *badger.Txn) error {
+		var hashTypes []uint64
+		if hashType != nil {
+			hashTypes = []uint64{*hashType}
+		} else {
+			var err error
+			if hashTypes, err = kc.readHashTypes(txn); err != nil {
+				return fmt.Errorf("failed to get registered hash types: %w", err)
+			}
+		}
+
+		var err error
+		for _, ht := range hashTypes {
+			scanErr := kc.scan(txn, ht, "", nil, DefaultScanOptions(), func(hash *Hash) bool {
+				if err = ctx.Err(); err != nil {
+					return false
+				}
+				if hash.Value == "" {
+					return true
+				}
+				cracked++
+				value := hash.Value
+				if o.CaseInsensitive {
+					value = foldValue(value)
+				}
+				err = counter.add(value)
+				return err == nil
+			})
+			if scanErr != nil {
+				return scanErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err == nil {
+		var result DistinctCount
+		if result, err = counter.count(); err == nil {
+			result.Cracked = cracked
+			return result, nil
+		}
+	}
+	kc.log(fmt.Sprintf("Failed to count distinct values: %v", err), Error)
+	return DistinctCount{}, fmt.Errorf("failed to count distinct values: %w", err)
+}
+
+// distinctCounter counts distinct values for DistinctValueCount
+type distinctCounter interface {
+	add(value string) error
+	count() (DistinctCount, error)
+	close()
+}
+
+// approxDistinct estimates with a HyperLogLog sketch
+type approxDistinct struct {
+	hll *util.HyperLogLog
+}
+
+func (a *approxDistinct) add(value string) error {
+	a.hll.Add([]byte(value))
+	return nil
+}
+
+func (a *approxDistinct) count() (DistinctCount, error) {
+	return DistinctCount{Count: a.hll.Count(), Mode: DistinctApproximate, StdError: util.HLLStdError}, nil
+}
+
+func (a *approxDistinct) close() {}
+
+// exactDistinct sorts SHA-256 digests of the values in memory and spills sorted runs to temporary
+// files, counting merges them
+type exactDistinct struct {
+	dir  string
+	run  [][sha256.Size]byte
+	runs []*os.File
+}
+
+func (e *exactDistinct) add(value string) error {
+	e.run = append(e.run, sha256.Sum256([]byte(value)))
+	if len(e.run) >= distinctRunSize {
+		return e.spill()
+	}
+	return nil
+}
+
+// sortRun sorts the digests in memory and drops duplicates
+func (e *exactDistinct) sortRun() {
+	slices.SortFunc(e.run, func(a, b [sha256.Size]byte) int {
+		return bytes.Compare(a[:], b[:])
+	})
+	e.run = slices.Compact(e.run)
+}
+
+// spill writes the digests in memory to a new run file
+func (e *exactDistinct) spill() error {
+	e.sortRun()
+
+	f, err := os.CreateTemp(e.dir, "krkn-distinct-*.run")
+	if err != nil {
+		return err
+	}
+	e.runs = append(e.runs, f)
+
+	w := bufio.NewWriter(f)
+	for _, digest := range e.run {
+		if _, err := w.Write(digest[:]); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	e.run = e.run[:0]
+	return nil
+}
+
+func (e *exactDistinct) count() (DistinctCount, error) {
+	result := DistinctCount{Mode: DistinctExact}
+	if len(e.runs) == 0 {
+		e.sortRun()
+		result.Count = uint64(len(e.run))
+		return result, nil
+	}
+
+	if len(e.run) > 0 {
+		if err := e.spill(); err != nil {
+			return result, err
+		}
+	}
+
+	h := &runHeap{}
+	for _, f := range e.runs {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return result, err
+		}
+		r := &runReader{r: bufio.NewReader(f)}
+		ok, err := r.next()
+		if err != nil {
+			return result, err
+		}
+		if ok {
+			h.readers = append(h.readers, r)
+		}
+	}
+	heap.Init(h)
+
+	var last [sha256.Size]byte
+	for h.Len() > 0 {
+		r := h.readers[0]
+		if result.Count == 0 || r.head != last {
+			result.Count++
+			last = r.head
+		}
+		ok, err := r.next()
+		if err != nil {
+			return result, err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return result, nil
+}
+
+func (e *exactDistinct) close() {
+	for _, f := range e.runs {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+	e.runs = nil
+}
+
+// runReader reads the digests of a run file one by one
+type runReader struct {
+	r    *bufio.Reader
+	head [sha256.Size]byte
+}
+
+// next reads the next digest into head, false at the end of the run
+func (r *runReader) next() (bool, error) {
+	_, err := io.ReadFull(r.r, r.head[:])
+	if err == io.EOF {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// runHeap orders run readers by their head digest
+type runHeap struct {
+	readers []*runReader
+}
+
+func (h *runHeap) Len() int { return len(h.readers) }
+func (h *runHeap) Less(i, j int) bool {
+	return bytes.Compare(h.readers[i].head[:], h.readers[j].head[:]) < 0
+}
+func (h *runHeap) Swap(i, j int) { h.readers[i], h.readers[j] = h.readers[j], h.readers[i] }
+func (h *runHeap) Push(x any)    { h.readers = append(h.readers, x.(*runReader)) }
+func (h *runHeap) Pop() any {
+	r := h.readers[len(h.readers)-1]
+	h.readers = h.readers[:len(h.readers)-1]
+	return r
+}
@@ -0,0 +1,394 @@
+package kdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Stored values start with a format tag, with flagCompressed set when the rest is zstd compressed.
+// Legacy JSON records start with '{' and carry no tag
+const (
+	formatProto1 byte = 0x01 // protobuf wire format, see the field numbers below
+	formatLegacy byte = '{'  // JSON storedHash written before format tags
+)
+
+// Field numbers of the protobuf format. Numbers are never reused, decoders skip the ones they
+// don't know so records written by newer versions still read
+const (
+	fieldHash      protowire.Number = 1
+	fieldSum       protowire.Number = 2
+	fieldValue     protowire.Number = 3 // raw bytes, values don't need to be valid UTF-8
+	fieldHashType  protowire.Number = 4
+	fieldKey       protowire.Number = 5
+	fieldCreatedAt protowire.Number = 6 // Unix nanoseconds
+	fieldSalt      protowire.Number = 7
+	fieldMeta      protowire.Number = 8 // one entry message per key
+	fieldSession   protowire.Number = 9
+	fieldBinary    protowire.Number = 10
+	fieldSource    protowire.Number = 11
+	fieldSeq       protowire.Number = 12 // insertion sequence, see order.go
+	fieldCrackedAt protowire.Number = 13 // Unix nanoseconds
+	fieldCapture   protowire.Number = 14 // one per earlier challenge-response capture, most recent first
+	fieldOriginal  protowire.Number = 15 // the hash as submitted, only when it isn't the canonical form in fieldHash
+	fieldValueRef  protowire.Number = 16 // value dictionary id, in place of fieldValue for interned values
+
+	fieldEntryKey   protowire.Number = 1
+	fieldEntryValue protowire.Number = 2
+
+	fieldSourceWordlist protowire.Number = 1
+	fieldSourceRule     protowire.Number = 2
+	fieldSourceMask     protowire.Number = 3
+	fieldSourceTool     protowire.Number = 4
+)
+
+// errTruncated is returned for a protobuf record that ends in the middle of a field
+var errTruncated = errors.New("truncated hash record")
+
+// storedHash is the legacy JSON representation of a Hash in the database, still read for records
+// written before format tags. It is kept separate from the public JSON form so that either can
+// change without the other
+type storedHash struct {
+	Hash      string `json:"hash"`
+	Sum       []byte `json:"sum"`
+	Value     string `json:"value"`
+	ValueRaw  []byte `json:"value_raw,omitempty"` // Values that aren't valid UTF-8, which JSON strings can't hold
+	HashType  uint64 `json:"hash_type"`
+	Key       []byte `json:"key"`
+	CreatedAt int64  `json:"created_at,omitempty"` // Unix nanoseconds, absent in older records
+
+	Salt    string            `json:"salt,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+	Session string            `json:"session,omitempty"`
+	Binary  []byte            `json:"binary,omitempty"`
+	Source  *Source           `json:"source,omitempty"`
+}
+
+// encodeHash serializes a hash for storage in the current format
+func encodeHash(sh *Hash) ([]byte, error) {
+	b := make([]byte, 0, 64+len(sh.Hash)+len(sh.Value)+len(sh.Key))
+	b = append(b, formatProto1)
+
+	canonical := sh.Canonical()
+	b = appendString(b, fieldHash, canonical)
+	b = appendBytes(b, fieldSum, sh.sum)
+	if sh.valueRef != 0 {
+		b = protowire.AppendTag(b, fieldValueRef, protowire.VarintType)
+		b = protowire.AppendVarint(b, sh.valueRef)
+	} else {
+		b = appendString(b, fieldValue, sh.Value)
+	}
+	if sh.HashType != 0 {
+		b = protowire.AppendTag(b, fieldHashType, protowire.VarintType)
+		b = protowire.AppendVarint(b, sh.HashType)
+	}
+	b = appendBytes(b, fieldKey, sh.Key)
+	if !sh.CreatedAt.IsZero() {
+		b = protowire.AppendTag(b, fieldCreatedAt, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(sh.CreatedAt.UnixNano()))
+	}
+	b = appendString(b, fieldSalt, sh.Salt)
+	// Sorted so equal hashes always encode to equal bytes
+	keys := make([]string, 0, len(sh.Meta))
+	for k := range sh.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var entry []byte
+		entry = appendString(entry, fieldEntryKey, k)
+		entry = appendString(entry, fieldEntryValue, sh.Meta[k])
+		b = protowire.AppendTag(b, fieldMeta, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	b = appendString(b, fieldSession, sh.Session)
+	b = appendBytes(b, fieldBinary, sh.Binary)
+	if sh.Source != nil {
+		var src []byte
+		src = appendString(src, fieldSourceWordlist, sh.Source.Wordlist)
+		src = appendString(src, fieldSourceRule, sh.Source.Rule)
+		src = appendString(src, fieldSourceMask, sh.Source.Mask)
+		src = appendString(src, fieldSourceTool, sh.Source.Tool)
+		b = protowire.AppendTag(b, fieldSource, protowire.BytesType)
+		b = protowire.AppendBytes(b, src)
+	}
+	if sh.seq != 0 {
+		b = protowire.AppendTag(b, fieldSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, sh.seq)
+	}
+	if !sh.CrackedAt.IsZero() {
+		b = protowire.AppendTag(b, fieldCrackedAt, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(sh.CrackedAt.UnixNano()))
+	}
+	for _, capture := range sh.Captures {
+		b = protowire.AppendTag(b, fieldCapture, protowire.BytesType)
+		b = protowire.AppendString(b, capture)
+	}
+	if sh.Hash != canonical {
+		b = appendString(b, fieldOriginal, sh.Hash)
+	}
+
+	return b, nil
+}
+
+// decodeHash deserializes a hash read from storage in any format this version knows. Interned
+// values are left as the dictionary reference, see KDB.decodeHash
+func decodeHash(data []byte, sh *Hash) error {
+	if len(data) == 0 {
+		return errors.New("empty hash record")
+	}
+
+	if data[0]&flagCompressed != 0 {
+		var err error
+		if data, err = decompressRecord(data); err != nil {
+			return err
+		}
+	}
+
+	switch data[0] {
+	case formatProto1:
+		return decodeProto1(data[1:], sh)
+	case formatLegacy:
+		return decodeLegacy(data, sh)
+	default:
+		return fmt.Errorf("unknown hash record format 0x%02x", data[0])
+	}
+}
+
+// encodeHash serializes a hash for storage in this database, applying value normalization and
+// compression. The caller's hash is left unchanged
+func (kc *KDB) encodeHash(sh *Hash) ([]byte, error) {
+	data, _, err := kc.encodeRecord(sh)
+	return data, err
+}
+
+// decodeHash deserializes a hash read from this database, resolving an interned value and
+// applying Options.ValueDecoder, and binds it to the database
+func (kc *KDB) decodeHash(data []byte, sh *Hash) error {
+	if err := kc.decodeRawHash(data, sh); err != nil {
+		return err
+	}
+	return kc.decodeValue(sh)
+}
+
+// decodeRawHash is decodeHash leaving the value as stored, for code rewriting the stored form and
+// exports with RawValues
+func (kc *KDB) decodeRawHash(data []byte, sh *Hash) error {
+	if err := decodeHash(data, sh); err != nil {
+		return err
+	}
+	if err := kc.resolveValue(sh); err != nil {
+		return err
+	}
+	sh.db = kc
+	return nil
+}
+
+// decodeValue replaces the stored value of sh with what Options.ValueDecoder makes of it. Empty
+// values mean uncracked and are left alone
+func (kc *KDB) decodeValue(sh *Hash) error {
+	if kc.opts == nil || kc.opts.ValueDecoder == nil || sh.Value == "" {
+		return nil
+	}
+	value, err := kc.opts.ValueDecoder([]byte(sh.Value), sh)
+	if err != nil {
+		return &ValueDecodeError{Key: sh.Key, Err: err}
+	}
+	sh.Value = string(value)
+	return nil
+}
+
+// encodeValue returns value as Options.ValueEncoder stores it for sh, empty values are left alone
+func (kc *KDB) encodeValue(value string, sh *Hash) (string, error) {
+	if kc.opts == nil || kc.opts.ValueEncoder == nil || value == "" {
+		return value, nil
+	}
+	encoded, err := kc.opts.ValueEncoder([]byte(value), sh)
+	if err != nil {
+		return "", fmt.Errorf("value encoder failed for %s: %w", sh.Key, err)
+	}
+	return string(encoded), nil
+}
+
+// decodeProto1 decodes the fields of a formatProto1 record, skipping unknown ones. Records
+// without fieldOriginal, written before it or submitted in canonical form, get the canonical hash
+func decodeProto1(b []byte, sh *Hash) error {
+	*sh = Hash{}
+
+	var original string
+	err := eachField(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == fieldHashType && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			sh.HashType = v
+			return n, nil
+		case num == fieldCreatedAt && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			sh.CreatedAt = time.Unix(0, int64(v)).UTC()
+			return n, nil
+		case num == fieldSeq && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			sh.seq = v
+			return n, nil
+		case num == fieldValueRef && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			sh.valueRef = v
+			return n, nil
+		case num == fieldCrackedAt && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			sh.CrackedAt = time.Unix(0, int64(v)).UTC()
+			return n, nil
+		case typ != protowire.BytesType:
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, nil
+		}
+
+		switch num {
+		case fieldHash:
+			sh.Hash = string(v)
+		case fieldSum:
+			sh.sum = rawSum(v)
+		case fieldValue:
+			sh.Value = string(v)
+		case fieldKey:
+			sh.Key = append([]byte(nil), v...)
+		case fieldSalt:
+			sh.Salt = string(v)
+		case fieldMeta:
+			var k, val string
+			if err := eachString(v, func(num protowire.Number, s string) {
+				switch num {
+				case fieldEntryKey:
+					k = s
+				case fieldEntryValue:
+					val = s
+				}
+			}); err != nil {
+				return n, err
+			}
+			if sh.Meta == nil {
+				sh.Meta = make(map[string]string)
+			}
+			sh.Meta[k] = val
+		case fieldSession:
+			sh.Session = string(v)
+		case fieldBinary:
+			sh.Binary = append([]byte(nil), v...)
+		case fieldCapture:
+			sh.Captures = append(sh.Captures, string(v))
+		case fieldOriginal:
+			original = string(v)
+		case fieldSource:
+			sh.Source = &Source{}
+			if err := eachString(v, func(num protowire.Number, s string) {
+				switch num {
+				case fieldSourceWordlist:
+					sh.Source.Wordlist = s
+				case fieldSourceRule:
+					sh.Source.Rule = s
+				case fieldSourceMask:
+					sh.Source.Mask = s
+				case fieldSourceTool:
+					sh.Source.Tool = s
+				}
+			}); err != nil {
+				return n, err
+			}
+		}
+		return n, nil
+	})
+	if original != "" {
+		sh.Hash = original
+	}
+	return err
+}
+
+// decodeLegacy decodes a JSON storedHash
+func decodeLegacy(data []byte, sh *Hash) error {
+	var rec storedHash
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return err
+	}
+
+	*sh = Hash{
+		Hash:     rec.Hash,
+		sum:      rawSum(rec.Sum),
+		Value:    rec.Value,
+		HashType: rec.HashType,
+		Key:      rec.Key,
+		Salt:     rec.Salt,
+		Meta:     rec.Meta,
+		Session:  rec.Session,
+		Binary:   rec.Binary,
+		Source:   rec.Source,
+	}
+	if rec.CreatedAt != 0 {
+		sh.CreatedAt = time.Unix(0, rec.CreatedAt).UTC()
+	}
+	if len(rec.ValueRaw) > 0 {
+		sh.Value = string(rec.ValueRaw)
+	}
+	return nil
+}
+
+// eachField calls fn with the number, type and remaining bytes of every field in b.
+// fn returns how many bytes the field value took, negative for a malformed value
+func eachField(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errTruncated
+		}
+		b = b[n:]
+
+		n, err := fn(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return errTruncated
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// eachString calls fn for every length-delimited field of a nested message, skipping other fields
+func eachString(b []byte, fn func(num protowire.Number, s string)) error {
+	return eachField(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if typ != protowire.BytesType {
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n >= 0 {
+			fn(num, string(v))
+		}
+		return n, nil
+	})
+}
+
+// appendString appends a string field, leaving out empty strings
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendBytes appends a bytes field, leaving out empty slices
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
@@ -0,0 +1,134 @@
+package kdb
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// encodeFixture returns a hash with every field of the protobuf format set but fieldValueRef,
+// submitted in a form other than the canonical one
+func encodeFixture() *Hash {
+	h := &Hash{
+		Hash:      strings.ToUpper(testHash(0xbeef)),
+		Value:     "Winter2026!\xff",
+		HashType:  NTLM,
+		Key:       []byte("krkn:1000:" + SumOf(testHash(0xbeef))),
+		CreatedAt: time.Unix(0, 1767225600123456789).UTC(),
+		CrackedAt: time.Unix(0, 1767312000987654321).UTC(),
+		Salt:      "pepper",
+		Meta:      map[string]string{UserMetaKey: `CORP\jdoe`, "rid": "1104"},
+		Session:   "job-42",
+		Binary:    []byte{0, 1, 2, 0xff},
+		Source:    &Source{Wordlist: "rockyou.txt", Rule: "best64.rule", Mask: "?d?d", Tool: "hashcat"},
+		Captures:  []string{"older", "oldest"},
+		sum:       SumOfBytes(testHash(0xbeef)),
+		seq:       12345,
+	}
+	return h
+}
+
+// fieldNumbers returns the field numbers of the protobuf record data, in order
+func fieldNumbers(t *testing.T, data []byte) []protowire.Number {
+	t.Helper()
+	var nums []protowire.Number
+	err := eachField(data[1:], func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		nums = append(nums, num)
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	if err != nil {
+		t.Fatalf("the record doesn't parse: %v", err)
+	}
+	return nums
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	interned := encodeFixture()
+	interned.Value, interned.valueRef = "", 99
+
+	seen := make(map[protowire.Number]bool)
+	for _, want := range []*Hash{encodeFixture(), interned} {
+		data, err := encodeHash(want)
+		if err != nil || data[0] != formatProto1 {
+			t.Fatalf("encodeHash: %v", err)
+		}
+		for _, num := range fieldNumbers(t, data) {
+			seen[num] = true
+		}
+
+		var got Hash
+		if err := decodeHash(data, &got); err != nil {
+			t.Fatalf("decodeHash: %v", err)
+		}
+		if !reflect.DeepEqual(&got, want) {
+			t.Errorf("the hash came back as\n%+v\nwant\n%+v", got, *want)
+		}
+	}
+	for num := fieldHash; num <= fieldValueRef; num++ {
+		if !seen[num] {
+			t.Errorf("field %d was never written", num)
+		}
+	}
+}
+
+func TestDecodeSkipsUnknownFields(t *testing.T) {
+	want := encodeFixture()
+	data, err := encodeHash(want)
+	if err != nil {
+		t.Fatalf("encodeHash: %v", err)
+	}
+
+	// Fields of every wire type a newer version might add, before and after the known ones
+	var unknown []byte
+	unknown = protowire.AppendTag(unknown, 100, protowire.BytesType)
+	unknown = protowire.AppendString(unknown, "from the future")
+	unknown = protowire.AppendTag(unknown, 101, protowire.VarintType)
+	unknown = protowire.AppendVarint(unknown, 1<<40)
+	unknown = protowire.AppendTag(unknown, 102, protowire.Fixed32Type)
+	unknown = protowire.AppendFixed32(unknown, 7)
+	unknown = protowire.AppendTag(unknown, 103, protowire.Fixed64Type)
+	unknown = protowire.AppendFixed64(unknown, 8)
+	newer := slices.Concat(data[:1], unknown, data[1:], unknown)
+
+	var got Hash
+	if err := decodeHash(newer, &got); err != nil {
+		t.Fatalf("decodeHash: %v", err)
+	}
+	if !reflect.DeepEqual(&got, want) {
+		t.Errorf("the hash came back as\n%+v\nwant\n%+v", got, *want)
+	}
+
+	// A record cut off in a field doesn't decode
+	if err := decodeHash(newer[:len(newer)-3], &got); err == nil {
+		t.Errorf("a truncated record decoded")
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	h := encodeFixture()
+	legacy := storedHash{
+		Hash: h.Canonical(), Sum: hexSum(h.sum), ValueRaw: []byte(h.Value), HashType: h.HashType, Key: h.Key,
+		CreatedAt: h.CreatedAt.UnixNano(), Salt: h.Salt, Meta: h.Meta, Session: h.Session, Binary: h.Binary, Source: h.Source,
+	}
+	b.Run("proto1", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := encodeHash(h); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("json", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := json.Marshal(&legacy); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package kdb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+var (
+	// ErrNotInitialized is returned when an operation needs the default database and none is open
+	ErrNotInitialized = errors.New("krkn database is not initialized")
+
+	// ErrDatabaseLocked is returned by New when another process, or another KDB of this one, has the database open
+	ErrDatabaseLocked = errors.New("database is in use")
+
+	// ErrUnsupportedOptions is returned by New for options the platform can't satisfy, such as memory maps larger than a 32-bit address space
+	ErrUnsupportedOptions = errors.New("options not supported on this platform")
+
+	// ErrWrongKey is returned by New when the encryption key doesn't match the one the database was created with
+	ErrWrongKey = errors.New("wrong encryption key")
+
+	// ErrMigrationRequired is returned by New for a database with an older schema version unless Options.AutoMigrate is set
+	ErrMigrationRequired = errors.New("database migration required")
+
+	// ErrKeyPrefixMismatch is returned by New when Options.KeyPrefix differs from the prefix the database was created with
+	ErrKeyPrefixMismatch = errors.New("key prefix mismatch")
+
+	// ErrInvalidKeyPrefix is returned by New for an Options.KeyPrefix that can't start a key
+	ErrInvalidKeyPrefix = errors.New("invalid key prefix")
+
+	// ErrUnsupportedSchema is returned by New for a database written by a newer version of KrknDB
+	ErrUnsupportedSchema = errors.New("unsupported database schema version")
+
+	// ErrDirtyOpen is returned by New with Options.StrictOpen for a database that wasn't closed cleanly, such as a copy taken while it was open
+	ErrDirtyOpen = errors.New("database wasn't closed cleanly")
+
+	// ErrTooMuchContention is returned by a write that still conflicted with concurrent writes after Options.TxRetries retries
+	ErrTooMuchContention = errors.New("too much write contention")
+
+	// ErrTooManyIterators is reported by Tx.Err and Snapshot.Err for an iteration started while Options.MaxOpenIterators are open, it yields nothing
+	ErrTooManyIterators = errors.New("too many open iterators")
+
+	// ErrBatchTooLarge is returned by StoreHashes for a batch that would take the bytes buffered by batches past Options.MaxBatchBytes
+	ErrBatchTooLarge = errors.New("batch too large")
+
+	// ErrCompactionRunning is returned by CompactNow while another CompactNow is running
+	ErrCompactionRunning = errors.New("compaction already running")
+
+	// ErrOperationInProgress is returned by StartOperation while a conflicting operation is running
+	ErrOperationInProgress = errors.New("conflicting operation in progress")
+
+	// ErrOperationNotFound is returned for operation IDs the operation manager doesn't know
+	ErrOperationNotFound = errors.New("operation not found")
+
+	// ErrWarmupRunning is returned by Warmup while another warmup is running
+	ErrWarmupRunning = errors.New("warmup already running")
+
+	// ErrSnapshotReleased is returned by reads through a Snapshot after Release
+	ErrSnapshotReleased = errors.New("snapshot released")
+
+	// ErrValueIndexDisabled is returned by value index maintenance when Options.ValueIndex and
+	// Options.ValueIndexFolded are off
+	ErrValueIndexDisabled = errors.New("value index disabled")
+
+	// ErrQuotaExceeded is returned when a store would take a scope past its quota
+	ErrQuotaExceeded = errors.New("quota exceeded")
+
+	// ErrEmptyHash is returned when a hash has no hash string
+	ErrEmptyHash = errors.New("hash is empty")
+
+	// ErrHashTooLarge is returned when a hash string is longer than Options.MaxHashSize
+	ErrHashTooLarge = errors.New("hash too large")
+
+	// ErrValueTooLarge is returned when a value is larger than Options.MaxValueSize
+	ErrValueTooLarge = errors.New("value too large")
+
+	// ErrInvalidHash is returned by strict validation for a hash string that isn't valid UTF-8 or
+	// a digest of the wrong length for its type
+	ErrInvalidHash = errors.New("invalid hash")
+
+	// ErrInvalidHashType is returned by strict validation for a hash type outside the hashcat range
+	ErrInvalidHashType = errors.New("invalid hash type")
+
+	// ErrMetadataTooLarge is returned when a hash carries more metadata than allowed
+	ErrMetadataTooLarge = errors.New("metadata too large")
+
+	// ErrWordlistNotFound is returned for a wordlist name that was never imported
+	ErrWordlistNotFound = errors.New("wordlist not found")
+
+	// ErrVerificationUnsupported is returned by VerifyCrack for a hash type it can't compute locally
+	ErrVerificationUnsupported = errors.New("verification not supported for this hash type")
+
+	// ErrUnknownFormat is returned by IngestStream and ParseFormat for a format that isn't one of the Format constants
+	ErrUnknownFormat = errors.New("unknown import format")
+
+	// ErrRedactionUnsupported is returned by an exporter that can't write the requested redaction
+	ErrRedactionUnsupported = errors.New("redaction mode not supported by this export format")
+
+	// ErrFindIncomplete is returned by FindHashesChunked when some inputs weren't searched, see FindIncompleteError
+	ErrFindIncomplete = errors.New("find incomplete")
+
+	// ErrDatabaseNotEmpty is returned by Restore into a database that already holds hashes
+	ErrDatabaseNotEmpty = errors.New("database not empty")
+
+	// ErrOperationTimeout is returned by a lookup or write whose deadline passed, see Options.DefaultOpTimeout
+	ErrOperationTimeout = errors.New("operation timed out")
+
+	// ErrInvalidMarker is returned for a delta marker that is malformed, from another database or ahead of it
+	ErrInvalidMarker = errors.New("invalid delta marker")
+
+	// ErrForeignDatabase is returned by New for a directory holding another application's badger data, see Options.AdoptForeignDB
+	ErrForeignDatabase = errors.New("foreign database")
+
+	// ErrValueDecode is returned for a stored value Options.ValueDecoder rejected, see ValueDecodeError
+	ErrValueDecode = errors.New("value decode failed")
+
+	// ErrInternalPanic is returned by a call that panicked inside the database, see PanicError
+	ErrInternalPanic = errors.New("internal panic")
+
+	// ErrInvalidSum is returned by GetHashBySum for a sum that is neither 64 hex characters nor 32 raw bytes
+	ErrInvalidSum = errors.New("invalid sum")
+)
+
+// QuotaExceededError identifies the quota that rejected a store.
+// errors.Is(err, ErrQuotaExceeded) reports true for it
+type QuotaExceededError struct {
+	Scope   QuotaScope
+	Current uint64
+	Limit   uint64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota exceeded for %s: %d of %d used", e.Scope, e.Current, e.Limit)
+}
+
+func (e *QuotaExceededError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// FindIncompleteError lists the inputs FindHashesChunked didn't search, pass them to it again to
+// search just those. errors.Is(err, ErrFindIncomplete) reports true for it, as does errors.Is for
+// the error of the chunk that failed first
+type FindIncompleteError struct {
+	Unsearched []InputRange // Input ranges not searched, in input order
+	Err        error        // Why the first of them wasn't
+}
+
+func (e *FindIncompleteError) Error() string {
+	ranges := make([]string, len(e.Unsearched))
+	n := 0
+	for i, r := range e.Unsearched {
+		ranges[i] = r.String()
+		n += r.Len()
+	}
+	return fmt.Sprintf("find incomplete: %d inputs not searched in %s: %v", n, strings.Join(ranges, ", "), e.Err)
+}
+
+func (e *FindIncompleteError) Unwrap() []error {
+	return []error{ErrFindIncomplete, e.Err}
+}
+
+// ValueDecodeError identifies the hash whose stored value Options.ValueDecoder rejected.
+// errors.Is(err, ErrValueDecode) reports true for it, as does errors.Is for the decoder's error
+type ValueDecodeError struct {
+	Key []byte // Key of the hash
+	Err error  // What the decoder returned
+}
+
+func (e *ValueDecodeError) Error() string {
+	return fmt.Sprintf("value decode failed for %s: %v", e.Key, e.Err)
+}
+
+func (e *ValueDecodeError) Unwrap() []error {
+	return []error{ErrValueDecode, e.Err}
+}
+
+// isNotFound reports whether err means a key does not exist
+func isNotFound(err error) bool {
+	return errors.Is(err, badger.ErrKeyNotFound)
+}
@@ -0,0 +1,110 @@
+package kdb
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+
+	"github.com/dgraph-io/badger/v4/y"
+)
+
+// CountEstimate is an approximate count of the hashes of a hash type read from badger's table
+// metadata. Tables count every version and deletion marker they hold and writes still in the
+// memtables aren't in any table yet, so even the whole table part is only as exact as the last
+// compaction and memtable flush
+type CountEstimate struct {
+	Count       uint64  `json:"count"`        // Whole plus partial, rounded
+	WholeTables uint64  `json:"whole_tables"` // Keys of tables that only hold hashes of the type
+	Partial     uint64  `json:"partial"`      // Share of tables that also hold other keys, interpolated from their key ranges
+	Accuracy    float64 `json:"accuracy"`     // Fraction of Count from whole tables, 1 when nothing was interpolated
+}
+
+// EstimateCount returns an approximate number of hashes of hashType in milliseconds, without
+// scanning keys or trusting the counters. See EstimateCountDetail for how it was arrived at
+func (kc *KDB) EstimateCount(hashType uint64) (uint64, error) {
+	est, err := kc.EstimateCountDetail(hashType)
+	return est.Count, err
+}
+
+// EstimateCountDetail estimates the hashes of hashType from the key counts of badger's tables.
+// Tables holding nothing but hashes of the type count in full. Tables that also hold other hash
+// types are split between the registered types they overlap by how much of each type's sum range
+// they cover, which works since stored hash keys end in evenly spread hex sums. Tables flushed
+// straight from the memtables mix everything written in between, so the estimate is rough until
+// compaction has sorted them into levels, Accuracy shows how much of it is interpolated
+func (kc *KDB) EstimateCountDetail(hashType uint64) (CountEstimate, error) {
+	if kc.c == nil || kc.c.IsClosed() {
+		return CountEstimate{}, ErrNotInitialized
+	}
+
+	hashTypes, err := kc.getRegisteredHashTypes()
+	if err != nil {
+		return CountEstimate{}, fmt.Errorf("failed to get registered hash types: %w", err)
+	}
+	if !slices.Contains(hashTypes, hashType) {
+		return CountEstimate{Accuracy: 1}, nil
+	}
+
+	prefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+
+	var est CountEstimate
+	var partial float64
+	for _, t := range kc.c.Tables() {
+		left, right := y.ParseKey(t.Left), y.ParseKey(t.Right)
+		if bytes.HasPrefix(left, prefix) && bytes.HasPrefix(right, prefix) {
+			est.WholeTables += uint64(t.KeyCount)
+			continue
+		}
+
+		own := sumCoverage(left, right, prefix)
+		if own == 0 {
+			continue
+		}
+		all := 0.0
+		for _, ht := range hashTypes {
+			all += sumCoverage(left, right, []byte(kc.keys.key(hashTypeScanPrefix, ht)))
+		}
+		partial += float64(t.KeyCount) * own / all
+	}
+
+	est.Partial = uint64(math.Round(partial))
+	est.Count = est.WholeTables + est.Partial
+	est.Accuracy = 1
+	if est.Count > 0 {
+		est.Accuracy = float64(est.WholeTables) / float64(est.Count)
+	}
+	return est, nil
+}
+
+// sumCoverage returns the fraction of the sum range of prefix, from 0 to 1, that the key range
+// [left, right] covers
+func sumCoverage(left, right, prefix []byte) float64 {
+	return math.Max(0, sumPosition(right, prefix)-sumPosition(left, prefix))
+}
+
+// sumPosition places key in the sum range of prefix: 0 for keys sorting before the range, 1 for
+// keys after it, and the value of the leading sum digits for keys in it
+func sumPosition(key, prefix []byte) float64 {
+	if !bytes.HasPrefix(key, prefix) {
+		if bytes.Compare(key, prefix) < 0 {
+			return 0
+		}
+		return 1
+	}
+
+	// 13 hex digits fill the 52 bit mantissa of a float64
+	var v uint64
+	digits := key[len(prefix):]
+	for i := 0; i < 13; i++ {
+		var d uint64
+		if i < len(digits) {
+			if n, err := strconv.ParseUint(string(digits[i]), 16, 8); err == nil {
+				d = n
+			}
+		}
+		v = v<<4 | d
+	}
+	return float64(v) / float64(uint64(1)<<52)
+}
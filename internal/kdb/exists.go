@@ -0,0 +1,177 @@
+package kdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// existsCheckInterval is how many keys ExistsMany visits between checks of its context
+const existsCheckInterval = 4096
+
+// existsSum is one sum an input of ExistsMany may be stored under
+type existsSum struct {
+	sum   string
+	index int // Position of the input in the hashes passed to ExistsMany
+}
+
+// Exists returns true if the hash is stored as hashType. Only the key is looked up, the value is
+// neither fetched nor decrypted, except for challenge-response captures: their account record
+// must hold them. Fallback resolvers aren't consulted.
+// Bounded by Options.DefaultOpTimeout, see ExistsContext
+func (kc *KDB) Exists(originalHash string, hashType uint64) (bool, error) {
+	return withDefaultTimeout(kc, func(ctx context.Context) (bool, error) {
+		return kc.ExistsContext(ctx, originalHash, hashType)
+	})
+}
+
+// ExistsContext is Exists bounded by ctx, returning ErrOperationTimeout once its deadline passes.
+// The lookup can't be interrupted and finishes in the background
+func (kc *KDB) ExistsContext(ctx context.Context, originalHash string, hashType uint64) (bool, error) {
+	return awaitRead(kc, ctx, "exists", func() (bool, error) {
+		return kc.exists(originalHash, hashType)
+	})
+}
+
+// exists looks the key of a hash up
+func (kc *KDB) exists(originalHash string, hashType uint64) (bool, error) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	err := kc.c.View(func(txn *badger.Txn) error {
+		_, err := kc.originalHashKey(txn, originalHash, hashType)
+		return err
+	})
+
+	kc.countLookup(err)
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up hash: %w", err)
+	}
+	return true, nil
+}
+
+// ExistsMany reports for every hash whether it is stored as hashType, found[i] for hashes[i],
+// without fetching or decrypting a single value. Few hashes in a large type are looked up one by
+// one, where badger's bloom filters answer most misses without reading a table, more are merged
+// in key order against a keys-only scan of the type, see FindHashes. Memory use is linear in the
+// number of hashes. Challenge-response captures are looked up one by one like Exists does, their
+// account record must hold them. Fallback resolvers aren't consulted
+func (kc *KDB) ExistsMany(ctx context.Context, hashes []string, hashType uint64) ([]bool, error) {
+	found := make([]bool, len(hashes))
+	if len(hashes) == 0 {
+		return found, nil
+	}
+	wanted, captures := kc.existsSums(hashes, hashType)
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	kc.ops.iterations.Add(1)
+
+	err := kc.c.View(func(txn *badger.Txn) error {
+		for _, i := range captures {
+			if _, err := kc.originalHashKey(txn, hashes[i], hashType); !isNotFound(err) {
+				if err != nil {
+					return err
+				}
+				found[i] = true
+			}
+		}
+		if len(wanted) == 0 {
+			return ctx.Err()
+		}
+
+		plan, err := kc.planFind(txn, hashType, len(wanted), scanOptions(nil))
+		if err != nil {
+			return err
+		}
+		if plan.Strategy == FindPointLookups {
+			return kc.existsLookups(ctx, txn, hashType, wanted, found)
+		}
+		return kc.existsScan(ctx, txn, hashType, wanted, found)
+	})
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to check %d hashes of type %d: %v", len(hashes), hashType, err), Error)
+		return nil, fmt.Errorf("failed to check hashes of type %d: %w", hashType, err)
+	}
+	return found, nil
+}
+
+// existsSums returns every sum the hashes may be stored under, see lookupSums, sorted by sum, and
+// the positions of the challenge-response captures, which the key alone can't answer
+func (kc *KDB) existsSums(hashes []string, hashType uint64) ([]existsSum, []int) {
+	wanted := make([]existsSum, 0, len(hashes))
+	var captures []int
+	for i, hash := range hashes {
+		if parseCapture(normalizeHash(hash), hashType) != nil {
+			captures = append(captures, i)
+			continue
+		}
+		for _, sum := range kc.lookupSums(hash, hashType) {
+			wanted = append(wanted, existsSum{sum: sum, index: i})
+		}
+	}
+	slices.SortFunc(wanted, func(a, b existsSum) int {
+		return strings.Compare(a.sum, b.sum)
+	})
+	return wanted, captures
+}
+
+// existsLookups marks the wanted sums stored in txn found with one Get each
+func (kc *KDB) existsLookups(ctx context.Context, txn *badger.Txn, hashType uint64, wanted []existsSum, found []bool) error {
+	for i, w := range wanted {
+		if i%existsCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if found[w.index] {
+			continue
+		}
+
+		_, err := txn.Get(kc.keys.hashKey(hashType, w.sum))
+		if err == nil {
+			found[w.index] = true
+		} else if !isNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// existsScan marks the wanted sums stored in txn found by walking the keys of the type from the
+// smallest wanted sum to the largest alongside them
+func (kc *KDB) existsScan(ctx context.Context, txn *badger.Txn, hashType uint64, wanted []existsSum, found []bool) error {
+	prefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false // The key alone answers
+
+	it := kc.newIterator(txn, opts)
+	defer it.Close()
+
+	next, visited := 0, 0
+	for it.Seek(append(bytes.Clone(prefix), wanted[0].sum...)); it.ValidForPrefix(prefix) && next < len(wanted); it.Next() {
+		if visited++; visited%existsCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		sum := it.Item().Key()[len(prefix):]
+		for next < len(wanted) && wanted[next].sum < string(sum) {
+			next++
+		}
+		for ; next < len(wanted) && wanted[next].sum == string(sum); next++ {
+			found[wanted[next].index] = true
+		}
+	}
+	return ctx.Err()
+}
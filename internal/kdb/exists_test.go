@@ -0,0 +1,74 @@
+package kdb
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// existsFixture stores n hashes and returns m candidates, every other one of them stored
+func existsFixture(tb testing.TB, n, m int) (*KDB, []string) {
+	tb.Helper()
+	db := newTestDB(tb, testPrefix, nil)
+	batch := make([]*Hash, 0, 5000)
+	for i := range n {
+		batch = append(batch, NewHash(testHash(2*i), "", 0))
+		if len(batch) == cap(batch) || i == n-1 {
+			if _, err := db.StoreHashes(batch); err != nil {
+				tb.Fatalf("store: %v", err)
+			}
+			batch = batch[:0]
+		}
+	}
+	candidates := make([]string, m)
+	for i := range candidates {
+		candidates[i] = testHash(i)
+	}
+	return db, candidates
+}
+
+func TestExistsManyAgreesWithExists(t *testing.T) {
+	db, candidates := existsFixture(t, 2000, 4000)
+	ctx := context.Background()
+
+	// The few are looked up, the many are scanned for
+	for _, n := range []int{20, len(candidates)} {
+		want := make([]bool, n)
+		for i, hash := range candidates[:n] {
+			ok, err := db.Exists(hash, 0)
+			if err != nil {
+				t.Fatalf("Exists: %v", err)
+			}
+			if want[i] = ok; ok != (i%2 == 0) {
+				t.Fatalf("Exists of candidate %d returned %v", i, ok)
+			}
+		}
+		got, err := db.ExistsMany(ctx, candidates[:n], 0)
+		if err != nil || !slices.Equal(got, want) {
+			t.Errorf("ExistsMany of %d candidates disagrees with Exists: %v", n, err)
+		}
+	}
+}
+
+func BenchmarkExistsMany(b *testing.B) {
+	db, candidates := existsFixture(b, 20000, 40000)
+	for _, n := range []int{200, len(candidates)} {
+		b.Run(fmt.Sprintf("ExistsMany/%d", n), func(b *testing.B) {
+			for b.Loop() {
+				if _, err := db.ExistsMany(context.Background(), candidates[:n], 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("Exists/%d", n), func(b *testing.B) {
+			for b.Loop() {
+				for _, hash := range candidates[:n] {
+					if _, err := db.Exists(hash, 0); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
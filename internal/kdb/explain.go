@@ -0,0 +1,235 @@
+package kdb
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// findLookupCost is roughly how many keys a point lookup touches on its way down the LSM tree,
+// FindHashes looks hashes up one by one while that costs less than scanning the type
+const findLookupCost = 20
+
+// FindStrategy is how FindHashes reads the hashes searched for
+type FindStrategy string
+
+const (
+	FindPointLookups FindStrategy = "point lookups" // One Get per searched hash, for few hashes in a large type
+	FindScan         FindStrategy = "scan"          // One scan of the type, filtering keys by the searched sums
+)
+
+// FindPlan is what a FindHashes call did, as returned by ExplainFind
+type FindPlan struct {
+	Strategy      FindStrategy  `json:"strategy"`
+	HashType      uint64        `json:"hash_type"`
+	Searched      int           `json:"searched"`       // Distinct hashes searched for
+	TypeCount     int           `json:"type_count"`     // Hashes of the type, from the counters
+	EstimatedKeys int           `json:"estimated_keys"` // Keys the strategy was expected to visit, from the counters
+	Indexes       []string      `json:"indexes"`        // Key spaces read
+	KeysVisited   int           `json:"keys_visited"`   // Keys actually visited, index entries included
+	Found         int           `json:"found"`
+	Duration      time.Duration `json:"duration"`
+}
+
+// String describes the plan on one line
+func (p FindPlan) String() string {
+	return fmt.Sprintf("find %d hashes of type %d via %s over %s: estimated %d keys of %d, visited %d, found %d in %s",
+		p.Searched, p.HashType, p.Strategy, strings.Join(p.Indexes, ", "), p.EstimatedKeys, p.TypeCount,
+		p.KeysVisited, p.Found, p.Duration)
+}
+
+// ExplainFind runs FindHashes with the same arguments, discarding the hashes, and returns the
+// strategy it chose with the keys it expected to visit and what it actually did. Use it to see
+// why a search is slow
+func (kc *KDB) ExplainFind(possibleHashes []string, hashType uint64, scanOpts ...*ScanOptions) (FindPlan, error) {
+	so := *scanOptions(scanOpts)
+	so.visited = new(int)
+
+	plan := FindPlan{HashType: hashType}
+	if len(possibleHashes) == 0 {
+		plan.Strategy = FindPointLookups
+		plan.Indexes = []string{"hash keys"}
+		return plan, nil
+	}
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	kc.ops.iterations.Add(1)
+
+	start := time.Now()
+	targets := kc.findSums(possibleHashes, hashType)
+	err := kc.c.View(func(txn *badger.Txn) error {
+		var err error
+		if plan, err = kc.planFind(txn, hashType, len(targets.sums), &so); err != nil {
+			return err
+		}
+		return kc.find(txn, plan.Strategy, hashType, targets, &so, func(*Hash) bool {
+			plan.Found++
+			return true
+		})
+	})
+	plan.KeysVisited = *so.visited
+	plan.Duration = time.Since(start)
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to explain search of hash type %d: %v", hashType, err), Error)
+		return plan, fmt.Errorf("failed to explain search of hash type %d: %w", hashType, err)
+	}
+	return plan, nil
+}
+
+// findTargets are the hashes a search looks for in hashType
+type findTargets struct {
+	sums     map[string]string // Every sum they may be stored under, to the normalized hash, see lookupSums
+	captures map[string]bool   // The normalized hashes, if any is a challenge-response capture
+}
+
+// holds returns true if stored, found under one of the sums, holds a searched hash. Account
+// records are shared by the captures of an account and must hold one of those searched, see
+// holdsCapture
+func (t *findTargets) holds(stored *Hash) bool {
+	if t.captures == nil || t.captures[normalizeHash(stored.Hash)] {
+		return true
+	}
+	return slices.ContainsFunc(stored.Captures, func(c string) bool {
+		return t.captures[normalizeHash(c)]
+	})
+}
+
+// filter wraps yield to pass over the records that hold no searched capture and returns the
+// options to read them with: values are read so their captures can be compared, and the limit
+// applies to what yield gets
+func (t *findTargets) filter(so *ScanOptions, yield func(*Hash) bool) (func(*Hash) bool, *ScanOptions) {
+	read := *so
+	read.KeysOnly, read.Limit = false, 0
+
+	yielded := 0
+	return func(stored *Hash) bool {
+		if !t.holds(stored) {
+			return true
+		}
+		if !yield(stored) {
+			return false
+		}
+		yielded++
+		return so.Limit <= 0 || yielded < so.Limit
+	}, &read
+}
+
+// findSums returns the targets of a search for possibleHashes
+func (kc *KDB) findSums(possibleHashes []string, hashType uint64) *findTargets {
+	t := &findTargets{sums: make(map[string]string, len(possibleHashes))}
+	for _, hashStr := range possibleHashes {
+		normalized := canonicalHash(hashStr, hashType)
+		if t.captures == nil && parseCapture(normalized, hashType) != nil {
+			t.captures = make(map[string]bool, len(possibleHashes))
+		}
+		for _, sum := range kc.lookupSums(normalized, hashType) {
+			t.sums[sum] = normalized
+		}
+	}
+	if t.captures != nil {
+		for _, normalized := range t.sums {
+			t.captures[normalized] = true
+		}
+	}
+	return t
+}
+
+// planFind picks the strategy for searching searched sums of hashType. Point lookups pay off
+// while they touch fewer keys than a scan of the type, they can't yield insertion orders
+func (kc *KDB) planFind(txn *badger.Txn, hashType uint64, searched int, so *ScanOptions) (FindPlan, error) {
+	count, err := kc.readTypeCount(txn, hashType)
+	if err != nil && !isNotFound(err) {
+		return FindPlan{}, fmt.Errorf("failed to read count: %w", err)
+	}
+
+	plan := FindPlan{
+		Strategy:      FindScan,
+		HashType:      hashType,
+		Searched:      searched,
+		TypeCount:     count,
+		EstimatedKeys: count,
+		Indexes:       []string{"hash keys"},
+	}
+	switch {
+	case so.Order.insertion():
+		// Every index entry plus the records of the matches
+		plan.Indexes = []string{"insertion index", "hash keys"}
+		plan.EstimatedKeys = count + searched
+	case searched*findLookupCost < count:
+		plan.Strategy = FindPointLookups
+		plan.EstimatedKeys = searched
+	}
+	return plan, nil
+}
+
+// find yields the hashes of hashType stored under the sums of targets, and holding one of them,
+// using strategy
+func (kc *KDB) find(txn *badger.Txn, strategy FindStrategy, hashType uint64, targets *findTargets, so *ScanOptions, yield func(*Hash) bool) error {
+	sumMap := targets.sums
+	if targets.captures != nil {
+		yield, so = targets.filter(so, yield)
+	}
+
+	if strategy == FindScan {
+		return kc.scan(txn, hashType, "", func(hexSum []byte) (string, bool) {
+			original, ok := sumMap[string(hexSum)]
+			return original, ok
+		}, so, yield)
+	}
+
+	// Looked up in key order so the results come out as a scan would yield them
+	sums := slices.Sorted(maps.Keys(sumMap))
+	if so.Order.reverse() {
+		slices.Reverse(sums)
+	}
+
+	typePrefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+	yielded, skipped := 0, 0
+	defer func() { kc.reportSkipped(hashType, skipped) }()
+	for _, sum := range sums {
+		if so.After != nil {
+			cmp := strings.Compare(sum, so.After.Sum())
+			if cmp == 0 || (cmp < 0) != so.Order.reverse() {
+				continue
+			}
+		}
+
+		key := append(bytes.Clone(typePrefix), sum...)
+		if err := so.visit(); err != nil {
+			return err
+		}
+		item, err := txn.Get(key)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		hash := kc.keyOnlyHash(key, typePrefix, hashType)
+		hash.Hash = sumMap[sum]
+		if !so.KeysOnly {
+			hash = &Hash{}
+			if err := item.Value(func(val []byte) error {
+				return kc.decodeHash(val, hash)
+			}); err != nil {
+				kc.skip(so, &skipped, key, err)
+				continue
+			}
+		}
+		if !yield(hash) {
+			return nil
+		}
+		yielded++
+		if so.Limit > 0 && yielded >= so.Limit {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package kdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+)
+
+// csvHeader is the header row written by ExportCSV and recognized by ImportCSV
+var csvHeader = []string{"hash", "salt", "value", "type"}
+
+// csvHashedOnlyHeader is the header row of a CSV export with RedactHashedOnly
+var csvHashedOnlyHeader = []string{"hash", "salt", "cracked", "type"}
+
+// ExportJSONL writes every hash of hashType to w, one JSON object per line.
+// Lines use the same encoding as Hash.MarshalJSON so the two can't drift apart.
+// RedactHashedOnly replaces the value with a cracked flag. Returns the number of hashes written
+func (kc *KDB) ExportJSONL(w io.Writer, hashType uint64, opts ...*ExportOptions) (int, error) {
+	o := exportOptions(opts)
+	bw := bufio.NewWriter(w)
+
+	ex := kc.newExporter(o)
+	err := ex.each(hashType, func(hash *Hash) (int, error) {
+		line, err := o.marshalJSON(hash)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal hash: %w", err)
+		}
+
+		line = append(line, '\n')
+		if _, err := bw.Write(line); err != nil {
+			return 0, fmt.Errorf("failed to write hash: %w", err)
+		}
+		return len(line), nil
+	})
+	return ex.finish(err, bw.Flush)
+}
+
+// ExportPotfile writes every cracked hash of hashType to w in hashcat potfile format (hash:plain).
+// Salted hashes are written as hash:salt:plain. Plaintexts that would break the line format are
+// written as $HEX[...], see util.EncodeHexPlain. RedactHashedOnly writes the cracked hashes without
+// their plaintexts, RedactMasked returns ErrRedactionUnsupported since a potfile of masked plaintexts
+// would be read back as real ones. Returns the number of hashes written
+func (kc *KDB) ExportPotfile(w io.Writer, hashType uint64, opts ...*ExportOptions) (int, error) {
+	o := exportOptions(opts)
+	if o.Redaction == RedactMasked {
+		return 0, fmt.Errorf("%w: potfiles can't hold masked plaintexts", ErrRedactionUnsupported)
+	}
+
+	bw := bufio.NewWriter(w)
+
+	ex := kc.newExporter(o)
+	err := ex.each(hashType, func(hash *Hash) (int, error) {
+		if hash.Value == "" {
+			return 0, nil // Not cracked, nothing to put in a potfile
+		}
+
+		line := o.redact(hash).hashLine()
+		if o.Redaction == RedactNone {
+			line += ":" + util.EncodeHexPlain(hash.Value)
+		}
+		line += "\n"
+		if _, err := bw.WriteString(line); err != nil {
+			return 0, fmt.Errorf("failed to write hash: %w", err)
+		}
+		return len(line), nil
+	})
+	return ex.finish(err, bw.Flush)
+}
+
+// ExportCSV writes every hash of hashType to w as CSV with a hash,salt,value,type header.
+// Values are encoded with util.EncodeHexPlain like the potfile export, so CSV readers that
+// normalize line endings or choke on invalid UTF-8 can't alter them. RedactHashedOnly replaces the
+// value column with a cracked column. Returns the number of hashes written
+func (kc *KDB) ExportCSV(w io.Writer, hashType uint64, opts ...*ExportOptions) (int, error) {
+	o := exportOptions(opts)
+	cw := csv.NewWriter(w)
+
+	header := csvHeader
+	if o.Redaction == RedactHashedOnly {
+		header = csvHashedOnlyHeader
+	}
+	if err := cw.Write(header); err != nil {
+		return 0, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	ex := kc.newExporter(o)
+	err := ex.each(hashType, func(hash *Hash) (int, error) {
+		redacted := o.redact(hash)
+		value := util.EncodeHexPlain(redacted.Value)
+		if o.Redaction == RedactHashedOnly {
+			value = strconv.FormatBool(hash.Value != "")
+		}
+
+		record := []string{
+			redacted.Hash,
+			hash.Salt,
+			value,
+			strconv.FormatUint(hash.HashType, 10),
+		}
+		if err := cw.Write(record); err != nil {
+			return 0, fmt.Errorf("failed to write hash: %w", err)
+		}
+		return csvRecordSize(record), nil
+	})
+	return ex.finish(err, func() error {
+		cw.Flush()
+		return cw.Error()
+	})
+}
+
+// csvRecordSize estimates the bytes a CSV record takes for BytesPerSecond, quotes left out
+func csvRecordSize(record []string) int {
+	size := len(record) // Commas and the line ending
+	for _, field := range record {
+		size += len(field)
+	}
+	return size
+}
+
+// ExportHashtopolis writes the hashes of hashType as a Hashtopolis hashlist, one hash per line
+// and salted hashes as hash:salt, the default Hashtopolis salt separator. With uncrackedOnly only
+// hashes without a value are written. Returns the number of hashes written
+func (kc *KDB) ExportHashtopolis(w io.Writer, hashType uint64, uncrackedOnly bool, opts ...*ExportOptions) (int, error) {
+	o := exportOptions(opts)
+	bw := bufio.NewWriter(w)
+
+	ex := kc.newExporter(o)
+	err := ex.each(hashType, func(hash *Hash) (int, error) {
+		if uncrackedOnly && hash.Value != "" {
+			return 0, nil
+		}
+
+		line := o.redact(hash).hashLine() + "\n"
+		if _, err := bw.WriteString(line); err != nil {
+			return 0, fmt.Errorf("failed to write hash: %w", err)
+		}
+		return len(line), nil
+	})
+	return ex.finish(err, bw.Flush)
+}
+
+const (
+	// exportPageSize is how many hashes an export reads per transaction. The database is unlocked
+	// between pages, so a long export doesn't hold up other callers and can sleep for its rate
+	exportPageSize = 1000
+
+	defaultExportProgressInterval = time.Second
+)
+
+// exporter pages through the hashes of an export, reporting progress and keeping to the rates
+// of ExportOptions. Every exporter goes through it, so every export yields hashes in key order
+// and two exports of an unchanged database are byte-identical
+type exporter struct {
+	kc  *KDB
+	o   ExportOptions
+	ctx context.Context
+
+	written  uint64 // rows written
+	bytes    uint64
+	started  time.Time
+	reported time.Time // when progress was last reported
+}
+
+func (kc *KDB) newExporter(o ExportOptions) *exporter {
+	ctx := o.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	now := time.Now()
+	return &exporter{kc: kc, o: o, ctx: ctx, started: now, reported: now}
+}
+
+// pageSize returns how many hashes to read per page, fewer under a low rate so the pauses
+// between pages stay short
+func (ex *exporter) pageSize() int {
+	size := exportPageSize
+	if ex.o.RowsPerSecond > 0 {
+		size = min(size, max(1, ex.o.RowsPerSecond/10))
+	}
+	if ex.o.BytesPerSecond > 0 {
+		size = min(size, max(1, ex.o.BytesPerSecond/1000)) // Rows of about 100 bytes, ten pages a second
+	}
+	return size
+}
+
+// each calls write with every hash of hashType, page by page, until write fails or the context
+// is done. write returns the bytes it wrote, 0 for a hash it skipped
+func (ex *exporter) each(hashType uint64, write func(*Hash) (int, error)) error {
+	so := DefaultScanOptions()
+	so.Limit = ex.pageSize()
+	so.rawValues = ex.o.RawValues
+
+	for {
+		read := 0
+		var err error
+		for hash := range ex.kc.GetHashesByHashType(hashType, so) {
+			read++
+			so.After = hash
+			if err = ex.ctx.Err(); err != nil {
+				break
+			}
+			var n int
+			if n, err = write(hash); err != nil {
+				break
+			}
+			if n > 0 {
+				ex.written++
+				ex.bytes += uint64(n)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if read < so.Limit {
+			return nil
+		}
+
+		ex.reportProgress(false)
+		if err := ex.throttle(); err != nil {
+			return err
+		}
+	}
+}
+
+// throttle sleeps until the rows and bytes written so far are within the rates
+func (ex *exporter) throttle() error {
+	var due time.Duration
+	if ex.o.RowsPerSecond > 0 {
+		due = time.Duration(ex.written) * time.Second / time.Duration(ex.o.RowsPerSecond)
+	}
+	if ex.o.BytesPerSecond > 0 {
+		due = max(due, time.Duration(ex.bytes)*time.Second/time.Duration(ex.o.BytesPerSecond))
+	}
+	return sleepContext(ex.ctx, due-time.Since(ex.started))
+}
+
+// reportProgress calls ExportOptions.Progress once the interval has passed since the last
+// report, or always for the final one
+func (ex *exporter) reportProgress(done bool) {
+	if ex.o.Progress == nil {
+		return
+	}
+	interval := ex.o.ProgressInterval
+	if interval <= 0 {
+		interval = defaultExportProgressInterval
+	}
+	if now := time.Now(); done || now.Sub(ex.reported) >= interval {
+		ex.reported = now
+		ex.o.Progress(ex.written)
+	}
+}
+
+// finish flushes what was written, also after an error, and returns the rows written and the
+// error that ended the export
+func (ex *exporter) finish(err error, flush func() error) (int, error) {
+	if flushErr := flush(); flushErr != nil && err == nil {
+		err = fmt.Errorf("failed to flush export: %w", flushErr)
+	}
+	ex.reportProgress(true)
+	if err != nil && ex.ctx.Err() != nil && errors.Is(err, ex.ctx.Err()) {
+		ex.kc.log(fmt.Sprintf("Export canceled after %d rows", ex.written), Warning)
+	}
+	return int(ex.written), err
+}
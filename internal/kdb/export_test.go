@@ -0,0 +1,422 @@
+package kdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// awkwardValues are plaintexts that break naive line and field based formats
+var awkwardValues = []string{
+	"plain",
+	"embedded\x00nul",
+	"\xff\xfe\x80",
+	"pass:word",
+	"line\nbreak",
+	"carriage\rreturn",
+	"tab\tseparated",
+	"comma,\"quoted\"",
+	"$HEX[41414141]",
+	"$HEX[",
+	"Windows-1252 \xe9t\xe9",
+	"ünïcödé",
+	" surrounding space ",
+}
+
+func TestValueRoundTrip(t *testing.T) {
+	formats := []struct {
+		name   string
+		export func(db *KDB, w io.Writer) (int, error)
+		imp    func(db *KDB, r io.Reader) (ImportReport, error)
+	}{
+		{"potfile",
+			func(db *KDB, w io.Writer) (int, error) { return db.ExportPotfile(w, 0) },
+			func(db *KDB, r io.Reader) (ImportReport, error) { return db.ImportPotfile(r, 0) }},
+		{"csv",
+			func(db *KDB, w io.Writer) (int, error) { return db.ExportCSV(w, 0) },
+			func(db *KDB, r io.Reader) (ImportReport, error) { return db.ImportCSV(r) }},
+		{"jsonl",
+			func(db *KDB, w io.Writer) (int, error) { return db.ExportJSONL(w, 0) },
+			func(db *KDB, r io.Reader) (ImportReport, error) { return db.ImportJSONL(r) }},
+	}
+
+	src := newTestDB(t, testPrefix, nil)
+	for i, value := range awkwardValues {
+		if _, err := src.StoreHash(NewHash(testHash(i), value, 0)); err != nil {
+			t.Fatalf("store %q: %v", value, err)
+		}
+	}
+
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := format.export(src, &buf)
+			if err != nil || n != len(awkwardValues) {
+				t.Fatalf("exported %d of %d: %v", n, len(awkwardValues), err)
+			}
+
+			dst := newTestDB(t, testPrefix, nil)
+			report, err := format.imp(dst, &buf)
+			if err != nil || report.Imported != len(awkwardValues) {
+				t.Fatalf("imported %+v: %v", report, err)
+			}
+			for i, want := range awkwardValues {
+				got, err := dst.GetHashByOriginalHash(testHash(i), 0)
+				if err != nil {
+					t.Errorf("%q was lost: %v", want, err)
+					continue
+				}
+				if !bytes.Equal(got.ValueBytes(), []byte(want)) {
+					t.Errorf("value %q came back as %q", want, got.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestPotfileHexEncoding(t *testing.T) {
+	db := newTestDB(t, testPrefix, nil)
+	for i, value := range awkwardValues {
+		if _, err := db.StoreHash(NewHash(testHash(i), value, 0)); err != nil {
+			t.Fatalf("store %q: %v", value, err)
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := db.ExportPotfile(&buf, 0); err != nil {
+		t.Fatalf("ExportPotfile: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(awkwardValues) {
+		t.Fatalf("%d lines for %d hashes:\n%s", len(lines), len(awkwardValues), buf.String())
+	}
+	for _, line := range lines {
+		hash, value, _ := strings.Cut(line, ":")
+		i, err := strconv.ParseInt(hash, 16, 64)
+		if err != nil || int(i) >= len(awkwardValues) {
+			t.Fatalf("unexpected line %q", line)
+		}
+		if hexed := strings.HasPrefix(value, "$HEX[") && strings.HasSuffix(value, "]"); hexed != needsHex(awkwardValues[i]) {
+			t.Errorf("%q written as %q", awkwardValues[i], value)
+		}
+	}
+}
+
+func TestPotfileColonPlaintexts(t *testing.T) {
+	const md5Salted = 10 // md5($pass.$salt)
+	db := newTestDB(t, testPrefix, nil)
+	stored, err := BuildHash(testHash(1)).Type(md5Salted).Salt("s:1").Build()
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if _, err := db.StoreHash(stored); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	// Unsalted lines split after the digest, salted ones where the hash is stored, or else at the
+	// last colon
+	potfiles := map[uint64][]string{
+		0:         {testHash(0) + ":a:b:c"},
+		md5Salted: {testHash(1) + ":s:1:pass:word", testHash(2) + ":s2:a:b"},
+	}
+	for hashType, lines := range potfiles {
+		report, err := db.ImportPotfile(strings.NewReader(strings.Join(lines, "\n")), hashType)
+		if err != nil || report.Imported != len(lines) {
+			t.Fatalf("importing the lines of type %d: %+v %v", hashType, report, err)
+		}
+	}
+
+	want := map[uint64]map[string]string{
+		0: {testHash(0): "a:b:c"},
+		md5Salted: {
+			testHash(1) + ":s:1":  "pass:word",
+			testHash(2) + ":s2:a": "b",
+		},
+	}
+	for hashType, values := range want {
+		cracked := make(map[string]string)
+		for h := range db.GetHashesByHashType(hashType) {
+			cracked[h.hashLine()] = h.Value
+		}
+		for line, value := range values {
+			if got, ok := cracked[line]; !ok || got != value {
+				t.Errorf("%s of type %d was cracked as %q, want %q", line, hashType, got, value)
+			}
+		}
+	}
+}
+
+// needsHex reports whether hashcat writes value as $HEX[...]: it holds a byte outside printable
+// ASCII or starts like a hex encoded value
+func needsHex(value string) bool {
+	if strings.HasPrefix(value, "$HEX[") {
+		return true
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x20 || value[i] > 0x7e {
+			return true
+		}
+	}
+	return false
+}
+
+func TestJSONValueRaw(t *testing.T) {
+	for _, value := range awkwardValues {
+		sh := NewHash(testHash(1), value, 0)
+		data, err := json.Marshal(sh)
+		if err != nil {
+			t.Fatalf("marshal %q: %v", value, err)
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(data, &fields); err != nil {
+			t.Fatalf("unmarshal %s: %v", data, err)
+		}
+		_, raw := fields["value_raw"]
+		if raw == utf8.ValidString(value) {
+			t.Errorf("%q: value_raw present %v in %s", value, raw, data)
+		}
+
+		var back Hash
+		if err := json.Unmarshal(data, &back); err != nil || back.Value != value {
+			t.Errorf("%q came back as %q: %v", value, back.Value, err)
+		}
+	}
+}
+
+// exportRows is the number of hashes the export rate tests store
+const exportRows = 3000
+
+// exportFixture stores exportRows cracked hashes
+func exportFixture(t *testing.T) *KDB {
+	t.Helper()
+	db := newTestDB(t, testPrefix, nil)
+	batch := make([]*Hash, 0, exportRows)
+	for i := range exportRows {
+		batch = append(batch, NewHash(testHash(i), fmt.Sprintf("plain-%d", i), 0))
+	}
+	if _, err := db.StoreHashes(batch); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	return db
+}
+
+// wholeLines returns the number of lines of out, failing t unless every one is a whole record
+func wholeLines(t *testing.T, out []byte) int {
+	t.Helper()
+	lines := 0
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		lines++
+		var v map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &v); err != nil {
+			t.Errorf("line %d isn't a whole record: %v", lines, err)
+		}
+	}
+	if len(out) > 0 && out[len(out)-1] != '\n' {
+		t.Errorf("the output ends mid-line")
+	}
+	return lines
+}
+
+func TestExportRateLimits(t *testing.T) {
+	db := exportFixture(t)
+
+	var buf bytes.Buffer
+	var reports []uint64
+	written, err := db.ExportJSONL(&buf, 0, &ExportOptions{
+		Progress:         func(written uint64) { reports = append(reports, written) },
+		ProgressInterval: time.Nanosecond,
+	})
+	if err != nil || written != exportRows || wholeLines(t, buf.Bytes()) != exportRows {
+		t.Fatalf("the unbounded export wrote %d rows: %v", written, err)
+	}
+	if len(reports) < 2 || reports[len(reports)-1] != exportRows {
+		t.Errorf("progress reports %v", reports)
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i] < reports[i-1] {
+			t.Errorf("progress went back from %d to %d", reports[i-1], reports[i])
+		}
+	}
+	size := buf.Len()
+
+	// The first page isn't waited for
+	const rowRate = 10000
+	buf.Reset()
+	start := time.Now()
+	written, err = db.ExportJSONL(&buf, 0, &ExportOptions{RowsPerSecond: rowRate})
+	least := time.Duration(exportRows-rowRate/10) * time.Second / rowRate
+	if elapsed := time.Since(start); err != nil || written != exportRows || elapsed < least {
+		t.Errorf("%d rows at %d/s took %v, under %v: %v", written, rowRate, elapsed, least, err)
+	}
+
+	// About half a second
+	byteRate := size * 2
+	buf.Reset()
+	start = time.Now()
+	written, err = db.ExportJSONL(&buf, 0, &ExportOptions{BytesPerSecond: byteRate})
+	observed := float64(buf.Len()) / time.Since(start).Seconds()
+	if err != nil || written != exportRows || observed > float64(byteRate)*1.2 {
+		t.Errorf("%d rows at %.0f bytes/s over the %d bytes/s rate: %v", written, observed, byteRate, err)
+	}
+}
+
+func TestCancelledExportLeavesWholeRecords(t *testing.T) {
+	db := exportFixture(t)
+
+	var buf bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	written, err := db.ExportJSONL(&buf, 0, &ExportOptions{RowsPerSecond: exportRows, Context: ctx})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("the cancelled export returned %v", err)
+	}
+	if written == 0 || written >= exportRows || wholeLines(t, buf.Bytes()) != written {
+		t.Fatalf("the cancelled export wrote %d of %d rows, its output doesn't hold them", written, exportRows)
+	}
+
+	// The truncated output still imports
+	other := newTestDB(t, testPrefix, nil)
+	if report, err := other.ImportJSONL(&buf); err != nil || report.Imported != written || report.Invalid != 0 {
+		t.Errorf("the reimport: %+v %v", report, err)
+	}
+}
+
+// exportFixtureHashes returns the same hashes every call, with meta keys in no order and times in
+// a zone other than UTC
+func exportFixtureHashes() []*Hash {
+	zone := time.FixedZone("UTC+2", 2*60*60)
+	epoch := time.Date(2026, 3, 1, 12, 0, 0, 0, zone)
+
+	var hashes []*Hash
+	for i := range 600 {
+		hashType := uint64(0)
+		if i%3 == 0 {
+			hashType = NTLM
+		}
+		value := ""
+		if i%2 == 0 {
+			value = fmt.Sprintf("pass:%d\xff", i)
+		}
+		h := NewHash(testHash(i), value, hashType)
+		h.CreatedAt = epoch.Add(time.Duration(i) * time.Second)
+		if value != "" {
+			h.CrackedAt = epoch.Add(time.Duration(i) * time.Minute)
+		}
+		h.Meta = map[string]string{"zeta": "z", "user": fmt.Sprintf("user%d", i), "alpha": "a", "rid": fmt.Sprint(1000 + i), "mid": "m"}
+		if i%5 == 0 {
+			h.Source = &Source{Wordlist: "rockyou.txt", Rule: "best64.rule"}
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes
+}
+
+// exportAll returns every export of db by name
+func exportAll(t *testing.T, db *KDB) map[string][]byte {
+	t.Helper()
+	exports := make(map[string][]byte)
+	run := func(name string, fn func(*bytes.Buffer) error) {
+		var buf bytes.Buffer
+		if err := fn(&buf); err != nil {
+			t.Fatalf("the %s export: %v", name, err)
+		}
+		exports[name] = buf.Bytes()
+	}
+	for _, hashType := range []uint64{0, NTLM} {
+		run(fmt.Sprintf("jsonl %d", hashType), func(b *bytes.Buffer) error { _, err := db.ExportJSONL(b, hashType); return err })
+		run(fmt.Sprintf("csv %d", hashType), func(b *bytes.Buffer) error { _, err := db.ExportCSV(b, hashType); return err })
+		run(fmt.Sprintf("potfile %d", hashType), func(b *bytes.Buffer) error { _, err := db.ExportPotfile(b, hashType); return err })
+		run(fmt.Sprintf("hashtopolis %d", hashType), func(b *bytes.Buffer) error {
+			_, err := db.ExportHashtopolis(b, hashType, false)
+			return err
+		})
+		run(fmt.Sprintf("masked %d", hashType), func(b *bytes.Buffer) error {
+			_, err := db.ExportJSONL(b, hashType, &ExportOptions{Redaction: RedactMasked})
+			return err
+		})
+	}
+	run("pwdump", func(b *bytes.Buffer) error { _, err := db.ExportPwdump(b); return err })
+	run("registry", func(b *bytes.Buffer) error { return db.ExportRegistry(b) })
+	return exports
+}
+
+// sameExports fails t unless a and b hold the same exports, byte for byte
+func sameExports(t *testing.T, what string, a, b map[string][]byte) {
+	t.Helper()
+	if len(a) != len(b) {
+		t.Errorf("%s: %d exports against %d", what, len(a), len(b))
+	}
+	for name, data := range a {
+		if len(data) == 0 {
+			t.Errorf("%s: the %s export is empty", what, name)
+		} else if !bytes.Equal(data, b[name]) {
+			t.Errorf("%s: the %s exports differ", what, name)
+		}
+	}
+}
+
+func TestExportsAreDeterministic(t *testing.T) {
+	dir := t.TempDir()
+	db, err := openTestDB(t, dir, testPrefix, nil)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := db.StoreHashes(exportFixtureHashes()); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	first := exportAll(t, db)
+	sameExports(t, "a second export", first, exportAll(t, db))
+
+	db.Close()
+	if db, err = openTestDB(t, dir, testPrefix, nil); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	sameExports(t, "after reopening", first, exportAll(t, db))
+
+	// Stored backwards in small batches. The registry records when each database first saw a
+	// type, which is data rather than order
+	other := newTestDB(t, testPrefix, nil)
+	hashes := exportFixtureHashes()
+	for end := len(hashes); end > 0; end -= 70 {
+		batch := hashes[max(end-70, 0):end]
+		slices.Reverse(batch)
+		if _, err := other.StoreHashes(batch); err != nil {
+			t.Fatalf("store: %v", err)
+		}
+	}
+	exports := exportAll(t, other)
+	exports["registry"] = first["registry"]
+	sameExports(t, "another database", first, exports)
+
+	lines := strings.Split(strings.TrimSpace(string(first["jsonl 0"])), "\n")
+	if len(lines) != 400 {
+		t.Fatalf("%d JSON lines for type 0", len(lines))
+	}
+	order := regexp.MustCompile(`"meta":\{"alpha":"a","mid":"m","rid":"\d+","user":"user\d+","zeta":"z"\}`)
+	stamp := regexp.MustCompile(`"(created|cracked)_at":"([^"]+)"`)
+	for _, line := range lines {
+		if !order.MatchString(line) {
+			t.Errorf("meta keys out of order: %s", line)
+		}
+		for _, m := range stamp.FindAllStringSubmatch(line, -1) {
+			if _, err := time.Parse(time.RFC3339, m[2]); err != nil || !strings.HasSuffix(m[2], "Z") {
+				t.Errorf("%s_at %q isn't RFC 3339 UTC", m[1], m[2])
+			}
+		}
+	}
+	if bytes.Contains(first["registry"], []byte("exported_at")) {
+		t.Errorf("the registry export carries the export time")
+	}
+}
@@ -0,0 +1,155 @@
+package kdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"runtime"
+	"slices"
+
+	"github.com/dgraph-io/badger/v4"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxFindAllWorkers bounds the types FindHashesAllTypes searches at once
+const maxFindAllWorkers = 8
+
+// TypeMatch is a hash found by FindHashesAllTypes
+type TypeMatch struct {
+	Input    string // The searched hash that matched, as passed
+	HashType uint64 // The type it was found under
+	Hash     *Hash
+	// Ambiguous is set when Input was already yielded under another type, OtherTypes lists them
+	Ambiguous  bool
+	OtherTypes []uint64
+}
+
+// FindHashesAllTypes searches hashes under every registered type, for hashes whose mode isn't
+// known. Types are searched as FindHashes would by a bounded number of workers and their matches
+// merged into one stream in no particular order. A hash stored under several types is yielded
+// once per type, every match after the first is flagged Ambiguous. Cancelling ctx or breaking
+// out of the loop stops every worker, none is left running once the iterator returns. Workers
+// take turns on the database lock, the loop body runs without it
+func (kc *KDB) FindHashesAllTypes(ctx context.Context, hashes []string) iter.Seq[*TypeMatch] {
+	return func(yield func(*TypeMatch) bool) {
+		yield, recovery := guardYield(kc, "find across types", yield)
+		defer recovery()
+
+		if len(hashes) == 0 {
+			return
+		}
+		types, err := kc.getRegisteredHashTypes()
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to get registered hash types: %v", err), Error)
+			return
+		}
+		if len(types) == 0 {
+			return
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		g, gctx := errgroup.WithContext(ctx)
+		g.SetLimit(min(len(types), runtime.GOMAXPROCS(0), maxFindAllWorkers))
+
+		matches := make(chan []*TypeMatch)
+		var searchErr error
+		// Panics come back as the search error, matches is closed either way so the loop ends
+		go func() {
+			defer close(matches)
+			defer kc.recoverPanic("find across types", &searchErr)
+			for _, hashType := range types {
+				if gctx.Err() != nil {
+					break
+				}
+				g.Go(func() (err error) {
+					defer kc.recoverPanic("find across types", &err)
+					found, err := kc.findTypeMatches(gctx, hashes, hashType)
+					if err != nil || len(found) == 0 {
+						return err
+					}
+					select {
+					case matches <- found:
+						return nil
+					case <-gctx.Done():
+						return gctx.Err()
+					}
+				})
+			}
+			searchErr = g.Wait()
+		}()
+
+		// Drained on the way out so the workers are gone before returning
+		defer func() {
+			cancel()
+			for range matches {
+			}
+			if searchErr != nil && !errors.Is(searchErr, context.Canceled) && !errors.Is(searchErr, context.DeadlineExceeded) {
+				kc.log(fmt.Sprintf("Failed to search %d hash types: %v", len(types), searchErr), Error)
+			}
+		}()
+
+		// A type comes in one batch, so the types seen before it are the other ones
+		seen := make(map[string][]uint64)
+		for found := range matches {
+			for _, m := range found {
+				if ctx.Err() != nil {
+					return
+				}
+				m.OtherTypes = slices.Clone(seen[m.Input])
+				m.Ambiguous = len(m.OtherTypes) > 0
+				if !yield(m) {
+					return
+				}
+			}
+			for _, m := range found {
+				if !slices.Contains(seen[m.Input], m.HashType) {
+					seen[m.Input] = append(seen[m.Input], m.HashType)
+				}
+			}
+		}
+	}
+}
+
+// findTypeMatches returns the hashes of hashType among hashes, read under the database lock and
+// handed on once it is released
+func (kc *KDB) findTypeMatches(ctx context.Context, hashes []string, hashType uint64) ([]*TypeMatch, error) {
+	so := DefaultScanOptions()
+	so.ctx = ctx
+
+	// Matches come back in their canonical form, which depends on the type
+	inputs := make(map[string]string, len(hashes))
+	for _, hash := range hashes {
+		if canonical := canonicalHash(hash, hashType); inputs[canonical] == "" {
+			inputs[canonical] = hash
+		}
+	}
+	targets := kc.findSums(hashes, hashType)
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	kc.ops.iterations.Add(1)
+
+	var found []*TypeMatch
+	err := kc.c.View(func(txn *badger.Txn) error {
+		plan, err := kc.planFind(txn, hashType, len(targets.sums), so)
+		if err != nil {
+			return err
+		}
+		return kc.find(txn, plan.Strategy, hashType, targets, so, func(hash *Hash) bool {
+			input, ok := inputs[hash.Canonical()]
+			for i := 0; !ok && i < len(hash.Captures); i++ {
+				input, ok = inputs[canonicalHash(hash.Captures[i], hashType)]
+			}
+			if !ok {
+				input = hash.Hash
+			}
+			found = append(found, &TypeMatch{Input: input, HashType: hashType, Hash: hash})
+			return true
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search hash type %d: %w", hashType, err)
+	}
+	return found, nil
+}
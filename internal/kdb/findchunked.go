@@ -0,0 +1,237 @@
+package kdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	defaultFindChunkSize    = 10000                 // inputs FindHashesChunked searches per chunk
+	defaultFindChunkRetries = 3                     // retries of a failed chunk
+	defaultFindChunkBackoff = 50 * time.Millisecond // wait before the first retry, doubling with every one
+	findChunkBackoffMax     = 5 * time.Second       // longest wait between two retries
+)
+
+// InputRange is the inputs [Start, End) of a FindHashesChunked call
+type InputRange struct {
+	Start int // Index of the first input
+	End   int // Index one past the last input
+}
+
+// Len returns the number of inputs in the range
+func (r InputRange) Len() int {
+	return r.End - r.Start
+}
+
+// String returns the range as "[start, end)"
+func (r InputRange) String() string {
+	return fmt.Sprintf("[%d, %d)", r.Start, r.End)
+}
+
+// ChunkedFindOptions tune FindHashesChunked
+type ChunkedFindOptions struct {
+	ChunkSize int           // Inputs searched per chunk, 0 uses 10000
+	Retries   int           // Retries of a failed chunk, 0 uses 3, negative disables them
+	Backoff   time.Duration // Wait before the first retry of a chunk, doubling with every one, 0 uses 50ms
+	// Ranges restricts the search to these ranges of the inputs, such as the Unsearched ranges of
+	// an earlier call with the same inputs. Nil searches every input
+	Ranges []InputRange
+	// Scan tunes the search of each chunk as it would FindHashes, Limit and After are ignored
+	Scan *ScanOptions
+	// OnRetry is called before a failed chunk is tried again, attempt counting from 1
+	OnRetry func(r InputRange, attempt int, err error)
+}
+
+// ChunkMatch is a hash found by FindHashesChunked
+type ChunkMatch struct {
+	Index int    // Position of the matched input in the inputs
+	Input string // The input, as passed
+	Hash  *Hash
+}
+
+// FindHashesChunked searches possibleHashes as FindHashes would, a chunk of inputs at a time, so
+// a failure halfway through a large search loses only the chunk it hit. A chunk's matches are
+// yielded once the whole chunk was searched; a failed chunk is tried again with exponential
+// backoff and, once its retries are exhausted, skipped. Inputs yielded twice, such as duplicates
+// in possibleHashes, are yielded under each of their indexes, every index at most once.
+//
+// If some inputs weren't searched the last pair yielded carries a *FindIncompleteError listing
+// them, search them again by passing its Unsearched ranges as ChunkedFindOptions.Ranges with the
+// same inputs. Cancelling ctx or closing the database leaves the remaining chunks unsearched
+// rather than retrying them. Each chunk holds the database lock while it is searched, the loop
+// body runs without it
+func (kc *KDB) FindHashesChunked(ctx context.Context, possibleHashes []string, hashType uint64, opts *ChunkedFindOptions) iter.Seq2[*ChunkMatch, error] {
+	if opts == nil {
+		opts = &ChunkedFindOptions{}
+	}
+	return func(yield func(*ChunkMatch, error) bool) {
+		yield, recovery := guardYield2(kc, "chunked find", yield)
+		defer recovery()
+
+		chunks, err := opts.chunks(len(possibleHashes))
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		delivered := make([]bool, len(possibleHashes))
+		incomplete := &FindIncompleteError{}
+		for i, chunk := range chunks {
+			found, err := kc.findChunk(ctx, possibleHashes, chunk, hashType, opts)
+			if err != nil {
+				if incomplete.Err == nil {
+					incomplete.Err = err
+				}
+				incomplete.add(chunk)
+				kc.log(fmt.Sprintf("Failed to search inputs %s of hash type %d: %v", chunk, hashType, err), Error)
+				if !findRetryable(ctx, err) {
+					for _, rest := range chunks[i+1:] {
+						incomplete.add(rest)
+					}
+					break
+				}
+				continue
+			}
+
+			for _, m := range found {
+				if delivered[m.Index] {
+					continue
+				}
+				delivered[m.Index] = true
+				if !yield(m, nil) {
+					return
+				}
+			}
+		}
+
+		if len(incomplete.Unsearched) > 0 {
+			yield(nil, incomplete)
+		}
+	}
+}
+
+// chunks splits the ranges to search among n inputs into chunks of ChunkSize
+func (o *ChunkedFindOptions) chunks(n int) ([]InputRange, error) {
+	size := o.ChunkSize
+	if size <= 0 {
+		size = defaultFindChunkSize
+	}
+	ranges := o.Ranges
+	if ranges == nil {
+		ranges = []InputRange{{Start: 0, End: n}}
+	}
+
+	var chunks []InputRange
+	for _, r := range ranges {
+		if r.Start < 0 || r.Start > r.End || r.End > n {
+			return nil, fmt.Errorf("input range %s is outside the %d inputs", r, n)
+		}
+		for start := r.Start; start < r.End; start += size {
+			chunks = append(chunks, InputRange{Start: start, End: min(start+size, r.End)})
+		}
+	}
+	return chunks, nil
+}
+
+// add records r as unsearched, merging it into the previous range if they touch
+func (e *FindIncompleteError) add(r InputRange) {
+	if last := len(e.Unsearched) - 1; last >= 0 && e.Unsearched[last].End == r.Start {
+		e.Unsearched[last].End = r.End
+		return
+	}
+	e.Unsearched = append(e.Unsearched, r)
+}
+
+// findRetryable reports whether a chunk that failed with err may succeed if tried again
+func findRetryable(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && !errors.Is(err, badger.ErrDBClosed) && !errors.Is(err, ErrNotInitialized)
+}
+
+// findChunk searches the inputs in r, trying again with backoff while it fails
+func (kc *KDB) findChunk(ctx context.Context, hashes []string, r InputRange, hashType uint64, opts *ChunkedFindOptions) ([]*ChunkMatch, error) {
+	retries := opts.Retries
+	switch {
+	case retries == 0:
+		retries = defaultFindChunkRetries
+	case retries < 0:
+		retries = 0
+	}
+	backoff := opts.Backoff
+	if backoff <= 0 {
+		backoff = defaultFindChunkBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		found, err := kc.searchChunk(ctx, hashes, r, hashType, opts.Scan)
+		if err == nil || attempt == retries || !findRetryable(ctx, err) {
+			return found, err
+		}
+		if opts.OnRetry != nil {
+			opts.OnRetry(r, attempt+1, err)
+		}
+
+		wait := findChunkBackoffMax
+		if attempt < 16 {
+			wait = min(backoff<<attempt, findChunkBackoffMax)
+		}
+		kc.log(fmt.Sprintf("Searching inputs %s failed, retrying in %v (%d of %d): %v", r, wait, attempt+1, retries, err), Warning)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// searchChunk returns the matches of the inputs in r, read under the database lock and handed on
+// once it is released
+func (kc *KDB) searchChunk(ctx context.Context, hashes []string, r InputRange, hashType uint64, scan *ScanOptions) ([]*ChunkMatch, error) {
+	so := *scanOptions([]*ScanOptions{scan})
+	so.Limit, so.After, so.ctx = 0, nil, ctx
+
+	// Matches come back in their canonical form, which depends on the type
+	inputs := make(map[string][]int, r.Len())
+	for i := r.Start; i < r.End; i++ {
+		canonical := canonicalHash(hashes[i], hashType)
+		inputs[canonical] = append(inputs[canonical], i)
+	}
+	targets := kc.findSums(hashes[r.Start:r.End], hashType)
+	if len(targets.sums) == 0 {
+		return nil, nil
+	}
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	if kc.c == nil || kc.c.IsClosed() {
+		return nil, badger.ErrDBClosed
+	}
+	kc.ops.iterations.Add(1)
+
+	var found []*ChunkMatch
+	err := kc.c.View(func(txn *badger.Txn) error {
+		plan, err := kc.planFind(txn, hashType, len(targets.sums), &so)
+		if err != nil {
+			return err
+		}
+		return kc.find(txn, plan.Strategy, hashType, targets, &so, func(hash *Hash) bool {
+			// Account records match every searched capture they hold
+			indexes := inputs[hash.Canonical()]
+			for _, capture := range hash.Captures {
+				indexes = append(indexes, inputs[canonicalHash(capture, hashType)]...)
+			}
+			for _, i := range indexes {
+				found = append(found, &ChunkMatch{Index: i, Input: hashes[i], Hash: hash})
+			}
+			return true
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search hash type %d: %w", hashType, err)
+	}
+	return found, nil
+}
@@ -0,0 +1,136 @@
+package kdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// FoundsFormat is the line format of a founds list
+type FoundsFormat int
+
+const (
+	HashColonPlain FoundsFormat = iota // hash:plain, or a bare hash for an uncracked entry
+	HashSaltPlain                      // hash:salt:plain
+)
+
+// ConflictPolicy decides what an import does with a hash that is already stored with a different value
+type ConflictPolicy int
+
+const (
+	ConflictKeep      ConflictPolicy = iota // Keep the stored value and skip the imported one
+	ConflictOverwrite                       // Replace the stored value with the imported one
+)
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ImportFounds imports a founds list bought from an online service as hashes of hashType.
+// Gzip and zstd compressed lists are detected by their magic bytes and $HEX[...] plaintexts are decoded.
+// Hashes already stored with a different value are conflicts, they usually mean a bad list, and are
+// counted in ImportReport.Conflicts. The optional policy decides who wins, ConflictKeep by default.
+// Hashes already stored with the same value are skipped as duplicates
+func (kc *KDB) ImportFounds(r io.Reader, format FoundsFormat, hashType uint64, policy ...ConflictPolicy) (ImportReport, error) {
+	if format != HashColonPlain && format != HashSaltPlain {
+		return ImportReport{}, fmt.Errorf("unknown founds format %d", int(format))
+	}
+
+	im := kc.newImporter()
+	if len(policy) > 0 {
+		im.onConflict = policy[0]
+	}
+	ingestFormat := FormatFounds
+	if format == HashSaltPlain {
+		ingestFormat = FormatFoundsSalted
+	}
+	return kc.ingest(context.Background(), im, r, ingestFormat, hashType, nil)
+}
+
+// parseFoundsLine parses one line of a founds list. Hex hashes never contain colons,
+// so the hash and salt end at the first colons and the plaintext keeps any others
+func (kc *KDB) parseFoundsLine(text string, format FoundsFormat, hashType uint64) (*Hash, error) {
+	hash, rest, found := strings.Cut(text, ":")
+	salt := ""
+
+	if format == HashSaltPlain {
+		if !found {
+			return nil, errors.New("missing ':' after hash")
+		}
+		salt, rest, found = strings.Cut(rest, ":")
+		if !found {
+			return nil, errors.New("missing ':' after salt")
+		}
+	}
+
+	plain, err := util.DecodeHexPlain(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	sh := kc.NewHash(hash, plain, hashType)
+	if salt != "" {
+		sh.Salt = salt
+		sh.generateKey()
+	}
+	return sh, nil
+}
+
+// resolveConflicts looks up the stored values of the queued hashes and applies the conflict policy
+func (im *importer) resolveConflicts() error {
+	stored := make(map[string]string, len(im.batch))
+
+	err := im.kc.viewLocked(func(txn *badger.Txn) error {
+		for _, sh := range im.batch {
+			item, err := txn.Get(sh.Key)
+			if isNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			var existing Hash
+			if err := item.Value(func(val []byte) error {
+				return im.kc.decodeHash(val, &existing)
+			}); err != nil {
+				return err
+			}
+			stored[string(sh.Key)] = existing.Value
+		}
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to check for conflicts: %w", err)
+	}
+
+	// Copies later in the batch are checked against the ones kept before them
+	kept := im.batch[:0]
+	for _, sh := range im.batch {
+		value, ok := stored[string(sh.Key)]
+		// A bare hash adds nothing to one already stored, unless whole records replace stored ones
+		if ok && !im.wholeRecords && (sh.Value == "" || value == im.kc.normalizeValue(sh.Value)) {
+			im.report.Duplicates++
+			continue
+		}
+		if ok && value != "" && value != im.kc.normalizeValue(sh.Value) {
+			im.report.Conflicts++
+			if len(im.report.ConflictHashes) < importMaxErrorLines {
+				im.report.ConflictHashes = append(im.report.ConflictHashes, sh.hashLine())
+			}
+			if im.onConflict == ConflictKeep {
+				continue
+			}
+		}
+		kept = append(kept, sh)
+		stored[string(sh.Key)] = im.kc.normalizeValue(sh.Value)
+	}
+	im.batch = kept
+
+	return nil
+}
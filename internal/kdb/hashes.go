@@ -1,40 +1,252 @@
 package kdb
 
 import (
+	"encoding/json"
 	"fmt"
-	"strings"
-
-	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
-// Hash represents a cryptographic hash and its cracked value
+// hashTypeNames maps common hashcat codes to the short names used by Hash.String and HashType
+var hashTypeNames = map[uint64]string{
+	MD5:            "md5",
+	SHA1:           "sha1",
+	MD5Crypt:       "md5crypt",
+	MD4:            "md4",
+	NTLM:           "ntlm",
+	SHA256:         "sha256",
+	SHA512:         "sha512",
+	SHA512Crypt:    "sha512crypt",
+	DCC2:           "dcc2",
+	LM:             "lm",
+	Bcrypt:         "bcrypt",
+	NetNTLMv1:      "netntlmv1",
+	NetNTLMv2:      "netntlmv2",
+	SHA256Crypt:    "sha256crypt",
+	KerberosTGSREP: "krb5tgs",
+	KerberosASREP:  "krb5asrep",
+	WPA:            "wpa",
+}
+
+// Hash represents a cryptographic hash and its cracked value.
+// Its JSON form is produced by MarshalJSON, see hashJSON
 type Hash struct {
-	Hash     string `json:"hash"`
-	Sum      []byte `json:"sum"`       // Hex Encoded SHA256 Sum of the Hash
-	Value    string `json:"value"`     // The Password or Secret
-	HashType uint64 `json:"hash_type"` // The hashcat code for the hash (0 - 99999)
-	Key      []byte `json:"key"`       // The key used to store the hash
+	Hash      string    // The hash as submitted, see Canonical for the form it is keyed on
+	Value     string    // The Password or Secret
+	HashType  uint64    // The hashcat code for the hash (0 - 99999)
+	Key       []byte    // The key used to store the hash
+	CreatedAt time.Time // When the hash was created, zero for hashes stored before timestamps
+	CrackedAt time.Time // When the value was first stored, zero while uncracked or for hashes cracked before timestamps
+
+	Salt    string            // Salt for salted modes, keyed together with the hash as hash:salt
+	Meta    map[string]string // Free-form metadata such as the account the hash came from
+	Session string            // The cracking session or job that produced the hash
+	Binary  []byte            // Raw bytes for binary hash formats
+	Source  *Source           // The attack that cracked the hash, nil if unknown
+
+	// Captures are earlier challenge-response captures of the account, most recent first. Hash
+	// holds the latest, see ChallengeKeying
+	Captures []string
+
+	sum []byte // raw SHA256 digest of the key material, see Sum
+	db  *KDB   // the database the hash was created from or read out of, nil for NewHash
+	seq uint64 // position in the insertion index, 0 until stored or for hashes stored before it
+
+	valueRef uint64 // value dictionary id of a decoded record, until resolved, see InternValues
+	record   []byte // the record as stored, for hashes handed to a MigrationFunc
+}
+
+// hashJSON is the public JSON representation of a Hash.
+// Values that aren't valid UTF-8 are carried base64 encoded in value_raw instead of value
+type hashJSON struct {
+	Hash      string    `json:"hash"`
+	Value     string    `json:"value"`
+	ValueRaw  []byte    `json:"value_raw,omitempty"`
+	HashType  uint64    `json:"type"`
+	Sum       string    `json:"sum"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	CrackedAt time.Time `json:"cracked_at,omitzero"`
+
+	Salt    string            `json:"salt,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+	Session string            `json:"session,omitempty"`
+	Binary  []byte            `json:"binary,omitempty"`
+	Source  *Source           `json:"source,omitempty"`
+
+	Captures []string `json:"captures,omitempty"`
 }
 
 // NewHash creates a new Hash object
-// The hash is automatically normalized to lowercase for consistent storage and lookup
+// The hash is kept as submitted and keyed on its canonical form, see Hash.Canonical
 func NewHash(hash, value string, hashType uint64) *Hash {
 	sh := &Hash{
-		Hash:     strings.ToLower(hash),
-		Value:    value,
-		HashType: hashType,
+		Hash:      submittedHash(hash, hashType),
+		Value:     value,
+		HashType:  hashType,
+		CreatedAt: time.Now().UTC(),
 	}
 	sh.generateKey()
 	return sh
 }
 
-// generateKey computes the SHA256 sum and generates the key
+// generateKey computes the SHA256 sum and generates the key in the keyspace of the database the
+// hash is bound to
 func (sh *Hash) generateKey() {
-	sh.Sum = util.SHA256Sum(sh.Hash)
-	sh.Key = []byte(fmt.Sprintf(storedHashPrefix, sh.HashType, string(sh.Sum)))
+	sh.sum = digestOf(sh.keyMaterial())
+	keys := defaultKeys
+	if sh.db != nil {
+		keys = sh.db.keys
+	}
+	keys.bindKey(sh)
+}
+
+// Canonical returns the form the hash is keyed on: lowercase, and for Kerberos tickets rewritten
+// in hashcat's format. Hash keeps the case it was submitted in, so the two may differ in case,
+// tickets also in their format. Lookups canonicalize the hash they are given the same way, so
+// either form finds the hash
+func (sh *Hash) Canonical() string {
+	return canonicalHash(sh.Hash, sh.HashType)
+}
+
+// keyMaterial returns the string the sum is computed over.
+// Salted hashes are keyed as hash:salt, the same way hashcat writes them
+func (sh *Hash) keyMaterial() string {
+	if sh.Salt == "" {
+		return sh.Canonical()
+	}
+	return sh.Canonical() + ":" + sh.Salt
+}
+
+// hashLine returns the hash as exporters write it, hash:salt for salted hashes like
+// keyMaterial but in the form it was submitted
+func (sh *Hash) hashLine() string {
+	if sh.Salt == "" {
+		return sh.Hash
+	}
+	return sh.Hash + ":" + sh.Salt
 }
 
-// Store stores the hash in the database
+// NewHash creates a new Hash bound to this database, so Hash.Store writes to it
+func (kc *KDB) NewHash(hash, value string, hashType uint64) *Hash {
+	sh := NewHash(hash, value, hashType)
+	sh.db = kc
+	kc.bindHash(sh)
+	return sh
+}
+
+// Store stores the hash in the database it is bound to, see KDB.NewHash.
+// Hashes created with the package-level NewHash or BuildHash fall back to the default
+// database; that fallback is deprecated, use KDB.StoreHash instead.
+// Returns ErrNotInitialized if the hash is unbound and no database is open
 func (sh *Hash) Store() error {
-	return krkn.StoreHash(sh)
+	db := sh.db
+	if db == nil {
+		db = Get()
+	}
+	if db == nil {
+		return ErrNotInitialized
+	}
+	_, err := db.StoreHash(sh)
+	return err
+}
+
+// String returns a short human readable form of the hash, e.g. "md5:5f4dcc3b…→password"
+func (sh *Hash) String() string {
+	name, ok := hashTypeNames[sh.HashType]
+	if !ok {
+		name = fmt.Sprintf("%d", sh.HashType)
+	}
+
+	hash := sh.Hash
+	if len(hash) > 8 {
+		hash = hash[:8] + "…"
+	}
+
+	if sh.Value == "" {
+		return fmt.Sprintf("%s:%s", name, hash)
+	}
+	return fmt.Sprintf("%s:%s→%s", name, hash, sh.Value)
+}
+
+// ValueBytes returns the cracked value as raw bytes. Values are stored byte for byte,
+// so this is exact even when the value isn't valid UTF-8
+func (sh *Hash) ValueBytes() []byte {
+	return []byte(sh.Value)
+}
+
+// ValueIsPrintable reports whether the value is valid UTF-8 made only of printable characters
+func (sh *Hash) ValueIsPrintable() bool {
+	if !utf8.ValidString(sh.Value) {
+		return false
+	}
+	for _, r := range sh.Value {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalJSON encodes the hash with its sum as a hex string, times as RFC 3339 in UTC and meta
+// keys sorted. A value that isn't valid UTF-8 is written base64 encoded as value_raw
+func (sh *Hash) MarshalJSON() ([]byte, error) {
+	return json.Marshal(sh.toJSON())
+}
+
+// toJSON returns the public JSON form of the hash. Times are written in UTC so the form doesn't
+// depend on the zone of whoever built the hash
+func (sh *Hash) toJSON() hashJSON {
+	hj := hashJSON{
+		Hash:      sh.Hash,
+		Value:     sh.Value,
+		HashType:  sh.HashType,
+		Sum:       sh.Sum(),
+		CreatedAt: sh.CreatedAt.UTC(),
+		CrackedAt: sh.CrackedAt.UTC(),
+		Salt:      sh.Salt,
+		Meta:      sh.Meta,
+		Session:   sh.Session,
+		Binary:    sh.Binary,
+		Source:    sh.Source,
+		Captures:  sh.Captures,
+	}
+	if !utf8.ValidString(sh.Value) {
+		hj.Value, hj.ValueRaw = "", []byte(sh.Value)
+	}
+	return hj
+}
+
+// UnmarshalJSON decodes a hash produced by MarshalJSON.
+// The sum and key are recomputed from the hash so they always agree with it
+func (sh *Hash) UnmarshalJSON(data []byte) error {
+	var hj hashJSON
+	if err := json.Unmarshal(data, &hj); err != nil {
+		return err
+	}
+
+	*sh = Hash{
+		Hash:      submittedHash(hj.Hash, hj.HashType),
+		Value:     hj.Value,
+		HashType:  hj.HashType,
+		CreatedAt: hj.CreatedAt,
+		CrackedAt: hj.CrackedAt,
+		Salt:      hj.Salt,
+		Meta:      hj.Meta,
+		Session:   hj.Session,
+		Binary:    hj.Binary,
+		Source:    hj.Source,
+		Captures:  hj.Captures,
+	}
+	if len(hj.ValueRaw) > 0 {
+		sh.Value = string(hj.ValueRaw)
+	}
+
+	if sh.Hash != "" {
+		sh.generateKey()
+	} else {
+		sh.sum = rawSum([]byte(hj.Sum))
+		sh.Key = defaultKeys.hashKey(sh.HashType, hj.Sum)
+	}
+	return nil
 }
@@ -0,0 +1,99 @@
+package kdb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// hashtopolisMaxSaltSplits is how many colons after the hash are tried as the end of a salt
+const hashtopolisMaxSaltSplits = 4
+
+// ImportHashtopolisFounds imports the cracked hashes export of Hashtopolis (hash:plain, or
+// hash:salt:plain for salted lists) as hashes of hashType. The export doesn't say whether a list
+// is salted and plaintexts may contain colons, so each line is matched against the stored hashes:
+// the first split whose hash (and salt) is already stored wins, with the existing entry kept and
+// only its value set. Lines matching nothing are imported as unsalted hash:plain.
+// $HEX[...] plaintexts are decoded and Windows line endings are tolerated
+func (kc *KDB) ImportHashtopolisFounds(r io.Reader, hashType uint64) (ImportReport, error) {
+	return kc.ingest(context.Background(), kc.newImporter(), r, FormatHashtopolis, hashType, nil)
+}
+
+// parseHashtopolisLine splits a founds line, preferring a split that matches a stored hash
+func (kc *KDB) parseHashtopolisLine(text string, hashType uint64) (*Hash, error) {
+	hash, rest, found := strings.Cut(text, ":")
+	if !found {
+		return nil, errors.New("missing ':' separator")
+	}
+
+	// Try hash:salt:plain with ever longer salts, then plain hash:plain
+	candidates := make([]*Hash, 0, hashtopolisMaxSaltSplits+1)
+	saltEnd := 0
+	for i := 0; i < hashtopolisMaxSaltSplits; i++ {
+		next := strings.IndexByte(rest[saltEnd:], ':')
+		if next < 0 {
+			break
+		}
+		saltEnd += next
+
+		sh := kc.NewHash(hash, rest[saltEnd+1:], hashType)
+		sh.Salt = rest[:saltEnd]
+		sh.generateKey()
+		candidates = append(candidates, sh)
+		saltEnd++
+	}
+	candidates = append(candidates, kc.NewHash(hash, rest, hashType))
+
+	match, err := kc.firstStored(candidates)
+	if err != nil {
+		return nil, err
+	}
+	if match == nil {
+		match = candidates[len(candidates)-1]
+	}
+
+	plain, err := util.DecodeHexPlain(match.Value)
+	if err != nil {
+		return nil, err
+	}
+	match.Value = plain
+	return match, nil
+}
+
+// firstStored returns the first candidate that is already stored, as stored but with the
+// candidate's value, or nil if none is
+func (kc *KDB) firstStored(candidates []*Hash) (*Hash, error) {
+	var match *Hash
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	err := kc.c.View(func(txn *badger.Txn) error {
+		for _, sh := range candidates {
+			item, err := txn.Get(sh.Key)
+			if isNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			existing := &Hash{}
+			if err := item.Value(func(val []byte) error {
+				return kc.decodeHash(val, existing)
+			}); err != nil {
+				return err
+			}
+			existing.Value = sh.Value
+			match = existing
+			return nil
+		}
+		return nil
+	})
+
+	return match, err
+}
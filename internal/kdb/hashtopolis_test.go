@@ -0,0 +1,131 @@
+package kdb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// hashtopolisFixture returns the lines of a file of testdata/hashtopolis. The hashlists are what
+// Hashtopolis takes as an upload, hash or hash:salt, the founds what its cracked export writes,
+// hash:plain or hash:salt:plain with hashcat's $HEX[] plaintexts
+func hashtopolisFixture(t *testing.T, name string) []string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "hashtopolis", name))
+	if err != nil {
+		t.Fatalf("failed to read the fixture: %v", err)
+	}
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n"), "\n")
+}
+
+// hashtopolisList returns the sorted lines of the hashlist export of hashType
+func hashtopolisList(t *testing.T, db *KDB, hashType uint64, uncrackedOnly bool) []string {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := db.ExportHashtopolis(&buf, hashType, uncrackedOnly); err != nil {
+		t.Fatalf("ExportHashtopolis: %v", err)
+	}
+	lines := strings.Fields(buf.String())
+	slices.Sort(lines)
+	return lines
+}
+
+// sortedCopy returns a sorted copy of lines
+func sortedCopy(lines []string) []string {
+	lines = slices.Clone(lines)
+	slices.Sort(lines)
+	return lines
+}
+
+func TestHashtopolisRoundTrip(t *testing.T) {
+	const md5Salted = 10 // md5($pass.$salt)
+	db := newTestDB(t, testPrefix, nil)
+
+	// The unsalted list as a target's dump, the salted one as Hashtopolis took it: hash, then salt
+	list, err := os.Open(filepath.Join("testdata", "hashtopolis", "md5.hashlist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer list.Close()
+	if report, err := db.ImportHashList(list, 0, HashListOptions{}); err != nil || report.Imported != 6 {
+		t.Fatalf("importing the hashlist: %+v %v", report, err)
+	}
+	salted := hashtopolisFixture(t, "md5salt.hashlist")
+	for _, line := range salted {
+		hash, salt, _ := strings.Cut(line, ":")
+		h, err := BuildHash(hash).Type(md5Salted).Salt(salt).Build()
+		if err != nil {
+			t.Fatalf("build %s: %v", line, err)
+		}
+		if _, err := db.StoreHash(h); err != nil {
+			t.Fatalf("store %s: %v", line, err)
+		}
+	}
+
+	// The export uploads to Hashtopolis as it came from there
+	unsalted := hashtopolisFixture(t, "md5.hashlist")
+	if got := hashtopolisList(t, db, 0, true); !slices.Equal(got, sortedCopy(unsalted)) {
+		t.Errorf("the unsalted hashlist exported as %v", got)
+	}
+	if got := hashtopolisList(t, db, md5Salted, true); !slices.Equal(got, sortedCopy(salted)) {
+		t.Errorf("the salted hashlist exported as %v", got)
+	}
+
+	// Founds with Windows line endings, colons and $HEX[] in plaintexts, and colons in salts
+	founds := map[uint64]string{0: "md5.founds", md5Salted: "md5salt.founds"}
+	want := map[uint64]map[string]string{
+		0: {
+			unsalted[0]: "Summer2026!",
+			unsalted[1]: "pass:word",
+			unsalted[2]: "caf\xe9",
+			unsalted[3]: "hunter2",
+		},
+		md5Salted: {
+			salted[0]: "admin1",
+			salted[1]: "letmein",
+			salted[2]: "a:b:c",
+		},
+	}
+	for hashType, name := range founds {
+		f, err := os.Open(filepath.Join("testdata", "hashtopolis", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := db.ImportHashtopolisFounds(f, hashType)
+		f.Close()
+		if err != nil || report.Imported != len(want[hashType]) || report.Invalid != 0 {
+			t.Fatalf("importing %s: %+v %v", name, report, err)
+		}
+	}
+	for hashType, values := range want {
+		cracked := make(map[string]string)
+		for h := range db.GetHashesByHashType(hashType) {
+			cracked[h.hashLine()] = h.Value
+		}
+		for line, value := range values {
+			if got, ok := cracked[line]; !ok || got != value {
+				t.Errorf("%s of type %d was cracked as %q, want %q", line, hashType, got, value)
+			}
+		}
+	}
+
+	// The founds matched the stored entries, no hash was stored twice or lost its salt
+	if n, err := db.HashesByType(0); err != nil || n != len(unsalted) {
+		t.Errorf("%d unsalted hashes stored: %v", n, err)
+	}
+	if n, err := db.HashesByType(md5Salted); err != nil || n != len(salted) {
+		t.Errorf("%d salted hashes stored: %v", n, err)
+	}
+	if got := hashtopolisList(t, db, 0, true); !slices.Equal(got, sortedCopy(unsalted[4:])) {
+		t.Errorf("the unsalted hashes left are %v", got)
+	}
+	if got := hashtopolisList(t, db, md5Salted, true); !slices.Equal(got, salted[3:]) {
+		t.Errorf("the salted hashes left are %v", got)
+	}
+	if got := hashtopolisList(t, db, md5Salted, false); !slices.Equal(got, sortedCopy(salted)) {
+		t.Errorf("the whole salted hashlist exported as %v", got)
+	}
+}
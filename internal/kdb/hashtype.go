@@ -0,0 +1,104 @@
+package kdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HashType is a hashcat mode. The API takes modes as uint64, the underlying type, and the mode
+// constants are untyped, so they fit both: db.HashesByType(SHA256) and HashType(SHA256).String()
+type HashType uint64
+
+// Hashcat modes of common hash types. NTLM, LM, NetNTLMv1, NetNTLMv2, KerberosTGSREP and
+// KerberosASREP are next to their parsers
+const (
+	MD5         = 0
+	SHA1        = 100
+	MD5Crypt    = 500 // $1$
+	MD4         = 900
+	SHA256      = 1400
+	SHA512      = 1700
+	SHA512Crypt = 1800 // $6$
+	DCC2        = 2100 // Domain Cached Credentials 2, $DCC2$10240#user#digest
+	Bcrypt      = 3200 // $2a$, $2b$, $2y$
+	SHA256Crypt = 7400 // $5$
+	WPA         = 22000
+)
+
+// saltedTypes are the modes whose hashes can't be computed from the plaintext alone: they carry a
+// salt, a challenge or the account they belong to
+var saltedTypes = map[uint64]bool{
+	10:             true, // md5($pass.$salt)
+	20:             true, // md5($salt.$pass)
+	110:            true, // sha1($pass.$salt)
+	120:            true, // sha1($salt.$pass)
+	MD5Crypt:       true,
+	1410:           true, // sha256($pass.$salt)
+	1420:           true, // sha256($salt.$pass)
+	1710:           true, // sha512($pass.$salt)
+	1720:           true, // sha512($salt.$pass)
+	SHA512Crypt:    true,
+	DCC2:           true,
+	Bcrypt:         true,
+	NetNTLMv1:      true,
+	NetNTLMv2:      true,
+	SHA256Crypt:    true,
+	KerberosTGSREP: true,
+	KerberosASREP:  true,
+	WPA:            true,
+}
+
+// String returns the short name of the mode, e.g. "ntlm", or its number for modes without one
+func (t HashType) String() string {
+	if name, ok := hashTypeNames[uint64(t)]; ok {
+		return name
+	}
+	return strconv.FormatUint(uint64(t), 10)
+}
+
+// IsSalted returns true for modes whose hashes carry a salt or challenge, so the same plaintext
+// hashes differently per hash. Modes not known to be salted return false
+func (t HashType) IsSalted() bool {
+	return saltedTypes[uint64(t)]
+}
+
+// ExpectedLength returns the length of a hash of the mode as a hex digest, 32 for md5 and ntlm,
+// or 0 for modes that aren't a plain digest or whose length isn't known
+func (t HashType) ExpectedLength() int {
+	return hashShapes[uint64(t)].hexLen
+}
+
+// ParseHashType returns the mode a name given by String, such as "ntlm", or a hashcat mode
+// number, such as "1000", stands for. Names are matched case-insensitively
+func ParseHashType(name string) (HashType, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for hashType, typeName := range hashTypeNames {
+		if typeName == name {
+			return HashType(hashType), nil
+		}
+	}
+
+	mode, err := strconv.ParseUint(name, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: unknown hash type %q", ErrInvalidHashType, name)
+	}
+	if mode > maxHashcatMode {
+		return 0, fmt.Errorf("%w: %d is not a hashcat mode (0 - %d)", ErrInvalidHashType, mode, maxHashcatMode)
+	}
+	return HashType(mode), nil
+}
+
+// checkHashLength returns an error if hash isn't a hex digest of the length of hashType, for the
+// modes whose length is known. A salt after the digest, hash:salt, doesn't count against it
+func checkHashLength(hash string, hashType uint64) error {
+	want := HashType(hashType).ExpectedLength()
+	if want == 0 {
+		return nil
+	}
+	digest, _, _ := strings.Cut(strings.TrimSpace(hash), ":")
+	if len(digest) != want || !isHex(digest) {
+		return fmt.Errorf("%w: %s hashes are %d hex characters", ErrInvalidHash, HashType(hashType), want)
+	}
+	return nil
+}
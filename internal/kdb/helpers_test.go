@@ -0,0 +1,71 @@
+package kdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// testKey is the encryption key of the databases newTestDB opens
+var testKey = []byte("12345678901234567890123456789012")
+
+// testPrefixes are the key prefixes the tests run under, the default and one an embedding
+// application might pick
+var testPrefixes = []string{DefaultKeyPrefix, "embedded"}
+
+// testPrefix is the key prefix of the current run of the tests. Tests pass it to newTestDB,
+// databases laid out by hand with default keys pass DefaultKeyPrefix
+var testPrefix string
+
+// TestMain runs the tests once per key prefix of testPrefixes, so no key is built without it
+func TestMain(m *testing.M) {
+	code := 0
+	for _, testPrefix = range testPrefixes {
+		if c := m.Run(); c != 0 {
+			fmt.Fprintf(os.Stderr, "the tests failed under the key prefix %q\n", testPrefix)
+			code = c
+		}
+	}
+	os.Exit(code)
+}
+
+// newTestDB opens a database with low memory options and the key prefix prefix in a temporary
+// directory of t, closed when the test ends. configure, if not nil, adjusts the options first
+func newTestDB(t testing.TB, prefix string, configure func(opts *Options)) *KDB {
+	t.Helper()
+	return newTestDBIn(t, t.TempDir(), prefix, configure)
+}
+
+// newTestDBIn is newTestDB for the database in dir
+func newTestDBIn(t testing.TB, dir, prefix string, configure func(opts *Options)) *KDB {
+	t.Helper()
+
+	db, err := openTestDB(t, dir, prefix, configure)
+	if err != nil {
+		t.Fatalf("failed to open a test database: %v", err)
+	}
+	return db
+}
+
+// openTestDB opens the database in dir like newTestDB, returning the error of New
+func openTestDB(t testing.TB, dir, prefix string, configure func(opts *Options)) (*KDB, error) {
+	t.Helper()
+
+	opts := LowMemoryOptions()
+	opts.Logger = func(string, Severity) {}
+	opts.KeyPrefix = prefix
+	if configure != nil {
+		configure(opts)
+	}
+	db, err := New(dir, testKey, opts)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, nil
+}
+
+// testHash returns the i-th of a run of distinct md5 hashes, 32 hex characters
+func testHash(i int) string {
+	return fmt.Sprintf("%032x", i)
+}
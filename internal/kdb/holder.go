@@ -0,0 +1,69 @@
+package kdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+)
+
+// holderFile names the process holding a database directory open. badger's own lock file can't
+// be read on Windows, so the holder is recorded next to it
+const holderFile = "krkn.pid"
+
+// badgerLockedMessage is part of the error badger.Open returns when another process holds the
+// directory lock, on every platform
+const badgerLockedMessage = "Another process is using this Badger database"
+
+// isLockedError returns true if badger.Open failed because the directory is locked
+func isLockedError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), badgerLockedMessage)
+}
+
+// readHolder returns the pid recorded in dir, 0 if there is none
+func readHolder(dir string) int {
+	data, err := os.ReadFile(filepath.Join(dir, holderFile))
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// liveHolder returns the pid of the running process holding dir, 0 if the recorded one is gone.
+// Another database of this process counts as well
+func liveHolder(dir string) int {
+	pid := readHolder(dir)
+	if pid == os.Getpid() || util.ProcessAlive(pid) {
+		return pid
+	}
+	return 0
+}
+
+// claimHolder records this process as the holder of dir, logging through log. A holder left
+// behind by a process that is gone means it didn't close the database, its counters are recounted
+// by the dirty recount
+func claimHolder(dir string, log Logger) error {
+	if pid := readHolder(dir); pid != 0 && pid != os.Getpid() && !util.ProcessAlive(pid) {
+		log(fmt.Sprintf("Process %d didn't close the database cleanly, taking over its stale lock", pid), Warning)
+	}
+	return os.WriteFile(filepath.Join(dir, holderFile), []byte(strconv.Itoa(os.Getpid())), 0600)
+}
+
+// releaseHolder removes the holder file of dir if this process wrote it
+func releaseHolder(dir string) error {
+	if readHolder(dir) != os.Getpid() {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(dir, holderFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package kdb
+
+// crackHook is called after a hash with a value has been written
+type crackHook func(sh *Hash)
+
+// addCrackHook registers a hook for cracked hashes, the returned func removes it again
+func (kc *KDB) addCrackHook(hook crackHook) func() {
+	kc.hooksMu.Lock()
+	defer kc.hooksMu.Unlock()
+
+	if kc.crackHooks == nil {
+		kc.crackHooks = make(map[int]crackHook)
+	}
+	id := kc.nextHookID
+	kc.nextHookID++
+	kc.crackHooks[id] = hook
+
+	return func() {
+		kc.hooksMu.Lock()
+		defer kc.hooksMu.Unlock()
+		delete(kc.crackHooks, id)
+	}
+}
+
+// fireCracked calls the crack hooks for every hash with a value. Must be called without kc.mu held
+func (kc *KDB) fireCracked(hashes ...*Hash) {
+	kc.hooksMu.RLock()
+	defer kc.hooksMu.RUnlock()
+
+	if len(kc.crackHooks) == 0 {
+		return
+	}
+	for _, sh := range hashes {
+		if sh.Value == "" {
+			continue
+		}
+		for _, hook := range kc.crackHooks {
+			hook(sh)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package kdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	// IdentityMetaKey is the meta entry marking the directory as a KrknDB database, see identityMarker
+	IdentityMetaKey = "identity"
+
+	identityApplication = "krkndb"
+)
+
+// identityMarker is written by the first open of a database and checked by every later one, so
+// New can tell its own data from another application's badger directory. Backups carry it, a
+// restored database keeps the marker of the one backed up
+type identityMarker struct {
+	Application   string    `json:"application"`
+	SchemaVersion int       `json:"schema_version"` // Schema version the database was created at
+	CreatedAt     time.Time `json:"created_at"`     // When the marker was written, UTC
+}
+
+// directoryContents is what checkIdentity found in a directory without an identity marker
+type directoryContents int
+
+const (
+	directoryEmpty    directoryContents = iota // No keys at all
+	directoryLegacy                            // This keyspace, written before identity markers
+	directoryKeyspace                          // Another keyspace of KrknDB, see checkKeyPrefix
+	directoryForeign                           // Another application's data
+)
+
+// checkIdentity makes sure the directory holds this database before anything is written to it.
+// A marker naming another application returns ErrForeignDatabase, as does badger data without a
+// marker that isn't KrknDB's unless Options.AdoptForeignDB is set. Empty directories and databases
+// written before markers existed get one: it is returned for writeIdentity once the open got
+// through, so a failed open doesn't stamp the directory. Returns nil if the directory has one
+func (kc *KDB) checkIdentity() (*identityMarker, error) {
+	stored, err := kc.GetMeta(IdentityMetaKey)
+	if err == nil {
+		var marker identityMarker
+		if err := json.Unmarshal(stored, &marker); err != nil {
+			return nil, fmt.Errorf("%w: unreadable identity marker: %v", ErrForeignDatabase, err)
+		}
+		if marker.Application != identityApplication {
+			return nil, fmt.Errorf("%w: the directory belongs to %q", ErrForeignDatabase, marker.Application)
+		}
+		return nil, nil
+	}
+	if !isNotFound(err) {
+		return nil, err
+	}
+
+	contents, err := kc.classifyDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	schema := currentSchemaVersion
+	switch contents {
+	case directoryKeyspace:
+		return nil, nil
+	case directoryEmpty:
+		// An existing but empty directory, such as a fresh temporary one, holds a new database
+		kc.isNew = true
+	case directoryLegacy:
+		if schema, err = kc.SchemaVersion(); err != nil {
+			return nil, err
+		}
+		kc.log("Recording the identity of a database created before identity markers", Info)
+	case directoryForeign:
+		if !kc.opts.AdoptForeignDB {
+			return nil, fmt.Errorf("%w: %s holds badger data without a KrknDB identity marker, set Options.AdoptForeignDB to use it anyway", ErrForeignDatabase, kc.parentFolder)
+		}
+		kc.log(fmt.Sprintf("Adopting %s, it holds badger data of another application", kc.parentFolder), Warning)
+		kc.isNew = true
+	}
+
+	return &identityMarker{
+		Application:   identityApplication,
+		SchemaVersion: schema,
+		CreatedAt:     time.Now().UTC(),
+	}, nil
+}
+
+// writeIdentity records the marker checkIdentity returned, if any
+func (kc *KDB) writeIdentity(marker *identityMarker) error {
+	if marker == nil {
+		return nil
+	}
+	data, err := json.Marshal(marker)
+	if err != nil {
+		return err
+	}
+	return kc.SetMeta(IdentityMetaKey, data)
+}
+
+// classifyDirectory tells apart the data of a directory without an identity marker. Every database
+// written before markers existed has a key probe, a hash type registry or a total counter
+func (kc *KDB) classifyDirectory() (directoryContents, error) {
+	contents := directoryEmpty
+	err := kc.c.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := kc.newIterator(txn, opts)
+		it.Rewind()
+		empty := !it.Valid()
+		it.Close()
+		if empty {
+			return nil
+		}
+
+		for _, key := range []string{kc.keys.key(metaPrefix, keyProbeMetaKey), kc.keys.key(hashTypeRegistryKey), kc.keys.key(totalHashesKey)} {
+			_, err := txn.Get([]byte(key))
+			if err == nil {
+				contents = directoryLegacy
+				return nil
+			}
+			if !isNotFound(err) {
+				return err
+			}
+		}
+		contents = directoryForeign
+		return nil
+	})
+	if err != nil || contents != directoryForeign {
+		return contents, err
+	}
+
+	other, err := kc.findKeyspace()
+	if err != nil {
+		return contents, err
+	}
+	if other != "" {
+		contents = directoryKeyspace
+	}
+	return contents, nil
+}
@@ -0,0 +1,170 @@
+package kdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// openRaw opens dir with badger alone, the way another application sharing the key would
+func openRaw(t *testing.T, dir string) *badger.DB {
+	t.Helper()
+	db, err := badger.Open(badger.DefaultOptions(dir).WithEncryptionKey(testKey).WithIndexCacheSize(1 << 20).WithLogger(nil))
+	if err != nil {
+		t.Fatalf("failed to open %s with badger: %v", dir, err)
+	}
+	return db
+}
+
+// rawKeys returns every key of the badger data in dir
+func rawKeys(t *testing.T, dir string) []string {
+	t.Helper()
+	db := openRaw(t, dir)
+	defer db.Close()
+	var keys []string
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, string(it.Item().Key()))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to list the keys of %s: %v", dir, err)
+	}
+	return keys
+}
+
+// identityOf returns the identity marker of db, nil if it has none
+func identityOf(db *KDB) *identityMarker {
+	data, err := db.GetMeta(IdentityMetaKey)
+	if err != nil {
+		return nil
+	}
+	var marker identityMarker
+	if json.Unmarshal(data, &marker) != nil {
+		return nil
+	}
+	return &marker
+}
+
+func adopting(opts *Options) { opts.AdoptForeignDB = true }
+
+func TestForeignDirectoriesAreRefused(t *testing.T) {
+	foreign := t.TempDir()
+	other := openRaw(t, foreign)
+	err := other.Update(func(txn *badger.Txn) error {
+		for _, key := range []string{"sessions/1", "sessions/2", "users/alice"} {
+			if err := txn.Set([]byte(key), []byte("theirs")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	other.Close()
+	if err != nil {
+		t.Fatalf("failed to write the other application's data: %v", err)
+	}
+
+	if _, err := openTestDB(t, foreign, testPrefix, nil); !errors.Is(err, ErrForeignDatabase) {
+		t.Fatalf("the foreign open returned %v", err)
+	}
+	keys := rawKeys(t, foreign)
+	if len(keys) != 3 || slices.ContainsFunc(keys, func(key string) bool { return strings.HasPrefix(key, testPrefix+":") }) {
+		t.Errorf("the refused open wrote keys: %v", keys)
+	}
+
+	// AdoptForeignDB opens it once and for all
+	adopted, err := openTestDB(t, foreign, testPrefix, adopting)
+	if err != nil {
+		t.Fatalf("the adopting open: %v", err)
+	}
+	if m := identityOf(adopted); m == nil || m.Application != identityApplication {
+		t.Errorf("the adopted directory has the marker %+v", m)
+	}
+	adopted.Close()
+	if _, err := openTestDB(t, foreign, testPrefix, nil); err != nil {
+		t.Errorf("reopening the adopted directory: %v", err)
+	}
+}
+
+func TestIdentityMarker(t *testing.T) {
+	dir := t.TempDir()
+	db := newTestDBIn(t, dir, testPrefix, nil)
+	marker := identityOf(db)
+	if marker == nil || marker.Application != identityApplication || marker.SchemaVersion != currentSchemaVersion || marker.CreatedAt.IsZero() {
+		t.Fatalf("a fresh database has the marker %+v", marker)
+	}
+	db.Close()
+	db = newTestDBIn(t, dir, testPrefix, nil)
+	if m := identityOf(db); m == nil || !m.CreatedAt.Equal(marker.CreatedAt) {
+		t.Errorf("reopening changed the marker to %+v", m)
+	}
+
+	// Databases written before markers are recognized
+	if err := db.DeleteMeta(IdentityMetaKey); err != nil {
+		t.Fatalf("DeleteMeta: %v", err)
+	}
+	db.Close()
+	db = newTestDBIn(t, dir, testPrefix, nil)
+	if m := identityOf(db); m == nil || m.Application != identityApplication {
+		t.Errorf("a database without a marker got %+v", m)
+	}
+
+	// A marker naming another application is refused, adopting or not
+	if err := db.SetMeta(IdentityMetaKey, []byte(`{"application":"other","schema_version":1}`)); err != nil {
+		t.Fatalf("SetMeta: %v", err)
+	}
+	db.Close()
+	if _, err := openTestDB(t, dir, testPrefix, adopting); !errors.Is(err, ErrForeignDatabase) {
+		t.Errorf("a marker of another application returned %v", err)
+	}
+}
+
+func TestFailedOpenLeavesNoMarker(t *testing.T) {
+	// Schema version 1 needs a migration, the open fails on the schema check after identity's
+	dir := t.TempDir()
+	writeV1Database(t, dir, v1Hashes())
+	if _, err := openTestDB(t, dir, DefaultKeyPrefix, nil); !errors.Is(err, ErrMigrationRequired) {
+		t.Fatalf("the version 1 open returned %v", err)
+	}
+	identity := DefaultKeyPrefix + ":" + strings.Replace(metaPrefix, "%s", IdentityMetaKey, 1)
+	if keys := rawKeys(t, dir); slices.Contains(keys, identity) {
+		t.Errorf("the failed open stamped the directory")
+	}
+
+	db := newTestDBIn(t, dir, DefaultKeyPrefix, func(opts *Options) { opts.AutoMigrate = true })
+	if m := identityOf(db); m == nil || m.SchemaVersion != 1 {
+		t.Errorf("the migrated database has the marker %+v", m)
+	}
+}
+
+func TestRestoreKeepsTheMarker(t *testing.T) {
+	source := newTestDB(t, testPrefix, nil)
+	if _, err := source.StoreHash(NewHash(testHash(0), "password", MD5)); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	var backup bytes.Buffer
+	if _, err := source.Backup(&backup); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	want := identityOf(source)
+
+	dir := filepath.Join(t.TempDir(), "restored")
+	restored := newTestDBIn(t, dir, testPrefix, nil)
+	if _, err := restored.Restore(&backup, nil); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	restored.Close()
+	restored = newTestDBIn(t, dir, testPrefix, nil)
+	if m := identityOf(restored); m == nil || !m.CreatedAt.Equal(want.CreatedAt) {
+		t.Errorf("the restored marker %+v, backed up %+v", m, want)
+	}
+}
@@ -0,0 +1,296 @@
+package kdb
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+)
+
+const (
+	importBatchSize     = 1000    // Hashes written per StoreHashes call during imports
+	importMaxLineSize   = 8 << 20 // Longest line the importers accept
+	importMaxErrorLines = 20      // Problems kept in ImportReport.Errors
+)
+
+// ImportReport summarizes an import
+type ImportReport struct {
+	Lines    int      `json:"lines"`            // Non-blank lines (or records) read
+	Imported int      `json:"imported"`         // Hashes stored
+	Invalid  int      `json:"invalid"`          // Lines that couldn't be parsed or failed validation
+	Errors   []string `json:"errors,omitempty"` // The first few problems, for diagnostics
+
+	// Conflicts counts hashes that were already stored with a different value, only importers
+	// that check for conflicts fill it in. ConflictHashes holds the first few of them
+	Conflicts      int      `json:"conflicts,omitempty"`
+	ConflictHashes []string `json:"conflict_hashes,omitempty"`
+
+	// Duplicates counts hashes skipped because they were already stored with the same value,
+	// only importers that check for conflicts fill it in
+	Duplicates int `json:"duplicates,omitempty"`
+
+	// SkippedDuplicates counts hashes Options.DedupWindow found stored moments ago as they are
+	// and didn't write again, they aren't counted as imported
+	SkippedDuplicates int `json:"skipped_duplicates,omitempty"`
+
+	// Rejected counts values that don't hash to their hash and weren't imported, Unverified the
+	// values imported without a check because their mode can't be computed locally. Both are only
+	// filled in with Options.VerifyCracks
+	Rejected   int `json:"rejected,omitempty"`
+	Unverified int `json:"unverified,omitempty"`
+}
+
+// importer batches parsed hashes into StoreHashes and keeps the report.
+// Every importer goes through it so validation and reporting behave the same everywhere
+type importer struct {
+	kc     *KDB
+	report ImportReport
+	batch  []*Hash
+
+	checkConflicts bool           // look for stored hashes with a different value before writing
+	onConflict     ConflictPolicy // what to do with them
+	wholeRecords   bool           // replace stored records even when the value is the same, see ApplyDelta
+	keepStored     bool           // carry values and meta of stored hashes over, see keepStoredRecords
+	verify         bool           // check values with VerifyCrack, see Options.VerifyCracks
+	session        *Session       // records the hashes written, nil outside Session.IngestStream
+
+	source   *Source              // recorded on every hash of a potfile
+	listOpts HashListOptions      // where the hash is on a hash list line
+	progress func(IngestProgress) // see IngestStream, nil for the Import methods
+	started  time.Time
+	reported time.Time // when progress was last reported
+}
+
+func (kc *KDB) newImporter() *importer {
+	return &importer{
+		kc:     kc,
+		batch:  make([]*Hash, 0, importBatchSize),
+		verify: kc.opts != nil && kc.opts.VerifyCracks,
+	}
+}
+
+// invalid records a line that can't be imported
+func (im *importer) invalid(line int, err error) {
+	im.report.Invalid++
+	if len(im.report.Errors) < importMaxErrorLines {
+		im.report.Errors = append(im.report.Errors, fmt.Sprintf("line %d: %v", line, err))
+	}
+}
+
+// add validates a parsed hash and queues it, flushing full batches
+func (im *importer) add(line int, sh *Hash) error {
+	if err := im.kc.validateHash(sh); err != nil {
+		im.invalid(line, err)
+		return nil
+	}
+	if im.verify {
+		ok, err := im.verifyFound(line, sh)
+		if err != nil {
+			im.invalid(line, err)
+			return nil
+		}
+		if !ok {
+			return nil
+		}
+	}
+	im.kc.bindHash(sh)
+
+	im.batch = append(im.batch, sh)
+	if len(im.batch) >= importBatchSize {
+		return im.flush()
+	}
+	return nil
+}
+
+// flush writes the queued hashes
+func (im *importer) flush() error {
+	if len(im.batch) == 0 {
+		return nil
+	}
+
+	if im.checkConflicts {
+		if err := im.resolveConflicts(); err != nil {
+			return err
+		}
+	}
+	if im.keepStored {
+		if err := im.keepStoredRecords(); err != nil {
+			return fmt.Errorf("failed to read stored hashes: %w", err)
+		}
+	}
+
+	if im.session != nil {
+		if err := im.session.record(im.batch); err != nil {
+			return err
+		}
+	}
+	if im.wholeRecords {
+		for _, sh := range im.batch {
+			im.kc.dedup.forget(sh.Key)
+		}
+	}
+	res, err := im.kc.storeHashes(im.batch, nil)
+	if err != nil {
+		return err
+	}
+
+	im.report.Imported += len(im.batch) - res.Skipped
+	im.report.SkippedDuplicates += res.Skipped
+	im.batch = im.batch[:0]
+	return nil
+}
+
+// finish flushes what's left and returns the report
+func (im *importer) finish() (ImportReport, error) {
+	if err := im.flush(); err != nil {
+		return im.report, fmt.Errorf("failed to store hashes: %w", err)
+	}
+
+	if im.report.Rejected > 0 {
+		im.kc.log(fmt.Sprintf("%d imported values don't hash to their hash and were rejected", im.report.Rejected), Warning)
+	}
+	if im.report.Conflicts > 0 {
+		im.kc.log(fmt.Sprintf("%d imported hashes were already stored with a different value", im.report.Conflicts), Warning)
+	}
+	im.kc.log(fmt.Sprintf("Imported %d hashes from %d lines, %d invalid", im.report.Imported, im.report.Lines, im.report.Invalid), Info)
+	return im.report, nil
+}
+
+// scanLines calls fn for every non-blank line of r with the line number and the line
+// without its line ending. Windows line endings and a leading UTF-8 byte order mark are tolerated
+func scanLines(r io.Reader, fn func(line int, text string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), importMaxLineSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if text == "" {
+			continue
+		}
+		if err := fn(line, text); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// ImportPotfile imports hashcat potfile lines (hash:plain) as hashes of hashType.
+// Salted hashes (hash:salt:plain) are kept whole and key the same as a hash built with that salt,
+// plaintexts keep their colons, see parsePotfileLine. $HEX[...] plaintexts are decoded.
+// Lines that can't be parsed or fail validation are counted as invalid and skipped.
+// An optional source is recorded on every imported hash as the attack that cracked it
+func (kc *KDB) ImportPotfile(r io.Reader, hashType uint64, source ...*Source) (ImportReport, error) {
+	im := kc.newImporter()
+	if len(source) > 0 {
+		im.source = source[0]
+	}
+	return kc.ingest(context.Background(), im, r, FormatPotfile, hashType, nil)
+}
+
+// HashListOptions tells ImportHashList where the hash is on a line
+type HashListOptions struct {
+	// Column is the field holding the hash, counted from 1, when lines are split at Separator, such
+	// as 2 for user:hash. 0 takes the whole line as the hash
+	Column int
+	// Separator splits lines into fields, ":" if empty. Only used with a Column
+	Separator string
+}
+
+// ImportHashList imports a list of uncracked hashes of hashType, one per line, such as a target's
+// hash dump loaded before the engagement so coverage can be tracked against it. Hashes are stored
+// without a value. Hashes already stored, cracked or not, are skipped as duplicates and keep their
+// value. Lines without the column are counted as invalid
+func (kc *KDB) ImportHashList(r io.Reader, hashType uint64, opts HashListOptions) (ImportReport, error) {
+	if opts.Column < 0 {
+		return ImportReport{}, fmt.Errorf("invalid hash list column %d", opts.Column)
+	}
+	im := kc.newImporter()
+	im.listOpts = opts
+	return kc.ingest(context.Background(), im, r, FormatHashList, hashType, nil)
+}
+
+// parseHashListLine returns the uncracked hash in the column of a hash list line
+func (im *importer) parseHashListLine(text string, hashType uint64) (*Hash, error) {
+	hash := text
+	if im.listOpts.Column > 0 {
+		separator := im.listOpts.Separator
+		if separator == "" {
+			separator = ":"
+		}
+		fields := strings.Split(text, separator)
+		if len(fields) < im.listOpts.Column {
+			return nil, fmt.Errorf("no column %d", im.listOpts.Column)
+		}
+		hash = fields[im.listOpts.Column-1]
+	}
+	hash = strings.TrimSpace(hash)
+	if hash == "" {
+		return nil, ErrEmptyHash
+	}
+	return im.kc.NewHash(hash, "", hashType), nil
+}
+
+// potfileMaxSplits is how many colons from the end of a potfile line are tried as the end of its hash
+const potfileMaxSplits = 4
+
+// parsePotfileLine splits a potfile line into the hash and the plaintext. Plaintexts may hold
+// colons, so like hashcat the split is chosen by the hash: modes of a known digest length that
+// aren't salted end the hash after the digest, other lines take the colon nearest the end whose
+// hash is stored, and the last colon when none is
+func (kc *KDB) parsePotfileLine(text string, hashType uint64) (*Hash, error) {
+	first, last := strings.IndexByte(text, ':'), strings.LastIndexByte(text, ':')
+	if first < 0 {
+		return nil, errors.New("missing ':' separator")
+	}
+
+	ht := HashType(hashType)
+	if first == last || (!ht.IsSalted() && first == ht.ExpectedLength() && isHex(text[:first])) {
+		return kc.newPotfileHash(text, first, hashType)
+	}
+
+	candidates := make([]*Hash, 0, potfileMaxSplits)
+	for i := last; i >= 0 && len(candidates) < potfileMaxSplits; i = strings.LastIndexByte(text[:i], ':') {
+		if sh, err := kc.newPotfileHash(text, i, hashType); err == nil {
+			candidates = append(candidates, sh)
+		}
+	}
+	match, err := kc.firstStored(candidates)
+	if err != nil {
+		return nil, err
+	}
+	if match != nil {
+		return match, nil
+	}
+	return kc.newPotfileHash(text, last, hashType)
+}
+
+// newPotfileHash returns the hash of a potfile line split at its colon i
+func (kc *KDB) newPotfileHash(text string, i int, hashType uint64) (*Hash, error) {
+	plain, err := util.DecodeHexPlain(text[i+1:])
+	if err != nil {
+		return nil, err
+	}
+	return kc.NewHash(text[:i], plain, hashType), nil
+}
+
+// ImportCSV imports CSV written by ExportCSV: hash,salt,value,type with an optional header row.
+// Values are decoded with util.DecodeHexPlain
+func (kc *KDB) ImportCSV(r io.Reader) (ImportReport, error) {
+	return kc.ingest(context.Background(), kc.newImporter(), r, FormatCSV, 0, nil)
+}
+
+// ImportJSONL imports lines written by ExportJSONL, one Hash JSON object per line
+func (kc *KDB) ImportJSONL(r io.Reader) (ImportReport, error) {
+	return kc.ingest(context.Background(), kc.newImporter(), r, FormatJSONL, 0, nil)
+}
@@ -0,0 +1,207 @@
+package kdb
+
+import (
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// indexWriter is what secondary index entries are written through, a transaction or a write batch
+type indexWriter interface {
+	Set(key, value []byte) error
+	Delete(key []byte) error
+}
+
+// storedRecord returns the hash stored under key in txn, nil if there is none. Records that don't
+// decode count as absent, storing over them gives the hash fresh index entries
+func (kc *KDB) storedRecord(txn *badger.Txn, key []byte) (*Hash, error) {
+	item, err := txn.Get(key)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stored := &Hash{}
+	if err := item.Value(func(val []byte) error {
+		return kc.decodeHash(val, stored)
+	}); err != nil {
+		return nil, nil
+	}
+	return stored, nil
+}
+
+// indexHash updates the secondary indexes for a hash about to be stored in txn. The hash gets its
+// insertion sequence, the one it was first stored with if it has one, so it must be encoded after
+func (kc *KDB) indexHash(txn *badger.Txn, sh *Hash) error {
+	stored, err := kc.storedRecord(txn, sh.Key)
+	if err != nil {
+		return err
+	}
+
+	if stored != nil && stored.seq != 0 {
+		sh.seq = stored.seq
+	} else {
+		seq, err := kc.readInsertionSeq(txn)
+		if err != nil {
+			return err
+		}
+		if err := txn.Set([]byte(kc.keys.key(insertionSeqKey)), insertionSeqValue(seq+1)); err != nil {
+			return err
+		}
+		sh.seq = seq
+		if err := txn.Set(kc.keys.insertionKey(sh.HashType, seq, sh.sum), nil); err != nil {
+			return err
+		}
+	}
+	kc.mergeCaptures(sh, stored)
+
+	if delta := crackedDelta(sh, stored); delta != 0 {
+		if err := addCount(txn, kc.keys.key(crackedCountPrefix, sh.HashType), delta); err != nil {
+			return err
+		}
+	}
+	if err := kc.indexCrackTime(txn, sh, stored, time.Now()); err != nil {
+		return err
+	}
+	if err := kc.indexUser(txn, sh, stored); err != nil {
+		return err
+	}
+	return kc.indexValue(txn, sh, stored)
+}
+
+// indexHashes is indexHash for a write batch. The stored records and the insertion counter are
+// read in a read transaction and the entries queued on wb, the caller holds kc.mu so nothing else
+// takes sequences in between. Returns how many hashes of each type weren't stored yet
+func (kc *KDB) indexHashes(wb *badger.WriteBatch, hashes []*Hash) (map[uint64]int, error) {
+	var next uint64
+	stored := make([]*Hash, len(hashes))
+	cracked := make(map[uint64]int)
+	err := kc.c.View(func(txn *badger.Txn) error {
+		var err error
+		if next, err = kc.readInsertionSeq(txn); err != nil {
+			return err
+		}
+		for i, sh := range hashes {
+			if stored[i], err = kc.storedRecord(txn, sh.Key); err != nil {
+				return err
+			}
+			if _, ok := cracked[sh.HashType]; !ok {
+				count, err := readCount(txn, kc.keys.key(crackedCountPrefix, sh.HashType))
+				if err != nil && !isNotFound(err) {
+					return err
+				}
+				cracked[sh.HashType] = count
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A hash in the batch twice takes the sequence of its first copy, later copies are stored over
+	// the earlier ones
+	batch := make(map[string]uint64)
+	previous := make(map[string]*Hash)
+	fresh := make(map[uint64]int)
+	now := time.Now()
+	for i, sh := range hashes {
+		before := stored[i]
+		if p, ok := previous[string(sh.Key)]; ok {
+			before = p
+		}
+		if before == nil {
+			fresh[sh.HashType]++
+		}
+		kc.mergeCaptures(sh, before)
+		cracked[sh.HashType] += crackedDelta(sh, before)
+
+		switch seq, ok := batch[string(sh.Key)]; {
+		case stored[i] != nil && stored[i].seq != 0:
+			sh.seq = stored[i].seq
+		case ok:
+			sh.seq = seq
+		default:
+			sh.seq = next
+			batch[string(sh.Key)] = next
+			next++
+			if err := wb.Set(kc.keys.insertionKey(sh.HashType, sh.seq, sh.sum), nil); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := kc.indexCrackTime(wb, sh, before, now); err != nil {
+			return nil, err
+		}
+		if err := kc.indexUser(wb, sh, before); err != nil {
+			return nil, err
+		}
+		if err := kc.indexValue(wb, sh, before); err != nil {
+			return nil, err
+		}
+
+		// Later copies are indexed over this one as it will be written, with its value normalized
+		written := *sh
+		written.Value = kc.normalizeValue(sh.Value)
+		previous[string(sh.Key)] = &written
+	}
+
+	for hashType, count := range cracked {
+		if err := wb.Set([]byte(kc.keys.key(crackedCountPrefix, hashType)), countValue(count)); err != nil {
+			return nil, err
+		}
+	}
+	if err := wb.Set([]byte(kc.keys.key(insertionSeqKey)), insertionSeqValue(next)); err != nil {
+		return nil, err
+	}
+	return fresh, nil
+}
+
+// unindexHash removes the secondary index entries of a stored hash that is being deleted in txn
+// and takes it off the cracked count
+func (kc *KDB) unindexHash(txn *badger.Txn, stored *Hash) error {
+	if stored.seq != 0 {
+		if err := txn.Delete(kc.keys.insertionKey(stored.HashType, stored.seq, stored.sum)); err != nil {
+			return err
+		}
+	}
+	if key := kc.keys.hashUserKey(stored); key != nil {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	if stored.Value == "" {
+		return nil
+	}
+	if err := addCount(txn, kc.keys.key(crackedCountPrefix, stored.HashType), -1); err != nil {
+		return err
+	}
+	if !stored.CrackedAt.IsZero() {
+		if err := txn.Delete(kc.keys.crackTimeKey(stored.HashType, stored.CrackedAt, stored.sum)); err != nil {
+			return err
+		}
+	}
+	if kc.valueIndexEnabled() {
+		if err := txn.Delete(kc.keys.valueIndexKey(stored.HashType, stored.Value, stored.sum)); err != nil {
+			return err
+		}
+	}
+	if kc.valueFoldEnabled() {
+		return txn.Delete(kc.keys.valueFoldKey(stored.HashType, stored.Value, stored.sum))
+	}
+	return nil
+}
+
+// crackedDelta returns how storing sh over stored, nil if it is new, changes the cracked count
+func crackedDelta(sh, stored *Hash) int {
+	delta := 0
+	if sh.Value != "" {
+		delta++
+	}
+	if stored != nil && stored.Value != "" {
+		delta--
+	}
+	return delta
+}
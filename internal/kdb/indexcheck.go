@@ -0,0 +1,411 @@
+package kdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	defaultIndexCheckSample = 0.01 // fraction VerifyIndexesOnOpen checks when Options.VerifyIndexesSample is 0
+	indexRepairChunkSize    = 1000 // index entries fixed per transaction by RepairIndexes
+)
+
+// IndexName names a secondary index, for VerifyIndexes
+type IndexName string
+
+const (
+	IndexInsertion   IndexName = "insertion"    // Insertion order, see Order
+	IndexCrackTime   IndexName = "crack_time"   // Crack times, see Coverage
+	IndexUser        IndexName = "user"         // Accounts, see GetHashesByUser
+	IndexValue       IndexName = "value"        // Values, with Options.ValueIndex
+	IndexValueFolded IndexName = "value_folded" // Case folded values, with Options.ValueIndexFolded
+)
+
+// IndexDrift is what VerifyIndexes found for one index. Dangling entries point to a record that
+// is gone or indexes differently, missing entries are those of checked records that aren't there
+type IndexDrift struct {
+	Index    IndexName `json:"index"`
+	Records  int       `json:"records"` // Records checked for their entry
+	Entries  int       `json:"entries"` // Entries checked against their record
+	Dangling int       `json:"dangling"`
+	Missing  int       `json:"missing"`
+
+	fixes []indexFix // what RepairIndexes changes
+}
+
+// Drift returns the share of the checked records and entries that were wrong, 0 to 1
+func (d IndexDrift) Drift() float64 {
+	if d.Records+d.Entries == 0 {
+		return 0
+	}
+	return float64(d.Dangling+d.Missing) / float64(d.Records+d.Entries)
+}
+
+// indexFix is an entry RepairIndexes writes, for a missing one, or deletes, for a dangling one
+type indexFix struct {
+	key      []byte
+	hashType uint64
+	sum      string
+	missing  bool
+}
+
+// IndexReport is the result of VerifyIndexes, pass it to RepairIndexes to fix what it found
+type IndexReport struct {
+	At       time.Time     `json:"at"`
+	Duration time.Duration `json:"duration"`
+	Sample   float64       `json:"sample"` // Fraction of records and entries checked, 1 for all of them
+	Indexes  []IndexDrift  `json:"indexes"`
+}
+
+// OK returns true if no index drifted
+func (r *IndexReport) OK() bool {
+	for _, d := range r.Indexes {
+		if d.Dangling+d.Missing > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// String summarizes the report for logs
+func (r *IndexReport) String() string {
+	parts := make([]string, 0, len(r.Indexes))
+	for _, d := range r.Indexes {
+		parts = append(parts, fmt.Sprintf("%s: %d dangling, %d missing of %d records and %d entries", d.Index, d.Dangling, d.Missing, d.Records, d.Entries))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// LastIndexReport returns the report of the last VerifyIndexes, nil if none ran since open
+func (kc *KDB) LastIndexReport() *IndexReport {
+	return kc.indexReport.Load()
+}
+
+// maintainedIndexes returns the indexes the database keeps up to date
+func (kc *KDB) maintainedIndexes() []IndexName {
+	indexes := []IndexName{IndexInsertion, IndexCrackTime, IndexUser}
+	if kc.valueIndexEnabled() {
+		indexes = append(indexes, IndexValue)
+	}
+	if kc.valueFoldEnabled() {
+		indexes = append(indexes, IndexValueFolded)
+	}
+	return indexes
+}
+
+// indexEntry returns the entry a stored hash has in index, nil if it has none there
+func (kc *KDB) indexEntry(index IndexName, sh *Hash) []byte {
+	switch index {
+	case IndexInsertion:
+		if sh.seq != 0 {
+			return kc.keys.insertionKey(sh.HashType, sh.seq, sh.sum)
+		}
+	case IndexCrackTime:
+		if sh.Value != "" && !sh.CrackedAt.IsZero() {
+			return kc.keys.crackTimeKey(sh.HashType, sh.CrackedAt, sh.sum)
+		}
+	case IndexUser:
+		return kc.keys.hashUserKey(sh)
+	case IndexValue:
+		if sh.Value != "" {
+			return kc.keys.valueIndexKey(sh.HashType, kc.normalizeValue(sh.Value), sh.sum)
+		}
+	case IndexValueFolded:
+		if sh.Value != "" {
+			return kc.keys.valueFoldKey(sh.HashType, kc.normalizeValue(sh.Value), sh.sum)
+		}
+	}
+	return nil
+}
+
+// indexScanPrefix returns the prefix the entries of index for hashType are under. The user index
+// spans every type, so its prefix ignores hashType
+func (kc *KDB) indexScanPrefix(index IndexName, hashType uint64) []byte {
+	switch index {
+	case IndexInsertion:
+		return []byte(kc.keys.key(insertionScanPrefix, hashType))
+	case IndexCrackTime:
+		return []byte(kc.keys.key(crackTimeScanPrefix, hashType))
+	case IndexUser:
+		return []byte(kc.keys.key(userIndexPrefix))
+	case IndexValue:
+		return []byte(kc.keys.key(valueIndexPrefix, hashType))
+	default:
+		return []byte(kc.keys.key(valueFoldPrefix, hashType))
+	}
+}
+
+// entryTarget returns the hash type and hex sum an index entry points to. Every entry ends with
+// the hex sum, user entries carry the type before it as type:sum
+func entryTarget(index IndexName, key []byte, hashType uint64) (uint64, string, bool) {
+	if len(key) < 2*sha256.Size {
+		return 0, "", false
+	}
+	sum := string(key[len(key)-2*sha256.Size:])
+	if index != IndexUser {
+		return hashType, sum, isHex(sum)
+	}
+	rest := key[:len(key)-2*sha256.Size]
+	if !bytes.HasSuffix(rest, []byte(":")) {
+		return 0, "", false
+	}
+	rest = rest[:len(rest)-1]
+	typ, err := strconv.ParseUint(string(rest[bytes.LastIndexByte(rest, 0)+1:]), 10, 64)
+	return typ, sum, err == nil && isHex(sum)
+}
+
+// indexedRecord returns the record an index entry points to, with the type and sum it is stored
+// under, nil if there is none
+func (kc *KDB) indexedRecord(txn *badger.Txn, hashType uint64, sum string) (*Hash, error) {
+	stored, err := kc.storedRecord(txn, kc.keys.hashKey(hashType, sum))
+	if stored != nil {
+		stored.HashType, stored.sum = hashType, rawSum([]byte(sum))
+	}
+	return stored, err
+}
+
+// VerifyIndexes cross-checks secondary indexes against the stored records in both directions: the
+// entries of records that are missing, and entries whose record is gone or indexes differently.
+// which names the indexes, every maintained one if empty; naming one that isn't maintained is an
+// error. sample is the fraction of records and entries checked, drawn at random, 0 or 1 checks
+// all of them. Records stored before the insertion index existed have no entry there and don't
+// count as missing. The report, also kept for Stats, quantifies the drift of every index and
+// feeds RepairIndexes
+func (kc *KDB) VerifyIndexes(ctx context.Context, which []IndexName, sample float64) (IndexReport, error) {
+	if sample <= 0 || sample > 1 {
+		sample = 1
+	}
+	report := IndexReport{At: time.Now(), Sample: sample}
+	maintained := kc.maintainedIndexes()
+	if len(which) == 0 {
+		which = maintained
+	}
+	for _, index := range which {
+		if !slices.Contains(maintained, index) {
+			return report, fmt.Errorf("index %q is not maintained by this database", index)
+		}
+	}
+	checked := func() bool { return sample == 1 || rand.Float64() < sample }
+
+	err := kc.viewLocked(func(txn *badger.Txn) error {
+		hashTypes, err := kc.readHashTypes(txn)
+		if err != nil {
+			return fmt.Errorf("failed to get registered hash types: %w", err)
+		}
+
+		drift := make([]IndexDrift, len(which))
+		for i, index := range which {
+			drift[i].Index = index
+		}
+
+		// Records to entries, every index at once so each record is read once
+		for _, hashType := range hashTypes {
+			prefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = prefix
+			it := kc.newIterator(txn, opts)
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				if err := ctx.Err(); err != nil {
+					it.Close()
+					return err
+				}
+				if !checked() {
+					continue
+				}
+				var hash Hash
+				if err := it.Item().Value(func(val []byte) error {
+					return kc.decodeHash(val, &hash)
+				}); err != nil {
+					continue
+				}
+				sum := string(hexSum(it.Item().Key()[len(prefix):]))
+				hash.HashType, hash.sum = hashType, rawSum([]byte(sum))
+
+				for i, index := range which {
+					drift[i].Records++
+					key := kc.indexEntry(index, &hash)
+					if key == nil {
+						continue
+					}
+					if _, err := txn.Get(key); err == nil {
+						continue
+					} else if !isNotFound(err) {
+						it.Close()
+						return err
+					}
+					drift[i].Missing++
+					drift[i].fixes = append(drift[i].fixes, indexFix{key: key, hashType: hashType, sum: sum, missing: true})
+				}
+			}
+			it.Close()
+		}
+
+		// Entries to records, the user index is one range across every type
+		for i, index := range which {
+			if index == IndexUser {
+				if err := kc.checkIndexEntries(ctx, txn, &drift[i], 0, checked); err != nil {
+					return err
+				}
+				continue
+			}
+			for _, hashType := range hashTypes {
+				if err := kc.checkIndexEntries(ctx, txn, &drift[i], hashType, checked); err != nil {
+					return err
+				}
+			}
+		}
+		report.Indexes = drift
+		return nil
+	})
+
+	report.Duration = time.Since(report.At)
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to verify indexes: %v", err), Error)
+		return report, fmt.Errorf("failed to verify indexes: %w", err)
+	}
+	kc.indexReport.Store(&report)
+	if report.OK() {
+		kc.log(fmt.Sprintf("Verified indexes in %v: %s", report.Duration, report.String()), Info)
+	} else {
+		kc.log(fmt.Sprintf("Indexes drifted: %s", report.String()), Warning)
+	}
+	return report, nil
+}
+
+// checkIndexEntries checks the entries of one index under hashType, all of the user index, against
+// the records they point to
+func (kc *KDB) checkIndexEntries(ctx context.Context, txn *badger.Txn, drift *IndexDrift, hashType uint64, checked func() bool) error {
+	prefix := kc.indexScanPrefix(drift.Index, hashType)
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+	it := kc.newIterator(txn, opts)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !checked() {
+			continue
+		}
+		drift.Entries++
+		key := it.Item().KeyCopy(nil)
+		typ, sum, ok := entryTarget(drift.Index, key, hashType)
+		if ok {
+			stored, err := kc.indexedRecord(txn, typ, sum)
+			if err != nil {
+				return err
+			}
+			if stored != nil && bytes.Equal(kc.indexEntry(drift.Index, stored), key) {
+				continue
+			}
+		}
+		drift.Dangling++
+		if !ok {
+			typ, sum = 0, ""
+		}
+		drift.fixes = append(drift.fixes, indexFix{key: key, hashType: typ, sum: sum})
+	}
+	return nil
+}
+
+// RepairIndexes fixes what VerifyIndexes reported, the report as returned rather than read back
+// from JSON, which leaves the entries out. It writes the missing entries and deletes the
+// dangling ones in transactions of 1000 entries. Every fix is checked against the record and the
+// entry first, so entries that were put right or changed since the report are left alone.
+// Returns the number of entries written and deleted
+func (kc *KDB) RepairIndexes(report IndexReport) (int, error) {
+	var fixes []indexFix
+	var indexes []IndexName
+	for _, d := range report.Indexes {
+		for _, fix := range d.fixes {
+			fixes = append(fixes, fix)
+			indexes = append(indexes, d.Index)
+		}
+	}
+
+	repaired := 0
+	for start := 0; start < len(fixes); start += indexRepairChunkSize {
+		end := min(start+indexRepairChunkSize, len(fixes))
+		n := 0
+		err := kc.updateLocked(func(txn *badger.Txn) error {
+			n = 0
+			for i := start; i < end; i++ {
+				fix := fixes[i]
+				var want []byte
+				if fix.sum != "" {
+					stored, err := kc.indexedRecord(txn, fix.hashType, fix.sum)
+					if err != nil {
+						return err
+					}
+					if stored != nil {
+						want = kc.indexEntry(indexes[i], stored)
+					}
+				}
+				indexed := bytes.Equal(want, fix.key)
+				_, err := txn.Get(fix.key)
+				if err != nil && !isNotFound(err) {
+					return err
+				}
+				present := err == nil
+				switch {
+				case fix.missing && indexed && !present:
+					if err := txn.Set(fix.key, nil); err != nil {
+						return err
+					}
+				case !fix.missing && !indexed && present:
+					if err := txn.Delete(fix.key); err != nil {
+						return err
+					}
+				default:
+					continue
+				}
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to repair indexes: %v", err), Error)
+			return repaired, fmt.Errorf("failed to repair indexes: %w", err)
+		}
+		repaired += n
+	}
+
+	kc.log(fmt.Sprintf("Repaired indexes, %d entries changed", repaired), Info)
+	return repaired, nil
+}
+
+// StartIndexCheck runs VerifyIndexes as an OpCheck operation and, with repair, RepairIndexes on
+// what it found. Progress counts the steps, verifying and repairing
+func (kc *KDB) StartIndexCheck(which []IndexName, sample float64, repair bool) (uint64, error) {
+	return kc.StartOperation(OpCheck, "VerifyIndexes", func(ctx context.Context, progress func(done, total int64)) error {
+		steps := int64(1)
+		if repair {
+			steps = 2
+		}
+		report, err := kc.VerifyIndexes(ctx, which, sample)
+		if err != nil {
+			return err
+		}
+		progress(1, steps)
+		if !repair || report.OK() {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_, err = kc.RepairIndexes(report)
+		progress(2, steps)
+		return err
+	})
+}
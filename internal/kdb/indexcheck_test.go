@@ -0,0 +1,135 @@
+package kdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// fixtureTypes are the hash types of indexFixture
+var fixtureTypes = []uint64{0, NTLM}
+
+// indexFixture stores hashes of two types, every other cracked and all with a user, in a database
+// keeping every index. Returns the database and the key of a cracked hash
+func indexFixture(t *testing.T) (*KDB, string) {
+	t.Helper()
+	db := newTestDB(t, testPrefix, func(opts *Options) {
+		opts.ValueIndex = true
+		opts.ValueIndexFolded = true
+	})
+	for i := range 60 {
+		value := ""
+		if i%2 == 0 {
+			value = fmt.Sprintf("Winter%d!", i)
+		}
+		h := NewHash(testHash(i), value, fixtureTypes[i/30])
+		h.Meta = map[string]string{UserMetaKey: fmt.Sprintf("user%d", i)}
+		if _, err := db.StoreHash(h); err != nil {
+			t.Fatalf("store %d: %v", i, err)
+		}
+	}
+	victim, err := db.GetHashByOriginalHash(testHash(4), 0)
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	return db, string(victim.Key)
+}
+
+// indexKeys returns the entries of index, of every hash type of the fixture
+func indexKeys(t *testing.T, db *KDB, index IndexName) []string {
+	t.Helper()
+	prefixes := map[string]bool{}
+	for _, hashType := range fixtureTypes {
+		prefixes[string(db.indexScanPrefix(index, hashType))] = true
+	}
+	var keys []string
+	err := db.c.View(func(txn *badger.Txn) error {
+		for prefix := range prefixes {
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = []byte(prefix)
+			opts.PrefetchValues = false
+			it := txn.NewIterator(opts)
+			for it.Rewind(); it.Valid(); it.Next() {
+				keys = append(keys, string(it.Item().KeyCopy(nil)))
+			}
+			it.Close()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("scanning the %s index: %v", index, err)
+	}
+	return keys
+}
+
+func TestVerifyAndRepairIndexes(t *testing.T) {
+	db, victim := indexFixture(t)
+	ctx := context.Background()
+
+	report, err := db.VerifyIndexes(ctx, nil, 1)
+	if err != nil || !report.OK() || len(report.Indexes) != 5 {
+		t.Fatalf("the fresh fixture: %s %v", report.String(), err)
+	}
+
+	// Behind the database's back: delete the victim's record, leaving its entries dangling, delete
+	// two entries of other records from every index and make one up pointing nowhere
+	sum := victim[len(victim)-64:]
+	dangling, missing := map[IndexName]int{}, map[IndexName]int{}
+	var set, del []string
+	for _, index := range db.maintainedIndexes() {
+		keys := indexKeys(t, db, index)
+		for _, key := range keys {
+			switch {
+			case strings.HasSuffix(key, sum):
+				dangling[index]++
+			case missing[index] < 2:
+				del = append(del, key)
+				missing[index]++
+			}
+		}
+		set = append(set, keys[0][:len(keys[0])-64]+strings.Repeat("0", 64))
+		dangling[index]++
+	}
+	err = db.c.Update(func(txn *badger.Txn) error {
+		for _, key := range set {
+			if err := txn.Set([]byte(key), nil); err != nil {
+				return err
+			}
+		}
+		for _, key := range append(del, victim) {
+			if err := txn.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("corrupting the indexes: %v", err)
+	}
+
+	report, err = db.VerifyIndexes(ctx, nil, 1)
+	if err != nil || report.OK() {
+		t.Fatalf("the corruption wasn't found: %s %v", report.String(), err)
+	}
+	want := 0
+	for _, d := range report.Indexes {
+		if d.Dangling != dangling[d.Index] || d.Missing != missing[d.Index] {
+			t.Errorf("%s: %d dangling and %d missing, want %d and %d", d.Index, d.Dangling, d.Missing, dangling[d.Index], missing[d.Index])
+		}
+		want += dangling[d.Index] + missing[d.Index]
+	}
+
+	repaired, err := db.RepairIndexes(report)
+	if err != nil || repaired != want {
+		t.Errorf("%d entries repaired, want %d: %v", repaired, want, err)
+	}
+	if report, err = db.VerifyIndexes(ctx, nil, 1); err != nil || !report.OK() {
+		t.Errorf("the repaired fixture drifted: %s %v", report.String(), err)
+	}
+	if repaired, err = db.RepairIndexes(report); err != nil || repaired != 0 {
+		t.Errorf("a clean report repaired %d: %v", repaired, err)
+	}
+}
@@ -0,0 +1,135 @@
+package kdb
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	defaultMaxOpenIterators = 64      // iterators of the iteration APIs open at once
+	defaultMaxBatchBytes    = 1 << 30 // bytes StoreHashes batches buffer at once
+	batchEntryOverhead      = 64      // bytes a batch entry takes besides its key and fields
+)
+
+// inflight accounts for the open iterators and the bytes write batches buffer, see
+// Options.MaxOpenIterators and Options.MaxBatchBytes
+type inflight struct {
+	iterators        atomic.Int64  // badger iterators open, internal ones included
+	limited          atomic.Int64  // iterators of transactions and snapshots open, those MaxOpenIterators limits
+	peakIterators    atomic.Int64  // most iterators open at once since the database was opened
+	batchBytes       atomic.Int64  // bytes buffered by StoreHashes batches being written
+	peakBatchBytes   atomic.Int64  // most bytes buffered at once since the database was opened
+	refusedIterators atomic.Uint64 // iterators refused with ErrTooManyIterators
+	refusedBatches   atomic.Uint64 // batches refused with ErrBatchTooLarge
+}
+
+// InflightStats reports the open iterators and the bytes buffered by write batches
+type InflightStats struct {
+	OpenIterators    int64  `json:"open_iterators"`    // Badger iterators open, internal ones included
+	PeakIterators    int64  `json:"peak_iterators"`    // Most iterators open at once since the database was opened
+	BatchBytes       int64  `json:"batch_bytes"`       // Approximate bytes buffered by StoreHashes batches being written
+	PeakBatchBytes   int64  `json:"peak_batch_bytes"`  // Most bytes buffered at once since the database was opened
+	RefusedIterators uint64 `json:"refused_iterators"` // Iterations refused with ErrTooManyIterators
+	RefusedBatches   uint64 `json:"refused_batches"`   // Batches refused with ErrBatchTooLarge
+}
+
+// snapshot returns the current figures
+func (in *inflight) snapshot() InflightStats {
+	return InflightStats{
+		OpenIterators:    in.iterators.Load(),
+		PeakIterators:    in.peakIterators.Load(),
+		BatchBytes:       in.batchBytes.Load(),
+		PeakBatchBytes:   in.peakBatchBytes.Load(),
+		RefusedIterators: in.refusedIterators.Load(),
+		RefusedBatches:   in.refusedBatches.Load(),
+	}
+}
+
+// raisePeak raises peak to n if n is larger
+func raisePeak(peak *atomic.Int64, n int64) {
+	for {
+		old := peak.Load()
+		if n <= old || peak.CompareAndSwap(old, n) {
+			return
+		}
+	}
+}
+
+// iterator is a badger iterator counted by inflight, Close gives it back
+type iterator struct {
+	*badger.Iterator
+	kc      *KDB
+	limited bool // counted against Options.MaxOpenIterators
+}
+
+// Close closes the badger iterator. Closing twice is a no-op
+func (it *iterator) Close() {
+	if it.kc == nil {
+		return
+	}
+	it.Iterator.Close()
+	it.kc.inflight.iterators.Add(-1)
+	if it.limited {
+		it.kc.inflight.limited.Add(-1)
+	}
+	it.kc = nil
+}
+
+// newIterator opens an iterator within txn for work of the database itself, such as counting or a
+// migration, and for the iteration APIs of KDB. It is counted but never refused: the former are
+// short lived or bounded, and the latter hold kc.mu for the whole iteration, so only one of them is
+// ever open
+func (kc *KDB) newIterator(txn *badger.Txn, opts badger.IteratorOptions) *iterator {
+	raisePeak(&kc.inflight.peakIterators, kc.inflight.iterators.Add(1))
+	return &iterator{Iterator: txn.NewIterator(opts), kc: kc}
+}
+
+// openIterator opens an iterator within the txn of a Tx or Snapshot, whose caller decides how long
+// it stays open. Snapshots don't take kc.mu and a transaction can nest iterations, so any number
+// can be open at once. Returns ErrTooManyIterators once Options.MaxOpenIterators are open
+func (kc *KDB) openIterator(txn *badger.Txn, opts badger.IteratorOptions) (*iterator, error) {
+	if limit := int64(kc.opts.MaxOpenIterators); limit > 0 {
+		if open := kc.inflight.limited.Add(1); open > limit {
+			kc.inflight.limited.Add(-1)
+			kc.inflight.refusedIterators.Add(1)
+			err := fmt.Errorf("%w: %d open, Options.MaxOpenIterators is %d", ErrTooManyIterators, open-1, limit)
+			kc.recordError(err)
+			return nil, err
+		}
+	} else {
+		kc.inflight.limited.Add(1)
+	}
+
+	it := kc.newIterator(txn, opts)
+	it.limited = true
+	return it, nil
+}
+
+// batchFootprint approximates the bytes a write batch buffers for hashes: their keys and fields
+// along with badger's per entry bookkeeping
+func batchFootprint(hashes []*Hash) int64 {
+	var n int64
+	for _, sh := range hashes {
+		n += int64(len(sh.Key) + len(sh.Hash) + len(sh.Value) + len(sh.Session) + len(sh.Salt) + batchEntryOverhead)
+		for k, v := range sh.Meta {
+			n += int64(len(k) + len(v))
+		}
+	}
+	return n
+}
+
+// reserveBatch accounts for a batch buffering n bytes and returns the function giving them back.
+// Returns ErrBatchTooLarge if the batches being written would buffer more than
+// Options.MaxBatchBytes with it
+func (kc *KDB) reserveBatch(n int64) (func(), error) {
+	buffered := kc.inflight.batchBytes.Add(n)
+	if limit := kc.opts.MaxBatchBytes; limit > 0 && buffered > limit {
+		kc.inflight.batchBytes.Add(-n)
+		kc.inflight.refusedBatches.Add(1)
+		return nil, fmt.Errorf("%w: %d bytes with %d buffered by other batches, Options.MaxBatchBytes is %d", ErrBatchTooLarge, n, buffered-n, limit)
+	}
+	raisePeak(&kc.inflight.peakBatchBytes, buffered)
+	return func() { kc.inflight.batchBytes.Add(-n) }, nil
+}
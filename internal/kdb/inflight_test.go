@@ -0,0 +1,77 @@
+package kdb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRefusedIterationsReportErr(t *testing.T) {
+	db := newTestDB(t, testPrefix, func(opts *Options) { opts.MaxOpenIterators = 1 })
+	for i := range 3 {
+		if _, err := db.StoreHash(NewHash(testHash(i), "", 0)); err != nil {
+			t.Fatalf("StoreHash: %v", err)
+		}
+	}
+
+	err := db.View(func(tx *Tx) error {
+		outer, inner := 0, 0
+		for range tx.Hashes(0) {
+			outer++
+			for range tx.Hashes(0) {
+				inner++
+			}
+		}
+		if outer != 3 || inner != 0 {
+			t.Errorf("iterated %d hashes with %d nested", outer, inner)
+		}
+		if !errors.Is(tx.Err(), ErrTooManyIterators) {
+			t.Errorf("the refused transaction iteration left %v", tx.Err())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %v", err)
+	}
+
+	snap, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	for range snap.GetHashesByHashType(0) {
+		// The database's own iterations hold its lock throughout and aren't limited
+		n := 0
+		for range db.GetHashesByHashType(0) {
+			n++
+		}
+		if n != 3 {
+			t.Errorf("a database iteration beside a snapshot one found %d hashes", n)
+		}
+		for range snap.GetHashesByHashType(0) {
+			t.Errorf("a nested snapshot iteration past the limit yielded")
+		}
+		break
+	}
+	if !errors.Is(snap.Err(), ErrTooManyIterators) {
+		t.Errorf("the refused snapshot iteration left %v", snap.Err())
+	}
+	snap.Release()
+
+	snap, err = db.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	n := 0
+	for range snap.GetHashesByHashType(0) {
+		n++
+	}
+	if n != 3 || snap.Err() != nil {
+		t.Errorf("a snapshot iteration within the limit found %d hashes: %v", n, snap.Err())
+	}
+	snap.Release()
+	for range snap.GetHashesByHashType(0) {
+		t.Errorf("a released snapshot yielded")
+	}
+	if !errors.Is(snap.Err(), ErrSnapshotReleased) {
+		t.Errorf("iterating a released snapshot left %v", snap.Err())
+	}
+}
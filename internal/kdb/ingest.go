@@ -0,0 +1,365 @@
+package kdb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// defaultIngestProgressInterval is how often IngestStream reports progress unless
+	// Options.IngestProgressInterval says otherwise
+	defaultIngestProgressInterval = time.Second
+
+	ingestChunkSize = 64 << 10 // Bytes read from a stream at a time, see contextReader
+)
+
+// Format is the format of an import stream, see IngestStream
+type Format int
+
+const (
+	FormatPotfile      Format = iota // hash:plain, see ImportPotfile
+	FormatHashList                   // One uncracked hash per line, see ImportHashList
+	FormatFounds                     // hash:plain founds list, see ImportFounds
+	FormatFoundsSalted               // hash:salt:plain founds list
+	FormatHashtopolis                // Hashtopolis cracked hashes export, see ImportHashtopolisFounds
+	FormatPwdump                     // user:rid:lm:nt::: lines, see ImportPwdump
+	FormatCSV                        // hash,salt,value,type, see ImportCSV
+	FormatJSONL                      // One Hash JSON object per line, see ImportJSONL
+)
+
+// formatNames are the names ParseFormat accepts and Format.String returns
+var formatNames = map[Format]string{
+	FormatPotfile:      "potfile",
+	FormatHashList:     "hashlist",
+	FormatFounds:       "founds",
+	FormatFoundsSalted: "founds-salted",
+	FormatHashtopolis:  "hashtopolis",
+	FormatPwdump:       "pwdump",
+	FormatCSV:          "csv",
+	FormatJSONL:        "jsonl",
+}
+
+// zstdMagic starts every zstd frame
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// String returns the name of the format, e.g. "potfile"
+func (f Format) String() string {
+	if name, ok := formatNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("format %d", int(f))
+}
+
+// ParseFormat returns the format named name, as returned by Format.String.
+// Returns ErrUnknownFormat for other names
+func ParseFormat(name string) (Format, error) {
+	for f, n := range formatNames {
+		if strings.EqualFold(n, name) {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownFormat, name)
+}
+
+// IngestProgress is a progress report of IngestStream
+type IngestProgress struct {
+	Lines       int           `json:"lines"`    // Non-blank lines (or records) read
+	Inserted    int           `json:"inserted"` // Hashes stored so far
+	Invalid     int           `json:"invalid"`
+	Elapsed     time.Duration `json:"elapsed"`
+	LinesPerSec float64       `json:"lines_per_sec"`
+	Done        bool          `json:"done"` // Set on the last report, once what was read is stored
+}
+
+// IngestStream imports r in format, the one entry point every importer goes through. Gzip and
+// zstd compressed streams are detected by their magic bytes. Hashes are stored in batches and
+// progress is reported every Options.IngestProgressInterval, and once more when the stream ends,
+// to progress if it isn't nil. hashType applies to the formats that don't carry one, CSV, JSONL
+// and pwdump lines bring their own. Cancelling ctx stops reading, even while r is blocked, such as
+// a pipe whose writer went quiet, stores what was read and returns ctx.Err()
+func (kc *KDB) IngestStream(ctx context.Context, r io.Reader, format Format, hashType uint64, progress func(IngestProgress)) error {
+	_, err := kc.ingest(ctx, kc.newImporter(), r, format, hashType, progress)
+	return err
+}
+
+// ingest reads r in format through im and returns its report
+func (kc *KDB) ingest(ctx context.Context, im *importer, r io.Reader, format Format, hashType uint64, progress func(IngestProgress)) (ImportReport, error) {
+	if _, ok := formatNames[format]; !ok {
+		return ImportReport{}, fmt.Errorf("%w: %d", ErrUnknownFormat, int(format))
+	}
+	switch format {
+	case FormatHashList, FormatFounds, FormatFoundsSalted:
+		im.checkConflicts = true
+	case FormatPwdump:
+		im.keepStored = true
+	}
+	im.progress = progress
+	im.started = time.Now()
+	im.reported = im.started
+
+	if ctx.Done() != nil {
+		r = newContextReader(im.kc, ctx, r)
+	}
+	src, err := maybeDecompress(r)
+	if err != nil && ctx.Err() != nil {
+		return im.report, ctx.Err()
+	}
+	if err != nil {
+		return im.report, fmt.Errorf("failed to read %s: %w", format, err)
+	}
+	defer src.Close()
+
+	if format == FormatCSV {
+		err = im.readCSV(ctx, src)
+	} else {
+		err = scanLines(src, func(line int, text string) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			im.report.Lines++
+			if err := im.line(format, hashType, line, text); err != nil {
+				return err
+			}
+			im.reportProgress(false)
+			return nil
+		})
+	}
+	// Decompressors don't always pass the error of the stream on as is
+	canceled := err != nil && ctx.Err() != nil
+	if err != nil && !canceled {
+		return im.report, fmt.Errorf("failed to import %s: %w", format, err)
+	}
+
+	report, err := im.finish()
+	if err != nil {
+		return report, err
+	}
+	im.reportProgress(true)
+	if canceled {
+		kc.log(fmt.Sprintf("Import of %s canceled after %d lines, %d hashes stored", format, report.Lines, report.Imported), Warning)
+		return report, ctx.Err()
+	}
+	return report, nil
+}
+
+// line parses one line of a line based format and queues the hash it holds
+func (im *importer) line(format Format, hashType uint64, line int, text string) error {
+	kc := im.kc
+	var (
+		sh  *Hash
+		err error
+	)
+	switch format {
+	case FormatPotfile:
+		if sh, err = kc.parsePotfileLine(text, hashType); err == nil {
+			sh.Source = im.source
+		}
+	case FormatHashList:
+		sh, err = im.parseHashListLine(text, hashType)
+	case FormatFounds:
+		sh, err = kc.parseFoundsLine(text, HashColonPlain, hashType)
+	case FormatFoundsSalted:
+		sh, err = kc.parseFoundsLine(text, HashSaltPlain, hashType)
+	case FormatHashtopolis:
+		sh, err = kc.parseHashtopolisLine(text, hashType)
+	case FormatPwdump:
+		return im.pwdumpLine(line, text)
+	case FormatJSONL:
+		sh = &Hash{}
+		if err = json.Unmarshal([]byte(text), sh); err == nil {
+			sh.db = kc
+		}
+	}
+	if err != nil {
+		im.invalid(line, err)
+		return nil
+	}
+	return im.add(line, sh)
+}
+
+// readCSV reads the records of a CSV stream, see ImportCSV
+func (im *importer) readCSV(ctx context.Context, r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(csvHeader)
+
+	for line := 1; ; line++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				im.report.Lines++
+				im.invalid(line, err)
+				continue
+			}
+			return err
+		}
+
+		if line == 1 && record[0] == csvHeader[0] {
+			continue
+		}
+		im.report.Lines++
+
+		if err := im.csvRecord(line, record); err != nil {
+			return err
+		}
+		im.reportProgress(false)
+	}
+}
+
+// csvRecord queues the hash of one CSV record
+func (im *importer) csvRecord(line int, record []string) error {
+	value, err := util.DecodeHexPlain(record[2])
+	if err != nil {
+		im.invalid(line, err)
+		return nil
+	}
+
+	hashType, err := strconv.ParseUint(record[3], 10, 64)
+	if err != nil {
+		im.invalid(line, fmt.Errorf("invalid hash type '%s'", record[3]))
+		return nil
+	}
+
+	sh := im.kc.NewHash(record[0], value, hashType)
+	if record[1] != "" {
+		sh.Salt = record[1]
+		sh.generateKey()
+	}
+	return im.add(line, sh)
+}
+
+// reportProgress calls the progress callback once the interval has passed since the last
+// report, or always for the final one
+func (im *importer) reportProgress(done bool) {
+	if im.progress == nil {
+		return
+	}
+	now := time.Now()
+	if !done && now.Sub(im.reported) < im.kc.ingestProgressInterval() {
+		return
+	}
+	im.reported = now
+
+	p := IngestProgress{
+		Lines:    im.report.Lines,
+		Inserted: im.report.Imported,
+		Invalid:  im.report.Invalid,
+		Elapsed:  now.Sub(im.started),
+		Done:     done,
+	}
+	if p.Elapsed > 0 {
+		p.LinesPerSec = float64(p.Lines) / p.Elapsed.Seconds()
+	}
+	im.progress(p)
+}
+
+// ingestProgressInterval returns Options.IngestProgressInterval or its default
+func (kc *KDB) ingestProgressInterval() time.Duration {
+	if kc.opts == nil || kc.opts.IngestProgressInterval <= 0 {
+		return defaultIngestProgressInterval
+	}
+	return kc.opts.IngestProgressInterval
+}
+
+// maybeDecompress returns a reader that decompresses r if it starts with the gzip or zstd magic
+// bytes, r as is otherwise
+func maybeDecompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		dec, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	default:
+		return io.NopCloser(br), nil
+	}
+}
+
+// contextReader reads r in a goroutine so that a read blocked on r doesn't hold up cancellation.
+// The goroutine exits with the first read of r that returns after ctx is done
+type contextReader struct {
+	ctx    context.Context
+	chunks chan []byte
+	err    error // What ended r, set before chunks is closed
+	buf    []byte
+}
+
+// newContextReader starts reading r for ctx, a panic of r is recorded as one of kc
+func newContextReader(kc *KDB, ctx context.Context, r io.Reader) *contextReader {
+	cr := &contextReader{ctx: ctx, chunks: make(chan []byte)}
+	go func() {
+		// A panicking r ends the stream with a *PanicError, chunks is closed after it is set
+		defer close(cr.chunks)
+		defer func() {
+			if r := recover(); r != nil {
+				cr.err = kc.panicked("read", r)
+			}
+		}()
+		for {
+			buf := make([]byte, ingestChunkSize)
+			n, err := r.Read(buf)
+			if n > 0 {
+				select {
+				case cr.chunks <- buf[:n]:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				cr.err = err
+				return
+			}
+		}
+	}()
+	return cr
+}
+
+// Read returns what r read, or ctx.Err() once ctx is done
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if len(cr.buf) == 0 {
+		select {
+		case <-cr.ctx.Done():
+			return 0, cr.ctx.Err()
+		case chunk, ok := <-cr.chunks:
+			if !ok {
+				if cr.err == nil {
+					return 0, cr.ctx.Err()
+				}
+				return 0, cr.err
+			}
+			cr.buf = chunk
+		}
+	}
+	n := copy(p, cr.buf)
+	cr.buf = cr.buf[n:]
+	return n, nil
+}
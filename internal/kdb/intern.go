@@ -0,0 +1,373 @@
+package kdb
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	internValuePrefix = "intern:val:%016x" // dictionary id, the value the id stands for
+	internScanPrefix  = "intern:val:"      // used to load the whole dictionary
+	internSeqKey      = "intern:seq"       // last dictionary id handed out
+	internSavedKey    = "intern_saved"     // Counter of the bytes saved by value interning
+
+	minInternFrequency = 2 // A value held by one hash can't be shared
+)
+
+// valueDictionary is the in-memory copy of the value dictionary, see InternValues
+type valueDictionary struct {
+	mu     sync.RWMutex
+	values map[uint64]string // every entry loaded or written since open, retired ones included
+	ids    map[string]uint64 // the live entries, the ones new records reference
+	seq    uint64            // last id handed out
+
+	job sync.Mutex // held while InternValues runs
+}
+
+// valueDigest keys the value frequencies of InternValues without keeping every value in memory
+type valueDigest [16]byte
+
+func digestValue(value string) valueDigest {
+	sum := sha256.Sum256([]byte(value))
+	return valueDigest(sum[:16])
+}
+
+// loadValueDictionary reads the value dictionary into memory
+func (kc *KDB) loadValueDictionary() error {
+	values := make(map[uint64]string)
+	ids := make(map[string]uint64)
+	var seq uint64
+
+	prefix := []byte(kc.keys.key(internScanPrefix))
+	err := kc.c.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(kc.keys.key(internSeqKey)))
+		if err == nil {
+			err = item.Value(func(val []byte) error {
+				if len(val) == 8 {
+					seq = binary.BigEndian.Uint64(val)
+				}
+				return nil
+			})
+		}
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			key := string(it.Item().Key()[len(prefix):])
+			id, err := strconv.ParseUint(key, 16, 64)
+			if err != nil {
+				kc.log(fmt.Sprintf("ignoring invalid value dictionary entry '%s'", key), Warning)
+				continue
+			}
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			values[id] = string(value)
+			ids[string(value)] = id
+			seq = max(seq, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	kc.interned.mu.Lock()
+	kc.interned.values, kc.interned.ids, kc.interned.seq = values, ids, seq
+	kc.interned.mu.Unlock()
+	return nil
+}
+
+// internedID returns the dictionary id of value, 0 if it isn't interned
+func (kc *KDB) internedID(value string) uint64 {
+	if value == "" {
+		return 0
+	}
+	kc.interned.mu.RLock()
+	defer kc.interned.mu.RUnlock()
+	return kc.interned.ids[value]
+}
+
+// resolveValue replaces the dictionary reference of a decoded record with the value it stands for
+func (kc *KDB) resolveValue(sh *Hash) error {
+	if sh.valueRef == 0 {
+		return nil
+	}
+	kc.interned.mu.RLock()
+	value, ok := kc.interned.values[sh.valueRef]
+	kc.interned.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("hash record references unknown interned value %d", sh.valueRef)
+	}
+	sh.Value, sh.valueRef = value, 0
+	return nil
+}
+
+// internHash returns sh referencing the dictionary entry of its value, sh itself if the value
+// isn't interned. The caller's hash is left unchanged
+func (kc *KDB) internHash(sh *Hash) *Hash {
+	id := kc.internedID(sh.Value)
+	if id == 0 {
+		return sh
+	}
+	interned := *sh
+	interned.valueRef = id
+	return &interned
+}
+
+// internSaving is what referencing the dictionary instead of holding value saves a record
+func internSaving(value string, id uint64) int {
+	inline := protowire.SizeTag(fieldValue) + protowire.SizeBytes(len(value))
+	return inline - protowire.SizeTag(fieldValueRef) - protowire.SizeVarint(id)
+}
+
+// InternValues stores every value held by at least minFrequency hashes once, in a value dictionary,
+// and rewrites those hashes to reference their dictionary entry, so the millions of hashes cracked
+// to "123456" share one copy. Nothing is interned until it is first run. Reads and exports resolve
+// references transparently and hashes stored afterwards reference the entries that exist.
+// Each run extends the dictionary and garbage collects it: entries whose value fell below
+// minFrequency, through deletes or new values, are inlined again and removed. minFrequency below 2
+// counts as 2. The bytes saved reported by Stats are recomputed exactly, net of the dictionary.
+// Hashes are rewritten a type at a time under the database lock. Returns the number of hashes
+// rewritten
+func (kc *KDB) InternValues(ctx context.Context, minFrequency int) (int, error) {
+	minFrequency = max(minFrequency, minInternFrequency)
+
+	kc.interned.job.Lock()
+	defer kc.interned.job.Unlock()
+
+	hashTypes, err := kc.getRegisteredHashTypes()
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to get registered hash types: %v", err), Error)
+		return 0, fmt.Errorf("failed to get registered hash types: %w", err)
+	}
+
+	frequency := make(map[valueDigest]int)
+	for _, hashType := range hashTypes {
+		if err := kc.countValueFrequency(ctx, hashType, frequency); err != nil {
+			kc.log(fmt.Sprintf("Failed to count values of hash type %d: %v", hashType, err), Error)
+			return 0, fmt.Errorf("failed to count values of hash type %d: %w", hashType, err)
+		}
+	}
+
+	// Retired entries stop being handed out to new records, which hold the database lock from
+	// encoding to commit, so once a type is rewritten no record of it references them
+	retired := make(map[uint64]bool)
+	kc.interned.mu.Lock()
+	for value, id := range kc.interned.ids {
+		if frequency[digestValue(value)] < minFrequency {
+			delete(kc.interned.ids, value)
+			retired[id] = true
+		}
+	}
+	kc.interned.mu.Unlock()
+
+	rewritten, saved := 0, 0
+	for _, hashType := range hashTypes {
+		n, s, err := kc.internTypeValues(ctx, hashType, frequency, minFrequency, retired)
+		rewritten += n
+		saved += s
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to intern values of hash type %d: %v", hashType, err), Error)
+			return rewritten, fmt.Errorf("failed to intern values of hash type %d: %w", hashType, err)
+		}
+	}
+
+	if err := kc.dropInterned(retired); err != nil {
+		kc.log(fmt.Sprintf("Failed to remove unused interned values: %v", err), Error)
+		return rewritten, fmt.Errorf("failed to remove unused interned values: %w", err)
+	}
+
+	entries, size := kc.dictionarySize()
+	if err := kc.setCount(kc.keys.key(internSavedKey), saved-size); err != nil {
+		return rewritten, fmt.Errorf("failed to update interning counter: %w", err)
+	}
+
+	kc.log(fmt.Sprintf("Interned values: %d hashes rewritten, %d dictionary entries, %d removed, %d bytes saved", rewritten, entries, len(retired), saved-size), Info)
+	return rewritten, nil
+}
+
+// countValueFrequency adds the values of the hashes of hashType to frequency
+func (kc *KDB) countValueFrequency(ctx context.Context, hashType uint64, frequency map[valueDigest]int) error {
+	prefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	return kc.c.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var hash Hash
+			if err := it.Item().Value(func(val []byte) error {
+				return kc.decodeRawHash(val, &hash)
+			}); err != nil {
+				continue
+			}
+			if hash.Value != "" {
+				frequency[digestValue(hash.Value)]++
+			}
+		}
+		return nil
+	})
+}
+
+// internTypeValues rewrites the hashes of hashType whose value qualifies to reference the
+// dictionary, adding the entries they need, and inlines the ones referencing retired entries.
+// Returns how many were rewritten and the bytes the references of the type save
+func (kc *KDB) internTypeValues(ctx context.Context, hashType uint64, frequency map[valueDigest]int, minFrequency int, retired map[uint64]bool) (int, int, error) {
+	rewritten, saved := 0, 0
+	prefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	wb := kc.c.NewWriteBatch()
+	defer wb.Cancel()
+
+	var added []uint64
+	err := kc.c.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var hash Hash
+			if err := it.Item().Value(func(val []byte) error {
+				return decodeHash(val, &hash)
+			}); err != nil {
+				continue
+			}
+			ref := hash.valueRef
+			if err := kc.resolveValue(&hash); err != nil {
+				kc.log(fmt.Sprintf("Failed to resolve interned value of %s: %v", it.Item().Key(), err), Warning)
+				continue
+			}
+			if hash.Value == "" {
+				continue
+			}
+
+			id := kc.internedID(hash.Value)
+			if id == 0 && frequency[digestValue(hash.Value)] >= minFrequency {
+				var err error
+				if id, err = kc.addInterned(wb, hash.Value); err != nil {
+					return err
+				}
+				added = append(added, id)
+			}
+			if id != 0 {
+				saved += internSaving(hash.Value, id)
+			}
+			if id == ref {
+				continue
+			}
+
+			// Normalization is left to NormalizeValues, only the stored form changes here
+			hash.valueRef = id
+			data, err := encodeHash(&hash)
+			if err != nil {
+				return err
+			}
+			data, _ = compressRecord(data, kc.opts.CompressValueThreshold)
+			if err := wb.Set(it.Item().KeyCopy(nil), data); err != nil {
+				return err
+			}
+			rewritten++
+		}
+		return nil
+	})
+	if err == nil {
+		err = wb.Flush()
+	}
+	if err != nil {
+		kc.forgetInterned(added)
+		return 0, 0, err
+	}
+	return rewritten, saved, nil
+}
+
+// addInterned adds a dictionary entry for value to wb and hands its id out right away. The database
+// lock is held, so nothing references the entry before wb is flushed
+func (kc *KDB) addInterned(wb *badger.WriteBatch, value string) (uint64, error) {
+	kc.interned.mu.Lock()
+	defer kc.interned.mu.Unlock()
+
+	id := kc.interned.seq + 1
+	seq := make([]byte, 8)
+	binary.BigEndian.PutUint64(seq, id)
+	if err := wb.Set([]byte(kc.keys.key(internValuePrefix, id)), []byte(value)); err != nil {
+		return 0, err
+	}
+	if err := wb.Set([]byte(kc.keys.key(internSeqKey)), seq); err != nil {
+		return 0, err
+	}
+
+	kc.interned.seq = id
+	kc.interned.values[id] = value
+	kc.interned.ids[value] = id
+	return id, nil
+}
+
+// forgetInterned takes back entries added by a batch that wasn't written
+func (kc *KDB) forgetInterned(ids []uint64) {
+	kc.interned.mu.Lock()
+	defer kc.interned.mu.Unlock()
+	for _, id := range ids {
+		delete(kc.interned.ids, kc.interned.values[id])
+		delete(kc.interned.values, id)
+	}
+}
+
+// dropInterned deletes retired dictionary entries. They stay resolvable in memory for snapshots
+// taken before they were dropped
+func (kc *KDB) dropInterned(retired map[uint64]bool) error {
+	if len(retired) == 0 {
+		return nil
+	}
+	wb := kc.c.NewWriteBatch()
+	defer wb.Cancel()
+	for id := range retired {
+		if err := wb.Delete([]byte(kc.keys.key(internValuePrefix, id))); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// dictionarySize returns the number of live dictionary entries and the bytes they take
+func (kc *KDB) dictionarySize() (int, int) {
+	kc.interned.mu.RLock()
+	defer kc.interned.mu.RUnlock()
+
+	size := 0
+	for value, id := range kc.interned.ids {
+		size += len(kc.keys.key(internValuePrefix, id)) + len(value)
+	}
+	return len(kc.interned.ids), size
+}
@@ -0,0 +1,173 @@
+package kdb
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+)
+
+// Hashcat modes of the Kerberos ticket formats, both RC4 (etype 23)
+const (
+	KerberosTGSREP = 13100 // $krb5tgs$23$*user$realm$spn*$checksum$edata2, Kerberoasting
+	KerberosASREP  = 18200 // $krb5asrep$23$user@realm:checksum$edata2, AS-REP roasting
+)
+
+const (
+	kerberosEtype               = 23
+	kerberosChecksumLen         = 32       // Hex length of the HMAC-MD5 checksum
+	defaultMaxTicketSize        = 64 << 10 // Tickets of accounts in many groups carry a large PAC
+	defaultTicketValueThreshold = 512      // Below the smallest AS-REP ticket records, above most other records
+)
+
+// expectedRecordSizes are the typical encoded record sizes of the types that outgrow the inline
+// value threshold, measured on tickets requested with Rubeus and impacket
+var expectedRecordSizes = map[uint64]int{
+	KerberosTGSREP: 2500,
+	KerberosASREP:  700,
+}
+
+// KerberosTicket is a parsed Kerberos ticket hash. Hex fields are lowercase
+type KerberosTicket struct {
+	HashType uint64
+	User     string // Service account for KerberosTGSREP, empty for tickets without the account part
+	Realm    string
+	SPN      string // KerberosTGSREP only
+	Checksum string
+	EncPart  string // The encrypted part after the checksum, edata2
+}
+
+// IsKerberosTicket returns true for the hash types ParseKerberosTicket parses
+func IsKerberosTicket(hashType uint64) bool {
+	return hashType == KerberosTGSREP || hashType == KerberosASREP
+}
+
+// ParseKerberosTicket parses a ticket in the format Rubeus, impacket or hashcat write for
+// hashType, KerberosTGSREP or KerberosASREP. Whitespace is ignored, so tickets Rubeus wrapped
+// across lines parse, and AS-REP tickets without the etype, Rubeus' john format, get etype 23.
+// Returns ErrInvalidHashType for other types and ErrInvalidHash for a ticket that doesn't have
+// the fields of its type
+func ParseKerberosTicket(ticket string, hashType uint64) (*KerberosTicket, error) {
+	if !IsKerberosTicket(hashType) {
+		return nil, fmt.Errorf("%w: %d is not a Kerberos ticket mode", ErrInvalidHashType, hashType)
+	}
+
+	ticket = stripSpace(ticket)
+	kt := &KerberosTicket{HashType: hashType}
+	var rest string
+	if hashType == KerberosTGSREP {
+		var ok bool
+		if rest, ok = cutPrefixFold(ticket, fmt.Sprintf("$krb5tgs$%d$", kerberosEtype)); !ok {
+			return nil, fmt.Errorf("%w: expected a $krb5tgs$%d$ ticket", ErrInvalidHash, kerberosEtype)
+		}
+		if strings.HasPrefix(rest, "*") {
+			account, after, ok := strings.Cut(rest[1:], "*$")
+			if !ok {
+				return nil, fmt.Errorf("%w: unterminated *user$realm$spn* part", ErrInvalidHash)
+			}
+			parts := strings.SplitN(account, "$", 3)
+			if len(parts) != 3 {
+				return nil, fmt.Errorf("%w: expected *user$realm$spn*", ErrInvalidHash)
+			}
+			kt.User, kt.Realm, kt.SPN, rest = parts[0], parts[1], parts[2], after
+		}
+	} else {
+		var ok bool
+		if rest, ok = cutPrefixFold(ticket, "$krb5asrep$"); !ok {
+			return nil, fmt.Errorf("%w: expected a $krb5asrep$ ticket", ErrInvalidHash)
+		}
+		rest, _ = strings.CutPrefix(rest, fmt.Sprintf("%d$", kerberosEtype))
+		i := strings.LastIndex(rest, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("%w: expected user@realm:checksum$edata2", ErrInvalidHash)
+		}
+		principal := rest[:i]
+		rest = rest[i+1:]
+		if at := strings.LastIndex(principal, "@"); at >= 0 {
+			kt.User, kt.Realm = principal[:at], principal[at+1:]
+		} else {
+			kt.User = principal
+		}
+	}
+
+	checksum, encPart, ok := strings.Cut(rest, "$")
+	if !ok {
+		return nil, fmt.Errorf("%w: expected checksum$edata2", ErrInvalidHash)
+	}
+	kt.Checksum, kt.EncPart = strings.ToLower(checksum), strings.ToLower(encPart)
+	if len(kt.Checksum) != kerberosChecksumLen || !isHex(kt.Checksum) {
+		return nil, fmt.Errorf("%w: the checksum is not 16 bytes of hex", ErrInvalidHash)
+	}
+	if kt.EncPart == "" || !isHex(kt.EncPart) {
+		return nil, fmt.Errorf("%w: edata2 is not hex", ErrInvalidHash)
+	}
+	return kt, nil
+}
+
+// String returns the ticket in hashcat's format
+func (kt *KerberosTicket) String() string {
+	if kt.HashType == KerberosASREP {
+		principal := kt.User
+		if kt.Realm != "" {
+			principal += "@" + kt.Realm
+		}
+		return fmt.Sprintf("$krb5asrep$%d$%s:%s$%s", kerberosEtype, principal, kt.Checksum, kt.EncPart)
+	}
+	if kt.User == "" && kt.Realm == "" && kt.SPN == "" {
+		return fmt.Sprintf("$krb5tgs$%d$%s$%s", kerberosEtype, kt.Checksum, kt.EncPart)
+	}
+	return fmt.Sprintf("$krb5tgs$%d$*%s$%s$%s*$%s$%s", kerberosEtype, kt.User, kt.Realm, kt.SPN, kt.Checksum, kt.EncPart)
+}
+
+// ticketSum returns the sum a ticket is keyed on, its checksum and encrypted part. The account
+// part is left out, tools spell the SPN and realm differently for the same ticket
+func ticketSum(kt *KerberosTicket) []byte {
+	return util.SHA256Sum("\x00ticket\x00" + kt.Checksum + "$" + kt.EncPart)
+}
+
+// parseTicket returns the parsed ticket if hash is one of hashType, nil otherwise
+func parseTicket(hash string, hashType uint64) *KerberosTicket {
+	if !IsKerberosTicket(hashType) {
+		return nil
+	}
+	kt, err := ParseKerberosTicket(hash, hashType)
+	if err != nil {
+		return nil
+	}
+	return kt
+}
+
+// stripSpace returns s without whitespace
+func stripSpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// cutPrefixFold is strings.CutPrefix ignoring ASCII case
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+// isHex returns true if s is an even number of hex digits
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// badgerValueThreshold returns the value threshold badger is opened with, ValueThreshold lowered
+// to Options.TicketValueThreshold so ticket records go to the value log instead of the LSM tree
+func badgerValueThreshold(opts *Options) int64 {
+	if opts.TicketValueThreshold > 0 && opts.TicketValueThreshold < opts.ValueThreshold {
+		return opts.TicketValueThreshold
+	}
+	return opts.ValueThreshold
+}
@@ -0,0 +1,140 @@
+package kdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// hexBlob returns n bytes of deterministic hex standing in for an encrypted part
+func hexBlob(seed string, n int) string {
+	var buf bytes.Buffer
+	sum := sha256.Sum256([]byte(seed))
+	for buf.Len() < n {
+		buf.Write(sum[:])
+		sum = sha256.Sum256(sum[:])
+	}
+	return hex.EncodeToString(buf.Bytes()[:n])
+}
+
+// wrapTicket splits s into lines of width indented like Rubeus' console output without /nowrap
+func wrapTicket(s string, width int) string {
+	var b strings.Builder
+	for len(s) > width {
+		b.WriteString(s[:width] + "\n      ")
+		s = s[width:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// testTickets returns a TGS-REP and an AS-REP ticket in the forms Rubeus, impacket and hashcat
+// write them, by tool
+func testTickets() (tgs, asrep map[string]string) {
+	tgsChecksum, tgsEdata := "7B3F1E6A0C9D42E8B51F3A6C9E0D2B47", hexBlob("tgs", 1150)
+	asrepChecksum, asrepEdata := "3e156ada591263b8aab0965f5aebd837", hexBlob("asrep", 280)
+	tgs = map[string]string{
+		// impacket GetUserSPNs.py
+		"impacket": "$krb5tgs$23$*svc_sql$CORP.LOCAL$corp.local/svc_sql*$" + tgsChecksum + "$" + tgsEdata,
+		// Rubeus kerberoast without /nowrap
+		"rubeus": wrapTicket("$krb5tgs$23$*svc_sql$corp.local$MSSQLSvc/sql01.corp.local:1433@corp.local*$"+tgsChecksum+"$"+strings.ToUpper(tgsEdata), 100),
+		// Old hashcat format without the account
+		"hashcat": "$krb5tgs$23$" + strings.ToLower(tgsChecksum) + "$" + tgsEdata,
+	}
+	asrep = map[string]string{
+		// impacket GetNPUsers.py
+		"impacket": "$krb5asrep$23$jdoe@CORP.LOCAL:" + asrepChecksum + "$" + asrepEdata,
+		// Rubeus asreproast, john format
+		"rubeus": wrapTicket("$krb5asrep$jdoe@corp.local:"+asrepChecksum+"$"+asrepEdata, 100),
+	}
+	return tgs, asrep
+}
+
+func TestKerberosTicketForms(t *testing.T) {
+	db := newTestDB(t, testPrefix, func(opts *Options) { opts.StrictValidation = true })
+	tgs, asrep := testTickets()
+
+	for hashType, forms := range map[uint64]map[string]string{KerberosTGSREP: tgs, KerberosASREP: asrep} {
+		for tool, ticket := range forms {
+			if _, err := db.StoreHash(db.NewHash(ticket, "", hashType)); err != nil {
+				t.Errorf("%d from %s: %v", hashType, tool, err)
+			}
+		}
+		if count, _ := db.HashesByType(hashType); count != 1 {
+			t.Errorf("%d: %d records for one ticket", hashType, count)
+		}
+	}
+
+	// A crack of one form is found by every form
+	if err := db.MarkCracked(tgs["rubeus"], KerberosTGSREP, "Summer2026!"); err != nil {
+		t.Fatalf("MarkCracked: %v", err)
+	}
+	for tool, ticket := range tgs {
+		got, err := db.GetHashByOriginalHash(ticket, KerberosTGSREP)
+		if err != nil || got.Value != "Summer2026!" {
+			t.Errorf("lookup of the %s form: %v", tool, err)
+			continue
+		}
+		kt, err := ParseKerberosTicket(got.Hash, KerberosTGSREP)
+		if err != nil || strings.ToLower(kt.String()) != got.Canonical() {
+			t.Errorf("the stored ticket differs from its canonical form beyond case: %v", err)
+		}
+		if strings.ContainsAny(got.Hash, " \n") {
+			t.Errorf("the stored ticket kept its line breaks")
+		}
+	}
+	if err := db.MarkCracked(asrep["impacket"], KerberosASREP, "Welcome1"); err != nil {
+		t.Fatalf("MarkCracked: %v", err)
+	}
+	got, err := db.GetHashByOriginalHash(asrep["rubeus"], KerberosASREP)
+	if err != nil || !strings.HasPrefix(got.Canonical(), "$krb5asrep$23$jdoe@corp.local:") {
+		t.Errorf("the AS-REP was stored as %v: %v", got, err)
+	}
+	users := 0
+	for range db.GetHashesByUser(`corp.local\jdoe`) {
+		users++
+	}
+	if users != 1 {
+		t.Errorf("GetHashesByUser found %d AS-REP tickets", users)
+	}
+
+	stats, err := db.Stats()
+	if err != nil || stats.ValueLogTypes[KerberosTGSREP] == 0 {
+		t.Errorf("Stats doesn't list tickets in the value log: %v", err)
+	}
+
+	if _, err := db.StoreHash(db.NewHash("$krb5tgs$23$*svc$corp$spn*$nothex$00", "", KerberosTGSREP)); err == nil {
+		t.Errorf("a malformed ticket was stored")
+	}
+}
+
+func TestKerberosPotfileRoundTrip(t *testing.T) {
+	a, b := newTestDB(t, testPrefix, nil), newTestDB(t, testPrefix, nil)
+	tgs, asrep := testTickets()
+	if _, err := a.StoreHash(a.NewHash(tgs["impacket"], "Summer2026!", KerberosTGSREP)); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	if _, err := a.StoreHash(a.NewHash(asrep["impacket"], "Welcome1", KerberosASREP)); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	for _, hashType := range []uint64{KerberosTGSREP, KerberosASREP} {
+		var pot bytes.Buffer
+		if n, err := a.ExportPotfile(&pot, hashType); err != nil || n != 1 {
+			t.Fatalf("export of %d: %d lines, %v", hashType, n, err)
+		}
+		if report, err := b.ImportPotfile(&pot, hashType); err != nil || report.Imported != 1 {
+			t.Fatalf("import of %d: %+v, %v", hashType, report, err)
+		}
+	}
+	for tool, ticket := range tgs {
+		if got, err := b.GetHashByOriginalHash(ticket, KerberosTGSREP); err != nil || got.Value != "Summer2026!" {
+			t.Errorf("reimported lookup of the %s form: %v", tool, err)
+		}
+	}
+	if got, err := b.GetHashByOriginalHash(asrep["rubeus"], KerberosASREP); err != nil || got.Value != "Welcome1" {
+		t.Errorf("reimported AS-REP lookup: %v", err)
+	}
+}
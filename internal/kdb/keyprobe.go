@@ -0,0 +1,39 @@
+package kdb
+
+import (
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+)
+
+const (
+	keyProbeMetaKey = "key_probe"    // meta key holding the encryption key probe
+	keyProbeMessage = "krkndb-probe" // message authenticated by the probe
+)
+
+// keyProbe returns the probe value for an encryption key
+func keyProbe(encryptionKey []byte) []byte {
+	return util.HMACSHA256(encryptionKey, []byte(keyProbeMessage))
+}
+
+// verifyKnownValue checks the encryption key against the probe stored when the database was created.
+// Databases created before probes existed get one on their first open.
+// The probe is compared in constant time, a mismatch returns ErrWrongKey
+func (kc *KDB) verifyKnownValue(encryptionKey []byte) error {
+	want := keyProbe(encryptionKey)
+
+	stored, err := kc.GetMeta(keyProbeMetaKey)
+	if isNotFound(err) {
+		return kc.SetMeta(keyProbeMetaKey, want)
+	}
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare(stored, want) != 1 {
+		return fmt.Errorf("%w: key probe mismatch", ErrWrongKey)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package kdb
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	// DefaultKeyPrefix is the first segment of every key a database writes unless Options.KeyPrefix
+	// names another
+	DefaultKeyPrefix = "krkn"
+
+	keyPrefixMetaKey = "key_prefix" // meta key holding the key prefix the database was created with
+)
+
+// defaultKeys is the keyspace of hashes built before they are stored in a database
+var defaultKeys = keyspace{prefix: DefaultKeyPrefix}
+
+// keyspace builds the keys of a database under its key prefix. Key formats leave the prefix out,
+// key(storedHashPrefix, 0, sum) is "krkn:0:<sum>" under the default prefix
+type keyspace struct {
+	prefix string
+}
+
+// newKeyspace returns the keyspace of prefix, the default one if it is empty
+func newKeyspace(prefix string) (keyspace, error) {
+	if prefix == "" {
+		return defaultKeys, nil
+	}
+	if strings.ContainsAny(prefix, ":\x00") {
+		return keyspace{}, fmt.Errorf("%w: %q contains a colon or NUL", ErrInvalidKeyPrefix, prefix)
+	}
+	return keyspace{prefix: prefix}, nil
+}
+
+// key returns the key of format and args under the prefix
+func (ks keyspace) key(format string, args ...any) string {
+	return ks.prefix + ":" + fmt.Sprintf(format, args...)
+}
+
+// hashKey returns the key a hash with sum, raw or hex encoded, is stored under
+func (ks keyspace) hashKey(hashType uint64, sum string) []byte {
+	return []byte(ks.key(storedHashPrefix, hashType, hexSumString(sum)))
+}
+
+// bindKey sets the key of sh to the one it is stored under in the keyspace. Hashes not built
+// through a database get a key under the default prefix
+func (ks keyspace) bindKey(sh *Hash) {
+	sh.Key = ks.hashKey(sh.HashType, string(sh.sum))
+}
+
+// checkKeyPrefix makes sure the database is opened with the key prefix it was created with and
+// records the prefix of new databases. Keys written under another prefix are invisible to this
+// one, so a prefix with no key probe of its own is compared against the other keyspaces in the
+// directory. A new keyspace in a directory shared with other data, adopted by checkIdentity, is a
+// new database
+func (kc *KDB) checkKeyPrefix() error {
+	recorded, err := kc.GetMeta(keyPrefixMetaKey)
+	if err == nil {
+		if string(recorded) != kc.keys.prefix {
+			return fmt.Errorf("%w: database was created with key prefix %q, opened with %q", ErrKeyPrefixMismatch, recorded, kc.keys.prefix)
+		}
+		return nil
+	}
+	if !isNotFound(err) {
+		return err
+	}
+
+	// Every database opened by this or an older version has a key probe
+	if _, err := kc.GetMeta(keyProbeMetaKey); isNotFound(err) {
+		other, err := kc.findKeyspace()
+		if err != nil {
+			return err
+		}
+		if other != "" {
+			return fmt.Errorf("%w: database was created with key prefix %q, opened with %q", ErrKeyPrefixMismatch, other, kc.keys.prefix)
+		}
+		// Prefixes other than the default are new to this version, they have no legacy databases
+		if kc.keys.prefix != DefaultKeyPrefix {
+			kc.isNew = true
+		}
+	} else if err != nil {
+		return err
+	}
+
+	return kc.SetMeta(keyPrefixMetaKey, []byte(kc.keys.prefix))
+}
+
+// findKeyspace returns the prefix of another keyspace in the directory holding a database, "" if
+// there is none. Keys are visited once per first segment, skipping past each keyspace
+func (kc *KDB) findKeyspace() (string, error) {
+	var found string
+	err := kc.c.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); {
+			key := it.Item().Key()
+			end := bytes.IndexByte(key, ':')
+			if end < 0 {
+				it.Next()
+				continue
+			}
+
+			prefix := string(key[:end])
+			other := keyspace{prefix: prefix}
+			if prefix != kc.keys.prefix {
+				_, err := txn.Get([]byte(other.key(metaPrefix, keyProbeMetaKey)))
+				if err == nil {
+					found = prefix
+					return nil
+				}
+				if !isNotFound(err) {
+					return err
+				}
+			}
+			// ';' follows ':', seeking to it skips every key of the keyspace
+			it.Seek([]byte(prefix + ";"))
+		}
+		return nil
+	})
+	return found, err
+}
@@ -0,0 +1,71 @@
+package kdb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestKeysStayInTheirPrefix(t *testing.T) {
+	dir := t.TempDir()
+	db := newTestDBIn(t, dir, testPrefix, func(opts *Options) {
+		opts.ValueIndex = true
+		opts.ValueIndexFolded = true
+	})
+	for i := range 20 {
+		h := NewHash(testHash(i), fmt.Sprintf("value%d", i%3), deltaTypes[i%2])
+		h.Meta = map[string]string{UserMetaKey: fmt.Sprintf("user%d", i)}
+		if _, err := db.StoreHash(h); err != nil {
+			t.Fatalf("store %d: %v", i, err)
+		}
+	}
+	if err := db.DeleteHash(testHash(0), 0); err != nil {
+		t.Fatalf("DeleteHash: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	for _, key := range rawKeys(t, dir) {
+		if !strings.HasPrefix(key, testPrefix+":") {
+			t.Errorf("the key %q is outside the prefix %q", key, testPrefix)
+		}
+	}
+
+	// The prefix is recorded, another one is refused
+	other := "other"
+	if testPrefix == other {
+		other = DefaultKeyPrefix
+	}
+	if _, err := openTestDB(t, dir, other, nil); !errors.Is(err, ErrKeyPrefixMismatch) {
+		t.Errorf("opening with the prefix %q returned %v", other, err)
+	}
+	if db, err := openTestDB(t, dir, testPrefix, nil); err != nil || db.IsNew() {
+		t.Errorf("reopening with the prefix %q: %v", testPrefix, err)
+	}
+}
+
+func TestCheckKeyPrefixMarksNewDatabases(t *testing.T) {
+	for _, prefix := range testPrefixes {
+		// Without the prefix and the key probe the database looks like one written before either
+		db := newTestDB(t, prefix, nil)
+		for _, key := range []string{keyPrefixMetaKey, keyProbeMetaKey} {
+			if err := db.DeleteMeta(key); err != nil {
+				t.Fatalf("DeleteMeta %s: %v", key, err)
+			}
+		}
+		db.isNew = false
+
+		if err := db.checkKeyPrefix(); err != nil {
+			t.Fatalf("checkKeyPrefix with %q: %v", prefix, err)
+		}
+		// Only the default prefix has databases from before prefixes were recorded
+		if want := prefix != DefaultKeyPrefix; db.IsNew() != want {
+			t.Errorf("the database with %q is new %v, want %v", prefix, db.IsNew(), want)
+		}
+		if recorded, err := db.GetMeta(keyPrefixMetaKey); err != nil || string(recorded) != prefix {
+			t.Errorf("the prefix %q was recorded as %q: %v", prefix, recorded, err)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package kdb
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// LMFullMetaKey is the meta entry linking an LM half to the LM hash it was last imported with,
+// holding the type and sum of that hash as type:sum like SiblingMetaKey
+const LMFullMetaKey = "lm_full"
+
+// RIDMetaKey is the meta entry holding the relative ID of the account of a pwdump line
+const RIDMetaKey = "rid"
+
+// lmHalfLength is the length of the plaintext each half of an LM hash encodes
+const lmHalfLength = 7
+
+// lmHalvesSource is the source of LM hashes and halves cracked by AssembleLMHalves
+var lmHalvesSource = &Source{Tool: "krkndb", Rule: "lm-halves"}
+
+// LMHalf is one half of an LM hash, the hash of 7 characters of the uppercased password
+type LMHalf struct {
+	Hash    string `json:"hash"`            // 16 hex characters
+	Value   string `json:"value,omitempty"` // The plaintext of the half, "" until it is cracked
+	Cracked bool   `json:"cracked"`         // Always true for the LM hash of "", the second half of short passwords
+	Stored  bool   `json:"stored"`          // Stored as a hash of its own
+}
+
+// LMHalves is an LM hash split into the halves cracking tools crack separately
+type LMHalves struct {
+	Hash      string `json:"hash"`
+	Left      LMHalf `json:"left"`
+	Right     LMHalf `json:"right"`
+	Plaintext string `json:"plaintext,omitempty"` // The halves put together once both are cracked
+	Cracked   bool   `json:"cracked"`             // Both halves are cracked
+}
+
+// splitLM returns the halves of a 32 character LM hash
+func splitLM(hash string) (string, string) {
+	return hash[:16], hash[16:]
+}
+
+// lmHalves returns the halves of lm to store along with it, the empty half is left out
+func (kc *KDB) lmHalves(lm *Hash) []*Hash {
+	var halves []*Hash
+	left, right := splitLM(lm.Canonical())
+	for _, half := range []string{left, right} {
+		if half == emptyLMHalf {
+			continue
+		}
+		sh := kc.NewHash(half, "", LM)
+		sh.Meta = map[string]string{LMFullMetaKey: siblingRef(lm)}
+		halves = append(halves, sh)
+	}
+	return halves
+}
+
+// lmHalfValue returns the part of the LM plaintext plain that half i encodes, false if plain
+// doesn't hash to half
+func lmHalfValue(plain string, i int, half string) (string, bool) {
+	if !isASCII(plain) || len(plain) > maxLMPassword {
+		return "", false
+	}
+	plain = strings.ToUpper(plain)
+	part := plain[min(i*lmHalfLength, len(plain)):min((i+1)*lmHalfLength, len(plain))]
+	full, ok := util.LMHash(part)
+	if !ok || full[:16] != half {
+		return "", false
+	}
+	return part, true
+}
+
+// GetLMHalves returns the halves of an LM hash with their cracked status. A half is cracked if it
+// is stored with a value or the LM hash is, and the plaintext is put together once both are.
+// Returns ErrInvalidHash for a hash that isn't 32 hex characters and badger.ErrKeyNotFound if
+// neither the LM hash nor one of its halves is stored
+func (kc *KDB) GetLMHalves(fullHash string) (*LMHalves, error) {
+	fullHash = normalizeHash(strings.TrimSpace(fullHash))
+	if len(fullHash) != 32 || !isHex(fullHash) {
+		return nil, fmt.Errorf("%w: LM hashes are 32 hex characters", ErrInvalidHash)
+	}
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	halves := &LMHalves{Hash: fullHash}
+	err := kc.c.View(func(txn *badger.Txn) error {
+		full, err := kc.storedLM(txn, fullHash)
+		if err != nil {
+			return err
+		}
+		found := full != nil
+
+		left, right := splitLM(fullHash)
+		for i, half := range []*LMHalf{{Hash: left}, {Hash: right}} {
+			stored, err := kc.storedLM(txn, half.Hash)
+			if err != nil {
+				return err
+			}
+			if stored != nil {
+				found, half.Stored, half.Value = true, true, stored.Value
+			}
+			if half.Value == "" && full != nil && full.Value != "" {
+				half.Value, _ = lmHalfValue(full.Value, i, half.Hash)
+			}
+			half.Cracked = half.Value != "" || half.Hash == emptyLMHalf
+			if i == 0 {
+				halves.Left = *half
+			} else {
+				halves.Right = *half
+			}
+		}
+		if !found {
+			return badger.ErrKeyNotFound
+		}
+		return nil
+	})
+	kc.countLookup(err)
+	if err != nil {
+		return nil, err
+	}
+
+	halves.Cracked = halves.Left.Cracked && halves.Right.Cracked
+	if halves.Cracked {
+		halves.Plaintext = halves.Left.Value + halves.Right.Value
+	}
+	return halves, nil
+}
+
+// storedLM returns the stored LM hash or half hash, nil if it isn't stored
+func (kc *KDB) storedLM(txn *badger.Txn, hash string) (*Hash, error) {
+	key, err := kc.originalHashKey(txn, hash, LM)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kc.storedRecord(txn, key)
+}
+
+// lmCrack is a stored LM hash or half and the plaintext AssembleLMHalves found for it
+type lmCrack struct {
+	hash  string
+	value string
+}
+
+// AssembleLMHalves carries cracks between LM hashes and their halves: LM hashes whose halves are
+// both cracked get the halves put together as their value, and the stored halves of cracked LM
+// hashes get their part of it. PromoteLMCracks runs it first. Returns the number of LM hashes and
+// halves cracked
+func (kc *KDB) AssembleLMHalves(ctx context.Context) (int, error) {
+	cracks, err := kc.lmCracks(ctx)
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to match LM hashes with their halves: %v", err), Error)
+		return 0, fmt.Errorf("failed to match LM hashes with their halves: %w", err)
+	}
+
+	for i, c := range cracks {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if err := kc.MarkCracked(c.hash, LM, c.value, lmHalvesSource); err != nil {
+			return i, fmt.Errorf("failed to mark LM hash cracked: %w", err)
+		}
+	}
+
+	if len(cracks) > 0 {
+		kc.log(fmt.Sprintf("Cracked %d LM hashes and halves from each other", len(cracks)), Info)
+	}
+	return len(cracks), nil
+}
+
+// lmCracks returns the LM hashes and halves AssembleLMHalves can crack
+func (kc *KDB) lmCracks(ctx context.Context) ([]lmCrack, error) {
+	var cracks []lmCrack
+	seen := make(map[string]bool)
+	prefix := []byte(kc.keys.key(hashTypeScanPrefix, LM))
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	err := kc.c.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var lm Hash
+			if err := it.Item().Value(func(val []byte) error {
+				return kc.decodeHash(val, &lm)
+			}); err != nil {
+				continue
+			}
+			hash := lm.Canonical()
+			if len(hash) != 32 {
+				continue
+			}
+
+			if lm.Value == "" {
+				plain, ok, err := kc.lmPlaintext(txn, &lm)
+				if err != nil {
+					return err
+				}
+				if ok {
+					cracks = append(cracks, lmCrack{hash: hash, value: plain})
+				}
+				continue
+			}
+
+			left, right := splitLM(hash)
+			for i, half := range []string{left, right} {
+				if half == emptyLMHalf || seen[half] {
+					continue
+				}
+				stored, err := kc.storedLM(txn, half)
+				if err != nil {
+					return err
+				}
+				if stored == nil || stored.Value != "" {
+					continue
+				}
+				if value, ok := lmHalfValue(lm.Value, i, half); ok {
+					seen[half] = true
+					cracks = append(cracks, lmCrack{hash: half, value: value})
+				}
+			}
+		}
+		return nil
+	})
+	return cracks, err
+}
+
+// ExportPwdump writes the accounts imported with ImportPwdump as pwdump lines, user:rid:lm:nt:::,
+// with the LM hash the halves were split from. NT hashes without a user meta entry aren't
+// accounts and are left out, accounts without an LM or NT hash get the hash of the empty
+// password. Returns the number of lines written
+func (kc *KDB) ExportPwdump(w io.Writer, opts ...*ExportOptions) (int, error) {
+	o := exportOptions(opts)
+	bw := bufio.NewWriter(w)
+
+	// Siblings are read from a snapshot, the exporter holds the database while it yields
+	snap, err := kc.Snapshot()
+	if err != nil {
+		return 0, err
+	}
+	defer snap.Release()
+
+	line := func(sh *Hash, lm, nt string) (int, error) {
+		rid := sh.Meta[RIDMetaKey]
+		if rid == "" {
+			rid = "0"
+		}
+		text := fmt.Sprintf("%s:%s:%s:%s:::\n", sh.Meta[UserMetaKey], rid, lm, nt)
+		if _, err := bw.WriteString(text); err != nil {
+			return 0, fmt.Errorf("failed to write account: %w", err)
+		}
+		return len(text), nil
+	}
+
+	ex := kc.newExporter(o)
+	err = ex.each(NTLM, func(nt *Hash) (int, error) {
+		if nt.Meta[UserMetaKey] == "" {
+			return 0, nil
+		}
+		lm := emptyLMHash
+		if sum, ok := strings.CutPrefix(nt.Meta[SiblingMetaKey], fmt.Sprintf("%d:", LM)); ok {
+			sibling, err := snap.GetHashBySum(sum, LM)
+			if err != nil && !isNotFound(err) {
+				return 0, fmt.Errorf("failed to read LM sibling: %w", err)
+			}
+			if sibling != nil {
+				lm = sibling.Canonical()
+			}
+		}
+		return line(nt, lm, nt.Canonical())
+	})
+	if err == nil {
+		// The NT hash of the empty password isn't stored, its LM hash is
+		err = ex.each(LM, func(lm *Hash) (int, error) {
+			if lm.Meta[UserMetaKey] == "" || len(lm.Canonical()) != 32 || strings.HasPrefix(lm.Meta[SiblingMetaKey], fmt.Sprintf("%d:", NTLM)) {
+				return 0, nil
+			}
+			return line(lm, lm.Canonical(), emptyNTHash)
+		})
+	}
+	return ex.finish(err, bw.Flush)
+}
@@ -0,0 +1,69 @@
+package kdb
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// logRecorder keeps the messages sent to its log
+type logRecorder struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (r *logRecorder) log(msg string, _ Severity) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, msg)
+}
+
+// count returns how many of the messages contain substr
+func (r *logRecorder) count(substr string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, msg := range r.messages {
+		if strings.Contains(msg, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestEveryDatabaseLogsToItsOwnLogger(t *testing.T) {
+	var ra, rb, rc logRecorder
+	a := newTestDB(t, testPrefix, func(opts *Options) { opts.Logger = ra.log })
+	b := newTestDB(t, testPrefix, func(opts *Options) { opts.Logger = rb.log })
+	if ra.count("Successfully opened database") != 1 || rb.count("Successfully opened database") != 1 {
+		t.Fatalf("the opens were logged %v and %v", ra.messages, rb.messages)
+	}
+
+	purge := func(db *KDB) {
+		t.Helper()
+		if _, err := db.PurgeByValue(context.Background(), ValueQuery{Match: ValueExact, Value: "x", DryRun: true}); err != nil {
+			t.Fatalf("purge: %v", err)
+		}
+	}
+	purge(a)
+	if ra.count("Purged") != 1 || rb.count("Purged") != 0 {
+		t.Errorf("a's purge was logged %d times to a and %d to b", ra.count("Purged"), rb.count("Purged"))
+	}
+
+	// Setting the logger of b leaves a's alone
+	b.SetLogger(rc.log)
+	purge(b)
+	purge(a)
+	if ra.count("Purged") != 2 || rb.count("Purged") != 0 || rc.count("Purged") != 1 {
+		t.Errorf("the purges were logged %d, %d and %d times", ra.count("Purged"), rb.count("Purged"), rc.count("Purged"))
+	}
+
+	// Where no database is at hand messages go to the default database's logger
+	if Get() == a {
+		logger("no database", Info)
+		if ra.count("no database") != 1 || rc.count("no database") != 0 {
+			t.Errorf("the package logger didn't follow the default database")
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package kdb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	compactProgressEvery = 10 * time.Second // how often CompactNow logs the LSM while flattening
+	flattenStaleRatio    = 0.1              // stale share of the LSM above which a Flatten is worth it
+)
+
+// LevelInfo describes one level of badger's LSM tree
+type LevelInfo struct {
+	Level      int     `json:"level"`
+	Tables     int     `json:"tables"`
+	Size       int64   `json:"size"`        // Bytes on disk
+	TargetSize int64   `json:"target_size"` // Size badger compacts the level down to
+	StaleSize  int64   `json:"stale_size"`  // Bytes of overwritten or deleted entries
+	Score      float64 `json:"score"`       // Compaction priority, above 1 the level is due
+	BaseLevel  bool    `json:"base_level"`  // The level L0 compacts into
+}
+
+// LSMInfo is a point-in-time view of badger's LSM tree and how close writes are to stalling
+type LSMInfo struct {
+	Levels    []LevelInfo `json:"levels"`
+	Tables    int         `json:"tables"`
+	Size      int64       `json:"size"`
+	StaleSize int64       `json:"stale_size"`
+	KeyCount  uint64      `json:"key_count"`  // Keys in all tables, including old versions and tombstones
+	IndexSize int64       `json:"index_size"` // Memory held by table indexes
+	BloomSize int64       `json:"bloom_size"` // Memory held by bloom filters
+
+	L0Tables    int  `json:"l0_tables"`
+	L0CompactAt int  `json:"l0_compact_at"` // Options.NumLevelZeroTables, L0 compaction starts here
+	L0StallAt   int  `json:"l0_stall_at"`   // Options.NumLevelZeroTablesStall, writes stall here
+	NearStall   bool `json:"near_stall"`    // L0 is past the compaction threshold and compactors are behind
+	Stalled     bool `json:"stalled"`       // Writes are stalled until L0 is compacted
+
+	// Pressure is how close writes are to stalling, from 0 to 1, see KDB.WritePressure
+	Pressure float64 `json:"pressure"`
+
+	// FlattenHelps is true when data is spread over several levels and enough of it is stale, or L0
+	// is backed up, so CompactNow would reclaim space or speed up reads
+	FlattenHelps bool `json:"flatten_helps"`
+}
+
+// LSMInfo returns the tables and sizes of every level of badger's LSM tree along with its stall
+// indicators. It reads badger's in-memory table metadata, nothing is read from disk
+func (kc *KDB) LSMInfo() (LSMInfo, error) {
+	if kc.Nil() || kc.c.IsClosed() {
+		return LSMInfo{}, ErrNotInitialized
+	}
+
+	var info LSMInfo
+	nonEmpty := 0
+	for _, l := range kc.c.Levels() {
+		info.Levels = append(info.Levels, LevelInfo{
+			Level:      l.Level,
+			Tables:     l.NumTables,
+			Size:       l.Size,
+			TargetSize: l.TargetSize,
+			StaleSize:  l.StaleDatSize,
+			Score:      l.Score,
+			BaseLevel:  l.IsBaseLevel,
+		})
+		if l.Level == 0 {
+			info.L0Tables = l.NumTables
+		}
+		if l.NumTables > 0 {
+			nonEmpty++
+		}
+	}
+
+	for _, t := range kc.c.Tables() {
+		info.Tables++
+		info.Size += int64(t.OnDiskSize)
+		info.StaleSize += int64(t.StaleDataSize)
+		info.KeyCount += uint64(t.KeyCount)
+		info.IndexSize += int64(t.IndexSz)
+		info.BloomSize += int64(t.BloomFilterSize)
+	}
+
+	if kc.opts != nil {
+		info.L0CompactAt = kc.opts.NumLevelZeroTables
+		info.L0StallAt = kc.opts.NumLevelZeroTablesStall
+	}
+	if info.L0StallAt > 0 {
+		info.Stalled = info.L0Tables >= info.L0StallAt
+		info.NearStall = !info.Stalled && info.L0CompactAt > 0 && info.L0Tables > info.L0CompactAt
+	}
+
+	info.Pressure = lsmPressure(info)
+
+	stale := info.Size > 0 && float64(info.StaleSize) >= flattenStaleRatio*float64(info.Size)
+	info.FlattenHelps = nonEmpty > 1 && (stale || info.NearStall || info.Stalled)
+
+	return info, nil
+}
+
+// String summarizes the LSM tree for logs
+func (li LSMInfo) String() string {
+	s := fmt.Sprintf("%d tables, %d MB, %d MB stale, L0 %d/%d tables", li.Tables, li.Size>>20, li.StaleSize>>20, li.L0Tables, li.L0StallAt)
+	switch {
+	case li.Stalled:
+		s += ", writes stalled"
+	case li.NearStall:
+		s += ", near stall"
+	}
+	return s
+}
+
+// CompactNow flattens the LSM tree into a single level and then garbage collects the value log,
+// logging the tree as it goes. It returns ErrCompactionRunning if a CompactNow is already running.
+// badger can't interrupt a Flatten once started, ctx is checked before each step
+func (kc *KDB) CompactNow(ctx context.Context) (err error) {
+	if kc.Nil() || kc.c.IsClosed() {
+		return ErrNotInitialized
+	}
+	defer kc.recoverPanic("compaction", &err)
+	if !kc.compacting.CompareAndSwap(false, true) {
+		return ErrCompactionRunning
+	}
+	defer kc.compacting.Store(false)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	before, err := kc.LSMInfo()
+	if err != nil {
+		return err
+	}
+	kc.log(fmt.Sprintf("Compaction started: %s", before), Info)
+	start := time.Now()
+
+	done := make(chan struct{})
+	go kc.logCompactProgress(start, done)
+
+	workers := 1
+	if kc.opts != nil && kc.opts.NumCompactors > 1 {
+		workers = kc.opts.NumCompactors
+	}
+	err = func() error {
+		defer close(done)
+		return kc.c.Flatten(workers)
+	}()
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to flatten LSM tree: %v", err), Error)
+		return fmt.Errorf("failed to flatten LSM tree: %w", err)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := kc.runValueLogGC()
+		if err == badger.ErrNoRewrite || err == badger.ErrRejected { // nothing left, or the periodic GC is running
+			break
+		}
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to run value log GC: %v", err), Error)
+			return fmt.Errorf("failed to run value log GC: %w", err)
+		}
+	}
+
+	after, err := kc.LSMInfo()
+	if err != nil {
+		return err
+	}
+	kc.log(fmt.Sprintf("Compaction finished in %s: %s", time.Since(start).Round(time.Millisecond), after), Info)
+	return nil
+}
+
+// logCompactProgress logs the LSM tree every compactProgressEvery until done is closed
+func (kc *KDB) logCompactProgress(start time.Time, done <-chan struct{}) {
+	defer kc.recoverBackground("compaction progress")
+	ticker := time.NewTicker(compactProgressEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			info, err := kc.LSMInfo()
+			if err != nil {
+				return
+			}
+			kc.log(fmt.Sprintf("Compaction running for %s: %s", time.Since(start).Round(time.Second), info), Info)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package kdb
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	// cleanMarkerFile is written next to badger's files by every clean Close and removed again
+	// once the database is open, a directory without it wasn't closed, or was copied while open
+	cleanMarkerFile = "krkn.clean"
+
+	// badgerManifestFile is badger's record of the tables making up the LSM tree
+	badgerManifestFile = "MANIFEST"
+
+	// cleanGenerationMetaKey is the meta entry holding the generation of the last clean close
+	cleanGenerationMetaKey = "clean_generation"
+)
+
+// cleanMarker is what a clean Close leaves behind for the next open to compare with
+type cleanMarker struct {
+	Generation uint64         `json:"generation"` // Bumped by every clean close, also stored in the meta store
+	ClosedAt   time.Time      `json:"closed_at"`
+	Total      int            `json:"total"`    // The total hash counter
+	Counts     map[uint64]int `json:"counts"`   // The counter of every registered hash type
+	Manifest   string         `json:"manifest"` // SHA-256 of badger's MANIFEST after it closed
+}
+
+// readCleanMarker reads the marker of dir, nil if there is none
+func readCleanMarker(dir string) (*cleanMarker, error) {
+	data, err := os.ReadFile(filepath.Join(dir, cleanMarkerFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var marker cleanMarker
+	if err := json.Unmarshal(data, &marker); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", cleanMarkerFile, err)
+	}
+	return &marker, nil
+}
+
+// writeCleanMarker writes the marker of dir through a temporary file, so a crash leaves either the
+// whole marker or none
+func writeCleanMarker(dir string, marker *cleanMarker) error {
+	data, err := json.Marshal(marker)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, cleanMarkerFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// removeCleanMarker removes the marker of dir if there is one
+func removeCleanMarker(dir string) error {
+	if err := os.Remove(filepath.Join(dir, cleanMarkerFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// manifestChecksum returns the hex SHA-256 of badger's MANIFEST in dir
+func manifestChecksum(dir string) (string, error) {
+	f, err := os.Open(filepath.Join(dir, badgerManifestFile))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// OpenedDirty returns true if the database wasn't closed cleanly before it was opened: it crashed,
+// or the directory is a copy taken while it was open. Such a copy may be missing whatever was
+// written while it was taken. See Options.StrictOpen
+func (kc *KDB) OpenedDirty() bool {
+	return kc.dirtyOpen != ""
+}
+
+// checkCleanMarker compares the marker and MANIFEST checksum read before badger opened the
+// directory with what is in badger, and returns why they don't match, "" if they do. Databases
+// closed before markers were written have no generation and aren't checked
+func (kc *KDB) checkCleanMarker(marker *cleanMarker, manifest string) (string, error) {
+	var generation uint64
+	found := false
+	var total int
+	counts := make(map[uint64]int)
+
+	err := kc.c.View(func(txn *badger.Txn) error {
+		var err error
+		if generation, found, err = kc.readCleanGeneration(txn); err != nil || marker == nil {
+			return err
+		}
+
+		if total, err = kc.readShardedCount(txn, kc.keys.key(totalHashesKey)); err != nil && !isNotFound(err) {
+			return err
+		}
+		for hashType := range marker.Counts {
+			count, err := kc.readTypeCount(txn, hashType)
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+			counts[hashType] = count
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case marker == nil && !found:
+		return "", nil
+	case marker == nil:
+		return fmt.Sprintf("no clean close marker, generation %d was closed cleanly since", generation), nil
+	case marker.Generation != generation:
+		return fmt.Sprintf("clean close marker of generation %d, the database is at generation %d", marker.Generation, generation), nil
+	case marker.Manifest != manifest:
+		return "badger's MANIFEST changed since the clean close", nil
+	case marker.Total != total:
+		return fmt.Sprintf("%d hashes at the clean close, %d now", marker.Total, total), nil
+	}
+	for _, hashType := range slices.Sorted(maps.Keys(marker.Counts)) {
+		if want := marker.Counts[hashType]; counts[hashType] != want {
+			return fmt.Sprintf("%d hashes of type %d at the clean close, %d now", want, hashType, counts[hashType]), nil
+		}
+	}
+	return "", nil
+}
+
+// readCleanGeneration reads the generation of the last clean close within txn, found is false for
+// databases never closed since markers were written
+func (kc *KDB) readCleanGeneration(txn *badger.Txn) (generation uint64, found bool, err error) {
+	item, err := txn.Get([]byte(kc.keys.key(metaPrefix, cleanGenerationMetaKey)))
+	if isNotFound(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	err = item.Value(func(val []byte) error {
+		if len(val) != 8 {
+			return fmt.Errorf("invalid %s entry of %d bytes", cleanGenerationMetaKey, len(val))
+		}
+		generation = binary.BigEndian.Uint64(val)
+		return nil
+	})
+	return generation, true, err
+}
+
+// bumpCleanGeneration stores the next generation and snapshots the counters for the marker Close
+// writes once badger closed. The caller holds kc.mu
+func (kc *KDB) bumpCleanGeneration() (*cleanMarker, error) {
+	marker := &cleanMarker{Counts: make(map[uint64]int)}
+
+	err := kc.c.Update(func(txn *badger.Txn) error {
+		generation, _, err := kc.readCleanGeneration(txn)
+		if err != nil {
+			return err
+		}
+		marker.Generation = generation + 1
+
+		if marker.Total, err = kc.readShardedCount(txn, kc.keys.key(totalHashesKey)); err != nil && !isNotFound(err) {
+			return err
+		}
+		hashTypes, err := kc.readHashTypes(txn)
+		if err != nil {
+			return err
+		}
+		for _, hashType := range hashTypes {
+			count, err := kc.readTypeCount(txn, hashType)
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+			marker.Counts[hashType] = count
+		}
+
+		return txn.Set([]byte(kc.keys.key(metaPrefix, cleanGenerationMetaKey)), binary.BigEndian.AppendUint64(nil, marker.Generation))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return marker, nil
+}
@@ -0,0 +1,78 @@
+package kdb
+
+import (
+	"bytes"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// SetMeta stores an arbitrary value under a key in the meta store.
+// Meta keys live outside the hash keyspace and are never counted or iterated as hashes
+func (kc *KDB) SetMeta(key string, value []byte) error {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	return kc.c.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(kc.keys.key(metaPrefix, key)), value)
+	})
+}
+
+// GetMeta retrieves a value from the meta store.
+// Returns badger.ErrKeyNotFound if the key has never been set
+func (kc *KDB) GetMeta(key string) ([]byte, error) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	var value []byte
+	err := kc.c.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(kc.keys.key(metaPrefix, key)))
+		if err != nil {
+			return err
+		}
+
+		value, err = item.ValueCopy(nil)
+		return err
+	})
+
+	return value, err
+}
+
+// DeleteMeta removes a key from the meta store
+func (kc *KDB) DeleteMeta(key string) error {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	return kc.c.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(kc.keys.key(metaPrefix, key)))
+	})
+}
+
+// listMeta returns every meta entry whose key starts with keyPrefix, keyed by the meta key
+func (kc *KDB) listMeta(keyPrefix string) (map[string][]byte, error) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	entries := make(map[string][]byte)
+	root := []byte(kc.keys.key(metaPrefix, ""))
+	prefix := []byte(kc.keys.key(metaPrefix, keyPrefix))
+
+	err := kc.c.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			entries[string(bytes.TrimPrefix(item.Key(), root))] = value
+		}
+		return nil
+	})
+
+	return entries, err
+}
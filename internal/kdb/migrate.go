@@ -0,0 +1,283 @@
+package kdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/pb"
+	"github.com/dgraph-io/ristretto/v2/z"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	migrationMetaPrefix   = "migration:" // Meta key prefix of the migration checkpoints, followed by the name
+	defaultMigrationBatch = 1000         // hashes handed to a MigrationFunc at once
+	migrationRanges       = 16           // ranges each hash type is migrated in, one per first hex digit of the sum
+)
+
+// MigrationWrite is a change a migration makes to a key
+type MigrationWrite struct {
+	Key    []byte
+	Value  []byte
+	Delete bool // Delete Key instead of setting it to Value
+}
+
+// MigrationFunc migrates a batch of stored hashes, decoded with Key, Sum and HashType set, and
+// returns the writes to make. It runs on several batches at once while the database is held, so
+// it must be safe for concurrent use and must not call methods of the database. Hashes of a range
+// interrupted before its checkpoint are handed to it again on resume, it has to be idempotent
+type MigrationFunc func(ctx context.Context, batch []*Hash) ([]MigrationWrite, error)
+
+// MigrationOptions tunes RunMigration
+type MigrationOptions struct {
+	BatchSize int                     // Hashes per MigrationFunc call, 0 uses 1000
+	Parallel  int                     // MigrationFunc calls running at once, 0 uses GOMAXPROCS
+	HashTypes []uint64                // Hash types to migrate, every registered type if empty
+	Restart   bool                    // Start over instead of resuming the checkpoint of an interrupted run
+	Progress  func(done, total int64) // Called as ranges finish, counts ranges, see OperationFunc
+}
+
+// MigrationResult is what a RunMigration call did
+type MigrationResult struct {
+	Visited int  `json:"visited"` // Hashes handed to the MigrationFunc
+	Written int  `json:"written"` // Writes made
+	Ranges  int  `json:"ranges"`  // Ranges migrated, a hash type has 16
+	Skipped int  `json:"skipped"` // Ranges a previous run finished
+	Resumed bool `json:"resumed"` // A checkpoint of an interrupted run was picked up
+}
+
+// migrationCheckpoint is the progress of a migration, kept in the meta store until it finishes
+type migrationCheckpoint struct {
+	Done    []string  `json:"done"` // Finished ranges, as type:digit
+	Started time.Time `json:"started"`
+}
+
+// migrationRange returns the checkpoint name of range digit of hashType
+func migrationRange(hashType uint64, digit byte) string {
+	return fmt.Sprintf("%d:%c", hashType, digit)
+}
+
+// RunMigration rewrites stored hashes with fn. Each hash type is read in 16 ranges, by the first
+// hex digit of the sum, with badger's Stream framework, and fn is applied to batches of
+// Options.BatchSize hashes with at most Options.Parallel calls running at once. The database is
+// held while a range is migrated, and after its writes are flushed the range is checkpointed
+// under name, so a run that is cancelled or fails resumes with the next range. The checkpoint is
+// removed once every range is done
+func (kc *KDB) RunMigration(ctx context.Context, name string, fn MigrationFunc, opts *MigrationOptions) (MigrationResult, error) {
+	var res MigrationResult
+	if name == "" {
+		return res, fmt.Errorf("migration needs a name")
+	}
+	o := MigrationOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = defaultMigrationBatch
+	}
+	if o.Parallel <= 0 {
+		o.Parallel = runtime.GOMAXPROCS(0)
+	}
+
+	hashTypes := o.HashTypes
+	if len(hashTypes) == 0 {
+		var err error
+		if hashTypes, err = kc.getRegisteredHashTypes(); err != nil {
+			kc.log(fmt.Sprintf("Failed to get registered hash types: %v", err), Error)
+			return res, fmt.Errorf("failed to get registered hash types: %w", err)
+		}
+	}
+
+	defer kc.dedup.suspend()()
+
+	metaKey := migrationMetaPrefix + name
+	checkpoint := migrationCheckpoint{Started: time.Now().UTC()}
+	if !o.Restart {
+		data, err := kc.GetMeta(metaKey)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(data, &checkpoint); err != nil {
+				return res, fmt.Errorf("failed to decode checkpoint of migration %s: %w", name, err)
+			}
+			res.Resumed = true
+			kc.log(fmt.Sprintf("Resuming migration %s, %d ranges done", name, len(checkpoint.Done)), Info)
+		case !isNotFound(err):
+			return res, fmt.Errorf("failed to read checkpoint of migration %s: %w", name, err)
+		}
+	}
+
+	total := int64(len(hashTypes) * migrationRanges)
+	done := int64(0)
+	for _, hashType := range hashTypes {
+		for _, digit := range []byte("0123456789abcdef") {
+			r := migrationRange(hashType, digit)
+			if slices.Contains(checkpoint.Done, r) {
+				res.Skipped++
+			} else {
+				visited, written, err := kc.migrateRange(ctx, name, hashType, digit, fn, o)
+				res.Visited += visited
+				res.Written += written
+				if err != nil {
+					kc.log(fmt.Sprintf("Migration %s failed in range %s: %v", name, r, err), Error)
+					return res, fmt.Errorf("migration %s failed in range %s: %w", name, r, err)
+				}
+				res.Ranges++
+
+				checkpoint.Done = append(checkpoint.Done, r)
+				data, err := json.Marshal(checkpoint)
+				if err != nil {
+					return res, fmt.Errorf("failed to encode checkpoint of migration %s: %w", name, err)
+				}
+				if err := kc.SetMeta(metaKey, data); err != nil {
+					kc.log(fmt.Sprintf("Failed to checkpoint migration %s: %v", name, err), Error)
+					return res, fmt.Errorf("failed to checkpoint migration %s: %w", name, err)
+				}
+			}
+
+			done++
+			if o.Progress != nil {
+				o.Progress(done, total)
+			}
+		}
+	}
+
+	if err := kc.DeleteMeta(metaKey); err != nil {
+		return res, fmt.Errorf("failed to remove checkpoint of migration %s: %w", name, err)
+	}
+	kc.log(fmt.Sprintf("Migration %s finished, %d hashes visited, %d writes", name, res.Visited, res.Written), Info)
+	return res, nil
+}
+
+// migrateRange migrates the hashes of hashType whose sum starts with digit and flushes the writes.
+// Returns the number of hashes visited and of writes made
+func (kc *KDB) migrateRange(ctx context.Context, name string, hashType uint64, digit byte, fn MigrationFunc, o MigrationOptions) (int, int, error) {
+	typePrefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+	var visited, written atomic.Int64
+
+	wb := kc.c.NewWriteBatch()
+	defer wb.Cancel()
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(o.Parallel)
+
+	apply := func(batch []*Hash) {
+		g.Go(func() error {
+			writes, err := fn(gctx, batch)
+			if err != nil {
+				return err
+			}
+			visited.Add(int64(len(batch)))
+			for _, w := range writes {
+				if w.Delete {
+					err = wb.Delete(w.Key)
+				} else {
+					err = wb.Set(w.Key, w.Value)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			written.Add(int64(len(writes)))
+			return nil
+		})
+	}
+
+	stream := kc.c.NewStream()
+	stream.Prefix = append(bytes.Clone(typePrefix), digit)
+	stream.NumGo = o.Parallel
+	stream.LogPrefix = "krkndb.Migration." + name
+
+	// Only the newest version of a key is migrated
+	stream.KeyToList = func(key []byte, itr *badger.Iterator) (*pb.KVList, error) {
+		item := itr.Item()
+		if item.IsDeletedOrExpired() {
+			return nil, nil
+		}
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.KVList{Kv: []*pb.KV{{Key: key, Value: value}}}, nil
+	}
+
+	var batch []*Hash
+	stream.Send = func(buf *z.Buffer) error {
+		list, err := badger.BufferToKVList(buf)
+		if err != nil {
+			return err
+		}
+		for _, kv := range list.Kv {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
+
+			var hash Hash
+			if err := kc.decodeHash(kv.Value, &hash); err != nil {
+				continue
+			}
+			hash.HashType, hash.Key, hash.sum = hashType, kv.Key, rawSum(kv.Key[len(typePrefix):])
+			hash.record = kv.Value
+			batch = append(batch, &hash)
+			if len(batch) == o.BatchSize {
+				apply(batch)
+				batch = nil
+			}
+		}
+		return nil
+	}
+
+	err := stream.Orchestrate(gctx)
+	if err == nil && len(batch) > 0 {
+		apply(batch)
+	}
+	if waitErr := g.Wait(); err == nil {
+		err = waitErr
+	}
+	if err == nil {
+		if err = ctx.Err(); err == nil {
+			err = wb.Flush()
+		}
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(visited.Load()), int(written.Load()), nil
+}
+
+// migrationWriter collects the writes of a MigrationFunc, for helpers that take an indexWriter
+type migrationWriter struct {
+	writes []MigrationWrite
+}
+
+func (w *migrationWriter) Set(key, value []byte) error {
+	w.writes = append(w.writes, MigrationWrite{Key: key, Value: value})
+	return nil
+}
+
+func (w *migrationWriter) Delete(key []byte) error {
+	w.writes = append(w.writes, MigrationWrite{Key: key, Delete: true})
+	return nil
+}
+
+// StartMigration runs RunMigration as an OpMigration operation. Progress counts ranges
+func (kc *KDB) StartMigration(name string, fn MigrationFunc, opts *MigrationOptions) (uint64, error) {
+	return kc.StartOperation(OpMigration, name, func(ctx context.Context, progress func(done, total int64)) error {
+		o := MigrationOptions{}
+		if opts != nil {
+			o = *opts
+		}
+		o.Progress = progress
+		_, err := kc.RunMigration(ctx, name, fn, &o)
+		return err
+	})
+}
@@ -0,0 +1,212 @@
+package kdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+)
+
+// potfileMirror keeps a potfile in sync with the cracked hashes of one hash type
+type potfileMirror struct {
+	path     string
+	hashType uint64
+	remove   func() // removes the crack hook
+
+	mu sync.Mutex // guards f and serializes appends
+	f  *os.File   // nil once closed
+}
+
+// EnablePotfileMirror keeps the potfile at path up to date with the cracked hashes of hashType.
+// Every hash stored with a value, including through MarkCracked and imports, is appended as
+// hash:plain (hash:salt:plain for salted hashes, plaintexts as $HEX[...] where needed). Each line
+// goes out as a single append under a POSIX record lock, the lock hashcat takes on its own potfile,
+// so concurrent writers never interleave partial lines. A missing potfile is built from the
+// database first. Mirrors are closed by DisablePotfileMirror or Close
+func (kc *KDB) EnablePotfileMirror(path string, hashType uint64) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path for '%s': %w", path, err)
+	}
+
+	kc.mirrorMu.Lock()
+	defer kc.mirrorMu.Unlock()
+
+	if _, ok := kc.mirrors[absPath]; ok {
+		return fmt.Errorf("potfile '%s' is already mirrored", absPath)
+	}
+
+	m := &potfileMirror{path: absPath, hashType: hashType}
+	if !util.PathExists(absPath) {
+		err = kc.rebuildMirror(m)
+	} else {
+		m.f, err = openMirror(absPath)
+	}
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to enable potfile mirror '%s': %v", absPath, err), Error)
+		return fmt.Errorf("failed to enable potfile mirror '%s': %w", absPath, err)
+	}
+
+	m.remove = kc.addCrackHook(func(sh *Hash) {
+		if sh.HashType != m.hashType {
+			return
+		}
+		if err := m.append(sh.hashLine() + ":" + util.EncodeHexPlain(kc.normalizeValue(sh.Value)) + "\n"); err != nil {
+			kc.log(fmt.Sprintf("Failed to append to potfile mirror '%s': %v", m.path, err), Error)
+		}
+	})
+
+	if kc.mirrors == nil {
+		kc.mirrors = make(map[string]*potfileMirror)
+	}
+	kc.mirrors[absPath] = m
+	return nil
+}
+
+// RebuildPotfileMirror regenerates a mirrored potfile from the database, for when it went missing,
+// was edited by hand or missed writes while the mirror was disabled. The new file replaces the old
+// one atomically
+func (kc *KDB) RebuildPotfileMirror(path string) error {
+	m, err := kc.mirror(path)
+	if err != nil {
+		return err
+	}
+
+	if err := kc.rebuildMirror(m); err != nil {
+		kc.log(fmt.Sprintf("Failed to rebuild potfile mirror '%s': %v", m.path, err), Error)
+		return fmt.Errorf("failed to rebuild potfile mirror '%s': %w", m.path, err)
+	}
+	return nil
+}
+
+// DisablePotfileMirror stops mirroring to path and closes the file
+func (kc *KDB) DisablePotfileMirror(path string) error {
+	m, err := kc.mirror(path)
+	if err != nil {
+		return err
+	}
+
+	kc.mirrorMu.Lock()
+	delete(kc.mirrors, m.path)
+	kc.mirrorMu.Unlock()
+
+	return m.close()
+}
+
+// closeMirrors closes every potfile mirror, called by Close
+func (kc *KDB) closeMirrors() error {
+	kc.mirrorMu.Lock()
+	defer kc.mirrorMu.Unlock()
+
+	var errs []error
+	for path, m := range kc.mirrors {
+		if err := m.close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close potfile mirror '%s': %w", path, err))
+		}
+		delete(kc.mirrors, path)
+	}
+	return errors.Join(errs...)
+}
+
+// mirror returns the enabled mirror of path
+func (kc *KDB) mirror(path string) (*potfileMirror, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path for '%s': %w", path, err)
+	}
+
+	kc.mirrorMu.Lock()
+	defer kc.mirrorMu.Unlock()
+
+	m, ok := kc.mirrors[absPath]
+	if !ok {
+		return nil, fmt.Errorf("potfile '%s' is not mirrored", absPath)
+	}
+	return m, nil
+}
+
+// rebuildMirror exports the cracked hashes to a temporary file, renames it over the potfile and
+// switches the mirror to the new file. Appends wait for the rebuild to finish
+func (kc *KDB) rebuildMirror(m *potfileMirror) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	n, err := kc.ExportPotfile(tmp, m.hashType)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), m.path)
+	}
+	if err != nil {
+		return err
+	}
+
+	f, err := openMirror(m.path)
+	if err != nil {
+		return err
+	}
+	if m.f != nil {
+		_ = m.f.Close()
+	}
+	m.f = f
+
+	kc.log(fmt.Sprintf("Rebuilt potfile mirror '%s' with %d hashes", m.path, n), Info)
+	return nil
+}
+
+// openMirror opens a potfile for appending
+func openMirror(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+}
+
+// append writes one line to the potfile in a single write under the file lock
+func (m *potfileMirror) append(line string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.f == nil {
+		return nil // Closed while the hook was running
+	}
+
+	unlock, err := util.LockFile(m.f)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, err = m.f.WriteString(line)
+	return err
+}
+
+// close removes the hook, syncs the potfile and closes it
+func (m *potfileMirror) close() error {
+	if m.remove != nil {
+		m.remove()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.f == nil {
+		return nil
+	}
+	err := m.f.Sync()
+	if closeErr := m.f.Close(); err == nil {
+		err = closeErr
+	}
+	m.f = nil
+	return err
+}
@@ -0,0 +1,97 @@
+package kdb
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeNFCMetaKey records whether values in this database are NFC normalized
+const normalizeNFCMetaKey = "normalize_values_nfc"
+
+// normalizeValue returns value in NFC if Options.NormalizeValuesNFC is enabled.
+// Values that aren't valid UTF-8 are left untouched, they have no canonical form
+func (kc *KDB) normalizeValue(value string) string {
+	if kc.opts == nil || !kc.opts.NormalizeValuesNFC || !utf8.ValidString(value) {
+		return value
+	}
+	return norm.NFC.String(value)
+}
+
+// checkNormalizationFlag compares Options.NormalizeValuesNFC with the setting recorded in the
+// database and warns about mixed usage. The first open records the setting
+func (kc *KDB) checkNormalizationFlag() error {
+	enabled := kc.opts != nil && kc.opts.NormalizeValuesNFC
+
+	recorded, err := kc.GetMeta(normalizeNFCMetaKey)
+	if isNotFound(err) {
+		return kc.recordNormalizationFlag(enabled)
+	}
+	if err != nil {
+		return err
+	}
+
+	wasEnabled := len(recorded) == 1 && recorded[0] == 1
+	switch {
+	case enabled && !wasEnabled:
+		kc.log("NormalizeValuesNFC is enabled but existing values were stored without normalization, run NormalizeValues to migrate them", Warning)
+	case !enabled && wasEnabled:
+		kc.log("NormalizeValuesNFC is disabled but this database was written with NFC normalized values, new values will not be normalized", Warning)
+	}
+
+	return nil
+}
+
+// recordNormalizationFlag stores the normalization setting in the meta store
+func (kc *KDB) recordNormalizationFlag(enabled bool) error {
+	flag := []byte{0}
+	if enabled {
+		flag[0] = 1
+	}
+	return kc.SetMeta(normalizeNFCMetaKey, flag)
+}
+
+// NormalizeValues rewrites every stored value that is not in NFC and records in the database that
+// its values are normalized. Run it once after enabling Options.NormalizeValuesNFC on an existing
+// database, an interrupted run resumes where it stopped. Returns the number of hashes rewritten
+func (kc *KDB) NormalizeValues(ctx context.Context) (int, error) {
+	var rewritten atomic.Int64
+	_, err := kc.RunMigration(ctx, "normalize_nfc", func(ctx context.Context, batch []*Hash) ([]MigrationWrite, error) {
+		var w migrationWriter
+		for _, hash := range batch {
+			if !utf8.ValidString(hash.Value) {
+				continue
+			}
+			normalized := norm.NFC.String(hash.Value)
+			if normalized == hash.Value {
+				continue
+			}
+
+			previous := *hash
+			hash.Value = normalized
+			if err := kc.indexValue(&w, hash, &previous); err != nil {
+				return nil, err
+			}
+			data, err := kc.encodeHash(hash)
+			if err != nil {
+				return nil, err
+			}
+			w.Set(hash.Key, data)
+			rewritten.Add(1)
+		}
+		return w.writes, nil
+	}, nil)
+	if err != nil {
+		return int(rewritten.Load()), fmt.Errorf("failed to normalize values: %w", err)
+	}
+
+	if err := kc.recordNormalizationFlag(true); err != nil {
+		return int(rewritten.Load()), fmt.Errorf("failed to record normalization setting: %w", err)
+	}
+
+	kc.log(fmt.Sprintf("Normalized %d values to NFC", rewritten.Load()), Info)
+	return int(rewritten.Load()), nil
+}
@@ -0,0 +1,178 @@
+package kdb
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	openCheckSamples  = 8      // records decoded per hash type by the open check
+	hashTypeCountScan = "num:" // used to scan every hash type counter shard
+)
+
+// OpenCheck is the result of the consistency check Options.CheckOnOpen runs when a database is
+// opened. It only reads the registry, the counter keys and a few records per hash type, so it
+// stays fast on large databases but can't prove the counts right, RecountHashType does that
+type OpenCheck struct {
+	At        time.Time     `json:"at"`
+	Duration  time.Duration `json:"duration"`
+	HashTypes int           `json:"hash_types"` // Registered hash types checked
+	Sampled   int           `json:"sampled"`    // Records read and decoded
+
+	// MissingCounters are registered hash types without a counter. They got a zero counter and,
+	// if records of the type were sampled, a dirty flag so the next dirty recount counts them
+	MissingCounters []uint64 `json:"missing_counters,omitempty"`
+	// Unregistered are hash types with a counter that weren't in the registry, they were registered
+	Unregistered []uint64 `json:"unregistered,omitempty"`
+	// Undecodable holds the keys of sampled records that failed to decode
+	Undecodable []string `json:"undecodable,omitempty"`
+}
+
+// OK returns true if the check found nothing wrong
+func (oc *OpenCheck) OK() bool {
+	return len(oc.MissingCounters) == 0 && len(oc.Unregistered) == 0 && len(oc.Undecodable) == 0
+}
+
+// String summarizes the check for logs
+func (oc *OpenCheck) String() string {
+	if oc.OK() {
+		return fmt.Sprintf("%d hash types and %d sampled records consistent", oc.HashTypes, oc.Sampled)
+	}
+	return fmt.Sprintf("%d hash types without a counter %v, %d unregistered hash types %v, %d of %d sampled records undecodable",
+		len(oc.MissingCounters), oc.MissingCounters, len(oc.Unregistered), oc.Unregistered, len(oc.Undecodable), oc.Sampled)
+}
+
+// LastOpenCheck returns the result of the check Options.CheckOnOpen ran when the database was
+// opened, nil if it didn't run
+func (kc *KDB) LastOpenCheck() *OpenCheck {
+	return kc.openCheck
+}
+
+// checkOnOpen compares the registry with the counters, fixing what it can, and decodes a sample of
+// records of every hash type
+func (kc *KDB) checkOnOpen() (*OpenCheck, error) {
+	check := &OpenCheck{At: time.Now()}
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	err := kc.c.Update(func(txn *badger.Txn) error {
+		registered, err := kc.readHashTypes(txn)
+		if err != nil {
+			return fmt.Errorf("failed to read registered hash types: %w", err)
+		}
+		counted, err := kc.countedHashTypes(txn)
+		if err != nil {
+			return fmt.Errorf("failed to read hash type counters: %w", err)
+		}
+		check.HashTypes = len(registered)
+
+		for _, hashType := range registered {
+			sampled, err := kc.sampleRecords(txn, hashType, check)
+			if err != nil {
+				return fmt.Errorf("failed to sample hash type %d: %w", hashType, err)
+			}
+			if counted[hashType] {
+				continue
+			}
+
+			check.MissingCounters = append(check.MissingCounters, hashType)
+			if err := kc.setTypeCount(txn, hashType, 0); err != nil {
+				return err
+			}
+			if sampled > 0 {
+				if err := txn.Set(kc.keys.dirtyTypeKey(hashType), nil); err != nil {
+					return err
+				}
+			}
+		}
+
+		for hashType := range counted {
+			if slices.Contains(registered, hashType) {
+				continue
+			}
+			check.Unregistered = append(check.Unregistered, hashType)
+			if err := kc.addHashType(txn, hashType); err != nil {
+				return err
+			}
+		}
+		slices.Sort(check.Unregistered)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	check.Duration = time.Since(check.At)
+	return check, nil
+}
+
+// countedHashTypes returns the hash types that have a counter, read from the counter keys alone
+func (kc *KDB) countedHashTypes(txn *badger.Txn) (map[uint64]bool, error) {
+	prefix := []byte(kc.keys.key(hashTypeCountScan))
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+	it := kc.newIterator(txn, opts)
+	defer it.Close()
+
+	counted := make(map[uint64]bool)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		// type or type:shard
+		rest := string(it.Item().Key()[len(prefix):])
+		if i := strings.IndexByte(rest, ':'); i >= 0 {
+			rest = rest[:i]
+		}
+		hashType, err := strconv.ParseUint(rest, 10, 64)
+		if err != nil {
+			kc.log(fmt.Sprintf("Skipping malformed counter key %q", it.Item().Key()), Warning)
+			continue
+		}
+		counted[hashType] = true
+	}
+	return counted, nil
+}
+
+// sampleRecords decodes up to openCheckSamples records of hashType, found by seeking to random
+// sums so they come from all over the type. Returns how many records were read
+func (kc *KDB) sampleRecords(txn *badger.Txn, hashType uint64, check *OpenCheck) (int, error) {
+	prefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+	it := kc.newIterator(txn, opts)
+	defer it.Close()
+
+	seen := make(map[string]bool, openCheckSamples)
+	for range openCheckSamples {
+		it.Seek(append(bytes.Clone(prefix), fmt.Sprintf("%02x", rand.IntN(256))...))
+		if !it.ValidForPrefix(prefix) {
+			it.Seek(prefix)
+			if !it.ValidForPrefix(prefix) {
+				return 0, nil
+			}
+		}
+
+		item := it.Item()
+		if seen[string(item.Key())] {
+			continue
+		}
+		seen[string(item.Key())] = true
+
+		var hash Hash
+		if err := item.Value(func(val []byte) error {
+			return kc.decodeHash(val, &hash)
+		}); err != nil {
+			check.Undecodable = append(check.Undecodable, string(item.Key()))
+		}
+		check.Sampled++
+	}
+	return len(seen), nil
+}
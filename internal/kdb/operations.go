@@ -0,0 +1,335 @@
+package kdb
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxFinishedOperations is how many finished operations ListOperations keeps
+const maxFinishedOperations = 32
+
+// OperationKind is what a long-running operation does. Operations of kinds that conflict can't
+// run at the same time, see StartOperation
+type OperationKind string
+
+const (
+	OpRecount   OperationKind = "recount"   // Counter recounts
+	OpImport    OperationKind = "import"    // Imports, several may run at once
+	OpMigration OperationKind = "migration" // Rewrites of stored records, such as NormalizeValues or InternValues
+	OpGC        OperationKind = "gc"        // Compaction and value log garbage collection
+	OpCheck     OperationKind = "check"     // Integrity checks
+	OpRetention OperationKind = "retention" // Retention sweeps deleting expired hashes
+)
+
+// operationConflicts are the kinds each kind can't run alongside. Kinds not listed only conflict
+// with themselves
+var operationConflicts = map[OperationKind][]OperationKind{
+	OpRecount:   {OpRecount, OpMigration},
+	OpImport:    {OpMigration},
+	OpMigration: {OpMigration, OpImport, OpRecount, OpCheck, OpRetention},
+	OpGC:        {OpGC},
+	OpCheck:     {OpCheck, OpMigration},
+	OpRetention: {OpRetention, OpMigration},
+}
+
+// conflictsWith returns true if operations of kinds a and b can't run at the same time
+func (a OperationKind) conflictsWith(b OperationKind) bool {
+	conflicts, ok := operationConflicts[a]
+	if !ok {
+		return a == b
+	}
+	return slices.Contains(conflicts, b)
+}
+
+// OperationState is where an operation is in its life
+type OperationState int
+
+const (
+	OperationRunning OperationState = iota
+	OperationSucceeded
+	OperationFailed
+	OperationCanceled
+)
+
+// String returns the name of the state, e.g. "running"
+func (s OperationState) String() string {
+	switch s {
+	case OperationRunning:
+		return "running"
+	case OperationSucceeded:
+		return "succeeded"
+	case OperationFailed:
+		return "failed"
+	case OperationCanceled:
+		return "canceled"
+	default:
+		return fmt.Sprintf("state %d", int(s))
+	}
+}
+
+// MarshalText encodes the state as its name
+func (s OperationState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Operation is the status of an operation started with StartOperation
+type Operation struct {
+	ID       uint64         `json:"id"`
+	Kind     OperationKind  `json:"kind"`
+	Name     string         `json:"name"` // What is running, such as "NormalizeValues"
+	State    OperationState `json:"state"`
+	Done     int64          `json:"done"`  // Progress in units of the operation, such as lines or hash types
+	Total    int64          `json:"total"` // What Done counts up to, 0 if not known
+	Started  time.Time      `json:"started"`
+	Finished time.Time      `json:"finished,omitzero"` // Zero while running
+	Error    string         `json:"error,omitempty"`   // Why a failed operation failed
+}
+
+// Duration returns how long the operation ran, or has run so far
+func (op Operation) Duration() time.Duration {
+	if op.Finished.IsZero() {
+		return time.Since(op.Started)
+	}
+	return op.Finished.Sub(op.Started)
+}
+
+// OperationFunc is the work of an operation. It stops once ctx is done and may report its
+// progress, done out of total, at any time
+type OperationFunc func(ctx context.Context, progress func(done, total int64)) error
+
+// operation is an operation known to the manager
+type operation struct {
+	Operation
+	cancel context.CancelFunc
+	done   chan struct{} // closed once the operation finished
+}
+
+// operationManager keeps the running and recently finished operations of a database
+type operationManager struct {
+	mu       sync.Mutex
+	nextID   uint64
+	running  map[uint64]*operation
+	finished []*operation // oldest first
+	closed   bool         // set by stopAll, no operation starts after it
+	wg       sync.WaitGroup
+}
+
+// StartOperation runs fn in the background as an operation of kind and returns its ID, for
+// ListOperations, WaitOperation and CancelOperation. Operations of conflicting kinds are mutually
+// exclusive: recounts, migrations, garbage collections and checks run one at a time, imports
+// alongside each other but not during a migration, and a migration alone. Starting one that
+// conflicts with a running operation returns ErrOperationInProgress naming it. Close cancels the
+// operations still running and waits for them
+func (kc *KDB) StartOperation(kind OperationKind, name string, fn OperationFunc) (uint64, error) {
+	m := &kc.operations
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return 0, ErrNotInitialized
+	}
+	for _, id := range slices.Sorted(maps.Keys(m.running)) {
+		if other := m.running[id]; kind.conflictsWith(other.Kind) || other.Kind.conflictsWith(kind) {
+			return 0, fmt.Errorf("%w: %s operation %d (%s) is running", ErrOperationInProgress, other.Kind, other.ID, other.Name)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.nextID++
+	op := &operation{
+		Operation: Operation{ID: m.nextID, Kind: kind, Name: name, State: OperationRunning, Started: time.Now()},
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	if m.running == nil {
+		m.running = make(map[uint64]*operation)
+	}
+	m.running[op.ID] = op
+	kc.log(fmt.Sprintf("Operation %d started: %s %s", op.ID, kind, name), Info)
+
+	// A panic fails the operation, finish still runs so waiters don't hang
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		_, err := recovered(kc, fmt.Sprintf("operation %d", op.ID), func() (struct{}, error) {
+			return struct{}{}, fn(ctx, func(done, total int64) {
+				m.mu.Lock()
+				op.Done, op.Total = done, total
+				m.mu.Unlock()
+			})
+		})
+		kc.finishOperation(op, ctx, err)
+	}()
+	return op.ID, nil
+}
+
+// finishOperation records the outcome of op and moves it to the finished operations
+func (kc *KDB) finishOperation(op *operation, ctx context.Context, err error) {
+	m := &kc.operations
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	op.Finished = time.Now()
+	switch {
+	case err == nil:
+		op.State = OperationSucceeded
+	case ctx.Err() != nil:
+		op.State = OperationCanceled
+	default:
+		op.State = OperationFailed
+		op.Error = err.Error()
+	}
+	op.cancel()
+	close(op.done)
+
+	delete(m.running, op.ID)
+	m.finished = append(m.finished, op)
+	if len(m.finished) > maxFinishedOperations {
+		m.finished = slices.Delete(m.finished, 0, len(m.finished)-maxFinishedOperations)
+	}
+
+	severity := Info
+	if op.State == OperationFailed {
+		severity = Warning
+	}
+	kc.log(fmt.Sprintf("Operation %d %s after %v: %s %s", op.ID, op.State, op.Duration().Round(time.Millisecond), op.Kind, op.Name), severity)
+}
+
+// ListOperations returns the running operations and the most recently finished ones, ordered by ID
+func (kc *KDB) ListOperations() []Operation {
+	m := &kc.operations
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ops := make([]Operation, 0, len(m.running)+len(m.finished))
+	for _, op := range m.finished {
+		ops = append(ops, op.Operation)
+	}
+	for _, op := range m.running {
+		ops = append(ops, op.Operation)
+	}
+	slices.SortFunc(ops, func(a, b Operation) int { return cmp.Compare(a.ID, b.ID) })
+	return ops
+}
+
+// GetOperation returns the status of an operation. Returns ErrOperationNotFound for IDs that
+// weren't handed out or whose operation finished too long ago to be kept
+func (kc *KDB) GetOperation(id uint64) (Operation, error) {
+	op, err := kc.operations.lookup(id)
+	if err != nil {
+		return Operation{}, err
+	}
+	kc.operations.mu.Lock()
+	defer kc.operations.mu.Unlock()
+	return op.Operation, nil
+}
+
+// CancelOperation cancels a running operation and returns without waiting for it to stop, see
+// WaitOperation. Cancelling a finished operation does nothing. Returns ErrOperationNotFound for
+// unknown IDs
+func (kc *KDB) CancelOperation(id uint64) error {
+	op, err := kc.operations.lookup(id)
+	if err != nil {
+		return err
+	}
+	op.cancel()
+	return nil
+}
+
+// WaitOperation waits for an operation to finish and returns its final status, or ctx.Err() if
+// ctx is done first. Returns ErrOperationNotFound for unknown IDs
+func (kc *KDB) WaitOperation(ctx context.Context, id uint64) (Operation, error) {
+	op, err := kc.operations.lookup(id)
+	if err != nil {
+		return Operation{}, err
+	}
+	select {
+	case <-op.done:
+	case <-ctx.Done():
+		return Operation{}, ctx.Err()
+	}
+	return kc.GetOperation(id)
+}
+
+// lookup returns the running or finished operation of id
+func (m *operationManager) lookup(id uint64) (*operation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if op, ok := m.running[id]; ok {
+		return op, nil
+	}
+	for _, op := range m.finished {
+		if op.ID == id {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %d", ErrOperationNotFound, id)
+}
+
+// stopAll cancels the running operations and waits for them to finish
+func (m *operationManager) stopAll() {
+	m.mu.Lock()
+	m.closed = true
+	for _, op := range m.running {
+		op.cancel()
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+}
+
+// StartRecount recounts the counters of hashTypes, every registered type if none are given, and
+// the total as an OpRecount operation. Progress counts hash types
+func (kc *KDB) StartRecount(hashTypes ...uint64) (uint64, error) {
+	return kc.StartOperation(OpRecount, "Recount", func(ctx context.Context, progress func(done, total int64)) error {
+		all := len(hashTypes) == 0
+		if all {
+			var err error
+			if hashTypes, err = kc.getRegisteredHashTypes(); err != nil {
+				return fmt.Errorf("failed to get registered hash types: %w", err)
+			}
+		}
+
+		total := 0
+		for i, hashType := range hashTypes {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := kc.RecountHashType(hashType); err != nil {
+				return err
+			}
+			count, err := kc.typeCount(hashType)
+			if err != nil && !isNotFound(err) {
+				return fmt.Errorf("failed to read count for hash type %d: %w", hashType, err)
+			}
+			total += count
+			progress(int64(i+1), int64(len(hashTypes)))
+		}
+		if !all {
+			return nil
+		}
+		return kc.recordShardedCount(kc.keys.key(totalHashesKey), total)
+	})
+}
+
+// StartIngest imports r with IngestStream as an OpImport operation. Progress counts lines read
+func (kc *KDB) StartIngest(r io.Reader, format Format, hashType uint64) (uint64, error) {
+	return kc.StartOperation(OpImport, fmt.Sprintf("Ingest %s", format), func(ctx context.Context, progress func(done, total int64)) error {
+		return kc.IngestStream(ctx, r, format, hashType, func(p IngestProgress) {
+			progress(int64(p.Lines), 0)
+		})
+	})
+}
+
+// StartCompaction runs CompactNow as an OpGC operation
+func (kc *KDB) StartCompaction() (uint64, error) {
+	return kc.StartOperation(OpGC, "CompactNow", func(ctx context.Context, _ func(done, total int64)) error {
+		return kc.CompactNow(ctx)
+	})
+}
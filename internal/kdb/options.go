@@ -69,6 +69,98 @@ BaseLevelSize: The size of the base level in bytes
 MaxLevels: The maximum number of levels of compaction
 
 BloomFalsePositive: The false positive rate of the bloom filter
+
+Expvar: Publish debug metrics under the "krkndb" expvar map
+
+MaxHashSize: The maximum size of a hash string in bytes, 0 uses the default. Kerberos tickets may be up to 64KB whatever it is
+
+MaxValueSize: The maximum size of a cracked value in bytes, 0 uses the default
+
+StrictValidation: Reject hashes with an out of range hash type, a hash string that isn't valid UTF-8, a digest of the wrong length for its type (see HashType.ExpectedLength), a malformed NetNTLM capture or Kerberos ticket
+
+NormalizeValuesNFC: Normalize plaintext values to Unicode NFC before they are stored
+
+Fallbacks: Resolvers consulted in order by GetHashByOriginalHash when a hash isn't stored
+
+FallbackTimeout: How long each fallback may take, 0 uses the default
+
+FallbackWriteBack: Store hashes found by a fallback, tagged with the resolver in their metadata
+
+FallbackTTL: How long written back hashes are kept, 0 keeps them
+
+AutoMigrate: Upgrade databases written with an older schema version when they are opened
+
+CompressValueThreshold: Stored records larger than this many bytes are zstd compressed, 0 disables compression
+
+TxRetries: How often Update, stores, deletes and counter updates run again after a conflict, 0 uses the default
+
+RecountDirtyOnOpen: Recount the hash types left dirty by an unclean shutdown when the database is opened
+
+ValueIndex: Maintain an index of cracked hashes ordered by value for GetHashesByValueOrder
+
+CounterShards: How many keys each hash type counter and the total are spread over so overlapping transactions don't contend on one, 0 uses the default
+
+DetectConflicts: Have badger detect conflicting transactions, see TxRetries
+
+KeyPrefix: The first segment of every key, for sharing a badger directory with other data. Fixed at creation
+
+CheckOnOpen: Compare the hash type registry with the counters and decode a few records per type when the database is opened, see KDB.LastOpenCheck
+
+KeySource: Where New loads the encryption key from when it is passed none: a file path, env:NAME or keyring:service/account
+
+WarmupOnOpen: Read every registered hash type in the background after opening to fill the caches, see KDB.Warmup
+
+WarmupFraction: The share of values WarmupOnOpen reads along with the keys, 0 uses the default
+
+WarmupRate: The most keys a second a warmup reads, 0 uses the default
+
+ChallengeKeying: What NetNTLMv1 and NetNTLMv2 captures are keyed on, so captures of one account share a record
+
+ChallengeCaptures: How many captures a record keyed on an account keeps, 0 uses the default
+
+VerifyCracks: Importers hash every imported value with VerifyCrack and reject the ones that don't match their hash
+
+TicketValueThreshold: ValueThreshold is lowered to this so Kerberos ticket records are kept in the value log, 0 keeps ValueThreshold
+
+IngestProgressInterval: How often IngestStream reports progress, 0 uses the default
+
+StrictOpen: Refuse to open a database whose clean close marker is missing or doesn't match it, see KDB.OpenedDirty
+
+MaxOpenIterators: How many transaction and snapshot iterations may be open at once before new ones fail with ErrTooManyIterators, 0 means no limit
+
+MaxBatchBytes: How many bytes StoreHashes batches may buffer at once before new ones fail with ErrBatchTooLarge, 0 means no limit
+
+ValueIndexFolded: Maintain a case folded index of cracked hashes so case-insensitive value queries don't scan
+
+WritePressureLevel: The write pressure from which the database counts as pressured, see KDB.WritePressure
+
+ThrottleWrites: Have StoreHashes wait before writing while the database is pressured, longer as pressure nears 1
+
+ThrottleMaxDelay: The longest a StoreHashes batch waits when throttled, at full pressure
+
+OnWritePressure: Called when the database becomes pressured and when it recovers, with the pressure read
+
+DefaultOpTimeout: How long the lookups and writes that take no context may take before failing with ErrOperationTimeout, 0 means no limit
+
+AdoptForeignDB: Open a directory holding badger data without a KrknDB identity marker instead of failing with ErrForeignDatabase, writing the marker
+
+SessionTTL: How long a session set may go unwritten before SweepSessions drops it, 0 uses the default
+
+ValueDecoder: Called on every non-empty value read, with the stored bytes, to unwrap values stored wrapped. Its errors surface as ValueDecodeError
+
+ValueEncoder: Called on every non-empty value stored, after normalization, to wrap it the way ValueDecoder unwraps it
+
+WrapEngine: Called once on open with the badger database, every call of the KDB goes through the Engine it returns
+
+DedupWindow: How many recently stored hashes StoreHash and StoreHashes remember to skip storing again unchanged, 0 turns the window off
+
+DedupWindowTTL: How long the dedup window remembers a stored hash, 0 uses the default
+
+VerifyIndexesOnOpen: Start an index check on open that verifies the secondary indexes against the records and repairs the drift it finds, see StartIndexCheck
+
+VerifyIndexesSample: The fraction of records and index entries VerifyIndexesOnOpen checks, 0 uses the default
+
+RetentionInterval: How often the retention policies set by SetRetention are applied in the background, 0 only applies them on ApplyRetention
 */
 type Options struct {
 	ValueDir                      string
@@ -87,6 +179,52 @@ type Options struct {
 	MaxLevels                     int
 	BloomFalsePositive            float64
 	Logger                        Logger
+	Expvar                        bool
+	MaxHashSize                   int
+	MaxValueSize                  int
+	StrictValidation              bool
+	NormalizeValuesNFC            bool
+	Fallbacks                     []Resolver
+	FallbackTimeout               time.Duration
+	FallbackWriteBack             bool
+	FallbackTTL                   time.Duration
+	AutoMigrate                   bool
+	CompressValueThreshold        int
+	TxRetries                     int
+	RecountDirtyOnOpen            bool
+	ValueIndex                    bool
+	CounterShards                 int
+	DetectConflicts               bool
+	KeyPrefix                     string
+	CheckOnOpen                   bool
+	KeySource                     string
+	WarmupOnOpen                  bool
+	WarmupFraction                float64
+	WarmupRate                    int
+	ChallengeKeying               ChallengeKeying
+	ChallengeCaptures             int
+	VerifyCracks                  bool
+	TicketValueThreshold          int64
+	IngestProgressInterval        time.Duration
+	StrictOpen                    bool
+	MaxOpenIterators              int
+	MaxBatchBytes                 int64
+	ValueIndexFolded              bool
+	WritePressureLevel            float64
+	ThrottleWrites                bool
+	ThrottleMaxDelay              time.Duration
+	OnWritePressure               func(pressured bool, pressure float64)
+	DefaultOpTimeout              time.Duration
+	AdoptForeignDB                bool
+	SessionTTL                    time.Duration
+	ValueDecoder                  func(raw []byte, h *Hash) ([]byte, error)
+	ValueEncoder                  func(raw []byte, h *Hash) ([]byte, error)
+	WrapEngine                    func(db Engine) Engine
+	DedupWindow                   int
+	DedupWindowTTL                time.Duration
+	VerifyIndexesOnOpen           bool
+	VerifyIndexesSample           float64
+	RetentionInterval             time.Duration
 }
 
 /*
@@ -108,7 +246,7 @@ DefaultOptions returns the default options for the KDB
 
 	NumMemTables: 10 - Number of in-memory tables
 
-	NumCompactors: NumCPU() - Number of compaction threads
+	NumCompactors: NumCPU(), at least 2 - Number of compaction threads
 
 	NumLevelZeroTables: 20 - Maximum number of L0 tables before compaction
 
@@ -119,8 +257,110 @@ DefaultOptions returns the default options for the KDB
 	MaxLevels: 7 - Maximum number of levels of compaction
 
 	BloomFalsePositive: 0.01 - Describes the false positive rate of the bloom filter.
+
+	Expvar: false - Don't publish to the global expvar namespace unless asked to
+
+	MaxHashSize: 4KB - Longer hash strings are rejected with ErrHashTooLarge
+
+	MaxValueSize: 1MB - Larger values are rejected with ErrValueTooLarge
+
+	StrictValidation: false - Only the empty hash and size checks apply
+
+	NormalizeValuesNFC: false - Values are stored byte for byte as given
+
+	Fallbacks: none - Misses are final
+
+	FallbackTimeout: 5 seconds - A fallback that takes longer counts as failed, five failures in a row skip it for 30 seconds
+
+	FallbackWriteBack: false - Fallback results are returned but not stored
+
+	FallbackTTL: 0 - Written back hashes don't expire
+
+	AutoMigrate: false - Opening an older database fails with ErrMigrationRequired
+
+	CompressValueThreshold: 0 - Records rely on badger's block compression only
+
+	TxRetries: 3 - Conflicting transactions are tried up to four times in total, with a jittered backoff in between, then fail with ErrTooMuchContention
+
+	RecountDirtyOnOpen: true - Only the types being written when a crash happened get recounted
+
+	ValueIndex: false - No value index, it costs one extra key per cracked hash
+
+	CounterShards: 8 - Readers sum the shards, every shard written is read whatever the setting
+
+	DetectConflicts: true - A transaction that read keys written since it started fails with a conflict and is retried, off skips that bookkeeping
+
+	KeyPrefix: "krkn" - Opening with another prefix than the database was created with fails with ErrKeyPrefixMismatch
+
+	CheckOnOpen: false - Opening doesn't look at the counters beyond the dirty recount
+
+	KeySource: "" - The key passed to New is used
+
+	WarmupOnOpen: false - Caches fill as lookups come in
+
+	WarmupFraction: 0.1 - One value in ten is read, keys are always read
+
+	WarmupRate: 50000 - Keys a second, in batches of 1000 between which foreground operations get the database
+
+	ChallengeKeying: ChallengeKeyAccount - Captures are keyed on user and domain, a lookup by any capture of the account finds its record
+
+	ChallengeCaptures: 5 - The latest capture and the four before it
+
+	VerifyCracks: false - Imported values are trusted, most modes can't be verified locally anyway
+
+	TicketValueThreshold: 512B - Records this large, every ticket and few others, go to the value log so millions of tickets don't inflate the LSM tree
+
+	IngestProgressInterval: 1 second
+
+	StrictOpen: false - A database that wasn't closed cleanly opens with a warning and OpenedDirty set
+
+	MaxOpenIterators: 64 - Iterations of KDB hold its lock throughout, so only one is open at a time, and are counted but never refused like the database's own work
+
+	MaxBatchBytes: 1GB - Approximated from the keys and fields of the hashes, about 5M hashes with short values
+
+	ValueIndexFolded: false - Case-insensitive value queries scan, the index costs one extra key per cracked hash
+
+	WritePressureLevel: 0.75 - Pressured once L0 is three quarters of the way from NumLevelZeroTables to NumLevelZeroTablesStall, recovered 0.1 below
+
+	ThrottleWrites: false - Writes go through at full speed until badger stalls them
+
+	ThrottleMaxDelay: 1 second - Throttled batches wait from a millisecond at WritePressureLevel up to this at full pressure
+
+	OnWritePressure: nil - Pressure changes are only logged and counted in Stats
+
+	DefaultOpTimeout: 0 - Calls wait for the disk however long it takes, the Context variants still honour their context
+
+	AdoptForeignDB: false - Another application's badger directory is refused rather than written into
+
+	SessionTTL: 24 hours - Sets of sessions not written to for a day are dropped by the next open or SweepSessions
+
+	ValueDecoder: nil - Values are read as stored
+
+	ValueEncoder: nil - Values are stored as given
+
+	WrapEngine: nil - Badger is called directly
+
+	DedupWindow: 0 - Every store is written, even of a hash stored a moment ago
+
+	DedupWindowTTL: 10 seconds - A hash stored again more than 10 seconds later is written
+
+	VerifyIndexesOnOpen: false - Indexes are only checked by VerifyIndexes or StartIndexCheck
+
+	VerifyIndexesSample: 0.01 - The check on open reads 1% of the records and entries, 1 checks all of them
+
+	RetentionInterval: 0 - Expired hashes stay until ApplyRetention or StartRetention runs
+
+On 32-bit platforms the smaller sizes of LowMemoryOptions apply instead
 */
 func DefaultOptions() *Options {
+	if lowMemoryPlatform {
+		return LowMemoryOptions()
+	}
+	return defaultOptions()
+}
+
+// defaultOptions returns the DefaultOptions of 64-bit platforms
+func defaultOptions() *Options {
 	return &Options{
 		ValueDir:                      "",
 		Compression:                   options.ZSTD,
@@ -131,12 +371,58 @@ func DefaultOptions() *Options {
 		ValueLogFileSize:              (2 << 30) - 1,
 		MemTableSize:                  512 << 20,
 		NumMemTables:                  10,
-		NumCompactors:                 runtime.NumCPU(),
+		NumCompactors:                 max(runtime.NumCPU(), 2), // badger refuses fewer
 		NumLevelZeroTables:            20,
 		NumLevelZeroTablesStall:       40,
 		BaseLevelSize:                 20 << 30,
 		MaxLevels:                     7,
 		BloomFalsePositive:            0.01,
 		Logger:                        DefaultLogger,
+		Expvar:                        false,
+		MaxHashSize:                   defaultMaxHashSize,
+		MaxValueSize:                  defaultMaxValueSize,
+		StrictValidation:              false,
+		NormalizeValuesNFC:            false,
+		Fallbacks:                     nil,
+		FallbackTimeout:               defaultFallbackTimeout,
+		FallbackWriteBack:             false,
+		FallbackTTL:                   0,
+		AutoMigrate:                   false,
+		CompressValueThreshold:        0,
+		TxRetries:                     defaultTxRetries,
+		RecountDirtyOnOpen:            true,
+		ValueIndex:                    false,
+		CounterShards:                 defaultCounterShards,
+		DetectConflicts:               true,
+		KeyPrefix:                     DefaultKeyPrefix,
+		CheckOnOpen:                   false,
+		KeySource:                     "",
+		WarmupOnOpen:                  false,
+		WarmupFraction:                defaultWarmupSample,
+		WarmupRate:                    defaultWarmupRate,
+		ChallengeKeying:               ChallengeKeyAccount,
+		ChallengeCaptures:             defaultChallengeCaptures,
+		VerifyCracks:                  false,
+		TicketValueThreshold:          defaultTicketValueThreshold,
+		IngestProgressInterval:        defaultIngestProgressInterval,
+		StrictOpen:                    false,
+		MaxOpenIterators:              defaultMaxOpenIterators,
+		MaxBatchBytes:                 defaultMaxBatchBytes,
+		ValueIndexFolded:              false,
+		WritePressureLevel:            defaultWritePressureLevel,
+		ThrottleWrites:                false,
+		ThrottleMaxDelay:              defaultThrottleMaxDelay,
+		OnWritePressure:               nil,
+		DefaultOpTimeout:              0,
+		AdoptForeignDB:                false,
+		SessionTTL:                    defaultSessionTTL,
+		ValueDecoder:                  nil,
+		ValueEncoder:                  nil,
+		WrapEngine:                    nil,
+		DedupWindow:                   0,
+		DedupWindowTTL:                defaultDedupWindowTTL,
+		VerifyIndexesOnOpen:           false,
+		VerifyIndexesSample:           defaultIndexCheckSample,
+		RetentionInterval:             0,
 	}
 }
@@ -0,0 +1,177 @@
+package kdb
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	insertionIndexPrefix = "insertion:%d:%016x:%s" // hash_type, sequence, hex sum
+	insertionScanPrefix  = "insertion:%d:"         // hash_type, used to scan the index of a type in insertion order
+	insertionSeqKey      = "insertion:seq"         // next insertion sequence number
+)
+
+// Order is the order the iteration APIs yield hashes in
+type Order int
+
+const (
+	SumAsc        Order = iota // Key order, ascending by sum. The default
+	SumDesc                    // Key order reversed
+	InsertionAsc               // Order the hashes were first stored in, oldest first. Storing a hash again keeps its place
+	InsertionDesc              // Newest first
+)
+
+// String returns the name of the order
+func (o Order) String() string {
+	switch o {
+	case SumAsc:
+		return "sum_asc"
+	case SumDesc:
+		return "sum_desc"
+	case InsertionAsc:
+		return "insertion_asc"
+	case InsertionDesc:
+		return "insertion_desc"
+	}
+	return fmt.Sprintf("order(%d)", int(o))
+}
+
+// insertion returns true for the orders that scan the insertion index
+func (o Order) insertion() bool {
+	return o == InsertionAsc || o == InsertionDesc
+}
+
+// reverse returns true for the descending orders
+func (o Order) reverse() bool {
+	return o == SumDesc || o == InsertionDesc
+}
+
+// insertionKey returns the key of the insertion index entry of a hash with sum, raw or hex encoded
+func (ks keyspace) insertionKey(hashType, seq uint64, sum []byte) []byte {
+	return []byte(ks.key(insertionIndexPrefix, hashType, seq, hexSum(sum)))
+}
+
+// readInsertionSeq returns the next insertion sequence number, sequences start at 1 so that 0
+// marks hashes without an index entry
+func (kc *KDB) readInsertionSeq(txn *badger.Txn) (uint64, error) {
+	seq := uint64(1)
+
+	item, err := txn.Get([]byte(kc.keys.key(insertionSeqKey)))
+	if err == nil {
+		err = item.Value(func(val []byte) error {
+			seq, err = strconv.ParseUint(string(val), 10, 64)
+			return err
+		})
+	}
+	if err != nil && !isNotFound(err) {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// insertionSeqValue encodes the next insertion sequence number for insertionSeqKey
+func insertionSeqValue(next uint64) []byte {
+	return []byte(strconv.FormatUint(next, 10))
+}
+
+// BackfillInsertionIndex indexes the hashes stored before the insertion index existed, so that
+// insertion ordered iteration includes them. They are placed after every indexed hash in key order,
+// their real insertion order wasn't recorded. Safe to run again, indexed hashes are skipped.
+// Returns the number of hashes indexed
+func (kc *KDB) BackfillInsertionIndex(ctx context.Context) (int, error) {
+	hashTypes, err := kc.getRegisteredHashTypes()
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to get registered hash types: %v", err), Error)
+		return 0, fmt.Errorf("failed to get registered hash types: %w", err)
+	}
+
+	indexed := 0
+	for _, hashType := range hashTypes {
+		n, err := kc.backfillTypeInsertions(ctx, hashType)
+		indexed += n
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to backfill insertion index of hash type %d: %v", hashType, err), Error)
+			return indexed, fmt.Errorf("failed to backfill insertion index of hash type %d: %w", hashType, err)
+		}
+	}
+
+	kc.log(fmt.Sprintf("Backfilled insertion index with %d hashes", indexed), Info)
+	return indexed, nil
+}
+
+// backfillTypeInsertions indexes the unindexed hashes of one hash type
+func (kc *KDB) backfillTypeInsertions(ctx context.Context, hashType uint64) (int, error) {
+	indexed := 0
+	prefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+
+	wb := kc.c.NewWriteBatch()
+	defer wb.Cancel()
+
+	// Held across the flush so no store takes sequences the batch hands out
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	counted := false // the counter is queued, so what's in the batch can be flushed
+	err := kc.c.View(func(txn *badger.Txn) error {
+		next, err := kc.readInsertionSeq(txn)
+		if err != nil {
+			return err
+		}
+
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if err := ctx.Err(); err != nil {
+				break
+			}
+
+			var hash Hash
+			if err := it.Item().Value(func(val []byte) error {
+				return kc.decodeRawHash(val, &hash)
+			}); err != nil || hash.seq != 0 {
+				continue
+			}
+
+			// Compressed as the current options say, like RecompressValues does
+			hash.seq = next
+			data, err := encodeHash(&hash)
+			if err != nil {
+				return err
+			}
+			if kc.opts != nil {
+				data, _ = compressRecord(data, kc.opts.CompressValueThreshold)
+			}
+			key := it.Item().KeyCopy(nil)
+			if err := wb.Set(key, data); err != nil {
+				return err
+			}
+			if err := wb.Set(kc.keys.insertionKey(hashType, next, key[len(prefix):]), nil); err != nil {
+				return err
+			}
+			next++
+			indexed++
+		}
+
+		// Hashes indexed before a cancellation still count, the counter covers them
+		if err := wb.Set([]byte(kc.keys.key(insertionSeqKey)), insertionSeqValue(next)); err != nil {
+			return err
+		}
+		counted = true
+		return ctx.Err()
+	})
+	if !counted {
+		return 0, err
+	}
+	if flushErr := wb.Flush(); flushErr != nil {
+		return 0, flushErr
+	}
+
+	return indexed, err
+}
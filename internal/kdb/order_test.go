@@ -0,0 +1,151 @@
+package kdb
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// orderFixture stores 60 hashes of one type out of sum order, stores one again and one after
+// deleting it, then makes five more look stored before the insertion index and backfills them.
+// Returns the hashes in insertion order
+func orderFixture(t *testing.T, db *KDB) []string {
+	t.Helper()
+	var inserted []string
+	for i := range 60 {
+		h := testHash(i * 37 % 60)
+		if _, err := db.StoreHash(NewHash(h, "", NTLM)); err != nil {
+			t.Fatalf("store %s: %v", h, err)
+		}
+		inserted = append(inserted, h)
+	}
+	// Storing again keeps the place, deleting first gives a new one
+	if _, err := db.StoreHash(NewHash(inserted[10], "again", NTLM)); err != nil {
+		t.Fatalf("store again: %v", err)
+	}
+	if err := db.DeleteHash(inserted[20], NTLM); err != nil {
+		t.Fatalf("DeleteHash: %v", err)
+	}
+	if _, err := db.StoreHash(NewHash(inserted[20], "", NTLM)); err != nil {
+		t.Fatalf("store after the delete: %v", err)
+	}
+	moved := inserted[20]
+	inserted = append(slices.Delete(inserted, 20, 21), moved)
+
+	// The unindexed go after every indexed hash, in sum order
+	var old []*Hash
+	for i := 60; i < 65; i++ {
+		h := NewHash(testHash(i), "", NTLM)
+		if _, err := db.StoreHash(h); err != nil {
+			t.Fatalf("store %d: %v", i, err)
+		}
+		stored, err := db.GetHashByOriginalHash(testHash(i), NTLM)
+		if err != nil {
+			t.Fatalf("lookup %d: %v", i, err)
+		}
+		old = append(old, stored)
+	}
+	err := db.c.Update(func(txn *badger.Txn) error {
+		for _, h := range old {
+			if err := txn.Delete(db.keys.insertionKey(NTLM, h.seq, h.sum)); err != nil {
+				return err
+			}
+			h.seq = 0
+			data, err := encodeHash(h)
+			if err != nil {
+				return err
+			}
+			if err := txn.Set(h.Key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unindexing: %v", err)
+	}
+	if n, err := db.BackfillInsertionIndex(context.Background()); err != nil || n != len(old) {
+		t.Fatalf("the backfill indexed %d of %d: %v", n, len(old), err)
+	}
+	slices.SortFunc(old, func(a, b *Hash) int { return strings.Compare(a.Sum(), b.Sum()) })
+	for _, h := range old {
+		inserted = append(inserted, h.Hash)
+	}
+	return inserted
+}
+
+// ordered returns every hash of the fixture scanned in order so, a page of size at a time if
+// size isn't 0
+func ordered(t *testing.T, db *KDB, order Order, size int) []string {
+	t.Helper()
+	var got []string
+	var after *Hash
+	for {
+		page := 0
+		for h := range db.GetHashesByHashType(NTLM, &ScanOptions{Order: order, Limit: size, After: after}) {
+			got = append(got, h.Hash)
+			after = h
+			page++
+		}
+		if size == 0 || page < size {
+			return got
+		}
+	}
+}
+
+// reversed returns a reversed copy of s
+func reversed(s []string) []string {
+	r := slices.Clone(s)
+	slices.Reverse(r)
+	return r
+}
+
+func TestOrdersAreStable(t *testing.T) {
+	dir := t.TempDir()
+	db := newTestDBIn(t, dir, testPrefix, nil)
+	inserted := orderFixture(t, db)
+
+	bySum := slices.Clone(inserted)
+	slices.SortFunc(bySum, func(a, b string) int { return strings.Compare(SumOf(a), SumOf(b)) })
+	want := map[Order][]string{
+		SumAsc:        bySum,
+		SumDesc:       reversed(bySum),
+		InsertionAsc:  inserted,
+		InsertionDesc: reversed(inserted),
+	}
+
+	check := func(what string) {
+		t.Helper()
+		for order, hashes := range want {
+			for _, size := range []int{0, 1, 7, len(hashes)} {
+				if got := ordered(t, db, order, size); !slices.Equal(got, hashes) {
+					t.Errorf("%s: %s in pages of %d yielded\n%v\nwant\n%v", what, order, size, got, hashes)
+				}
+			}
+
+			// Prefix searches and lookups keep the order of the hashes they match
+			prefix := SumOf(hashes[0])[:1]
+			var matched, found []string
+			for h := range db.SearchHashesByPrefix(prefix, NTLM, &ScanOptions{Order: order}) {
+				matched = append(matched, h.Hash)
+			}
+			for h := range db.FindHashes(hashes[:30], NTLM, &ScanOptions{Order: order}) {
+				found = append(found, h.Hash)
+			}
+			wantMatched := slices.DeleteFunc(slices.Clone(hashes), func(h string) bool { return !strings.HasPrefix(SumOf(h), prefix) })
+			wantFound := slices.DeleteFunc(slices.Clone(hashes), func(h string) bool { return !slices.Contains(hashes[:30], h) })
+			if !slices.Equal(matched, wantMatched) || !slices.Equal(found, wantFound) {
+				t.Errorf("%s: %s searched %v and found %v, want %v and %v", what, order, matched, found, wantMatched, wantFound)
+			}
+		}
+	}
+	check("first run")
+	check("second run")
+
+	db.Close()
+	db = newTestDBIn(t, dir, testPrefix, nil)
+	check("after a reopen")
+}
@@ -0,0 +1,134 @@
+package kdb
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/ristretto/v2"
+)
+
+// Engine is the part of the badger database the KDB calls, *badger.DB implements it.
+// Options.WrapEngine puts a wrapper in between, to instrument the calls or inject faults
+type Engine interface {
+	View(fn func(txn *badger.Txn) error) error
+	Update(fn func(txn *badger.Txn) error) error
+	NewTransaction(update bool) *badger.Txn
+	NewWriteBatch() *badger.WriteBatch
+	NewStream() *badger.Stream
+	RunValueLogGC(discardRatio float64) error
+	Flatten(workers int) error
+	Size() (lsm, vlog int64)
+	Tables() []badger.TableInfo
+	Levels() []badger.LevelInfo
+	MaxVersion() uint64
+	BlockCacheMetrics() *ristretto.Metrics
+	IndexCacheMetrics() *ristretto.Metrics
+	IsClosed() bool
+	Close() error
+}
+
+var _ Engine = (*badger.DB)(nil)
+
+// PanicError is what a panic inside the database, such as of badger on a corrupted table, turns
+// into instead of taking the process down. errors.Is(err, ErrInternalPanic) reports true for it,
+// as does errors.Is for the panic value if it is an error
+type PanicError struct {
+	Op    string // The call that panicked
+	Value any    // What was passed to panic
+	Stack []byte // The stack of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("internal panic during %s: %v", e.Op, e.Value)
+}
+
+func (e *PanicError) Unwrap() []error {
+	if err, ok := e.Value.(error); ok {
+		return []error{ErrInternalPanic, err}
+	}
+	return []error{ErrInternalPanic}
+}
+
+// recoverPanic turns a panic of the calling function into a *PanicError stored in err. It must be
+// deferred directly, recover only stops a panic there. Locks the function took with a deferred
+// unlock are released by then
+func (kc *KDB) recoverPanic(op string, err *error) {
+	if r := recover(); r != nil {
+		*err = kc.panicked(op, r)
+	}
+}
+
+// recoverBackground ends background work of the database that panicked, logging the panic. It
+// must be deferred directly, like recoverPanic
+func (kc *KDB) recoverBackground(op string) {
+	if r := recover(); r != nil {
+		_ = kc.panicked(op, r)
+	}
+}
+
+// panicked logs, counts and records the panic r of op and returns it as a *PanicError, with the
+// stack of the goroutine recovering it
+func (kc *KDB) panicked(op string, r any) error {
+	err := &PanicError{Op: op, Value: r, Stack: debug.Stack()}
+	kc.log(fmt.Sprintf("Recovered from a panic during %s: %v\n%s", op, r, err.Stack), Error)
+	kc.ops.panics.Add(1)
+	kc.recordError(err)
+	return err
+}
+
+// guardYield returns yield wrapped to tell the panics of the loop body from the database's, and
+// the recovery to defer in the iterator: a panic of the database ends the iteration as a failed
+// one, logged and recorded, while the loop body's runs on to the caller untouched
+func guardYield[T any](kc *KDB, op string, yield func(T) bool) (func(T) bool, func()) {
+	yielding := false
+	guarded := func(v T) bool {
+		yielding = true
+		more := yield(v)
+		yielding = false
+		return more
+	}
+	recovery := func() {
+		if yielding {
+			return
+		}
+		if r := recover(); r != nil {
+			_ = kc.panicked(op, r)
+		}
+	}
+	return guarded, recovery
+}
+
+// guardYield2 is guardYield for iter.Seq2
+func guardYield2[K, V any](kc *KDB, op string, yield func(K, V) bool) (func(K, V) bool, func()) {
+	yielding := false
+	guarded := func(k K, v V) bool {
+		yielding = true
+		more := yield(k, v)
+		yielding = false
+		return more
+	}
+	recovery := func() {
+		if yielding {
+			return
+		}
+		if r := recover(); r != nil {
+			_ = kc.panicked(op, r)
+		}
+	}
+	return guarded, recovery
+}
+
+// viewLocked runs a read-only transaction holding kc.mu, released even if fn panics
+func (kc *KDB) viewLocked(fn func(txn *badger.Txn) error) error {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	return kc.c.View(fn)
+}
+
+// updateLocked runs a read-write transaction holding kc.mu, released even if fn panics
+func (kc *KDB) updateLocked(fn func(txn *badger.Txn) error) error {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	return kc.c.Update(fn)
+}
@@ -0,0 +1,115 @@
+package kdb
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// panickingEngine panics in its next transaction once armed
+type panickingEngine struct {
+	Engine
+	armed atomic.Bool
+}
+
+func (e *panickingEngine) View(fn func(txn *badger.Txn) error) error {
+	if e.armed.CompareAndSwap(true, false) {
+		panic("corrupted table")
+	}
+	return e.Engine.View(fn)
+}
+
+func (e *panickingEngine) Update(fn func(txn *badger.Txn) error) error {
+	if e.armed.CompareAndSwap(true, false) {
+		panic("corrupted table")
+	}
+	return e.Engine.Update(fn)
+}
+
+func TestEnginePanicsAreRecovered(t *testing.T) {
+	engine := &panickingEngine{}
+	db := newTestDB(t, testPrefix, func(opts *Options) {
+		opts.WrapEngine = func(e Engine) Engine {
+			engine.Engine = e
+			return engine
+		}
+	})
+	if _, err := db.StoreHash(NewHash(testHash(0), "password", 0)); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	// unlocked fails the test if the panic left the database lock held
+	unlocked := func(what string) {
+		t.Helper()
+		if !db.mu.TryLock() {
+			t.Fatalf("%s left the lock held", what)
+		}
+		db.mu.Unlock()
+	}
+	isPanic := func(err error) bool {
+		var pe *PanicError
+		return errors.Is(err, ErrInternalPanic) && errors.As(err, &pe) && pe.Value == "corrupted table" && len(pe.Stack) > 0
+	}
+
+	engine.armed.Store(true)
+	if _, err := db.StoreHash(NewHash(testHash(1), "", 0)); !isPanic(err) {
+		t.Errorf("a panicking store returned %v", err)
+	}
+	unlocked("a store")
+
+	engine.armed.Store(true)
+	if _, err := db.StoreHashes([]*Hash{NewHash(testHash(2), "", 0)}); !isPanic(err) {
+		t.Errorf("a panicking batch returned %v", err)
+	}
+	unlocked("a batch")
+
+	engine.armed.Store(true)
+	if _, err := db.GetHashByOriginalHash(testHash(0), 0); !isPanic(err) {
+		t.Errorf("a panicking lookup returned %v", err)
+	}
+	unlocked("a lookup")
+
+	engine.armed.Store(true)
+	n := 0
+	for range db.GetHashesByHashType(0) {
+		n++
+	}
+	if n != 0 {
+		t.Errorf("a panicking iteration yielded %d", n)
+	}
+	unlocked("an iteration")
+
+	if panics := db.ops.panics.Load(); panics != 4 {
+		t.Errorf("%d panics counted, want 4", panics)
+	}
+	if h, err := db.GetHashByOriginalHash(testHash(0), 0); err != nil || h.Value != "password" {
+		t.Errorf("the lookup after the panics: %v", err)
+	}
+}
+
+func TestLoopBodyPanicsReachTheCaller(t *testing.T) {
+	db := newTestDB(t, testPrefix, nil)
+	if _, err := db.StoreHash(NewHash(testHash(0), "", 0)); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "loop body" {
+				t.Errorf("the loop body's panic came back as %v", r)
+			}
+		}()
+		for range db.GetHashesByHashType(0) {
+			panic("loop body")
+		}
+	}()
+	if db.ops.panics.Load() != 0 {
+		t.Errorf("the loop body's panic was counted as the database's")
+	}
+	if !db.mu.TryLock() {
+		t.Fatalf("the loop body's panic left the lock held")
+	}
+	db.mu.Unlock()
+}
@@ -0,0 +1,73 @@
+package kdb
+
+import (
+	"context"
+	"errors"
+	"runtime"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Result is the outcome of looking one input of Pipeline up
+type Result struct {
+	Input string // The hash as read from the input channel
+	Hash  *Hash  // The stored hash, nil if it isn't stored or the lookup failed
+	Err   error  // Why the lookup failed, nil for a hash that isn't stored
+}
+
+// Pipeline looks up every hash read from in under hashType with workers lookups at once, 0 uses
+// GOMAXPROCS, and sends their results on the returned channel in no particular order. Every input
+// read gets exactly one Result. The channel is closed once in is closed and drained or ctx is
+// done, and every input read has its result: inputs read but not looked up by then get ctx.Err().
+// Lookups are GetHashByOriginalHashContext, consulting the fallbacks on a miss. The caller must
+// drain the channel until it is closed
+func Pipeline(ctx context.Context, db *KDB, in <-chan string, hashType uint64, workers int) (<-chan *Result, error) {
+	if db == nil || db.Nil() || db.c.IsClosed() {
+		return nil, ErrNotInitialized
+	}
+	if in == nil {
+		return nil, errors.New("pipeline needs an input channel")
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	out := make(chan *Result, workers)
+	var g errgroup.Group
+	for range workers {
+		g.Go(func() error {
+			for {
+				var hash string
+				var ok bool
+				select {
+				case <-ctx.Done():
+					return nil
+				case hash, ok = <-in:
+					if !ok {
+						return nil
+					}
+				}
+				out <- db.pipelineLookup(ctx, hash, hashType)
+			}
+		})
+	}
+	go func() {
+		_ = g.Wait()
+		close(out)
+	}()
+	return out, nil
+}
+
+// pipelineLookup returns the Result of one input of Pipeline
+func (kc *KDB) pipelineLookup(ctx context.Context, hash string, hashType uint64) *Result {
+	r := &Result{Input: hash}
+	if err := ctx.Err(); err != nil {
+		r.Err = err
+		return r
+	}
+	r.Hash, r.Err = kc.GetHashByOriginalHashContext(ctx, hash, hashType)
+	if isNotFound(r.Err) {
+		r.Hash, r.Err = nil, nil
+	}
+	return r
+}
@@ -0,0 +1,126 @@
+package kdb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// stallingEngine holds every read back once stalling is set, until release is closed
+type stallingEngine struct {
+	Engine
+	stalling atomic.Bool
+	stalled  atomic.Int32
+	release  chan struct{}
+}
+
+func (e *stallingEngine) View(fn func(txn *badger.Txn) error) error {
+	if e.stalling.Load() {
+		e.stalled.Add(1)
+		<-e.release
+	}
+	return e.Engine.View(fn)
+}
+
+func TestPipelineCancelledMidStream(t *testing.T) {
+	engine := &stallingEngine{release: make(chan struct{})}
+	db := newTestDB(t, testPrefix, func(opts *Options) {
+		opts.WrapEngine = func(e Engine) Engine {
+			engine.Engine = e
+			return engine
+		}
+	})
+	for i := range 100 {
+		if _, err := db.StoreHash(NewHash(testHash(i), "", 0)); err != nil {
+			t.Fatalf("store %d: %v", i, err)
+		}
+	}
+
+	// The stalled read holds the database lock, it must end before the database is closed
+	release := sync.OnceFunc(func() { close(engine.release) })
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan string)
+	const workers = 4
+	out, err := Pipeline(ctx, db, in, 0, workers)
+	if err != nil {
+		t.Fatalf("Pipeline: %v", err)
+	}
+
+	// Feed inputs without end until cancelled, a send that went through was read by the pipeline
+	read := make(chan map[string]int, 1)
+	go func() {
+		sent := make(map[string]int)
+		for i := 0; ; i++ {
+			select {
+			case in <- testHash(i % 200):
+				sent[testHash(i%200)]++
+			case <-ctx.Done():
+				read <- sent
+				return
+			}
+		}
+	}()
+
+	// After 50 results lookups stall, the cancel comes once one is stuck and holds up the rest
+	results := make(map[string]int)
+	cancelled := 0
+	timeout := time.After(10 * time.Second)
+	tick := time.NewTicker(time.Millisecond)
+	defer tick.Stop()
+	for done := false; !done; {
+		select {
+		case <-tick.C:
+			if engine.stalled.Load() > 0 && ctx.Err() == nil {
+				cancel()
+			}
+		case r, ok := <-out:
+			if !ok {
+				done = true
+				break
+			}
+			results[r.Input]++
+			if errors.Is(r.Err, context.Canceled) {
+				cancelled++
+			} else if r.Err != nil {
+				t.Errorf("%s failed with %v", r.Input, r.Err)
+			}
+			if len(results) == 50 {
+				engine.stalling.Store(true)
+			}
+		case <-timeout:
+			t.Fatalf("the results weren't closed after the cancel")
+		}
+	}
+
+	release()
+
+	sent := <-read
+	for input, n := range sent {
+		if results[input] != n {
+			t.Errorf("%s was read %d times and had %d results", input, n, results[input])
+		}
+	}
+	for input := range results {
+		if sent[input] == 0 {
+			t.Errorf("%s had a result but was never read", input)
+		}
+	}
+	if cancelled == 0 {
+		t.Errorf("no lookup was cut short by the cancel")
+	}
+
+	// The workers are gone, nothing reads the input anymore
+	select {
+	case in <- testHash(0):
+		t.Errorf("the pipeline read an input after it shut down")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
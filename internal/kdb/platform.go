@@ -0,0 +1,46 @@
+package kdb
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+)
+
+// lowMemoryPlatform is true where the address space is too small for the mmaps the default
+// options ask badger for, 32-bit platforms such as the Raspberry Pi OS builds for arm
+const lowMemoryPlatform = strconv.IntSize == 32
+
+// addressBudget32 is how much of a 32-bit address space the value log file, the memtables and the
+// index cache may take together. The rest is left to the heap, the stacks and badger's tables
+const addressBudget32 = 1 << 30
+
+// LowMemoryOptions returns options for small machines and 32-bit platforms: 64MB value log files,
+// two 16MB memtables, a 64MB index cache and two compactors. DefaultOptions returns these on
+// 32-bit platforms
+func LowMemoryOptions() *Options {
+	opts := defaultOptions()
+	opts.IndexCacheSize = 64 << 20
+	opts.ValueLogFileSize = 64 << 20
+	opts.MemTableSize = 16 << 20
+	opts.NumMemTables = 2
+	opts.NumCompactors = 2 // badger refuses fewer
+	opts.NumLevelZeroTables = 5
+	opts.NumLevelZeroTablesStall = 10
+	opts.BaseLevelSize = 256 << 20
+	return opts
+}
+
+// checkPlatform returns ErrUnsupportedOptions if opts map more than a 32-bit address space can
+// hold, where badger would otherwise fail with an mmap error or panic while opening
+func checkPlatform(opts *Options) error {
+	if !lowMemoryPlatform {
+		return nil
+	}
+
+	mapped := opts.ValueLogFileSize + opts.MemTableSize*int64(opts.NumMemTables) + opts.IndexCacheSize
+	if mapped > addressBudget32 {
+		return fmt.Errorf("%w: value log file, memtables and index cache take %d MB, %s/%s allows %d MB, start from LowMemoryOptions",
+			ErrUnsupportedOptions, mapped>>20, runtime.GOOS, runtime.GOARCH, addressBudget32>>20)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package kdb
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPlatformDefaults(t *testing.T) {
+	low, regular := LowMemoryOptions(), defaultOptions()
+	want := regular
+	if lowMemoryPlatform {
+		want = low
+	}
+	if got := DefaultOptions(); got.ValueLogFileSize != want.ValueLogFileSize || got.MemTableSize != want.MemTableSize ||
+		got.NumMemTables != want.NumMemTables || got.IndexCacheSize != want.IndexCacheSize {
+		t.Errorf("DefaultOptions on %s returned %+v", runtime.GOARCH, got)
+	}
+
+	// Whatever the platform, the low memory options fit a 32-bit address space
+	if mapped := low.ValueLogFileSize + low.MemTableSize*int64(low.NumMemTables) + low.IndexCacheSize; mapped > addressBudget32 {
+		t.Errorf("LowMemoryOptions map %d MB", mapped>>20)
+	}
+	if err := checkPlatform(low); err != nil {
+		t.Errorf("the low memory options were refused: %v", err)
+	}
+
+	err := checkPlatform(regular)
+	if !lowMemoryPlatform {
+		if err != nil {
+			t.Errorf("the regular options were refused on %s: %v", runtime.GOARCH, err)
+		}
+		return
+	}
+	if !errors.Is(err, ErrUnsupportedOptions) || !strings.Contains(err.Error(), runtime.GOARCH) {
+		t.Errorf("the regular options on %s returned %v", runtime.GOARCH, err)
+	}
+	regularSizes := func(opts *Options) {
+		opts.ValueLogFileSize, opts.MemTableSize = regular.ValueLogFileSize, regular.MemTableSize
+		opts.NumMemTables, opts.IndexCacheSize = regular.NumMemTables, regular.IndexCacheSize
+	}
+	if _, err := openTestDB(t, t.TempDir(), testPrefix, regularSizes); !errors.Is(err, ErrUnsupportedOptions) {
+		t.Errorf("opening with the regular options on %s returned %v", runtime.GOARCH, err)
+	}
+}
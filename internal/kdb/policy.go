@@ -0,0 +1,179 @@
+package kdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+)
+
+// ValueScore describes the strength of a cracked value, see ScoreValue
+type ValueScore = util.ValueScore
+
+// CharClass is a set of character classes
+type CharClass = util.CharClass
+
+// Dictionary is a set of words values are checked against
+type Dictionary = util.Dictionary
+
+const (
+	ClassLower  = util.ClassLower
+	ClassUpper  = util.ClassUpper
+	ClassDigit  = util.ClassDigit
+	ClassSymbol = util.ClassSymbol
+	ClassOther  = util.ClassOther
+)
+
+// ScoreValue scores a value against the optional dictionary, the embedded list of common
+// password words if none is given. See util.ScoreValue
+func ScoreValue(value string, dict ...Dictionary) ValueScore {
+	return util.ScoreValue(value, dict...)
+}
+
+// NewDictionary builds a dictionary from words, case is ignored
+func NewDictionary(words []string) Dictionary {
+	return util.NewDictionary(words)
+}
+
+// PolicyReason is why a value fails a Policy
+type PolicyReason string
+
+const (
+	ReasonTooShort        PolicyReason = "too_short"        // Shorter than MinLength
+	ReasonTooFewClasses   PolicyReason = "too_few_classes"  // Fewer than MinClasses character classes
+	ReasonMissingClass    PolicyReason = "missing_class"    // Lacks one of RequiredClasses
+	ReasonBannedSubstring PolicyReason = "banned_substring" // Contains one of BannedSubstrings
+	ReasonDictionaryWord  PolicyReason = "dictionary_word"  // Built on a dictionary word
+	ReasonLowEntropy      PolicyReason = "low_entropy"      // Estimated below MinEntropy bits
+)
+
+// Policy is a password policy cracked values are checked against. Zero fields don't apply, so
+// Policy{MinLength: 12, MinClasses: 3} is a corporate "12 characters, 3 classes" rule
+type Policy struct {
+	MinLength        int        `json:"min_length,omitempty"`        // Characters, not bytes
+	MinClasses       int        `json:"min_classes,omitempty"`       // Distinct character classes
+	RequiredClasses  CharClass  `json:"required_classes,omitempty"`  // Classes that must all be present
+	BannedSubstrings []string   `json:"banned_substrings,omitempty"` // Such as the company name, case is ignored
+	NoDictionaryWord bool       `json:"no_dictionary_word,omitempty"`
+	Dictionary       Dictionary `json:"-"`                     // Checked with NoDictionaryWord, the embedded list if nil
+	MinEntropy       float64    `json:"min_entropy,omitempty"` // Estimated bits
+}
+
+// Check scores value and returns why it fails the policy, nothing if it passes
+func (p Policy) Check(value string) (ValueScore, []PolicyReason) {
+	score := ScoreValue(value, p.Dictionary)
+
+	var reasons []PolicyReason
+	if score.Length < p.MinLength {
+		reasons = append(reasons, ReasonTooShort)
+	}
+	if score.Classes.Count() < p.MinClasses {
+		reasons = append(reasons, ReasonTooFewClasses)
+	}
+	if score.Classes&p.RequiredClasses != p.RequiredClasses {
+		reasons = append(reasons, ReasonMissingClass)
+	}
+	lower := strings.ToLower(value)
+	for _, banned := range p.BannedSubstrings {
+		if banned != "" && strings.Contains(lower, strings.ToLower(banned)) {
+			reasons = append(reasons, ReasonBannedSubstring)
+			break
+		}
+	}
+	if p.NoDictionaryWord && score.DictionaryWord {
+		reasons = append(reasons, ReasonDictionaryWord)
+	}
+	if score.Entropy < p.MinEntropy {
+		reasons = append(reasons, ReasonLowEntropy)
+	}
+	return score, reasons
+}
+
+// PolicyCounts is how many cracked values passed and failed a policy. A value failing for several
+// reasons counts once in Failed and once for each reason
+type PolicyCounts struct {
+	Checked        int                  `json:"checked"` // Cracked values checked
+	Passed         int                  `json:"passed"`
+	Failed         int                  `json:"failed"`
+	PercentFailed  float64              `json:"percent_failed"`
+	Reasons        map[PolicyReason]int `json:"reasons"`
+	AverageLength  float64              `json:"average_length"`
+	AverageEntropy float64              `json:"average_entropy"`
+
+	lengths, entropy float64 // sums for the averages
+}
+
+// add counts one checked value
+func (pc *PolicyCounts) add(score ValueScore, reasons []PolicyReason) {
+	pc.Checked++
+	pc.lengths += float64(score.Length)
+	pc.entropy += score.Entropy
+	if len(reasons) == 0 {
+		pc.Passed++
+		return
+	}
+	pc.Failed++
+	for _, reason := range reasons {
+		pc.Reasons[reason]++
+	}
+}
+
+// finish computes the percentage and the averages
+func (pc *PolicyCounts) finish() {
+	if pc.Checked == 0 {
+		return
+	}
+	pc.PercentFailed = float64(pc.Failed) / float64(pc.Checked) * 100
+	pc.AverageLength = pc.lengths / float64(pc.Checked)
+	pc.AverageEntropy = pc.entropy / float64(pc.Checked)
+}
+
+// PolicyReport is how the cracked values of one or every hash type fare against a policy
+type PolicyReport struct {
+	Policy Policy `json:"policy"`
+	PolicyCounts
+	HashTypes map[uint64]*PolicyCounts `json:"hash_types"`
+}
+
+// PolicyReport checks every cracked value of hashType, or of every registered type if it is nil,
+// against policy. Values are streamed and only counts are kept, so memory doesn't grow with the
+// database. Every hash counts, a password shared by several accounts counts for each of them
+func (kc *KDB) PolicyReport(ctx context.Context, hashType *uint64, policy Policy) (*PolicyReport, error) {
+	var hashTypes []uint64
+	if hashType != nil {
+		hashTypes = []uint64{*hashType}
+	} else {
+		var err error
+		if hashTypes, err = kc.getRegisteredHashTypes(); err != nil {
+			kc.log(fmt.Sprintf("Failed to get registered hash types: %v", err), Error)
+			return nil, fmt.Errorf("failed to get registered hash types: %w", err)
+		}
+	}
+
+	report := &PolicyReport{
+		Policy:       policy,
+		PolicyCounts: PolicyCounts{Reasons: make(map[PolicyReason]int)},
+		HashTypes:    make(map[uint64]*PolicyCounts),
+	}
+	for _, ht := range hashTypes {
+		counts := &PolicyCounts{Reasons: make(map[PolicyReason]int)}
+		for hash := range kc.GetHashesByHashType(ht) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if hash.Value == "" {
+				continue
+			}
+			score, reasons := policy.Check(hash.Value)
+			counts.add(score, reasons)
+			report.add(score, reasons)
+		}
+		counts.finish()
+		report.HashTypes[ht] = counts
+	}
+	report.finish()
+
+	kc.log(fmt.Sprintf("Checked %d cracked values against the policy, %d failed", report.Checked, report.Failed), Info)
+	return report, nil
+}
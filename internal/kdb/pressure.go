@@ -0,0 +1,165 @@
+package kdb
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultWritePressureLevel = 0.75             // pressure from which the database counts as pressured
+	defaultThrottleMaxDelay   = time.Second      // longest StoreHashes waits at full pressure
+	pressureInterval          = time.Second      // how often the pressure monitor reads the LSM tree
+	pressureHysteresis        = 0.1              // how far below the level pressure drops before the pressured state ends
+	pendingCompactionPressure = 0.1              // pressure each level due for compaction below L0 adds
+	minThrottleDelay          = time.Millisecond // shorter waits aren't worth a sleep
+)
+
+// writePressure tracks the pressure the monitor last read and the pressured state, see
+// KDB.WritePressure
+type writePressure struct {
+	bits      atomic.Uint64 // math.Float64bits of the last pressure read
+	pressured atomic.Bool
+	episodes  atomic.Uint64 // times the database became pressured
+	throttled atomic.Uint64 // StoreHashes batches that waited
+	waited    atomic.Int64  // nanoseconds StoreHashes batches waited in total
+}
+
+// PressureStats reports write pressure and what throttling it cost
+type PressureStats struct {
+	Pressure  float64       `json:"pressure"`  // As last read by the monitor, see KDB.WritePressure
+	Pressured bool          `json:"pressured"` // At or above Options.WritePressureLevel
+	Episodes  uint64        `json:"episodes"`  // Times the database became pressured since it was opened
+	Throttled uint64        `json:"throttled"` // StoreHashes batches held back by Options.ThrottleWrites
+	Waited    time.Duration `json:"waited"`    // Time those batches waited in total
+}
+
+// snapshot returns the current figures
+func (wp *writePressure) snapshot() PressureStats {
+	return PressureStats{
+		Pressure:  wp.load(),
+		Pressured: wp.pressured.Load(),
+		Episodes:  wp.episodes.Load(),
+		Throttled: wp.throttled.Load(),
+		Waited:    time.Duration(wp.waited.Load()),
+	}
+}
+
+// load returns the pressure the monitor last read
+func (wp *writePressure) load() float64 {
+	return math.Float64frombits(wp.bits.Load())
+}
+
+// lsmPressure derives write pressure from the LSM tree: 0 while L0 is at or below the compaction
+// threshold, rising to 1 as it fills up to the stall threshold, plus a little for every lower level
+// due for compaction. Stalled writes are 1
+func lsmPressure(info LSMInfo) float64 {
+	if info.Stalled {
+		return 1
+	}
+	var p float64
+	if span := info.L0StallAt - info.L0CompactAt; span > 0 && info.L0Tables > info.L0CompactAt {
+		p = float64(info.L0Tables-info.L0CompactAt) / float64(span)
+	}
+	for _, l := range info.Levels {
+		if l.Level > 0 && l.Score >= 1 {
+			p += pendingCompactionPressure
+		}
+	}
+	return min(p, 1)
+}
+
+// WritePressure returns how close writes are to stalling, from 0 for an LSM tree compaction keeps
+// up with to 1 for writes stalled until L0 is compacted. It rises as L0 tables pile up past
+// Options.NumLevelZeroTables towards Options.NumLevelZeroTablesStall and as lower levels wait for
+// compaction, so producers can slow down before badger stops them. Read from badger's in-memory
+// table metadata
+func (kc *KDB) WritePressure() float64 {
+	info, err := kc.LSMInfo()
+	if err != nil {
+		return 0
+	}
+	return info.Pressure
+}
+
+// pressureLevel returns Options.WritePressureLevel or its default
+func (kc *KDB) pressureLevel() float64 {
+	if kc.opts != nil && kc.opts.WritePressureLevel > 0 {
+		return kc.opts.WritePressureLevel
+	}
+	return defaultWritePressureLevel
+}
+
+// runPressureMonitor reads write pressure every pressureInterval until the database is closed,
+// logging and calling Options.OnWritePressure as the database becomes pressured and recovers
+func (kc *KDB) runPressureMonitor() {
+	defer kc.recoverBackground("write pressure monitor")
+	kc.readPressure()
+	ticker := time.NewTicker(pressureInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-kc.stop:
+			return
+		case <-ticker.C:
+			kc.readPressure()
+		}
+	}
+}
+
+// readPressure reads write pressure once and updates the pressured state
+func (kc *KDB) readPressure() {
+	info, err := kc.LSMInfo()
+	if err != nil {
+		return
+	}
+	p := info.Pressure
+	kc.pressure.bits.Store(math.Float64bits(p))
+
+	level := kc.pressureLevel()
+	wp := &kc.pressure
+	switch {
+	case !wp.pressured.Load() && p >= level:
+		wp.pressured.Store(true)
+		wp.episodes.Add(1)
+		kc.log(fmt.Sprintf("Write pressure %.2f reached %.2f, compaction is falling behind: %s", p, level, info), Warning)
+	case wp.pressured.Load() && p < level-pressureHysteresis:
+		wp.pressured.Store(false)
+		kc.log(fmt.Sprintf("Write pressure down to %.2f, compaction caught up: %s", p, info), Info)
+	default:
+		return
+	}
+	if kc.opts != nil && kc.opts.OnWritePressure != nil {
+		kc.opts.OnWritePressure(wp.pressured.Load(), p)
+	}
+}
+
+// throttleWrites holds a StoreHashes batch back while the database is pressured and
+// Options.ThrottleWrites is set, longer the closer pressure is to 1
+func (kc *KDB) throttleWrites() {
+	if kc.opts == nil || !kc.opts.ThrottleWrites || !kc.pressure.pressured.Load() {
+		return
+	}
+	maxDelay := kc.opts.ThrottleMaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultThrottleMaxDelay
+	}
+
+	level := kc.pressureLevel()
+	share := 1.0
+	if level < 1 {
+		share = min(max((kc.pressure.load()-level)/(1-level), 0), 1)
+	}
+	delay := max(time.Duration(share*float64(maxDelay)), minThrottleDelay)
+
+	kc.pressure.throttled.Add(1)
+	kc.pressure.waited.Add(int64(delay))
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-kc.stop:
+	}
+}
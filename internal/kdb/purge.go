@@ -0,0 +1,310 @@
+package kdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	purgeChunkSize   = 1000     // Keys deleted per transaction during a purge
+	purgeAuditPrefix = "purge:" // Meta key prefix of the purge audit records
+)
+
+// ValueMatch selects how a ValueQuery compares stored values
+type ValueMatch int
+
+const (
+	ValueExact    ValueMatch = iota // The value equals ValueQuery.Value
+	ValueContains                   // The value contains ValueQuery.Value
+	ValueRegex                      // The value matches the regular expression ValueQuery.Value
+)
+
+func (m ValueMatch) String() string {
+	switch m {
+	case ValueExact:
+		return "exact"
+	case ValueContains:
+		return "contains"
+	case ValueRegex:
+		return "regex"
+	}
+	return fmt.Sprintf("ValueMatch(%d)", int(m))
+}
+
+// ValueQuery selects hashes by their cracked value
+type ValueQuery struct {
+	Match     ValueMatch // How Value is compared
+	Value     string     // The value, substring or regular expression to look for
+	HashTypes []uint64   // Hash types to scan, every registered type if empty
+	DryRun    bool       // Count matches without deleting them
+
+	// CaseInsensitive compares Unicode case folded values, regular expressions get the (?i) flag
+	CaseInsensitive bool
+}
+
+// matcher compiles the query into a predicate on values. normalize is applied to the query value
+// first, so it compares like the stored values, see KDB.normalizeValue
+func (q ValueQuery) matcher(normalize func(string) string) (func(string) bool, error) {
+	fold := func(s string) string { return s }
+	if q.CaseInsensitive {
+		fold = foldValue
+	}
+
+	switch q.Match {
+	case ValueExact:
+		value := fold(normalize(q.Value))
+		return func(v string) bool { return fold(v) == value }, nil
+	case ValueContains:
+		value := fold(normalize(q.Value))
+		return func(v string) bool { return strings.Contains(fold(v), value) }, nil
+	case ValueRegex:
+		expr := normalize(q.Value)
+		if q.CaseInsensitive {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value regex: %w", err)
+		}
+		return re.MatchString, nil
+	}
+	return nil, fmt.Errorf("unknown value match %d", int(q.Match))
+}
+
+// PurgeRecord is the audit record written for every purge run
+type PurgeRecord struct {
+	Match           string    `json:"match"`
+	Query           string    `json:"query"`
+	HashTypes       []uint64  `json:"hash_types,omitempty"`
+	Purged          int       `json:"purged"`
+	Skipped         int       `json:"skipped,omitempty"` // Records that failed to decode, they weren't checked and may still match
+	DryRun          bool      `json:"dry_run"`
+	CaseInsensitive bool      `json:"case_insensitive,omitempty"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+// PurgeByValue deletes every hash whose cracked value matches q and updates the counters.
+// Matches are deleted in chunks of purgeChunkSize keys per transaction and every run, dry or not,
+// leaves a PurgeRecord in the meta store, see PurgeHistory. Uncracked hashes never match.
+//
+// Deleting writes tombstones, the old values stay on disk until compaction drops them.
+// With Options.NumVersionsToKeep above 1 the purge forces a compaction of the LSM tree so older
+// versions are discarded too. Versions still in memtables are only dropped once those are flushed
+// and compacted, close and reopen the database to be certain. Records that fail to decode can't
+// be checked, they are kept, logged and counted in PurgeRecord.Skipped. Returns the number of
+// hashes purged, or the number that would be purged for a dry run
+func (kc *KDB) PurgeByValue(ctx context.Context, q ValueQuery) (int, error) {
+	match, err := q.matcher(kc.normalizeValue)
+	if err != nil {
+		return 0, err
+	}
+
+	hashTypes := q.HashTypes
+	if len(hashTypes) == 0 {
+		hashTypes, err = kc.getRegisteredHashTypes()
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to get registered hash types: %v", err), Error)
+			return 0, fmt.Errorf("failed to get registered hash types: %w", err)
+		}
+	}
+
+	purged, skipped := 0, 0
+	for _, hashType := range hashTypes {
+		keys, undecodable, err := kc.matchValues(ctx, hashType, match)
+		skipped += undecodable
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to scan hash type %d: %v", hashType, err), Error)
+			return purged, fmt.Errorf("failed to scan hash type %d: %w", hashType, err)
+		}
+
+		if q.DryRun {
+			purged += len(keys)
+			continue
+		}
+
+		n, err := kc.deleteKeys(ctx, hashType, keys)
+		purged += n
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to purge hash type %d: %v", hashType, err), Error)
+			return purged, fmt.Errorf("failed to purge hash type %d: %w", hashType, err)
+		}
+	}
+
+	if !q.DryRun && purged > 0 && kc.opts != nil && kc.opts.NumVersionsToKeep > 1 {
+		if err := kc.c.Flatten(1); err != nil {
+			kc.log(fmt.Sprintf("Failed to compact purged versions: %v", err), Warning)
+		}
+	}
+
+	record := PurgeRecord{
+		Match:           q.Match.String(),
+		Query:           q.Value,
+		HashTypes:       q.HashTypes,
+		Purged:          purged,
+		Skipped:         skipped,
+		DryRun:          q.DryRun,
+		CaseInsensitive: q.CaseInsensitive,
+		Timestamp:       time.Now().UTC(),
+	}
+	if err := kc.writePurgeRecord(record); err != nil {
+		kc.log(fmt.Sprintf("Failed to write purge audit record: %v", err), Error)
+		return purged, fmt.Errorf("failed to write purge audit record: %w", err)
+	}
+
+	kc.log(fmt.Sprintf("Purged %d hashes matching %s value query, %d undecodable skipped (dry run: %v)", purged, record.Match, skipped, q.DryRun), Info)
+	return purged, nil
+}
+
+// matchValues returns the keys of the hashes of hashType whose value matches and how many
+// records failed to decode, which are logged and counted but can't be matched
+func (kc *KDB) matchValues(ctx context.Context, hashType uint64, match func(string) bool) ([][]byte, int, error) {
+	var keys [][]byte
+	skipped := 0
+	prefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	err := kc.c.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var hash Hash
+			err := it.Item().Value(func(val []byte) error {
+				return kc.decodeHash(val, &hash)
+			})
+			if err != nil {
+				kc.ops.undecodable.Add(1)
+				skipped++
+				continue
+			}
+			if hash.Value == "" || !match(hash.Value) {
+				continue
+			}
+
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+
+	kc.reportSkipped(hashType, skipped)
+	return keys, skipped, err
+}
+
+// deleteKeys deletes hashes of hashType in chunked transactions with their index entries and
+// decrements the counters by the number deleted, also when a later chunk fails
+func (kc *KDB) deleteKeys(ctx context.Context, hashType uint64, keys [][]byte) (int, error) {
+	return kc.deleteKeysWhere(ctx, hashType, keys, nil)
+}
+
+// deleteKeysWhere is deleteKeys for the records match still selects when their chunk is deleted,
+// so records rewritten since the keys were collected are kept. A nil match deletes every key
+func (kc *KDB) deleteKeysWhere(ctx context.Context, hashType uint64, keys [][]byte, match func(stored *Hash) bool) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	if err := kc.markDirty(hashType); err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	var err error
+	for start := 0; start < len(keys) && err == nil; start += purgeChunkSize {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+
+		chunk := keys[start:min(start+purgeChunkSize, len(keys))]
+
+		n := 0
+		err = kc.updateLocked(func(txn *badger.Txn) error {
+			n = 0
+			for _, key := range chunk {
+				stored, err := kc.storedRecord(txn, key)
+				if err != nil {
+					return err
+				}
+				if match != nil && (stored == nil || !match(stored)) {
+					continue
+				}
+				if stored != nil {
+					if err := kc.unindexHash(txn, stored); err != nil {
+						return err
+					}
+				}
+				kc.dedup.forget(key)
+				if err := kc.writeTombstone(txn, hashType, key); err != nil {
+					return err
+				}
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+
+		if err == nil {
+			deleted += n
+		}
+	}
+
+	if deleted > 0 {
+		if err := kc.updateTypeCount(hashType, -deleted); err != nil {
+			kc.log(fmt.Sprintf("failed to update hash type count: %v", err), Error)
+		}
+		if err := kc.updateShardedCount(kc.keys.key(totalHashesKey), -deleted); err != nil {
+			kc.log(fmt.Sprintf("failed to update total hash count: %v", err), Error)
+		}
+	}
+
+	return deleted, err
+}
+
+// writePurgeRecord stores a purge audit record keyed by its timestamp
+func (kc *KDB) writePurgeRecord(record PurgeRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return kc.SetMeta(fmt.Sprintf("%s%020d", purgeAuditPrefix, record.Timestamp.UnixNano()), data)
+}
+
+// PurgeHistory returns the audit records of every purge run, oldest first
+func (kc *KDB) PurgeHistory() ([]PurgeRecord, error) {
+	entries, err := kc.listMeta(purgeAuditPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read purge audit records: %w", err)
+	}
+
+	records := make([]PurgeRecord, 0, len(entries))
+	for key, data := range entries {
+		var record PurgeRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			kc.log(fmt.Sprintf("Skipping invalid purge audit record '%s': %v", key, err), Warning)
+			continue
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Timestamp.Before(records[j].Timestamp)
+	})
+
+	return records, nil
+}
@@ -0,0 +1,59 @@
+package kdb
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+func TestPurgeNormalizesTheQuery(t *testing.T) {
+	const nfc, nfd = "caf\u00e9", "cafe\u0301"
+	queries := []ValueQuery{
+		{Match: ValueExact, Value: nfd},
+		{Match: ValueContains, Value: "fe\u0301"},
+		{Match: ValueExact, Value: strings.ToUpper(nfd), CaseInsensitive: true},
+		{Match: ValueRegex, Value: "^" + nfd + "$"},
+	}
+	for _, q := range queries {
+		db := newTestDB(t, testPrefix, func(opts *Options) { opts.NormalizeValuesNFC = true })
+		if _, err := db.StoreHash(NewHash(testHash(0), nfc, 0)); err != nil {
+			t.Fatalf("store: %v", err)
+		}
+		purged, err := db.PurgeByValue(context.Background(), q)
+		if err != nil || purged != 1 {
+			t.Errorf("%s %q purged %d: %v", q.Match, q.Value, purged, err)
+		}
+		if _, err := db.GetHashByOriginalHash(testHash(0), 0); err == nil {
+			t.Errorf("%s %q left the value stored", q.Match, q.Value)
+		}
+	}
+}
+
+func TestPurgeCountsUndecodableRecords(t *testing.T) {
+	db := newTestDB(t, testPrefix, nil)
+	for i := range 3 {
+		if _, err := db.StoreHash(NewHash(testHash(i), "secret", 0)); err != nil {
+			t.Fatalf("store %d: %v", i, err)
+		}
+	}
+	garbage, err := db.GetHashByOriginalHash(testHash(1), 0)
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+	if err := db.c.Update(func(txn *badger.Txn) error {
+		return txn.Set(garbage.Key, []byte("\xffnot a record"))
+	}); err != nil {
+		t.Fatalf("planting garbage: %v", err)
+	}
+
+	purged, err := db.PurgeByValue(context.Background(), ValueQuery{Match: ValueExact, Value: "secret"})
+	if err != nil || purged != 2 {
+		t.Fatalf("purged %d: %v", purged, err)
+	}
+	history, err := db.PurgeHistory()
+	if err != nil || len(history) != 1 || history[0].Skipped != 1 {
+		t.Errorf("the audit record %+v: %v", history, err)
+	}
+}
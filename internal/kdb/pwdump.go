@@ -0,0 +1,294 @@
+package kdb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Hashcat modes of the hashes in a pwdump line
+const (
+	NTLM = 1000
+	LM   = 3000
+)
+
+// SiblingMetaKey is the meta entry linking the LM and NT hash of one account, holding the type
+// and sum of the other one as type:sum
+const SiblingMetaKey = "sibling"
+
+const (
+	emptyLMHash   = "aad3b435b51404eeaad3b435b51404ee" // LM hash of accounts without one, the LM hash of ""
+	emptyLMHalf   = "aad3b435b51404ee"
+	emptyNTHash   = "31d6cfe0d16ae931b73c59d7e0c089c0"
+	maxLMPassword = 14 // Longer passwords have no LM hash
+)
+
+// lmPromotionSource is the source of NT hashes cracked by PromoteLMCracks
+var lmPromotionSource = &Source{Tool: "krkndb", Rule: "lm-case-permutations"}
+
+// ImportPwdump imports pwdump lines, user:rid:lm:nt::: as written by pwdump, secretsdump and
+// mimikatz, storing the NT hash and the LM hash of every account without a value. Both get the
+// account in their user meta entry, its RID in RIDMetaKey and the other one in SiblingMetaKey,
+// see PromoteLMCracks and ExportPwdump. The two halves of the LM hash are stored as hashes of
+// their own linked to it by LMFullMetaKey, see GetLMHalves. Empty LM hashes and halves aren't
+// stored, and neither is the NT hash of an empty password. Hashes already stored keep their value
+// and their other meta entries
+func (kc *KDB) ImportPwdump(r io.Reader) (ImportReport, error) {
+	return kc.ingest(context.Background(), kc.newImporter(), r, FormatPwdump, 0, nil)
+}
+
+// pwdumpLine queues the NT and LM hash of one pwdump line
+func (im *importer) pwdumpLine(line int, text string) error {
+	fields := strings.Split(strings.TrimSpace(text), ":")
+	if len(fields) < 4 || fields[0] == "" {
+		im.invalid(line, fmt.Errorf("expected user:rid:lm:nt"))
+		return nil
+	}
+	lm, nt := normalizeHash(fields[2]), normalizeHash(fields[3])
+	if len(lm) != 32 || !isHex(lm) || len(nt) != 32 || !isHex(nt) {
+		im.invalid(line, fmt.Errorf("%w: LM and NT hashes are 16 bytes of hex", ErrInvalidHash))
+		return nil
+	}
+
+	var pair []*Hash
+	if nt != emptyNTHash {
+		pair = append(pair, im.kc.NewHash(nt, "", NTLM))
+	}
+	if lm != emptyLMHash {
+		pair = append(pair, im.kc.NewHash(lm, "", LM))
+	}
+	for _, sh := range pair {
+		sh.Meta = map[string]string{UserMetaKey: fields[0], RIDMetaKey: fields[1]}
+	}
+	if len(pair) == 2 {
+		pair[0].Meta[SiblingMetaKey] = siblingRef(pair[1])
+		pair[1].Meta[SiblingMetaKey] = siblingRef(pair[0])
+	}
+	if lm != emptyLMHash {
+		pair = append(pair, im.kc.lmHalves(pair[len(pair)-1])...)
+	}
+
+	for _, sh := range pair {
+		if err := im.add(line, sh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// siblingRef returns the SiblingMetaKey entry pointing at sh
+func siblingRef(sh *Hash) string {
+	return fmt.Sprintf("%d:%s", sh.HashType, sh.Sum())
+}
+
+// parseSiblingRef returns the key of the hash a SiblingMetaKey entry points at, false if it
+// doesn't point at a hash of hashType
+func (kc *KDB) parseSiblingRef(ref string, hashType uint64) ([]byte, bool) {
+	prefix := fmt.Sprintf("%d:", hashType)
+	sum, ok := strings.CutPrefix(ref, prefix)
+	if !ok || sum == "" {
+		return nil, false
+	}
+	return kc.keys.hashKey(hashType, sum), true
+}
+
+// keepStoredRecords carries the value, cracking details, creation time and meta entries of
+// records already stored, or of earlier copies in the batch, over to the hashes replacing them
+func (im *importer) keepStoredRecords() error {
+	im.kc.mu.Lock()
+	defer im.kc.mu.Unlock()
+
+	previous := make(map[string]*Hash, len(im.batch))
+	return im.kc.c.View(func(txn *badger.Txn) error {
+		for _, sh := range im.batch {
+			stored, ok := previous[string(sh.Key)]
+			if !ok {
+				var err error
+				if stored, err = im.kc.storedRecord(txn, sh.Key); err != nil {
+					return err
+				}
+			}
+			previous[string(sh.Key)] = sh
+			if stored == nil {
+				continue
+			}
+
+			if sh.Value == "" {
+				sh.Value, sh.CrackedAt, sh.Source = stored.Value, stored.CrackedAt, stored.Source
+			}
+			if !stored.CreatedAt.IsZero() {
+				sh.CreatedAt = stored.CreatedAt
+			}
+			for k, v := range stored.Meta {
+				if _, ok := sh.Meta[k]; !ok && len(sh.Meta) < maxMetaEntries {
+					if sh.Meta == nil {
+						sh.Meta = make(map[string]string, len(stored.Meta))
+					}
+					sh.Meta[k] = v
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// lmCandidate is an uncracked NT hash and the plaintext of its cracked LM sibling
+type lmCandidate struct {
+	ntHash  string
+	lmPlain string
+}
+
+// PromoteLMCracks cracks NT hashes whose LM sibling, see ImportPwdump, is cracked. The LM
+// plaintext is the password uppercased, so every case permutation of it is hashed with MD4 and
+// the NT hash is marked cracked with the one that matches, 2^14 hashes at most. LM hashes
+// cracked in halves, the way hashcat stores them, are put together from the stored halves by
+// AssembleLMHalves first. Returns the number of NT hashes cracked
+func (kc *KDB) PromoteLMCracks(ctx context.Context) (int, error) {
+	if _, err := kc.AssembleLMHalves(ctx); err != nil {
+		return 0, err
+	}
+
+	candidates, err := kc.lmCandidates(ctx)
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to find cracked LM hashes: %v", err), Error)
+		return 0, fmt.Errorf("failed to find cracked LM hashes: %w", err)
+	}
+
+	promoted := 0
+	for _, c := range candidates {
+		if err := ctx.Err(); err != nil {
+			return promoted, err
+		}
+
+		plain, ok := ntPermutation(c.lmPlain, c.ntHash)
+		if !ok {
+			continue
+		}
+		if err := kc.MarkCracked(c.ntHash, NTLM, plain, lmPromotionSource); err != nil {
+			return promoted, fmt.Errorf("failed to mark NT hash cracked: %w", err)
+		}
+		promoted++
+	}
+
+	kc.log(fmt.Sprintf("Cracked %d NT hashes from %d cracked LM siblings", promoted, len(candidates)), Info)
+	return promoted, nil
+}
+
+// lmCandidates returns the uncracked NT hashes whose LM sibling has a plaintext
+func (kc *KDB) lmCandidates(ctx context.Context) ([]lmCandidate, error) {
+	var candidates []lmCandidate
+	prefix := []byte(kc.keys.key(hashTypeScanPrefix, NTLM))
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	err := kc.c.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var nt Hash
+			if err := it.Item().Value(func(val []byte) error {
+				return kc.decodeHash(val, &nt)
+			}); err != nil || nt.Value != "" {
+				continue
+			}
+			lmKey, ok := kc.parseSiblingRef(nt.Meta[SiblingMetaKey], LM)
+			if !ok {
+				continue
+			}
+
+			lm, err := kc.storedRecord(txn, lmKey)
+			if err != nil {
+				return err
+			}
+			if lm == nil {
+				continue
+			}
+			plain, ok, err := kc.lmPlaintext(txn, lm)
+			if err != nil {
+				return err
+			}
+			if ok {
+				candidates = append(candidates, lmCandidate{ntHash: nt.Canonical(), lmPlain: plain})
+			}
+		}
+		return nil
+	})
+	return candidates, err
+}
+
+// lmPlaintext returns the plaintext of a stored LM hash, its value or the values of its two
+// halves stored as hashes of their own. The second half of passwords up to 7 characters is the
+// LM hash of ""
+func (kc *KDB) lmPlaintext(txn *badger.Txn, lm *Hash) (string, bool, error) {
+	if lm.Value != "" {
+		return lm.Value, true, nil
+	}
+	hash := lm.Canonical()
+	if len(hash) != 32 {
+		return "", false, nil
+	}
+
+	plain := ""
+	for _, half := range []string{hash[:16], hash[16:]} {
+		if half == emptyLMHalf {
+			continue
+		}
+		key, err := kc.originalHashKey(txn, half, LM)
+		if isNotFound(err) {
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		stored, err := kc.storedRecord(txn, key)
+		if err != nil || stored == nil || stored.Value == "" {
+			return "", false, err
+		}
+		plain += stored.Value
+	}
+	return plain, plain != "", nil
+}
+
+// ntPermutation returns the case permutation of the LM plaintext lmPlain whose NT hash is ntHash
+func ntPermutation(lmPlain, ntHash string) (string, bool) {
+	if utf8.RuneCountInString(lmPlain) > maxLMPassword {
+		return "", false
+	}
+
+	runes := []rune(strings.ToUpper(lmPlain))
+	var letters []int
+	for i, r := range runes {
+		if unicode.ToLower(r) != r {
+			letters = append(letters, i)
+		}
+	}
+
+	candidate := make([]rune, len(runes))
+	for mask := 0; mask < 1<<len(letters); mask++ {
+		copy(candidate, runes)
+		for bit, i := range letters {
+			if mask&(1<<bit) != 0 {
+				candidate[i] = unicode.ToLower(candidate[i])
+			}
+		}
+		if util.NTHash(string(candidate)) == ntHash {
+			return string(candidate), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,71 @@
+package kdb
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// testPwdump holds published LM/NT pairs: PASSWORD/password, PASSWORD1/Password1 and the empty
+// password, which isn't stored
+const testPwdump = `Administrator:500:E52CAC67419A9A224A3B108F3FA6CB6D:8846F7EAEE8FB117AD06BDD830B7586C:::
+CORP\jdoe:1104:E52CAC67419A9A2238F10713B629B565:64F12CDDAA88057E06A81B54E73B949B:::
+Guest:501:AAD3B435B51404EEAAD3B435B51404EE:31D6CFE0D16AE931B73C59D7E0C089C0:::
+svc_backup:1105:AAD3B435B51404EEAAD3B435B51404EE:8846F7EAEE8FB117AD06BDD830B7586C:::
+broken line
+`
+
+func TestPromoteLMCracks(t *testing.T) {
+	db := newTestDB(t, testPrefix, nil)
+	ctx := context.Background()
+
+	report, err := db.ImportPwdump(strings.NewReader(testPwdump))
+	if err != nil {
+		t.Fatalf("ImportPwdump: %v", err)
+	}
+	// Two LM hashes with their four halves and three NT hashes
+	if report.Imported != 9 || report.Invalid != 1 {
+		t.Errorf("imported %d, %d invalid", report.Imported, report.Invalid)
+	}
+	nt, err := db.GetHashByOriginalHash("8846f7eaee8fb117ad06bdd830b7586c", NTLM)
+	if err != nil || !strings.HasPrefix(nt.Meta[SiblingMetaKey], "3000:") {
+		t.Fatalf("the NT hash isn't linked to its LM hash: %+v %v", nt, err)
+	}
+
+	// One LM hash is cracked whole, the other in halves like hashcat does
+	if n, err := db.PromoteLMCracks(ctx); err != nil || n != 0 {
+		t.Errorf("PromoteLMCracks before any crack cracked %d: %v", n, err)
+	}
+	if err := db.MarkCracked("e52cac67419a9a224a3b108f3fa6cb6d", LM, "PASSWORD"); err != nil {
+		t.Fatalf("MarkCracked: %v", err)
+	}
+	if _, err := db.ImportPotfile(strings.NewReader("e52cac67419a9a22:PASSWOR\n38f10713b629b565:D1\n"), LM); err != nil {
+		t.Fatalf("ImportPotfile: %v", err)
+	}
+
+	if n, err := db.PromoteLMCracks(ctx); err != nil || n != 2 {
+		t.Errorf("PromoteLMCracks cracked %d: %v", n, err)
+	}
+	for hash, want := range map[string]string{
+		"8846f7eaee8fb117ad06bdd830b7586c": "password",
+		"64f12cddaa88057e06a81b54e73b949b": "Password1",
+	} {
+		got, err := db.GetHashByOriginalHash(hash, NTLM)
+		if err != nil || got.Value != want {
+			t.Errorf("NT %s: %+v %v, want %q", hash, got, err, want)
+		} else if got.Source == nil || got.Source.Tool != "krkndb" {
+			t.Errorf("NT %s has source %+v", hash, got.Source)
+		}
+	}
+	if n, err := db.PromoteLMCracks(ctx); err != nil || n != 0 {
+		t.Errorf("a second PromoteLMCracks cracked %d: %v", n, err)
+	}
+
+	// Importing the dump again keeps the cracks
+	if _, err := db.ImportPwdump(strings.NewReader(testPwdump)); err != nil {
+		t.Fatalf("ImportPwdump: %v", err)
+	}
+	if got, err := db.GetHashByOriginalHash("64f12cddaa88057e06a81b54e73b949b", NTLM); err != nil || got.Value != "Password1" {
+		t.Errorf("the reimport lost the NT value: %+v %v", got, err)
+	}
+}
@@ -1,53 +1,311 @@
 package kdb
 
 import (
-	"bytes"
+	"context"
 	"encoding/binary"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"iter"
-	"strings"
+	"time"
 
-	"github.com/KrakenTech-LLC/KrknDB/internal/util"
 	"github.com/dgraph-io/badger/v4"
 )
 
-// StoreHash stores a hash in the database
-func (kc *KDB) StoreHash(sh *Hash) error {
-	kc.mu.Lock()
-	err := kc.c.Update(func(txn *badger.Txn) error {
-		// Marshal the hash to JSON
-		data, err := json.Marshal(sh)
-		if err != nil {
-			return fmt.Errorf("failed to marshal hash: %w", err)
+// StoreHash stores a hash in the database and reports whether it wasn't stored before. Counters
+// only count new hashes, storing a hash again replaces it. The write is committed when StoreHash
+// returns, so every read started after a successful StoreHash sees it or a later write.
+// Returns ErrEmptyHash, ErrHashTooLarge, ErrValueTooLarge (or ErrInvalidHash and ErrInvalidHashType
+// under strict validation) for invalid input, a *QuotaExceededError if the hash isn't stored yet
+// and a quota for the hash type or the database is full, and ErrTooMuchContention if concurrent
+// writes kept conflicting.
+// Bounded by Options.DefaultOpTimeout, see StoreHashContext
+func (kc *KDB) StoreHash(sh *Hash) (isNew bool, err error) {
+	return withDefaultTimeout(kc, func(ctx context.Context) (bool, error) {
+		return kc.StoreHashContext(ctx, sh)
+	})
+}
+
+// StoreHashContext is StoreHash bounded by ctx. If ctx is done before the write begins it is
+// abandoned and ErrOperationTimeout returned for a passed deadline; once it began, the outcome
+// is returned however long it takes
+func (kc *KDB) StoreHashContext(ctx context.Context, sh *Hash) (bool, error) {
+	return awaitWrite(kc, ctx, "store", func(gate *opGate) (bool, error) {
+		return kc.storeHash(sh, gate)
+	})
+}
+
+// storeHash stores a hash once gate lets the write begin
+func (kc *KDB) storeHash(sh *Hash, gate *opGate) (isNew bool, err error) {
+	if err := kc.validateHash(sh); err != nil {
+		kc.recordError(err)
+		return false, err
+	}
+	kc.bindHash(sh)
+
+	if kc.dedup.seen(sh) {
+		kc.ops.skippedDuplicates.Add(1)
+		return false, nil
+	}
+
+	if err := kc.markDirty(sh.HashType); err != nil {
+		kc.recordError(err)
+		return false, err
+	}
+
+	saved := 0
+	var quotaErr error
+	err = kc.retryConflicts("store", func() error {
+		kc.mu.Lock()
+		defer kc.mu.Unlock()
+		if !gate.begin() {
+			return errAbandoned
 		}
 
-		// Store the hash with the generated key
-		return txn.Set(sh.Key, data)
+		err := kc.c.Update(func(txn *badger.Txn) error {
+			_, err := txn.Get(sh.Key)
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+			isNew = err != nil
+
+			// Only a new hash takes up quota, storing one again doesn't change the counters
+			if isNew {
+				if quotaErr = kc.checkQuota(map[uint64]uint64{sh.HashType: 1}); quotaErr != nil {
+					return quotaErr
+				}
+			}
+
+			// The insertion sequence is part of the record, so it is taken first
+			if err := kc.indexHash(txn, sh); err != nil {
+				return err
+			}
+
+			// Serialize the hash for storage
+			data, s, err := kc.encodeRecord(sh)
+			if err != nil {
+				return fmt.Errorf("failed to encode hash: %w", err)
+			}
+			saved = s
+
+			// Store the hash with the generated key
+			if err := txn.Set(sh.Key, data); err != nil {
+				return err
+			}
+			if !isNew {
+				return nil
+			}
+			return kc.countNewHash(txn, sh.HashType)
+		})
+		if err == nil {
+			kc.dedup.remember(sh)
+		}
+		return err
 	})
-	kc.mu.Unlock()
+	if err == errAbandoned {
+		return false, err
+	}
+	if quotaErr != nil {
+		kc.recordError(quotaErr)
+		return false, quotaErr
+	}
 
+	kc.ops.stores.Add(1)
 	if err != nil {
-		return fmt.Errorf("failed to store hash: %w", err)
+		kc.ops.storeErrors.Add(1)
+		err = fmt.Errorf("failed to store hash: %w", err)
+		kc.recordError(err)
+		return false, err
 	}
+	kc.recordCompressionSaved(saved)
 
-	// Increment the total hash count (outside the mutex lock to avoid deadlock)
-	kc.incrementTotalHashCount()
-	kc.incrementHashTypeCount(sh.HashType)
+	kc.fireCracked(sh)
+	return isNew, nil
+}
 
-	return nil
+// StoreResult reports how many hashes of a StoreHashes batch were new and how many replaced
+// stored ones. A hash in the batch twice counts as new once and updated after that. Skipped
+// counts the hashes Options.DedupWindow found stored moments ago as they are and didn't write
+type StoreResult struct {
+	New     int `json:"new"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped,omitempty"`
 }
 
-// GetHashBySum retrieves a hash by its hex-encoded SHA256 sum and hash type
-// This is the most efficient method for finding a single hash by exact sum (O(1) lookup)
-func (kc *KDB) GetHashBySum(hexSum string, hashType uint64) (*Hash, error) {
+// StoreHashes stores a batch of hashes in the database and reports how many were new.
+// The batch is written with a single badger write batch and counters are updated once per hash type,
+// counting new hashes only. Every hash is validated and quotas and Options.MaxBatchBytes are
+// checked for the whole batch before anything is written, quotas counting only the hashes that
+// aren't stored yet. The batch is flushed before StoreHashes returns, reads started after it see
+// every hash of a successful batch. With Options.ThrottleWrites the batch waits first while the
+// database is under write pressure.
+// Bounded by Options.DefaultOpTimeout, see StoreHashesContext
+func (kc *KDB) StoreHashes(hashes []*Hash) (StoreResult, error) {
+	return withDefaultTimeout(kc, func(ctx context.Context) (StoreResult, error) {
+		return kc.StoreHashesContext(ctx, hashes)
+	})
+}
+
+// StoreHashesContext is StoreHashes bounded by ctx. If ctx is done before the batch begins being
+// written, such as while it waits for the database lock or is throttled, it is abandoned and
+// ErrOperationTimeout returned for a passed deadline; once it began, the outcome is returned
+// however long it takes
+func (kc *KDB) StoreHashesContext(ctx context.Context, hashes []*Hash) (StoreResult, error) {
+	return awaitWrite(kc, ctx, "store batch", func(gate *opGate) (StoreResult, error) {
+		return kc.storeHashes(hashes, gate)
+	})
+}
+
+// storeHashes stores a batch once gate lets the write begin
+func (kc *KDB) storeHashes(hashes []*Hash, gate *opGate) (StoreResult, error) {
+	for i, sh := range hashes {
+		if err := kc.validateHash(sh); err != nil {
+			err = fmt.Errorf("hash %d in batch: %w", i, err)
+			kc.recordError(err)
+			return StoreResult{}, err
+		}
+		kc.bindHash(sh)
+	}
+
+	hashes, skipped := kc.skipDuplicates(hashes)
+	if len(hashes) == 0 {
+		return StoreResult{Skipped: skipped}, nil
+	}
+
+	perType := make(map[uint64]uint64)
+	for _, sh := range hashes {
+		perType[sh.HashType]++
+	}
+
+	kc.throttleWrites()
+	release, err := kc.reserveBatch(batchFootprint(hashes))
+	if err != nil {
+		kc.recordError(err)
+		return StoreResult{}, err
+	}
+	defer release()
+
+	dirty := make([]uint64, 0, len(perType))
+	for hashType := range perType {
+		dirty = append(dirty, hashType)
+	}
+	if err := kc.markDirty(dirty...); err != nil {
+		kc.recordError(err)
+		return StoreResult{}, err
+	}
+
+	saved := 0
+	var fresh map[uint64]int
+	var quotaErr error
+	err = func() error {
+		kc.mu.Lock()
+		defer kc.mu.Unlock()
+		if !gate.begin() {
+			return errAbandoned
+		}
+		wb := kc.c.NewWriteBatch()
+		defer wb.Cancel()
+
+		var err error
+		if fresh, err = kc.indexHashes(wb, hashes); err != nil {
+			return err
+		}
+		// Only hashes not stored yet take up quota, nothing is written if they don't fit
+		pending := make(map[uint64]uint64, len(fresh))
+		for hashType, n := range fresh {
+			pending[hashType] = uint64(n)
+		}
+		if quotaErr = kc.checkQuota(pending); quotaErr != nil {
+			return quotaErr
+		}
+		for _, sh := range hashes {
+			data, s, err := kc.encodeRecord(sh)
+			if err != nil {
+				return fmt.Errorf("failed to encode hash: %w", err)
+			}
+			saved += s
+			if err := wb.Set(sh.Key, data); err != nil {
+				return err
+			}
+		}
+		if err := wb.Flush(); err != nil {
+			return err
+		}
+		kc.dedup.remember(hashes...)
+		return nil
+	}()
+	if err == errAbandoned {
+		return StoreResult{}, err
+	}
+	if quotaErr != nil {
+		kc.recordError(quotaErr)
+		return StoreResult{}, quotaErr
+	}
+
+	kc.ops.stores.Add(uint64(len(hashes)))
+	if err != nil {
+		kc.ops.storeErrors.Add(1)
+		err = fmt.Errorf("failed to store hashes: %w", err)
+		kc.recordError(err)
+		return StoreResult{}, err
+	}
+
+	// Update the counters once per hash type (outside the mutex lock to avoid deadlock)
+	result := StoreResult{Skipped: skipped}
+	for hashType, n := range fresh {
+		result.New += n
+		if err := kc.registerHashType(hashType); err != nil {
+			kc.log(fmt.Sprintf("failed to register hash type %d: %v", hashType, err), Error)
+		}
+
+		if err := kc.updateTypeCount(hashType, n); err != nil {
+			kc.log(fmt.Sprintf("failed to update hash type count: %v", err), Error)
+		}
+	}
+	if result.New > 0 {
+		if err := kc.updateShardedCount(kc.keys.key(totalHashesKey), result.New); err != nil {
+			kc.log(fmt.Sprintf("failed to update total hash count: %v", err), Error)
+		}
+	}
+	result.Updated = len(hashes) - result.New
+	kc.recordCompressionSaved(saved)
+
+	kc.fireCracked(hashes...)
+	return result, nil
+}
+
+// GetHashBySum retrieves a hash by its SHA256 sum and hash type, the sum hex encoded as Hash.Sum
+// and SumOf return it or the raw 32 bytes of Hash.SumBytes. Anything else fails with
+// ErrInvalidSum, to look a hash up by the hash itself use GetHashByOriginalHash.
+// This is the most efficient method for finding a single hash by exact sum (O(1) lookup).
+// Bounded by Options.DefaultOpTimeout, see GetHashBySumContext
+func (kc *KDB) GetHashBySum(sum string, hashType uint64) (*Hash, error) {
+	return withDefaultTimeout(kc, func(ctx context.Context) (*Hash, error) {
+		return kc.GetHashBySumContext(ctx, sum, hashType)
+	})
+}
+
+// GetHashBySumContext is GetHashBySum bounded by ctx, returning ErrOperationTimeout once its
+// deadline passes. The lookup can't be interrupted and finishes in the background
+func (kc *KDB) GetHashBySumContext(ctx context.Context, sum string, hashType uint64) (*Hash, error) {
+	return awaitRead(kc, ctx, "lookup", func() (*Hash, error) {
+		return kc.getHashBySum(sum, hashType)
+	})
+}
+
+// getHashBySum looks a hash up by its sum, raw or hex encoded
+func (kc *KDB) getHashBySum(sum string, hashType uint64) (*Hash, error) {
+	keySum, err := checkSum(sum)
+	if err != nil {
+		return nil, err
+	}
+
 	kc.mu.Lock()
 	defer kc.mu.Unlock()
 
-	key := []byte(fmt.Sprintf(storedHashPrefix, hashType, hexSum))
+	key := kc.keys.hashKey(hashType, keySum)
 	var hash *Hash
 
-	err := kc.c.View(func(txn *badger.Txn) error {
+	err = kc.c.View(func(txn *badger.Txn) error {
 		item, err := txn.Get(key)
 		if err != nil {
 			return err
@@ -55,10 +313,11 @@ func (kc *KDB) GetHashBySum(hexSum string, hashType uint64) (*Hash, error) {
 
 		return item.Value(func(val []byte) error {
 			hash = &Hash{}
-			return json.Unmarshal(val, hash)
+			return kc.decodeHash(val, hash)
 		})
 	})
 
+	kc.countLookup(err)
 	if err != nil {
 		return nil, err
 	}
@@ -68,17 +327,40 @@ func (kc *KDB) GetHashBySum(hexSum string, hashType uint64) (*Hash, error) {
 
 // GetHashByOriginalHash retrieves a hash by the original hash string and hash type
 // This computes the SHA256 sum and does a direct lookup (O(1))
-// The hash is automatically normalized to lowercase for consistent lookup
+// The hash is automatically normalized to lowercase for consistent lookup.
+// On a miss the Options.Fallbacks are consulted in order.
+// Bounded by Options.DefaultOpTimeout, see GetHashByOriginalHashContext
 func (kc *KDB) GetHashByOriginalHash(originalHash string, hashType uint64) (*Hash, error) {
+	return withDefaultTimeout(kc, func(ctx context.Context) (*Hash, error) {
+		return kc.GetHashByOriginalHashContext(ctx, originalHash, hashType)
+	})
+}
+
+// GetHashByOriginalHashContext is GetHashByOriginalHash bounded by ctx, returning
+// ErrOperationTimeout once its deadline passes. The lookup can't be interrupted and finishes in
+// the background
+func (kc *KDB) GetHashByOriginalHashContext(ctx context.Context, originalHash string, hashType uint64) (*Hash, error) {
+	return awaitRead(kc, ctx, "lookup", func() (*Hash, error) {
+		hash, err := kc.getHashByOriginalHash(originalHash, hashType)
+		if isNotFound(err) && len(kc.fallbacks) > 0 {
+			return kc.resolve(originalHash, hashType)
+		}
+		return hash, err
+	})
+}
+
+// getHashByOriginalHash looks a hash up in the local store only
+func (kc *KDB) getHashByOriginalHash(originalHash string, hashType uint64) (*Hash, error) {
 	kc.mu.Lock()
 	defer kc.mu.Unlock()
 
-	// Normalize to lowercase and compute the SHA256 sum of the original hash
-	hexSum := string(util.SHA256Sum(strings.ToLower(originalHash)))
-	key := []byte(fmt.Sprintf(storedHashPrefix, hashType, hexSum))
 	var hash *Hash
 
 	err := kc.c.View(func(txn *badger.Txn) error {
+		key, err := kc.originalHashKey(txn, originalHash, hashType)
+		if err != nil {
+			return err
+		}
 		item, err := txn.Get(key)
 		if err != nil {
 			return err
@@ -86,10 +368,11 @@ func (kc *KDB) GetHashByOriginalHash(originalHash string, hashType uint64) (*Has
 
 		return item.Value(func(val []byte) error {
 			hash = &Hash{}
-			return json.Unmarshal(val, hash)
+			return kc.decodeHash(val, hash)
 		})
 	})
 
+	kc.countLookup(err)
 	if err != nil {
 		return nil, err
 	}
@@ -97,61 +380,230 @@ func (kc *KDB) GetHashByOriginalHash(originalHash string, hashType uint64) (*Has
 	return hash, nil
 }
 
+// DeleteHash deletes a hash by the original hash string and hash type and decrements the counters.
+// The hash is normalized to lowercase like GetHashByOriginalHash.
+// Returns badger.ErrKeyNotFound if the hash is not stored.
+// Bounded by Options.DefaultOpTimeout, see DeleteHashContext
+func (kc *KDB) DeleteHash(originalHash string, hashType uint64) error {
+	_, err := withDefaultTimeout(kc, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, kc.DeleteHashContext(ctx, originalHash, hashType)
+	})
+	return err
+}
+
+// DeleteHashContext is DeleteHash bounded by ctx. If ctx is done before the delete begins it is
+// abandoned and ErrOperationTimeout returned for a passed deadline; once it began, the outcome
+// is returned however long it takes
+func (kc *KDB) DeleteHashContext(ctx context.Context, originalHash string, hashType uint64) error {
+	_, err := awaitWrite(kc, ctx, "delete", func(gate *opGate) (struct{}, error) {
+		return struct{}{}, kc.deleteHash(originalHash, hashType, gate)
+	})
+	return err
+}
+
+// deleteHash deletes a hash once gate lets the write begin
+func (kc *KDB) deleteHash(originalHash string, hashType uint64, gate *opGate) error {
+	if err := kc.markDirty(hashType); err != nil {
+		kc.recordError(err)
+		return err
+	}
+
+	err := kc.retryConflicts("delete", func() error {
+		kc.mu.Lock()
+		defer kc.mu.Unlock()
+		if !gate.begin() {
+			return errAbandoned
+		}
+
+		return kc.c.Update(func(txn *badger.Txn) error {
+			key, err := kc.originalHashKey(txn, originalHash, hashType)
+			if err != nil {
+				return err
+			}
+			if _, err := txn.Get(key); err != nil {
+				return err
+			}
+			stored, err := kc.storedRecord(txn, key)
+			if err != nil {
+				return err
+			}
+			if stored != nil {
+				if err := kc.unindexHash(txn, stored); err != nil {
+					return err
+				}
+			}
+			kc.dedup.forget(key)
+			if err := kc.writeTombstone(txn, hashType, key); err != nil {
+				return err
+			}
+			return txn.Delete(key)
+		})
+	})
+
+	if err != nil {
+		if !isNotFound(err) && err != errAbandoned {
+			err = fmt.Errorf("failed to delete hash: %w", err)
+			kc.recordError(err)
+		}
+		return err
+	}
+
+	// Decrement the counters (outside the mutex lock to avoid deadlock)
+	if err := kc.updateTypeCount(hashType, -1); err != nil {
+		kc.log(fmt.Sprintf("failed to update hash type count: %v", err), Error)
+	}
+	if err := kc.updateShardedCount(kc.keys.key(totalHashesKey), -1); err != nil {
+		kc.log(fmt.Sprintf("failed to update total hash count: %v", err), Error)
+	}
+
+	return nil
+}
+
+// MarkCracked sets the value of a stored hash, keeping the rest of the entry, and acks its queue item.
+// A hash that isn't stored yet is stored with the value. An optional source records the attack that
+// cracked it
+func (kc *KDB) MarkCracked(originalHash string, hashType uint64, value string, source ...*Source) error {
+	sh := kc.NewHash(originalHash, value, hashType)
+	if len(source) > 0 {
+		sh.Source = source[0]
+	}
+	if err := kc.validateHash(sh); err != nil {
+		kc.recordError(err)
+		return err
+	}
+	kc.bindHash(sh)
+	id, _ := kc.queueID(originalHash, hashType)
+
+	// Only the cracked count changes, a drift there is still a drift
+	if err := kc.markDirty(hashType); err != nil {
+		kc.recordError(err)
+		return err
+	}
+
+	var updated *Hash
+	err := kc.updateLocked(func(txn *badger.Txn) error {
+		key, err := kc.originalHashKey(txn, originalHash, hashType)
+		if isNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+
+		var existing Hash
+		if err := item.Value(func(val []byte) error {
+			return kc.decodeHash(val, &existing)
+		}); err != nil {
+			return err
+		}
+
+		previous := existing
+		existing.Value = value
+		if sh.Source != nil {
+			existing.Source = sh.Source
+		}
+		if err := kc.indexValue(txn, &existing, &previous); err != nil {
+			return err
+		}
+		if delta := crackedDelta(&existing, &previous); delta != 0 {
+			if err := addCount(txn, kc.keys.key(crackedCountPrefix, hashType), delta); err != nil {
+				return err
+			}
+		}
+		if err := kc.indexCrackTime(txn, &existing, &previous, time.Now()); err != nil {
+			return err
+		}
+		data, err := kc.encodeHash(&existing)
+		if err != nil {
+			return fmt.Errorf("failed to encode hash: %w", err)
+		}
+		if err := txn.Set(key, data); err != nil {
+			return err
+		}
+		kc.dedup.forget(key)
+
+		updated = &existing
+		return kc.ackQueueItem(txn, id)
+	})
+
+	if err != nil {
+		err = fmt.Errorf("failed to mark hash cracked: %w", err)
+		kc.recordError(err)
+		return err
+	}
+	if updated != nil {
+		kc.fireCracked(updated)
+		return nil
+	}
+
+	if _, err := kc.storeHash(sh, nil); err != nil {
+		return err
+	}
+	return kc.QueueAck([]string{id})
+}
+
 // GetHashesByHashType returns an iterator that yields all hashes of a specific hash type
-// This is a generator function that allows efficient iteration over large datasets
-func (kc *KDB) GetHashesByHashType(hashType uint64) iter.Seq[*Hash] {
+// This is a generator function that allows efficient iteration over large datasets.
+// Optional ScanOptions tune the scan, pick the order and page through the results
+func (kc *KDB) GetHashesByHashType(hashType uint64, scanOpts ...*ScanOptions) iter.Seq[*Hash] {
+	so := scanOptions(scanOpts)
 	return func(yield func(*Hash) bool) {
+		yield, recovery := guardYield(kc, "iteration", yield)
+		defer recovery()
+
 		kc.mu.Lock()
 		defer kc.mu.Unlock()
+		kc.ops.iterations.Add(1)
+
+		err := kc.c.View(func(txn *badger.Txn) error {
+			return kc.scan(txn, hashType, "", nil, so, yield)
+		})
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to iterate hash type %d: %v", hashType, err), Error)
+		}
+	}
+}
 
-		// Create the prefix for this hash type
-		prefix := []byte(fmt.Sprintf(hashTypeLookupPrefix, hashType))
+// GetHashesByHashTypes returns an iterator over the hashes of several hash types, read in one
+// transaction with one seek per type. Types are yielded one after the other in the order given,
+// an empty slice means every registered type
+func (kc *KDB) GetHashesByHashTypes(hashTypes []uint64) iter.Seq[*Hash] {
+	return func(yield func(*Hash) bool) {
+		yield, recovery := guardYield(kc, "iteration", yield)
+		defer recovery()
 
-		// Start a read transaction
+		kc.mu.Lock()
+		defer kc.mu.Unlock()
+		kc.ops.iterations.Add(1)
+
+		so := DefaultScanOptions()
 		err := kc.c.View(func(txn *badger.Txn) error {
-			// Create iterator options with prefix
-			opts := badger.DefaultIteratorOptions
-			opts.Prefix = prefix
-
-			// Create a new iterator
-			it := txn.NewIterator(opts)
-			defer it.Close()
-
-			// Iterate over all keys with this prefix
-			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-				item := it.Item()
-
-				// Get the value
-				err := item.Value(func(val []byte) error {
-					var hash Hash
-					if err := json.Unmarshal(val, &hash); err != nil {
-						return err
-					}
-
-					// Yield the hash to the caller
-					// If yield returns false, stop iteration
-					if !yield(&hash) {
-						return fmt.Errorf("iteration stopped")
-					}
-					return nil
-				})
+			types := hashTypes
+			if len(types) == 0 {
+				var err error
+				if types, err = kc.readHashTypes(txn); err != nil {
+					return fmt.Errorf("failed to get registered hash types: %w", err)
+				}
+			}
 
-				if err != nil {
-					// If iteration was stopped, break
-					if err.Error() == "iteration stopped" {
-						break
-					}
-					// For other errors, continue to next item
-					continue
+			stopped := false
+			for _, hashType := range types {
+				err := kc.scan(txn, hashType, "", nil, so, func(hash *Hash) bool {
+					stopped = !yield(hash)
+					return !stopped
+				})
+				if err != nil || stopped {
+					return err
 				}
 			}
 			return nil
 		})
-
-		// Log error if any (in production, you might want to handle this differently)
-		if err != nil && err.Error() != "iteration stopped" {
-			// Error occurred during iteration
-			_ = err
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to iterate hash types: %v", err), Error)
 		}
 	}
 }
@@ -171,138 +623,84 @@ func (kc *KDB) GetHashesByHashType(hashType uint64) iter.Seq[*Hash] {
 // Break-even point is typically around 10-50 hashes depending on dataset size.
 //
 // For single hash lookups, use GetHashByOriginalHash() instead (O(1) direct lookup).
-func (kc *KDB) FindHashes(possibleHashes []string, hashType uint64) iter.Seq[*Hash] {
+// When the searched hashes are few next to the hashes of the type, they are looked up one by one
+// instead, ExplainFind tells which way a search went.
+// Optional ScanOptions tune the scan, with KeysOnly the values aren't read at all
+func (kc *KDB) FindHashes(possibleHashes []string, hashType uint64, scanOpts ...*ScanOptions) iter.Seq[*Hash] {
+	so := scanOptions(scanOpts)
 	return func(yield func(*Hash) bool) {
+		yield, recovery := guardYield(kc, "find", yield)
+		defer recovery()
+
 		if len(possibleHashes) == 0 {
 			return
 		}
 
 		kc.mu.Lock()
 		defer kc.mu.Unlock()
+		kc.ops.iterations.Add(1)
 
 		// Create a map of hex sums for O(1) lookup
 		// Normalize all hashes to lowercase before computing SHA256
-		sumMap := make(map[string]bool, len(possibleHashes))
-		for _, hashStr := range possibleHashes {
-			hexSum := util.SHA256Sum(strings.ToLower(hashStr))
-			sumMap[string(hexSum)] = true
-		}
-
-		// Create the prefix for this hash type (scan all hashes of this type)
-		prefix := []byte(fmt.Sprintf(hashTypeLookupPrefix, hashType))
+		targets := kc.findSums(possibleHashes, hashType)
 
+		// Scan all hashes of this type, the key alone tells whether a hash is searched for,
+		// unless looking the few searched for up one by one is cheaper
 		err := kc.c.View(func(txn *badger.Txn) error {
-			opts := badger.DefaultIteratorOptions
-			opts.Prefix = prefix
-			opts.PrefetchValues = true
-
-			it := txn.NewIterator(opts)
-			defer it.Close()
-
-			// Iterate over all hashes of this type
-			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-				item := it.Item()
-
-				err := item.Value(func(val []byte) error {
-					var hash Hash
-					if err := json.Unmarshal(val, &hash); err != nil {
-						return err
-					}
-
-					// Check if this hash's sum is in our search set
-					if sumMap[string(hash.Sum)] {
-						if !yield(&hash) {
-							return fmt.Errorf("iteration stopped")
-						}
-					}
-					return nil
-				})
-
-				if err != nil && err.Error() == "iteration stopped" {
-					break
-				}
+			plan, err := kc.planFind(txn, hashType, len(targets.sums), so)
+			if err != nil {
+				return err
 			}
-			return nil
+			return kc.find(txn, plan.Strategy, hashType, targets, so, yield)
 		})
-
-		if err != nil && err.Error() != "iteration stopped" {
-			_ = err
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to search hash type %d: %v", hashType, err), Error)
 		}
 	}
 }
 
 // SearchHashesByPrefix searches for hashes where the hex sum starts with the given prefix
-// This is useful for partial hash lookups
-func (kc *KDB) SearchHashesByPrefix(hexPrefix string, hashType uint64) iter.Seq[*Hash] {
+// This is useful for partial hash lookups. Optional ScanOptions tune the scan, pick the order
+// and page through the results
+func (kc *KDB) SearchHashesByPrefix(hexPrefix string, hashType uint64, scanOpts ...*ScanOptions) iter.Seq[*Hash] {
+	so := scanOptions(scanOpts)
 	return func(yield func(*Hash) bool) {
+		yield, recovery := guardYield(kc, "prefix search", yield)
+		defer recovery()
+
 		kc.mu.Lock()
 		defer kc.mu.Unlock()
-
-		// Create the search prefix
-		searchPrefix := []byte(fmt.Sprintf(storedHashPrefix, hashType, hexPrefix))
+		kc.ops.iterations.Add(1)
 
 		err := kc.c.View(func(txn *badger.Txn) error {
-			opts := badger.DefaultIteratorOptions
-			opts.PrefetchValues = true
-
-			it := txn.NewIterator(opts)
-			defer it.Close()
-
-			// Seek to the prefix
-			for it.Seek(searchPrefix); it.Valid(); it.Next() {
-				item := it.Item()
-				key := item.Key()
-
-				// Check if the key still matches our prefix
-				if !bytes.HasPrefix(key, searchPrefix) {
-					break
-				}
-
-				err := item.Value(func(val []byte) error {
-					var hash Hash
-					if err := json.Unmarshal(val, &hash); err != nil {
-						return err
-					}
-
-					if !yield(&hash) {
-						return fmt.Errorf("iteration stopped")
-					}
-					return nil
-				})
-
-				if err != nil && err.Error() == "iteration stopped" {
-					break
-				}
-			}
-			return nil
+			return kc.scan(txn, hashType, hexPrefix, nil, so, yield)
 		})
-
-		if err != nil && err.Error() != "iteration stopped" {
-			_ = err
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to search hash type %d by prefix: %v", hashType, err), Error)
 		}
 	}
 }
 
+// countLookup updates the lookup counters for a point lookup that returned err
+func (kc *KDB) countLookup(err error) {
+	kc.ops.lookups.Add(1)
+	switch {
+	case err == nil:
+		kc.ops.lookupHits.Add(1)
+	case errors.Is(err, badger.ErrKeyNotFound):
+		kc.ops.lookupMisses.Add(1)
+	default:
+		kc.recordError(err)
+	}
+}
+
 func (kc *KDB) getCount(key string) (int, error) {
 	var count int
 
 	err := kc.c.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(key))
-		if err != nil {
-			return err
-		}
-
-		return item.Value(func(val []byte) error {
-			if len(val) == 8 {
-				// Binary format
-				count = int(binary.BigEndian.Uint64(val))
-			} else {
-				// String format (legacy or corrupted data)
-				_, err := fmt.Sscanf(string(val), "%d", &count)
-				return err
-			}
-			return nil
-		})
+		var err error
+		count, err = readCount(txn, key)
+		return err
 	})
 
 	if err != nil {
@@ -312,82 +710,74 @@ func (kc *KDB) getCount(key string) (int, error) {
 	return count, nil
 }
 
-func (kc *KDB) updateCount(key string, delta int) error {
-	kc.mu.Lock()
-	defer kc.mu.Unlock()
+// readCount reads a counter within txn
+func readCount(txn *badger.Txn, key string) (int, error) {
+	var count int
+	item, err := txn.Get([]byte(key))
+	if err != nil {
+		return 0, err
+	}
 
-	return kc.c.Update(func(txn *badger.Txn) error {
-		var count int
-		item, err := txn.Get([]byte(key))
-		if err != nil && err != badger.ErrKeyNotFound {
+	err = item.Value(func(val []byte) error {
+		if len(val) == 8 {
+			// Binary format
+			count = int(binary.BigEndian.Uint64(val))
+		} else {
+			// String format (legacy or corrupted data)
+			_, err := fmt.Sscanf(string(val), "%d", &count)
 			return err
 		}
-
-		if err == nil {
-			err = item.Value(func(val []byte) error {
-				if len(val) == 8 {
-					// Binary format
-					count = int(binary.BigEndian.Uint64(val))
-				} else {
-					// String format (legacy or corrupted data)
-					_, err := fmt.Sscanf(string(val), "%d", &count)
-					return err
-				}
-				return nil
-			})
-			if err != nil {
-				return err
-			}
-		}
-
-		count += delta
-		// Store as binary uint64 (8 bytes)
-		buf := make([]byte, 8)
-		binary.BigEndian.PutUint64(buf, uint64(count))
-		return txn.Set([]byte(key), buf)
-	})
-}
-
-func (kc *KDB) initializeCounter(key string, initial int) error {
-	return kc.c.Update(func(txn *badger.Txn) error {
-		// Store as binary uint64 (8 bytes)
-		buf := make([]byte, 8)
-		binary.BigEndian.PutUint64(buf, uint64(initial))
-		return txn.Set([]byte(key), buf)
+		return nil
 	})
+	return count, err
 }
 
-func (kc *KDB) checkCounter(key string) error {
-	var exists bool
-
-	err := kc.c.View(func(txn *badger.Txn) error {
-		_, err := txn.Get([]byte(key))
-		if err == badger.ErrKeyNotFound {
-			exists = false
-			return nil
-		}
-
-		if err != nil {
-			logger(fmt.Sprintf("failed to check counter: %v", err), Error)
-			return err
-		}
+func (kc *KDB) updateCount(key string, delta int) error {
+	return kc.retryConflicts("counter update", func() error {
+		kc.mu.Lock()
+		defer kc.mu.Unlock()
 
-		exists = true
-		return nil
+		return kc.c.Update(func(txn *badger.Txn) error {
+			return addCount(txn, key, delta)
+		})
 	})
+}
 
-	if err != nil {
-		logger(fmt.Sprintf("failed to check counter: %v", err), Error)
+// addCount adds delta to a counter within txn, the counter never goes below zero
+func addCount(txn *badger.Txn, key string, delta int) error {
+	var count int
+	item, err := txn.Get([]byte(key))
+	if err != nil && err != badger.ErrKeyNotFound {
 		return err
 	}
 
-	if !exists {
-		err = kc.initializeCounter(key, 0)
+	if err == nil {
+		err = item.Value(func(val []byte) error {
+			if len(val) == 8 {
+				// Binary format
+				count = int(binary.BigEndian.Uint64(val))
+			} else {
+				// String format (legacy or corrupted data)
+				_, err := fmt.Sscanf(string(val), "%d", &count)
+				return err
+			}
+			return nil
+		})
 		if err != nil {
-			logger(fmt.Sprintf("failed to initialize counter: %v", err), Error)
 			return err
 		}
 	}
 
-	return err
+	return txn.Set([]byte(key), countValue(count+delta))
+}
+
+// countValue encodes a counter, counters never go below zero
+func countValue(count int) []byte {
+	if count < 0 {
+		count = 0
+	}
+	// Store as binary uint64 (8 bytes)
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(count))
+	return buf
 }
@@ -0,0 +1,502 @@
+package kdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// AccessPath is how a query reads the database
+type AccessPath string
+
+const (
+	AccessCounter        AccessPath = "counter"         // Read from the counters, nothing is scanned
+	AccessValueIndex     AccessPath = "value index"     // A range of the value index, or the case folded one, for ValueEquals and ValuePrefix
+	AccessInsertionIndex AccessPath = "insertion index" // The insertion index, for insertion orders
+	AccessKeyScan        AccessPath = "keys-only scan"  // Keys under the type or sum prefix, no values read
+	AccessScan           AccessPath = "scan"            // Keys and values under the type or sum prefix
+)
+
+// QueryPlan is the access path the planner picked for a query
+type QueryPlan struct {
+	Operation   string     `json:"operation"`            // run, count or delete
+	Access      AccessPath `json:"access"`               // Cheapest path that can answer the query
+	HashTypes   []uint64   `json:"hash_types,omitempty"` // Types read, empty for every registered type
+	SumPrefix   string     `json:"sum_prefix,omitempty"`
+	ReadsValues bool       `json:"reads_values"` // Whether every visited value is read and decrypted
+	Order       Order      `json:"order"`
+	Limit       int        `json:"limit,omitempty"`
+
+	CaseInsensitive bool `json:"case_insensitive,omitempty"` // Values are compared case folded
+}
+
+// String describes the plan on one line
+func (p QueryPlan) String() string {
+	types := "all registered types"
+	if len(p.HashTypes) > 0 {
+		types = fmt.Sprintf("types %v", p.HashTypes)
+	}
+
+	s := fmt.Sprintf("%s via %s over %s", p.Operation, p.Access, types)
+	if p.SumPrefix != "" {
+		s += fmt.Sprintf(", sum prefix %q", p.SumPrefix)
+	}
+	if p.ReadsValues {
+		s += ", reads values"
+	}
+	if p.CaseInsensitive {
+		s += ", case-insensitive"
+	}
+	if p.Access == AccessValueIndex {
+		s += ", order value"
+	} else {
+		s += ", order " + p.Order.String()
+	}
+	if p.Limit > 0 {
+		s += fmt.Sprintf(", limit %d", p.Limit)
+	}
+	return s
+}
+
+// QueryBuilder is a composable query over the stored hashes, built with Query and executed with
+// KDB.Run, KDB.Count and KDB.Delete. Every filter narrows the result and every method returns the
+// builder so calls chain. Values given to the value filters are normalized like stored values,
+// see Options.NormalizeValuesNFC. A query can be executed any number of times
+type QueryBuilder struct {
+	hashTypes   []uint64
+	sumPrefix   string
+	valueEquals *string
+	valuePrefix *string
+	contains    []string
+	predicates  []func(string) bool
+	foldCase    bool // CaseInsensitive was called
+	crackedOnly bool
+	order       Order
+	ordered     bool // OrderBy was called, the value index can't be used for its value order
+	limit       int
+	after       *Hash
+	dryRun      bool
+
+	mu   sync.Mutex
+	last *QueryPlan // plan of the last execution, for Explain
+}
+
+// Query starts a query over every registered hash type
+func Query() *QueryBuilder {
+	return &QueryBuilder{}
+}
+
+// Type restricts the query to hash types, calling it again adds more
+func (q *QueryBuilder) Type(hashTypes ...uint64) *QueryBuilder {
+	q.hashTypes = append(q.hashTypes, hashTypes...)
+	return q
+}
+
+// SumPrefix keeps hashes whose hex sum starts with hexPrefix, checked on the key
+func (q *QueryBuilder) SumPrefix(hexPrefix string) *QueryBuilder {
+	q.sumPrefix = strings.ToLower(hexPrefix)
+	return q
+}
+
+// ValueEquals keeps hashes cracked to exactly value. Served from the value index when it is on
+func (q *QueryBuilder) ValueEquals(value string) *QueryBuilder {
+	q.valueEquals = &value
+	return q
+}
+
+// ValuePrefix keeps hashes whose value starts with prefix. Served from the value index when it is on
+func (q *QueryBuilder) ValuePrefix(prefix string) *QueryBuilder {
+	q.valuePrefix = &prefix
+	return q
+}
+
+// ValueContains keeps hashes whose value contains substr
+func (q *QueryBuilder) ValueContains(substr string) *QueryBuilder {
+	q.contains = append(q.contains, substr)
+	return q
+}
+
+// ValueWhere keeps hashes whose value passes fn
+func (q *QueryBuilder) ValueWhere(fn func(value string) bool) *QueryBuilder {
+	q.predicates = append(q.predicates, fn)
+	return q
+}
+
+// CaseInsensitive makes ValueEquals, ValuePrefix and ValueContains compare Unicode case folded
+// values, ValueWhere still gets the value as stored. Served from the case folded value index when
+// Options.ValueIndexFolded is on
+func (q *QueryBuilder) CaseInsensitive() *QueryBuilder {
+	q.foldCase = true
+	return q
+}
+
+// CrackedOnly keeps hashes that have a value
+func (q *QueryBuilder) CrackedOnly() *QueryBuilder {
+	q.crackedOnly = true
+	return q
+}
+
+// OrderBy sets the order Run yields hashes in. Without it, queries served from the value index
+// come in value order and all others in sum order
+func (q *QueryBuilder) OrderBy(order Order) *QueryBuilder {
+	q.order = order
+	q.ordered = true
+	return q
+}
+
+// Limit stops after n hashes, 0 means no limit
+func (q *QueryBuilder) Limit(n int) *QueryBuilder {
+	q.limit = n
+	return q
+}
+
+// After resumes after a hash yielded by a previous Run of the query in the same order, types
+// listed before its type are skipped
+func (q *QueryBuilder) After(hash *Hash) *QueryBuilder {
+	q.after = hash
+	return q
+}
+
+// DryRun makes Delete count the hashes it would delete without deleting them
+func (q *QueryBuilder) DryRun() *QueryBuilder {
+	q.dryRun = true
+	return q
+}
+
+// Explain describes how the query was executed the last time, or how it would be run if it
+// hasn't been yet. Before the first execution the value index is assumed to be off
+func (q *QueryBuilder) Explain() string {
+	q.mu.Lock()
+	last := q.last
+	q.mu.Unlock()
+
+	if last != nil {
+		return last.String()
+	}
+	return q.plan(nil, "run").String()
+}
+
+// filtersValues returns true if the query has to read values to filter
+func (q *QueryBuilder) filtersValues() bool {
+	return q.crackedOnly || q.valueEquals != nil || q.valuePrefix != nil || len(q.contains) > 0 || len(q.predicates) > 0
+}
+
+// queryForm returns the function turning a value of the query into the form stored values are
+// compared in: normalized like stored values, see KDB.normalizeValue, and case folded for
+// case-insensitive queries. kc is nil when explaining in advance
+func (q *QueryBuilder) queryForm(kc *KDB) func(string) string {
+	normalize := func(s string) string { return s }
+	if kc != nil {
+		normalize = kc.normalizeValue
+	}
+	if q.foldCase {
+		return func(s string) string { return foldValue(normalize(s)) }
+	}
+	return normalize
+}
+
+// valueMatcher returns the function applying the value filters of the query to stored values
+func (q *QueryBuilder) valueMatcher(kc *KDB) func(string) bool {
+	form := q.queryForm(kc)
+	fold := func(s string) string { return s }
+	if q.foldCase {
+		fold = foldValue
+	}
+	var equals, prefix *string
+	if q.valueEquals != nil {
+		v := form(*q.valueEquals)
+		equals = &v
+	}
+	if q.valuePrefix != nil {
+		v := form(*q.valuePrefix)
+		prefix = &v
+	}
+	contains := make([]string, len(q.contains))
+	for i, substr := range q.contains {
+		contains[i] = form(substr)
+	}
+
+	return func(value string) bool {
+		if q.crackedOnly && value == "" {
+			return false
+		}
+		compared := value
+		if q.foldCase && (equals != nil || prefix != nil || len(contains) > 0) {
+			compared = fold(value)
+		}
+		if equals != nil && compared != *equals {
+			return false
+		}
+		if prefix != nil && !strings.HasPrefix(compared, *prefix) {
+			return false
+		}
+		for _, substr := range contains {
+			if !strings.Contains(compared, substr) {
+				return false
+			}
+		}
+		for _, fn := range q.predicates {
+			if !fn(value) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// valueIndexRange returns the part of a type's value index the query covers after the type
+// prefix, nil if the value index can't serve it. Empty values aren't indexed. Case-insensitive
+// queries cover a range of the case folded index
+func (q *QueryBuilder) valueIndexRange(kc *KDB) []byte {
+	form := q.queryForm(kc)
+
+	switch {
+	case q.valueEquals != nil && *q.valueEquals != "":
+		return append(appendIndexValue(nil, form(*q.valueEquals)), valueIndexTerminator...)
+	case q.valuePrefix != nil && *q.valuePrefix != "":
+		return appendIndexValue(nil, form(*q.valuePrefix))
+	}
+	return nil
+}
+
+// valueIndexUsable returns true if the value index the query would use is maintained
+func (q *QueryBuilder) valueIndexUsable(kc *KDB) bool {
+	if kc == nil {
+		return false
+	}
+	if q.foldCase {
+		return kc.valueFoldEnabled()
+	}
+	return kc.valueIndexEnabled()
+}
+
+// plan picks the cheapest access path for an operation, kc is nil when explaining in advance
+func (q *QueryBuilder) plan(kc *KDB, op string) QueryPlan {
+	p := QueryPlan{
+		Operation: op,
+		HashTypes: q.hashTypes,
+		SumPrefix: q.sumPrefix,
+		Order:     q.order,
+		Limit:     q.limit,
+
+		CaseInsensitive: q.foldCase,
+	}
+
+	switch {
+	case op == "count" && !q.filtersValues() && q.sumPrefix == "" && q.after == nil:
+		p.Access = AccessCounter
+	case q.valueIndexRange(kc) != nil && q.valueIndexUsable(kc) && !q.ordered && q.after == nil:
+		p.Access = AccessValueIndex
+		p.ReadsValues = true
+	case q.order.insertion() && (op == "run" || q.after != nil):
+		// Counting and deleting don't care about order unless they resume after a hash
+		p.Access = AccessInsertionIndex
+		p.ReadsValues = true
+	case op != "run" && !q.filtersValues():
+		p.Access = AccessKeyScan
+	default:
+		p.Access = AccessScan
+		p.ReadsValues = true
+	}
+	return p
+}
+
+// record keeps the plan of an execution for Explain
+func (q *QueryBuilder) record(p QueryPlan) {
+	q.mu.Lock()
+	q.last = &p
+	q.mu.Unlock()
+}
+
+// each calls fn for every hash the query selects, following plan, until fn returns false, the
+// limit is reached or ctx is done. Key scans pass hashes with only the key fields set
+func (q *QueryBuilder) each(ctx context.Context, kc *KDB, p QueryPlan, fn func(*Hash) bool) error {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	kc.ops.iterations.Add(1)
+
+	return kc.c.View(func(txn *badger.Txn) error {
+		hashTypes := q.hashTypes
+		if len(hashTypes) == 0 {
+			var err error
+			if hashTypes, err = kc.readHashTypes(txn); err != nil {
+				return fmt.Errorf("failed to get registered hash types: %w", err)
+			}
+		}
+		if q.after != nil {
+			if i := slices.Index(hashTypes, q.after.HashType); i >= 0 {
+				hashTypes = hashTypes[i:]
+			}
+		}
+
+		yielded := 0
+		var err error
+		match := q.valueMatcher(kc)
+		emit := func(hash *Hash) bool {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+			if p.ReadsValues && !match(hash.Value) {
+				return true
+			}
+			if !fn(hash) {
+				return false
+			}
+			yielded++
+			return q.limit <= 0 || yielded < q.limit
+		}
+
+		for _, hashType := range hashTypes {
+			stopped := false
+			visit := func(hash *Hash) bool {
+				stopped = !emit(hash)
+				return !stopped
+			}
+
+			switch p.Access {
+			case AccessValueIndex:
+				indexPrefix := valueIndexPrefix
+				if q.foldCase {
+					indexPrefix = valueFoldPrefix
+				}
+				prefix := append([]byte(kc.keys.key(indexPrefix, hashType)), q.valueIndexRange(kc)...)
+				sumPrefix := []byte(q.sumPrefix)
+				scanErr := kc.scanValueIndex(txn, hashType, q.foldCase, prefix, prefix, func(hash *Hash) bool {
+					if !bytes.HasPrefix(hexSum(hash.sum), sumPrefix) {
+						return true
+					}
+					return visit(hash)
+				})
+				if scanErr != nil {
+					return scanErr
+				}
+			default:
+				so := &ScanOptions{
+					KeysOnly:       p.Access == AccessKeyScan,
+					PrefetchValues: true,
+					Order:          q.order,
+				}
+				if q.order.insertion() && p.Access != AccessInsertionIndex {
+					so.Order = SumAsc
+				}
+				if q.after != nil && q.after.HashType == hashType {
+					so.After = q.after
+				}
+				if scanErr := kc.scan(txn, hashType, q.sumPrefix, nil, so, visit); scanErr != nil {
+					return scanErr
+				}
+			}
+
+			if stopped {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Run returns an iterator over the hashes q selects. Errors, including a cancelled ctx, end the
+// iteration and are logged
+func (kc *KDB) Run(ctx context.Context, q *QueryBuilder) iter.Seq[*Hash] {
+	return func(yield func(*Hash) bool) {
+		yield, recovery := guardYield(kc, "query", yield)
+		defer recovery()
+
+		p := q.plan(kc, "run")
+		q.record(p)
+
+		if err := q.each(ctx, kc, p, yield); err != nil {
+			kc.log(fmt.Sprintf("Query failed: %v", err), Error)
+		}
+	}
+}
+
+// Count returns the number of hashes q selects. Queries filtering on nothing but hash types are
+// answered from the counters, sum prefixes from the keys alone
+func (kc *KDB) Count(ctx context.Context, q *QueryBuilder) (int, error) {
+	p := q.plan(kc, "count")
+	q.record(p)
+
+	if p.Access == AccessCounter {
+		hashTypes := q.hashTypes
+		if len(hashTypes) == 0 {
+			var err error
+			if hashTypes, err = kc.getRegisteredHashTypes(); err != nil {
+				kc.log(fmt.Sprintf("Failed to get registered hash types: %v", err), Error)
+				return 0, fmt.Errorf("failed to get registered hash types: %w", err)
+			}
+		}
+
+		total := 0
+		for _, hashType := range hashTypes {
+			n, err := kc.HashesByType(hashType)
+			if err != nil && !isNotFound(err) {
+				return 0, fmt.Errorf("failed to read count for hash type %d: %w", hashType, err)
+			}
+			total += n
+		}
+		if q.limit > 0 {
+			total = min(total, q.limit)
+		}
+		return total, nil
+	}
+
+	count := 0
+	err := q.each(ctx, kc, p, func(*Hash) bool {
+		count++
+		return true
+	})
+	if err != nil {
+		kc.log(fmt.Sprintf("Query count failed: %v", err), Error)
+		return count, fmt.Errorf("query count failed: %w", err)
+	}
+	return count, nil
+}
+
+// Delete deletes the hashes q selects and updates the counters, or only counts them for a dry run.
+// Matches are collected first and deleted in chunks like PurgeByValue. Returns the number of
+// hashes deleted, or that would be deleted
+func (kc *KDB) Delete(ctx context.Context, q *QueryBuilder) (int, error) {
+	p := q.plan(kc, "delete")
+	q.record(p)
+
+	keys := make(map[uint64][][]byte)
+	var order []uint64
+	matched := 0
+	err := q.each(ctx, kc, p, func(hash *Hash) bool {
+		matched++
+		if q.dryRun {
+			return true
+		}
+		if _, ok := keys[hash.HashType]; !ok {
+			order = append(order, hash.HashType)
+		}
+		key := []byte(kc.keys.key(storedHashPrefix, hash.HashType, hash.Sum()))
+		keys[hash.HashType] = append(keys[hash.HashType], key)
+		return true
+	})
+	if err != nil {
+		kc.log(fmt.Sprintf("Query delete failed: %v", err), Error)
+		return 0, fmt.Errorf("query delete failed: %w", err)
+	}
+	if q.dryRun {
+		return matched, nil
+	}
+
+	deleted := 0
+	for _, hashType := range order {
+		n, err := kc.deleteKeys(ctx, hashType, keys[hashType])
+		deleted += n
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to delete hashes of hash type %d: %v", hashType, err), Error)
+			return deleted, fmt.Errorf("failed to delete hashes of hash type %d: %w", hashType, err)
+		}
+	}
+	return deleted, nil
+}
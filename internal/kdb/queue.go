@@ -0,0 +1,383 @@
+package kdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	queueItemPrefix  = "queue:item:%s"        // item id
+	queueReadyPrefix = "queue:ready:%s:%016x" // priority order, sequence
+	queueLeasePrefix = "queue:lease:%016x:%s" // lease expiry in unix nanos, item id
+	queueSeqKey      = "queue:seq"            // next sequence number
+
+	queueReadyScanPrefix = "queue:ready:" // used to scan the ready index in lease order
+	queueLeaseScanPrefix = "queue:lease:" // used to scan the lease index in expiry order
+)
+
+// QueueItem is a hash waiting to be cracked
+type QueueItem struct {
+	ID          string    `json:"id"` // hash_type:sum, pushing the same hash again updates its priority
+	HashType    uint64    `json:"hash_type"`
+	Hash        string    `json:"hash"`
+	Priority    int       `json:"priority"`
+	Seq         uint64    `json:"seq"` // Push order, breaks ties between equal priorities
+	EnqueuedAt  time.Time `json:"enqueued_at"`
+	LeasedUntil time.Time `json:"leased_until,omitzero"` // Zero while the item is waiting
+}
+
+// QueueStats counts the items in the work queue
+type QueueStats struct {
+	Pending int `json:"pending"` // Waiting to be leased
+	Leased  int `json:"leased"`  // Leased and within their ttl
+	Expired int `json:"expired"` // Leased past their ttl, handed out again by the next lease
+}
+
+// queueOrder returns the part of a ready key that sorts higher priorities first
+func queueOrder(priority int) string {
+	return fmt.Sprintf("%016x", ^(uint64(int64(priority)) ^ 1<<63))
+}
+
+// queueReadyKey returns the key of the ready index entry of a waiting item
+func (ks keyspace) queueReadyKey(item *QueueItem) []byte {
+	return []byte(ks.key(queueReadyPrefix, queueOrder(item.Priority), item.Seq))
+}
+
+// queueLeaseKey returns the key of the lease index entry of a leased item
+func (ks keyspace) queueLeaseKey(item *QueueItem) []byte {
+	return []byte(ks.key(queueLeasePrefix, item.LeasedUntil.UnixNano(), item.ID))
+}
+
+// queueID returns the id of the queue item for a hash and the key the hash is stored under,
+// captures of one account and copies of one ticket share an item, see lookupSums
+func (kc *KDB) queueID(hash string, hashType uint64) (string, []byte) {
+	hexSum := kc.lookupSums(hash, hashType)[0]
+	return fmt.Sprintf("%d:%s", hashType, hexSum), kc.keys.hashKey(hashType, hexSum)
+}
+
+// QueuePush adds an uncracked hash to the work queue. Higher priorities are leased first and
+// equal priorities in push order. Pushing a queued hash again changes its priority, pushing a hash
+// that is already stored with a value does nothing
+func (kc *KDB) QueuePush(hashType uint64, hash string, priority int) error {
+	if hash == "" {
+		return ErrEmptyHash
+	}
+
+	id, storedKey := kc.queueID(hash, hashType)
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	err := kc.c.Update(func(txn *badger.Txn) error {
+		if cracked, err := kc.storedHashCracked(txn, storedKey); err != nil || cracked {
+			return err
+		}
+
+		item, err := kc.getQueueItem(txn, id)
+		if err == nil {
+			if item.Priority == priority {
+				return nil
+			}
+			if item.LeasedUntil.IsZero() {
+				if err := txn.Delete(kc.keys.queueReadyKey(item)); err != nil {
+					return err
+				}
+			}
+			item.Priority = priority
+			return kc.putQueueItem(txn, item, item.LeasedUntil.IsZero())
+		}
+		if !isNotFound(err) {
+			return err
+		}
+
+		seq, err := kc.nextQueueSeq(txn)
+		if err != nil {
+			return err
+		}
+
+		item = &QueueItem{
+			ID:         id,
+			HashType:   hashType,
+			Hash:       canonicalHash(hash, hashType),
+			Priority:   priority,
+			Seq:        seq,
+			EnqueuedAt: time.Now().UTC(),
+		}
+		return kc.putQueueItem(txn, item, true)
+	})
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to push hash to queue: %v", err), Error)
+		return fmt.Errorf("failed to push hash to queue: %w", err)
+	}
+
+	return nil
+}
+
+// QueueLease leases up to n items to a worker for ttl. Leased items aren't handed out again until
+// their ttl runs out without a QueueAck, every lease happens in one transaction under the database
+// lock so concurrent workers never get the same item within a lease window
+func (kc *KDB) QueueLease(n int, ttl time.Duration) ([]QueueItem, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	var leased []QueueItem
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	err := kc.c.Update(func(txn *badger.Txn) error {
+		now := time.Now().UTC()
+		if err := kc.requeueExpired(txn, now); err != nil {
+			return err
+		}
+
+		ids, err := kc.readyQueueIDs(txn, n)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			item, err := kc.getQueueItem(txn, id)
+			if err != nil {
+				return err
+			}
+			if err := txn.Delete(kc.keys.queueReadyKey(item)); err != nil {
+				return err
+			}
+
+			item.LeasedUntil = now.Add(ttl)
+			if err := txn.Set(kc.keys.queueLeaseKey(item), []byte(item.ID)); err != nil {
+				return err
+			}
+			if err := kc.putQueueItem(txn, item, false); err != nil {
+				return err
+			}
+			leased = append(leased, *item)
+		}
+		return nil
+	})
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to lease queue items: %v", err), Error)
+		return nil, fmt.Errorf("failed to lease queue items: %w", err)
+	}
+
+	return leased, nil
+}
+
+// QueueAck removes items from the queue, leased or not. Unknown ids are ignored
+func (kc *KDB) QueueAck(ids []string) error {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	err := kc.c.Update(func(txn *badger.Txn) error {
+		for _, id := range ids {
+			if err := kc.ackQueueItem(txn, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to ack queue items: %v", err), Error)
+		return fmt.Errorf("failed to ack queue items: %w", err)
+	}
+
+	return nil
+}
+
+// QueueStats counts the pending, leased and expired items in the queue
+func (kc *KDB) QueueStats() (QueueStats, error) {
+	var stats QueueStats
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	err := kc.c.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		ready := []byte(kc.keys.key(queueReadyScanPrefix))
+		for it.Seek(ready); it.ValidForPrefix(ready); it.Next() {
+			stats.Pending++
+		}
+
+		now := time.Now().UnixNano()
+		lease := []byte(kc.keys.key(queueLeaseScanPrefix))
+		for it.Seek(lease); it.ValidForPrefix(lease); it.Next() {
+			if kc.keys.leaseExpiry(it.Item().Key()) <= now {
+				stats.Expired++
+			} else {
+				stats.Leased++
+			}
+		}
+		return nil
+	})
+
+	return stats, err
+}
+
+// getQueueItem reads a queue item
+func (kc *KDB) getQueueItem(txn *badger.Txn, id string) (*QueueItem, error) {
+	entry, err := txn.Get([]byte(kc.keys.key(queueItemPrefix, id)))
+	if err != nil {
+		return nil, err
+	}
+
+	item := &QueueItem{}
+	err = entry.Value(func(val []byte) error {
+		return json.Unmarshal(val, item)
+	})
+	return item, err
+}
+
+// putQueueItem writes a queue item, and its ready key if it's waiting to be leased
+func (kc *KDB) putQueueItem(txn *badger.Txn, item *QueueItem, ready bool) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if err := txn.Set([]byte(kc.keys.key(queueItemPrefix, item.ID)), data); err != nil {
+		return err
+	}
+	if ready {
+		return txn.Set(kc.keys.queueReadyKey(item), []byte(item.ID))
+	}
+	return nil
+}
+
+// ackQueueItem removes a queue item and its index keys
+func (kc *KDB) ackQueueItem(txn *badger.Txn, id string) error {
+	item, err := kc.getQueueItem(txn, id)
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	indexKey := kc.keys.queueReadyKey(item)
+	if !item.LeasedUntil.IsZero() {
+		indexKey = kc.keys.queueLeaseKey(item)
+	}
+	if err := txn.Delete(indexKey); err != nil {
+		return err
+	}
+	return txn.Delete([]byte(kc.keys.key(queueItemPrefix, id)))
+}
+
+// nextQueueSeq returns the next push sequence number
+func (kc *KDB) nextQueueSeq(txn *badger.Txn) (uint64, error) {
+	var seq uint64
+
+	entry, err := txn.Get([]byte(kc.keys.key(queueSeqKey)))
+	if err == nil {
+		err = entry.Value(func(val []byte) error {
+			seq, err = strconv.ParseUint(string(val), 10, 64)
+			return err
+		})
+	}
+	if err != nil && !isNotFound(err) {
+		return 0, err
+	}
+
+	return seq, txn.Set([]byte(kc.keys.key(queueSeqKey)), []byte(strconv.FormatUint(seq+1, 10)))
+}
+
+// readyQueueIDs returns the ids of the first n items waiting to be leased
+func (kc *KDB) readyQueueIDs(txn *badger.Txn, n int) ([]string, error) {
+	var ids []string
+	prefix := []byte(kc.keys.key(queueReadyScanPrefix))
+
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+
+	it := kc.newIterator(txn, opts)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix) && len(ids) < n; it.Next() {
+		id, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, string(id))
+	}
+
+	return ids, nil
+}
+
+// requeueExpired moves items whose lease ran out by now back to the ready index
+func (kc *KDB) requeueExpired(txn *badger.Txn, now time.Time) error {
+	var ids []string
+	prefix := []byte(kc.keys.key(queueLeaseScanPrefix))
+
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+
+	it := kc.newIterator(txn, opts)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		// Lease keys sort by expiry, the first one still valid ends the scan
+		if kc.keys.leaseExpiry(it.Item().Key()) > now.UnixNano() {
+			break
+		}
+		id, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			it.Close()
+			return err
+		}
+		ids = append(ids, string(id))
+	}
+	it.Close()
+
+	for _, id := range ids {
+		item, err := kc.getQueueItem(txn, id)
+		if err != nil {
+			return err
+		}
+		if err := txn.Delete(kc.keys.queueLeaseKey(item)); err != nil {
+			return err
+		}
+
+		item.LeasedUntil = time.Time{}
+		if err := kc.putQueueItem(txn, item, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// leaseExpiry parses the expiry out of a lease key
+func (ks keyspace) leaseExpiry(key []byte) int64 {
+	rest := bytes.TrimPrefix(key, []byte(ks.key(queueLeaseScanPrefix)))
+	if len(rest) < 16 {
+		return 0
+	}
+	expiry, _ := strconv.ParseUint(string(rest[:16]), 16, 64)
+	return int64(expiry)
+}
+
+// storedHashCracked reports whether the hash stored under key has a value
+func (kc *KDB) storedHashCracked(txn *badger.Txn, key []byte) (bool, error) {
+	entry, err := txn.Get(key)
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var hash Hash
+	err = entry.Value(func(val []byte) error {
+		return kc.decodeHash(val, &hash)
+	})
+	return hash.Value != "", err
+}
@@ -0,0 +1,238 @@
+package kdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// quotaMetaPrefix is the meta store prefix quotas are persisted under
+const quotaMetaPrefix = "quota:"
+
+// QuotaKind is the kind of scope a quota applies to
+type QuotaKind int
+
+const (
+	// QuotaHashType limits the number of hashes of a single hash type
+	QuotaHashType QuotaKind = iota
+	// QuotaDatabase limits the total number of hashes in the database
+	QuotaDatabase
+)
+
+// QuotaScope identifies what a quota limits. There is no namespace scope: the namespace of a
+// database is its Options.KeyPrefix, every database has exactly one and counts it under that
+// prefix, so a quota on the namespace is the DatabaseScope of the database opened with it
+type QuotaScope struct {
+	Kind     QuotaKind
+	HashType uint64 // Only used by QuotaHashType
+}
+
+// HashTypeScope returns the quota scope for a single hash type
+func HashTypeScope(hashType uint64) QuotaScope {
+	return QuotaScope{Kind: QuotaHashType, HashType: hashType}
+}
+
+// DatabaseScope returns the quota scope for the whole database
+func DatabaseScope() QuotaScope {
+	return QuotaScope{Kind: QuotaDatabase}
+}
+
+// String returns the scope as it is persisted, e.g. "type:1000" or "database"
+func (s QuotaScope) String() string {
+	if s.Kind == QuotaDatabase {
+		return "database"
+	}
+	return fmt.Sprintf("type:%d", s.HashType)
+}
+
+// parseQuotaScope parses a scope produced by QuotaScope.String
+func parseQuotaScope(s string) (QuotaScope, error) {
+	if s == "database" {
+		return DatabaseScope(), nil
+	}
+
+	typeStr, ok := strings.CutPrefix(s, "type:")
+	if !ok {
+		return QuotaScope{}, fmt.Errorf("unknown quota scope '%s'", s)
+	}
+
+	hashType, err := strconv.ParseUint(typeStr, 10, 64)
+	if err != nil {
+		return QuotaScope{}, fmt.Errorf("invalid hash type in quota scope '%s': %w", s, err)
+	}
+	return HashTypeScope(hashType), nil
+}
+
+// Quota is a limit on the number of hashes stored in a scope
+type Quota struct {
+	Scope QuotaScope
+	Limit uint64
+}
+
+// QuotaUsage reports how much of a quota is used
+type QuotaUsage struct {
+	Scope        string  `json:"scope"`
+	Limit        uint64  `json:"limit"`
+	Used         uint64  `json:"used"`
+	UsagePercent float64 `json:"usage_percent"`
+}
+
+// SetQuota limits the number of hashes that can be stored in scope.
+// The quota is persisted in the meta store and enforced by every store from now on
+func (kc *KDB) SetQuota(scope QuotaScope, limit uint64) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, limit)
+
+	if err := kc.SetMeta(quotaMetaPrefix+scope.String(), buf); err != nil {
+		kc.log(fmt.Sprintf("failed to persist quota for %s: %v", scope, err), Error)
+		return fmt.Errorf("failed to persist quota for %s: %w", scope, err)
+	}
+
+	kc.quotaMu.Lock()
+	kc.quotas[scope] = limit
+	kc.quotaMu.Unlock()
+
+	kc.log(fmt.Sprintf("Set quota for %s to %d", scope, limit), Info)
+	return nil
+}
+
+// RemoveQuota removes the quota for scope, if any
+func (kc *KDB) RemoveQuota(scope QuotaScope) error {
+	if err := kc.DeleteMeta(quotaMetaPrefix + scope.String()); err != nil {
+		kc.log(fmt.Sprintf("failed to remove quota for %s: %v", scope, err), Error)
+		return fmt.Errorf("failed to remove quota for %s: %w", scope, err)
+	}
+
+	kc.quotaMu.Lock()
+	delete(kc.quotas, scope)
+	kc.quotaMu.Unlock()
+
+	return nil
+}
+
+// ListQuotas returns every configured quota, ordered by scope
+func (kc *KDB) ListQuotas() []Quota {
+	kc.quotaMu.RLock()
+	defer kc.quotaMu.RUnlock()
+
+	quotas := make([]Quota, 0, len(kc.quotas))
+	for scope, limit := range kc.quotas {
+		quotas = append(quotas, Quota{Scope: scope, Limit: limit})
+	}
+
+	sort.Slice(quotas, func(i, j int) bool {
+		return quotas[i].Scope.String() < quotas[j].Scope.String()
+	})
+	return quotas
+}
+
+// QuotaUsage returns the usage of every configured quota
+func (kc *KDB) QuotaUsage() ([]QuotaUsage, error) {
+	quotas := kc.ListQuotas()
+	usage := make([]QuotaUsage, 0, len(quotas))
+
+	for _, q := range quotas {
+		used, err := kc.quotaUsed(q.Scope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read usage for %s: %w", q.Scope, err)
+		}
+
+		u := QuotaUsage{Scope: q.Scope.String(), Limit: q.Limit, Used: used}
+		if q.Limit > 0 {
+			u.UsagePercent = float64(used) / float64(q.Limit) * 100
+		}
+		usage = append(usage, u)
+	}
+
+	return usage, nil
+}
+
+// loadQuotas reads the persisted quotas into memory
+func (kc *KDB) loadQuotas() error {
+	entries, err := kc.listMeta(quotaMetaPrefix)
+	if err != nil {
+		return err
+	}
+
+	quotas := make(map[QuotaScope]uint64, len(entries))
+	for key, value := range entries {
+		scope, err := parseQuotaScope(strings.TrimPrefix(key, quotaMetaPrefix))
+		if err != nil || len(value) != 8 {
+			kc.log(fmt.Sprintf("ignoring invalid quota entry '%s'", key), Warning)
+			continue
+		}
+		quotas[scope] = binary.BigEndian.Uint64(value)
+	}
+
+	kc.quotaMu.Lock()
+	kc.quotas = quotas
+	kc.quotaMu.Unlock()
+
+	return nil
+}
+
+// quotaUsed reads the counter backing scope
+func (kc *KDB) quotaUsed(scope QuotaScope) (uint64, error) {
+	var (
+		count int
+		err   error
+	)
+
+	if scope.Kind == QuotaDatabase {
+		count, err = kc.TotalHashes()
+	} else {
+		count, err = kc.HashesByType(scope.HashType)
+	}
+
+	if err != nil {
+		// No counter yet means nothing has been stored in the scope
+		if isNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if count < 0 {
+		return 0, nil
+	}
+	return uint64(count), nil
+}
+
+// checkQuota returns a *QuotaExceededError if storing the given number of new hashes
+// per hash type would exceed a quota. Only counters are read, so this is cheap enough for every store
+func (kc *KDB) checkQuota(perType map[uint64]uint64) error {
+	kc.quotaMu.RLock()
+	defer kc.quotaMu.RUnlock()
+
+	if len(kc.quotas) == 0 {
+		return nil
+	}
+
+	var total uint64
+	for hashType, n := range perType {
+		total += n
+		if err := kc.checkScopeQuota(HashTypeScope(hashType), n); err != nil {
+			return err
+		}
+	}
+
+	return kc.checkScopeQuota(DatabaseScope(), total)
+}
+
+// checkScopeQuota checks a single scope, quotaMu must be held
+func (kc *KDB) checkScopeQuota(scope QuotaScope, n uint64) error {
+	limit, ok := kc.quotas[scope]
+	if !ok {
+		return nil
+	}
+
+	used, err := kc.quotaUsed(scope)
+	if err != nil {
+		return fmt.Errorf("failed to read usage for %s: %w", scope, err)
+	}
+	if used+n > limit {
+		return &QuotaExceededError{Scope: scope, Current: used, Limit: limit}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package kdb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestQuotaCountsOnlyNewHashes(t *testing.T) {
+	db := newTestDB(t, testPrefix, nil)
+	if err := db.SetQuota(HashTypeScope(0), 2); err != nil {
+		t.Fatalf("SetQuota: %v", err)
+	}
+	for i := range 2 {
+		if _, err := db.StoreHash(NewHash(testHash(i), "", 0)); err != nil {
+			t.Fatalf("store %d under the quota: %v", i, err)
+		}
+	}
+
+	// At the limit, stores of hashes already counted go through
+	if _, err := db.StoreHash(NewHash(testHash(0), "password", 0)); err != nil {
+		t.Errorf("re-storing with a value at the limit: %v", err)
+	}
+	res, err := db.StoreHashes([]*Hash{NewHash(testHash(0), "password", 0), NewHash(testHash(1), "letmein", 0)})
+	if err != nil || res.Updated != 2 {
+		t.Errorf("re-storing a batch at the limit: %+v %v", res, err)
+	}
+	report, err := db.ImportPotfile(strings.NewReader(testHash(1)+":Summer2024\n"), 0)
+	if err != nil || report.Imported != 1 {
+		t.Errorf("importing a stored hash at the limit: %+v %v", report, err)
+	}
+	if err := db.MarkCracked(testHash(1), 0, "changed"); err != nil {
+		t.Errorf("MarkCracked at the limit: %v", err)
+	}
+
+	// New hashes don't, alone or in a batch with stored ones
+	_, err = db.StoreHash(NewHash(testHash(2), "", 0))
+	var quotaErr *QuotaExceededError
+	if !errors.As(err, &quotaErr) || quotaErr.Current != 2 || quotaErr.Limit != 2 {
+		t.Errorf("a new hash past the limit returned %v", err)
+	}
+	_, err = db.StoreHashes([]*Hash{NewHash(testHash(0), "password", 0), NewHash(testHash(3), "", 0)})
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("a batch with a new hash past the limit returned %v", err)
+	}
+	if _, err := db.GetHashByOriginalHash(testHash(3), 0); err == nil {
+		t.Errorf("a rejected batch was written")
+	}
+	if n, _ := db.HashesByType(0); n != 2 {
+		t.Errorf("%d hashes counted, want 2", n)
+	}
+
+	// Deletes free quota
+	if err := db.DeleteHash(testHash(0), 0); err != nil {
+		t.Fatalf("DeleteHash: %v", err)
+	}
+	if _, err := db.StoreHash(NewHash(testHash(2), "", 0)); err != nil {
+		t.Errorf("a store after a delete freed quota: %v", err)
+	}
+}
+
+func TestQuotaDatabaseScope(t *testing.T) {
+	db := newTestDB(t, testPrefix, nil)
+	if err := db.SetQuota(DatabaseScope(), 3); err != nil {
+		t.Fatalf("SetQuota: %v", err)
+	}
+	hashes := make([]*Hash, 3)
+	for i := range hashes {
+		hashes[i] = NewHash(testHash(i), "", uint64(i%2)*NTLM)
+	}
+	if _, err := db.StoreHashes(hashes); err != nil {
+		t.Fatalf("filling the quota: %v", err)
+	}
+	if _, err := db.StoreHashes(hashes); err != nil {
+		t.Errorf("re-storing a full database: %v", err)
+	}
+	_, err := db.StoreHash(NewHash(testHash(9), "", NTLM))
+	if !errors.Is(err, ErrQuotaExceeded) || !strings.Contains(err.Error(), "database") {
+		t.Errorf("a new hash in a full database returned %v", err)
+	}
+
+	usage, err := db.QuotaUsage()
+	if err != nil || len(usage) != 1 || usage[0].Used != 3 || usage[0].UsagePercent != 100 {
+		t.Errorf("usage %+v: %v", usage, err)
+	}
+}
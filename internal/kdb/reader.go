@@ -0,0 +1,59 @@
+package kdb
+
+import "github.com/dgraph-io/badger/v4"
+
+// Reader reads hashes, meta values and counters within one read-only transaction, so the reads
+// agree with each other and cost one transaction instead of one each. KDB.ReadMulti and
+// Snapshot.Read pass it. A Reader is only valid inside the function it was passed to
+type Reader struct {
+	tx *Tx
+}
+
+// ReadMulti runs fn with a Reader over one read-only transaction, for a few reads that must agree
+// without the cost of a Snapshot. Fallback resolvers are not consulted. Other KDB methods must not
+// be called from fn
+func (kc *KDB) ReadMulti(fn func(r *Reader) error) error {
+	if kc.c == nil || kc.c.IsClosed() {
+		return ErrNotInitialized
+	}
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	return kc.c.View(func(txn *badger.Txn) error {
+		return fn(&Reader{tx: newTx(kc, txn)})
+	})
+}
+
+// GetHash returns a stored hash by its original hash.
+// Returns badger.ErrKeyNotFound if the hash is not stored
+func (r *Reader) GetHash(originalHash string, hashType uint64) (*Hash, error) {
+	return r.tx.GetHash(originalHash, hashType)
+}
+
+// GetHashBySum returns a stored hash by its SHA256 sum, raw or hex encoded. Anything else fails
+// with ErrInvalidSum
+func (r *Reader) GetHashBySum(sum string, hashType uint64) (*Hash, error) {
+	keySum, err := checkSum(sum)
+	if err != nil {
+		return nil, err
+	}
+	return r.tx.getHash(r.tx.kc.keys.hashKey(hashType, keySum))
+}
+
+// GetMeta reads a value from the meta store.
+// Returns badger.ErrKeyNotFound if the key has never been set
+func (r *Reader) GetMeta(key string) ([]byte, error) {
+	return r.tx.GetMeta(key)
+}
+
+// GetCount returns the counter of a hash type, like KDB.HashesByType.
+// Returns badger.ErrKeyNotFound if no hash of the type has been counted
+func (r *Reader) GetCount(hashType uint64) (int, error) {
+	return r.tx.kc.readTypeCount(r.tx.txn, hashType)
+}
+
+// GetTotal returns the counter of all hashes, like KDB.TotalHashes
+func (r *Reader) GetTotal() (int, error) {
+	return r.tx.kc.readShardedCount(r.tx.txn, r.tx.kc.keys.key(totalHashesKey))
+}
@@ -0,0 +1,91 @@
+package kdb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Every worker does a fixed sequence of operations, so runs differ only in scheduling
+const (
+	rywWorkers    = 8
+	rywIterations = 300
+	rywBatch      = 50
+	rywShared     = 16 // hashes overwritten by every worker in the contended test
+)
+
+// runWorkers calls fn from every worker and waits for them
+func runWorkers(fn func(w int)) {
+	var wg sync.WaitGroup
+	for w := range rywWorkers {
+		wg.Go(func() { fn(w) })
+	}
+	wg.Wait()
+}
+
+// rywDB opens a database with retries enough that a store running out of them stays rare, such a
+// store fails and isn't a missed write
+func rywDB(t *testing.T) *KDB {
+	return newTestDB(t, testPrefix, func(opts *Options) { opts.TxRetries = 20 })
+}
+
+func TestReadYourWrites(t *testing.T) {
+	db := rywDB(t)
+	runWorkers(func(w int) {
+		for i := range rywIterations {
+			hash := fmt.Sprintf("unique-%d-%d", w, i)
+			if _, err := db.StoreHash(NewHash(hash, "v", 0)); err != nil {
+				t.Errorf("store %s: %v", hash, err)
+				return
+			}
+			got, err := db.GetHashByOriginalHash(hash, 0)
+			if err != nil || got.Value != "v" {
+				t.Errorf("%s read %+v right after it was stored: %v", hash, got, err)
+			}
+		}
+	})
+}
+
+func TestReadYourBatchWrites(t *testing.T) {
+	db := rywDB(t)
+	runWorkers(func(w int) {
+		for i := range rywIterations / rywBatch {
+			batch := make([]*Hash, rywBatch)
+			for j := range batch {
+				batch[j] = NewHash(fmt.Sprintf("batch-%d-%d-%d", w, i, j), "v", 0)
+			}
+			if _, err := db.StoreHashes(batch); err != nil {
+				t.Errorf("store: %v", err)
+				return
+			}
+			for _, h := range batch {
+				if _, err := db.GetHashByOriginalHash(h.Hash, 0); err != nil {
+					t.Errorf("%s: %v right after its batch was stored", h.Hash, err)
+				}
+			}
+		}
+	})
+}
+
+func TestReadYourOverwrites(t *testing.T) {
+	// Other workers may overwrite a shared hash between the store and the read, so the read must
+	// find the hash with this worker's value or one another worker wrote
+	db := rywDB(t)
+	runWorkers(func(w int) {
+		for i := range rywIterations {
+			hash := fmt.Sprintf("shared-%d", (w*7+i)%rywShared)
+			if _, err := db.StoreHash(NewHash(hash, fmt.Sprintf("%s/%d/%d", hash, w, i), 0)); errors.Is(err, ErrTooMuchContention) {
+				continue
+			} else if err != nil {
+				t.Errorf("store %s: %v", hash, err)
+				return
+			}
+			got, err := db.GetHashByOriginalHash(hash, 0)
+			if err != nil || !strings.HasPrefix(got.Value, hash+"/") {
+				t.Errorf("%s read %+v, not a value written to it: %v", hash, got, err)
+			}
+		}
+	})
+}
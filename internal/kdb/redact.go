@@ -0,0 +1,110 @@
+package kdb
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ValueRedaction selects how exporters write cracked values
+type ValueRedaction int
+
+const (
+	RedactNone       ValueRedaction = iota // Values are written as stored
+	RedactMasked                           // Only the first and last character and the length are kept, e.g. "P******3"
+	RedactHashedOnly                       // Values are omitted, a cracked flag is written instead
+)
+
+// ExportOptions controls what the exporters write and how fast. Nil options export everything as
+// stored, as fast as the writer takes it. Redaction is applied while writing, stored hashes are
+// never modified. Redacted exports are meant for sharing, importing one stores the redacted values
+type ExportOptions struct {
+	Redaction ValueRedaction // How values are written
+	DropMeta  bool           // Leave out Hash.Meta, which may carry plaintext derived data
+	Canonical bool           // Write hashes in the canonical form they are keyed on instead of as submitted
+	RawValues bool           // Write values as stored, without Options.ValueDecoder, e.g. to move them still wrapped
+
+	// Progress is called with the rows written so far every ProgressInterval, 1 second if zero,
+	// and once more when the export ends. It is called without the database locked
+	Progress         func(written uint64)
+	ProgressInterval time.Duration
+
+	// RowsPerSecond and BytesPerSecond bound the rate rows are written at, 0 leaves it unbounded.
+	// Exports sleep between pages of rows for their share, so lookups on the same disk keep up
+	RowsPerSecond  int
+	BytesPerSecond int
+
+	// Context cancels the export. The rows written so far are flushed and the export returns
+	// ctx.Err(), the output ends with the last complete row. Nil never cancels
+	Context context.Context
+}
+
+// exportOptions returns the options passed to an exporter, or the defaults
+func exportOptions(opts []*ExportOptions) ExportOptions {
+	if len(opts) > 0 && opts[0] != nil {
+		return *opts[0]
+	}
+	return ExportOptions{}
+}
+
+// redact returns a copy of sh as it should be exported. The value of the copy is
+// masked or cleared depending on the redaction mode
+func (o ExportOptions) redact(sh *Hash) *Hash {
+	if o.Redaction == RedactNone && !o.DropMeta && !o.Canonical {
+		return sh
+	}
+
+	redacted := *sh
+	if o.Canonical {
+		redacted.Hash = sh.Canonical()
+	}
+	switch o.Redaction {
+	case RedactMasked:
+		redacted.Value = maskValue(sh.Value)
+	case RedactHashedOnly:
+		redacted.Value = ""
+	}
+	if o.DropMeta {
+		redacted.Meta = nil
+	}
+	return &redacted
+}
+
+// redactedHashJSON is the JSON form written for RedactHashedOnly. The value fields shadow
+// the ones of hashJSON so they are left out, and cracked says whether there was a value
+type redactedHashJSON struct {
+	hashJSON
+	Value    string `json:"value,omitempty"`
+	ValueRaw []byte `json:"value_raw,omitempty"`
+	Cracked  bool   `json:"cracked"`
+}
+
+// marshalJSON encodes sh for a JSON export
+func (o ExportOptions) marshalJSON(sh *Hash) ([]byte, error) {
+	redacted := o.redact(sh)
+	if o.Redaction != RedactHashedOnly {
+		return json.Marshal(redacted)
+	}
+	return json.Marshal(redactedHashJSON{hashJSON: redacted.toJSON(), Cracked: sh.Value != ""})
+}
+
+// maskValue keeps the first and last character of a value and replaces the rest with '*'.
+// Values of one or two characters are masked completely, every byte that isn't valid UTF-8 counts
+// as a character and is never kept
+func maskValue(value string) string {
+	runes := []rune(value)
+	if len(runes) <= 2 {
+		return strings.Repeat("*", len(runes))
+	}
+
+	first, last := runes[0], runes[len(runes)-1]
+	if first == utf8.RuneError {
+		first = '*'
+	}
+	if last == utf8.RuneError {
+		last = '*'
+	}
+	return string(first) + strings.Repeat("*", len(runes)-2) + string(last)
+}
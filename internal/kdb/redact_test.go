@@ -0,0 +1,136 @@
+package kdb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// redactionFixture stores cracked hashes of two types, every other value not valid UTF-8, with a
+// user and, with leakyMeta, a meta entry holding the value. Returns the database and the values
+func redactionFixture(t *testing.T, leakyMeta bool) (*KDB, []string) {
+	t.Helper()
+	db := newTestDB(t, testPrefix, nil)
+	var values []string
+	for i := range 20 {
+		value := fmt.Sprintf("Zq%dsecret%dpw", i, i)
+		if i%2 == 1 {
+			value = fmt.Sprintf("Zq%dsecret\xff%dpw", i, i)
+		}
+		h := NewHash(testHash(i), value, deltaTypes[i%2])
+		h.Salt = map[bool]string{true: "pepper"}[i%4 == 0]
+		h.Meta = map[string]string{UserMetaKey: fmt.Sprintf("user%d", i)}
+		if leakyMeta {
+			h.Meta["note"] = "was " + value
+		}
+		if _, err := db.StoreHash(h); err != nil {
+			t.Fatalf("store %d: %v", i, err)
+		}
+		values = append(values, value)
+	}
+	return db, values
+}
+
+// valueForms returns the forms a value can take in an export: as is, hex encoded, base64 encoded
+// and JSON escaped, and the same of the part masking hides
+func valueForms(value string) []string {
+	var forms []string
+	for _, v := range []string{value, value[1 : len(value)-1]} {
+		quoted, _ := json.Marshal(v)
+		forms = append(forms, v, hex.EncodeToString([]byte(v)), strings.ToUpper(hex.EncodeToString([]byte(v))),
+			base64.StdEncoding.EncodeToString([]byte(v)), strings.Trim(string(quoted), `"`))
+	}
+	return forms
+}
+
+// redactedExports returns every export of db with o, by name. Formats refusing the redaction
+// are left out
+func redactedExports(t *testing.T, db *KDB, o *ExportOptions) map[string][]byte {
+	t.Helper()
+	exports := make(map[string][]byte)
+	run := func(name string, fn func(*bytes.Buffer) error) {
+		var buf bytes.Buffer
+		err := fn(&buf)
+		if o.Redaction == RedactMasked && strings.HasPrefix(name, "potfile") {
+			if !errors.Is(err, ErrRedactionUnsupported) || buf.Len() != 0 {
+				t.Errorf("a masked potfile export wrote %d bytes: %v", buf.Len(), err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("the %s export: %v", name, err)
+		}
+		exports[name] = buf.Bytes()
+	}
+	for _, hashType := range deltaTypes {
+		run(fmt.Sprintf("jsonl %d", hashType), func(b *bytes.Buffer) error { _, err := db.ExportJSONL(b, hashType, o); return err })
+		run(fmt.Sprintf("csv %d", hashType), func(b *bytes.Buffer) error { _, err := db.ExportCSV(b, hashType, o); return err })
+		run(fmt.Sprintf("potfile %d", hashType), func(b *bytes.Buffer) error { _, err := db.ExportPotfile(b, hashType, o); return err })
+		run(fmt.Sprintf("hashtopolis %d", hashType), func(b *bytes.Buffer) error {
+			_, err := db.ExportHashtopolis(b, hashType, false, o)
+			return err
+		})
+	}
+	run("pwdump", func(b *bytes.Buffer) error { _, err := db.ExportPwdump(b, o); return err })
+	return exports
+}
+
+func TestRedactedExportsHoldNoValues(t *testing.T) {
+	for _, leakyMeta := range []bool{false, true} {
+		db, values := redactionFixture(t, leakyMeta)
+		for _, redaction := range []ValueRedaction{RedactMasked, RedactHashedOnly} {
+			// Meta only holding the value is dropped, meta without it is kept
+			o := &ExportOptions{Redaction: redaction, DropMeta: leakyMeta}
+			what := fmt.Sprintf("redaction %d, meta dropped %v", redaction, leakyMeta)
+
+			exports := redactedExports(t, db, o)
+			for name, data := range exports {
+				if len(data) == 0 {
+					t.Errorf("%s: the %s export is empty", what, name)
+				}
+				for _, value := range values {
+					for _, form := range valueForms(value) {
+						if bytes.Contains(data, []byte(form)) {
+							t.Errorf("%s: the %s export holds %q of %q", what, name, form, value)
+						}
+					}
+				}
+			}
+
+			jsonl := exports[fmt.Sprintf("jsonl %d", NTLM)]
+			if hasUser := bytes.Contains(jsonl, []byte(`"user1"`)); hasUser == leakyMeta {
+				t.Errorf("%s: the JSONL export has the meta %v", what, hasUser)
+			}
+			var wantValue string
+			if redaction == RedactMasked {
+				wantValue = `"value":"Z**********w"`
+			} else {
+				wantValue = `"cracked":true`
+			}
+			if !bytes.Contains(exports["jsonl 0"], []byte(wantValue)) {
+				t.Errorf("%s: the JSONL export lacks %s:\n%s", what, wantValue, exports["jsonl 0"])
+			}
+		}
+	}
+}
+
+func TestMaskValue(t *testing.T) {
+	for value, want := range map[string]string{
+		"":            "",
+		"a":           "*",
+		"ab":          "**",
+		"abc":         "a*c",
+		"Password1":   "P*******1",
+		"\xffabc\xfe": "*****",
+		"ünïcödé":     "ü*****é",
+	} {
+		if got := maskValue(value); got != want {
+			t.Errorf("%q masked as %q, want %q", value, got, want)
+		}
+	}
+}
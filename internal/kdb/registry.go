@@ -0,0 +1,362 @@
+package kdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// registryFormatVersion is the version of the JSON written by ExportRegistry
+const registryFormatVersion = 1
+
+// RegistryEntry is one hash type of the registry
+type RegistryEntry struct {
+	HashType  uint64    `json:"type"`
+	Name      string    `json:"name,omitempty"` // Short name for common hashcat modes, e.g. "ntlm"
+	FirstSeen time.Time `json:"first_seen"`     // When the type was registered, zero for types registered before this was kept
+	Count     int       `json:"count"`          // Hashes stored when the registry was read
+}
+
+// registryFile is the JSON document written by ExportRegistry
+type registryFile struct {
+	Version    int             `json:"version"`
+	ExportedAt time.Time       `json:"exported_at,omitzero"` // Written by earlier versions only, it made every export differ
+	HashTypes  []RegistryEntry `json:"hash_types"`
+}
+
+// Registry returns every registered hash type with its first-seen time and count, ordered by type
+func (kc *KDB) Registry() ([]RegistryEntry, error) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	var entries []RegistryEntry
+	err := kc.c.View(func(txn *badger.Txn) error {
+		hashTypes, err := kc.readHashTypes(txn)
+		if err != nil {
+			return err
+		}
+		slices.Sort(hashTypes)
+
+		for _, hashType := range hashTypes {
+			entry := RegistryEntry{HashType: hashType, Name: hashTypeNames[hashType]}
+			if entry.FirstSeen, err = kc.readFirstSeen(txn, hashType); err != nil {
+				return err
+			}
+			if entry.Count, err = kc.readTypeCount(txn, hashType); err != nil && !isNotFound(err) {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to read hash type registry: %v", err), Error)
+		return nil, fmt.Errorf("failed to read hash type registry: %w", err)
+	}
+	return entries, nil
+}
+
+// ExportRegistry writes the hash type registry to w as JSON, see Registry. Types are ordered by
+// type and no export time is written, so exports of an unchanged registry are identical
+func (kc *KDB) ExportRegistry(w io.Writer) error {
+	entries, err := kc.Registry()
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(registryFile{Version: registryFormatVersion, HashTypes: entries}); err != nil {
+		return fmt.Errorf("failed to write hash type registry: %w", err)
+	}
+	return nil
+}
+
+// ImportRegistry registers the hash types of a registry written by ExportRegistry, keeping the
+// earlier first-seen time of types registered on both sides. Counters always describe the records
+// stored here, so the exported counts are never applied: types without a local counter get a zero
+// one, local counts are left alone
+func (kc *KDB) ImportRegistry(r io.Reader) error {
+	var file registryFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return fmt.Errorf("failed to read hash type registry: %w", err)
+	}
+	if file.Version > registryFormatVersion {
+		return fmt.Errorf("registry format version %d is newer than %d, upgrade KrknDB to import it", file.Version, registryFormatVersion)
+	}
+
+	firstSeen := make(map[uint64]time.Time, len(file.HashTypes))
+	for _, entry := range file.HashTypes {
+		firstSeen[entry.HashType] = entry.FirstSeen
+	}
+	if err := kc.registerHashTypes(firstSeen); err != nil {
+		kc.log(fmt.Sprintf("Failed to import hash type registry: %v", err), Error)
+		return fmt.Errorf("failed to import hash type registry: %w", err)
+	}
+	return nil
+}
+
+// RegisterHashTypes registers hash types ahead of a bulk import, in one write. Stores of an
+// unregistered type add it to the registry in their own transaction, conflicting with every
+// concurrent store that does the same
+func (kc *KDB) RegisterHashTypes(hashTypes []uint64) error {
+	now := time.Now()
+	firstSeen := make(map[uint64]time.Time, len(hashTypes))
+	for _, hashType := range hashTypes {
+		firstSeen[hashType] = now
+	}
+	if err := kc.registerHashTypes(firstSeen); err != nil {
+		kc.log(fmt.Sprintf("Failed to register hash types: %v", err), Error)
+		return fmt.Errorf("failed to register hash types: %w", err)
+	}
+	return nil
+}
+
+// registerHashTypes registers every hash type of firstSeen, recording the given first-seen time
+// where it's earlier than the local one, and gives types without a counter a zero one
+func (kc *KDB) registerHashTypes(firstSeen map[uint64]time.Time) error {
+	return kc.retryConflicts("registry update", func() error {
+		kc.mu.Lock()
+		defer kc.mu.Unlock()
+
+		return kc.c.Update(func(txn *badger.Txn) error {
+			for hashType, seen := range firstSeen {
+				if err := kc.addHashType(txn, hashType); err != nil {
+					return err
+				}
+				if err := kc.recordFirstSeen(txn, hashType, seen); err != nil {
+					return err
+				}
+				if _, err := kc.readTypeCount(txn, hashType); isNotFound(err) {
+					err = kc.setTypeCount(txn, hashType, 0)
+					if err != nil {
+						return err
+					}
+				} else if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// recordFirstSeen sets the first-seen time of hashType to seen unless an earlier one is recorded
+func (kc *KDB) recordFirstSeen(txn *badger.Txn, hashType uint64, seen time.Time) error {
+	if seen.IsZero() {
+		return nil
+	}
+	recorded, err := kc.readFirstSeen(txn, hashType)
+	if err != nil {
+		return err
+	}
+	if !recorded.IsZero() && !seen.Before(recorded) {
+		return nil
+	}
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(seen.UnixNano()))
+	return txn.Set([]byte(kc.keys.key(firstSeenPrefix, hashType)), buf)
+}
+
+// readFirstSeen returns the first-seen time of hashType, zero if none is recorded
+func (kc *KDB) readFirstSeen(txn *badger.Txn, hashType uint64) (time.Time, error) {
+	item, err := txn.Get([]byte(kc.keys.key(firstSeenPrefix, hashType)))
+	if isNotFound(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var seen time.Time
+	err = item.Value(func(val []byte) error {
+		if len(val) != 8 {
+			return fmt.Errorf("malformed first-seen time of hash type %d", hashType)
+		}
+		seen = time.Unix(0, int64(binary.BigEndian.Uint64(val))).UTC()
+		return nil
+	})
+	return seen, err
+}
+
+// RebuildOptions tune RebuildRegistry
+type RebuildOptions struct {
+	// PruneEmpty unregisters types without a single stored hash, dropping their counters
+	PruneEmpty bool
+}
+
+// RebuildRegistry rebuilds the hash type registry from the keys actually stored, for data written
+// around KrknDB, such as restored with a low-level badger tool. The hash keys are scanned keys
+// only, types found there are registered and every type gets its counters recounted, the total
+// with them. Writes wait for the rebuild, so the counts it records are exact. Cancelling ctx
+// stops the scan before anything is written
+func (kc *KDB) RebuildRegistry(ctx context.Context, opts ...*RebuildOptions) error {
+	o := RebuildOptions{}
+	if len(opts) > 0 && opts[0] != nil {
+		o = *opts[0]
+	}
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+	kc.ops.recounts.Add(1)
+
+	var counts map[uint64]int
+	if err := kc.c.View(func(txn *badger.Txn) error {
+		var err error
+		counts, err = kc.storedHashTypes(ctx, txn)
+		return err
+	}); err != nil {
+		kc.log(fmt.Sprintf("Failed to scan for stored hash types: %v", err), Error)
+		return fmt.Errorf("failed to scan for stored hash types: %w", err)
+	}
+
+	var added, pruned []uint64
+	err := kc.c.Update(func(txn *badger.Txn) error {
+		registered, err := kc.readHashTypes(txn)
+		if err != nil {
+			return err
+		}
+
+		hashTypes := make([]uint64, 0, len(counts))
+		total := 0
+		for hashType, count := range counts {
+			hashTypes = append(hashTypes, hashType)
+			total += count
+			if !slices.Contains(registered, hashType) {
+				added = append(added, hashType)
+			}
+		}
+		for _, hashType := range registered {
+			if _, ok := counts[hashType]; ok {
+				continue
+			}
+			if o.PruneEmpty {
+				pruned = append(pruned, hashType)
+				if err := kc.dropHashType(txn, hashType); err != nil {
+					return err
+				}
+				continue
+			}
+			hashTypes = append(hashTypes, hashType)
+		}
+
+		if err := kc.writeHashTypes(txn, hashTypes); err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, hashType := range hashTypes {
+			if err := kc.recordFirstSeen(txn, hashType, now); err != nil {
+				return err
+			}
+			if err := kc.setTypeCount(txn, hashType, counts[hashType]); err != nil {
+				return err
+			}
+		}
+		return kc.setShardedCount(txn, kc.keys.key(totalHashesKey), total)
+	})
+	if err == nil {
+		err = kc.recountCracked(nil)
+	}
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to rebuild hash type registry: %v", err), Error)
+		return fmt.Errorf("failed to rebuild hash type registry: %w", err)
+	}
+
+	slices.Sort(added)
+	slices.Sort(pruned)
+	kc.log(fmt.Sprintf("Rebuilt hash type registry: %d hash types stored, registered %v, unregistered %v", len(counts), added, pruned), Info)
+	return nil
+}
+
+// storedHashTypes counts the hash keys of every type in txn, keys only. Hash keys are the only
+// keys of the keyspace that start with a digit, type:sum
+func (kc *KDB) storedHashTypes(ctx context.Context, txn *badger.Txn) (map[uint64]int, error) {
+	prefix := []byte(kc.keys.key(""))
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+	it := kc.newIterator(txn, opts)
+	defer it.Close()
+
+	counts := make(map[uint64]int)
+	visited := 0
+	for it.Seek(append(bytes.Clone(prefix), '0')); it.ValidForPrefix(prefix); it.Next() {
+		if visited++; visited%existsCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		rest := it.Item().Key()[len(prefix):]
+		if rest[0] < '0' || rest[0] > '9' {
+			break
+		}
+		i := bytes.IndexByte(rest, ':')
+		if i < 0 {
+			continue
+		}
+		hashType, err := strconv.ParseUint(string(rest[:i]), 10, 64)
+		if err != nil {
+			kc.log(fmt.Sprintf("Skipping malformed hash key %q", it.Item().Key()), Warning)
+			continue
+		}
+		counts[hashType]++
+	}
+	return counts, ctx.Err()
+}
+
+// dropHashType removes the first-seen time and counters of an unregistered hash type
+func (kc *KDB) dropHashType(txn *badger.Txn, hashType uint64) error {
+	if err := kc.setTypeCount(txn, hashType, 0); err != nil {
+		return err
+	}
+	for _, key := range []string{
+		kc.keys.key(hashTypeCountPrefix, hashType),
+		kc.keys.key(crackedCountPrefix, hashType),
+		kc.keys.key(firstSeenPrefix, hashType),
+	} {
+		if err := txn.Delete([]byte(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// warnUnregistered logs a warning when the registry is empty but hashes are stored, such as after
+// a restore around KrknDB. Lookups still find them, but counts and type scans miss them until
+// RebuildRegistry runs
+func (kc *KDB) warnUnregistered() error {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	return kc.c.View(func(txn *badger.Txn) error {
+		registered, err := kc.readHashTypes(txn)
+		if err != nil || len(registered) > 0 {
+			return err
+		}
+
+		prefix := []byte(kc.keys.key(""))
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		opts.PrefetchValues = false
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		it.Seek(append(bytes.Clone(prefix), '0'))
+		if it.ValidForPrefix(prefix) {
+			if c := it.Item().Key()[len(prefix)]; c >= '0' && c <= '9' {
+				kc.log("The hash type registry is empty but hashes are stored, run RebuildRegistry to register their types", Warning)
+			}
+		}
+		return nil
+	})
+}
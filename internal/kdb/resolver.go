@@ -0,0 +1,214 @@
+package kdb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	defaultFallbackTimeout = 5 * time.Second  // Per resolver timeout when Options.FallbackTimeout is 0
+	breakerThreshold       = 5                // Consecutive failures that open a resolver's circuit breaker
+	breakerCooldown        = 30 * time.Second // How long an open breaker skips its resolver
+	resolvedByMetaKey      = "resolved_by"    // Meta entry naming the resolver of a written back hash
+)
+
+// Resolver is a secondary source consulted when a lookup misses locally, see Options.Fallbacks.
+// A miss is reported as (nil, nil) or badger.ErrKeyNotFound, any other error counts as a failure
+type Resolver interface {
+	Resolve(ctx context.Context, hash string, hashType uint64) (*Hash, error)
+}
+
+// NamedResolver can be implemented by a Resolver to name itself in logs and in the
+// resolved_by metadata of written back hashes
+type NamedResolver interface {
+	Name() string
+}
+
+// fallback wraps a Resolver with its circuit breaker
+type fallback struct {
+	kc       *KDB
+	resolver Resolver
+	name     string
+
+	mu        sync.Mutex
+	failures  int       // consecutive failures
+	openUntil time.Time // the breaker skips the resolver until then
+}
+
+// newFallbacks wraps the resolvers of the options of kc
+func newFallbacks(kc *KDB, resolvers []Resolver) []*fallback {
+	fallbacks := make([]*fallback, 0, len(resolvers))
+	for _, r := range resolvers {
+		name := fmt.Sprintf("%T", r)
+		if named, ok := r.(NamedResolver); ok {
+			name = named.Name()
+		}
+		fallbacks = append(fallbacks, &fallback{kc: kc, resolver: r, name: name})
+	}
+	return fallbacks
+}
+
+// available reports whether the breaker lets a call through
+func (f *fallback) available(now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !now.Before(f.openUntil)
+}
+
+// record updates the breaker with the outcome of a call
+func (f *fallback) record(failed bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !failed {
+		f.failures = 0
+		return
+	}
+
+	f.failures++
+	if f.failures >= breakerThreshold {
+		f.openUntil = time.Now().Add(breakerCooldown)
+		f.failures = 0
+		f.kc.log(fmt.Sprintf("Resolver %s failed %d times in a row, skipping it for %v", f.name, breakerThreshold, breakerCooldown), Warning)
+	}
+}
+
+// call runs the resolver with a timeout, also for resolvers that ignore their context
+func (f *fallback) call(originalHash string, hashType uint64, timeout time.Duration) (*Hash, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	type result struct {
+		hash *Hash
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		defer func() {
+			if p := recover(); p != nil {
+				r.err = f.kc.panicked("fallback resolve", p)
+			}
+			done <- r
+		}()
+		r.hash, r.err = f.resolver.Resolve(ctx, originalHash, hashType)
+	}()
+
+	select {
+	case r := <-done:
+		return r.hash, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// resolve consults the fallbacks in order after a local miss. Returns badger.ErrKeyNotFound when
+// none of them knows the hash
+func (kc *KDB) resolve(originalHash string, hashType uint64) (*Hash, error) {
+	timeout := defaultFallbackTimeout
+	if kc.opts.FallbackTimeout > 0 {
+		timeout = kc.opts.FallbackTimeout
+	}
+
+	for _, f := range kc.fallbacks {
+		if !f.available(time.Now()) {
+			continue
+		}
+
+		hash, err := f.call(originalHash, hashType, timeout)
+
+		if err != nil && !isNotFound(err) {
+			f.record(true)
+			kc.log(fmt.Sprintf("Resolver %s failed: %v", f.name, err), Warning)
+			continue
+		}
+		f.record(false)
+		if hash == nil {
+			continue
+		}
+
+		// Key the answer the way a local store would, whatever the resolver filled in
+		resolved := *hash
+		resolved.Hash = submittedHash(originalHash, hashType)
+		resolved.HashType = hashType
+		resolved.db = kc
+		if resolved.CreatedAt.IsZero() {
+			resolved.CreatedAt = time.Now().UTC()
+		}
+		resolved.generateKey()
+
+		if kc.opts.FallbackWriteBack {
+			if err := kc.writeBack(&resolved, f.name); err != nil {
+				kc.log(fmt.Sprintf("Failed to write back hash resolved by %s: %v", f.name, err), Warning)
+			}
+		}
+		return &resolved, nil
+	}
+
+	return nil, badger.ErrKeyNotFound
+}
+
+// writeBack stores a hash returned by a resolver, tagged with the resolver's name and
+// expiring after Options.FallbackTTL if set
+func (kc *KDB) writeBack(sh *Hash, resolver string) error {
+	meta := make(map[string]string, len(sh.Meta)+1)
+	for k, v := range sh.Meta {
+		meta[k] = v
+	}
+	meta[resolvedByMetaKey] = resolver
+	sh.Meta = meta
+
+	if kc.opts.FallbackTTL <= 0 {
+		_, err := kc.storeHash(sh, nil)
+		return err
+	}
+
+	if err := kc.validateHash(sh); err != nil {
+		return err
+	}
+	kc.bindHash(sh)
+
+	if err := kc.markDirty(sh.HashType); err != nil {
+		return err
+	}
+
+	// Expired entries stay counted until the next recount
+	err := kc.updateLocked(func(txn *badger.Txn) error {
+		_, err := txn.Get(sh.Key)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		isNew := err != nil
+		if isNew {
+			if err := kc.checkQuota(map[uint64]uint64{sh.HashType: 1}); err != nil {
+				return err
+			}
+		}
+
+		if err := kc.indexHash(txn, sh); err != nil {
+			return err
+		}
+		data, err := kc.encodeHash(sh)
+		if err != nil {
+			return fmt.Errorf("failed to encode hash: %w", err)
+		}
+		if err := txn.SetEntry(badger.NewEntry(sh.Key, data).WithTTL(kc.opts.FallbackTTL)); err != nil {
+			return err
+		}
+		kc.dedup.forget(sh.Key)
+		if !isNew {
+			return nil
+		}
+		return kc.countNewHash(txn, sh.HashType)
+	})
+	if err != nil {
+		return err
+	}
+
+	kc.fireCracked(sh)
+	return nil
+}
@@ -0,0 +1,208 @@
+package kdb
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// retentionMetaPrefix is the meta store prefix retention policies are persisted under, followed
+// by the hash type
+const retentionMetaPrefix = "retention:"
+
+// RetentionPolicy is how long hashes of a type are kept, see SetRetention
+type RetentionPolicy struct {
+	HashType uint64        `json:"hash_type"`
+	MaxAge   time.Duration `json:"max_age"`
+}
+
+// RetentionResult is what ApplyRetention did to one hash type
+type RetentionResult struct {
+	HashType uint64        `json:"hash_type"`
+	MaxAge   time.Duration `json:"max_age"`
+	Cutoff   time.Time     `json:"cutoff"`            // Hashes created before it expired
+	Removed  int           `json:"removed"`           // Hashes deleted, or that would be for a dry run
+	Undated  int           `json:"undated,omitempty"` // Hashes kept as they were stored without CreatedAt
+}
+
+// RetentionReport is the result of ApplyRetention
+type RetentionReport struct {
+	At      time.Time         `json:"at"`
+	DryRun  bool              `json:"dry_run"`
+	Types   []RetentionResult `json:"types"`
+	Removed int               `json:"removed"` // Across every type
+}
+
+// SetRetention keeps the hashes of hashType for maxAge after they were created, ApplyRetention
+// and Options.RetentionInterval delete the older ones. The policy is persisted in the meta store
+// and replaces an earlier one of the type
+func (kc *KDB) SetRetention(hashType uint64, maxAge time.Duration) error {
+	if maxAge <= 0 {
+		return fmt.Errorf("retention for hash type %d must be positive, got %v", hashType, maxAge)
+	}
+	buf := binary.BigEndian.AppendUint64(nil, uint64(maxAge))
+	if err := kc.SetMeta(retentionMetaPrefix+strconv.FormatUint(hashType, 10), buf); err != nil {
+		kc.log(fmt.Sprintf("failed to persist retention for hash type %d: %v", hashType, err), Error)
+		return fmt.Errorf("failed to persist retention for hash type %d: %w", hashType, err)
+	}
+
+	kc.log(fmt.Sprintf("Set retention for hash type %d to %v", hashType, maxAge), Info)
+	return nil
+}
+
+// RemoveRetention removes the retention policy of hashType, its hashes are kept for good again
+func (kc *KDB) RemoveRetention(hashType uint64) error {
+	if err := kc.DeleteMeta(retentionMetaPrefix + strconv.FormatUint(hashType, 10)); err != nil {
+		kc.log(fmt.Sprintf("failed to remove retention for hash type %d: %v", hashType, err), Error)
+		return fmt.Errorf("failed to remove retention for hash type %d: %w", hashType, err)
+	}
+	return nil
+}
+
+// ListRetention returns every retention policy, ordered by hash type
+func (kc *KDB) ListRetention() ([]RetentionPolicy, error) {
+	entries, err := kc.listMeta(retentionMetaPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read retention policies: %w", err)
+	}
+
+	policies := make([]RetentionPolicy, 0, len(entries))
+	for key, value := range entries {
+		hashType, err := strconv.ParseUint(strings.TrimPrefix(key, retentionMetaPrefix), 10, 64)
+		if err != nil || len(value) != 8 {
+			kc.log(fmt.Sprintf("ignoring invalid retention entry '%s'", key), Warning)
+			continue
+		}
+		policies = append(policies, RetentionPolicy{HashType: hashType, MaxAge: time.Duration(binary.BigEndian.Uint64(value))})
+	}
+
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].HashType < policies[j].HashType
+	})
+	return policies, nil
+}
+
+// ApplyRetention deletes the hashes older than the retention policy of their type, judged by
+// CreatedAt. Types without a policy are left alone, and so are hashes stored before timestamps,
+// which are counted as undated. Expired hashes are deleted like PurgeByValue deletes them: in
+// chunks, with their index entries, counters updated and a tombstone each so delta exports carry
+// the deletes. A hash stored again since the scan is kept if it no longer expired. With
+// Options.NumVersionsToKeep above 1 the LSM tree is compacted afterwards so older versions go
+// too. A dry run only counts what would be deleted
+func (kc *KDB) ApplyRetention(ctx context.Context, dryRun bool) (RetentionReport, error) {
+	report := RetentionReport{At: time.Now().UTC(), DryRun: dryRun}
+	if kc.Nil() || kc.c.IsClosed() {
+		return report, ErrNotInitialized
+	}
+	policies, err := kc.ListRetention()
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to apply retention: %v", err), Error)
+		return report, err
+	}
+
+	for _, policy := range policies {
+		result := RetentionResult{HashType: policy.HashType, MaxAge: policy.MaxAge, Cutoff: report.At.Add(-policy.MaxAge)}
+		expired := func(stored *Hash) bool {
+			return !stored.CreatedAt.IsZero() && stored.CreatedAt.Before(result.Cutoff)
+		}
+
+		keys, undated, err := kc.expiredKeys(ctx, policy.HashType, expired)
+		result.Undated = undated
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to scan hash type %d: %v", policy.HashType, err), Error)
+			return report, fmt.Errorf("failed to scan hash type %d: %w", policy.HashType, err)
+		}
+
+		if dryRun {
+			result.Removed = len(keys)
+		} else {
+			result.Removed, err = kc.deleteKeysWhere(ctx, policy.HashType, keys, expired)
+		}
+		report.Types = append(report.Types, result)
+		report.Removed += result.Removed
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to apply retention to hash type %d: %v", policy.HashType, err), Error)
+			return report, fmt.Errorf("failed to apply retention to hash type %d: %w", policy.HashType, err)
+		}
+	}
+
+	if !dryRun && report.Removed > 0 && kc.opts != nil && kc.opts.NumVersionsToKeep > 1 {
+		if err := kc.c.Flatten(1); err != nil {
+			kc.log(fmt.Sprintf("Failed to compact expired versions: %v", err), Warning)
+		}
+	}
+
+	kc.log(fmt.Sprintf("Applied %d retention policies, %d hashes expired (dry run: %v)", len(policies), report.Removed, dryRun), Info)
+	return report, nil
+}
+
+// expiredKeys returns the keys of the hashes of hashType expired selects and how many hashes
+// carry no CreatedAt
+func (kc *KDB) expiredKeys(ctx context.Context, hashType uint64, expired func(*Hash) bool) ([][]byte, int, error) {
+	var keys [][]byte
+	undated := 0
+	prefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+
+	err := kc.viewLocked(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var hash Hash
+			if err := it.Item().Value(func(val []byte) error {
+				return kc.decodeHash(val, &hash)
+			}); err != nil {
+				continue
+			}
+			if hash.CreatedAt.IsZero() {
+				undated++
+				continue
+			}
+			if expired(&hash) {
+				keys = append(keys, it.Item().KeyCopy(nil))
+			}
+		}
+		return nil
+	})
+
+	return keys, undated, err
+}
+
+// StartRetention runs ApplyRetention as an OpRetention operation
+func (kc *KDB) StartRetention(dryRun bool) (uint64, error) {
+	return kc.StartOperation(OpRetention, "ApplyRetention", func(ctx context.Context, progress func(done, total int64)) error {
+		_, err := kc.ApplyRetention(ctx, dryRun)
+		progress(1, 1)
+		return err
+	})
+}
+
+// runRetentionSweeper starts a retention operation every interval until the database is closed
+func (kc *KDB) runRetentionSweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-kc.stop:
+			return
+		case <-ticker.C:
+			if _, err := kc.StartRetention(false); err != nil {
+				kc.log(fmt.Sprintf("failed to start the retention sweep: %v", err), Warning)
+			}
+		}
+	}
+}
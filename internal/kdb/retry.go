@@ -0,0 +1,56 @@
+package kdb
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	conflictBackoff    = time.Millisecond       // wait before the first retry, doubling with every attempt
+	conflictBackoffMax = 100 * time.Millisecond // longest wait between two retries
+)
+
+// txRetries returns how often a conflicting write is run again
+func (kc *KDB) txRetries() int {
+	if kc.opts != nil && kc.opts.TxRetries > 0 {
+		return kc.opts.TxRetries
+	}
+	return defaultTxRetries
+}
+
+// retryConflicts runs fn, one transaction taking kc.mu itself, again while it fails with
+// badger.ErrConflict, up to Options.TxRetries times. Waits between attempts back off
+// exponentially with full jitter so contending writers spread out. Once retries are exhausted the
+// error wraps ErrTooMuchContention as well as the conflict
+func (kc *KDB) retryConflicts(op string, fn func() error) error {
+	retries := kc.txRetries()
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = fn(); !errors.Is(err, badger.ErrConflict) {
+			return err
+		}
+		kc.ops.conflicts.Add(1)
+		if attempt == retries {
+			break
+		}
+
+		kc.log(fmt.Sprintf("%s conflicted, retrying (%d of %d)", op, attempt+1, retries), Debug)
+		time.Sleep(backoffJitter(attempt))
+	}
+
+	return fmt.Errorf("%w: %s failed after %d retries: %w", ErrTooMuchContention, op, retries, err)
+}
+
+// backoffJitter returns a random wait before retry attempt+1
+func backoffJitter(attempt int) time.Duration {
+	limit := conflictBackoffMax
+	if attempt < 8 {
+		limit = min(conflictBackoff<<attempt, conflictBackoffMax)
+	}
+	return rand.N(limit) + 1
+}
@@ -0,0 +1,101 @@
+package kdb
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// conflictingEngine refuses the commit of every conflictEvery-th update once armed with
+// badger.ErrConflict, after the transaction ran, as badger does when a concurrent write got in first
+type conflictingEngine struct {
+	Engine
+	conflictEvery atomic.Int64
+	updates       atomic.Int64
+}
+
+func (e *conflictingEngine) Update(fn func(txn *badger.Txn) error) error {
+	every := e.conflictEvery.Load()
+	if every == 0 {
+		return e.Engine.Update(fn)
+	}
+
+	txn := e.NewTransaction(true)
+	defer txn.Discard()
+	if err := fn(txn); err != nil {
+		return err
+	}
+	if e.updates.Add(1)%every == 0 {
+		return badger.ErrConflict
+	}
+	return txn.Commit()
+}
+
+func TestCounterContention(t *testing.T) {
+	engine := &conflictingEngine{}
+	db := newTestDB(t, testPrefix, func(opts *Options) {
+		opts.TxRetries = 10
+		opts.WrapEngine = func(e Engine) Engine {
+			engine.Engine = e
+			return engine
+		}
+	})
+	engine.conflictEvery.Store(3)
+
+	// Every writer adds to the counter of the same type, some take back one of their own
+	const writers, perWriter = 32, 40
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Go(func() {
+			for i := range perWriter {
+				n := w*perWriter + i
+				var err error
+				switch i % 4 {
+				case 0, 1:
+					_, err = db.StoreHash(NewHash(testHash(n), "", NTLM))
+				case 2:
+					_, err = db.StoreHashes([]*Hash{NewHash(testHash(n), "", NTLM)})
+				default:
+					err = db.Update(func(tx *Tx) error { return tx.StoreHash(NewHash(testHash(n), "", NTLM)) })
+					if err == nil {
+						err = db.DeleteHash(testHash(n-1), NTLM)
+					}
+				}
+				if err != nil {
+					t.Errorf("writer %d, write %d: %v", w, i, err)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	if db.ops.conflicts.Load() == 0 {
+		t.Fatalf("no write conflicted")
+	}
+	want := writers * perWriter * 3 / 4
+	if n, err := db.HashesByType(NTLM); err != nil || n != want {
+		t.Errorf("the counter reads %d, want %d: %v", n, want, err)
+	}
+	if n, err := db.TotalHashes(); err != nil || n != want {
+		t.Errorf("the total reads %d, want %d: %v", n, want, err)
+	}
+
+	// A write conflicting on every attempt gives up with ErrTooMuchContention
+	engine.conflictEvery.Store(1)
+	before := db.ops.conflicts.Load()
+	_, err := db.StoreHash(NewHash(testHash(writers*perWriter), "", NTLM))
+	if !errors.Is(err, ErrTooMuchContention) || !errors.Is(err, badger.ErrConflict) {
+		t.Errorf("a write conflicting every time returned %v", err)
+	}
+	if n := db.ops.conflicts.Load() - before; n != 11 {
+		t.Errorf("%d attempts conflicted, want 11", n)
+	}
+	engine.conflictEvery.Store(0)
+	if n, err := db.HashesByType(NTLM); err != nil || n != want {
+		t.Errorf("the given up write counted, the counter reads %d: %v", n, err)
+	}
+}
@@ -0,0 +1,312 @@
+package kdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// ScanOptions tunes the iteration APIs GetHashesByHashType, FindHashes and SearchHashesByPrefix.
+// Passing none uses DefaultScanOptions
+type ScanOptions struct {
+	// KeysOnly yields hashes built from their keys without reading or decrypting values. Keys hold
+	// the sum, not the original hash, so only Sum, HashType and Key are set. FindHashes also sets
+	// Hash to the searched string that matched. Insertion orders still read each record to skip
+	// index entries left behind by deletes, and searches for challenge-response captures to tell
+	// which captures an account record holds
+	KeysOnly bool
+	// PrefetchValues fetches values ahead of the iterator, ignored with KeysOnly
+	PrefetchValues bool
+	// PrefetchSize is how many values are fetched ahead, 0 uses badger's default of 100
+	PrefetchSize int
+	// Order is the order hashes are yielded in
+	Order Order
+	// Limit stops the iteration after this many hashes, 0 yields all
+	Limit int
+	// After resumes the iteration after this hash, the last one yielded by the previous page.
+	// Insertion orders need it as read from the database, a hash looked up with GetHashBySum will do
+	After *Hash
+	// Skipped collects the entries the scan passed over because their values failed to decode,
+	// such as corrupt or truncated records. Scans sharing the options add to it
+	Skipped *SkippedEntries
+
+	visited   *int            // counts the keys visited when set, for ExplainFind
+	ctx       context.Context // stops the scan once done when set, for FindHashesAllTypes
+	rawValues bool            // leaves values as stored, for ExportOptions.RawValues
+}
+
+// skippedKeysMax is how many keys SkippedEntries keeps
+const skippedKeysMax = 100
+
+// SkippedEntries reports the entries a scan skipped because they failed to decode
+type SkippedEntries struct {
+	Count int      // Entries skipped
+	Keys  [][]byte // Storage keys of the first few, for a closer look or a repair
+	Err   error    // The first decode error
+}
+
+// add records a skipped entry
+func (se *SkippedEntries) add(key []byte, err error) {
+	se.Count++
+	if len(se.Keys) < skippedKeysMax {
+		se.Keys = append(se.Keys, bytes.Clone(key))
+	}
+	if se.Err == nil {
+		se.Err = err
+	}
+}
+
+// DefaultScanOptions returns the options the iteration APIs use when none are passed:
+// full hashes in sum order with values prefetched in badger's default batches
+func DefaultScanOptions() *ScanOptions {
+	return &ScanOptions{
+		KeysOnly:       false,
+		PrefetchValues: true,
+		PrefetchSize:   0,
+		Order:          SumAsc,
+		Limit:          0,
+		After:          nil,
+	}
+}
+
+// scanOptions returns the first of opts, or the defaults if there is none
+func scanOptions(opts []*ScanOptions) *ScanOptions {
+	if len(opts) > 0 && opts[0] != nil {
+		return opts[0]
+	}
+	return DefaultScanOptions()
+}
+
+// visit counts a visited key and returns the error of the scan's context once it is done
+func (so *ScanOptions) visit() error {
+	if so.visited != nil {
+		*so.visited++
+	}
+	if so.ctx != nil {
+		return so.ctx.Err()
+	}
+	return nil
+}
+
+// decodeScanned deserializes a hash the scan read, see KDB.decodeHash and KDB.decodeRawHash
+func (kc *KDB) decodeScanned(so *ScanOptions, data []byte, sh *Hash) error {
+	if so.rawValues {
+		return kc.decodeRawHash(data, sh)
+	}
+	return kc.decodeHash(data, sh)
+}
+
+// skip records an entry under key that failed to decode with err, the scan moves on to the next one
+func (kc *KDB) skip(so *ScanOptions, skipped *int, key []byte, err error) {
+	kc.ops.undecodable.Add(1)
+	*skipped++
+	if so.Skipped != nil {
+		so.Skipped.add(key, err)
+	}
+}
+
+// reportSkipped logs how many entries of hashType a scan skipped, if any
+func (kc *KDB) reportSkipped(hashType uint64, skipped int) {
+	if skipped > 0 {
+		kc.log(fmt.Sprintf("Skipped %d undecodable hashes of type %d", skipped, hashType), Warning)
+	}
+}
+
+// iteratorOptions maps the scan options onto badger's iterator options for a scan of prefix
+func (so *ScanOptions) iteratorOptions(prefix []byte) badger.IteratorOptions {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = so.PrefetchValues && !so.KeysOnly
+	if so.PrefetchSize > 0 {
+		opts.PrefetchSize = so.PrefetchSize
+	}
+	opts.Reverse = so.Order.reverse()
+	return opts
+}
+
+// scanMatch filters hashes by their sum before any value is read. It returns the original hash
+// if it knows it, for KeysOnly results
+type scanMatch func(hexSum []byte) (string, bool)
+
+// scan yields the hashes of hashType whose sums start with sumPrefix and pass match, nil matches
+// everything, in the order and within the limits of so. Hashes that fail to decode are skipped,
+// counted in so.Skipped and logged once the scan ends
+func (kc *KDB) scan(txn *badger.Txn, hashType uint64, sumPrefix string, match scanMatch, so *ScanOptions, yield func(*Hash) bool) error {
+	skipped := 0
+	defer func() { kc.reportSkipped(hashType, skipped) }()
+
+	if match == nil {
+		match = func([]byte) (string, bool) { return "", true }
+	}
+
+	yielded := 0
+	emit := func(hash *Hash) bool {
+		if !yield(hash) {
+			return false
+		}
+		yielded++
+		return so.Limit <= 0 || yielded < so.Limit
+	}
+
+	if so.Order.insertion() {
+		return kc.scanInsertions(txn, hashType, sumPrefix, match, so, &skipped, emit)
+	}
+
+	typePrefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+	prefix := append(bytes.Clone(typePrefix), sumPrefix...)
+
+	var after []byte
+	if so.After != nil {
+		after = []byte(kc.keys.key(storedHashPrefix, hashType, so.After.Sum()))
+	}
+
+	it := kc.newIterator(txn, so.iteratorOptions(prefix))
+	defer it.Close()
+
+	for it.Seek(seekKey(prefix, after, so.Order.reverse())); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		if err := so.visit(); err != nil {
+			return err
+		}
+		if after != nil && bytes.Equal(item.Key(), after) {
+			continue
+		}
+
+		original, ok := match(item.Key()[len(typePrefix):])
+		if !ok {
+			continue
+		}
+
+		if so.KeysOnly {
+			hash := kc.keyOnlyHash(item.KeyCopy(nil), typePrefix, hashType)
+			hash.Hash = original
+			if !emit(hash) {
+				return nil
+			}
+			continue
+		}
+
+		var hash Hash
+		if err := item.Value(func(val []byte) error {
+			return kc.decodeScanned(so, val, &hash)
+		}); err != nil {
+			kc.skip(so, &skipped, item.Key(), err)
+			continue
+		}
+		if !emit(&hash) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// scanInsertions is scan over the insertion index. Entries whose hash is gone or was stored again
+// after a delete no longer match the sequence in the record and are skipped
+func (kc *KDB) scanInsertions(txn *badger.Txn, hashType uint64, sumPrefix string, match scanMatch, so *ScanOptions, skipped *int, emit func(*Hash) bool) error {
+	prefix := []byte(kc.keys.key(insertionScanPrefix, hashType))
+	typePrefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+
+	var after []byte
+	if so.After != nil {
+		after = kc.keys.insertionKey(hashType, so.After.seq, so.After.sum)
+	}
+
+	// Index entries have no values, records are read one by one
+	opts := so.iteratorOptions(prefix)
+	opts.PrefetchValues = false
+
+	it, err := kc.openIterator(txn, opts)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	for it.Seek(seekKey(prefix, after, so.Order.reverse())); it.ValidForPrefix(prefix); it.Next() {
+		key := it.Item().Key()
+		if err := so.visit(); err != nil {
+			return err
+		}
+		if after != nil && bytes.Equal(key, after) {
+			continue
+		}
+
+		// sequence:sum
+		entry := key[len(prefix):]
+		if len(entry) < 17 {
+			continue
+		}
+		var seq uint64
+		if _, err := fmt.Sscanf(string(entry[:16]), "%016x", &seq); err != nil {
+			continue
+		}
+		hexSum := entry[17:]
+		if !bytes.HasPrefix(hexSum, []byte(sumPrefix)) {
+			continue
+		}
+		original, ok := match(hexSum)
+		if !ok {
+			continue
+		}
+
+		hashKey := append(bytes.Clone(typePrefix), hexSum...)
+		if err := so.visit(); err != nil {
+			return err
+		}
+		item, err := txn.Get(hashKey)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var hash Hash
+		if err := item.Value(func(val []byte) error {
+			return kc.decodeScanned(so, val, &hash)
+		}); err != nil {
+			kc.skip(so, skipped, hashKey, err)
+			continue
+		}
+		if hash.seq != seq {
+			continue
+		}
+
+		if so.KeysOnly {
+			keyOnly := kc.keyOnlyHash(hashKey, typePrefix, hashType)
+			keyOnly.Hash = original
+			keyOnly.seq = seq
+			if !emit(keyOnly) {
+				return nil
+			}
+			continue
+		}
+		if !emit(&hash) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// seekKey returns where a scan of prefix starts: after the cursor if there is one, otherwise the
+// first key under prefix, or the last one for reverse scans
+func seekKey(prefix, after []byte, reverse bool) []byte {
+	if after != nil {
+		return after
+	}
+	if reverse {
+		return append(bytes.Clone(prefix), 0xff)
+	}
+	return prefix
+}
+
+// keyOnlyHash builds the hash a KeysOnly scan yields for a key of hashType, prefix is the
+// hash type's scan prefix
+func (kc *KDB) keyOnlyHash(key, prefix []byte, hashType uint64) *Hash {
+	return &Hash{
+		sum:      rawSum(key[len(prefix):]),
+		HashType: hashType,
+		Key:      key,
+		db:       kc,
+	}
+}
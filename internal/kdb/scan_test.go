@@ -0,0 +1,89 @@
+package kdb
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+func TestScansSkipUndecodableEntries(t *testing.T) {
+	var rec logRecorder
+	db := newTestDB(t, testPrefix, func(opts *Options) { opts.Logger = rec.log })
+	var hashes []string
+	for i := range 50 {
+		hashes = append(hashes, testHash(i))
+		if _, err := db.StoreHash(NewHash(testHash(i), "", 0)); err != nil {
+			t.Fatalf("store %d: %v", i, err)
+		}
+	}
+
+	// Garbage under the keys of three healthy records: unknown format, a truncated record and a
+	// record cut off in its first field
+	victims := map[int][]byte{7: []byte("\xffnot a record"), 23: {formatProto1, 0x0a, 0x40, 'a'}, 41: {formatProto1, 0x0a}}
+	var planted [][]byte
+	err := db.c.Update(func(txn *badger.Txn) error {
+		for i, garbage := range victims {
+			h, err := db.GetHashByOriginalHash(testHash(i), 0)
+			if err != nil {
+				return err
+			}
+			planted = append(planted, h.Key)
+			if err := txn.Set(h.Key, garbage); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("planting garbage: %v", err)
+	}
+	slices.SortFunc(planted, bytes.Compare)
+
+	check := func(what string, so *ScanOptions, seq func(*ScanOptions) []string) {
+		t.Helper()
+		so.Skipped = &SkippedEntries{}
+		before := rec.count("Skipped 3 undecodable hashes of type 0")
+		got := seq(so)
+		if len(got) != len(hashes)-len(victims) {
+			t.Errorf("%s yielded %d of %d healthy hashes", what, len(got), len(hashes)-len(victims))
+		}
+		for i := range victims {
+			if slices.Contains(got, testHash(i)) {
+				t.Errorf("%s yielded the garbage under %s", what, testHash(i))
+			}
+		}
+		keys := slices.Clone(so.Skipped.Keys)
+		slices.SortFunc(keys, bytes.Compare)
+		if so.Skipped.Count != len(victims) || so.Skipped.Err == nil || !slices.EqualFunc(keys, planted, bytes.Equal) {
+			t.Errorf("%s skipped %d, %v: %q", what, so.Skipped.Count, so.Skipped.Err, so.Skipped.Keys)
+		}
+		if rec.count("Skipped 3 undecodable hashes of type 0") != before+1 {
+			t.Errorf("%s didn't log the skipped entries once", what)
+		}
+	}
+
+	all := func(so *ScanOptions) []string {
+		var got []string
+		for h := range db.GetHashesByHashType(0, so) {
+			got = append(got, h.Hash)
+		}
+		return got
+	}
+	found := func(so *ScanOptions) []string {
+		var got []string
+		for h := range db.FindHashes(hashes, 0, so) {
+			got = append(got, h.Hash)
+		}
+		return got
+	}
+	for _, order := range []Order{SumAsc, SumDesc, InsertionAsc, InsertionDesc} {
+		check(order.String(), &ScanOptions{Order: order}, all)
+	}
+	check("FindHashes", DefaultScanOptions(), found)
+
+	if n := db.ops.undecodable.Load(); n != 5*uint64(len(victims)) {
+		t.Errorf("%d undecodable entries counted, want %d", n, 5*len(victims))
+	}
+}
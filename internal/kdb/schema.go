@@ -0,0 +1,122 @@
+package kdb
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// currentSchemaVersion is the on-disk format this build reads and writes. Databases created
+	// before the version was recorded are version 1, version 2 stores sums as raw digests and
+	// version 3 keeps the count of every type under hashTypeCountPrefix
+	currentSchemaVersion = 3
+
+	schemaVersionMetaKey   = "schema_version"    // Meta entry holding the schema version
+	migrationCursorMetaKey = "migration_cursor:" // Meta key prefix of migration progress, followed by the migration name
+
+	compactSumsMigration = "compact_sums"
+)
+
+// migration upgrades a database from schema version to-1 to version to
+type migration struct {
+	to   int
+	name string
+	run  func(kc *KDB) error
+}
+
+// migrations upgrade older databases in order of their target version. A migration that can be
+// interrupted keeps its progress with saveMigrationCursor and must be safe to run again from it
+var migrations = []migration{
+	{to: 2, name: compactSumsMigration, run: compactSums},
+	{to: 3, name: "type_counters", run: recountTypeCounters},
+}
+
+// SchemaVersion returns the schema version recorded in the database
+func (kc *KDB) SchemaVersion() (int, error) {
+	value, err := kc.GetMeta(schemaVersionMetaKey)
+	if isNotFound(err) {
+		return 1, nil // Written before the version was recorded
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(value) != 4 {
+		return 0, fmt.Errorf("invalid schema version record of %d bytes", len(value))
+	}
+	return int(binary.BigEndian.Uint32(value)), nil
+}
+
+// setSchemaVersion records the schema version in the database
+func (kc *KDB) setSchemaVersion(version int) error {
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, uint32(version))
+	return kc.SetMeta(schemaVersionMetaKey, value)
+}
+
+// checkSchema records the schema version of a new database and brings older ones up to date.
+// Without Options.AutoMigrate an older database is rejected with ErrMigrationRequired, one newer
+// than this build with ErrUnsupportedSchema
+func (kc *KDB) checkSchema() error {
+	if kc.isNew {
+		return kc.setSchemaVersion(currentSchemaVersion)
+	}
+
+	version, err := kc.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case version == currentSchemaVersion:
+		return nil
+	case version > currentSchemaVersion:
+		return fmt.Errorf("%w: database is at schema version %d, this build supports up to %d", ErrUnsupportedSchema, version, currentSchemaVersion)
+	case !kc.opts.AutoMigrate:
+		return fmt.Errorf("%w: database is at schema version %d, this build needs %d, set Options.AutoMigrate to upgrade it", ErrMigrationRequired, version, currentSchemaVersion)
+	}
+
+	return kc.migrate(version)
+}
+
+// migrate runs the migrations from version up to the current schema. The version is recorded after
+// every migration, so an interrupted upgrade continues with the migration that didn't finish
+func (kc *KDB) migrate(version int) error {
+	for _, m := range migrations {
+		if m.to <= version {
+			continue
+		}
+
+		kc.log(fmt.Sprintf("Migrating database to schema version %d: %s", m.to, m.name), Info)
+		if err := m.run(kc); err != nil {
+			return fmt.Errorf("migration to schema version %d (%s) failed: %w", m.to, m.name, err)
+		}
+
+		if err := kc.setSchemaVersion(m.to); err != nil {
+			return err
+		}
+		if err := kc.DeleteMeta(migrationCursorMetaKey + m.name); err != nil {
+			return err
+		}
+		version = m.to
+	}
+
+	if version != currentSchemaVersion {
+		return fmt.Errorf("no migration from schema version %d to %d", version, currentSchemaVersion)
+	}
+	return nil
+}
+
+// loadMigrationCursor returns the progress saved by an interrupted run of a migration, nil if it
+// hasn't saved any
+func (kc *KDB) loadMigrationCursor(name string) ([]byte, error) {
+	cursor, err := kc.GetMeta(migrationCursorMetaKey + name)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	return cursor, err
+}
+
+// saveMigrationCursor saves the progress of a running migration
+func (kc *KDB) saveMigrationCursor(name string, cursor []byte) error {
+	return kc.SetMeta(migrationCursorMetaKey+name, cursor)
+}
@@ -0,0 +1,124 @@
+package kdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// v1Hash is a hash as schema version 1 stored it, JSON with the hex sum
+type v1Hash struct {
+	Hash     string `json:"hash"`
+	Sum      []byte `json:"sum"`
+	Value    string `json:"value"`
+	HashType uint64 `json:"hash_type"`
+	Key      []byte `json:"key"`
+}
+
+// v1Key returns the key schema version 1 stored hash under
+func v1Key(hash string, hashType uint64) []byte {
+	return []byte(fmt.Sprintf("krkn:%d:%s", hashType, util.SHA256Sum(hash)))
+}
+
+// writeV1Database writes hashes to dir laid out as schema version 1 did, before the version,
+// identity markers or the known value were recorded: JSON records under hex sums, the count of
+// every type under krkn:<type> and a registry of the types
+func writeV1Database(t *testing.T, dir string, hashes []*Hash) {
+	t.Helper()
+
+	db, err := badger.Open(badger.DefaultOptions(dir).WithEncryptionKey(testKey).WithIndexCacheSize(1 << 20).WithLogger(nil))
+	if err != nil {
+		t.Fatalf("failed to create a version 1 database: %v", err)
+	}
+	defer db.Close()
+
+	counts := make(map[uint64]uint64)
+	err = db.Update(func(txn *badger.Txn) error {
+		for _, h := range hashes {
+			key := v1Key(h.Hash, h.HashType)
+			data, err := json.Marshal(v1Hash{Hash: h.Hash, Sum: util.SHA256Sum(h.Hash), Value: h.Value, HashType: h.HashType, Key: key})
+			if err != nil {
+				return err
+			}
+			if err := txn.Set(key, data); err != nil {
+				return err
+			}
+			counts[h.HashType]++
+		}
+
+		var registry []byte
+		for hashType, n := range counts {
+			registry = binary.BigEndian.AppendUint64(registry, hashType)
+			if err := txn.Set([]byte(fmt.Sprintf("krkn:%d", hashType)), binary.BigEndian.AppendUint64(nil, n)); err != nil {
+				return err
+			}
+		}
+		if err := txn.Set([]byte("krkn:registry:hash_types"), registry); err != nil {
+			return err
+		}
+		return txn.Set([]byte("krkn:total_hashes"), binary.BigEndian.AppendUint64(nil, uint64(len(hashes))))
+	})
+	if err != nil {
+		t.Fatalf("failed to write a version 1 database: %v", err)
+	}
+}
+
+// v1Hashes are the hashes of the version 1 databases of the tests, 3 md5 and 2 NTLM
+func v1Hashes() []*Hash {
+	return []*Hash{
+		NewHash(testHash(0), "password", 0),
+		NewHash(testHash(1), "", 0),
+		NewHash(testHash(2), "letmein", 0),
+		NewHash(testHash(3), "Summer2024", NTLM),
+		NewHash(testHash(4), "", NTLM),
+	}
+}
+
+func TestOpenVersion1(t *testing.T) {
+	dir := t.TempDir()
+	writeV1Database(t, dir, v1Hashes())
+
+	if _, err := openTestDB(t, dir, DefaultKeyPrefix, nil); !errors.Is(err, ErrMigrationRequired) {
+		t.Fatalf("opening without AutoMigrate returned %v", err)
+	}
+	db, err := openTestDB(t, dir, DefaultKeyPrefix, func(opts *Options) { opts.AutoMigrate = true })
+	if err != nil {
+		t.Fatalf("opening with AutoMigrate: %v", err)
+	}
+
+	if version, err := db.SchemaVersion(); err != nil || version != currentSchemaVersion {
+		t.Errorf("upgraded to schema version %d: %v", version, err)
+	}
+	for hashType, want := range map[uint64]int{0: 3, NTLM: 2} {
+		if n, err := db.HashesByType(hashType); err != nil || n != want {
+			t.Errorf("hash type %d counts %d, want %d: %v", hashType, n, want, err)
+		}
+	}
+	if total, err := db.TotalHashes(); err != nil || total != 5 {
+		t.Errorf("total %d: %v", total, err)
+	}
+	for _, h := range v1Hashes() {
+		got, err := db.GetHashByOriginalHash(h.Hash, h.HashType)
+		if err != nil || got.Value != h.Value {
+			t.Errorf("%s of type %d came back as %+v: %v", h.Hash, h.HashType, got, err)
+		}
+	}
+
+	// The counters of version 1 are gone, the one of type 0 looked like a hash key
+	err = db.c.View(func(txn *badger.Txn) error {
+		for _, hashType := range []uint64{0, NTLM} {
+			if _, err := txn.Get([]byte(db.keys.key(hashTypeLookupPrefix, hashType))); !isNotFound(err) {
+				return fmt.Errorf("the counter of hash type %d is still there: %v", hashType, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
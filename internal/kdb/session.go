@@ -0,0 +1,305 @@
+package kdb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"iter"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	// Session sets, see StartSession. The header holds when the set was last written to, members
+	// have no value
+	sessionHeaderPrefix     = "session:%s"           // session id
+	sessionHeaderScanPrefix = "session:"             // used to sweep every session
+	sessionMemberPrefix     = "session_set:%s:%d:%s" // session id, hash_type, sum
+	sessionSetPrefix        = "session_set:%s:"      // session id, used to scan one set
+	sessionSetScanPrefix    = "session_set:"         // used to leave every set out of backups
+
+	defaultSessionTTL = 24 * time.Hour
+)
+
+// Session records the sums of the hashes stored through it in a set of its own, so the hashes a
+// long import touched can be read back with IterateSession without scanning their types. Sets hold
+// keys only and aren't counted, exported or backed up. A set outlives its session until
+// DropSessionSet, or until SweepSessions finds it idle for Options.SessionTTL, which also cleans up
+// after a process that crashed mid-import
+type Session struct {
+	kc *KDB
+	id string
+}
+
+// sessionRegistry counts the open sessions by id, SweepSessions leaves their sets alone
+type sessionRegistry struct {
+	mu   sync.Mutex
+	open map[string]int
+}
+
+// StartSession starts recording into the set of id, "" picks a random id, see Session. Starting a
+// session with the id of an existing set adds to it. Ids can't contain colons
+func (kc *KDB) StartSession(id string) (*Session, error) {
+	if kc.Nil() || kc.c.IsClosed() {
+		return nil, ErrNotInitialized
+	}
+	if id == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("failed to pick a session id: %w", err)
+		}
+		id = hex.EncodeToString(b)
+	}
+	if strings.ContainsAny(id, ":\x00") {
+		return nil, fmt.Errorf("invalid session id %q: contains a colon or NUL", id)
+	}
+
+	s := &Session{kc: kc, id: id}
+	kc.sessions.mu.Lock()
+	if kc.sessions.open == nil {
+		kc.sessions.open = make(map[string]int)
+	}
+	kc.sessions.open[id]++
+	kc.sessions.mu.Unlock()
+
+	if err := s.record(nil); err != nil {
+		s.Close()
+		kc.log(fmt.Sprintf("Failed to start session %s: %v", id, err), Error)
+		return nil, fmt.Errorf("failed to start session %s: %w", id, err)
+	}
+	return s, nil
+}
+
+// ID returns the id of the set the session records into
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Close ends the session, its set stays until DropSessionSet or SweepSessions
+func (s *Session) Close() {
+	s.kc.sessions.mu.Lock()
+	defer s.kc.sessions.mu.Unlock()
+	if s.kc.sessions.open[s.id]--; s.kc.sessions.open[s.id] <= 0 {
+		delete(s.kc.sessions.open, s.id)
+	}
+}
+
+// StoreHashes is KDB.StoreHashes, recording the hashes in the set of the session
+func (s *Session) StoreHashes(hashes []*Hash) (StoreResult, error) {
+	return withDefaultTimeout(s.kc, func(ctx context.Context) (StoreResult, error) {
+		return s.StoreHashesContext(ctx, hashes)
+	})
+}
+
+// StoreHashesContext is KDB.StoreHashesContext, recording the hashes in the set of the session
+func (s *Session) StoreHashesContext(ctx context.Context, hashes []*Hash) (StoreResult, error) {
+	if err := s.record(hashes); err != nil {
+		return StoreResult{}, err
+	}
+	return s.kc.StoreHashesContext(ctx, hashes)
+}
+
+// IngestStream is KDB.IngestStream, recording the hashes imported in the set of the session
+func (s *Session) IngestStream(ctx context.Context, r io.Reader, format Format, hashType uint64, progress func(IngestProgress)) error {
+	im := s.kc.newImporter()
+	im.session = s
+	_, err := s.kc.ingest(ctx, im, r, format, hashType, progress)
+	return err
+}
+
+// record adds hashes to the set and marks it written to now. Hashes are recorded before they are
+// stored, a crash in between leaves sums in the set that IterateSession skips
+func (s *Session) record(hashes []*Hash) error {
+	kc := s.kc
+	for _, sh := range hashes {
+		kc.bindHash(sh)
+	}
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	wb := kc.c.NewWriteBatch()
+	defer wb.Cancel()
+	for _, sh := range hashes {
+		if err := wb.Set([]byte(kc.keys.key(sessionMemberPrefix, s.id, sh.HashType, sh.Sum())), nil); err != nil {
+			return fmt.Errorf("failed to record session hashes: %w", err)
+		}
+	}
+	touched := binary.BigEndian.AppendUint64(nil, uint64(time.Now().UnixNano()))
+	if err := wb.Set([]byte(kc.keys.key(sessionHeaderPrefix, s.id)), touched); err != nil {
+		return fmt.Errorf("failed to record session hashes: %w", err)
+	}
+	if err := wb.Flush(); err != nil {
+		return fmt.Errorf("failed to record session hashes: %w", err)
+	}
+	return nil
+}
+
+// IterateSession returns an iterator over the hashes recorded in the set of sessionID, read as they
+// are stored now, ordered by type and sum. Hashes deleted since, or that failed to store, are skipped
+func (kc *KDB) IterateSession(sessionID string) iter.Seq[*Hash] {
+	return func(yield func(*Hash) bool) {
+		yield, recovery := guardYield(kc, "session iteration", yield)
+		defer recovery()
+
+		kc.mu.Lock()
+		defer kc.mu.Unlock()
+		kc.ops.iterations.Add(1)
+
+		prefix := []byte(kc.keys.key(sessionSetPrefix, sessionID))
+		err := kc.c.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = prefix
+			opts.PrefetchValues = false
+			it := kc.newIterator(txn, opts)
+			defer it.Close()
+
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				// hash_type:sum, laid out as the hash key
+				key := append([]byte(kc.keys.key("")), it.Item().Key()[len(prefix):]...)
+				item, err := txn.Get(key)
+				if isNotFound(err) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+
+				var hash Hash
+				if err := item.Value(func(val []byte) error {
+					return kc.decodeHash(val, &hash)
+				}); err != nil {
+					kc.log(fmt.Sprintf("Skipping undecodable hash %q of session %s: %v", key, sessionID, err), Warning)
+					continue
+				}
+				if !yield(&hash) {
+					return nil
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to iterate session %s: %v", sessionID, err), Error)
+		}
+	}
+}
+
+// DropSessionSet deletes the set of sessionID and returns how many sums it held
+func (kc *KDB) DropSessionSet(sessionID string) (int, error) {
+	if kc.Nil() || kc.c.IsClosed() {
+		return 0, ErrNotInitialized
+	}
+	n, err := kc.dropSessionSet(sessionID)
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to drop session set %s: %v", sessionID, err), Error)
+		return n, fmt.Errorf("failed to drop session set %s: %w", sessionID, err)
+	}
+	return n, nil
+}
+
+// dropSessionSet deletes the members of a set, then its header
+func (kc *KDB) dropSessionSet(sessionID string) (int, error) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	prefix := []byte(kc.keys.key(sessionSetPrefix, sessionID))
+	var keys [][]byte
+	err := kc.c.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		opts.PrefetchValues = false
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	wb := kc.c.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range keys {
+		if err := wb.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+	if err := wb.Delete([]byte(kc.keys.key(sessionHeaderPrefix, sessionID))); err != nil {
+		return 0, err
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// SweepSessions drops the sets not written to for Options.SessionTTL whose session isn't open,
+// such as those left by a process that crashed mid-import, and returns how many it dropped. New
+// sweeps once the database is open
+func (kc *KDB) SweepSessions() (int, error) {
+	if kc.Nil() || kc.c.IsClosed() {
+		return 0, ErrNotInitialized
+	}
+	ttl := kc.opts.SessionTTL
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	cutoff := time.Now().Add(-ttl)
+
+	var idle []string
+	prefix := []byte(kc.keys.key(sessionHeaderScanPrefix))
+	err := kc.viewLocked(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var touched time.Time
+			if err := it.Item().Value(func(val []byte) error {
+				if len(val) == 8 {
+					touched = time.Unix(0, int64(binary.BigEndian.Uint64(val)))
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			if touched.Before(cutoff) {
+				idle = append(idle, string(it.Item().Key()[len(prefix):]))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to sweep session sets: %v", err), Error)
+		return 0, fmt.Errorf("failed to sweep session sets: %w", err)
+	}
+
+	dropped := 0
+	for _, id := range idle {
+		kc.sessions.mu.Lock()
+		open := kc.sessions.open[id] > 0
+		kc.sessions.mu.Unlock()
+		if open {
+			continue
+		}
+		if _, err := kc.dropSessionSet(id); err != nil {
+			kc.log(fmt.Sprintf("Failed to drop session set %s: %v", id, err), Error)
+			return dropped, fmt.Errorf("failed to drop session set %s: %w", id, err)
+		}
+		dropped++
+	}
+	if dropped > 0 {
+		kc.log(fmt.Sprintf("Dropped %d session sets idle for over %v", dropped, ttl), Info)
+	}
+	return dropped, nil
+}
@@ -0,0 +1,181 @@
+package kdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	// defaultCounterShards is how many keys a counter is spread over when Options.CounterShards
+	// is 0
+	defaultCounterShards = 8
+
+	// counterShardSuffix follows the key of a sharded counter in the keys of its shards past 0:
+	// num:<hash_type>:<shard> and total_hashes:<shard>. Shard 0 is the counter key itself
+	counterShardSuffix = ":%d"
+)
+
+// counterShards returns how many shards writers spread a counter over
+func (kc *KDB) counterShards() int {
+	if kc.opts != nil && kc.opts.CounterShards > 0 {
+		return kc.opts.CounterShards
+	}
+	return defaultCounterShards
+}
+
+// counterShardKey returns the key of a shard of the counter at key. Shard 0 is the key databases
+// written before sharding count in
+func counterShardKey(key string, shard int) []byte {
+	if shard == 0 {
+		return []byte(key)
+	}
+	return []byte(key + fmt.Sprintf(counterShardSuffix, shard))
+}
+
+// shardValue encodes a shard. Shards are signed, a hash stored through one shard may be deleted
+// through another
+func shardValue(n int) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(int64(n)))
+	return buf
+}
+
+// addShardedCount adds delta to a random shard of the counter at key within txn, so concurrent
+// writers rarely touch the same key
+func (kc *KDB) addShardedCount(txn *badger.Txn, key string, delta int) error {
+	shard := counterShardKey(key, rand.IntN(kc.counterShards()))
+
+	var n int
+	if _, err := txn.Get(shard); err == nil {
+		if n, err = readCount(txn, string(shard)); err != nil {
+			return err
+		}
+	} else if !isNotFound(err) {
+		return err
+	}
+	return txn.Set(shard, shardValue(n+delta))
+}
+
+// readShardedCount sums the shards of the counter at key within txn. Every shard is read, also
+// those past Options.CounterShards written with a larger setting. Returns badger.ErrKeyNotFound if
+// nothing was ever counted
+func (kc *KDB) readShardedCount(txn *badger.Txn, key string) (int, error) {
+	found := false
+	total, err := readCount(txn, key)
+	if err == nil {
+		found = true
+	} else if !isNotFound(err) {
+		return 0, err
+	}
+
+	prefix := []byte(key + ":")
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := kc.newIterator(txn, opts)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		n, err := readCount(txn, string(it.Item().Key()))
+		if err != nil {
+			return 0, err
+		}
+		total += n
+		found = true
+	}
+
+	if !found {
+		return 0, badger.ErrKeyNotFound
+	}
+	return max(total, 0), nil
+}
+
+// setShardedCount sets the counter at key to count within txn: shard 0 holds it and the other
+// shards are removed
+func (kc *KDB) setShardedCount(txn *badger.Txn, key string, count int) error {
+	prefix := []byte(key + ":")
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+
+	var shards [][]byte
+	it := kc.newIterator(txn, opts)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		shards = append(shards, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+
+	for _, shard := range shards {
+		if err := txn.Delete(shard); err != nil {
+			return err
+		}
+	}
+	return txn.Set([]byte(key), countValue(count))
+}
+
+// updateShardedCount adds delta to the counter at key
+func (kc *KDB) updateShardedCount(key string, delta int) error {
+	return kc.retryConflicts("counter update", func() error {
+		kc.mu.Lock()
+		defer kc.mu.Unlock()
+
+		return kc.c.Update(func(txn *badger.Txn) error {
+			return kc.addShardedCount(txn, key, delta)
+		})
+	})
+}
+
+// recordShardedCount sets the counter at key to count, used by recounts
+func (kc *KDB) recordShardedCount(key string, count int) error {
+	return kc.retryConflicts("counter update", func() error {
+		kc.mu.Lock()
+		defer kc.mu.Unlock()
+
+		return kc.c.Update(func(txn *badger.Txn) error {
+			return kc.setShardedCount(txn, key, count)
+		})
+	})
+}
+
+// shardedCount reads the counter at key, see readShardedCount
+func (kc *KDB) shardedCount(key string) (int, error) {
+	var count int
+	err := kc.c.View(func(txn *badger.Txn) error {
+		var err error
+		count, err = kc.readShardedCount(txn, key)
+		return err
+	})
+	return count, err
+}
+
+// addTypeCount adds delta to the counter of hashType within txn
+func (kc *KDB) addTypeCount(txn *badger.Txn, hashType uint64, delta int) error {
+	return kc.addShardedCount(txn, kc.keys.key(hashTypeCountPrefix, hashType), delta)
+}
+
+// readTypeCount sums the counter of hashType within txn, see readShardedCount
+func (kc *KDB) readTypeCount(txn *badger.Txn, hashType uint64) (int, error) {
+	return kc.readShardedCount(txn, kc.keys.key(hashTypeCountPrefix, hashType))
+}
+
+// setTypeCount sets the counter of hashType to count within txn
+func (kc *KDB) setTypeCount(txn *badger.Txn, hashType uint64, count int) error {
+	return kc.setShardedCount(txn, kc.keys.key(hashTypeCountPrefix, hashType), count)
+}
+
+// updateTypeCount adds delta to the counter of hashType
+func (kc *KDB) updateTypeCount(hashType uint64, delta int) error {
+	return kc.updateShardedCount(kc.keys.key(hashTypeCountPrefix, hashType), delta)
+}
+
+// recordTypeCount sets the counter of hashType to count, used by recounts
+func (kc *KDB) recordTypeCount(hashType uint64, count int) error {
+	return kc.recordShardedCount(kc.keys.key(hashTypeCountPrefix, hashType), count)
+}
+
+// typeCount reads the counter of hashType
+func (kc *KDB) typeCount(hashType uint64) (int, error) {
+	return kc.shardedCount(kc.keys.key(hashTypeCountPrefix, hashType))
+}
@@ -0,0 +1,141 @@
+package kdb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// shardKeys returns how many shards of the counter at key are written
+func shardKeys(t testing.TB, db *KDB, key string) int {
+	n := 0
+	err := db.c.View(func(txn *badger.Txn) error {
+		if _, err := txn.Get([]byte(key)); err == nil {
+			n++
+		}
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(key + ":")
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to scan the shards of %s: %v", key, err)
+	}
+	return n
+}
+
+func TestTotalCountSharded(t *testing.T) {
+	db := newTestDB(t, testPrefix, nil)
+	for i := range 64 {
+		if _, err := db.StoreHash(NewHash(testHash(i), "", uint64(i%2)*NTLM)); err != nil {
+			t.Fatalf("StoreHash: %v", err)
+		}
+	}
+	if _, err := db.StoreHashes([]*Hash{NewHash(testHash(64), "", 0), NewHash(testHash(0), "", 0)}); err != nil {
+		t.Fatalf("StoreHashes: %v", err)
+	}
+	if err := db.DeleteHash(testHash(1), NTLM); err != nil {
+		t.Fatalf("DeleteHash: %v", err)
+	}
+
+	total := db.keys.key(totalHashesKey)
+	if n := shardKeys(t, db, total); n < 2 {
+		t.Errorf("the total is kept in %d shards", n)
+	}
+	if n, err := db.TotalHashes(); err != nil || n != 64 {
+		t.Errorf("TotalHashes returned %d, want 64: %v", n, err)
+	}
+
+	if err := db.PerformRecount(); err != nil {
+		t.Fatalf("PerformRecount: %v", err)
+	}
+	if n := shardKeys(t, db, total); n != 1 {
+		t.Errorf("the recount left %d shards of the total", n)
+	}
+	if n, err := db.TotalHashes(); err != nil || n != 64 {
+		t.Errorf("TotalHashes after the recount returned %d, want 64: %v", n, err)
+	}
+}
+
+// benchWriters is how many goroutines BenchmarkCounterShards writes from
+const benchWriters = 32
+
+// runWriters calls write b.N times spread over benchWriters goroutines
+func runWriters(b *testing.B, write func(i int) error) {
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for range benchWriters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := next.Add(1); i <= int64(b.N); i = next.Add(1) {
+				if err := write(int(i)); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	b.StopTimer()
+}
+
+// BenchmarkCounterShards counts hashes from 32 concurrent writers with one shard per counter, the
+// default and one per writer. The txn runs add to the type and total counters in raw transactions
+// that overlap and report how many conflicted, more shards keep more of them apart. The StoreHash
+// runs go through the database, whose writers take kc.mu and never overlap, so they don't
+// conflict whatever the setting
+func BenchmarkCounterShards(b *testing.B) {
+	for _, shards := range []int{1, defaultCounterShards, benchWriters} {
+		b.Run(fmt.Sprintf("txn/shards=%d", shards), func(b *testing.B) {
+			db := newTestDB(b, testPrefix, func(opts *Options) { opts.CounterShards = shards })
+			total := db.keys.key(totalHashesKey)
+
+			var committed, conflicts atomic.Int64
+			runWriters(b, func(int) error {
+				err := db.c.Update(func(txn *badger.Txn) error {
+					if err := db.addTypeCount(txn, 0, 1); err != nil {
+						return err
+					}
+					return db.addShardedCount(txn, total, 1)
+				})
+				switch {
+				case errors.Is(err, badger.ErrConflict):
+					conflicts.Add(1)
+				case err != nil:
+					return err
+				default:
+					committed.Add(1)
+				}
+				return nil
+			})
+
+			if n, err := db.TotalHashes(); err != nil || n != int(committed.Load()) {
+				b.Fatalf("counted %d of %d committed writes: %v", n, committed.Load(), err)
+			}
+			b.ReportMetric(float64(conflicts.Load())/float64(b.N), "conflicts/op")
+		})
+
+		b.Run(fmt.Sprintf("StoreHash/shards=%d", shards), func(b *testing.B) {
+			db := newTestDB(b, testPrefix, func(opts *Options) { opts.CounterShards = shards })
+			runWriters(b, func(i int) error {
+				_, err := db.StoreHash(NewHash(testHash(i), "", 0))
+				return err
+			})
+
+			if n, err := db.TotalHashes(); err != nil || n != b.N {
+				b.Fatalf("counted %d of %d hashes: %v", n, b.N, err)
+			}
+			b.ReportMetric(float64(db.ops.conflicts.Load())/float64(b.N), "conflicts/op")
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package kdb
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// FindSimilarValues returns an iterator over the cracked hashes whose value is within maxDistance
+// edits (Levenshtein, in characters) of value, with the distance, for finding variants such as
+// "Summer2023!" next to "Summer2024!". It scans the values of hashType, or of every registered
+// type if it is nil, so it is expensive on large databases. Values of very different length are
+// skipped without comparing them. The iteration ends when ctx is done
+func (kc *KDB) FindSimilarValues(ctx context.Context, value string, maxDistance int, hashType *uint64) iter.Seq2[*Hash, int] {
+	return func(yield func(*Hash, int) bool) {
+		yield, recovery := guardYield2(kc, "similarity search", yield)
+		defer recovery()
+
+		if maxDistance < 0 {
+			return
+		}
+		value := kc.normalizeValue(value)
+
+		kc.mu.Lock()
+		defer kc.mu.Unlock()
+		kc.ops.iterations.Add(1)
+
+		err := kc.c.View(func(txn *badger.Txn) error {
+			var hashTypes []uint64
+			if hashType != nil {
+				hashTypes = []uint64{*hashType}
+			} else {
+				var err error
+				if hashTypes, err = kc.readHashTypes(txn); err != nil {
+					return fmt.Errorf("failed to get registered hash types: %w", err)
+				}
+				hashTypes = slices.Sorted(slices.Values(hashTypes))
+			}
+
+			var err error
+			stopped := false
+			for _, ht := range hashTypes {
+				scanErr := kc.scan(txn, ht, "", nil, DefaultScanOptions(), func(hash *Hash) bool {
+					if err = ctx.Err(); err != nil {
+						return false
+					}
+					if hash.Value == "" {
+						return true
+					}
+					distance, ok := util.EditDistance(value, hash.Value, maxDistance)
+					if !ok {
+						return true
+					}
+					stopped = !yield(hash, distance)
+					return !stopped
+				})
+				if scanErr != nil {
+					return scanErr
+				}
+				if err != nil || stopped {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to search similar values: %v", err), Error)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package kdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// SizeEstimate describes the estimated disk usage of a single hash type
+type SizeEstimate struct {
+	Entries          uint64  `json:"entries"`            // Number of entries of this type
+	SampledEntries   uint64  `json:"sampled_entries"`    // Number of entries whose size was measured
+	EstimatedBytes   uint64  `json:"estimated_bytes"`    // Estimated key + value bytes for the whole type
+	AverageEntrySize float64 `json:"average_entry_size"` // Average key + value size of the sampled entries
+	Exact            bool    `json:"exact"`              // True if every entry was measured
+	ExpectedSize     int     `json:"expected_size"`      // Typical entry size of ticket types, kept in the value log
+	ValueLog         bool    `json:"value_log"`          // Entries are kept in the value log, EstimatedBytes is mostly value log rather than LSM tree
+}
+
+// EstimateSizeByType estimates the disk usage of every registered hash type.
+//
+// sampleFraction (0, 1] controls how much of each type is measured. Keys are ordered by
+// the SHA256 sum of the hash, so the first entries of a type are effectively a uniform
+// random sample and only that leading fraction of the keyspace is visited. Values are
+// never fetched; sizes come from badger's per-item size estimate.
+// A fraction of 1 (or anything outside (0, 1]) measures every entry.
+func (kc *KDB) EstimateSizeByType(ctx context.Context, sampleFraction float64) (map[uint64]SizeEstimate, error) {
+	if sampleFraction <= 0 || sampleFraction > 1 {
+		sampleFraction = 1
+	}
+
+	hashTypes, err := kc.getRegisteredHashTypes()
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to get registered hash types: %v", err), Error)
+		return nil, fmt.Errorf("failed to get registered hash types: %w", err)
+	}
+
+	estimates := make(map[uint64]SizeEstimate, len(hashTypes))
+	for _, hashType := range hashTypes {
+		estimate, err := kc.estimateTypeSize(ctx, hashType, sampleFraction)
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to estimate size of hash type %d: %v", hashType, err), Error)
+			return nil, fmt.Errorf("failed to estimate size of hash type %d: %w", hashType, err)
+		}
+		estimates[hashType] = estimate
+	}
+
+	return estimates, nil
+}
+
+// estimateTypeSize measures the leading sampleFraction of a hash type's keys
+func (kc *KDB) estimateTypeSize(ctx context.Context, hashType uint64, sampleFraction float64) (SizeEstimate, error) {
+	var estimate SizeEstimate
+
+	// The counter tells us how far to scan. Without it we have to measure everything
+	entries, err := kc.HashesByType(hashType)
+	limit := uint64(math.MaxUint64)
+	if err == nil && entries > 0 && sampleFraction < 1 {
+		limit = uint64(math.Ceil(float64(entries) * sampleFraction))
+	}
+
+	var sampledBytes uint64
+	prefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+
+	err = kc.viewLocked(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false // Sizes are available from the key side
+		opts.Prefix = prefix
+
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix) && estimate.SampledEntries < limit; it.Next() {
+			if estimate.SampledEntries%1024 == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			sampledBytes += uint64(it.Item().EstimatedSize())
+			estimate.SampledEntries++
+		}
+		return nil
+	})
+
+	if err != nil {
+		return estimate, err
+	}
+
+	estimate.Exact = estimate.SampledEntries < limit
+	if estimate.Exact {
+		// We ran out of keys before reaching the limit, so we saw them all
+		estimate.Entries = estimate.SampledEntries
+	} else {
+		estimate.Entries = uint64(entries)
+	}
+
+	if estimate.SampledEntries > 0 {
+		estimate.AverageEntrySize = float64(sampledBytes) / float64(estimate.SampledEntries)
+	}
+	estimate.EstimatedBytes = uint64(estimate.AverageEntrySize * float64(estimate.Entries))
+
+	// Ticket types are large from the first entry on, plan for them before any are stored
+	if size, ok := kc.valueLogRecordSize(hashType); ok {
+		estimate.ExpectedSize = size
+		estimate.ValueLog = true
+	} else if estimate.SampledEntries > 0 {
+		estimate.ValueLog = estimate.AverageEntrySize >= float64(kc.valueThreshold())
+	}
+
+	return estimate, nil
+}
+
+// valueThreshold returns the value threshold badger was opened with
+func (kc *KDB) valueThreshold() int64 {
+	if kc.opts == nil {
+		return defaultOptions().ValueThreshold
+	}
+	return badgerValueThreshold(kc.opts)
+}
+
+// valueLogRecordSize returns the expected record size of hashType and whether its records are kept
+// in the value log
+func (kc *KDB) valueLogRecordSize(hashType uint64) (int, bool) {
+	size, ok := expectedRecordSizes[hashType]
+	return size, ok && int64(size) >= kc.valueThreshold()
+}
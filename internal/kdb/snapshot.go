@@ -0,0 +1,198 @@
+package kdb
+
+import (
+	"iter"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Snapshot is a read-only view of the database pinned to the moment it was taken. Writes made after
+// that are invisible to it, so several reads through one snapshot always agree with each other.
+//
+// Badger keeps every version a snapshot can still see until the snapshot is released, value log GC
+// and compaction can't reclaim them in the meantime. Release snapshots as soon as the reads are done.
+// A Snapshot is safe for concurrent use
+type Snapshot struct {
+	tx *Tx
+
+	mu       sync.Mutex
+	idle     *sync.Cond // signalled when the last running read finishes
+	active   int        // running reads, Release waits for them
+	released bool
+	err      error // first error an iteration stopped on, see Err
+}
+
+// Snapshot takes a snapshot of the database. Call Release when done with it
+func (kc *KDB) Snapshot() (*Snapshot, error) {
+	if kc.c == nil || kc.c.IsClosed() {
+		return nil, ErrNotInitialized
+	}
+	s := &Snapshot{tx: newTx(kc, kc.c.NewTransaction(false))}
+	s.idle = sync.NewCond(&s.mu)
+	return s, nil
+}
+
+// Release releases the snapshot, letting badger reclaim the versions it pinned. It waits for running
+// reads and iterations to finish, reads after Release return ErrSnapshotReleased. Releasing twice is a no-op
+func (s *Snapshot) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.released {
+		return
+	}
+	s.released = true
+	for s.active > 0 {
+		s.idle.Wait()
+	}
+	s.tx.txn.Discard()
+}
+
+// read runs fn unless the snapshot has been released
+func (s *Snapshot) read(fn func() error) error {
+	s.mu.Lock()
+	if s.released {
+		s.mu.Unlock()
+		return ErrSnapshotReleased
+	}
+	s.active++
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.active--
+		if s.active == 0 {
+			s.idle.Broadcast()
+		}
+		s.mu.Unlock()
+	}()
+
+	return fn()
+}
+
+// Read runs fn with a Reader over the snapshot, for several reads without taking the snapshot's
+// lock for each. Release waits for fn to return
+func (s *Snapshot) Read(fn func(r *Reader) error) error {
+	return s.read(func() error {
+		return fn(&Reader{tx: s.tx})
+	})
+}
+
+// GetHashByOriginalHash returns a hash as stored when the snapshot was taken.
+// Returns badger.ErrKeyNotFound if it wasn't stored then. Fallback resolvers are not consulted
+func (s *Snapshot) GetHashByOriginalHash(originalHash string, hashType uint64) (*Hash, error) {
+	var hash *Hash
+	err := s.Read(func(r *Reader) error {
+		var err error
+		hash, err = r.GetHash(originalHash, hashType)
+		return err
+	})
+	return hash, err
+}
+
+// GetHashBySum returns a hash by its SHA256 sum, raw or hex encoded, as stored when the snapshot
+// was taken. Anything else fails with ErrInvalidSum
+func (s *Snapshot) GetHashBySum(sum string, hashType uint64) (*Hash, error) {
+	var hash *Hash
+	err := s.Read(func(r *Reader) error {
+		var err error
+		hash, err = r.GetHashBySum(sum, hashType)
+		return err
+	})
+	return hash, err
+}
+
+// GetHashesByHashType returns an iterator over the hashes of a hash type stored when the snapshot
+// was taken. Release waits for running iterations. An iteration refused with ErrTooManyIterators,
+// or started after Release, yields nothing, check Err after it
+func (s *Snapshot) GetHashesByHashType(hashType uint64) iter.Seq[*Hash] {
+	return func(yield func(*Hash) bool) {
+		err := s.read(func() error {
+			return s.tx.hashes(hashType, yield)
+		})
+		if err != nil {
+			s.mu.Lock()
+			if s.err == nil {
+				s.err = err
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Err returns the first error an iteration of the snapshot stopped on, nil if every one ran until
+// its end or the caller stopped it. An iteration that yields nothing had no hashes of its type
+// only if Err is nil
+func (s *Snapshot) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// GetMeta returns a meta value as set when the snapshot was taken
+func (s *Snapshot) GetMeta(key string) ([]byte, error) {
+	var value []byte
+	err := s.Read(func(r *Reader) error {
+		var err error
+		value, err = r.GetMeta(key)
+		return err
+	})
+	return value, err
+}
+
+// GetRegisteredHashTypes returns the hash types registered when the snapshot was taken
+func (s *Snapshot) GetRegisteredHashTypes() ([]uint64, error) {
+	var hashTypes []uint64
+	err := s.read(func() error {
+		var err error
+		hashTypes, err = s.tx.kc.readHashTypes(s.tx.txn)
+		return err
+	})
+	return hashTypes, err
+}
+
+// HashesByType counts the hashes of a hash type in the snapshot. Unlike KDB.HashesByType this scans
+// the keys instead of reading the counter, so the result is exact for the snapshot
+func (s *Snapshot) HashesByType(hashType uint64) (int, error) {
+	count := 0
+	err := s.read(func() error {
+		count = s.tx.kc.countKeys(s.tx.txn, []byte(s.tx.kc.keys.key(hashTypeScanPrefix, hashType)))
+		return nil
+	})
+	return count, err
+}
+
+// TotalHashes counts the hashes of every registered hash type in the snapshot by scanning their keys
+func (s *Snapshot) TotalHashes() (int, error) {
+	hashTypes, err := s.GetRegisteredHashTypes()
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, hashType := range hashTypes {
+		n, err := s.HashesByType(hashType)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// countKeys counts the keys under prefix without reading their values
+func (kc *KDB) countKeys(txn *badger.Txn, prefix []byte) int {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+
+	it := kc.newIterator(txn, opts)
+	defer it.Close()
+
+	count := 0
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		count++
+	}
+	return count
+}
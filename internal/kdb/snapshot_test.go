@@ -0,0 +1,128 @@
+package kdb
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// snapshotView returns everything s reads, one line per hash, count, meta value and hash type
+func snapshotView(s *Snapshot) (string, error) {
+	var lines []string
+	hashTypes, err := s.GetRegisteredHashTypes()
+	if err != nil {
+		return "", err
+	}
+	lines = append(lines, fmt.Sprintf("types %v", hashTypes))
+	for _, hashType := range deltaTypes {
+		for h := range s.GetHashesByHashType(hashType) {
+			lines = append(lines, fmt.Sprintf("%d %s %q", hashType, h.Hash, h.Value))
+		}
+		n, err := s.HashesByType(hashType)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, fmt.Sprintf("count %d %d", hashType, n))
+	}
+	for i := range 10 {
+		h, err := s.GetHashByOriginalHash(testHash(i), 0)
+		if err != nil {
+			return "", fmt.Errorf("lookup %d: %w", i, err)
+		}
+		lines = append(lines, fmt.Sprintf("lookup %s %q", h.Hash, h.Value))
+	}
+	meta, err := s.GetMeta("snapshot")
+	if err != nil {
+		return "", err
+	}
+	lines = append(lines, fmt.Sprintf("meta %q", meta), fmt.Sprintf("err %v", s.Err()))
+	return strings.Join(lines, "\n"), nil
+}
+
+func TestSnapshotIgnoresConcurrentWrites(t *testing.T) {
+	db := newTestDB(t, testPrefix, nil)
+	for i := range 100 {
+		if _, err := db.StoreHash(NewHash(testHash(i), fmt.Sprintf("value%d", i), 0)); err != nil {
+			t.Fatalf("store %d: %v", i, err)
+		}
+	}
+	if err := db.SetMeta("snapshot", []byte("before")); err != nil {
+		t.Fatalf("SetMeta: %v", err)
+	}
+
+	snap, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	defer snap.Release()
+	want, err := snapshotView(snap)
+	if err != nil {
+		t.Fatalf("reading the snapshot: %v", err)
+	}
+
+	// Writers overwrite, delete and add hashes, a new hash type and meta while readers go over the
+	// snapshot again and again
+	var writing sync.WaitGroup
+	var done atomic.Bool
+	for w := range 4 {
+		writing.Go(func() {
+			for i := w; i < 100; i += 4 {
+				var err error
+				switch i % 3 {
+				case 0:
+					err = db.MarkCracked(testHash(i), 0, "changed")
+				case 1:
+					err = db.DeleteHash(testHash(i), 0)
+				default:
+					_, err = db.StoreHash(NewHash(testHash(100+i), "", NTLM))
+				}
+				if err != nil {
+					t.Errorf("write %d: %v", i, err)
+				}
+				if err := db.SetMeta("snapshot", fmt.Appendf(nil, "after %d", i)); err != nil {
+					t.Errorf("SetMeta %d: %v", i, err)
+				}
+			}
+		})
+	}
+	var reading sync.WaitGroup
+	var reads atomic.Int32
+	for range 4 {
+		reading.Go(func() {
+			for !done.Load() || reads.Load() < 8 {
+				got, err := snapshotView(snap)
+				if err != nil {
+					t.Errorf("reading the snapshot: %v", err)
+					return
+				}
+				if got != want {
+					t.Errorf("the snapshot changed under writes:\n%s\nwant\n%s", got, want)
+					return
+				}
+				reads.Add(1)
+			}
+		})
+	}
+	writing.Wait()
+	done.Store(true)
+	reading.Wait()
+
+	if got, err := snapshotView(snap); err != nil || got != want {
+		t.Errorf("the snapshot after the writes:\n%s\nwant\n%s\n%v", got, want, err)
+	}
+
+	// The writes went through, a new snapshot sees them
+	fresh, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	defer fresh.Release()
+	types, _ := fresh.GetRegisteredHashTypes()
+	n, _ := fresh.HashesByType(0)
+	if !slices.Contains(types, NTLM) || n != 100-33 {
+		t.Errorf("a fresh snapshot has the hash types %v and %d hashes of type 0", types, n)
+	}
+}
@@ -0,0 +1,100 @@
+package kdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Source records the attack that cracked a hash
+type Source struct {
+	Wordlist string `json:"wordlist,omitempty"`
+	Rule     string `json:"rule,omitempty"`
+	Mask     string `json:"mask,omitempty"`
+	Tool     string `json:"tool,omitempty"`
+}
+
+// AttackCount is the number of cracks of one wordlist, rule and mask combination
+type AttackCount struct {
+	Wordlist string  `json:"wordlist,omitempty"`
+	Rule     string  `json:"rule,omitempty"`
+	Mask     string  `json:"mask,omitempty"`
+	Cracks   int     `json:"cracks"`
+	Percent  float64 `json:"percent"` // Share of the attributed cracks of the hash type
+}
+
+// TypeEffectiveness aggregates the attributed cracks of one hash type
+type TypeEffectiveness struct {
+	Cracked    int           `json:"cracked"`    // Hashes with a value
+	Attributed int           `json:"attributed"` // Cracked hashes with a Source
+	Attacks    []AttackCount `json:"attacks"`    // Most effective first
+}
+
+// EffectivenessReport aggregates cracks per attack for every hash type
+type EffectivenessReport struct {
+	HashTypes map[uint64]*TypeEffectiveness `json:"hash_types"`
+}
+
+// attackKey identifies an attack in the report, the tool doesn't make attacks different
+type attackKey struct {
+	wordlist, rule, mask string
+}
+
+// AttackEffectiveness reports how many cracks each wordlist, rule and mask combination produced,
+// per hash type. Hashes are streamed, memory grows with the number of distinct attacks only.
+// Hashes stored without a Source are left out of the attacks and percentages
+func (kc *KDB) AttackEffectiveness(ctx context.Context) (*EffectivenessReport, error) {
+	hashTypes, err := kc.getRegisteredHashTypes()
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to get registered hash types: %v", err), Error)
+		return nil, fmt.Errorf("failed to get registered hash types: %w", err)
+	}
+
+	report := &EffectivenessReport{HashTypes: make(map[uint64]*TypeEffectiveness)}
+	for _, hashType := range hashTypes {
+		te := &TypeEffectiveness{Attacks: []AttackCount{}}
+		counts := make(map[attackKey]int)
+
+		for hash := range kc.GetHashesByHashType(hashType) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if hash.Value == "" {
+				continue
+			}
+			te.Cracked++
+			if hash.Source == nil {
+				continue
+			}
+			te.Attributed++
+			counts[attackKey{hash.Source.Wordlist, hash.Source.Rule, hash.Source.Mask}]++
+		}
+
+		for key, n := range counts {
+			te.Attacks = append(te.Attacks, AttackCount{
+				Wordlist: key.wordlist,
+				Rule:     key.rule,
+				Mask:     key.mask,
+				Cracks:   n,
+				Percent:  float64(n) / float64(te.Attributed) * 100,
+			})
+		}
+		sort.Slice(te.Attacks, func(i, j int) bool {
+			a, b := te.Attacks[i], te.Attacks[j]
+			if a.Cracks != b.Cracks {
+				return a.Cracks > b.Cracks
+			}
+			if a.Wordlist != b.Wordlist {
+				return a.Wordlist < b.Wordlist
+			}
+			if a.Rule != b.Rule {
+				return a.Rule < b.Rule
+			}
+			return a.Mask < b.Mask
+		})
+
+		report.HashTypes[hashType] = te
+	}
+
+	return report, nil
+}
@@ -0,0 +1,86 @@
+package kdb
+
+import (
+	"fmt"
+)
+
+// Stats is a point-in-time summary of the database
+type Stats struct {
+	TotalHashes      int            `json:"total_hashes"`
+	HashTypes        map[uint64]int `json:"hash_types"`        // Count per registered hash type
+	CompressionSaved int            `json:"compression_saved"` // Bytes saved by value compression, exact after RecompressValues
+	InternSaved      int            `json:"intern_saved"`      // Bytes saved by value interning net of the dictionary, as of the last InternValues
+	InternedValues   int            `json:"interned_values"`   // Entries of the value dictionary
+	InternDictionary int            `json:"intern_dictionary"` // Bytes the value dictionary takes
+	Quotas           []QuotaUsage   `json:"quotas,omitempty"`
+	LSM              LSMInfo        `json:"lsm"`
+	ValueLogSize     int64          `json:"value_log_size"`  // Bytes of value log files, where records of ValueThreshold and up are kept
+	ValueThreshold   int64          `json:"value_threshold"` // Records this large go to the value log, see Options.TicketValueThreshold
+	ValueLogTypes    map[uint64]int `json:"value_log_types"` // Expected record size of the registered types kept in the value log
+	Warmup           WarmupStatus   `json:"warmup"`
+	Inflight         InflightStats  `json:"inflight"`          // Open iterators and bytes buffered by write batches
+	Pressure         PressureStats  `json:"pressure"`          // Write pressure and the batches throttled by it
+	Indexes          *IndexReport   `json:"indexes,omitempty"` // The last VerifyIndexes, nil if none ran since open
+
+	Estimates map[uint64]CountEstimate `json:"estimates"` // Per type estimates from table metadata, a cross-check for the counters
+}
+
+// Stats returns counts for the database and every registered hash type along with quota usage, the
+// LSM tree, the value log, warmup progress and the open iterators and batches. Everything is read from counters and table metadata, no scans are performed
+func (kc *KDB) Stats() (*Stats, error) {
+	stats := &Stats{HashTypes: make(map[uint64]int), ValueLogTypes: make(map[uint64]int), Estimates: make(map[uint64]CountEstimate)}
+
+	total, err := kc.TotalHashes()
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("failed to read total hash count: %w", err)
+	}
+	stats.TotalHashes = total
+
+	stats.CompressionSaved, err = kc.getCount(kc.keys.key(compressionSavedKey))
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("failed to read compression counter: %w", err)
+	}
+
+	stats.InternSaved, err = kc.getCount(kc.keys.key(internSavedKey))
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("failed to read interning counter: %w", err)
+	}
+	stats.InternedValues, stats.InternDictionary = kc.dictionarySize()
+
+	hashTypes, err := kc.getRegisteredHashTypes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get registered hash types: %w", err)
+	}
+
+	for _, hashType := range hashTypes {
+		count, err := kc.HashesByType(hashType)
+		if err != nil && !isNotFound(err) {
+			return nil, fmt.Errorf("failed to read count for hash type %d: %w", hashType, err)
+		}
+		stats.HashTypes[hashType] = count
+
+		if stats.Estimates[hashType], err = kc.EstimateCountDetail(hashType); err != nil {
+			return nil, fmt.Errorf("failed to estimate count for hash type %d: %w", hashType, err)
+		}
+		if size, ok := kc.valueLogRecordSize(hashType); ok {
+			stats.ValueLogTypes[hashType] = size
+		}
+	}
+
+	stats.Quotas, err = kc.QuotaUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.LSM, err = kc.LSMInfo(); err != nil {
+		return nil, err
+	}
+	_, stats.ValueLogSize = kc.c.Size()
+	stats.ValueThreshold = kc.valueThreshold()
+	stats.Warmup = kc.WarmupStatus()
+	stats.Inflight = kc.inflight.snapshot()
+	stats.Pressure = kc.pressure.snapshot()
+	stats.Indexes = kc.LastIndexReport()
+
+	return stats, nil
+}
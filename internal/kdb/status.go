@@ -0,0 +1,190 @@
+package kdb
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// LastBackupMetaKey is the meta entry holding when the database was last backed up, as RFC 3339
+// text. Backup sets it, other backup tooling with SetMeta, StatusHandler shows it
+const LastBackupMetaKey = "last_backup"
+
+// defaultStatusRefresh is how often the status page reloads itself
+const defaultStatusRefresh = 30 * time.Second
+
+// StatusPage is what the status page shows, see StatusHandler, ReadStatus and RenderStatus
+type StatusPage struct {
+	Path       string         // Directory of the database
+	Generated  time.Time      // When the figures were read
+	Refresh    time.Duration  // How often the page reloads itself, 0 disables it
+	Stats      *Stats         // Counts, sizes and in-flight work
+	Coverage   []TypeCoverage // Cracked counts of every registered type, ordered by type
+	LastBackup time.Time      // Zero if no backup was recorded, see LastBackupMetaKey
+	Operations []Operation    // Running operations
+}
+
+// statusTemplate is self-contained: styles are inline and it links to nothing but itself
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"typeName": hashTypeLabel,
+	"bytes":    formatStatusBytes,
+	"seconds":  func(d time.Duration) int { return int(d.Seconds()) },
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.UTC().Format("2006-01-02 15:04:05 UTC")
+	},
+	"since": func(t time.Time) string { return time.Since(t).Round(time.Second).String() },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+{{if gt .Refresh 0}}<meta http-equiv="refresh" content="{{seconds .Refresh}}">
+{{end}}<title>KrknDB status</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { padding: 0.3em 0.8em; border-bottom: 1px solid #ddd; text-align: left; }
+td.n { text-align: right; font-variant-numeric: tabular-nums; }
+.muted { color: #777; }
+.warn { color: #b00; }
+</style>
+</head>
+<body>
+<h1>KrknDB status</h1>
+<p class="muted">{{.Path}} &middot; generated {{time .Generated}}{{if gt .Refresh 0}} &middot; refreshes every {{seconds .Refresh}}s{{end}} &middot; <a href="">reload</a></p>
+
+<h2>Summary</h2>
+<table>
+<tr><th>Hashes</th><td class="n">{{.Stats.TotalHashes}}</td></tr>
+<tr><th>Hash types</th><td class="n">{{len .Coverage}}</td></tr>
+<tr><th>LSM tree</th><td class="n">{{bytes .Stats.LSM.Size}}</td></tr>
+<tr><th>Value log</th><td class="n">{{bytes .Stats.ValueLogSize}}</td></tr>
+<tr><th>Last backup</th><td>{{time .LastBackup}}</td></tr>
+{{if .Stats.LSM.Stalled}}<tr><th>Writes</th><td class="warn">stalled until L0 is compacted</td></tr>
+{{else if .Stats.LSM.NearStall}}<tr><th>Writes</th><td class="warn">close to stalling</td></tr>
+{{end}}</table>
+
+<h2>Hash types</h2>
+{{if .Coverage}}<table>
+<tr><th>Type</th><th>Hashes</th><th>Cracked</th><th>%</th></tr>
+{{range .Coverage}}<tr><td>{{typeName .HashType}}</td><td class="n">{{.Total}}</td><td class="n">{{.Cracked}}</td><td class="n">{{printf "%.1f" .Percent}}</td></tr>
+{{end}}</table>
+{{else}}<p class="muted">No hash types registered.</p>
+{{end}}
+<h2>Operations</h2>
+{{if .Operations}}<table>
+<tr><th>ID</th><th>Kind</th><th>Name</th><th>Progress</th><th>Running for</th></tr>
+{{range .Operations}}<tr><td class="n">{{.ID}}</td><td>{{.Kind}}</td><td>{{.Name}}</td><td class="n">{{.Done}}{{if .Total}} / {{.Total}}{{end}}</td><td>{{since .Started}}</td></tr>
+{{end}}</table>
+{{else}}<p class="muted">No operations running.</p>
+{{end}}
+<h2>In flight</h2>
+<table>
+<tr><th>Open iterators</th><td class="n">{{.Stats.Inflight.OpenIterators}} (peak {{.Stats.Inflight.PeakIterators}})</td></tr>
+<tr><th>Batch bytes</th><td class="n">{{bytes .Stats.Inflight.BatchBytes}} (peak {{bytes .Stats.Inflight.PeakBatchBytes}})</td></tr>
+</table>
+</body>
+</html>
+`))
+
+// formatStatusBytes renders a byte count with a binary unit, e.g. "1.5 MiB"
+func formatStatusBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// RenderStatus writes page as a self-contained HTML document
+func RenderStatus(w io.Writer, page *StatusPage) error {
+	if page == nil || page.Stats == nil {
+		return fmt.Errorf("status page has no stats")
+	}
+	return statusTemplate.Execute(w, page)
+}
+
+// ReadStatus reads what the status page shows: Stats, the cracked counts of every registered
+// type, the last backup and the running operations
+func (kc *KDB) ReadStatus() (*StatusPage, error) {
+	stats, err := kc.Stats()
+	if err != nil {
+		return nil, err
+	}
+	page := &StatusPage{Path: kc.absPath, Generated: time.Now().UTC(), Refresh: defaultStatusRefresh, Stats: stats}
+
+	for _, hashType := range slices.Sorted(maps.Keys(stats.HashTypes)) {
+		tc, err := kc.Coverage(hashType)
+		if err != nil {
+			return nil, err
+		}
+		page.Coverage = append(page.Coverage, tc)
+	}
+
+	if data, err := kc.GetMeta(LastBackupMetaKey); err == nil {
+		page.LastBackup, _ = time.Parse(time.RFC3339, string(data))
+	} else if !isNotFound(err) {
+		return nil, fmt.Errorf("failed to read last backup time: %w", err)
+	}
+
+	for _, op := range kc.ListOperations() {
+		if op.State == OperationRunning {
+			page.Operations = append(page.Operations, op)
+		}
+	}
+	return page, nil
+}
+
+// StatusHandler serves a read-only HTML status page for people to glance at, reloading itself
+// every refresh, 0 uses 30 seconds. It answers GET and HEAD on whatever path it is mounted at,
+// links only to itself and needs nothing but the page, so it can sit behind http.StripPrefix
+func (kc *KDB) StatusHandler(refresh time.Duration) http.Handler {
+	if refresh <= 0 {
+		refresh = defaultStatusRefresh
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		page, err := kc.ReadStatus()
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to read status: %v", err), Error)
+			http.Error(w, "failed to read status", http.StatusInternalServerError)
+			return
+		}
+		page.Refresh = refresh
+
+		var buf bytes.Buffer
+		if err := RenderStatus(&buf, page); err != nil {
+			kc.log(fmt.Sprintf("Failed to render status page: %v", err), Error)
+			http.Error(w, "failed to render status", http.StatusInternalServerError)
+			return
+		}
+
+		h := w.Header()
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		h.Set("Cache-Control", "no-store")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write(buf.Bytes())
+	})
+}
@@ -0,0 +1,141 @@
+package kdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// selfContained fails t unless page loads nothing and links nowhere but itself
+func selfContained(t *testing.T, page string) {
+	t.Helper()
+	if strings.Contains(page, "<script") || strings.Contains(page, "<form") {
+		t.Errorf("the page has a script or a form")
+	}
+	if link := regexp.MustCompile(`(src|href)="[^"]+"`).FindString(page); link != "" {
+		t.Errorf("the page links or loads something: %s", link)
+	}
+}
+
+func TestRenderStatus(t *testing.T) {
+	page := &StatusPage{
+		Path:      "/srv/krkndb",
+		Generated: time.Now(),
+		Refresh:   15 * time.Second,
+		Stats: &Stats{
+			TotalHashes:  1234,
+			HashTypes:    map[uint64]int{1000: 1200, 0: 34},
+			LSM:          LSMInfo{Size: 3 << 20, NearStall: true},
+			ValueLogSize: 1536,
+		},
+		Coverage: []TypeCoverage{
+			{HashType: MD5, Cracked: 34, Total: 34, Percent: 100},
+			{HashType: NTLM, Cracked: 300, Total: 1200, Percent: 25},
+		},
+		LastBackup: time.Date(2026, 10, 1, 2, 30, 0, 0, time.UTC),
+		Operations: []Operation{{ID: 7, Kind: OpImport, Name: "<script>alert(1)</script>", Done: 5, Total: 10, Started: time.Now().Add(-90 * time.Second)}},
+	}
+	var buf bytes.Buffer
+	if err := RenderStatus(&buf, page); err != nil {
+		t.Fatalf("RenderStatus: %v", err)
+	}
+	html := buf.String()
+	for _, want := range []string{
+		`<meta http-equiv="refresh" content="15">`,
+		`/srv/krkndb`,
+		`<td class="n">1234</td>`,
+		`ntlm (1000)`, `md5 (0)`, `<td class="n">25.0</td>`, `<td class="n">100.0</td>`,
+		`3.0 MiB`, `1.5 KiB`,
+		`2026-10-01 02:30:00 UTC`,
+		`close to stalling`,
+		`5 / 10`, `1m30s`,
+		`&lt;script&gt;alert(1)&lt;/script&gt;`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("the page lacks %q", want)
+		}
+	}
+	selfContained(t, html)
+
+	page.Refresh, page.LastBackup, page.Operations, page.Coverage = 0, time.Time{}, nil, nil
+	buf.Reset()
+	if err := RenderStatus(&buf, page); err != nil {
+		t.Fatalf("RenderStatus: %v", err)
+	}
+	html = buf.String()
+	if strings.Contains(html, "http-equiv") {
+		t.Errorf("a page without refresh reloads itself")
+	}
+	for _, want := range []string{"<td>never</td>", "No operations running", "No hash types registered"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("the empty page lacks %q", want)
+		}
+	}
+	if RenderStatus(io.Discard, &StatusPage{}) == nil {
+		t.Errorf("a page without stats rendered")
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	db := newTestDB(t, testPrefix, nil)
+	var hashes []*Hash
+	for i := range 40 {
+		value := ""
+		if i%4 == 0 {
+			value = fmt.Sprintf("password%d", i)
+		}
+		hashes = append(hashes, NewHash(testHash(i), value, MD5))
+	}
+	if _, err := db.StoreHashes(hashes); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	if err := db.SetMeta(LastBackupMetaKey, []byte("2026-10-13T22:00:00Z")); err != nil {
+		t.Fatalf("SetMeta: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/ops/krkndb", db.StatusHandler(time.Minute))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/ops/krkndb")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	html := string(body)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("GET returned %s, %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	if resp.Header.Get("Content-Security-Policy") == "" || resp.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("the headers %v", resp.Header)
+	}
+	for _, want := range []string{`<td class="n">40</td>`, `<td class="n">10</td>`, `<td class="n">25.0</td>`, `2026-10-13 22:00:00 UTC`, `content="60"`} {
+		if !strings.Contains(html, want) {
+			t.Errorf("the page lacks %q", want)
+		}
+	}
+	selfContained(t, html)
+
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+		req, _ := http.NewRequest(method, srv.URL+"/ops/krkndb", strings.NewReader("x"))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET, HEAD" {
+			t.Errorf("%s returned %s, allowing %q", method, resp.Status, resp.Header.Get("Allow"))
+		}
+	}
+	if resp, err := http.Head(srv.URL + "/ops/krkndb"); err != nil || resp.StatusCode != http.StatusOK || resp.ContentLength > 0 {
+		t.Errorf("HEAD returned %v: %v", resp, err)
+	}
+}
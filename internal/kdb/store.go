@@ -0,0 +1,23 @@
+package kdb
+
+import "iter"
+
+// HashStore is the hash storage API of a KDB. Code written against it doesn't depend on
+// where the hashes live, *KDB is the embedded implementation
+type HashStore interface {
+	StoreHash(sh *Hash) (isNew bool, err error)
+	StoreHashes(hashes []*Hash) (StoreResult, error)
+	GetHashBySum(sum string, hashType uint64) (*Hash, error)
+	GetHashByOriginalHash(originalHash string, hashType uint64) (*Hash, error)
+	DeleteHash(originalHash string, hashType uint64) error
+	MarkCracked(originalHash string, hashType uint64, value string, source ...*Source) error
+	GetHashesByHashType(hashType uint64, scanOpts ...*ScanOptions) iter.Seq[*Hash]
+	FindHashes(possibleHashes []string, hashType uint64, scanOpts ...*ScanOptions) iter.Seq[*Hash]
+	SearchHashesByPrefix(hexPrefix string, hashType uint64, scanOpts ...*ScanOptions) iter.Seq[*Hash]
+	TotalHashes() (int, error)
+	HashesByType(hashType uint64) (int, error)
+	GetRegisteredHashTypes() ([]uint64, error)
+	Close() error
+}
+
+var _ HashStore = (*KDB)(nil)
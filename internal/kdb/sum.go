@@ -0,0 +1,236 @@
+package kdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// A hash is keyed on the SHA256 digest of its key material. Hashes and records of schema version
+// 2 hold the raw 32 bytes, keys and records of version 1 the 64 hex characters. The helpers here
+// take either form, so nothing deriving a key has to know which one it was handed
+
+// digestOf returns the raw SHA256 digest of the key material of a hash
+func digestOf(material string) []byte {
+	sum := sha256.Sum256([]byte(material))
+	return sum[:]
+}
+
+// rawSum returns sum as the raw digest, given raw or hex encoded. Anything else is returned as is
+func rawSum(sum []byte) []byte {
+	if len(sum) == 2*sha256.Size {
+		raw := make([]byte, sha256.Size)
+		if _, err := hex.Decode(raw, sum); err == nil {
+			return raw
+		}
+	}
+	return bytes.Clone(sum)
+}
+
+// hexSum returns sum hex encoded as keys carry it, given raw or hex encoded
+func hexSum(sum []byte) []byte {
+	if len(sum) == sha256.Size {
+		return hex.AppendEncode(nil, sum)
+	}
+	return sum
+}
+
+// hexSumString is hexSum for sums passed as strings, such as to GetHashBySum
+func hexSumString(sum string) string {
+	if len(sum) == sha256.Size {
+		return hex.EncodeToString([]byte(sum))
+	}
+	return sum
+}
+
+// SumOf returns the hex encoded sum a hash is keyed on, for GetHashBySum without building a Hash.
+// It holds for unsalted hashes keyed on the hash itself. Salted hashes, NetNTLM captures and
+// Kerberos tickets are keyed on more or other material, look those up with GetHashByOriginalHash
+func SumOf(hash string) string {
+	return hex.EncodeToString(SumOfBytes(hash))
+}
+
+// SumOfBytes is SumOf returning the raw 32 byte digest
+func SumOfBytes(hash string) []byte {
+	return digestOf(normalizeHash(hash))
+}
+
+// checkSum returns sum, 64 hex characters in any case or 32 raw bytes, as keys carry it. 32 hex
+// characters are rejected as the hash itself rather than its sum, a raw digest is all hex digits
+// about once in 10^34
+func checkSum(sum string) (string, error) {
+	switch {
+	case len(sum) == 2*sha256.Size && isHex(sum):
+		return strings.ToLower(sum), nil
+	case len(sum) == sha256.Size && isHex(sum):
+		return "", fmt.Errorf("%w: %q is a hash, not its sum, see GetHashByOriginalHash and SumOf", ErrInvalidSum, sum)
+	case len(sum) == sha256.Size:
+		return hex.EncodeToString([]byte(sum)), nil
+	}
+	return "", fmt.Errorf("%w: %d bytes, want 64 hex characters or 32 raw bytes", ErrInvalidSum, len(sum))
+}
+
+// Sum returns the hex encoded SHA256 digest the hash is keyed on
+func (sh *Hash) Sum() string {
+	return hex.EncodeToString(sh.sum)
+}
+
+// SumBytes returns the raw 32 byte SHA256 digest the hash is keyed on
+func (sh *Hash) SumBytes() []byte {
+	return bytes.Clone(sh.sum)
+}
+
+// compactSumsChunk is the number of records compactSums rewrites per write batch, the progress
+// saved after each one bounds what an interrupted run does again
+const compactSumsChunk = 10000
+
+// compactSums is the migration to schema version 2, rewriting the hex sums of stored records as
+// raw digests. It runs before the value dictionary is loaded, so records are rewritten at the wire
+// level with every other field left as it is. Legacy JSON records and records that don't parse
+// keep their hex sum, both forms read. Types are rewritten in order and the last key of every
+// chunk is saved as the migration cursor, an interrupted run resumes after it
+func compactSums(kc *KDB) error {
+	hashTypes, err := kc.getRegisteredHashTypes()
+	if err != nil {
+		return fmt.Errorf("failed to get registered hash types: %w", err)
+	}
+	slices.Sort(hashTypes)
+
+	// The cursor is the hash type and the key of the last record compacted
+	cursor, err := kc.loadMigrationCursor(compactSumsMigration)
+	if err != nil {
+		return fmt.Errorf("failed to load the migration cursor: %w", err)
+	}
+	var resumeType uint64
+	var resumeKey []byte
+	if len(cursor) >= 8 {
+		resumeType, resumeKey = binary.BigEndian.Uint64(cursor), cursor[8:]
+		kc.log(fmt.Sprintf("Resuming the sum compaction at hash type %d", resumeType), Info)
+	}
+
+	for _, hashType := range hashTypes {
+		var after []byte
+		if cursor != nil {
+			if hashType < resumeType {
+				continue
+			}
+			if hashType == resumeType {
+				after = resumeKey
+			}
+		}
+		rewritten, err := kc.compactTypeSums(hashType, after)
+		if err != nil {
+			return fmt.Errorf("failed to compact the sums of hash type %d: %w", hashType, err)
+		}
+		kc.log(fmt.Sprintf("Compacted the sums of %d hashes of type %d", rewritten, hashType), Info)
+	}
+	return nil
+}
+
+// compactTypeSums compacts the sums of the records of hashType stored after the key after, every
+// record if it is nil, and returns how many it rewrote
+func (kc *KDB) compactTypeSums(hashType uint64, after []byte) (int, error) {
+	prefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+	rewritten, pending := 0, 0
+	wb := kc.c.NewWriteBatch()
+	defer func() { wb.Cancel() }()
+
+	// flush writes the pending records and saves last as the cursor
+	flush := func(last []byte) error {
+		if err := wb.Flush(); err != nil {
+			return err
+		}
+		wb = kc.c.NewWriteBatch()
+		pending = 0
+		return kc.saveMigrationCursor(compactSumsMigration, append(binary.BigEndian.AppendUint64(nil, hashType), last...))
+	}
+
+	err := kc.c.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix, PrefetchValues: true, PrefetchSize: 100})
+		defer it.Close()
+		if after == nil {
+			it.Rewind()
+		} else if it.Seek(after); it.Valid() && bytes.Equal(it.Item().Key(), after) {
+			it.Next()
+		}
+		for ; it.Valid(); it.Next() {
+			item := it.Item()
+			err := item.Value(func(val []byte) error {
+				record, ok := compactSumRecord(val)
+				if !ok {
+					return nil
+				}
+				rewritten++
+				pending++
+				return wb.Set(item.KeyCopy(nil), record)
+			})
+			if err != nil {
+				return err
+			}
+			if pending == compactSumsChunk {
+				if err := flush(item.KeyCopy(nil)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil && pending > 0 {
+		err = wb.Flush()
+	}
+	return rewritten, err
+}
+
+// compactSumRecord returns the record with its hex sum replaced by the raw digest, compressed
+// again if it was. Reports false for records that need no rewrite or can't be rewritten
+func compactSumRecord(data []byte) ([]byte, bool) {
+	if len(data) == 0 {
+		return nil, false
+	}
+	record := data
+	compressed := data[0]&flagCompressed != 0
+	if compressed {
+		var err error
+		if record, err = decompressRecord(data); err != nil {
+			return nil, false
+		}
+	}
+	if record[0] != formatProto1 {
+		return nil, false
+	}
+
+	out := make([]byte, 1, len(record))
+	out[0] = formatProto1
+	changed := false
+	err := eachField(record[1:], func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		n := protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return n, nil
+		}
+		if num == fieldSum && typ == protowire.BytesType {
+			v, _ := protowire.ConsumeBytes(b)
+			if raw := rawSum(v); len(raw) != len(v) {
+				out = appendBytes(out, fieldSum, raw)
+				changed = true
+				return n, nil
+			}
+		}
+		out = protowire.AppendTag(out, num, typ)
+		out = append(out, b[:n]...)
+		return n, nil
+	})
+	if err != nil || !changed {
+		return nil, false
+	}
+	if compressed {
+		out, _ = compressRecord(out, 1)
+	}
+	return out, true
+}
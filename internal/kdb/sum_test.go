@@ -0,0 +1,244 @@
+package kdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+	"github.com/dgraph-io/badger/v4"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// preChangeRecord encodes h the way records were written before sums were stored raw, under the
+// key of schema version 1
+func preChangeRecord(h *Hash) []byte {
+	b := []byte{formatProto1}
+	b = protowire.AppendTag(b, fieldHash, protowire.BytesType)
+	b = protowire.AppendString(b, h.Hash)
+	b = protowire.AppendTag(b, fieldSum, protowire.BytesType)
+	b = protowire.AppendBytes(b, util.SHA256Sum(h.Hash))
+	b = protowire.AppendTag(b, fieldValue, protowire.BytesType)
+	b = protowire.AppendString(b, h.Value)
+	b = protowire.AppendTag(b, fieldKey, protowire.BytesType)
+	return protowire.AppendBytes(b, v1Key(h.Hash, h.HashType))
+}
+
+// storedRecord returns the record stored under key, as the engine holds it
+func storedRecord(t *testing.T, db *KDB, key []byte) []byte {
+	t.Helper()
+	var record []byte
+	err := db.c.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		record, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to read the record %s: %v", key, err)
+	}
+	return record
+}
+
+// checkSumLookups fails t unless the hash is found by itself and by both forms of its sum
+func checkSumLookups(t *testing.T, db *KDB, h *Hash) {
+	t.Helper()
+	want := sha256.Sum256([]byte(h.Hash))
+	found, err := db.GetHashByOriginalHash(h.Hash, h.HashType)
+	if err != nil || found.Value != h.Value {
+		t.Errorf("%s looked up: %+v %v", h.Hash, found, err)
+	} else if !bytes.Equal(found.SumBytes(), want[:]) || found.Sum() != hex.EncodeToString(want[:]) {
+		t.Errorf("%s read with the sum %s", h.Hash, found.Sum())
+	}
+	for form, sum := range map[string]string{"hex": hex.EncodeToString(want[:]), "raw": string(want[:])} {
+		if bySum, err := db.GetHashBySum(sum, h.HashType); err != nil || bySum.Value != h.Value {
+			t.Errorf("%s looked up by its %s sum: %+v %v", h.Hash, form, bySum, err)
+		}
+	}
+}
+
+func TestHashesCarryTheRawSum(t *testing.T) {
+	db := newTestDB(t, testPrefix, nil)
+	h := NewHash(testHash(0), "current", MD5)
+	want := sha256.Sum256([]byte(h.Hash))
+	if !bytes.Equal(h.SumBytes(), want[:]) || h.Sum() != hex.EncodeToString(want[:]) {
+		t.Errorf("the sum is %x, %s", h.SumBytes(), h.Sum())
+	}
+	h.SumBytes()[0] ^= 0xff
+	if !bytes.Equal(h.SumBytes(), want[:]) {
+		t.Errorf("SumBytes handed out the sum itself")
+	}
+	var decoded struct{ Sum string }
+	if data, err := json.Marshal(h); err != nil || json.Unmarshal(data, &decoded) != nil || decoded.Sum != h.Sum() {
+		t.Errorf("the JSON sum is %q: %v", decoded.Sum, err)
+	}
+
+	if _, err := db.StoreHash(h); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	record := storedRecord(t, db, h.Key)
+	// The hex sum is only in the key the record carries
+	if !bytes.Contains(record, want[:]) || bytes.Count(record, []byte(h.Sum())) != 1 {
+		t.Errorf("the record doesn't hold the sum raw: %q", record)
+	}
+	checkSumLookups(t, db, h)
+}
+
+func TestOpenVersion1CompactsSums(t *testing.T) {
+	// Version 1 records of both formats: legacy JSON and protobuf with the hex sum
+	dir := t.TempDir()
+	legacy := v1Hashes()
+	writeV1Database(t, dir, legacy)
+	proto := []*Hash{NewHash(testHash(10), "proto", 0), NewHash(testHash(11), "", 0)}
+	raw := openRaw(t, dir)
+	err := raw.Update(func(txn *badger.Txn) error {
+		for _, h := range proto {
+			if err := txn.Set(v1Key(h.Hash, h.HashType), preChangeRecord(h)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	raw.Close()
+	if err != nil {
+		t.Fatalf("failed to write the protobuf records: %v", err)
+	}
+
+	db := newTestDBIn(t, dir, DefaultKeyPrefix, func(opts *Options) { opts.AutoMigrate = true })
+	for _, h := range proto {
+		old := preChangeRecord(h)
+		record := storedRecord(t, db, v1Key(h.Hash, h.HashType))
+		if len(record) != len(old)-sha256.Size || !bytes.Contains(record, SumOfBytes(h.Hash)) {
+			t.Errorf("the record of %s went from %d to %d bytes", h.Hash, len(old), len(record))
+		}
+		checkSumLookups(t, db, h)
+	}
+	for _, h := range legacy {
+		if record := storedRecord(t, db, v1Key(h.Hash, h.HashType)); record[0] != formatLegacy {
+			t.Errorf("the legacy record of %s was rewritten", h.Hash)
+		}
+		checkSumLookups(t, db, h)
+	}
+	if n, err := db.HashesByType(0); err != nil || n != 5 {
+		t.Errorf("hash type 0 counts %d: %v", n, err)
+	}
+
+	// Key only scans read the sum from the key, of either form
+	sums := make(map[string]bool)
+	for h := range db.GetHashesByHashType(0, &ScanOptions{KeysOnly: true}) {
+		sums[h.Sum()] = true
+	}
+	for _, h := range append(proto, legacy[:3]...) {
+		if !sums[SumOf(h.Hash)] {
+			t.Errorf("a key only scan missed %s", h.Hash)
+		}
+	}
+}
+
+// writePreChange writes the protobuf records of hashes with hex sums behind the database's back
+func writePreChange(t *testing.T, db *KDB, hashes []*Hash) {
+	t.Helper()
+	wb := db.c.NewWriteBatch()
+	defer wb.Cancel()
+	for _, h := range hashes {
+		if err := wb.Set(v1Key(h.Hash, h.HashType), preChangeRecord(h)); err != nil {
+			t.Fatalf("failed to write a pre-change record: %v", err)
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		t.Fatalf("failed to write the pre-change records: %v", err)
+	}
+}
+
+// compacted reports whether the record of h holds its sum raw
+func compacted(t *testing.T, db *KDB, h *Hash) bool {
+	t.Helper()
+	var sum []byte
+	err := eachField(storedRecord(t, db, v1Key(h.Hash, h.HashType))[1:], func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num == fieldSum {
+			sum, _ = protowire.ConsumeBytes(b)
+		}
+		return protowire.ConsumeFieldValue(num, typ, b), nil
+	})
+	if err != nil {
+		t.Fatalf("the record of %s doesn't parse: %v", h.Hash, err)
+	}
+	return bytes.Equal(sum, SumOfBytes(h.Hash))
+}
+
+func TestCompactSumsResumes(t *testing.T) {
+	// An upgrade interrupted in type 0: the records up to the cursor were compacted
+	dir := t.TempDir()
+	writeV1Database(t, dir, v1Hashes())
+	var proto []*Hash
+	for i := range 20 {
+		proto = append(proto, NewHash(testHash(100+i), "", []uint64{0, NTLM}[i%2]))
+	}
+	keys := make([]string, 0, 10)
+	for _, h := range proto {
+		if h.HashType == 0 {
+			keys = append(keys, string(v1Key(h.Hash, 0)))
+		}
+	}
+	slices.Sort(keys)
+	cursor := keys[4]
+
+	raw := openRaw(t, dir)
+	err := raw.Update(func(txn *badger.Txn) error {
+		for _, h := range proto {
+			if err := txn.Set(v1Key(h.Hash, h.HashType), preChangeRecord(h)); err != nil {
+				return err
+			}
+		}
+		value := append(binary.BigEndian.AppendUint64(nil, 0), cursor...)
+		return txn.Set([]byte("krkn:meta:"+migrationCursorMetaKey+compactSumsMigration), value)
+	})
+	raw.Close()
+	if err != nil {
+		t.Fatalf("failed to write the interrupted upgrade: %v", err)
+	}
+
+	db := newTestDBIn(t, dir, DefaultKeyPrefix, func(opts *Options) { opts.AutoMigrate = true })
+	for _, h := range proto {
+		key := string(v1Key(h.Hash, h.HashType))
+		if want := h.HashType != 0 || key > cursor; compacted(t, db, h) != want {
+			t.Errorf("%s of type %d compacted %v, want %v", h.Hash, h.HashType, !want, want)
+		}
+		checkSumLookups(t, db, h)
+	}
+	if cursor, err := db.loadMigrationCursor(compactSumsMigration); err != nil || cursor != nil {
+		t.Errorf("the finished upgrade left the cursor %q: %v", cursor, err)
+	}
+
+	// A run longer than a chunk saves the cursor after every chunk
+	more := make([]*Hash, compactSumsChunk+5)
+	for i := range more {
+		more[i] = NewHash(testHash(1000+i), "", NTLM)
+	}
+	writePreChange(t, db, more)
+	if err := compactSums(db); err != nil {
+		t.Fatalf("compactSums: %v", err)
+	}
+	saved, err := db.loadMigrationCursor(compactSumsMigration)
+	if err != nil || len(saved) < 8 || binary.BigEndian.Uint64(saved) != NTLM {
+		t.Fatalf("the saved cursor %q: %v", saved, err)
+	}
+	compactedKeys := 0
+	for _, h := range more {
+		if string(v1Key(h.Hash, NTLM)) <= string(saved[8:]) {
+			compactedKeys++
+		}
+		if !compacted(t, db, h) {
+			t.Fatalf("%s wasn't compacted", h.Hash)
+		}
+	}
+	if compactedKeys != compactSumsChunk {
+		t.Errorf("the cursor is after %d records, want %d", compactedKeys, compactSumsChunk)
+	}
+}
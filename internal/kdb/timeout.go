@@ -0,0 +1,129 @@
+package kdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// errAbandoned is returned by a write its caller gave up on before it began, nobody sees it
+var errAbandoned = errors.New("write abandoned")
+
+const (
+	gatePending   int32 = iota // the write hasn't begun
+	gateStarted                // the write began, its caller waits for the outcome
+	gateAbandoned              // the caller gave up first, the write must not begin
+)
+
+// opGate decides between a write beginning and its caller giving up on it, see awaitWrite
+type opGate struct {
+	state atomic.Int32
+}
+
+// begin reports whether the write may go ahead and marks it started. A nil gate always may, as
+// may a write retried after it began
+func (g *opGate) begin() bool {
+	if g == nil {
+		return true
+	}
+	return g.state.CompareAndSwap(gatePending, gateStarted) || g.state.Load() == gateStarted
+}
+
+// abandon keeps the write from beginning, false if it already began
+func (g *opGate) abandon() bool {
+	return g.state.CompareAndSwap(gatePending, gateAbandoned)
+}
+
+// opResult carries the outcome of a call run by awaitRead or awaitWrite
+type opResult[T any] struct {
+	value T
+	err   error
+}
+
+// withDefaultTimeout runs fn with a context bounded by Options.DefaultOpTimeout, or none if it's 0
+func withDefaultTimeout[T any](kc *KDB, fn func(ctx context.Context) (T, error)) (T, error) {
+	if kc.opts == nil || kc.opts.DefaultOpTimeout <= 0 {
+		return fn(context.Background())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kc.opts.DefaultOpTimeout)
+	defer cancel()
+	return fn(ctx)
+}
+
+// recovered runs fn for op, returning a panic as a *PanicError
+func recovered[T any](kc *KDB, op string, fn func() (T, error)) (value T, err error) {
+	defer kc.recoverPanic(op, &err)
+	return fn()
+}
+
+// awaitRead runs a read until ctx is done. Badger can't interrupt a read waiting on the disk, so
+// one that outlives ctx finishes in the background, holding the database lock until it does, and
+// its result is dropped. A panic of the read is returned as a *PanicError
+func awaitRead[T any](kc *KDB, ctx context.Context, op string, fn func() (T, error)) (T, error) {
+	if ctx.Done() == nil {
+		return recovered(kc, op, fn)
+	}
+	var zero T
+	if ctx.Err() != nil {
+		return zero, kc.opTimedOut(ctx, op)
+	}
+
+	done := make(chan opResult[T], 1)
+	go func() {
+		value, err := recovered(kc, op, fn)
+		done <- opResult[T]{value, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return zero, kc.opTimedOut(ctx, op)
+	}
+}
+
+// awaitWrite runs a write until ctx is done. The write calls gate.begin before it changes
+// anything; if ctx is done first the write is abandoned and never begins, once it began its
+// outcome is waited for and returned whatever ctx says, so a timeout always means nothing was
+// written. A panic of the write is returned as a *PanicError
+func awaitWrite[T any](kc *KDB, ctx context.Context, op string, fn func(gate *opGate) (T, error)) (T, error) {
+	if ctx.Done() == nil {
+		return recovered(kc, op, func() (T, error) { return fn(nil) })
+	}
+	var zero T
+	if ctx.Err() != nil {
+		return zero, kc.opTimedOut(ctx, op)
+	}
+
+	gate := &opGate{}
+	done := make(chan opResult[T], 1)
+	go func() {
+		value, err := recovered(kc, op, func() (T, error) { return fn(gate) })
+		done <- opResult[T]{value, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		if gate.abandon() {
+			return zero, kc.opTimedOut(ctx, op)
+		}
+		r := <-done
+		return r.value, r.err
+	}
+}
+
+// opTimedOut returns the error of a call whose ctx is done: ErrOperationTimeout, counted apart
+// from other errors, for a passed deadline, the context's error otherwise
+func (kc *KDB) opTimedOut(ctx context.Context, op string) error {
+	err := ctx.Err()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	kc.ops.timeouts.Add(1)
+	err = fmt.Errorf("%w: %s: %w", ErrOperationTimeout, op, err)
+	kc.recordError(err)
+	return err
+}
@@ -0,0 +1,273 @@
+package kdb
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// defaultTxRetries is how often Update retries a conflicting transaction when Options.TxRetries is 0
+const defaultTxRetries = 3
+
+// Tx is a transaction opened by KDB.Update or KDB.View. Everything done through it, counter
+// updates included, is committed together or not at all.
+// A Tx is only valid inside the function it was passed to
+type Tx struct {
+	kc  *KDB
+	txn *badger.Txn
+
+	typeDelta  map[uint64]int // pending hash type counter changes
+	totalDelta int            // pending total counter change
+	newTypes   map[uint64]int // pending additions for the quota check
+	saved      int            // bytes saved by compression
+	cracked    []*Hash        // hashes with values, for the crack hooks after commit
+	err        error          // first error an iteration stopped on, see Err
+}
+
+// Update runs fn in a read-write transaction and commits it if fn returns nil. Transactions that
+// conflict with a concurrent write are run again up to Options.TxRetries times, so fn must be safe
+// to call more than once, and fail with ErrTooMuchContention after that. Other KDB methods must
+// not be called from fn
+func (kc *KDB) Update(fn func(tx *Tx) error) error {
+	var tx *Tx
+	err := kc.retryConflicts("transaction", func() error {
+		kc.mu.Lock()
+		defer kc.mu.Unlock()
+
+		return kc.c.Update(func(txn *badger.Txn) error {
+			tx = newTx(kc, txn)
+			if err := fn(tx); err != nil {
+				return err
+			}
+			return tx.commitCounters()
+		})
+	})
+	if errors.Is(err, ErrTooMuchContention) {
+		kc.recordError(err)
+	}
+	if err == nil {
+		kc.fireCracked(tx.cracked...)
+	}
+	return err
+}
+
+// View runs fn in a read-only transaction. Other KDB methods must not be called from fn
+func (kc *KDB) View(fn func(tx *Tx) error) error {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	return kc.c.View(func(txn *badger.Txn) error {
+		return fn(newTx(kc, txn))
+	})
+}
+
+// newTx wraps a badger transaction
+func newTx(kc *KDB, txn *badger.Txn) *Tx {
+	return &Tx{
+		kc:        kc,
+		txn:       txn,
+		typeDelta: make(map[uint64]int),
+		newTypes:  make(map[uint64]int),
+	}
+}
+
+// StoreHash stores a hash in the transaction. Validation and quotas apply as for KDB.StoreHash.
+// Counters only count hashes that weren't stored yet
+func (tx *Tx) StoreHash(sh *Hash) error {
+	if err := tx.kc.validateHash(sh); err != nil {
+		return err
+	}
+	tx.kc.bindHash(sh)
+
+	_, err := tx.txn.Get(sh.Key)
+	exists := err == nil
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+
+	if !exists {
+		pending := make(map[uint64]uint64, len(tx.newTypes)+1)
+		for hashType, n := range tx.newTypes {
+			pending[hashType] = uint64(n)
+		}
+		pending[sh.HashType]++
+		if err := tx.kc.checkQuota(pending); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.kc.indexHash(tx.txn, sh); err != nil {
+		return err
+	}
+
+	data, saved, err := tx.kc.encodeRecord(sh)
+	if err != nil {
+		return fmt.Errorf("failed to encode hash: %w", err)
+	}
+	if err := tx.txn.Set(sh.Key, data); err != nil {
+		return err
+	}
+	tx.kc.dedup.forget(sh.Key)
+
+	tx.saved += saved
+	if !exists {
+		tx.newTypes[sh.HashType]++
+		tx.typeDelta[sh.HashType]++
+		tx.totalDelta++
+	}
+	if sh.Value != "" {
+		tx.cracked = append(tx.cracked, sh)
+	}
+	return nil
+}
+
+// DeleteHash deletes a hash in the transaction.
+// Returns badger.ErrKeyNotFound if the hash is not stored
+func (tx *Tx) DeleteHash(originalHash string, hashType uint64) error {
+	key, err := tx.kc.originalHashKey(tx.txn, originalHash, hashType)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.txn.Get(key); err != nil {
+		return err
+	}
+	stored, err := tx.kc.storedRecord(tx.txn, key)
+	if err != nil {
+		return err
+	}
+	if stored != nil {
+		if err := tx.kc.unindexHash(tx.txn, stored); err != nil {
+			return err
+		}
+	}
+	tx.kc.dedup.forget(key)
+	if err := tx.kc.writeTombstone(tx.txn, hashType, key); err != nil {
+		return err
+	}
+	if err := tx.txn.Delete(key); err != nil {
+		return err
+	}
+
+	tx.typeDelta[hashType]--
+	tx.totalDelta--
+	return nil
+}
+
+// GetHash returns a stored hash as the transaction sees it, including its own writes.
+// Returns badger.ErrKeyNotFound if the hash is not stored
+func (tx *Tx) GetHash(originalHash string, hashType uint64) (*Hash, error) {
+	key, err := tx.kc.originalHashKey(tx.txn, originalHash, hashType)
+	if err != nil {
+		return nil, err
+	}
+	return tx.getHash(key)
+}
+
+// getHash decodes the hash stored under key
+func (tx *Tx) getHash(key []byte) (*Hash, error) {
+	item, err := tx.txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := &Hash{}
+	err = item.Value(func(val []byte) error {
+		return tx.kc.decodeHash(val, hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// Hashes returns an iterator over the stored hashes of a hash type as the transaction sees them.
+// An iteration refused with ErrTooManyIterators yields nothing, check Err after it
+func (tx *Tx) Hashes(hashType uint64) iter.Seq[*Hash] {
+	return func(yield func(*Hash) bool) {
+		if err := tx.hashes(hashType, yield); err != nil && tx.err == nil {
+			tx.err = err
+		}
+	}
+}
+
+// Err returns the first error an iteration of the transaction stopped on, nil if every one ran
+// until its end or the caller stopped it. An iteration that yields nothing has no hashes of its
+// type only if Err is nil
+func (tx *Tx) Err() error {
+	return tx.err
+}
+
+// hashes yields the stored hashes of hashType, returning the error the iteration stopped on
+func (tx *Tx) hashes(hashType uint64, yield func(*Hash) bool) error {
+	yield, recovery := guardYield(tx.kc, "transaction iteration", yield)
+	defer recovery()
+
+	prefix := []byte(tx.kc.keys.key(hashTypeScanPrefix, hashType))
+
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it, err := tx.kc.openIterator(tx.txn, opts)
+	if err != nil {
+		tx.kc.log(fmt.Sprintf("Failed to iterate hash type %d: %v", hashType, err), Error)
+		return err
+	}
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		var hash Hash
+		err := it.Item().Value(func(val []byte) error {
+			return tx.kc.decodeHash(val, &hash)
+		})
+		if err != nil {
+			tx.kc.ops.undecodable.Add(1)
+			tx.kc.log(fmt.Sprintf("Skipping undecodable hash: %v", err), Warning)
+			continue
+		}
+		if !yield(&hash) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// SetMeta stores a value in the meta store in the transaction
+func (tx *Tx) SetMeta(key string, value []byte) error {
+	return tx.txn.Set([]byte(tx.kc.keys.key(metaPrefix, key)), value)
+}
+
+// GetMeta reads a value from the meta store as the transaction sees it.
+// Returns badger.ErrKeyNotFound if the key has never been set
+func (tx *Tx) GetMeta(key string) ([]byte, error) {
+	item, err := tx.txn.Get([]byte(tx.kc.keys.key(metaPrefix, key)))
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+// commitCounters writes the pending counter changes and registers new hash types in the transaction
+func (tx *Tx) commitCounters() error {
+	for hashType, delta := range tx.typeDelta {
+		if delta > 0 {
+			if err := tx.kc.addHashType(tx.txn, hashType); err != nil {
+				return err
+			}
+		}
+		if delta != 0 {
+			if err := tx.kc.addTypeCount(tx.txn, hashType, delta); err != nil {
+				return err
+			}
+		}
+	}
+	if tx.totalDelta != 0 {
+		if err := tx.kc.addShardedCount(tx.txn, tx.kc.keys.key(totalHashesKey), tx.totalDelta); err != nil {
+			return err
+		}
+	}
+	if tx.saved != 0 {
+		return addCount(tx.txn, tx.kc.keys.key(compressionSavedKey), tx.saved)
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+package kdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	// Meta keys naming the account a hash belongs to. The user may carry its own domain
+	UserMetaKey   = "user"
+	DomainMetaKey = "domain"
+
+	// userIndexPrefix is followed by the account name, NUL, the domain, NUL, and hash_type:hex sum
+	userIndexPrefix = "user:"
+)
+
+// NormalizeUser returns the canonical form of an account: "domain\user", or "user" without a
+// domain. The rules, so that every way of writing an account indexes the same:
+//   - case is ignored and surrounding space trimmed: "Bob" is "bob"
+//   - "DOMAIN\user" is user in DOMAIN
+//   - "user@domain" is user in the first label of domain, which is the NetBIOS domain name in
+//     the usual setup: "bob@contoso.com" and "CONTOSO\bob" are both "contoso\bob"
+//   - domain, from the domain meta entry, applies if user names none itself and is reduced the
+//     same way, to its first label
+//
+// NUL characters are dropped. An empty result means there is no account
+func NormalizeUser(user, domain string) string {
+	name, dom := splitUser(user, domain)
+	if name == "" {
+		return ""
+	}
+	if dom == "" {
+		return name
+	}
+	return dom + `\` + name
+}
+
+// splitUser normalizes an account into its name and domain, see NormalizeUser
+func splitUser(user, domain string) (string, string) {
+	clean := func(s string) string {
+		return strings.ToLower(strings.TrimSpace(strings.ReplaceAll(s, "\x00", "")))
+	}
+	user, domain = clean(user), clean(domain)
+
+	if d, name, ok := strings.Cut(user, `\`); ok {
+		user, domain = name, d
+	} else if name, d, ok := strings.Cut(user, "@"); ok {
+		user, domain = name, d
+	}
+	domain, _, _ = strings.Cut(domain, ".")
+	return strings.TrimSpace(user), strings.TrimSpace(domain)
+}
+
+// hashUser returns the account of a hash from its meta entries, "" if it has none
+func hashUser(sh *Hash) (string, string) {
+	if sh == nil || sh.Meta == nil {
+		return "", ""
+	}
+	return splitUser(sh.Meta[UserMetaKey], sh.Meta[DomainMetaKey])
+}
+
+// userIndexKey returns the user index key of a hash of the account name in domain with sum, raw
+// or hex encoded
+func (ks keyspace) userIndexKey(name, domain string, hashType uint64, sum []byte) []byte {
+	key := append([]byte(ks.key(userIndexPrefix)), name...)
+	key = append(key, 0)
+	key = append(key, domain...)
+	key = append(key, 0)
+	key = strconv.AppendUint(key, hashType, 10)
+	key = append(key, ':')
+	return append(key, hexSum(sum)...)
+}
+
+// hashUserKey returns the user index key of a hash, nil if it has no account
+func (ks keyspace) hashUserKey(sh *Hash) []byte {
+	name, domain := hashUser(sh)
+	if name == "" {
+		return nil
+	}
+	return ks.userIndexKey(name, domain, sh.HashType, sh.sum)
+}
+
+// indexUser updates the user index entry of a hash about to be stored over stored, nil if it is new
+func (kc *KDB) indexUser(w indexWriter, sh, stored *Hash) error {
+	key := kc.keys.hashUserKey(sh)
+	if old := kc.keys.hashUserKey(stored); old != nil && !bytes.Equal(old, key) {
+		if err := w.Delete(old); err != nil {
+			return err
+		}
+	}
+	if key == nil {
+		return nil
+	}
+	return w.Set(key, nil)
+}
+
+// GetHashesByUser returns an iterator over the hashes of an account across all hash types. The
+// account is normalized like NormalizeUser: an account with a domain only matches hashes of that
+// domain, one without matches the name in every domain and without one. Hashes are found through
+// the user index, RebuildUserIndex indexes hashes stored before it existed
+func (kc *KDB) GetHashesByUser(user string) iter.Seq[*Hash] {
+	return func(yield func(*Hash) bool) {
+		yield, recovery := guardYield(kc, "user lookup", yield)
+		defer recovery()
+
+		name, domain := splitUser(user, "")
+		if name == "" {
+			return
+		}
+		prefix := append([]byte(kc.keys.key(userIndexPrefix)), name...)
+		prefix = append(prefix, 0)
+		if domain != "" {
+			prefix = append(prefix, domain...)
+			prefix = append(prefix, 0)
+		}
+
+		kc.mu.Lock()
+		defer kc.mu.Unlock()
+		kc.ops.iterations.Add(1)
+
+		err := kc.c.View(func(txn *badger.Txn) error {
+			return kc.scanUserIndex(txn, prefix, yield)
+		})
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to look up hashes of user %q: %v", user, err), Error)
+		}
+	}
+}
+
+// scanUserIndex yields the hashes of the user index entries under prefix. Entries whose hash is
+// gone or belongs to another account by now are skipped
+func (kc *KDB) scanUserIndex(txn *badger.Txn, prefix []byte, yield func(*Hash) bool) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+
+	it := kc.newIterator(txn, opts)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		key := it.Item().Key()
+		// name NUL domain NUL hash_type:sum
+		parts := bytes.SplitN(key[len(kc.keys.key(userIndexPrefix)):], []byte{0}, 3)
+		if len(parts) != 3 {
+			continue
+		}
+		typeStr, hexSum, ok := bytes.Cut(parts[2], []byte(":"))
+		if !ok {
+			continue
+		}
+		hashType, err := strconv.ParseUint(string(typeStr), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		stored, err := kc.storedRecord(txn, []byte(kc.keys.key(storedHashPrefix, hashType, string(hexSum))))
+		if err != nil {
+			return err
+		}
+		if stored == nil || !bytes.Equal(kc.keys.hashUserKey(stored), key) {
+			continue
+		}
+
+		stored.db = kc
+		if !yield(stored) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// UsersWithValue returns the accounts, normalized like NormalizeUser and sorted, whose hashes of
+// any type are cracked to value. It answers "these 14 users share Welcome1". The value index is
+// used when Options.ValueIndex is set, otherwise every value is scanned
+func (kc *KDB) UsersWithValue(ctx context.Context, value string) ([]string, error) {
+	value = kc.normalizeValue(value)
+	if value == "" {
+		return []string{}, nil
+	}
+
+	users := make(map[string]bool)
+	collect := func(hash *Hash) bool {
+		if hash.Value != value {
+			return true
+		}
+		name, domain := hashUser(hash)
+		if name != "" {
+			users[NormalizeUser(name, domain)] = true
+		}
+		return ctx.Err() == nil
+	}
+
+	err := kc.viewLocked(func(txn *badger.Txn) error {
+		hashTypes, err := kc.readHashTypes(txn)
+		if err != nil {
+			return fmt.Errorf("failed to get registered hash types: %w", err)
+		}
+
+		for _, hashType := range hashTypes {
+			if kc.valueIndexEnabled() {
+				prefix := append([]byte(kc.keys.key(valueIndexPrefix, hashType)), appendIndexValue(nil, value)...)
+				prefix = append(prefix, valueIndexTerminator...)
+				err = kc.scanValueIndex(txn, hashType, false, prefix, prefix, collect)
+			} else {
+				err = kc.scan(txn, hashType, "", nil, DefaultScanOptions(), collect)
+			}
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to find users with value: %v", err), Error)
+		return nil, fmt.Errorf("failed to find users with value: %w", err)
+	}
+	return slices.Sorted(func(yield func(string) bool) {
+		for user := range users {
+			if !yield(user) {
+				return
+			}
+		}
+	}), nil
+}
+
+// RebuildUserIndex brings the user index in line with the stored hashes: entries are written for
+// hashes with an account missing one and stale entries are removed. Run it once on databases
+// written before the index existed. Returns the number of entries written and removed
+func (kc *KDB) RebuildUserIndex(ctx context.Context) (int, error) {
+	wb := kc.c.NewWriteBatch()
+	defer wb.Cancel()
+
+	changed := 0
+	err := kc.viewLocked(func(txn *badger.Txn) error {
+		// Existing entries, whatever is left once the records are through is stale
+		existing := make(map[string]bool)
+		indexPrefix := []byte(kc.keys.key(userIndexPrefix))
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = indexPrefix
+		opts.PrefetchValues = false
+		it := kc.newIterator(txn, opts)
+		for it.Seek(indexPrefix); it.ValidForPrefix(indexPrefix); it.Next() {
+			existing[string(it.Item().Key())] = true
+		}
+		it.Close()
+
+		hashTypes, err := kc.readHashTypes(txn)
+		if err != nil {
+			return fmt.Errorf("failed to get registered hash types: %w", err)
+		}
+		for _, hashType := range hashTypes {
+			prefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = prefix
+			it := kc.newIterator(txn, opts)
+
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				if err := ctx.Err(); err != nil {
+					it.Close()
+					return err
+				}
+
+				var hash Hash
+				if err := it.Item().Value(func(val []byte) error {
+					return kc.decodeHash(val, &hash)
+				}); err != nil {
+					continue
+				}
+				// Indexed under the key it is stored under, older records may lack the type
+				hash.HashType, hash.sum = hashType, rawSum(it.Item().Key()[len(prefix):])
+				key := kc.keys.hashUserKey(&hash)
+				if key == nil {
+					continue
+				}
+				if existing[string(key)] {
+					delete(existing, string(key))
+					continue
+				}
+				if err := wb.Set(key, nil); err != nil {
+					it.Close()
+					return err
+				}
+				changed++
+			}
+			it.Close()
+		}
+
+		for key := range existing {
+			if err := wb.Delete([]byte(key)); err != nil {
+				return err
+			}
+			changed++
+		}
+		return nil
+	})
+
+	if err == nil {
+		err = wb.Flush()
+	}
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to rebuild user index: %v", err), Error)
+		return 0, fmt.Errorf("failed to rebuild user index: %w", err)
+	}
+
+	kc.log(fmt.Sprintf("Rebuilt user index, %d entries changed", changed), Info)
+	return changed, nil
+}
@@ -0,0 +1,115 @@
+package kdb
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultMaxHashSize  = 4 << 10 // 4KB
+	defaultMaxValueSize = 1 << 20 // 1MB
+)
+
+// validateHash checks a hash against the input guards before it is written.
+// Every write path (Store, StoreHashes, importers) goes through here
+func (kc *KDB) validateHash(sh *Hash) error {
+	if sh == nil || sh.Hash == "" {
+		return ErrEmptyHash
+	}
+
+	maxHash, maxValue := kc.maxHashSize(sh.HashType), defaultMaxValueSize
+	strict := false
+	if kc.opts != nil {
+		if kc.opts.MaxValueSize > 0 {
+			maxValue = kc.opts.MaxValueSize
+		}
+		strict = kc.opts.StrictValidation
+	}
+
+	if len(sh.Hash) > maxHash {
+		return fmt.Errorf("%w: %d bytes, at most %d allowed", ErrHashTooLarge, len(sh.Hash), maxHash)
+	}
+	if len(sh.Value) > maxValue {
+		return fmt.Errorf("%w: %d bytes, at most %d allowed", ErrValueTooLarge, len(sh.Value), maxValue)
+	}
+
+	if err := validateMeta(sh.Meta); err != nil {
+		return err
+	}
+
+	if strict {
+		if sh.HashType > maxHashcatMode {
+			return fmt.Errorf("%w: %d is not a hashcat mode (0 - %d)", ErrInvalidHashType, sh.HashType, maxHashcatMode)
+		}
+		// Hash strings are text, binary formats belong in Hash.Binary
+		if !utf8.ValidString(sh.Hash) {
+			return fmt.Errorf("%w: hash string is not valid UTF-8", ErrInvalidHash)
+		}
+		if err := checkHashLength(sh.Hash, sh.HashType); err != nil {
+			return err
+		}
+		if IsChallengeResponse(sh.HashType) {
+			if _, err := ParseChallengeResponse(sh.Hash, sh.HashType); err != nil {
+				return err
+			}
+		}
+		if IsKerberosTicket(sh.HashType) {
+			if _, err := ParseKerberosTicket(sh.Hash, sh.HashType); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// maxHashSize returns the longest hash string of hashType accepted, Options.MaxHashSize and at least
+// 64KB for Kerberos tickets
+func (kc *KDB) maxHashSize(hashType uint64) int {
+	maxHash := defaultMaxHashSize
+	if kc.opts != nil && kc.opts.MaxHashSize > 0 {
+		maxHash = kc.opts.MaxHashSize
+	}
+	if IsKerberosTicket(hashType) {
+		maxHash = max(maxHash, defaultMaxTicketSize)
+	}
+	return maxHash
+}
+
+// canonicalHash normalizes a hash string of hashType and rewrites Kerberos tickets in hashcat's
+// format, so the same ticket from different tools is stored and exported the same way
+func canonicalHash(hash string, hashType uint64) string {
+	normalized := normalizeHash(hash)
+	if kt := parseTicket(normalized, hashType); kt != nil {
+		return kt.String()
+	}
+	return normalized
+}
+
+// submittedHash returns a hash string of hashType as it is kept next to its canonical form.
+// Kerberos tickets lose the whitespace Rubeus wraps them with, so they still fit on one line of a
+// potfile, everything else is kept as is
+func submittedHash(hash string, hashType uint64) string {
+	if !IsKerberosTicket(hashType) {
+		return hash
+	}
+	return stripSpace(hash)
+}
+
+// normalizeHash lowercases a hash string for keying and lookup.
+// strings.ToLower replaces invalid UTF-8 with U+FFFD, so strings that aren't valid UTF-8
+// only have their ASCII letters lowered and keep every other byte as is
+func normalizeHash(hash string) string {
+	if utf8.ValidString(hash) {
+		return strings.ToLower(hash)
+	}
+
+	b := []byte(hash)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + ('a' - 'A')
+		}
+	}
+	return string(b)
+}
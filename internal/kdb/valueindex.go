@@ -0,0 +1,303 @@
+package kdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"iter"
+
+	"github.com/dgraph-io/badger/v4"
+	"golang.org/x/text/cases"
+)
+
+const (
+	valueIndexPrefix = "vidx:%d:"  // hash_type, followed by the escaped value, the terminator and the hex sum
+	valueFoldPrefix  = "vfold:%d:" // hash_type, followed by the escaped case folded value, the terminator and the hex sum
+
+	// maxValueIndexBytes is how much of a value the index keeps. Longer values are ordered by
+	// their first maxValueIndexBytes bytes and then by sum
+	maxValueIndexBytes = 512
+)
+
+// Values are escaped so that index keys sort like the values: NUL bytes become 0x00 0xff and
+// the value ends with 0x00 0x01, which sorts before anything a longer value could continue with
+var (
+	valueIndexEscape     = []byte{0x00, 0xff}
+	valueIndexTerminator = []byte{0x00, 0x01}
+)
+
+// valueIndexEnabled returns true if the value index is maintained
+func (kc *KDB) valueIndexEnabled() bool {
+	return kc.opts != nil && kc.opts.ValueIndex
+}
+
+// valueFoldEnabled returns true if the case folded value index is maintained
+func (kc *KDB) valueFoldEnabled() bool {
+	return kc.opts != nil && kc.opts.ValueIndexFolded
+}
+
+// foldValue returns the Unicode case folding of value, the form case-insensitive queries compare.
+// A caser keeps state between calls, so each call gets its own
+func foldValue(value string) string {
+	return cases.Fold().String(value)
+}
+
+// valueIndexKey returns the value index key of a hash with value and sum, raw or hex encoded
+func (ks keyspace) valueIndexKey(hashType uint64, value string, sum []byte) []byte {
+	key := appendIndexValue([]byte(ks.key(valueIndexPrefix, hashType)), value)
+	key = append(key, valueIndexTerminator...)
+	return append(key, hexSum(sum)...)
+}
+
+// valueFoldKey returns the case folded value index key of a hash with value and sum
+func (ks keyspace) valueFoldKey(hashType uint64, value string, sum []byte) []byte {
+	key := appendIndexValue([]byte(ks.key(valueFoldPrefix, hashType)), foldValue(value))
+	key = append(key, valueIndexTerminator...)
+	return append(key, hexSum(sum)...)
+}
+
+// valueKeyFunc returns the key function of the value index, or of the case folded one
+func (ks keyspace) valueKeyFunc(folded bool) func(uint64, string, []byte) []byte {
+	if folded {
+		return ks.valueFoldKey
+	}
+	return ks.valueIndexKey
+}
+
+// appendIndexValue appends value escaped and truncated for the value index
+func appendIndexValue(b []byte, value string) []byte {
+	if len(value) > maxValueIndexBytes {
+		value = value[:maxValueIndexBytes]
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] == 0x00 {
+			b = append(b, valueIndexEscape...)
+			continue
+		}
+		b = append(b, value[i])
+	}
+	return b
+}
+
+// indexValue updates the value index entries of a hash about to be stored over stored, nil if it
+// is new. The entries follow the value as it will be stored, after normalization
+func (kc *KDB) indexValue(w indexWriter, sh, stored *Hash) error {
+	value := kc.normalizeValue(sh.Value)
+	if kc.valueIndexEnabled() {
+		if err := kc.updateValueIndex(w, kc.keys.valueIndexKey, sh, stored, value); err != nil {
+			return err
+		}
+	}
+	if kc.valueFoldEnabled() {
+		return kc.updateValueIndex(w, kc.keys.valueFoldKey, sh, stored, value)
+	}
+	return nil
+}
+
+// updateValueIndex moves the entry of one value index, built by indexKey, to value
+func (kc *KDB) updateValueIndex(w indexWriter, indexKey func(uint64, string, []byte) []byte, sh, stored *Hash, value string) error {
+	var key []byte
+	if value != "" {
+		key = indexKey(sh.HashType, value, sh.sum)
+	}
+	if stored != nil && stored.Value != "" {
+		// Values differing only in case share their case folded entry
+		if old := indexKey(stored.HashType, stored.Value, stored.sum); !bytes.Equal(old, key) {
+			if err := w.Delete(old); err != nil {
+				return err
+			}
+		}
+	}
+	if key == nil {
+		return nil
+	}
+	return w.Set(key, nil)
+}
+
+// GetHashesByValueOrder returns an iterator over the cracked hashes of a hash type ordered by
+// value, bytewise, and by sum for equal values. Iteration starts after startAfter, the last value
+// of the previous page normalized like stored values, or at the start if it is empty. Values longer than 512 bytes are ordered by
+// their first 512 bytes only. Needs Options.ValueIndex, yields nothing without it
+func (kc *KDB) GetHashesByValueOrder(hashType uint64, startAfter string) iter.Seq[*Hash] {
+	return func(yield func(*Hash) bool) {
+		yield, recovery := guardYield(kc, "value order iteration", yield)
+		defer recovery()
+
+		if !kc.valueIndexEnabled() {
+			kc.log("GetHashesByValueOrder needs Options.ValueIndex", Warning)
+			return
+		}
+
+		kc.mu.Lock()
+		defer kc.mu.Unlock()
+		kc.ops.iterations.Add(1)
+
+		prefix := []byte(kc.keys.key(valueIndexPrefix, hashType))
+
+		// Past every entry of startAfter itself, the terminator is followed by the sum
+		seek := prefix
+		if startAfter != "" {
+			seek = appendIndexValue(bytes.Clone(prefix), kc.normalizeValue(startAfter))
+			seek = append(seek, 0x00, 0x02)
+		}
+
+		err := kc.c.View(func(txn *badger.Txn) error {
+			return kc.scanValueIndex(txn, hashType, false, prefix, seek, yield)
+		})
+		if err != nil {
+			kc.log(fmt.Sprintf("Failed to iterate hash type %d by value: %v", hashType, err), Error)
+		}
+	}
+}
+
+// scanValueIndex yields the hashes of the value index entries of hashType under prefix from seek
+// on, of the case folded index if folded is set. Entries the value moved away from, or whose hash
+// is gone, are skipped
+func (kc *KDB) scanValueIndex(txn *badger.Txn, hashType uint64, folded bool, prefix, seek []byte, yield func(*Hash) bool) error {
+	typePrefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+	indexPrefix := []byte(kc.keys.key(valueIndexPrefix, hashType))
+	indexKey := kc.keys.valueKeyFunc(folded)
+	if folded {
+		indexPrefix = []byte(kc.keys.key(valueFoldPrefix, hashType))
+	}
+
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+
+	it := kc.newIterator(txn, opts)
+	defer it.Close()
+
+	for it.Seek(seek); it.ValidForPrefix(prefix); it.Next() {
+		key := it.Item().Key()
+		end := bytes.Index(key[len(indexPrefix):], valueIndexTerminator)
+		if end < 0 {
+			continue
+		}
+		hexSum := key[len(indexPrefix)+end+len(valueIndexTerminator):]
+
+		stored, err := kc.storedRecord(txn, append(bytes.Clone(typePrefix), hexSum...))
+		if err != nil {
+			return err
+		}
+		if stored == nil || !bytes.Equal(indexKey(hashType, stored.Value, hexSum), key) {
+			continue
+		}
+
+		stored.db = kc
+		if !yield(stored) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// RebuildValueOrderIndex brings the value index and the case folded one in line with the stored
+// values: entries are written for cracked hashes missing one and stale entries are removed. Run it
+// after enabling Options.ValueIndex or Options.ValueIndexFolded on an existing database. Returns
+// the number of entries written and removed
+func (kc *KDB) RebuildValueOrderIndex(ctx context.Context) (int, error) {
+	var indexes []bool
+	if kc.valueIndexEnabled() {
+		indexes = append(indexes, false)
+	}
+	if kc.valueFoldEnabled() {
+		indexes = append(indexes, true)
+	}
+	if len(indexes) == 0 {
+		return 0, ErrValueIndexDisabled
+	}
+
+	hashTypes, err := kc.getRegisteredHashTypes()
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to get registered hash types: %v", err), Error)
+		return 0, fmt.Errorf("failed to get registered hash types: %w", err)
+	}
+
+	changed := 0
+	for _, hashType := range hashTypes {
+		for _, folded := range indexes {
+			n, err := kc.rebuildTypeValueIndex(ctx, hashType, folded)
+			changed += n
+			if err != nil {
+				kc.log(fmt.Sprintf("Failed to rebuild value index of hash type %d: %v", hashType, err), Error)
+				return changed, fmt.Errorf("failed to rebuild value index of hash type %d: %w", hashType, err)
+			}
+		}
+	}
+
+	kc.log(fmt.Sprintf("Rebuilt value index, %d entries changed", changed), Info)
+	return changed, nil
+}
+
+// rebuildTypeValueIndex rebuilds the value index of one hash type, or its case folded index if
+// folded is set
+func (kc *KDB) rebuildTypeValueIndex(ctx context.Context, hashType uint64, folded bool) (int, error) {
+	indexPrefix := []byte(kc.keys.key(valueIndexPrefix, hashType))
+	indexKey := kc.keys.valueKeyFunc(folded)
+	if folded {
+		indexPrefix = []byte(kc.keys.key(valueFoldPrefix, hashType))
+	}
+	prefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+
+	wb := kc.c.NewWriteBatch()
+	defer wb.Cancel()
+
+	changed := 0
+	err := kc.viewLocked(func(txn *badger.Txn) error {
+		// Existing entries, whatever is left once the records are through is stale
+		existing := make(map[string]bool)
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = indexPrefix
+		opts.PrefetchValues = false
+		it := kc.newIterator(txn, opts)
+		for it.Seek(indexPrefix); it.ValidForPrefix(indexPrefix); it.Next() {
+			existing[string(it.Item().Key())] = true
+		}
+		it.Close()
+
+		opts = badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it = kc.newIterator(txn, opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var hash Hash
+			if err := it.Item().Value(func(val []byte) error {
+				return kc.decodeHash(val, &hash)
+			}); err != nil || hash.Value == "" {
+				continue
+			}
+
+			key := indexKey(hashType, hash.Value, it.Item().Key()[len(prefix):])
+			if existing[string(key)] {
+				delete(existing, string(key))
+				continue
+			}
+			if err := wb.Set(key, nil); err != nil {
+				return err
+			}
+			changed++
+		}
+
+		for key := range existing {
+			if err := wb.Delete([]byte(key)); err != nil {
+				return err
+			}
+			changed++
+		}
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
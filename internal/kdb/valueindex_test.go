@@ -0,0 +1,78 @@
+package kdb
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestValueIndexBatchDuplicates(t *testing.T) {
+	db := newTestDB(t, testPrefix, func(opts *Options) {
+		opts.ValueIndex = true
+		opts.ValueIndexFolded = true
+		opts.NormalizeValuesNFC = true
+	})
+
+	// The second copy replaces the value of the first, stored and indexed in NFC
+	_, err := db.StoreHashes([]*Hash{
+		NewHash(testHash(1), "fi\u0301rst", 0),
+		NewHash(testHash(1), "Second", 0),
+		NewHash(testHash(2), "other", 0),
+	})
+	if err != nil {
+		t.Fatalf("StoreHashes: %v", err)
+	}
+
+	var values []string
+	for h := range db.GetHashesByValueOrder(0, "") {
+		values = append(values, h.Value)
+	}
+	if want := []string{"Second", "other"}; !slices.Equal(values, want) {
+		t.Errorf("value order %q, want %q", values, want)
+	}
+
+	report, err := db.VerifyIndexes(context.Background(), []IndexName{IndexValue, IndexValueFolded}, 1)
+	if err != nil || !report.OK() {
+		t.Errorf("the batch left index drift: %s %v", report.String(), err)
+	}
+}
+
+func TestValueLookupsNormalizeTheQuery(t *testing.T) {
+	const nfc, nfd = "Caf\u00e9!", "Cafe\u0301!"
+	for _, indexed := range []bool{false, true} {
+		db := newTestDB(t, testPrefix, func(opts *Options) {
+			opts.ValueIndex = indexed
+			opts.ValueIndexFolded = indexed
+			opts.NormalizeValuesNFC = true
+		})
+		for i, value := range []string{"Apple", nfc, "Zebra"} {
+			if _, err := db.StoreHash(NewHash(testHash(i), value, 0)); err != nil {
+				t.Fatalf("store %q: %v", value, err)
+			}
+		}
+
+		queries := map[string]*QueryBuilder{
+			"equals":                  Query().ValueEquals(nfd),
+			"prefix":                  Query().ValuePrefix("Cafe\u0301"),
+			"contains":                Query().ValueContains("fe\u0301"),
+			"case-insensitive equals": Query().ValueEquals("CAFE\u0301!").CaseInsensitive(),
+			"case-insensitive prefix": Query().ValuePrefix("cafe\u0301").CaseInsensitive(),
+		}
+		for name, q := range queries {
+			if n, err := db.Count(context.Background(), q); err != nil || n != 1 {
+				t.Errorf("indexed %v, %s counted %d: %v (%s)", indexed, name, n, err, q.Explain())
+			}
+		}
+
+		if !indexed {
+			continue
+		}
+		var values []string
+		for h := range db.GetHashesByValueOrder(0, nfd) {
+			values = append(values, h.Value)
+		}
+		if want := []string{"Zebra"}; !slices.Equal(values, want) {
+			t.Errorf("after %q came %q, want %q", nfd, values, want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package kdb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+)
+
+// digestModes are the unsalted hashcat modes that are a digest of the plaintext bytes
+var digestModes = map[uint64]util.Digest{
+	0:     util.MD5,
+	100:   util.SHA1,
+	900:   util.MD4,
+	1300:  util.SHA224,
+	1400:  util.SHA256,
+	1700:  util.SHA512,
+	2600:  util.MD5.Of(util.MD5),
+	4400:  util.MD5.Of(util.SHA1),
+	4500:  util.SHA1.Of(util.SHA1),
+	4700:  util.SHA1.Of(util.MD5),
+	10800: util.SHA384,
+	17300: util.SHA3224,
+	17400: util.SHA3256,
+	17500: util.SHA3384,
+	17600: util.SHA3512,
+}
+
+// utf16Modes are the unsalted hashcat modes that are a digest of the UTF-16LE plaintext, NTLM
+// among them
+var utf16Modes = map[uint64]util.Digest{
+	70:   util.MD5,
+	170:  util.SHA1,
+	1000: util.MD4,
+	1470: util.SHA256,
+	1770: util.SHA512,
+}
+
+// CanVerify returns true for the hash types VerifyCrack computes locally
+func CanVerify(hashType uint64) bool {
+	_, digest := digestModes[hashType]
+	_, utf16 := utf16Modes[hashType]
+	return digest || utf16 || hashType == LM
+}
+
+// VerifyCrack hashes value locally and reports whether it is the plaintext of hash. Only the fast
+// unsalted modes are computed: MD4, MD5, the SHA1, SHA2 and SHA3 sums, their UTF-16LE variants
+// such as NTLM, md5(md5), sha1(sha1), md5(sha1) and sha1(md5), and LM, whole or one half of it.
+// Returns ErrVerificationUnsupported for other modes and LM plaintexts that aren't ASCII, and
+// ErrInvalidHash for a hash that isn't the length of its mode's digest
+func (kc *KDB) VerifyCrack(hash string, hashType uint64, value string) (bool, error) {
+	hash = normalizeHash(strings.TrimSpace(hash))
+
+	// hashcat cracks LM in halves of 7 characters, the potfile holds the half hashes
+	if hashType == LM && len(hash) == 16 {
+		if value != "" && !isASCII(value) {
+			return false, fmt.Errorf("%w: LM encodes non-ASCII plaintexts in the OEM code page", ErrVerificationUnsupported)
+		}
+		if len(value) > 7 {
+			return false, nil
+		}
+		full, _ := util.LMHash(value)
+		return util.ConstantTimeEqualHex(hash, full[:16]), nil
+	}
+
+	computed, err := ComputeHash(value, hashType)
+	if errors.Is(err, errLMTooLong) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if len(hash) != len(computed) {
+		return false, fmt.Errorf("%w: %d characters, hash type %d has %d", ErrInvalidHash, len(hash), hashType, len(computed))
+	}
+	return util.ConstantTimeEqualHex(hash, computed), nil
+}
+
+// errLMTooLong is returned by ComputeHash for LM plaintexts longer than 14 characters
+var errLMTooLong = fmt.Errorf("%w: LM plaintexts are at most 14 characters", ErrVerificationUnsupported)
+
+// ComputeHash returns the hash of value as hashType in hex, for the modes CanVerify reports.
+// Returns ErrVerificationUnsupported for other modes and LM plaintexts that aren't ASCII or are
+// longer than 14 characters
+func ComputeHash(value string, hashType uint64) (string, error) {
+	switch {
+	case hashType == LM:
+		if value != "" && !isASCII(value) {
+			return "", fmt.Errorf("%w: LM encodes non-ASCII plaintexts in the OEM code page", ErrVerificationUnsupported)
+		}
+		full, ok := util.LMHash(value)
+		if !ok {
+			return "", errLMTooLong
+		}
+		return full, nil
+	case digestModes[hashType] != nil:
+		return digestModes[hashType].Hex([]byte(value)), nil
+	case utf16Modes[hashType] != nil:
+		return utf16Modes[hashType].Hex(util.UTF16LE(value)), nil
+	default:
+		return "", fmt.Errorf("%w: hash type %d", ErrVerificationUnsupported, hashType)
+	}
+}
+
+// isASCII returns true if s has no bytes above 0x7f
+func isASCII(s string) bool {
+	for i := range len(s) {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyFound checks the value of an imported hash with VerifyCrack for Options.VerifyCracks.
+// Returns false for a value that doesn't hash to the hash, which is counted as rejected. Hashes
+// without a value, salted hashes and modes that can't be computed are counted as unverified
+// and imported
+func (im *importer) verifyFound(line int, sh *Hash) (bool, error) {
+	if sh.Value == "" {
+		return true, nil
+	}
+	if sh.Salt != "" {
+		im.report.Unverified++
+		return true, nil
+	}
+
+	ok, err := im.kc.VerifyCrack(sh.Hash, sh.HashType, sh.Value)
+	switch {
+	case errors.Is(err, ErrVerificationUnsupported):
+		im.report.Unverified++
+		return true, nil
+	case err != nil:
+		return false, err
+	case !ok:
+		im.report.Rejected++
+		if len(im.report.Errors) < importMaxErrorLines {
+			im.report.Errors = append(im.report.Errors, fmt.Sprintf("line %d: value doesn't hash to %s", line, sh.Hash))
+		}
+		return false, nil
+	}
+	return true, nil
+}
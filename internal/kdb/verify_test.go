@@ -0,0 +1,85 @@
+package kdb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// verifyVectors are known digests of every mode VerifyCrack computes
+var verifyVectors = []struct {
+	hashType uint64
+	plain    string
+	hash     string
+}{
+	{0, "password", "5f4dcc3b5aa765d61d8327deb882cf99"},
+	{100, "password", "5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8"},
+	{1300, "password", "d63dc919e201d7bc4c825630d2cf25fdc93d4b2f0d46706d29038d01"},
+	{1400, "password", "5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8"},
+	{1700, "password", "b109f3bbbc244eb82441917ed06d618b9008dd09b3befd1b5e07394c706a8bb980b1d7785e5976ec049b46df5f1326af5a2ea6d103fd07c95385ffab0cacbc86"},
+	{10800, "password", "a8b64babd0aca91a59bdbb7761b421d4f2bb38280d3a75ba0f21f2bebc45583d446c598660c94ce680c47d19c30783a7"},
+	{17300, "password", "c3f847612c3780385a859a1993dfd9fe7c4e6d7f477148e527e9374c"},
+	{17400, "password", "c0067d4af4e87f00dbac63b6156828237059172d1bbeac67427345d6a9fda484"},
+	{17500, "password", "9c1565e99afa2ce7800e96a73c125363c06697c5674d59f227b3368fd00b85ead506eefa90702673d873cb2c9357eafc"},
+	{17600, "password", "e9a75486736a550af4fea861e2378305c4a555a05094dee1dca2f68afea49cc3a50e8de6ea131ea521311f4d6fb054a146e8282f8e35ff2e6368c1a62e909716"},
+	{2600, "password", "696d29e0940a4957748fe3fc9efd22a3"},
+	{4400, "password", "1619d7adc23f4f633f11014d2f22b7d8"},
+	{4500, "password", "353e8061f2befecb6818ba0c034c632fb0bcae1b"},
+	{4700, "password", "55c3b5386c486feb662a0785f340938f518d547f"},
+	{70, "password", "b081dbe85e1ec3ffc3d4e7d0227400cd"},
+	{170, "password", "e8f97fba9104d1ea5047948e6dfb67facd9f5b73"},
+	{1470, "password", "e201065d0554652615c320c00a1d5bc8edca469d72c2790e24152d0c1e2b6189"},
+	{1770, "password", "a7c6bf7982f92ed197af475f194d3b750d0b26c0d555f3aad6e00849320062ce4b0628038360725174962662e7bae8e484adf2ad20b20d2d3ca67ee3f7b9f856"},
+	// RFC 1320
+	{900, "", "31d6cfe0d16ae931b73c59d7e0c089c0"},
+	{900, "abc", "a448017aaf21d8525fc10ae87aa6729d"},
+	{900, "message digest", "d9130a8164549fe818874806e1c7014b"},
+	{900, "12345678901234567890123456789012345678901234567890123456789012345678901234567890", "e33b4ddc9c38f2199c3e7b164fcc0536"},
+	{1000, "password", "8846f7eaee8fb117ad06bdd830b7586c"},
+	{1000, "Password1", "64F12CDDAA88057E06A81B54E73B949B"},
+	{3000, "password", "e52cac67419a9a224a3b108f3fa6cb6d"},
+	{3000, "PASSWORD1", "e52cac67419a9a2238f10713b629b565"},
+	{3000, "", "aad3b435b51404eeaad3b435b51404ee"},
+	{3000, "PASSWOR", "e52cac67419a9a22"}, // halves, as hashcat's potfile has them
+	{3000, "D1", "38f10713b629b565"},
+}
+
+func TestVerifyCrack(t *testing.T) {
+	db := newTestDB(t, testPrefix, nil)
+	for _, v := range verifyVectors {
+		if ok, err := db.VerifyCrack(v.hash, v.hashType, v.plain); !ok || err != nil {
+			t.Errorf("mode %d, %q: %v %v", v.hashType, v.plain, ok, err)
+		}
+		if ok, err := db.VerifyCrack(v.hash, v.hashType, v.plain+"x"); ok || err != nil {
+			t.Errorf("mode %d accepted a wrong plaintext: %v", v.hashType, err)
+		}
+	}
+	if _, err := db.VerifyCrack("$2a$05$LhayLxezLhK1LhWvKxCyLOj0j1u.Kj0jZ0pEmm134uzrQlFvQJLF6", Bcrypt, "hashcat"); !errors.Is(err, ErrVerificationUnsupported) {
+		t.Errorf("bcrypt: %v", err)
+	}
+	if _, err := db.VerifyCrack("5f4dcc3b5aa765d61d8327deb882cf", MD5, "password"); !errors.Is(err, ErrInvalidHash) {
+		t.Errorf("a short MD5: %v", err)
+	}
+}
+
+func TestImportRejectsLyingCracks(t *testing.T) {
+	db := newTestDB(t, testPrefix, func(opts *Options) { opts.VerifyCracks = true })
+	pot := strings.Join([]string{
+		"5f4dcc3b5aa765d61d8327deb882cf99:password",
+		"5f4dcc3b5aa765d61d8327deb882cf99:Password", // lies
+		"e10adc3949ba59abbe56e057f20f883e:123456",
+		"e10adc3949ba59abbe56e057f20f883e:654321", // lies
+	}, "\n")
+	report, err := db.ImportPotfile(strings.NewReader(pot), MD5)
+	if err != nil || report.Imported != 2 || report.Rejected != 2 {
+		t.Errorf("the MD5 potfile: %+v %v", report, err)
+	}
+	if got, err := db.GetHashByOriginalHash("5f4dcc3b5aa765d61d8327deb882cf99", MD5); err != nil || got.Value != "password" {
+		t.Errorf("the MD5 value after the import: %+v %v", got, err)
+	}
+
+	report, err = db.ImportPotfile(strings.NewReader("$2a$05$LhayLxezLhK1LhWvKxCyLOj0j1u.Kj0jZ0pEmm134uzrQlFvQJLF6:hashcat"), Bcrypt)
+	if err != nil || report.Imported != 1 || report.Unverified != 1 {
+		t.Errorf("the bcrypt potfile: %+v %v", report, err)
+	}
+}
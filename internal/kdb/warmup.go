@@ -0,0 +1,221 @@
+package kdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	warmupBatch         = 1000 // keys read per batch, the database is unlocked between batches
+	defaultWarmupRate   = 50000
+	defaultWarmupSample = 0.1
+)
+
+// WarmupStatus is the progress of the cache warmup started by Warmup or Options.WarmupOnOpen
+type WarmupStatus struct {
+	Running  bool    `json:"running"`
+	Complete bool    `json:"complete"` // The last warmup read every key it set out to
+	Keys     uint64  `json:"keys"`     // Keys read so far
+	Total    uint64  `json:"total"`    // Keys to read, from the counters
+	Percent  float64 `json:"percent"`  // 100 once complete
+}
+
+// warmupState tracks the running warmup for WarmupStatus
+type warmupState struct {
+	running  atomic.Bool
+	complete atomic.Bool
+	keys     atomic.Uint64
+	total    atomic.Uint64
+}
+
+// WarmupStatus returns the progress of the current or last warmup
+func (kc *KDB) WarmupStatus() WarmupStatus {
+	status := WarmupStatus{
+		Running:  kc.warmup.running.Load(),
+		Complete: kc.warmup.complete.Load(),
+		Keys:     kc.warmup.keys.Load(),
+		Total:    kc.warmup.total.Load(),
+	}
+	switch {
+	case status.Complete:
+		status.Percent = 100
+	case status.Total > 0:
+		status.Percent = min(99.9, math.Floor(float64(status.Keys)*1000/float64(status.Total))/10)
+	}
+	return status
+}
+
+// Warmup reads the keys of hashTypes, nil for every registered type, and the values of fraction of
+// them to fill badger's block and index caches after a restart. Keys are read in batches of 1000
+// at no more than Options.WarmupRate keys a second, unlocking the database in between so foreground
+// reads and writes go first. Progress is logged every 10% and reported by WarmupStatus. Returns
+// ErrWarmupRunning if a warmup is already running, a cancelled ctx stops it with the context's error
+func (kc *KDB) Warmup(ctx context.Context, hashTypes []uint64, fraction float64) error {
+	if kc.Nil() || kc.c.IsClosed() {
+		return ErrNotInitialized
+	}
+	if !kc.warmup.running.CompareAndSwap(false, true) {
+		return ErrWarmupRunning
+	}
+	defer kc.warmup.running.Store(false)
+
+	kc.warmup.complete.Store(false)
+	kc.warmup.keys.Store(0)
+	kc.warmup.total.Store(0)
+
+	if len(hashTypes) == 0 {
+		var err error
+		if hashTypes, err = kc.getRegisteredHashTypes(); err != nil {
+			return fmt.Errorf("failed to get registered hash types: %w", err)
+		}
+	}
+
+	var total uint64
+	for _, hashType := range hashTypes {
+		count, err := kc.typeCount(hashType)
+		if err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to read count for hash type %d: %w", hashType, err)
+		}
+		total += uint64(max(count, 0))
+	}
+	kc.warmup.total.Store(total)
+
+	rate := defaultWarmupRate
+	if kc.opts != nil && kc.opts.WarmupRate > 0 {
+		rate = kc.opts.WarmupRate
+	}
+	every := 0 // read the value of every nth key, 0 reads none
+	if fraction > 0 {
+		every = max(1, int(math.Round(1/fraction)))
+	}
+
+	kc.log(fmt.Sprintf("Warming up caches: %d hashes of %d hash types", total, len(hashTypes)), Info)
+	start := time.Now()
+	logged := 0
+	for _, hashType := range hashTypes {
+		prefix := []byte(kc.keys.key(hashTypeScanPrefix, hashType))
+		var last []byte
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			batchStart := time.Now()
+			var (
+				n   int
+				err error
+			)
+			if last, n, err = kc.warmupBatch(prefix, last, every); err != nil {
+				kc.log(fmt.Sprintf("Warmup of hash type %d failed: %v", hashType, err), Error)
+				return fmt.Errorf("failed to warm up hash type %d: %w", hashType, err)
+			}
+
+			keys := kc.warmup.keys.Add(uint64(n))
+			if total > 0 {
+				if step := int(min(keys*10/total, 10)); step > logged && step < 10 {
+					logged = step
+					kc.log(fmt.Sprintf("Warmup %d%% done, %d of %d keys", step*10, keys, total), Info)
+				}
+			}
+			if n < warmupBatch {
+				break
+			}
+
+			// Sleep off what's left of the batch's share of the rate
+			wait := time.Duration(warmupBatch)*time.Second/time.Duration(rate) - time.Since(batchStart)
+			if err := sleepContext(ctx, wait); err != nil {
+				return err
+			}
+		}
+	}
+
+	kc.warmup.complete.Store(true)
+	kc.log(fmt.Sprintf("Warmup done in %s: %d keys", time.Since(start).Round(time.Millisecond), kc.warmup.keys.Load()), Info)
+	return nil
+}
+
+// warmupBatch reads up to warmupBatch keys under prefix after last, and the values of every nth.
+// Returns the last key read and how many were read
+func (kc *KDB) warmupBatch(prefix, last []byte, every int) ([]byte, int, error) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	if kc.c.IsClosed() {
+		return nil, 0, ErrNotInitialized
+	}
+
+	n := 0
+	err := kc.c.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		opts.PrefetchValues = false
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		if last == nil {
+			it.Seek(prefix)
+		} else {
+			it.Seek(last)
+			if it.ValidForPrefix(prefix) && bytes.Equal(it.Item().Key(), last) {
+				it.Next()
+			}
+		}
+
+		for ; it.ValidForPrefix(prefix) && n < warmupBatch; it.Next() {
+			item := it.Item()
+			if every > 0 && (kc.warmup.keys.Load()+uint64(n))%uint64(every) == 0 {
+				if err := item.Value(func([]byte) error { return nil }); err != nil {
+					return err
+				}
+			}
+			last = item.KeyCopy(last[:0])
+			n++
+		}
+		return nil
+	})
+	return last, n, err
+}
+
+// runWarmupOnOpen warms up every registered hash type in the background for Options.WarmupOnOpen,
+// stopping when the database is closed
+func (kc *KDB) runWarmupOnOpen() {
+	defer kc.recoverBackground("warmup on open")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-kc.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	fraction := defaultWarmupSample
+	if kc.opts != nil && kc.opts.WarmupFraction > 0 {
+		fraction = kc.opts.WarmupFraction
+	}
+	if err := kc.Warmup(ctx, nil, fraction); err != nil && ctx.Err() == nil && !kc.c.IsClosed() {
+		kc.log(fmt.Sprintf("Warmup on open failed: %v", err), Warning)
+	}
+}
+
+// sleepContext sleeps for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,183 @@
+package kdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	defaultWatchPollInterval = 2 * time.Second   // Poll interval when WatchOptions.PollInterval is 0
+	potfileOffsetMetaKey     = "potfile_offset:" // Meta key prefix of the read offsets, followed by the potfile path
+)
+
+// WatchOptions controls WatchPotfile
+type WatchOptions struct {
+	PollInterval time.Duration  // How often the file is checked without a change notification, 0 uses the default
+	OnConflict   ConflictPolicy // What to do with hashes already stored with a different value
+	Source       *Source        // Recorded on every imported hash as the attack that cracked it
+}
+
+// WatchPotfile tails a hashcat potfile and imports appended lines as hashes of hashType until ctx
+// is done. Changes are picked up through file system notifications with polling as a fallback.
+// The read offset is kept in the meta store so a restart continues where it stopped, and a file
+// that shrinks (truncated or rotated) is read again from the start. Hashes already stored with the
+// same value are skipped and lines that can't be imported are logged and skipped.
+// Returns nil when ctx is done
+func (kc *KDB) WatchPotfile(ctx context.Context, path string, hashType uint64, opts WatchOptions) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path for '%s': %w", path, err)
+	}
+
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = defaultWatchPollInterval
+	}
+
+	// Notifications only make the watcher react faster, polling keeps it working without them
+	var events chan fsnotify.Event
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		defer watcher.Close()
+		if err = watcher.Add(filepath.Dir(absPath)); err == nil {
+			events = watcher.Events
+		}
+	}
+	if err != nil {
+		kc.log(fmt.Sprintf("File notifications unavailable for '%s', polling every %v: %v", absPath, interval, err), Warning)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := kc.ingestPotfile(absPath, hashType, opts); err != nil {
+			kc.log(fmt.Sprintf("Failed to read potfile '%s': %v", absPath, err), Error)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case event := <-events:
+			if event.Name != absPath {
+				continue
+			}
+		}
+	}
+}
+
+// ingestPotfile imports the complete lines appended to the potfile since the stored offset
+func (kc *KDB) ingestPotfile(path string, hashType uint64, opts WatchOptions) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // Not created yet, or between a rotation and the new file
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	offset, err := kc.potfileOffset(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() < offset {
+		kc.log(fmt.Sprintf("Potfile '%s' shrank, reading it again from the start", path), Warning)
+		offset = 0
+	}
+	if info.Size() == offset {
+		return nil
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	im := kc.newImporter()
+	im.checkConflicts = true
+	im.onConflict = opts.OnConflict
+
+	// Only complete lines are consumed, hashcat may be halfway through writing the last one
+	reader := bufio.NewReader(io.LimitReader(f, info.Size()-offset))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		offset += int64(len(line))
+
+		text := string(bytes.TrimRight(line, "\r\n"))
+		if text == "" {
+			continue
+		}
+		im.report.Lines++
+
+		sh, err := kc.parsePotfileLine(text, hashType)
+		if err != nil {
+			kc.log(fmt.Sprintf("Skipping potfile line at offset %d: %v", offset-int64(len(line)), err), Warning)
+			im.invalid(im.report.Lines, err)
+			continue
+		}
+		sh.Source = opts.Source
+
+		if err := im.add(im.report.Lines, sh); err != nil {
+			return err
+		}
+		// The offset is saved with every flushed batch so a crash re-imports at most one batch
+		if len(im.batch) == 0 {
+			if err := kc.setPotfileOffset(path, offset); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := im.finish(); err != nil {
+		return err
+	}
+	for _, problem := range im.report.Errors {
+		kc.log(fmt.Sprintf("Skipped potfile entry: %s", problem), Warning)
+	}
+
+	return kc.setPotfileOffset(path, offset)
+}
+
+// potfileOffset returns how far a potfile has been read
+func (kc *KDB) potfileOffset(path string) (int64, error) {
+	value, err := kc.GetMeta(potfileOffsetMetaKey + path)
+	if isNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(value) != 8 {
+		return 0, nil
+	}
+	return int64(binary.BigEndian.Uint64(value)), nil
+}
+
+// setPotfileOffset records how far a potfile has been read
+func (kc *KDB) setPotfileOffset(path string, offset int64) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(offset))
+	return kc.SetMeta(potfileOffsetMetaKey+path, value)
+}
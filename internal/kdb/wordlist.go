@@ -0,0 +1,347 @@
+package kdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/util"
+	"github.com/dgraph-io/badger/v4"
+)
+
+const (
+	wordPrefix          = "word:%s"            // word sum, value is the membership bitmap
+	wordScanPrefix      = "word:"              // used to scan every word
+	wordlistRegistryKey = "registry:wordlists" // Stores the wordlist names, ids and sizes
+	wordlistBatchSize   = 1000                 // Words written per transaction during imports
+)
+
+// WordlistInfo describes an imported wordlist
+type WordlistInfo struct {
+	Name  string `json:"name"`
+	ID    int    `json:"id"`    // Bit of the wordlist in the membership bitmaps
+	Words int    `json:"words"` // Distinct words in the wordlist
+}
+
+// ImportWordlist stores every line of r as a member of the wordlist name. Importing into an
+// existing name adds to it. Words are kept in their own keyspace keyed by their sum, so they never
+// show up in hash counts, exports or recounts. Returns the number of non-blank lines read
+func (kc *KDB) ImportWordlist(name string, r io.Reader) (int, error) {
+	info, err := kc.wordlistFor(name)
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to register wordlist '%s': %v", name, err), Error)
+		return 0, fmt.Errorf("failed to register wordlist '%s': %w", name, err)
+	}
+
+	lines, added := 0, 0
+	batch := make([]string, 0, wordlistBatchSize)
+	flush := func() error {
+		n, err := kc.addWords(info.ID, batch)
+		added += n
+		batch = batch[:0]
+		return err
+	}
+
+	err = scanLines(r, func(_ int, text string) error {
+		lines++
+		batch = append(batch, kc.normalizeValue(text))
+		if len(batch) >= wordlistBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+
+	// Record what made it in, also when the import stopped early
+	if regErr := kc.updateWordlist(name, added); regErr != nil && err == nil {
+		err = regErr
+	}
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to import wordlist '%s': %v", name, err), Error)
+		return lines, fmt.Errorf("failed to import wordlist '%s': %w", name, err)
+	}
+
+	kc.log(fmt.Sprintf("Imported %d lines into wordlist '%s', %d new words", lines, name, added), Info)
+	return lines, nil
+}
+
+// WordInLists returns the names of the wordlists containing word
+func (kc *KDB) WordInLists(word string) ([]string, error) {
+	key := []byte(kc.keys.key(wordPrefix, util.SHA256Sum(kc.normalizeValue(word))))
+
+	var bitmap []byte
+	err := kc.viewLocked(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		bitmap, err = item.ValueCopy(nil)
+		return err
+	})
+
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up word: %w", err)
+	}
+
+	lists, err := kc.ListWordlists()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, info := range lists {
+		if bitmapHas(bitmap, info.ID) {
+			names = append(names, info.Name)
+		}
+	}
+	return names, nil
+}
+
+// ListWordlists returns every imported wordlist with its size, sorted by name
+func (kc *KDB) ListWordlists() ([]WordlistInfo, error) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	var lists []WordlistInfo
+	err := kc.c.View(func(txn *badger.Txn) error {
+		var err error
+		lists, err = kc.readWordlists(txn)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wordlist registry: %w", err)
+	}
+
+	sort.Slice(lists, func(i, j int) bool { return lists[i].Name < lists[j].Name })
+	return lists, nil
+}
+
+// DropWordlist removes a wordlist. Words only it contained are deleted, shared words lose its bit.
+// Returns ErrWordlistNotFound for an unknown name
+func (kc *KDB) DropWordlist(name string) error {
+	lists, err := kc.ListWordlists()
+	if err != nil {
+		return err
+	}
+
+	id := -1
+	for _, info := range lists {
+		if info.Name == name {
+			id = info.ID
+		}
+	}
+	if id < 0 {
+		return fmt.Errorf("%w: %s", ErrWordlistNotFound, name)
+	}
+
+	prefix := []byte(kc.keys.key(wordScanPrefix))
+	wb := kc.c.NewWriteBatch()
+	defer wb.Cancel()
+
+	err = kc.viewLocked(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+
+		it := kc.newIterator(txn, opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			bitmap, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if !bitmapHas(bitmap, id) {
+				continue
+			}
+
+			bitmap = bitmapClear(bitmap, id)
+			if len(bitmap) == 0 {
+				err = wb.Delete(it.Item().KeyCopy(nil))
+			} else {
+				err = wb.Set(it.Item().KeyCopy(nil), bitmap)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err == nil {
+		err = wb.Flush()
+	}
+	if err != nil {
+		kc.log(fmt.Sprintf("Failed to drop wordlist '%s': %v", name, err), Error)
+		return fmt.Errorf("failed to drop wordlist '%s': %w", name, err)
+	}
+
+	// The id is only freed once no word carries its bit any more
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	return kc.c.Update(func(txn *badger.Txn) error {
+		lists, err := kc.readWordlists(txn)
+		if err != nil {
+			return err
+		}
+		kept := lists[:0]
+		for _, info := range lists {
+			if info.Name != name {
+				kept = append(kept, info)
+			}
+		}
+		return kc.writeWordlists(txn, kept)
+	})
+}
+
+// wordlistFor returns the registry entry of a wordlist, registering it with the lowest free id if it's new
+func (kc *KDB) wordlistFor(name string) (WordlistInfo, error) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	var info WordlistInfo
+	err := kc.c.Update(func(txn *badger.Txn) error {
+		lists, err := kc.readWordlists(txn)
+		if err != nil {
+			return err
+		}
+
+		used := make(map[int]bool, len(lists))
+		for _, existing := range lists {
+			if existing.Name == name {
+				info = existing
+				return nil
+			}
+			used[existing.ID] = true
+		}
+
+		id := 0
+		for used[id] {
+			id++
+		}
+		info = WordlistInfo{Name: name, ID: id}
+		return kc.writeWordlists(txn, append(lists, info))
+	})
+
+	return info, err
+}
+
+// updateWordlist adds n words to the size of a wordlist in the registry
+func (kc *KDB) updateWordlist(name string, n int) error {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	return kc.c.Update(func(txn *badger.Txn) error {
+		lists, err := kc.readWordlists(txn)
+		if err != nil {
+			return err
+		}
+		for i := range lists {
+			if lists[i].Name == name {
+				lists[i].Words += n
+			}
+		}
+		return kc.writeWordlists(txn, lists)
+	})
+}
+
+// addWords sets the bit of wordlist id on every word, returns how many words weren't in it yet
+func (kc *KDB) addWords(id int, words []string) (int, error) {
+	if len(words) == 0 {
+		return 0, nil
+	}
+
+	added := 0
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	err := kc.c.Update(func(txn *badger.Txn) error {
+		added = 0
+		seen := make(map[string]bool, len(words))
+
+		for _, word := range words {
+			key := kc.keys.key(wordPrefix, util.SHA256Sum(word))
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			var bitmap []byte
+			item, err := txn.Get([]byte(key))
+			if err == nil {
+				bitmap, err = item.ValueCopy(nil)
+			}
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+			if bitmapHas(bitmap, id) {
+				continue
+			}
+
+			if err := txn.Set([]byte(key), bitmapSet(bitmap, id)); err != nil {
+				return err
+			}
+			added++
+		}
+		return nil
+	})
+
+	return added, err
+}
+
+// readWordlists reads the wordlist registry
+func (kc *KDB) readWordlists(txn *badger.Txn) ([]WordlistInfo, error) {
+	item, err := txn.Get([]byte(kc.keys.key(wordlistRegistryKey)))
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lists []WordlistInfo
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &lists)
+	})
+	return lists, err
+}
+
+// writeWordlists writes the wordlist registry
+func (kc *KDB) writeWordlists(txn *badger.Txn, lists []WordlistInfo) error {
+	data, err := json.Marshal(lists)
+	if err != nil {
+		return err
+	}
+	return txn.Set([]byte(kc.keys.key(wordlistRegistryKey)), data)
+}
+
+// bitmapHas reports whether bit id is set
+func bitmapHas(bitmap []byte, id int) bool {
+	return id/8 < len(bitmap) && bitmap[id/8]&(1<<(id%8)) != 0
+}
+
+// bitmapSet returns bitmap with bit id set
+func bitmapSet(bitmap []byte, id int) []byte {
+	for len(bitmap) <= id/8 {
+		bitmap = append(bitmap, 0)
+	}
+	bitmap[id/8] |= 1 << (id % 8)
+	return bitmap
+}
+
+// bitmapClear returns bitmap with bit id cleared and trailing zero bytes trimmed
+func bitmapClear(bitmap []byte, id int) []byte {
+	if id/8 < len(bitmap) {
+		bitmap[id/8] &^= 1 << (id % 8)
+	}
+	for len(bitmap) > 0 && bitmap[len(bitmap)-1] == 0 {
+		bitmap = bitmap[:len(bitmap)-1]
+	}
+	return bitmap
+}
@@ -1,7 +1,9 @@
 package util
 
 import (
+	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 )
 
@@ -22,3 +24,32 @@ func SHA256Sum(data string) []byte {
 	// Return the hex encoded sum
 	return hexSum
 }
+
+// Zeroize overwrites b with zeros, for key material that is no longer needed
+func Zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// HMACSHA256 returns the HMAC-SHA256 of data under key
+func HMACSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// ConstantTimeEqualHex reports whether two hex strings encode the same bytes.
+// Case is ignored and the comparison takes the same time wherever the first difference is.
+// Strings that aren't valid hex are never equal
+func ConstantTimeEqualHex(a, b string) bool {
+	rawA, err := hex.DecodeString(a)
+	if err != nil {
+		return false
+	}
+	rawB, err := hex.DecodeString(b)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(rawA, rawB) == 1
+}
@@ -0,0 +1,15 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestZeroize(t *testing.T) {
+	key := []byte("12345678901234567890123456789012")
+	Zeroize(key[4:])
+	if !bytes.Equal(key, append([]byte("1234"), make([]byte, 28)...)) {
+		t.Errorf("Zeroize left %x", key)
+	}
+	Zeroize(nil)
+}
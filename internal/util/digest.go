@@ -0,0 +1,38 @@
+package util
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha3"
+	"crypto/sha512"
+	"encoding/hex"
+)
+
+// Digest computes an unsalted sum of data, the building block of the fast hash modes
+type Digest func(data []byte) []byte
+
+// The digests of the fast unsalted hash modes
+var (
+	MD4     Digest = func(data []byte) []byte { sum := md4Sum(data); return sum[:] }
+	MD5     Digest = func(data []byte) []byte { sum := md5.Sum(data); return sum[:] }
+	SHA1    Digest = func(data []byte) []byte { sum := sha1.Sum(data); return sum[:] }
+	SHA224  Digest = func(data []byte) []byte { sum := sha256.Sum224(data); return sum[:] }
+	SHA256  Digest = func(data []byte) []byte { sum := sha256.Sum256(data); return sum[:] }
+	SHA384  Digest = func(data []byte) []byte { sum := sha512.Sum384(data); return sum[:] }
+	SHA512  Digest = func(data []byte) []byte { sum := sha512.Sum512(data); return sum[:] }
+	SHA3224 Digest = func(data []byte) []byte { sum := sha3.Sum224(data); return sum[:] }
+	SHA3256 Digest = func(data []byte) []byte { sum := sha3.Sum256(data); return sum[:] }
+	SHA3384 Digest = func(data []byte) []byte { sum := sha3.Sum384(data); return sum[:] }
+	SHA3512 Digest = func(data []byte) []byte { sum := sha3.Sum512(data); return sum[:] }
+)
+
+// Hex returns the lowercase hex of the digest of data, the form hashcat writes hashes in
+func (d Digest) Hex(data []byte) string {
+	return hex.EncodeToString(d(data))
+}
+
+// Of returns a digest of the hex of d, such as md5(sha1($pass)) for MD5.Of(SHA1)
+func (d Digest) Of(inner Digest) Digest {
+	return func(data []byte) []byte { return d([]byte(inner.Hex(data))) }
+}
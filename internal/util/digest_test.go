@@ -0,0 +1,107 @@
+package util
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDigestVectors(t *testing.T) {
+	vectors := []struct {
+		name   string
+		digest Digest
+		in     string
+		want   string
+	}{
+		// RFC 1320
+		{"MD4", MD4, "", "31d6cfe0d16ae931b73c59d7e0c089c0"},
+		{"MD4", MD4, "a", "bde52cb31de33e46245e05fbdbd6fb24"},
+		{"MD4", MD4, "abc", "a448017aaf21d8525fc10ae87aa6729d"},
+		{"MD4", MD4, "message digest", "d9130a8164549fe818874806e1c7014b"},
+		{"MD4", MD4, "abcdefghijklmnopqrstuvwxyz", "d79e1c308aa5bbcdeea8ed63df412da9"},
+		{"MD4", MD4, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789", "043f8582f241db351ce627e153e7f0e4"},
+		{"MD4", MD4, strings.Repeat("1234567890", 8), "e33b4ddc9c38f2199c3e7b164fcc0536"},
+		// RFC 1321
+		{"MD5", MD5, "", "d41d8cd98f00b204e9800998ecf8427e"},
+		{"MD5", MD5, "abc", "900150983cd24fb0d6963f7d28e17f72"},
+		{"MD5", MD5, "message digest", "f96b697d7cb7938d525a2f31aaf161d0"},
+		// FIPS 180 and FIPS 202
+		{"SHA1", SHA1, "abc", "a9993e364706816aba3e25717850c26c9cd0d89d"},
+		{"SHA224", SHA224, "abc", "23097d223405d8228642a477bda255b32aadbce4bda0b3f7e36c9da7"},
+		{"SHA256", SHA256, "abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"SHA384", SHA384, "abc", "cb00753f45a35e8bb5a03d699ac65007272c32ab0eded1631a8b605a43ff5bed8086072ba1e7cc2358baeca134c825a7"},
+		{"SHA512", SHA512, "abc", "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f"},
+		{"SHA3224", SHA3224, "abc", "e642824c3f8cf24ad09234ee7d3c766fc9a3a5168d0c94ad73b46fdf"},
+		{"SHA3256", SHA3256, "abc", "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532"},
+		{"SHA3384", SHA3384, "abc", "ec01498288516fc926459f58e2c6ad8df9b473cb0fc08c2596da7cf0e49be4b298d88cea927ac7f539f1edf228376d25"},
+		{"SHA3512", SHA3512, "abc", "b751850b1a57168a5693cd924b6b096e08f621827444f70d884f5d0240d2712e10e116e9192af3c91a7ec57647e3934057340b4cf408d5a56592f8274eec53f0"},
+		// hashcat modes 4400 and 4700
+		{"MD5.Of(SHA1)", MD5.Of(SHA1), "password", "1619d7adc23f4f633f11014d2f22b7d8"},
+		{"SHA1.Of(MD5)", SHA1.Of(MD5), "password", "55c3b5386c486feb662a0785f340938f518d547f"},
+	}
+	for _, v := range vectors {
+		if got := v.digest.Hex([]byte(v.in)); got != v.want {
+			t.Errorf("%s(%q) = %s, want %s", v.name, v.in, got, v.want)
+		}
+	}
+}
+
+func TestMD4Padding(t *testing.T) {
+	// Lengths around the 56 byte boundary where the length no longer fits the last block
+	for n := 50; n < 70; n++ {
+		data := bytes.Repeat([]byte{'a'}, n)
+		if sum := md4Sum(data); sum == md4Sum(data[:n-1]) {
+			t.Errorf("%d and %d bytes have the same sum", n, n-1)
+		}
+	}
+	if got := MD4.Hex(bytes.Repeat([]byte{'a'}, 1000000)); got != "bbce80cc6bb65e5c6745e30d4eeca9a4" {
+		t.Errorf("MD4 of a million a's is %s", got)
+	}
+}
+
+func TestNTHash(t *testing.T) {
+	vectors := map[string]string{
+		"":          "31d6cfe0d16ae931b73c59d7e0c089c0",
+		"password":  "8846f7eaee8fb117ad06bdd830b7586c",
+		"Password1": "64f12cddaa88057e06a81b54e73b949b",
+	}
+	for password, want := range vectors {
+		if got := NTHash(password); got != want {
+			t.Errorf("NTHash(%q) = %s, want %s", password, got, want)
+		}
+	}
+}
+
+func TestUTF16LE(t *testing.T) {
+	vectors := map[string][]byte{
+		"":   {},
+		"Ab": {'A', 0, 'b', 0},
+		"€":  {0xac, 0x20},
+		// Outside the BMP, a surrogate pair
+		"\U0001f600": {0x3d, 0xd8, 0x00, 0xde},
+	}
+	for s, want := range vectors {
+		if got := UTF16LE(s); !bytes.Equal(got, want) {
+			t.Errorf("UTF16LE(%q) = %x, want %x", s, got, want)
+		}
+	}
+}
+
+func TestLMHash(t *testing.T) {
+	vectors := map[string]string{
+		"":          "aad3b435b51404eeaad3b435b51404ee",
+		"PASSWORD":  "e52cac67419a9a224a3b108f3fa6cb6d",
+		"password":  "e52cac67419a9a224a3b108f3fa6cb6d",
+		"PASSWORD1": "e52cac67419a9a2238f10713b629b565",
+	}
+	for password, want := range vectors {
+		if got, ok := LMHash(password); !ok || got != want {
+			t.Errorf("LMHash(%q) = %s %v, want %s", password, got, ok, want)
+		}
+	}
+	for _, password := range []string{strings.Repeat("a", 15), "café"} {
+		if got, ok := LMHash(password); ok {
+			t.Errorf("LMHash(%q) = %s, it has no LM hash", password, got)
+		}
+	}
+}
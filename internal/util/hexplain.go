@@ -0,0 +1,50 @@
+package util
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	hexPlainPrefix = "$HEX["
+	hexPlainSuffix = "]"
+)
+
+// NeedsHexPlain reports whether a plaintext has to be written as $HEX[...] in a line based format.
+// Following hashcat, that is any plaintext with bytes outside printable ASCII and plaintexts that
+// already look like $HEX[...]. Colons stay as they are, readers split a line by its hash
+func NeedsHexPlain(plain string) bool {
+	if strings.HasPrefix(plain, hexPlainPrefix) {
+		return true
+	}
+
+	for i := 0; i < len(plain); i++ {
+		c := plain[i]
+		if c < 0x20 || c > 0x7e {
+			return true
+		}
+	}
+	return false
+}
+
+// EncodeHexPlain returns plain as is, or as $HEX[hex] if NeedsHexPlain reports true
+func EncodeHexPlain(plain string) string {
+	if !NeedsHexPlain(plain) {
+		return plain
+	}
+	return hexPlainPrefix + hex.EncodeToString([]byte(plain)) + hexPlainSuffix
+}
+
+// DecodeHexPlain decodes a $HEX[hex] plaintext, anything else is returned unchanged
+func DecodeHexPlain(s string) (string, error) {
+	if !strings.HasPrefix(s, hexPlainPrefix) || !strings.HasSuffix(s, hexPlainSuffix) {
+		return s, nil
+	}
+
+	raw, err := hex.DecodeString(s[len(hexPlainPrefix) : len(s)-len(hexPlainSuffix)])
+	if err != nil {
+		return "", fmt.Errorf("invalid $HEX[] plaintext: %w", err)
+	}
+	return string(raw), nil
+}
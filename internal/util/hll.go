@@ -0,0 +1,58 @@
+package util
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+)
+
+// hllPrecision is the number of hash bits that pick a register, 2^14 registers take 16 KiB and
+// give a standard error of about 0.8%
+const hllPrecision = 14
+
+// HLLStdError is the relative standard error of HyperLogLog counts
+var HLLStdError = 1.04 / math.Sqrt(1<<hllPrecision)
+
+// hllSeed seeds the hash of every sketch, sketches are only compared within one process
+var hllSeed = maphash.MakeSeed()
+
+// HyperLogLog approximates the number of distinct items added to it in fixed memory
+type HyperLogLog struct {
+	registers [1 << hllPrecision]uint8
+}
+
+// NewHyperLogLog returns an empty sketch
+func NewHyperLogLog() *HyperLogLog {
+	return &HyperLogLog{}
+}
+
+// Add adds an item
+func (h *HyperLogLog) Add(item []byte) {
+	x := maphash.Bytes(hllSeed, item)
+	i := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+// Count returns the estimated number of distinct items added, with linear counting for small
+// counts where the raw estimate is biased
+func (h *HyperLogLog) Count() uint64 {
+	m := float64(len(h.registers))
+
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
@@ -0,0 +1,50 @@
+//go:build darwin
+
+package keysource
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// securityNotFound is the exit code of security(1) when no item matches
+const securityNotFound = 44
+
+// systemKeyring keeps secrets in the login keychain through security(1)
+type systemKeyring struct{}
+
+func (systemKeyring) Get(service, account string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == securityNotFound {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("security: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (systemKeyring) Set(service, account, secret string) error {
+	for _, s := range []string{service, account, secret} {
+		if strings.ContainsAny(s, "\"\\\n") {
+			return errors.New("service and account can't contain quotes, backslashes or newlines")
+		}
+	}
+
+	// Commands read from stdin keep the secret out of the process list
+	var stderr bytes.Buffer
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s \"%s\" -a \"%s\" -w \"%s\"\n", service, account, secret))
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("security: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
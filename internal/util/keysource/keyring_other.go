@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package keysource
+
+// systemKeyring reports every keyring source as unsupported
+type systemKeyring struct{}
+
+func (systemKeyring) Get(service, account string) (string, error) {
+	return "", ErrKeyringUnsupported
+}
+
+func (systemKeyring) Set(service, account, secret string) error {
+	return ErrKeyringUnsupported
+}
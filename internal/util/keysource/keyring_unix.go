@@ -0,0 +1,47 @@
+//go:build unix && !darwin
+
+package keysource
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// systemKeyring keeps secrets with the Secret Service (GNOME Keyring, KWallet) through
+// secret-tool(1) from libsecret
+type systemKeyring struct{}
+
+func (systemKeyring) Get(service, account string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", fmt.Errorf("%w: secret-tool is not installed", ErrKeyringUnsupported)
+	}
+	secret := strings.TrimSpace(string(out))
+	if err != nil && (stderr.Len() > 0 || secret != "") {
+		return "", fmt.Errorf("secret-tool: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	// A lookup without a match fails silently
+	if secret == "" {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+func (systemKeyring) Set(service, account, secret string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "store", "--label=KrknDB key "+service+"/"+account, "service", service, "account", account)
+	cmd.Stdin = strings.NewReader(secret)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("%w: secret-tool is not installed", ErrKeyringUnsupported)
+	} else if err != nil {
+		return fmt.Errorf("secret-tool: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
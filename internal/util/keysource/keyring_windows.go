@@ -0,0 +1,88 @@
+//go:build windows
+
+package keysource
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	credTypeGeneric         = 1 // CRED_TYPE_GENERIC
+	credPersistLocalMachine = 2 // CRED_PERSIST_LOCAL_MACHINE, kept across logons of this user
+)
+
+var (
+	advapi32   = windows.NewLazySystemDLL("advapi32.dll")
+	credReadW  = advapi32.NewProc("CredReadW")
+	credWriteW = advapi32.NewProc("CredWriteW")
+	credFree   = advapi32.NewProc("CredFree")
+)
+
+// credential is the CREDENTIALW structure
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        windows.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// systemKeyring keeps secrets as generic credentials in the Windows Credential Manager, named
+// service/account
+type systemKeyring struct{}
+
+func (systemKeyring) Get(service, account string) (string, error) {
+	target, err := windows.UTF16PtrFromString(service + "/" + account)
+	if err != nil {
+		return "", err
+	}
+
+	var cred *credential
+	ok, _, err := credReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ok == 0 {
+		if errors.Is(err, windows.ERROR_NOT_FOUND) {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("CredRead: %w", err)
+	}
+	defer credFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+func (systemKeyring) Set(service, account, secret string) error {
+	target, err := windows.UTF16PtrFromString(service + "/" + account)
+	if err != nil {
+		return err
+	}
+	user, err := windows.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+
+	blob := []byte(secret)
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		CredentialBlob:     unsafe.SliceData(blob),
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+	ok, _, err := credWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0)
+	if ok == 0 {
+		return fmt.Errorf("CredWrite: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+// Package keysource loads and saves the 32-byte database encryption key.
+//
+// A source is a file path, env:NAME for an environment variable or keyring:service/account for an
+// entry in the OS keychain. Errors name the source but never include key material
+package keysource
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// KeySize is the size of an encryption key in bytes
+const KeySize = 32
+
+const (
+	filePrefix    = "file:"
+	envPrefix     = "env:"
+	keyringPrefix = "keyring:"
+)
+
+var (
+	// ErrInvalidKey is returned for a source that holds something other than a 32-byte key
+	ErrInvalidKey = errors.New("not a 32-byte key, expected raw bytes, hex or base64")
+
+	// ErrNotFound is returned when the file, variable or keyring entry doesn't exist
+	ErrNotFound = errors.New("key not found")
+
+	// ErrKeyExists is returned by SaveKey for a destination that already holds a key
+	ErrKeyExists = errors.New("key already exists")
+
+	// ErrKeyringUnsupported is returned for keyring sources on platforms without a supported keychain
+	ErrKeyringUnsupported = errors.New("no supported keyring on this platform")
+)
+
+// Keyring reads and writes secrets in a keychain. The OS keychain is used unless SetKeyring
+// installs another one
+type Keyring interface {
+	// Get returns the secret of account in service, ErrNotFound if there is none
+	Get(service, account string) (string, error)
+	// Set stores the secret of account in service, replacing an existing one
+	Set(service, account, secret string) error
+}
+
+var (
+	keyringMu sync.RWMutex
+	keyring   Keyring = systemKeyring{}
+)
+
+// SetKeyring makes keyring: sources use k, nil goes back to the OS keychain
+func SetKeyring(k Keyring) {
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+
+	if k == nil {
+		k = systemKeyring{}
+	}
+	keyring = k
+}
+
+// currentKeyring returns the keyring keyring: sources use
+func currentKeyring() Keyring {
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+	return keyring
+}
+
+// LoadKey reads a key from source: a file path (optionally file:path), env:NAME or
+// keyring:service/account. Files and variables may hold the key as 32 raw bytes, hex or base64,
+// the encoding is detected. Keyring entries hold it as hex
+func LoadKey(source string) ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+
+	switch {
+	case strings.HasPrefix(source, envPrefix):
+		name := strings.TrimPrefix(source, envPrefix)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("key source %s: variable not set: %w", source, ErrNotFound)
+		}
+		data = []byte(value)
+	case strings.HasPrefix(source, keyringPrefix):
+		service, account, err := parseKeyring(source)
+		if err != nil {
+			return nil, err
+		}
+		secret, err := currentKeyring().Get(service, account)
+		if err != nil {
+			return nil, fmt.Errorf("key source %s: %w", source, err)
+		}
+		data = []byte(secret)
+	default:
+		path := strings.TrimPrefix(source, filePrefix)
+		if data, err = os.ReadFile(path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				err = ErrNotFound
+			}
+			return nil, fmt.Errorf("key source %s: %w", source, err)
+		}
+	}
+
+	key, err := decodeKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("key source %s: %w", source, err)
+	}
+	return key, nil
+}
+
+// SaveKey writes key to dest, a file path (optionally file:path) or keyring:service/account. Files
+// are created with mode 0600 and hold the key as hex, an existing file is never overwritten since
+// the key it holds may be the only way into a database. Environment variables can't be saved to
+func SaveKey(dest string, key []byte) error {
+	if len(key) != KeySize {
+		return fmt.Errorf("key destination %s: %w", dest, ErrInvalidKey)
+	}
+	encoded := hex.EncodeToString(key)
+
+	switch {
+	case strings.HasPrefix(dest, envPrefix):
+		return fmt.Errorf("key destination %s: environment variables can't be saved to", dest)
+	case strings.HasPrefix(dest, keyringPrefix):
+		service, account, err := parseKeyring(dest)
+		if err != nil {
+			return err
+		}
+		if err := currentKeyring().Set(service, account, encoded); err != nil {
+			return fmt.Errorf("key destination %s: %w", dest, err)
+		}
+		return nil
+	default:
+		path := strings.TrimPrefix(dest, filePrefix)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			if errors.Is(err, os.ErrExist) {
+				err = ErrKeyExists
+			}
+			return fmt.Errorf("key destination %s: %w", dest, err)
+		}
+		if _, err := f.WriteString(encoded + "\n"); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("key destination %s: %w", dest, err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("key destination %s: %w", dest, err)
+		}
+		return nil
+	}
+}
+
+// GenerateKey returns a new random key
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+// parseKeyring splits a keyring:service/account source
+func parseKeyring(source string) (string, string, error) {
+	service, account, ok := strings.Cut(strings.TrimPrefix(source, keyringPrefix), "/")
+	if !ok || service == "" || account == "" {
+		return "", "", fmt.Errorf("key source %s: expected keyring:service/account", source)
+	}
+	return service, account, nil
+}
+
+// decodeKey detects how data holds a key: exactly 32 bytes are taken as the raw key, anything
+// else as hex or base64 text with surrounding whitespace ignored
+func decodeKey(data []byte) ([]byte, error) {
+	if len(data) == KeySize {
+		return bytes.Clone(data), nil
+	}
+
+	text := string(bytes.TrimSpace(data))
+	if len(text) == hex.EncodedLen(KeySize) {
+		if key, err := hex.DecodeString(text); err == nil {
+			return key, nil
+		}
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if key, err := enc.DecodeString(text); err == nil && len(key) == KeySize {
+			return key, nil
+		}
+	}
+	return nil, ErrInvalidKey
+}
@@ -0,0 +1,217 @@
+package keysource
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testKey is the key the tests load and save
+var testKey = []byte("0123456789abcdefghijklmnopqrstuv")
+
+// fakeKeyring keeps secrets in memory, failing every call with err when it is set
+type fakeKeyring struct {
+	mu      sync.Mutex
+	secrets map[string]string
+	err     error
+}
+
+func (k *fakeKeyring) Get(service, account string) (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.err != nil {
+		return "", k.err
+	}
+	secret, ok := k.secrets[service+"/"+account]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+func (k *fakeKeyring) Set(service, account, secret string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.err != nil {
+		return k.err
+	}
+	k.secrets[service+"/"+account] = secret
+	return nil
+}
+
+// useFakeKeyring installs a fakeKeyring holding secrets until the test ends
+func useFakeKeyring(t *testing.T, secrets map[string]string) *fakeKeyring {
+	t.Helper()
+	k := &fakeKeyring{secrets: secrets}
+	if k.secrets == nil {
+		k.secrets = make(map[string]string)
+	}
+	SetKeyring(k)
+	t.Cleanup(func() { SetKeyring(nil) })
+	return k
+}
+
+// keyForms returns key as raw bytes, hex and base64, the forms a source may hold it in
+func keyForms(key []byte) map[string][]byte {
+	return map[string][]byte{
+		"raw":        key,
+		"hex":        []byte(hex.EncodeToString(key)),
+		"HEX":        []byte(strings.ToUpper(hex.EncodeToString(key))),
+		"base64":     []byte(base64.StdEncoding.EncodeToString(key)),
+		"raw base64": []byte(base64.RawURLEncoding.EncodeToString(key)),
+		"hex line":   []byte(" " + hex.EncodeToString(key) + "\r\n"),
+	}
+}
+
+// checkError fails t unless err wraps want, names source and holds none of secrets
+func checkError(t *testing.T, err, want error, source string, secrets ...[]byte) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Errorf("%s returned %v, want %v", source, err, want)
+		return
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, source) {
+		t.Errorf("the error %q doesn't name %s", msg, source)
+	}
+	for _, secret := range secrets {
+		for name, form := range keyForms(secret) {
+			if len(bytes.TrimSpace(form)) > 0 && strings.Contains(msg, string(bytes.TrimSpace(form))) {
+				t.Errorf("the error %q holds the %s key", msg, name)
+			}
+		}
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range keyForms(testKey) {
+		path := filepath.Join(dir, strings.ReplaceAll(name, " ", "_"))
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		for _, source := range []string{path, filePrefix + path} {
+			if key, err := LoadKey(source); err != nil || !bytes.Equal(key, testKey) {
+				t.Errorf("%s holding the %s key loaded %x: %v", source, name, key, err)
+			}
+		}
+	}
+
+	missing := filepath.Join(dir, "missing")
+	_, err := LoadKey(missing)
+	checkError(t, err, ErrNotFound, missing)
+
+	short := filepath.Join(dir, "short")
+	if err := os.WriteFile(short, []byte(hex.EncodeToString(testKey[1:])), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadKey(short)
+	checkError(t, err, ErrInvalidKey, short, testKey[1:])
+}
+
+func TestSaveKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	if err := SaveKey(filePrefix+path, testKey); err != nil {
+		t.Fatalf("SaveKey: %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != hex.EncodeToString(testKey)+"\n" {
+		t.Errorf("the key file holds %q: %v", data, err)
+	}
+	if info, err := os.Stat(path); err != nil || (runtime.GOOS != "windows" && info.Mode().Perm() != 0600) {
+		t.Errorf("the key file has the mode %v: %v", info.Mode(), err)
+	}
+	if key, err := LoadKey(path); err != nil || !bytes.Equal(key, testKey) {
+		t.Errorf("the saved key loaded as %x: %v", key, err)
+	}
+
+	// The key already there may be the only way into a database
+	other := bytes.Repeat([]byte{7}, KeySize)
+	checkError(t, SaveKey(path, other), ErrKeyExists, path, testKey, other)
+	if key, _ := LoadKey(path); !bytes.Equal(key, testKey) {
+		t.Errorf("the existing key was overwritten")
+	}
+
+	checkError(t, SaveKey(path+".short", testKey[1:]), ErrInvalidKey, path+".short", testKey[1:])
+	if _, err := os.Stat(path + ".short"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a short key was written: %v", err)
+	}
+}
+
+func TestLoadKeyEnv(t *testing.T) {
+	for name, data := range keyForms(testKey) {
+		t.Setenv("KRKN_TEST_KEY", string(data))
+		if key, err := LoadKey("env:KRKN_TEST_KEY"); err != nil || !bytes.Equal(key, testKey) {
+			t.Errorf("a variable holding the %s key loaded %x: %v", name, key, err)
+		}
+	}
+
+	t.Setenv("KRKN_TEST_SHORT", base64.StdEncoding.EncodeToString(testKey[2:]))
+	_, err := LoadKey("env:KRKN_TEST_SHORT")
+	checkError(t, err, ErrInvalidKey, "env:KRKN_TEST_SHORT", testKey[2:])
+
+	_, err = LoadKey("env:KRKN_TEST_UNSET")
+	checkError(t, err, ErrNotFound, "env:KRKN_TEST_UNSET")
+
+	err = SaveKey("env:KRKN_TEST_KEY", testKey)
+	if err == nil || !strings.Contains(err.Error(), "env:KRKN_TEST_KEY") || strings.Contains(err.Error(), hex.EncodeToString(testKey)) {
+		t.Errorf("saving to a variable returned %v", err)
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	k := useFakeKeyring(t, map[string]string{"krkndb/short": hex.EncodeToString(testKey[:31])})
+
+	const source = "keyring:krkndb/prod"
+	_, err := LoadKey(source)
+	checkError(t, err, ErrNotFound, source)
+
+	if err := SaveKey(source, testKey); err != nil {
+		t.Fatalf("SaveKey: %v", err)
+	}
+	if k.secrets["krkndb/prod"] != hex.EncodeToString(testKey) {
+		t.Errorf("the keyring holds %q", k.secrets["krkndb/prod"])
+	}
+	if key, err := LoadKey(source); err != nil || !bytes.Equal(key, testKey) {
+		t.Errorf("the saved key loaded as %x: %v", key, err)
+	}
+
+	_, err = LoadKey("keyring:krkndb/short")
+	checkError(t, err, ErrInvalidKey, "keyring:krkndb/short", testKey[:31])
+
+	for _, malformed := range []string{"keyring:krkndb", "keyring:/prod", "keyring:krkndb/"} {
+		if _, err := LoadKey(malformed); err == nil || !strings.Contains(err.Error(), malformed) {
+			t.Errorf("loading %s returned %v", malformed, err)
+		}
+		if err := SaveKey(malformed, testKey); err == nil || !strings.Contains(err.Error(), malformed) {
+			t.Errorf("saving to %s returned %v", malformed, err)
+		}
+	}
+
+	// Keychain failures come back naming the source
+	k.err = ErrKeyringUnsupported
+	_, err = LoadKey(source)
+	checkError(t, err, ErrKeyringUnsupported, source, testKey)
+	checkError(t, SaveKey(source, testKey), ErrKeyringUnsupported, source, testKey)
+
+	SetKeyring(nil)
+	if _, ok := currentKeyring().(systemKeyring); !ok {
+		t.Errorf("SetKeyring(nil) left %T installed", currentKeyring())
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	a, err := GenerateKey()
+	if err != nil || len(a) != KeySize {
+		t.Fatalf("GenerateKey returned %d bytes: %v", len(a), err)
+	}
+	if b, _ := GenerateKey(); bytes.Equal(a, b) {
+		t.Errorf("two keys came out the same")
+	}
+}
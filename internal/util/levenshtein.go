@@ -0,0 +1,73 @@
+package util
+
+import "unicode/utf8"
+
+// EditDistance returns the Levenshtein distance between a and b in characters if it is at most
+// maxDistance. Only the band of cells within maxDistance of the diagonal is computed and the
+// search stops as soon as a whole band row exceeds it, so unrelated strings are rejected after a
+// few characters. Strings whose lengths differ by more than maxDistance are rejected up front
+func EditDistance(a, b string, maxDistance int) (int, bool) {
+	if maxDistance < 0 {
+		return 0, false
+	}
+	if a == b {
+		return 0, true
+	}
+
+	ra, rb := []rune(a), []rune(b)
+	if !utf8.ValidString(a) || !utf8.ValidString(b) {
+		ra, rb = bytesAsRunes(a), bytesAsRunes(b)
+	}
+	if len(ra) > len(rb) {
+		ra, rb = rb, ra
+	}
+	if len(rb)-len(ra) > maxDistance {
+		return 0, false
+	}
+
+	// Cells outside the band stay above maxDistance
+	inf := maxDistance + 1
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = min(j, inf)
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		lo, hi := max(1, i-maxDistance), min(len(rb), i+maxDistance)
+		cur[lo-1] = inf
+		if lo == 1 {
+			cur[0] = min(i, inf)
+		}
+
+		best := cur[lo-1]
+		for j := lo; j <= hi; j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d := min(prev[j-1]+cost, prev[j]+1, cur[j-1]+1, inf)
+			cur[j] = d
+			best = min(best, d)
+		}
+		if hi < len(rb) {
+			cur[hi+1] = inf
+		}
+		if best > maxDistance {
+			return 0, false
+		}
+		prev, cur = cur, prev
+	}
+
+	d := prev[len(rb)]
+	return d, d <= maxDistance
+}
+
+// bytesAsRunes maps every byte of s to a rune, for values that aren't valid UTF-8
+func bytesAsRunes(s string) []rune {
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
+	}
+	return runes
+}
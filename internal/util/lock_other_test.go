@@ -0,0 +1,26 @@
+//go:build !unix
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockFile(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "hashcat.potfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	unlock, err := LockFile(f)
+	if err != nil || unlock == nil {
+		t.Fatalf("LockFile: %v", err)
+	}
+	unlock()
+	if _, err := f.WriteString("hash:plain\n"); err != nil {
+		t.Errorf("writing after the release: %v", err)
+	}
+}
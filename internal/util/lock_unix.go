@@ -0,0 +1,22 @@
+//go:build unix
+
+package util
+
+import (
+	"os"
+	"syscall"
+)
+
+// LockFile takes an exclusive POSIX record lock on f, waiting for other holders, and returns the
+// func releasing it. hashcat locks its potfile the same way before appending
+func LockFile(f *os.File) (func(), error) {
+	lock := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: 0}
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLKW, &lock); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		unlock := syscall.Flock_t{Type: syscall.F_UNLCK, Whence: 0}
+		_ = syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &unlock)
+	}, nil
+}
@@ -0,0 +1,75 @@
+//go:build unix
+
+package util
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// lockHelperEnv names the file TestLockHelper tries to lock in a child process
+const lockHelperEnv = "KRKN_LOCK_HELPER"
+
+// TestLockHelper runs only in the child of TestLockFile, POSIX locks don't exclude the process
+// holding them. It exits with 3 if the file is locked by another process
+func TestLockHelper(t *testing.T) {
+	path := os.Getenv(lockHelperEnv)
+	if path == "" {
+		t.Skip("only run by TestLockFile")
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lock := syscall.Flock_t{Type: syscall.F_WRLCK}
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lock); errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EACCES) {
+		os.Exit(3)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// lockedElsewhere reports whether another process finds path locked
+func lockedElsewhere(t *testing.T, path string) bool {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockHelper$")
+	cmd.Env = append(os.Environ(), lockHelperEnv+"="+path)
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 3 {
+		return true
+	}
+	if err != nil {
+		t.Fatalf("the lock helper failed: %v", err)
+	}
+	return false
+}
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashcat.potfile")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if lockedElsewhere(t, path) {
+		t.Fatalf("the file is locked before LockFile")
+	}
+	unlock, err := LockFile(f)
+	if err != nil {
+		t.Fatalf("LockFile: %v", err)
+	}
+	if !lockedElsewhere(t, path) {
+		t.Errorf("another process could lock the locked file")
+	}
+	unlock()
+	if lockedElsewhere(t, path) {
+		t.Errorf("the file is still locked after the release")
+	}
+}
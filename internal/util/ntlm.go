@@ -0,0 +1,120 @@
+package util
+
+import (
+	"crypto/des"
+	"encoding/binary"
+	"encoding/hex"
+	"math/bits"
+	"unicode/utf16"
+)
+
+// NTHash returns the NT hash of password, the hex MD4 sum of its UTF-16LE encoding
+func NTHash(password string) string {
+	return hex.EncodeToString(MD4(UTF16LE(password)))
+}
+
+// UTF16LE returns s encoded as UTF-16LE, the encoding Windows hashes passwords in
+func UTF16LE(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	data := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(data[2*i:], u)
+	}
+	return data
+}
+
+// LMHash returns the LM hash of password, false for passwords without one: longer than 14
+// characters or not ASCII, which LM encodes in the OEM code page of the machine
+func LMHash(password string) (string, bool) {
+	if len(password) > 14 {
+		return "", false
+	}
+	var key [14]byte
+	for i := range len(password) {
+		c := password[i]
+		if c >= 0x80 {
+			return "", false
+		}
+		if 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		key[i] = c
+	}
+
+	sum := make([]byte, 0, 16)
+	for _, half := range [][]byte{key[:7], key[7:]} {
+		block, err := des.NewCipher(lmDESKey(half))
+		if err != nil {
+			return "", false
+		}
+		out := make([]byte, 8)
+		block.Encrypt(out, []byte("KGS!@#$%"))
+		sum = append(sum, out...)
+	}
+	return hex.EncodeToString(sum), true
+}
+
+// lmDESKey spreads the 56 bits of a 7 byte LM key half over the 8 bytes of a DES key, leaving the
+// parity bits DES ignores at zero
+func lmDESKey(half []byte) []byte {
+	var bitsIn uint64
+	for _, b := range half {
+		bitsIn = bitsIn<<8 | uint64(b)
+	}
+	key := make([]byte, 8)
+	for i := range key {
+		key[i] = byte(bitsIn>>(49-7*i)) << 1
+	}
+	return key
+}
+
+// md4Shifts are the rotations of the three MD4 rounds, four per round
+var md4Shifts = [3][4]int{{3, 7, 11, 19}, {3, 5, 9, 13}, {3, 9, 11, 15}}
+
+// md4Order3 is the order round three reads the message words in
+var md4Order3 = [16]int{0, 8, 4, 12, 2, 10, 6, 14, 1, 9, 5, 13, 3, 11, 7, 15}
+
+// md4Sum returns the MD4 sum of data (RFC 1320). MD4 is broken, it is only here because NT hashes
+// are built on it and the standard library doesn't have it
+func md4Sum(data []byte) [16]byte {
+	msg := append([]byte(nil), data...)
+	msg = append(msg, 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(len(data))*8)
+
+	s := [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}
+	var x [16]uint32
+	for block := msg; len(block) > 0; block = block[64:] {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(block[4*i:])
+		}
+		a, b, c, d := s[0], s[1], s[2], s[3]
+
+		for i := range 16 {
+			f := (b & c) | (^b & d)
+			a, b, c, d = d, bits.RotateLeft32(a+f+x[i], md4Shifts[0][i%4]), b, c
+		}
+		for i := range 16 {
+			f := (b & c) | (b & d) | (c & d)
+			k := (i%4)*4 + i/4
+			a, b, c, d = d, bits.RotateLeft32(a+f+x[k]+0x5a827999, md4Shifts[1][i%4]), b, c
+		}
+		for i := range 16 {
+			f := b ^ c ^ d
+			a, b, c, d = d, bits.RotateLeft32(a+f+x[md4Order3[i]]+0x6ed9eba1, md4Shifts[2][i%4]), b, c
+		}
+
+		s[0] += a
+		s[1] += b
+		s[2] += c
+		s[3] += d
+	}
+
+	var sum [16]byte
+	for i, v := range s {
+		binary.LittleEndian.PutUint32(sum[4*i:], v)
+	}
+	return sum
+}
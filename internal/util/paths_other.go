@@ -0,0 +1,13 @@
+//go:build !windows
+
+package util
+
+// LongPath returns path unchanged, only Windows limits path lengths this way
+func LongPath(path string) string {
+	return path
+}
+
+// RestrictDir does nothing, the mode dir was created with already restricts it
+func RestrictDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLongPath(t *testing.T) {
+	long := filepath.Join(t.TempDir(), strings.Repeat("x", 300))
+	for _, path := range []string{"", "relative", "/short", long} {
+		if got := LongPath(path); got != path {
+			t.Errorf("LongPath(%q) = %q", path, got)
+		}
+	}
+}
+
+func TestRestrictDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := RestrictDir(dir); err != nil {
+		t.Fatalf("RestrictDir: %v", err)
+	}
+	// The mode it was created with is the restriction
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("the directory has the mode %v: %v", info.Mode(), err)
+	}
+}
@@ -0,0 +1,20 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPathExists(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]bool{dir: true, file: true, filepath.Join(dir, "missing"): false} {
+		if got := PathExists(path); got != want {
+			t.Errorf("PathExists(%s) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+//go:build windows
+
+package util
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	maxPath      = 260 // MAX_PATH, the longest path the Win32 API takes without the \\?\ prefix
+	fileNameRoom = 32  // room left for the names badger joins onto its directory
+)
+
+// LongPath returns an absolute path with the \\?\ extended-length prefix when files in it would
+// pass MAX_PATH. badger opens some files through the Win32 API directly, which unlike the os
+// package doesn't add the prefix itself. Relative and already prefixed paths are returned as is
+func LongPath(path string) string {
+	if len(path)+fileNameRoom < maxPath || strings.HasPrefix(path, `\\?\`) || !filepath.IsAbs(path) {
+		return path
+	}
+
+	path = filepath.Clean(path)
+	if strings.HasPrefix(path, `\\`) {
+		return `\\?\UNC\` + path[2:]
+	}
+	return `\\?\` + path
+}
+
+// RestrictDir replaces the ACL of dir with one granting full control to the current user, SYSTEM
+// and the administrators only, inherited by everything created inside it. Mode bits such as 0700
+// mean nothing on Windows, this is the equivalent
+func RestrictDir(dir string) error {
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return fmt.Errorf("failed to read the current user: %w", err)
+	}
+
+	// P protects the DACL from inherited entries, OICI passes the entries on to files and folders
+	sddl := fmt.Sprintf("D:P(A;OICI;FA;;;%s)(A;OICI;FA;;;SY)(A;OICI;FA;;;BA)", user.User.Sid)
+	sd, err := windows.SecurityDescriptorFromString(sddl)
+	if err != nil {
+		return fmt.Errorf("failed to build security descriptor: %w", err)
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return fmt.Errorf("failed to read DACL: %w", err)
+	}
+
+	return windows.SetNamedSecurityInfo(dir, windows.SE_FILE_OBJECT,
+		windows.DACL_SECURITY_INFORMATION|windows.PROTECTED_DACL_SECURITY_INFORMATION, nil, nil, dacl, nil)
+}
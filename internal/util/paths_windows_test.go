@@ -0,0 +1,84 @@
+//go:build windows
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestLongPath(t *testing.T) {
+	long := `C:\` + strings.Repeat(`x\`, 120) + "db"
+	tests := []struct {
+		path, want string
+	}{
+		{`C:\short\db`, `C:\short\db`},
+		{`relative\` + strings.Repeat("x", 300), `relative\` + strings.Repeat("x", 300)},
+		{long, `\\?\` + long},
+		{`C:\` + strings.Repeat(`x\`, 120) + `.\db`, `\\?\` + long},
+		{`\\server\share\` + strings.Repeat(`x\`, 120) + "db", `\\?\UNC\server\share\` + strings.Repeat(`x\`, 120) + "db"},
+		{`\\?\` + long, `\\?\` + long},
+	}
+	for _, tt := range tests {
+		if got := LongPath(tt.path); got != tt.want {
+			t.Errorf("LongPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// sddl returns the DACL of path in SDDL form
+func sddl(t *testing.T, path string) (string, windows.SECURITY_DESCRIPTOR_CONTROL) {
+	t.Helper()
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		t.Fatalf("failed to read the ACL of %s: %v", path, err)
+	}
+	control, _, err := sd.Control()
+	if err != nil {
+		t.Fatalf("failed to read the control flags of %s: %v", path, err)
+	}
+	return sd.String(), control
+}
+
+func TestRestrictDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := RestrictDir(dir); err != nil {
+		t.Fatalf("RestrictDir: %v", err)
+	}
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		t.Fatalf("failed to read the current user: %v", err)
+	}
+	sid := user.User.Sid.String()
+
+	// Only the user, SYSTEM and the administrators, nothing inherited from the parent
+	acl, control := sddl(t, dir)
+	if control&windows.SE_DACL_PROTECTED == 0 {
+		t.Errorf("the ACL of the directory isn't protected: %s", acl)
+	}
+	for _, trustee := range []string{sid, "SY", "BA"} {
+		if !strings.Contains(acl, "(A;OICI;FA;;;"+trustee+")") {
+			t.Errorf("the ACL %s doesn't grant %s full control", acl, trustee)
+		}
+	}
+	if n := strings.Count(acl, "("); n != 3 {
+		t.Errorf("the ACL %s has %d entries, want 3", acl, n)
+	}
+
+	// Files created inside inherit the entries and nothing else
+	file := filepath.Join(dir, "MANIFEST")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	acl, _ = sddl(t, file)
+	if !strings.Contains(acl, "(A;ID;FA;;;"+sid+")") || strings.Count(acl, "(") != 3 {
+		t.Errorf("the file inside has the ACL %s", acl)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix && !windows
+
+package util
+
+// ProcessAlive can't tell where processes can't be queried and reports every pid as running
+func ProcessAlive(pid int) bool {
+	return pid > 0
+}
@@ -0,0 +1,13 @@
+//go:build !unix && !windows
+
+package util
+
+import "testing"
+
+func TestProcessAlive(t *testing.T) {
+	for pid, want := range map[int]bool{1: true, 1 << 30: true, 0: false, -1: false} {
+		if got := ProcessAlive(pid); got != want {
+			t.Errorf("ProcessAlive(%d) = %v, want %v", pid, got, want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+//go:build unix || windows
+
+package util
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestProcessAlive(t *testing.T) {
+	if !ProcessAlive(os.Getpid()) {
+		t.Errorf("this process isn't alive")
+	}
+	for _, pid := range []int{0, -1} {
+		if ProcessAlive(pid) {
+			t.Errorf("the pid %d is alive", pid)
+		}
+	}
+
+	// A process that exited and was waited for is gone, unless its pid was taken again meanwhile
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to run a child: %v", err)
+	}
+	if ProcessAlive(cmd.Process.Pid) {
+		t.Errorf("the exited child %d is alive", cmd.Process.Pid)
+	}
+}
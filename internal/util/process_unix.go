@@ -0,0 +1,19 @@
+//go:build unix
+
+package util
+
+import (
+	"errors"
+	"syscall"
+)
+
+// ProcessAlive reports whether a process with pid is running. A pid reused by another process
+// counts as running
+func ProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	// Signal 0 only checks that the process exists, EPERM means it does but isn't ours
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,33 @@
+//go:build windows
+
+package util
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// stillActive is the exit code GetExitCodeProcess reports for a process that hasn't exited
+const stillActive = 259
+
+// ProcessAlive reports whether a process with pid is running. A pid reused by another process
+// counts as running
+func ProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// Processes of other users can't be opened, but they exist
+		return errors.Is(err, windows.ERROR_ACCESS_DENIED)
+	}
+	defer windows.CloseHandle(h)
+
+	var code uint32
+	if err := windows.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
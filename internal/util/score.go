@@ -0,0 +1,166 @@
+package util
+
+import (
+	_ "embed"
+	"encoding/json"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CharClass is a set of character classes
+type CharClass uint8
+
+const (
+	ClassLower  CharClass = 1 << iota // a-z and other lowercase letters
+	ClassUpper                        // A-Z and other uppercase letters
+	ClassDigit                        // 0-9
+	ClassSymbol                       // printable ASCII punctuation and space
+	ClassOther                        // anything else, such as letters without case or emoji
+)
+
+// charClassNames names the classes in bit order
+var charClassNames = []string{"lower", "upper", "digit", "symbol", "other"}
+
+// charClassPool is roughly how many characters each class draws from, for the entropy estimate
+var charClassPool = []float64{26, 26, 10, 33, 100}
+
+// Count returns the number of classes in the set
+func (c CharClass) Count() int {
+	n := 0
+	for i := range charClassNames {
+		if c&(1<<i) != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Names returns the names of the classes in the set
+func (c CharClass) Names() []string {
+	names := []string{}
+	for i, name := range charClassNames {
+		if c&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// String returns the class names joined by "+", e.g. "lower+digit"
+func (c CharClass) String() string {
+	return strings.Join(c.Names(), "+")
+}
+
+// MarshalJSON encodes the set as a list of class names
+func (c CharClass) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.Names())
+}
+
+// classOf returns the class of r
+func classOf(r rune) CharClass {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return ClassLower
+	case r >= 'A' && r <= 'Z':
+		return ClassUpper
+	case r >= '0' && r <= '9':
+		return ClassDigit
+	case r >= 0x20 && r <= 0x7e:
+		return ClassSymbol
+	case unicode.IsLower(r):
+		return ClassLower
+	case unicode.IsUpper(r):
+		return ClassUpper
+	case unicode.IsDigit(r):
+		return ClassDigit
+	}
+	return ClassOther
+}
+
+//go:embed words.txt
+var embeddedWords string
+
+// Dictionary is a set of lowercase words ScoreValue checks values against
+type Dictionary map[string]struct{}
+
+// NewDictionary builds a dictionary from words, case is ignored
+func NewDictionary(words []string) Dictionary {
+	dict := make(Dictionary, len(words))
+	for _, word := range words {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			dict[word] = struct{}{}
+		}
+	}
+	return dict
+}
+
+// DefaultDictionary is the small embedded list of base words of common passwords
+var DefaultDictionary = func() Dictionary {
+	var words []string
+	for _, line := range strings.Split(embeddedWords, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	return NewDictionary(words)
+}()
+
+// Contains reports whether the base word of value is in the dictionary. The base word is the
+// value lowercased and stripped of the digits and symbols around it, so "Summer2024!" is "summer"
+func (d Dictionary) Contains(value string) bool {
+	base := strings.TrimFunc(strings.ToLower(value), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if base == "" {
+		return false
+	}
+	_, ok := d[base]
+	return ok
+}
+
+// ValueScore describes the strength of a cracked value
+type ValueScore struct {
+	Length         int       `json:"length"`          // Characters, not bytes
+	Classes        CharClass `json:"classes"`         // Character classes present
+	Entropy        float64   `json:"entropy"`         // Estimated bits, see ScoreValue
+	DictionaryWord bool      `json:"dictionary_word"` // The base word is in the dictionary
+}
+
+// ScoreValue scores a value against the optional dictionary, DefaultDictionary if none is given.
+// The entropy estimate is the length times log2 of the pool of the classes present, as if every
+// character were picked at random from them. A value built on a dictionary word counts the word as
+// a single pick from the dictionary instead, which is what a wordlist attack on it costs
+func ScoreValue(value string, dict ...Dictionary) ValueScore {
+	d := DefaultDictionary
+	if len(dict) > 0 && dict[0] != nil {
+		d = dict[0]
+	}
+
+	score := ValueScore{Length: utf8.RuneCountInString(value)}
+	for _, r := range value {
+		score.Classes |= classOf(r)
+	}
+	score.DictionaryWord = d.Contains(value)
+
+	pool := 0.0
+	for i := range charClassPool {
+		if score.Classes&(1<<i) != 0 {
+			pool += charClassPool[i]
+		}
+	}
+	if pool == 0 {
+		return score
+	}
+
+	picks := float64(score.Length)
+	extra := 0.0
+	if score.DictionaryWord && len(d) > 1 {
+		base := strings.TrimFunc(value, func(r rune) bool { return !unicode.IsLetter(r) })
+		picks -= float64(utf8.RuneCountInString(base))
+		extra = math.Log2(float64(len(d)))
+	}
+	score.Entropy = math.Round((picks*math.Log2(pool)+extra)*100) / 100
+	return score
+}
@@ -0,0 +1,182 @@
+// Package kdbtest generates deterministic fixtures for tests of code built on KrknDB: hashes with
+// realistic plaintexts, mixed corpora loaded into a database, and throwaway databases closed when
+// the test ends.
+//
+//	db := kdbtest.NewTestDB(t)
+//	stored := kdbtest.PopulateDB(t, db, kdbtest.PopulationSpec{Types: map[uint64]int{0: 500, 1000: 500}, Cracked: 0.6, Seed: 1})
+//
+// The same seed always yields the same hashes and plaintexts, across runs and machines
+package kdbtest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/kdb"
+)
+
+// Epoch is the creation time of the first generated hash, each one after it was created a second
+// later
+var Epoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// testKey is the encryption key of the databases NewTestDB opens
+var testKey = []byte("kdbtest-fixture-key-0123456789ab")
+
+// words are the bases of the generated plaintexts
+var words = []string{
+	"password", "dragon", "monkey", "letmein", "football", "shadow", "master", "sunshine",
+	"princess", "welcome", "summer", "winter", "spring", "autumn", "admin", "login", "secret",
+	"baseball", "superman", "batman", "trustno1", "hello", "freedom", "whatever", "michael",
+	"jessica", "charlie", "ashley", "london", "berlin", "kraken", "company", "changeme", "iloveyou",
+}
+
+// walks are keyboard walks, another favourite
+var walks = []string{"qwerty", "asdfgh", "zxcvbn", "1qaz2wsx", "qwertyuiop", "1q2w3e4r"}
+
+// leet are the substitutions of leetspeak plaintexts
+var leet = strings.NewReplacer("a", "@", "o", "0", "e", "3", "s", "$", "i", "1")
+
+// users are the account names of PopulationSpec.Meta
+var users = []string{"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi", "ivan", "judy", "mallory", "oscar"}
+
+// domains are the account domains of PopulationSpec.Meta
+var domains = []string{"CORP", "LAB", "EXAMPLE"}
+
+// NewTestDB opens a database in a temporary directory of t, closed and removed when the test ends
+func NewTestDB(t testing.TB) *kdb.KDB {
+	t.Helper()
+
+	opts := kdb.LowMemoryOptions()
+	opts.Logger = func(string, kdb.Severity) {}
+	db, err := kdb.New(t.TempDir(), testKey, opts)
+	if err != nil {
+		t.Fatalf("kdbtest: failed to open a test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("kdbtest: failed to close the test database: %v", err)
+		}
+	})
+	return db
+}
+
+// GenerateHashes returns n distinct hashes of hashType with their plaintexts, the same n for the
+// same seed. Plaintexts look like real ones: words with digits, years and capitals, leetspeak,
+// keyboard walks and PINs. Modes KrknDB can compute, see CanVerify, get the real hash of the
+// plaintext, others random hex of 32 characters. Creation times start at Epoch
+func GenerateHashes(n int, hashType uint64, seed int64) []*kdb.Hash {
+	rng := rand.New(rand.NewSource(seed*1000003 + int64(hashType)))
+
+	hashes := make([]*kdb.Hash, 0, n)
+	seen := make(map[string]bool, n)
+	for i := range n {
+		plain := plaintext(rng)
+		for seen[plain] {
+			plain += strconv.Itoa(rng.Intn(10))
+		}
+		seen[plain] = true
+
+		hash, err := kdb.ComputeHash(plain, hashType)
+		if err != nil {
+			sum := sha256.Sum256(fmt.Appendf(nil, "%d:%d:%s", seed, hashType, plain))
+			hash = hex.EncodeToString(sum[:16])
+		}
+
+		sh := kdb.NewHash(hash, plain, hashType)
+		sh.CreatedAt = Epoch.Add(time.Duration(i) * time.Second)
+		hashes = append(hashes, sh)
+	}
+	return hashes
+}
+
+// plaintext returns a plaintext in one of the shapes people pick
+func plaintext(rng *rand.Rand) string {
+	word := words[rng.Intn(len(words))]
+	switch rng.Intn(8) {
+	case 0:
+		return word
+	case 1:
+		return capitalize(word) + strconv.Itoa(rng.Intn(10000))
+	case 2:
+		return word + strconv.Itoa(1970+rng.Intn(56))
+	case 3:
+		return capitalize(word) + strconv.Itoa(1970+rng.Intn(56)) + "!"
+	case 4:
+		return fmt.Sprintf("%0*d", 6+rng.Intn(3), rng.Intn(100000000))
+	case 5:
+		return walks[rng.Intn(len(walks))] + strconv.Itoa(rng.Intn(10))
+	case 6:
+		return leet.Replace(word)
+	default:
+		return word + words[rng.Intn(len(words))]
+	}
+}
+
+func capitalize(s string) string {
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// PopulationSpec describes the corpus PopulateDB loads
+type PopulationSpec struct {
+	Types   map[uint64]int // Hashes per hash type, 100 md5 and 100 NTLM if empty
+	Cracked float64        // Share of the hashes stored with their plaintext, from 0 to 1
+	Meta    bool           // Give the hashes user and domain meta entries and a session
+	Seed    int64
+}
+
+// PopulateDB stores the corpus spec describes in db, failing t if it can't, and returns the
+// hashes stored ordered by type, see Populate
+func PopulateDB(t testing.TB, db *kdb.KDB, spec PopulationSpec) []*kdb.Hash {
+	t.Helper()
+
+	stored, err := Populate(db, spec)
+	if err != nil {
+		t.Fatalf("kdbtest: %v", err)
+	}
+	return stored
+}
+
+// Populate stores the corpus spec describes in db and returns the hashes stored ordered by type.
+// Which hashes are cracked and their meta entries follow from the seed like the hashes, uncracked
+// ones are returned without their plaintext
+func Populate(db *kdb.KDB, spec PopulationSpec) ([]*kdb.Hash, error) {
+	types := spec.Types
+	if len(types) == 0 {
+		types = map[uint64]int{0: 100, kdb.NTLM: 100}
+	}
+	hashTypes := make([]uint64, 0, len(types))
+	for hashType := range types {
+		hashTypes = append(hashTypes, hashType)
+	}
+	slices.Sort(hashTypes)
+
+	rng := rand.New(rand.NewSource(spec.Seed))
+	var stored []*kdb.Hash
+	for _, hashType := range hashTypes {
+		for _, sh := range GenerateHashes(types[hashType], hashType, spec.Seed) {
+			if rng.Float64() >= spec.Cracked {
+				sh.Value = ""
+			}
+			if spec.Meta {
+				sh.Meta = map[string]string{
+					kdb.UserMetaKey:   users[rng.Intn(len(users))] + strconv.Itoa(rng.Intn(100)),
+					kdb.DomainMetaKey: domains[rng.Intn(len(domains))],
+				}
+				sh.Session = fmt.Sprintf("session-%d", rng.Intn(4))
+			}
+			stored = append(stored, sh)
+		}
+	}
+
+	if _, err := db.StoreHashes(stored); err != nil {
+		return nil, fmt.Errorf("failed to store %d hashes: %w", len(stored), err)
+	}
+	return stored, nil
+}
@@ -0,0 +1,99 @@
+package kdbtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/kdb"
+)
+
+func TestGenerateHashesDeterministic(t *testing.T) {
+	for _, hashType := range []uint64{0, kdb.NTLM, 22000} {
+		a, b := GenerateHashes(500, hashType, 42), GenerateHashes(500, hashType, 42)
+		if len(a) != 500 || len(b) != 500 {
+			t.Fatalf("hash type %d: generated %d and %d hashes", hashType, len(a), len(b))
+		}
+
+		seen := make(map[string]bool, len(a))
+		for i := range a {
+			if a[i].Hash != b[i].Hash || a[i].Value != b[i].Value || !a[i].CreatedAt.Equal(b[i].CreatedAt) {
+				t.Fatalf("hash type %d: hash %d differs between runs: %+v and %+v", hashType, i, a[i], b[i])
+			}
+			if seen[a[i].Hash] {
+				t.Errorf("hash type %d: %s generated twice", hashType, a[i].Hash)
+			}
+			seen[a[i].Hash] = true
+		}
+
+		other := GenerateHashes(500, hashType, 43)
+		same := 0
+		for i := range a {
+			if a[i].Value == other[i].Value {
+				same++
+			}
+		}
+		if same > len(a)/10 {
+			t.Errorf("hash type %d: %d of %d plaintexts shared with another seed", hashType, same, len(a))
+		}
+	}
+}
+
+func TestGenerateHashesStable(t *testing.T) {
+	// The fixtures must not change under tests that pinned them
+	want := []struct{ hash, value string }{
+		{"f201599f55da09772959a8a070cedbe0", "Freedom1971!"},
+		{"850757d92648dea17cf0d05b040238e6", "Spring9514"},
+		{"1019426cdd0483aba1c24c665ce36eec", "Master1984!"},
+	}
+	got := GenerateHashes(len(want), 0, 1)
+	for i, w := range want {
+		if got[i].Hash != w.hash || got[i].Value != w.value {
+			t.Errorf("hash %d is %s:%s, want %s:%s", i, got[i].Hash, got[i].Value, w.hash, w.value)
+		}
+	}
+	if !got[0].CreatedAt.Equal(Epoch) || !got[2].CreatedAt.Equal(Epoch.Add(2*time.Second)) {
+		t.Errorf("creation times %v and %v", got[0].CreatedAt, got[2].CreatedAt)
+	}
+}
+
+func TestGenerateHashesVerify(t *testing.T) {
+	for _, sh := range GenerateHashes(100, kdb.NTLM, 7) {
+		if computed, err := kdb.ComputeHash(sh.Value, kdb.NTLM); err != nil || computed != sh.Hash {
+			t.Fatalf("%s isn't the NTLM hash of %q: %s %v", sh.Hash, sh.Value, computed, err)
+		}
+	}
+}
+
+func TestPopulateDeterministic(t *testing.T) {
+	spec := PopulationSpec{Types: map[uint64]int{0: 200, kdb.NTLM: 100}, Cracked: 0.5, Meta: true, Seed: 9}
+	a := PopulateDB(t, NewTestDB(t), spec)
+	db := NewTestDB(t)
+	b := PopulateDB(t, db, spec)
+
+	if len(a) != 300 || len(b) != 300 {
+		t.Fatalf("populated %d and %d hashes", len(a), len(b))
+	}
+	cracked := 0
+	for i := range a {
+		if a[i].Hash != b[i].Hash || a[i].Value != b[i].Value || a[i].Session != b[i].Session ||
+			a[i].Meta[kdb.UserMetaKey] != b[i].Meta[kdb.UserMetaKey] || a[i].Meta[kdb.DomainMetaKey] != b[i].Meta[kdb.DomainMetaKey] {
+			t.Fatalf("hash %d differs between runs: %+v and %+v", i, a[i], b[i])
+		}
+		if a[i].Value != "" {
+			cracked++
+		}
+	}
+	if cracked < 100 || cracked > 200 {
+		t.Errorf("%d of 300 hashes cracked at a share of 0.5", cracked)
+	}
+
+	if n, err := db.TotalHashes(); err != nil || n != 300 {
+		t.Errorf("the database holds %d hashes: %v", n, err)
+	}
+	for _, sh := range b[:20] {
+		got, err := db.GetHashByOriginalHash(sh.Hash, sh.HashType)
+		if err != nil || got.Value != sh.Value || got.Meta[kdb.UserMetaKey] != sh.Meta[kdb.UserMetaKey] {
+			t.Errorf("%s was stored as %+v: %v", sh.Hash, got, err)
+		}
+	}
+}
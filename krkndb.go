@@ -1,13 +1,308 @@
 package KrknDB
 
-import "github.com/KrakenTech-LLC/KrknDB/internal/kdb"
+import (
+	"context"
+	"io"
+	"iter"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/kdb"
+	"github.com/KrakenTech-LLC/KrknDB/internal/util/keysource"
+)
 
 type KDB = kdb.KDB
+type HashStore = kdb.HashStore
 type Hash = kdb.Hash
+type HashBuilder = kdb.HashBuilder
+type StoreResult = kdb.StoreResult
+type Tx = kdb.Tx
+type Snapshot = kdb.Snapshot
+type Reader = kdb.Reader
 type Options = kdb.Options
 
 type Logger = kdb.Logger
 
+type Resolver = kdb.Resolver
+type NamedResolver = kdb.NamedResolver
+
+type Stats = kdb.Stats
+type OpenCheck = kdb.OpenCheck
+type InflightStats = kdb.InflightStats
+type PressureStats = kdb.PressureStats
+type StatusPage = kdb.StatusPage
+type Role = kdb.Role
+type TokenRoles = kdb.TokenRoles
+type APIError = kdb.APIError
+type LMHalf = kdb.LMHalf
+type LMHalves = kdb.LMHalves
+type LSMInfo = kdb.LSMInfo
+type LevelInfo = kdb.LevelInfo
+type WarmupStatus = kdb.WarmupStatus
+type RegistryEntry = kdb.RegistryEntry
+type RebuildOptions = kdb.RebuildOptions
+type ImportReport = kdb.ImportReport
+type HashListOptions = kdb.HashListOptions
+type CoverageReport = kdb.CoverageReport
+type TypeCoverage = kdb.TypeCoverage
+type ValueScore = kdb.ValueScore
+type CharClass = kdb.CharClass
+type Dictionary = kdb.Dictionary
+type Policy = kdb.Policy
+type PolicyReason = kdb.PolicyReason
+type PolicyCounts = kdb.PolicyCounts
+type PolicyReport = kdb.PolicyReport
+type DistinctMode = kdb.DistinctMode
+type DistinctOptions = kdb.DistinctOptions
+type DistinctCount = kdb.DistinctCount
+
+const ClassLower = kdb.ClassLower
+const ClassUpper = kdb.ClassUpper
+const ClassDigit = kdb.ClassDigit
+const ClassSymbol = kdb.ClassSymbol
+const ClassOther = kdb.ClassOther
+const ReasonTooShort = kdb.ReasonTooShort
+const ReasonTooFewClasses = kdb.ReasonTooFewClasses
+const ReasonMissingClass = kdb.ReasonMissingClass
+const ReasonBannedSubstring = kdb.ReasonBannedSubstring
+const ReasonDictionaryWord = kdb.ReasonDictionaryWord
+const ReasonLowEntropy = kdb.ReasonLowEntropy
+const DistinctApproximate = kdb.DistinctApproximate
+const DistinctExact = kdb.DistinctExact
+const UserMetaKey = kdb.UserMetaKey
+const SiblingMetaKey = kdb.SiblingMetaKey
+const LMFullMetaKey = kdb.LMFullMetaKey
+const LastBackupMetaKey = kdb.LastBackupMetaKey
+const IdentityMetaKey = kdb.IdentityMetaKey
+const RoleRead = kdb.RoleRead
+const RoleWrite = kdb.RoleWrite
+const RoleAdmin = kdb.RoleAdmin
+const RIDMetaKey = kdb.RIDMetaKey
+const DomainMetaKey = kdb.DomainMetaKey
+const DefaultKeyPrefix = kdb.DefaultKeyPrefix
+const NTLM = kdb.NTLM
+const LM = kdb.LM
+const NetNTLMv1 = kdb.NetNTLMv1
+const NetNTLMv2 = kdb.NetNTLMv2
+const ChallengeKeyAccount = kdb.ChallengeKeyAccount
+const ChallengeKeyUser = kdb.ChallengeKeyUser
+const ChallengeKeyCapture = kdb.ChallengeKeyCapture
+const KerberosTGSREP = kdb.KerberosTGSREP
+const KerberosASREP = kdb.KerberosASREP
+const MD5 = kdb.MD5
+const SHA1 = kdb.SHA1
+const MD5Crypt = kdb.MD5Crypt
+const MD4 = kdb.MD4
+const SHA256 = kdb.SHA256
+const SHA512 = kdb.SHA512
+const SHA512Crypt = kdb.SHA512Crypt
+const DCC2 = kdb.DCC2
+const Bcrypt = kdb.Bcrypt
+const SHA256Crypt = kdb.SHA256Crypt
+const WPA = kdb.WPA
+
+type HashType = kdb.HashType
+
+type ChallengeKeying = kdb.ChallengeKeying
+type ChallengeResponse = kdb.ChallengeResponse
+type KerberosTicket = kdb.KerberosTicket
+
+type RecentCrack = kdb.RecentCrack
+type BulkLookupSummary = kdb.BulkLookupSummary
+type SizeEstimate = kdb.SizeEstimate
+type CountEstimate = kdb.CountEstimate
+type CountOptions = kdb.CountOptions
+type QueryBuilder = kdb.QueryBuilder
+type QueryPlan = kdb.QueryPlan
+type AccessPath = kdb.AccessPath
+
+const AccessCounter = kdb.AccessCounter
+const AccessValueIndex = kdb.AccessValueIndex
+const AccessInsertionIndex = kdb.AccessInsertionIndex
+const AccessKeyScan = kdb.AccessKeyScan
+const AccessScan = kdb.AccessScan
+
+type FindPlan = kdb.FindPlan
+type FindStrategy = kdb.FindStrategy
+
+const FindPointLookups = kdb.FindPointLookups
+const FindScan = kdb.FindScan
+
+type ScanOptions = kdb.ScanOptions
+type SkippedEntries = kdb.SkippedEntries
+type Order = kdb.Order
+
+const SumAsc = kdb.SumAsc
+const SumDesc = kdb.SumDesc
+const InsertionAsc = kdb.InsertionAsc
+const InsertionDesc = kdb.InsertionDesc
+
+type QuotaScope = kdb.QuotaScope
+type Quota = kdb.Quota
+type QuotaUsage = kdb.QuotaUsage
+type QuotaExceededError = kdb.QuotaExceededError
+
+type ValueQuery = kdb.ValueQuery
+type ValueMatch = kdb.ValueMatch
+type PurgeRecord = kdb.PurgeRecord
+
+const ValueExact = kdb.ValueExact
+const ValueContains = kdb.ValueContains
+const ValueRegex = kdb.ValueRegex
+
+type Source = kdb.Source
+type EffectivenessReport = kdb.EffectivenessReport
+type TypeEffectiveness = kdb.TypeEffectiveness
+type AttackCount = kdb.AttackCount
+
+type WordlistInfo = kdb.WordlistInfo
+
+type FoundsFormat = kdb.FoundsFormat
+type ConflictPolicy = kdb.ConflictPolicy
+
+const HashColonPlain = kdb.HashColonPlain
+const HashSaltPlain = kdb.HashSaltPlain
+const ConflictKeep = kdb.ConflictKeep
+const ConflictOverwrite = kdb.ConflictOverwrite
+
+type Format = kdb.Format
+type IngestProgress = kdb.IngestProgress
+type TypeMatch = kdb.TypeMatch
+type InputRange = kdb.InputRange
+type ChunkedFindOptions = kdb.ChunkedFindOptions
+type ChunkMatch = kdb.ChunkMatch
+type FindIncompleteError = kdb.FindIncompleteError
+type BackupManifest = kdb.BackupManifest
+type RestoreOptions = kdb.RestoreOptions
+type RestoreReport = kdb.RestoreReport
+type CountCheck = kdb.CountCheck
+type DeltaReport = kdb.DeltaReport
+type Session = kdb.Session
+type ValueDecodeError = kdb.ValueDecodeError
+type PanicError = kdb.PanicError
+type Engine = kdb.Engine
+type Result = kdb.Result
+
+const FormatPotfile = kdb.FormatPotfile
+const FormatHashList = kdb.FormatHashList
+const FormatFounds = kdb.FormatFounds
+const FormatFoundsSalted = kdb.FormatFoundsSalted
+const FormatHashtopolis = kdb.FormatHashtopolis
+const FormatPwdump = kdb.FormatPwdump
+const FormatCSV = kdb.FormatCSV
+const FormatJSONL = kdb.FormatJSONL
+
+type WatchOptions = kdb.WatchOptions
+
+type QueueItem = kdb.QueueItem
+type QueueStats = kdb.QueueStats
+
+type ExportOptions = kdb.ExportOptions
+type ValueRedaction = kdb.ValueRedaction
+
+const RedactNone = kdb.RedactNone
+const RedactMasked = kdb.RedactMasked
+const RedactHashedOnly = kdb.RedactHashedOnly
+
+type Operation = kdb.Operation
+type OperationKind = kdb.OperationKind
+type OperationState = kdb.OperationState
+type OperationFunc = kdb.OperationFunc
+type MigrationFunc = kdb.MigrationFunc
+type MigrationWrite = kdb.MigrationWrite
+type MigrationOptions = kdb.MigrationOptions
+type MigrationResult = kdb.MigrationResult
+
+const OpRecount = kdb.OpRecount
+const OpImport = kdb.OpImport
+const OpMigration = kdb.OpMigration
+const OpGC = kdb.OpGC
+const OpCheck = kdb.OpCheck
+const OpRetention = kdb.OpRetention
+const OperationRunning = kdb.OperationRunning
+const OperationSucceeded = kdb.OperationSucceeded
+const OperationFailed = kdb.OperationFailed
+const OperationCanceled = kdb.OperationCanceled
+
+type IndexName = kdb.IndexName
+type IndexDrift = kdb.IndexDrift
+type IndexReport = kdb.IndexReport
+
+const IndexInsertion = kdb.IndexInsertion
+const IndexCrackTime = kdb.IndexCrackTime
+const IndexUser = kdb.IndexUser
+const IndexValue = kdb.IndexValue
+const IndexValueFolded = kdb.IndexValueFolded
+
+type RetentionPolicy = kdb.RetentionPolicy
+type RetentionResult = kdb.RetentionResult
+type RetentionReport = kdb.RetentionReport
+
+var ErrNotInitialized = kdb.ErrNotInitialized
+var ErrWrongKey = kdb.ErrWrongKey
+var ErrDatabaseLocked = kdb.ErrDatabaseLocked
+var ErrUnsupportedOptions = kdb.ErrUnsupportedOptions
+var ErrMigrationRequired = kdb.ErrMigrationRequired
+var ErrUnsupportedSchema = kdb.ErrUnsupportedSchema
+var ErrDirtyOpen = kdb.ErrDirtyOpen
+var ErrKeyPrefixMismatch = kdb.ErrKeyPrefixMismatch
+var ErrInvalidKeyPrefix = kdb.ErrInvalidKeyPrefix
+var ErrTooMuchContention = kdb.ErrTooMuchContention
+var ErrTooManyIterators = kdb.ErrTooManyIterators
+var ErrBatchTooLarge = kdb.ErrBatchTooLarge
+var ErrCompactionRunning = kdb.ErrCompactionRunning
+var ErrOperationInProgress = kdb.ErrOperationInProgress
+var ErrOperationNotFound = kdb.ErrOperationNotFound
+var ErrWarmupRunning = kdb.ErrWarmupRunning
+var ErrSnapshotReleased = kdb.ErrSnapshotReleased
+var ErrValueIndexDisabled = kdb.ErrValueIndexDisabled
+var ErrQuotaExceeded = kdb.ErrQuotaExceeded
+var ErrEmptyHash = kdb.ErrEmptyHash
+var ErrHashTooLarge = kdb.ErrHashTooLarge
+var ErrValueTooLarge = kdb.ErrValueTooLarge
+var ErrInvalidHash = kdb.ErrInvalidHash
+var ErrInvalidHashType = kdb.ErrInvalidHashType
+var ErrMetadataTooLarge = kdb.ErrMetadataTooLarge
+var ErrRedactionUnsupported = kdb.ErrRedactionUnsupported
+var ErrFindIncomplete = kdb.ErrFindIncomplete
+var ErrDatabaseNotEmpty = kdb.ErrDatabaseNotEmpty
+var ErrOperationTimeout = kdb.ErrOperationTimeout
+var ErrInvalidMarker = kdb.ErrInvalidMarker
+var ErrForeignDatabase = kdb.ErrForeignDatabase
+var ErrValueDecode = kdb.ErrValueDecode
+var ErrInternalPanic = kdb.ErrInternalPanic
+var ErrInvalidSum = kdb.ErrInvalidSum
+var ErrVerificationUnsupported = kdb.ErrVerificationUnsupported
+var ErrWordlistNotFound = kdb.ErrWordlistNotFound
+var ErrUnknownFormat = kdb.ErrUnknownFormat
+
+type Keyring = keysource.Keyring
+
+const KeySize = keysource.KeySize
+
+var ErrInvalidKey = keysource.ErrInvalidKey
+var ErrKeySourceNotFound = keysource.ErrNotFound
+var ErrKeyExists = keysource.ErrKeyExists
+var ErrKeyringUnsupported = keysource.ErrKeyringUnsupported
+
+// LoadKey reads an encryption key from a file path, env:NAME or keyring:service/account
+func LoadKey(source string) ([]byte, error) {
+	return keysource.LoadKey(source)
+}
+
+// SaveKey writes an encryption key to a new file or to keyring:service/account
+func SaveKey(dest string, key []byte) error {
+	return keysource.SaveKey(dest, key)
+}
+
+// GenerateKey returns a new random encryption key
+func GenerateKey() ([]byte, error) {
+	return keysource.GenerateKey()
+}
+
+// SetKeyring makes keyring: sources use k instead of the OS keychain, nil restores it
+func SetKeyring(k Keyring) {
+	keysource.SetKeyring(k)
+}
+
 type Severity = kdb.Severity
 
 const DEBUG = kdb.Debug
@@ -16,8 +311,8 @@ const WARNING = kdb.Warning
 const ERROR = kdb.Error
 const FATAL = kdb.Fatal
 
-func NewDB(dbFolder string, encryptionKey []byte) (*kdb.KDB, error) {
-	return kdb.New(dbFolder, encryptionKey)
+func NewDB(dbFolder string, encryptionKey []byte, opts ...*Options) (*kdb.KDB, error) {
+	return kdb.New(dbFolder, encryptionKey, opts...)
 }
 
 func GetDB() *kdb.KDB {
@@ -28,18 +323,287 @@ func NewHash(hash, value string, hashType uint64) *kdb.Hash {
 	return kdb.NewHash(hash, value, hashType)
 }
 
+func BuildHash(hash string) *kdb.HashBuilder {
+	return kdb.BuildHash(hash)
+}
+
+func SumOf(hash string) string {
+	return kdb.SumOf(hash)
+}
+
+func SumOfBytes(hash string) []byte {
+	return kdb.SumOfBytes(hash)
+}
+
 func DefaultOptions() *kdb.Options {
 	return kdb.DefaultOptions()
 }
 
+func LowMemoryOptions() *kdb.Options {
+	return kdb.LowMemoryOptions()
+}
+
+func DefaultScanOptions() *kdb.ScanOptions {
+	return kdb.DefaultScanOptions()
+}
+
+func Query() *kdb.QueryBuilder {
+	return kdb.Query()
+}
+
+func ScoreValue(value string, dict ...kdb.Dictionary) kdb.ValueScore {
+	return kdb.ScoreValue(value, dict...)
+}
+
+func NewDictionary(words []string) kdb.Dictionary {
+	return kdb.NewDictionary(words)
+}
+
+func RenderStatus(w io.Writer, page *kdb.StatusPage) error {
+	return kdb.RenderStatus(w, page)
+}
+
+func LoadTokenRoles(r io.Reader) (*kdb.TokenRoles, error) {
+	return kdb.LoadTokenRoles(r)
+}
+
+func ParseRole(name string) (kdb.Role, error) {
+	return kdb.ParseRole(name)
+}
+
+func ReadBackupManifest(r io.Reader) (*kdb.BackupManifest, error) {
+	return kdb.ReadBackupManifest(r)
+}
+
+func NormalizeUser(user, domain string) string {
+	return kdb.NormalizeUser(user, domain)
+}
+
+func ParseChallengeResponse(capture string, hashType uint64) (*kdb.ChallengeResponse, error) {
+	return kdb.ParseChallengeResponse(capture, hashType)
+}
+
+func IsChallengeResponse(hashType uint64) bool {
+	return kdb.IsChallengeResponse(hashType)
+}
+
+func ParseKerberosTicket(ticket string, hashType uint64) (*kdb.KerberosTicket, error) {
+	return kdb.ParseKerberosTicket(ticket, hashType)
+}
+
+func IsKerberosTicket(hashType uint64) bool {
+	return kdb.IsKerberosTicket(hashType)
+}
+
+func CanVerify(hashType uint64) bool {
+	return kdb.CanVerify(hashType)
+}
+
+func ComputeHash(value string, hashType uint64) (string, error) {
+	return kdb.ComputeHash(value, hashType)
+}
+
+func DetectHashTypes(hash string) []uint64 {
+	return kdb.DetectHashTypes(hash)
+}
+
+func ParseHashType(name string) (HashType, error) {
+	return kdb.ParseHashType(name)
+}
+
+func ParseFormat(name string) (kdb.Format, error) {
+	return kdb.ParseFormat(name)
+}
+
+func HashTypeScope(hashType uint64) kdb.QuotaScope {
+	return kdb.HashTypeScope(hashType)
+}
+
+func DatabaseScope() kdb.QuotaScope {
+	return kdb.DatabaseScope()
+}
+
+func Pipeline(ctx context.Context, db *kdb.KDB, in <-chan string, hashType uint64, workers int) (<-chan *kdb.Result, error) {
+	return kdb.Pipeline(ctx, db, in, hashType, workers)
+}
+
 func SetLogger(l Logger) {
 	kdb.Get().SetLogger(l)
 }
 
 func GetTotalHashes() (int, error) {
-	return kdb.Get().TotalHashes()
+	db := kdb.Get()
+	if db == nil {
+		return 0, kdb.ErrNotInitialized
+	}
+	return db.TotalHashes()
 }
 
 func GetHashesByType(hashType uint64) (int, error) {
-	return kdb.Get().HashesByType(hashType)
+	return Count(hashType)
+}
+
+/*
+The functions below are conveniences for small scripts. They operate on the default
+database, the first one opened with NewDB, and return ErrNotInitialized when none is open.
+Programs that open more than one database should call the methods on *KDB instead.
+*/
+
+// Store stores a hash and its cracked value in the default database
+func Store(hash, value string, hashType uint64) error {
+	db := kdb.Get()
+	if db == nil {
+		return kdb.ErrNotInitialized
+	}
+	_, err := db.StoreHash(kdb.NewHash(hash, value, hashType))
+	return err
+}
+
+// Lookup returns a single hash from the default database
+func Lookup(hash string, hashType uint64) (*kdb.Hash, error) {
+	db := kdb.Get()
+	if db == nil {
+		return nil, kdb.ErrNotInitialized
+	}
+	return db.GetHashByOriginalHash(hash, hashType)
+}
+
+// LookupAnyType returns the hash from the default database under every registered type it may be of
+func LookupAnyType(hash string) ([]*kdb.Hash, error) {
+	db := kdb.Get()
+	if db == nil {
+		return nil, kdb.ErrNotInitialized
+	}
+	return db.GetHashAnyType(hash)
+}
+
+// Find returns an iterator over the hashes from the default database that match any of hashes
+func Find(hashes []string, hashType uint64, scanOpts ...*kdb.ScanOptions) (iter.Seq[*kdb.Hash], error) {
+	db := kdb.Get()
+	if db == nil {
+		return nil, kdb.ErrNotInitialized
+	}
+	return db.FindHashes(hashes, hashType, scanOpts...), nil
+}
+
+// FindChunked returns an iterator over the hashes from the default database that match any of
+// hashes, searched a chunk at a time with failed chunks retried
+func FindChunked(ctx context.Context, hashes []string, hashType uint64, opts *kdb.ChunkedFindOptions) (iter.Seq2[*kdb.ChunkMatch, error], error) {
+	db := kdb.Get()
+	if db == nil {
+		return nil, kdb.ErrNotInitialized
+	}
+	return db.FindHashesChunked(ctx, hashes, hashType, opts), nil
+}
+
+// Backup writes a backup of the default database to w
+func Backup(w io.Writer) (*kdb.BackupManifest, error) {
+	db := kdb.Get()
+	if db == nil {
+		return nil, kdb.ErrNotInitialized
+	}
+	return db.Backup(w)
+}
+
+// Restore restores a backup into the default database, which must be empty, and verifies it
+func Restore(r io.Reader, opts *kdb.RestoreOptions) (*kdb.RestoreReport, error) {
+	db := kdb.Get()
+	if db == nil {
+		return nil, kdb.ErrNotInitialized
+	}
+	return db.Restore(r, opts)
+}
+
+// ExportDelta writes the hashes of the default database changed since sinceMarker to w and
+// returns the marker for the next delta
+func ExportDelta(w io.Writer, sinceMarker string) (string, error) {
+	db := kdb.Get()
+	if db == nil {
+		return "", kdb.ErrNotInitialized
+	}
+	return db.ExportDelta(w, sinceMarker)
+}
+
+// ApplyDelta applies a delta written by ExportDelta to the default database
+func ApplyDelta(r io.Reader, policy kdb.ConflictPolicy) (kdb.DeltaReport, error) {
+	db := kdb.Get()
+	if db == nil {
+		return kdb.DeltaReport{}, kdb.ErrNotInitialized
+	}
+	return db.ApplyDelta(r, policy)
+}
+
+// PruneTombstones removes the tombstones of the default database written before marker
+func PruneTombstones(marker string) (int, error) {
+	db := kdb.Get()
+	if db == nil {
+		return 0, kdb.ErrNotInitialized
+	}
+	return db.PruneTombstones(marker)
+}
+
+// StartSession starts recording the hashes stored through the session into a set of the default
+// database, "" picks a random id
+func StartSession(id string) (*kdb.Session, error) {
+	db := kdb.Get()
+	if db == nil {
+		return nil, kdb.ErrNotInitialized
+	}
+	return db.StartSession(id)
+}
+
+// IterateSession returns an iterator over the hashes recorded in the set of sessionID in the
+// default database
+func IterateSession(sessionID string) (iter.Seq[*kdb.Hash], error) {
+	db := kdb.Get()
+	if db == nil {
+		return nil, kdb.ErrNotInitialized
+	}
+	return db.IterateSession(sessionID), nil
+}
+
+// DropSessionSet deletes the set of sessionID from the default database
+func DropSessionSet(sessionID string) (int, error) {
+	db := kdb.Get()
+	if db == nil {
+		return 0, kdb.ErrNotInitialized
+	}
+	return db.DropSessionSet(sessionID)
+}
+
+// FindAllTypes returns an iterator over the hashes from the default database that match any of
+// hashes under any registered type
+func FindAllTypes(ctx context.Context, hashes []string) (iter.Seq[*kdb.TypeMatch], error) {
+	db := kdb.Get()
+	if db == nil {
+		return nil, kdb.ErrNotInitialized
+	}
+	return db.FindHashesAllTypes(ctx, hashes), nil
+}
+
+// All returns an iterator over every hash of hashType in the default database
+func All(hashType uint64, scanOpts ...*kdb.ScanOptions) (iter.Seq[*kdb.Hash], error) {
+	db := kdb.Get()
+	if db == nil {
+		return nil, kdb.ErrNotInitialized
+	}
+	return db.GetHashesByHashType(hashType, scanOpts...), nil
+}
+
+// Count returns the number of hashes of hashType in the default database
+func Count(hashType uint64) (int, error) {
+	db := kdb.Get()
+	if db == nil {
+		return 0, kdb.ErrNotInitialized
+	}
+	return db.HashesByType(hashType)
+}
+
+// Delete deletes a hash from the default database
+func Delete(hash string, hashType uint64) error {
+	db := kdb.Get()
+	if db == nil {
+		return kdb.ErrNotInitialized
+	}
+	return db.DeleteHash(hash, hashType)
 }
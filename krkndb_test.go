@@ -0,0 +1,28 @@
+package KrknDB
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestNewDBOptions(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	t.Setenv("KRKNDB_TEST_KEY", hex.EncodeToString(key))
+
+	// The key comes from the options alone
+	opts := LowMemoryOptions()
+	opts.Logger = func(string, Severity) {}
+	opts.KeySource = "env:KRKNDB_TEST_KEY"
+	db, err := NewDB(t.TempDir(), nil, opts)
+	if err != nil {
+		t.Fatalf("NewDB with a key source: %v", err)
+	}
+	db.Close()
+
+	if _, err := NewDB(t.TempDir(), nil); err == nil {
+		t.Errorf("NewDB opened without a key")
+	}
+}
@@ -0,0 +1,289 @@
+// Package client is the Go client of a KrknDB server, a database serving KDB.APIHandler. Client
+// implements the same HashStore interface as the embedded *KDB, so tools switch between a local
+// and a remote database by swapping the constructor:
+//
+//	var store KrknDB.HashStore
+//	store, err = KrknDB.NewDB("./data", key)                                             // embedded
+//	store, err = client.NewClient("https://krkndb.lab:8443", &client.Options{Token: token}) // remote
+//
+// Batches and finds are sent in chunks, requests that fail transiently are retried with backoff
+// and every call has a Context variant. Errors of the server are *kdb.APIError, errors.Is tells
+// them apart as it does for the embedded database, e.g. badger.ErrKeyNotFound for a miss
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/kdb"
+)
+
+const (
+	defaultChunkSize  = 1000
+	defaultBackoff    = 100 * time.Millisecond
+	defaultMaxBackoff = 5 * time.Second
+	maxErrorBody      = 64 << 10 // Most of an error response read for its message
+)
+
+// ErrInsecureToken is returned by NewClient for a token that would be sent in the clear, over
+// http to a host that isn't the loopback interface
+var ErrInsecureToken = errors.New("bearer token over plain http")
+
+// Options configures a Client
+type Options struct {
+	// TLS configures https connections, nil verifies the server against the system roots
+	TLS *tls.Config
+	// Token is the bearer token sent with every request, see kdb.TokenRoles
+	Token string
+	// ChunkSize is how many hashes a store request carries and a find request searches, 0 uses
+	// 1000. The server searches at most 10000 at once
+	ChunkSize int
+	// Retries is how often a request that failed transiently is tried again: refused or cut
+	// connections, write contention, timeouts and overloaded servers. Stores are upserts, so
+	// a retried store the server already applied counts its hashes as updated, not new
+	Retries int
+	// Backoff is the wait before the first retry, doubled for every one after it with jitter, 0
+	// uses 100ms
+	Backoff time.Duration
+	// MaxBackoff caps the wait between retries, 0 uses 5s
+	MaxBackoff time.Duration
+	// HTTPClient sends the requests instead of a client built from TLS, such as one with a
+	// timeout or a custom transport
+	HTTPClient *http.Client
+	// Logger receives the errors the iterators of HashStore can't return, nil uses
+	// kdb.DefaultLogger. The Context variants return them instead
+	Logger kdb.Logger
+}
+
+// DefaultOptions returns the options NewClient uses when given none
+func DefaultOptions() *Options {
+	return &Options{
+		ChunkSize:  defaultChunkSize,
+		Retries:    3,
+		Backoff:    defaultBackoff,
+		MaxBackoff: defaultMaxBackoff,
+	}
+}
+
+// Client is a KrknDB server as a HashStore. It is safe for concurrent use
+type Client struct {
+	base  *url.URL
+	opts  Options
+	http  *http.Client
+	owned bool // http was built by NewClient, Close closes its idle connections
+}
+
+var _ kdb.HashStore = (*Client)(nil)
+
+// NewClient returns a client of the server at addr, a URL such as https://krkndb.lab:8443 with an
+// optional path the API is mounted under, or host:port for http, https with Options.TLS set.
+// Nothing is sent until the first call
+func NewClient(addr string, opts ...*Options) (*Client, error) {
+	o := DefaultOptions()
+	if len(opts) > 0 && opts[0] != nil {
+		o = opts[0]
+	}
+
+	if !strings.Contains(addr, "://") {
+		scheme := "http"
+		if o.TLS != nil {
+			scheme = "https"
+		}
+		addr = scheme + "://" + addr
+	}
+	base, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server address: %w", err)
+	}
+	if (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid server address %q, want http(s)://host:port", addr)
+	}
+	if o.Token != "" && base.Scheme == "http" && !isLoopback(base.Hostname()) {
+		return nil, fmt.Errorf("%w: use https to reach %s", ErrInsecureToken, base.Host)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+
+	c := &Client{base: base, opts: *o, http: o.HTTPClient}
+	if c.opts.ChunkSize <= 0 {
+		c.opts.ChunkSize = defaultChunkSize
+	}
+	if c.opts.Backoff <= 0 {
+		c.opts.Backoff = defaultBackoff
+	}
+	if c.opts.MaxBackoff <= 0 {
+		c.opts.MaxBackoff = defaultMaxBackoff
+	}
+	if c.http == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if o.TLS != nil {
+			transport.TLSClientConfig = o.TLS.Clone()
+		}
+		c.http = &http.Client{Transport: transport}
+		c.owned = true
+	}
+	return c, nil
+}
+
+// isLoopback returns true for hosts of the loopback interface, where plain http doesn't leave
+// the machine
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Close releases the idle connections of the client. The server's database stays open
+func (c *Client) Close() error {
+	if c.owned {
+		c.http.CloseIdleConnections()
+	}
+	return nil
+}
+
+// log logs msg with the configured logger
+func (c *Client) log(msg string, severity kdb.Severity) {
+	if c.opts.Logger != nil {
+		c.opts.Logger(msg, severity)
+		return
+	}
+	kdb.DefaultLogger(msg, severity)
+}
+
+// do sends a request, trying again after a transient failure while retries are left, and returns
+// the response of the first attempt the server answered with success. The body is sent anew
+// with every attempt
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, query, body)
+		if err == nil {
+			return resp, nil
+		}
+		if attempt >= c.opts.Retries || !c.retryable(ctx, err) {
+			return nil, err
+		}
+		if err := c.wait(ctx, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// send makes one attempt at a request. Responses other than success are returned as their error
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body []byte) (*http.Response, error) {
+	u := *c.base
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.opts.Token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		defer resp.Body.Close()
+		return nil, readAPIError(resp)
+	}
+	return resp, nil
+}
+
+// readAPIError returns the error of a response that isn't a success. Errors of the API are
+// decoded, others such as the refusals of kdb.TokenRoles carry their text
+func readAPIError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	apiErr := &kdb.APIError{}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") && json.Unmarshal(body, apiErr) == nil && apiErr.Message != "" {
+		apiErr.Status = resp.StatusCode
+		return apiErr
+	}
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		message = resp.Status
+	}
+	return &kdb.APIError{Status: resp.StatusCode, Message: message}
+}
+
+// retryable returns true if a request that failed with err may succeed when tried again: the
+// server answered with a temporary error, or didn't answer at all, unless ctx is done or TLS
+// refused the server
+func (c *Client) retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *kdb.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	var certErr *tls.CertificateVerificationError
+	var headerErr tls.RecordHeaderError
+	return !errors.As(err, &certErr) && !errors.As(err, &headerErr)
+}
+
+// wait sleeps before retry attempt+1, Options.Backoff doubled attempt times and capped at
+// Options.MaxBackoff, half of it jittered so clients that failed together don't retry together
+func (c *Client) wait(ctx context.Context, attempt int) error {
+	d := c.opts.MaxBackoff
+	if attempt < 32 {
+		d = min(c.opts.Backoff<<attempt, c.opts.MaxBackoff)
+	}
+	d = d/2 + rand.N(d/2+1)
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// call sends in as the JSON body of a request and decodes the response into out, either may be
+// nil
+func (c *Client) call(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	resp, err := c.do(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to read the response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,386 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/kdb"
+	"github.com/KrakenTech-LLC/KrknDB/kdbtest"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// testTokens are the tokens of the servers with auth, by role
+const testTokens = `
+read  dash-7f3a9c
+write ingest-51be20
+admin ops-d41d8c
+`
+
+// newTestServer serves the API of a fresh database in process, with tokens if not empty and over
+// TLS if secure. The server is closed before the database when the test ends
+func newTestServer(t *testing.T, tokens string, secure bool) (*kdb.KDB, *httptest.Server) {
+	t.Helper()
+	db := kdbtest.NewTestDB(t)
+	var roles *kdb.TokenRoles
+	if tokens != "" {
+		var err error
+		if roles, err = kdb.LoadTokenRoles(strings.NewReader(tokens)); err != nil {
+			t.Fatalf("LoadTokenRoles: %v", err)
+		}
+	}
+	srv := httptest.NewUnstartedServer(db.APIHandler(roles))
+	// Handshakes refused on purpose aren't worth a line on stderr
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	if secure {
+		srv.StartTLS()
+	} else {
+		srv.Start()
+	}
+	t.Cleanup(srv.Close)
+	return db, srv
+}
+
+// newTestClient returns a client of srv, configured further by configure if not nil
+func newTestClient(t *testing.T, srv *httptest.Server, configure func(*Options)) *Client {
+	t.Helper()
+	opts := DefaultOptions()
+	opts.Backoff = time.Millisecond
+	opts.Logger = func(string, kdb.Severity) {}
+	if srv.TLS != nil {
+		opts.TLS = srv.Client().Transport.(*http.Transport).TLSClientConfig
+	}
+	if configure != nil {
+		configure(opts)
+	}
+	c, err := NewClient(srv.URL, opts)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// sums returns the sums of hashes in the order given
+func sums(hashes []*kdb.Hash) []string {
+	out := make([]string, len(hashes))
+	for i, sh := range hashes {
+		out[i] = sh.Sum()
+	}
+	return out
+}
+
+// reversed returns a reversed copy of hashes
+func reversed(hashes []*kdb.Hash) []*kdb.Hash {
+	out := slices.Clone(hashes)
+	slices.Reverse(out)
+	return out
+}
+
+// checkHashStore runs the same calls against a store, embedded or remote, and fails t where one
+// doesn't answer as the embedded database does
+func checkHashStore(t *testing.T, store kdb.HashStore) {
+	hashes := kdbtest.GenerateHashes(60, 0, 1)
+	if isNew, err := store.StoreHash(hashes[0]); err != nil || !isNew {
+		t.Fatalf("storing a new hash: %v %v", isNew, err)
+	}
+	if isNew, err := store.StoreHash(hashes[0]); err != nil || isNew {
+		t.Errorf("storing it again: %v %v", isNew, err)
+	}
+	if result, err := store.StoreHashes(hashes); err != nil || result.New != 59 || result.Updated != 1 {
+		t.Fatalf("storing the batch: %+v %v", result, err)
+	}
+
+	if n, err := store.TotalHashes(); err != nil || n != 60 {
+		t.Errorf("%d hashes in total: %v", n, err)
+	}
+	if n, err := store.HashesByType(0); err != nil || n != 60 {
+		t.Errorf("%d hashes of type 0: %v", n, err)
+	}
+	if types, err := store.GetRegisteredHashTypes(); err != nil || !slices.Equal(types, []uint64{0}) {
+		t.Errorf("the hash types are %v: %v", types, err)
+	}
+
+	want := hashes[3]
+	if got, err := store.GetHashByOriginalHash(strings.ToUpper(want.Hash), 0); err != nil || got.Value != want.Value || got.Sum() != want.Sum() {
+		t.Errorf("the lookup by hash returned %+v: %v", got, err)
+	}
+	if got, err := store.GetHashBySum(want.Sum(), 0); err != nil || got.Hash != want.Hash || !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Errorf("the lookup by sum returned %+v: %v", got, err)
+	}
+	if _, err := store.GetHashBySum(string(want.SumBytes()), 0); err != nil {
+		t.Errorf("the lookup by raw sum: %v", err)
+	}
+	if _, err := store.GetHashBySum(want.Hash, 0); !errors.Is(err, kdb.ErrInvalidSum) {
+		t.Errorf("a lookup by the hash as its sum returned %v", err)
+	}
+
+	// Every order, in pages
+	bySum := slices.Clone(hashes)
+	slices.SortFunc(bySum, func(a, b *kdb.Hash) int { return strings.Compare(a.Sum(), b.Sum()) })
+	orders := map[kdb.Order][]*kdb.Hash{
+		kdb.SumAsc:        bySum,
+		kdb.SumDesc:       reversed(bySum),
+		kdb.InsertionAsc:  hashes,
+		kdb.InsertionDesc: reversed(hashes),
+	}
+	for order, want := range orders {
+		var got []*kdb.Hash
+		so := &kdb.ScanOptions{Order: order, Limit: 25}
+		for page := 0; page < 5; page++ {
+			n := 0
+			for sh := range store.GetHashesByHashType(0, so) {
+				got = append(got, sh)
+				so.After = sh
+				n++
+			}
+			if n < so.Limit {
+				break
+			}
+		}
+		if !slices.Equal(sums(got), sums(want)) {
+			t.Errorf("%s yielded %d hashes out of order", order, len(got))
+		}
+	}
+
+	// Find, across chunks of the client and with the same hash searched twice
+	searched := []string{"4f0d0ab6c1b3a0f1aa7b6219967ddcd1"}
+	for _, sh := range hashes[:30] {
+		searched = append(searched, sh.Hash)
+	}
+	searched = append(searched, strings.ToUpper(hashes[1].Hash))
+	var found []string
+	for sh := range store.FindHashes(searched, 0) {
+		found = append(found, sh.Sum())
+	}
+	slices.Sort(found)
+	if wantFound := slices.Sorted(slices.Values(sums(hashes[:30]))); !slices.Equal(found, wantFound) {
+		t.Errorf("the find yielded %d hashes, want 30", len(found))
+	}
+	n := 0
+	for sh := range store.FindHashes(searched, 0, &kdb.ScanOptions{KeysOnly: true, Limit: 12}) {
+		if sh.Value != "" {
+			t.Errorf("a keys only find read the value of %s", sh.Hash)
+		}
+		n++
+	}
+	if n != 12 {
+		t.Errorf("the limited find yielded %d hashes, want 12", n)
+	}
+
+	prefix := hashes[0].Sum()[:1]
+	var wantPrefix []string
+	for _, sum := range sums(bySum) {
+		if strings.HasPrefix(sum, prefix) {
+			wantPrefix = append(wantPrefix, sum)
+		}
+	}
+	var gotPrefix []*kdb.Hash
+	for sh := range store.SearchHashesByPrefix(prefix, 0) {
+		gotPrefix = append(gotPrefix, sh)
+	}
+	if !slices.Equal(sums(gotPrefix), wantPrefix) {
+		t.Errorf("the prefix search for %s yielded %d hashes, want %d", prefix, len(gotPrefix), len(wantPrefix))
+	}
+
+	source := &kdb.Source{Wordlist: "rockyou.txt", Rule: "best64", Tool: "hashcat"}
+	if err := store.MarkCracked(hashes[5].Hash, 0, "Cracked:Again", source); err != nil {
+		t.Fatalf("MarkCracked: %v", err)
+	}
+	if got, err := store.GetHashByOriginalHash(hashes[5].Hash, 0); err != nil || got.Value != "Cracked:Again" || got.Source == nil || *got.Source != *source {
+		t.Errorf("the cracked hash reads %+v: %v", got, err)
+	}
+
+	if err := store.DeleteHash(hashes[6].Hash, 0); err != nil {
+		t.Fatalf("DeleteHash: %v", err)
+	}
+	if _, err := store.GetHashByOriginalHash(hashes[6].Hash, 0); !errors.Is(err, badger.ErrKeyNotFound) {
+		t.Errorf("the deleted hash looked up: %v", err)
+	}
+	if err := store.DeleteHash(hashes[6].Hash, 0); !errors.Is(err, badger.ErrKeyNotFound) {
+		t.Errorf("deleting it again returned %v", err)
+	}
+	if n, err := store.HashesByType(0); err != nil || n != 59 {
+		t.Errorf("%d hashes of type 0 after the delete: %v", n, err)
+	}
+
+	if _, err := store.StoreHash(kdb.NewHash("", "", 0)); !errors.Is(err, kdb.ErrEmptyHash) {
+		t.Errorf("storing an empty hash returned %v", err)
+	}
+}
+
+func TestClientMatchesEmbedded(t *testing.T) {
+	t.Run("embedded", func(t *testing.T) {
+		checkHashStore(t, kdbtest.NewTestDB(t))
+	})
+	t.Run("remote", func(t *testing.T) {
+		_, srv := newTestServer(t, "", false)
+		// Small chunks so batches and finds take several requests
+		checkHashStore(t, newTestClient(t, srv, func(opts *Options) { opts.ChunkSize = 7 }))
+	})
+}
+
+func TestClientTLSAndTokens(t *testing.T) {
+	_, srv := newTestServer(t, testTokens, true)
+	hashes := kdbtest.GenerateHashes(10, 0, 2)
+	withToken := func(token string) *Client {
+		return newTestClient(t, srv, func(opts *Options) { opts.Token = token })
+	}
+
+	var apiErr *kdb.APIError
+	if _, err := withToken("").TotalHashes(); !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnauthorized {
+		t.Errorf("a call without a token returned %v", err)
+	}
+	if _, err := withToken("dash-7f3a9c").StoreHashes(hashes); !errors.As(err, &apiErr) || apiErr.Status != http.StatusForbidden {
+		t.Errorf("a store with a read token returned %v", err)
+	}
+	if result, err := withToken("ingest-51be20").StoreHashes(hashes); err != nil || result.New != 10 {
+		t.Fatalf("a store with a write token: %+v %v", result, err)
+	}
+	if n, err := withToken("dash-7f3a9c").TotalHashes(); err != nil || n != 10 {
+		t.Errorf("%d hashes read with a read token: %v", n, err)
+	}
+	if err := withToken("ops-d41d8c").DeleteHash(hashes[0].Hash, 0); err != nil {
+		t.Errorf("a delete with an admin token: %v", err)
+	}
+
+	// Nothing but the server's certificate is trusted
+	untrusted := newTestClient(t, srv, func(opts *Options) { opts.TLS = nil; opts.Retries = 3 })
+	start := time.Now()
+	if _, err := untrusted.TotalHashes(); err == nil {
+		t.Errorf("the server was trusted without its certificate")
+	} else if time.Since(start) > time.Second {
+		t.Errorf("a refused certificate was retried")
+	}
+
+	if _, err := NewClient("http://krkndb.lab:8080", &Options{Token: "dash-7f3a9c"}); !errors.Is(err, ErrInsecureToken) {
+		t.Errorf("a token over plain http to another host returned %v", err)
+	}
+	for _, addr := range []string{"http://127.0.0.1:8080", "localhost:8080"} {
+		if _, err := NewClient(addr, &Options{Token: "dash-7f3a9c"}); err != nil {
+			t.Errorf("a token over plain http to %s: %v", addr, err)
+		}
+	}
+	for _, addr := range []string{"ftp://krkndb.lab", "https://", "http://[::1"} {
+		if _, err := NewClient(addr); err == nil {
+			t.Errorf("the address %q was taken", addr)
+		}
+	}
+}
+
+// flakyWriter aborts a response once it wrote cut lines
+type flakyWriter struct {
+	http.ResponseWriter
+	cut int
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	if w.cut == 0 {
+		http.NewResponseController(w.ResponseWriter).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.cut -= strings.Count(string(p), "\n")
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *flakyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func TestClientRetries(t *testing.T) {
+	db := kdbtest.NewTestDB(t)
+	hashes := kdbtest.GenerateHashes(50, 0, 3)
+	if _, err := db.StoreHashes(hashes); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	// The first requests are refused as overloaded and the first streams cut after a few lines
+	var unavailable, cuts, requests atomic.Int32
+	api := db.APIHandler(nil)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if unavailable.Add(-1) >= 0 {
+			http.Error(w, "restarting", http.StatusServiceUnavailable)
+			return
+		}
+		if cuts.Add(-1) >= 0 {
+			w = &flakyWriter{ResponseWriter: w, cut: 7}
+		}
+		api.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	c := newTestClient(t, srv, func(opts *Options) { opts.ChunkSize = 20 })
+
+	unavailable.Store(2)
+	if n, err := c.TotalHashes(); err != nil || n != 50 || requests.Load() != 3 {
+		t.Errorf("%d hashes counted after %d requests: %v", n, requests.Load(), err)
+	}
+
+	unavailable.Store(4)
+	var apiErr *kdb.APIError
+	if _, err := c.TotalHashes(); !errors.As(err, &apiErr) || !apiErr.Temporary() {
+		t.Errorf("a server down for longer than the retries returned %v", err)
+	}
+	unavailable.Store(0)
+
+	bySum := slices.Clone(hashes)
+	slices.SortFunc(bySum, func(a, b *kdb.Hash) int { return strings.Compare(a.Sum(), b.Sum()) })
+	for _, order := range []kdb.Order{kdb.SumAsc, kdb.InsertionDesc} {
+		want := bySum
+		if order == kdb.InsertionDesc {
+			want = reversed(hashes)
+		}
+		cuts.Store(2)
+		var got []*kdb.Hash
+		for sh, err := range c.GetHashesByHashTypeContext(context.Background(), 0, &kdb.ScanOptions{Order: order, Limit: 40}) {
+			if err != nil {
+				t.Fatalf("%s: %v", order, err)
+			}
+			got = append(got, sh)
+		}
+		if !slices.Equal(sums(got), sums(want[:40])) {
+			t.Errorf("%s resumed with %d hashes out of order", order, len(got))
+		}
+	}
+
+	cuts.Store(3)
+	searched := make([]string, len(hashes))
+	for i, sh := range hashes {
+		searched[i] = sh.Hash
+	}
+	n := 0
+	for _, err := range c.FindHashesContext(context.Background(), searched, 0) {
+		if err != nil {
+			t.Fatalf("find: %v", err)
+		}
+		n++
+	}
+	if n != 50 {
+		t.Errorf("the resumed find yielded %d hashes, want 50", n)
+	}
+
+	// Without retries left the cut is the error
+	cuts.Store(1)
+	noRetries := newTestClient(t, srv, func(opts *Options) { opts.Retries = 0 })
+	var last error
+	for _, err := range noRetries.GetHashesByHashTypeContext(context.Background(), 0) {
+		last = err
+	}
+	if last == nil || !strings.Contains(last.Error(), "stream cut short") {
+		t.Errorf("a cut stream without retries ended with %v", last)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.StoreHashesContext(ctx, hashes); !errors.Is(err, context.Canceled) {
+		t.Errorf("a cancelled store returned %v", err)
+	}
+}
@@ -0,0 +1,384 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	"github.com/KrakenTech-LLC/KrknDB/internal/kdb"
+)
+
+// streamLine is a line of a streamed response as KDB.APIHandler writes it
+type streamLine struct {
+	Hash   *kdb.Hash     `json:"hash"`
+	Cursor string        `json:"cursor"`
+	Error  *kdb.APIError `json:"error"`
+	Done   bool          `json:"done"`
+}
+
+// typePath returns the path of a route of hashType
+func typePath(hashType uint64, route string) string {
+	return "/v1/types/" + strconv.FormatUint(hashType, 10) + "/" + route
+}
+
+// StoreHash stores a hash on the server, see KDB.StoreHash
+func (c *Client) StoreHash(sh *kdb.Hash) (isNew bool, err error) {
+	return c.StoreHashContext(context.Background(), sh)
+}
+
+// StoreHashContext is StoreHash bounded by ctx
+func (c *Client) StoreHashContext(ctx context.Context, sh *kdb.Hash) (bool, error) {
+	var out struct {
+		New bool `json:"new"`
+	}
+	err := c.call(ctx, http.MethodPost, "/v1/hash", nil, sh, &out)
+	return out.New, err
+}
+
+// StoreHashes stores hashes on the server in batches of Options.ChunkSize, see StoreStream
+func (c *Client) StoreHashes(hashes []*kdb.Hash) (kdb.StoreResult, error) {
+	return c.StoreStream(context.Background(), slices.Values(hashes))
+}
+
+// StoreHashesContext is StoreHashes bounded by ctx
+func (c *Client) StoreHashesContext(ctx context.Context, hashes []*kdb.Hash) (kdb.StoreResult, error) {
+	return c.StoreStream(ctx, slices.Values(hashes))
+}
+
+// StoreStream stores the hashes of seq as they come, in batches of Options.ChunkSize, each
+// stored by the server as one KDB.StoreHashes batch. Only a batch is atomic: the batches stored
+// before one fails stay stored and are counted in the result returned with the error
+func (c *Client) StoreStream(ctx context.Context, seq iter.Seq[*kdb.Hash]) (kdb.StoreResult, error) {
+	var total kdb.StoreResult
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	stored, batched := 0, 0
+
+	flush := func() error {
+		if batched == 0 {
+			return nil
+		}
+		var result kdb.StoreResult
+		resp, err := c.do(ctx, http.MethodPost, "/v1/hashes", nil, body.Bytes())
+		if err == nil {
+			err = json.NewDecoder(resp.Body).Decode(&result)
+			resp.Body.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to store hashes %d to %d: %w", stored, stored+batched-1, err)
+		}
+		total.New += result.New
+		total.Updated += result.Updated
+		total.Skipped += result.Skipped
+		stored += batched
+		batched = 0
+		body.Reset()
+		return nil
+	}
+
+	for sh := range seq {
+		if err := enc.Encode(sh); err != nil {
+			return total, err
+		}
+		if batched++; batched == c.opts.ChunkSize {
+			if err := flush(); err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, flush()
+}
+
+// GetHashBySum looks a hash up by its sum, hex encoded or the raw 32 bytes, see KDB.GetHashBySum
+func (c *Client) GetHashBySum(sum string, hashType uint64) (*kdb.Hash, error) {
+	return c.GetHashBySumContext(context.Background(), sum, hashType)
+}
+
+// GetHashBySumContext is GetHashBySum bounded by ctx
+func (c *Client) GetHashBySumContext(ctx context.Context, sum string, hashType uint64) (*kdb.Hash, error) {
+	sh := &kdb.Hash{}
+	if err := c.call(ctx, http.MethodGet, typePath(hashType, "sum"), url.Values{"sum": {sum}}, nil, sh); err != nil {
+		return nil, err
+	}
+	return sh, nil
+}
+
+// GetHashByOriginalHash looks a hash up by the hash itself, see KDB.GetHashByOriginalHash
+func (c *Client) GetHashByOriginalHash(originalHash string, hashType uint64) (*kdb.Hash, error) {
+	return c.GetHashByOriginalHashContext(context.Background(), originalHash, hashType)
+}
+
+// GetHashByOriginalHashContext is GetHashByOriginalHash bounded by ctx
+func (c *Client) GetHashByOriginalHashContext(ctx context.Context, originalHash string, hashType uint64) (*kdb.Hash, error) {
+	sh := &kdb.Hash{}
+	if err := c.call(ctx, http.MethodGet, typePath(hashType, "hash"), url.Values{"hash": {originalHash}}, nil, sh); err != nil {
+		return nil, err
+	}
+	return sh, nil
+}
+
+// DeleteHash deletes a hash from the server, see KDB.DeleteHash
+func (c *Client) DeleteHash(originalHash string, hashType uint64) error {
+	return c.DeleteHashContext(context.Background(), originalHash, hashType)
+}
+
+// DeleteHashContext is DeleteHash bounded by ctx
+func (c *Client) DeleteHashContext(ctx context.Context, originalHash string, hashType uint64) error {
+	return c.call(ctx, http.MethodDelete, typePath(hashType, "hash"), url.Values{"hash": {originalHash}}, nil, nil)
+}
+
+// MarkCracked sets the value of a hash on the server, see KDB.MarkCracked
+func (c *Client) MarkCracked(originalHash string, hashType uint64, value string, source ...*kdb.Source) error {
+	return c.MarkCrackedContext(context.Background(), originalHash, hashType, value, source...)
+}
+
+// MarkCrackedContext is MarkCracked bounded by ctx
+func (c *Client) MarkCrackedContext(ctx context.Context, originalHash string, hashType uint64, value string, source ...*kdb.Source) error {
+	crack := struct {
+		Hash   string      `json:"hash"`
+		Value  string      `json:"value"`
+		Source *kdb.Source `json:"source,omitempty"`
+	}{Hash: originalHash, Value: value}
+	if len(source) > 0 {
+		crack.Source = source[0]
+	}
+	return c.call(ctx, http.MethodPost, typePath(hashType, "cracked"), nil, crack, nil)
+}
+
+// TotalHashes returns the number of hashes on the server
+func (c *Client) TotalHashes() (int, error) {
+	return c.TotalHashesContext(context.Background())
+}
+
+// TotalHashesContext is TotalHashes bounded by ctx
+func (c *Client) TotalHashesContext(ctx context.Context) (int, error) {
+	var out struct {
+		Count int `json:"count"`
+	}
+	err := c.call(ctx, http.MethodGet, "/v1/count", nil, nil, &out)
+	return out.Count, err
+}
+
+// HashesByType returns the number of hashes of hashType on the server
+func (c *Client) HashesByType(hashType uint64) (int, error) {
+	return c.HashesByTypeContext(context.Background(), hashType)
+}
+
+// HashesByTypeContext is HashesByType bounded by ctx
+func (c *Client) HashesByTypeContext(ctx context.Context, hashType uint64) (int, error) {
+	var out struct {
+		Count int `json:"count"`
+	}
+	err := c.call(ctx, http.MethodGet, typePath(hashType, "count"), nil, nil, &out)
+	return out.Count, err
+}
+
+// GetRegisteredHashTypes returns the hash types stored on the server
+func (c *Client) GetRegisteredHashTypes() ([]uint64, error) {
+	return c.GetRegisteredHashTypesContext(context.Background())
+}
+
+// GetRegisteredHashTypesContext is GetRegisteredHashTypes bounded by ctx
+func (c *Client) GetRegisteredHashTypesContext(ctx context.Context) ([]uint64, error) {
+	var out struct {
+		Types []uint64 `json:"types"`
+	}
+	err := c.call(ctx, http.MethodGet, "/v1/types", nil, nil, &out)
+	return out.Types, err
+}
+
+// GetHashesByHashType returns an iterator over the hashes of hashType on the server, see
+// GetHashesByHashTypeContext. An error ends the iteration and is logged
+func (c *Client) GetHashesByHashType(hashType uint64, scanOpts ...*kdb.ScanOptions) iter.Seq[*kdb.Hash] {
+	return c.logged(fmt.Sprintf("iterate hash type %d", hashType), c.GetHashesByHashTypeContext(context.Background(), hashType, scanOpts...))
+}
+
+// GetHashesByHashTypeContext is GetHashesByHashType bounded by ctx, yielding the error that
+// ended the iteration last. Of the ScanOptions the order, limit, KeysOnly and After are sent, the
+// rest tunes the server's own scans. A stream cut short is resumed after the last hash yielded
+func (c *Client) GetHashesByHashTypeContext(ctx context.Context, hashType uint64, scanOpts ...*kdb.ScanOptions) iter.Seq2[*kdb.Hash, error] {
+	return c.listing(ctx, hashType, url.Values{}, scanOpts)
+}
+
+// SearchHashesByPrefix returns an iterator over the hashes of hashType whose hex sums start
+// with hexPrefix, see SearchHashesByPrefixContext. An error ends the iteration and is logged
+func (c *Client) SearchHashesByPrefix(hexPrefix string, hashType uint64, scanOpts ...*kdb.ScanOptions) iter.Seq[*kdb.Hash] {
+	return c.logged(fmt.Sprintf("search hash type %d by prefix", hashType), c.SearchHashesByPrefixContext(context.Background(), hexPrefix, hashType, scanOpts...))
+}
+
+// SearchHashesByPrefixContext is SearchHashesByPrefix bounded by ctx, yielding errors as
+// GetHashesByHashTypeContext does
+func (c *Client) SearchHashesByPrefixContext(ctx context.Context, hexPrefix string, hashType uint64, scanOpts ...*kdb.ScanOptions) iter.Seq2[*kdb.Hash, error] {
+	return c.listing(ctx, hashType, url.Values{"prefix": {hexPrefix}}, scanOpts)
+}
+
+// listing streams a listing of hashType with query and the scan options
+func (c *Client) listing(ctx context.Context, hashType uint64, query url.Values, scanOpts []*kdb.ScanOptions) iter.Seq2[*kdb.Hash, error] {
+	return func(yield func(*kdb.Hash, error) bool) {
+		scanQuery(query, scanOpts)
+		err := c.stream(ctx, typePath(hashType, "hashes"), query, nil, func(sh *kdb.Hash) bool {
+			return yield(sh, nil)
+		})
+		if err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// FindHashes returns an iterator over the hashes of hashType on the server among
+// possibleHashes, see FindHashesContext. An error ends the iteration and is logged
+func (c *Client) FindHashes(possibleHashes []string, hashType uint64, scanOpts ...*kdb.ScanOptions) iter.Seq[*kdb.Hash] {
+	return c.logged(fmt.Sprintf("search hash type %d", hashType), c.FindHashesContext(context.Background(), possibleHashes, hashType, scanOpts...))
+}
+
+// FindHashesContext is FindHashes bounded by ctx, yielding errors as GetHashesByHashTypeContext
+// does. possibleHashes are searched Options.ChunkSize at a time, the order of the scan options
+// holds within every chunk and the limit across them; a hash found by several chunks is yielded
+// once
+func (c *Client) FindHashesContext(ctx context.Context, possibleHashes []string, hashType uint64, scanOpts ...*kdb.ScanOptions) iter.Seq2[*kdb.Hash, error] {
+	return func(yield func(*kdb.Hash, error) bool) {
+		query := url.Values{}
+		limit := scanQuery(query, scanOpts)
+		seen := make(map[string]bool)
+		stopped := false
+
+		for chunk := range slices.Chunk(possibleHashes, c.opts.ChunkSize) {
+			if limit > 0 {
+				query.Set("limit", strconv.Itoa(limit-len(seen)))
+			}
+			body, err := json.Marshal(struct {
+				Hashes []string `json:"hashes"`
+			}{chunk})
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			err = c.stream(ctx, typePath(hashType, "find"), query, body, func(sh *kdb.Hash) bool {
+				if seen[sh.Sum()] {
+					return true
+				}
+				seen[sh.Sum()] = true
+				stopped = !yield(sh, nil) || len(seen) == limit
+				return !stopped
+			})
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if stopped {
+				return
+			}
+		}
+	}
+}
+
+// scanQuery adds the scan options the server takes to query and returns the limit
+func scanQuery(query url.Values, scanOpts []*kdb.ScanOptions) int {
+	if len(scanOpts) == 0 || scanOpts[0] == nil {
+		return 0
+	}
+	so := scanOpts[0]
+	if so.Order != kdb.SumAsc {
+		query.Set("order", so.Order.String())
+	}
+	if so.Limit > 0 {
+		query.Set("limit", strconv.Itoa(so.Limit))
+	}
+	if so.KeysOnly {
+		query.Set("keys_only", "true")
+	}
+	if so.After != nil {
+		query.Set("after", so.After.Sum())
+	}
+	return so.Limit
+}
+
+// logged drops the error of seq, logging it the way the iterators of KDB do
+func (c *Client) logged(op string, seq iter.Seq2[*kdb.Hash, error]) iter.Seq[*kdb.Hash] {
+	return func(yield func(*kdb.Hash) bool) {
+		for sh, err := range seq {
+			if err != nil {
+				c.log(fmt.Sprintf("Failed to %s: %v", op, err), kdb.Error)
+				return
+			}
+			if !yield(sh) {
+				return
+			}
+		}
+	}
+}
+
+// stream yields the hashes of a streamed response, POSTing body if there is one. A stream cut
+// short by a transient failure is requested again after the cursor of the last hash yielded,
+// with the limit in query lowered by the hashes yielded, as long as retries are left
+func (c *Client) stream(ctx context.Context, path string, query url.Values, body []byte, yield func(*kdb.Hash) bool) error {
+	method := http.MethodGet
+	if body != nil {
+		method = http.MethodPost
+	}
+	query = maps.Clone(query)
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	for resume := 0; ; resume++ {
+		resp, err := c.do(ctx, method, path, query, body)
+		if err != nil {
+			return err
+		}
+		yielded, cursor, stopped, err := readStream(resp.Body, yield)
+		if stopped || err == nil {
+			return nil
+		}
+		if resume >= c.opts.Retries || !c.retryable(ctx, err) {
+			return err
+		}
+
+		if cursor != "" {
+			query.Set("after", cursor)
+		}
+		if limit > 0 {
+			limit -= yielded
+			query.Set("limit", strconv.Itoa(limit))
+		}
+		if err := c.wait(ctx, resume); err != nil {
+			return err
+		}
+	}
+}
+
+// readStream yields the hashes of a streamed response up to its last line and closes body. It
+// returns how many hashes were yielded, the cursor of the last one, and stopped if the loop body
+// ended the iteration. A stream without its last line failed
+func readStream(body io.ReadCloser, yield func(*kdb.Hash) bool) (yielded int, cursor string, stopped bool, err error) {
+	defer body.Close()
+	dec := json.NewDecoder(body)
+	for {
+		var line streamLine
+		if err := dec.Decode(&line); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return yielded, cursor, false, fmt.Errorf("stream cut short: %w", err)
+		}
+		switch {
+		case line.Error != nil:
+			return yielded, cursor, false, line.Error
+		case line.Done:
+			return yielded, cursor, false, nil
+		case line.Hash != nil:
+			yielded++
+			cursor = line.Cursor
+			if !yield(line.Hash) {
+				return yielded, cursor, true, nil
+			}
+		}
+	}
+}